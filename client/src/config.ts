@@ -1,4 +1,5 @@
 import { LANG } from './lang';
+import type { Cosmetics, TrackCurve, TrackDefinition } from './types';
 
 // Game configuration - must match server exactly for deterministic physics
 
@@ -19,11 +20,21 @@ export const CONFIG = {
   CAR_WIDTH: 20,
   CAR_HEIGHT: 34,
   ROAD_WIDTH: 400,
+  ROAD_LANES: 4, // Lanes of the built-in road
   CAMERA_Y_OFFSET: 0.7,
+  NIGHT_VISIBILITY: 420, // Reach of the headlights at night (protocol v21), pixels ahead of the car
 
   // Network
   SYNC_RATE_MS: 80, // Client sync rate (12.5 Hz)
+  PROTOCOL_VERSION: 38, // Highest protocol version announced in Hello
+  SUBPROTOCOL: 'vracer.v1.bin', // WebSocket subprotocol (binary wire format)
+  BROADCAST_INTERVAL_MS: 50, // Default server state broadcast interval (20 Hz); rooms may announce another
+  LATERAL_EXTRAPOLATION_LIMIT: 0.1, // Max seconds to extrapolate sideways velocity (server dead reckoning assumes the same)
+  PACKET_HISTORY_MS: 500, // Remote positions kept for delayed rendering (above the server's longest recommended delay)
   SERVER_URL: import.meta.env.VITE_SERVER_URL || getDefaultWebSocketUrl(),
+  TENANT: import.meta.env.VITE_TENANT || '', // Game on a multi-tenant server ('' = default)
+  TELEMETRY_KEY: import.meta.env.VITE_TELEMETRY_KEY || 'vracer-telemetry-v1', // Signs telemetry samples (protocol v33); the server's TELEMETRY_KEY
+  TELEMETRY_FRAMES_KEPT: 64, // Recent frame times kept for telemetry probes
 
   // Physics / Gameplay
   MAX_SPEED: 1400,
@@ -34,6 +45,16 @@ export const CONFIG = {
   INERTIA_DAMPENING: 0.3,
   MIN_TURN_AUTHORITY: 0.5,
   EXPLOSION_TOLERANCE: 0.35,
+  GRAVITY: 900, // Speed lost per second per unit of grade (elevation, protocol v18)
+  BANK_CURVATURE_FULL: 0.0005, // Road curvature from which bends are fully banked
+  LANDING_LOSS: 0.0005, // Share of speed lost landing a jump, per unit of vertical speed (protocol v19)
+  LANDING_LOSS_MAX: 0.5,
+  FUEL_CAPACITY: 100, // Fuel mode (protocol v20): a full tank
+  FUEL_BURN: 4, // Fuel burned per second at full throttle
+  FUEL_REFILL: 60, // Fuel a pit zone adds per second
+  FUEL_DRY_SPEED: 250, // Speed cap of a car with an empty tank
+  PIT_SPACING: 25000, // Road distance between pit zones
+  PIT_LENGTH: 800, // Length of a pit zone, at the end of each spacing
 
   // Steering
   TURN_SPEED: 550,
@@ -46,6 +67,14 @@ export const CONFIG = {
   PUSH_FORCE: 2.0,
   SPEED_DIFF_MULTIPLIER: 3.5,
   SPEED_DIFF_THRESHOLD: 200,
+  SOFT_COLLISION_FACTOR: 0.3, // Of the push and slowdown, in rooms with soft collisions (protocol v37)
+  GHOST_ALPHA: 0.35, // Opacity of cars with collisions off
+  IMPACT_SHAKE: 0.02, // Camera shake per unit/s of a hit's impact (protocol v24)
+  IMPACT_SHAKE_MAX: 12,
+
+  // Cosmetics (wire IDs of the server's catalog)
+  TRAIL_COLORS: ['', '#9ca3af', '#fbbf24', '#22d3ee', '#f97316', 'rainbow'], // smoke, sparks, neon, flame, rainbow
+  TRAIL_LENGTH: 40,
 
   // Road Generation (must match server exactly)
   ROAD_SCALE: 0.001,
@@ -63,11 +92,138 @@ export const CONFIG = {
   SHARP_TURN_THRESHOLD: 350,
 } as const;
 
+// Road of the current room: null for the built-in road, or a track announced
+// by the server (a custom track, or the built-in road in a variant, without
+// curves)
+let currentTrack: TrackDefinition | null = null;
+
+export function setTrack(track: TrackDefinition | null): void {
+  currentTrack = track;
+}
+
+// Road distance before Y=0 in the current room (moved by world rebasing)
+let roadOrigin = 0;
+
+export function setRoadOrigin(origin: number): void {
+  roadOrigin = origin;
+}
+
+export function getRoadOrigin(): number {
+  return roadOrigin;
+}
+
+// Road width of the current room
+export function getRoadWidth(): number {
+  return currentTrack ? currentTrack.width : CONFIG.ROAD_WIDTH;
+}
+
+// Number of lanes across the road of the current room
+export function getRoadLanes(): number {
+  return currentTrack?.lanes ?? CONFIG.ROAD_LANES;
+}
+
 // Road curve calculation - MUST match server implementation exactly
 export function getRoadCurve(worldY: number): number {
-  const baseCurve = Math.sin(worldY * CONFIG.ROAD_SCALE) * CONFIG.ROAD_AMPLITUDE;
-  const sharpTurn = Math.pow(Math.sin(worldY * CONFIG.ROAD_SCALE * 1.5), 3) * (CONFIG.ROAD_AMPLITUDE * 0.5);
-  return baseCurve + sharpTurn;
+  worldY += roadOrigin;
+  let x = 0;
+  if (currentTrack?.curves.length) {
+    for (const c of currentTrack.curves) {
+      x += Math.pow(Math.sin((worldY * 2 * Math.PI) / c.wavelength + c.phase), c.sharpness) * c.amplitude;
+    }
+  } else {
+    const baseCurve = Math.sin(worldY * CONFIG.ROAD_SCALE) * CONFIG.ROAD_AMPLITUDE;
+    const sharpTurn = Math.pow(Math.sin(worldY * CONFIG.ROAD_SCALE * 1.5), 3) * (CONFIG.ROAD_AMPLITUDE * 0.5);
+    x = baseCurve + sharpTurn;
+  }
+  x *= roadScale(worldY); // Escalating road (protocol v22)
+  return currentTrack?.mirror ? -x : x; // Mirror variant (protocol v21)
+}
+
+// Factor of the bends' amplitude at road distance d on an escalating road
+// (protocol v22), 1 elsewhere. MUST match server implementation exactly
+function roadScale(d: number): number {
+  if (!currentTrack?.escalationRate || d <= 0) return 1;
+  return 1 + Math.min(currentTrack.escalationMax ?? 0, d * currentTrack.escalationRate);
+}
+
+// Whether the room drives at night (protocol v21): less of the road ahead
+// is visible
+export function isNight(): boolean {
+  return currentTrack?.night ?? false;
+}
+
+// Climb of the road at worldY: height per unit forward, negative downhill.
+// MUST match server implementation exactly
+export function getRoadGrade(worldY: number): number {
+  if (!currentTrack?.elevation?.length) return 0;
+  return curvesSlope(currentTrack.elevation, worldY + roadOrigin);
+}
+
+// How banked the road is at worldY, 0 (flat) to the track's banking.
+// MUST match server implementation exactly
+export function getRoadBank(worldY: number): number {
+  if (!currentTrack?.banking) return 0;
+  const d = worldY + roadOrigin;
+  const curvature = roadScale(d) * curvesCurvature(currentTrack.curves, d);
+  return currentTrack.banking * Math.min(1, Math.abs(curvature) / CONFIG.BANK_CURVATURE_FULL);
+}
+
+// Whether a car driving forward from fromY to toY left a jump ramp's lip on
+// the way: the ramp's height and grade. MUST match server implementation exactly
+export function getRoadLaunch(fromY: number, toY: number): { height: number; grade: number } | null {
+  for (const r of currentTrack?.ramps ?? []) {
+    const lip = r.offset + r.length;
+    const next = Math.max(0, Math.floor((fromY + roadOrigin - lip) / r.spacing) + 1); // First lip past fromY
+    if (lip + next * r.spacing <= toY + roadOrigin) {
+      return { height: r.height, grade: r.height / r.length };
+    }
+  }
+  return null;
+}
+
+// How far up a jump ramp worldY is, 0 (none or its foot) to 1 (its lip)
+export function getRoadRampRise(worldY: number): number {
+  for (const r of currentTrack?.ramps ?? []) {
+    const along = worldY + roadOrigin - r.offset;
+    if (along >= 0 && along % r.spacing < r.length) {
+      return (along % r.spacing) / r.length;
+    }
+  }
+  return 0;
+}
+
+// Whether worldY is in a pit zone (fuel mode). MUST match server implementation exactly
+export function inPit(worldY: number): boolean {
+  return (worldY + roadOrigin) % CONFIG.PIT_SPACING >= CONFIG.PIT_SPACING - CONFIG.PIT_LENGTH;
+}
+
+// Derivative of a sum of track curves at y
+function curvesSlope(curves: TrackCurve[], y: number): number {
+  let s = 0;
+  for (const c of curves) {
+    const k = (2 * Math.PI) / c.wavelength;
+    const a = y * k + c.phase;
+    s += c.amplitude * c.sharpness * Math.pow(Math.sin(a), c.sharpness - 1) * Math.cos(a) * k;
+  }
+  return s;
+}
+
+// Second derivative of a sum of track curves at y
+function curvesCurvature(curves: TrackCurve[], y: number): number {
+  let s = 0;
+  for (const c of curves) {
+    const k = (2 * Math.PI) / c.wavelength;
+    const a = y * k + c.phase;
+    const n = c.sharpness;
+    const sin = Math.sin(a);
+    const cos = Math.cos(a);
+    let d = -Math.pow(sin, n);
+    if (n > 1) {
+      d += (n - 1) * Math.pow(sin, n - 2) * cos * cos;
+    }
+    s += c.amplitude * n * k * k * d;
+  }
+  return s;
 }
 
 // Name generation using localized strings
@@ -77,6 +233,22 @@ export function generateName(): string {
   return `${adj} ${ani}`;
 }
 
+// Keep the name of a linked account for later sessions
+export function saveName(name: string): void {
+  localStorage.setItem('racer_name', name);
+}
+
+// Cosmetics picked on this device (see GET /api/cosmetics); the server
+// shows only those the player owns
+export function getCosmetics(): Cosmetics {
+  const [trail, decal] = (localStorage.getItem('racer_cosmetics') || '').split(',').map(Number);
+  return { trail: trail || 0, decal: decal || 0 };
+}
+
+export function saveCosmetics(cosmetics: Cosmetics): void {
+  localStorage.setItem('racer_cosmetics', `${cosmetics.trail},${cosmetics.decal}`);
+}
+
 export function getOrAssignName(): string {
   let name = localStorage.getItem('racer_name');
   if (!name) {
@@ -1,6 +1,6 @@
-import { CONFIG, getRoadCurve } from '@/config';
+import { CONFIG, getRoadBank, getRoadCurve, getRoadGrade, getRoadLaunch, getRoadWidth, inPit } from '@/config';
 import { GameStateManager } from './state';
-import { Particle } from '@/types';
+import { Collisions, Particle } from '@/types';
 
 export class Physics {
   private stateManager: GameStateManager;
@@ -14,7 +14,7 @@ export class Physics {
     const state = this.stateManager.gameState;
     const p = state.localPlayer;
 
-    if (p.exploded) return;
+    if (p.exploded || p.parked) return;
 
     const { keys, mouse, controlMode } = state;
 
@@ -27,6 +27,15 @@ export class Physics {
       p.rating += (speedFactor * speedFactor) * dt * 0.5;
     }
 
+    // In the air off a jump ramp: no grip, and nothing on the ground (the
+    // road's edges, the grass) touches the car until it lands
+    if (p.height > 0) {
+      this.fly(dt);
+      this.checkCollisions(dt);
+      this.stateManager.decayCameraShake();
+      return;
+    }
+
     // Process input based on control mode
     if (controlMode === 'keyboard') {
       if (keys.ArrowUp) accForce = CONFIG.ACCELERATION;
@@ -54,17 +63,27 @@ export class Physics {
     // Check road boundaries
     const roadCenter = getRoadCurve(p.y);
     const distFromCenter = Math.abs(p.x - roadCenter);
-    const roadHalfWidth = CONFIG.ROAD_WIDTH / 2;
+    const roadHalfWidth = getRoadWidth() / 2;
     const carHalfWidth = CONFIG.CAR_WIDTH / 2;
     const edgeDist = distFromCenter - roadHalfWidth;
     const isOffRoad = edgeDist > -carHalfWidth;
 
     // Explosion check
-    if (edgeDist > CONFIG.ROAD_WIDTH * CONFIG.EXPLOSION_TOLERANCE) {
+    if (edgeDist > getRoadWidth() * CONFIG.EXPLOSION_TOLERANCE) {
       this.triggerExplosion();
       return;
     }
 
+    // Fuel mode: pits refill the tank, throttle burns it
+    if (p.fuel !== undefined) {
+      if (distFromCenter <= roadHalfWidth && inPit(p.y)) {
+        p.fuel = Math.min(CONFIG.FUEL_CAPACITY, p.fuel + CONFIG.FUEL_REFILL * dt);
+      }
+      if (accForce > 0) {
+        p.fuel = Math.max(0, p.fuel - CONFIG.FUEL_BURN * accForce / CONFIG.ACCELERATION * dt);
+      }
+    }
+
     // Friction
     const activeFriction = isOffRoad ? CONFIG.FRICTION_OFFROAD : CONFIG.FRICTION_ROAD;
 
@@ -81,26 +100,44 @@ export class Physics {
       p.speed -= p.speed * 2.0 * dt;
     }
 
-    // Apply acceleration
+    // Apply acceleration, and gravity on hills
     p.speed += accForce * dt;
+    const grade = getRoadGrade(p.y);
+    if (grade !== 0) {
+      p.speed -= CONFIG.GRAVITY * grade * dt;
+    }
     p.speed = Math.max(-CONFIG.MAX_SPEED * 0.2, Math.min(p.speed, CONFIG.MAX_SPEED));
+    if (p.fuel !== undefined && p.fuel <= 0) {
+      p.speed = Math.min(p.speed, CONFIG.FUEL_DRY_SPEED); // Out of fuel
+    }
 
-    // Steering with understeer
+    // Steering with understeer, less of it in banked bends
+    const bank = getRoadBank(p.y);
     const speedRatio = Math.abs(p.speed) / CONFIG.MAX_SPEED;
-    const understeerFactor = Math.max(CONFIG.MIN_TURN_AUTHORITY, 1.0 - (speedRatio * CONFIG.INERTIA_DAMPENING));
+    const understeerFactor = Math.max(CONFIG.MIN_TURN_AUTHORITY, 1.0 - speedRatio * CONFIG.INERTIA_DAMPENING * (1 - bank));
 
     if (Math.abs(turnDir) > 0.01 && Math.abs(p.speed) > 20) {
       p.x += turnDir * CONFIG.TURN_SPEED * understeerFactor * dt;
       p.angle = turnDir * 25 * understeerFactor;
 
       // Speed penalty from turning
-      p.speed *= 1 - (0.3 * Math.abs(turnDir) * dt);
+      p.speed *= 1 - (0.3 * Math.abs(turnDir) * (1 - bank) * dt);
     } else {
       p.angle *= 0.9;
     }
 
-    // Update position
+    // Update position, taking off if the car drove off a ramp's lip on the
+    // road
+    const fromY = p.y;
     p.y += p.speed * dt;
+    if (p.speed > 0 && distFromCenter <= roadHalfWidth) {
+      const launch = getRoadLaunch(fromY, p.y);
+      if (launch) {
+        p.height = launch.height;
+        p.velZ = p.speed * launch.grade;
+      }
+    }
+    p.airborne = p.height > 0;
 
     // Check collisions with remote players
     this.checkCollisions(dt);
@@ -109,11 +146,35 @@ export class Physics {
     this.stateManager.decayCameraShake();
   }
 
+  // Move the local car in the air: it can't steer, throttle or brake, so it
+  // keeps its speed and heading while gravity pulls it down, and loses speed
+  // on landing, the more the harder it lands
+  private fly(dt: number): void {
+    const p = this.stateManager.localPlayer;
+    p.velZ -= CONFIG.GRAVITY * dt;
+    p.height += p.velZ * dt;
+    if (p.height <= 0) {
+      p.speed *= 1 - Math.min(CONFIG.LANDING_LOSS_MAX, -p.velZ * CONFIG.LANDING_LOSS);
+      p.height = 0;
+      p.velZ = 0;
+      this.stateManager.shakeCamera(Math.random() * 6 - 3, Math.random() * 6 - 3);
+    }
+    p.airborne = p.height > 0;
+    p.y += p.speed * dt;
+  }
+
   // Check collisions with remote players
   private checkCollisions(dt: number): void {
     const p = this.stateManager.localPlayer;
+    if (p.ghost) return; // Ghosts drive through everyone
+    const collisions = this.stateManager.gameState.collisions;
+    if (collisions === Collisions.Off) return;
+    const push = collisions === Collisions.Soft ? CONFIG.SOFT_COLLISION_FACTOR : 1;
 
     this.stateManager.remotePlayers.forEach((other) => {
+      // Cars in the air fly over those on the ground
+      if (other.ghost || other.airborne !== p.airborne) return;
+
       const dx = p.x - other.currentX;
       const dy = p.y - other.currentY;
       const dist = Math.sqrt(dx * dx + dy * dy);
@@ -125,7 +186,7 @@ export class Physics {
         const otherSpeed = other.speed || 0;
         const speedDiff = p.speed - otherSpeed;
 
-        let pushPower = CONFIG.PUSH_FORCE * (Math.abs(p.speed) + 100) * dt;
+        let pushPower = CONFIG.PUSH_FORCE * (Math.abs(p.speed) + 100) * dt * push;
 
         if (speedDiff > CONFIG.SPEED_DIFF_THRESHOLD) {
           pushPower *= CONFIG.SPEED_DIFF_MULTIPLIER;
@@ -137,7 +198,7 @@ export class Physics {
 
         p.x += nx * pushPower;
         p.y += ny * pushPower;
-        p.speed *= 0.9;
+        p.speed *= 1 - 0.1 * push;
       }
     });
   }
@@ -145,12 +206,28 @@ export class Physics {
   // Update remote player positions using dead reckoning
   updateRemotePlayers(): void {
     const now = Date.now();
+    // Render behind the latest state by the server-recommended delay
+    // (protocol v11), so late updates on a jittery connection still arrive
+    // before they are needed. Without a delay this is the latest packet.
+    const renderTime = now - this.stateManager.gameState.interpDelayMs;
 
     this.stateManager.remotePlayers.forEach((remote) => {
-      // Predict future position based on last packet
-      const timeSincePacket = (now - remote.lastPacketTime) / 1000;
-      const predictedY = remote.packetY + (remote.speed * timeSincePacket);
-      const predictedX = remote.packetX;
+      let packet = remote.packets[0] ?? {
+        time: remote.lastPacketTime, x: remote.packetX, y: remote.packetY, velX: remote.velX, velY: remote.velY,
+      };
+      for (const p of remote.packets) {
+        if (p.time <= renderTime) packet = p;
+      }
+
+      // Predict the position at render time from that packet. Servers
+      // speaking protocol v2 send the actual velocity; otherwise assume
+      // straight ahead. Lateral motion reverses quickly, so it is only
+      // extrapolated briefly.
+      const timeSincePacket = Math.max(0, renderTime - packet.time) / 1000;
+      const velY = packet.velY ?? remote.speed;
+      const velX = packet.velX ?? 0;
+      const predictedY = packet.y + (velY * timeSincePacket);
+      const predictedX = packet.x + (velX * Math.min(timeSincePacket, CONFIG.LATERAL_EXTRAPOLATION_LIMIT));
 
       // Interpolate towards prediction
       const t = 0.1;
@@ -1,5 +1,5 @@
-import { GameState, LocalPlayer, RemotePlayer, ControlMode, ColorPalette } from '@/types';
-import { getOrAssignName } from '@/config';
+import { GameState, LocalPlayer, RemotePlayer, ControlMode, ColorPalette, Cosmetics, Collisions } from '@/types';
+import { CONFIG, getOrAssignName } from '@/config';
 
 // Create initial game state
 export function createGameState(): GameState {
@@ -18,6 +18,12 @@ export function createGameState(): GameState {
     particles: [],
     camera: { shakeX: 0, shakeY: 0 },
     connected: false,
+    latencies: new Map(),
+    hostId: 0,
+    interpDelayMs: 0,
+    nearby: new Set(),
+    appearances: new Map(),
+    collisions: Collisions.On,
   };
 }
 
@@ -33,7 +39,11 @@ function createLocalPlayer(): LocalPlayer {
     angle: 0,
     rating: 0,
     exploded: false,
+    ghost: false,
+    airborne: false,
     lastSync: 0,
+    height: 0,
+    velZ: 0,
   };
 }
 
@@ -74,6 +84,10 @@ export class GameStateManager {
     this.state.localPlayer.speed = 0;
     this.state.localPlayer.rating = 0;
     this.state.localPlayer.exploded = false;
+    this.state.localPlayer.ghost = false;
+    this.state.localPlayer.airborne = false;
+    this.state.localPlayer.height = 0;
+    this.state.localPlayer.velZ = 0;
   }
 
   // Stop the game
@@ -81,9 +95,15 @@ export class GameStateManager {
     this.state.running = false;
   }
 
-  // Set player ID from server
+  // Set player ID from server (a new room: the old cosmetics are gone)
   setPlayerId(id: number): void {
     this.state.localPlayer.id = id;
+    this.state.appearances.clear();
+  }
+
+  // Set name (a guest linked to an account)
+  setName(name: string): void {
+    this.state.localPlayer.name = name;
   }
 
   // Set color
@@ -147,7 +167,18 @@ export class GameStateManager {
       if (data.color !== undefined) existing.color = data.color;
       if (data.name !== undefined) existing.name = data.name;
       if (data.exploded !== undefined) existing.exploded = data.exploded;
+      if (data.ghost !== undefined) existing.ghost = data.ghost;
+      if (data.airborne !== undefined) existing.airborne = data.airborne;
+      existing.velX = data.velX;
+      existing.velY = data.velY;
       existing.lastPacketTime = now;
+      if (data.x !== undefined && data.y !== undefined) {
+        existing.packets.push({ time: now, x: data.x, y: data.y, velX: data.velX, velY: data.velY });
+        // Keep what the longest interpolation delay needs
+        while (existing.packets.length > 1 && now - existing.packets[1].time > CONFIG.PACKET_HISTORY_MS) {
+          existing.packets.shift();
+        }
+      }
     } else {
       // New player
       const newPlayer: RemotePlayer = {
@@ -160,30 +191,102 @@ export class GameStateManager {
         angle: data.angle || 0,
         rating: data.rating || 0,
         exploded: data.exploded || false,
+        ghost: data.ghost || false,
+        airborne: data.airborne || false,
         packetX: data.x || 0,
         packetY: data.y || 0,
         currentX: data.x || 0,
         currentY: data.y || 0,
         lastPacketTime: now,
+        velX: data.velX,
+        velY: data.velY,
+        packets: data.x !== undefined && data.y !== undefined
+          ? [{ time: now, x: data.x, y: data.y, velX: data.velX, velY: data.velY }]
+          : [],
       };
       this.state.remotePlayers.set(id, newPlayer);
     }
   }
 
+  // Set the interpolation delay recommended by the server
+  setInterpDelay(delayMs: number): void {
+    this.state.interpDelayMs = delayMs;
+  }
+
+  // Set how the room's cars collide (protocol v37)
+  setCollisions(collisions: number): void {
+    this.state.collisions = collisions;
+  }
+
+  // Replace the set of nearby cars from a Nearby message
+  setNearby(ids: number[]): void {
+    this.state.nearby = new Set(ids);
+  }
+
+  // Set the cosmetics shown on a player's car (none: forget them)
+  setAppearance(id: number, cosmetics: Cosmetics): void {
+    if (cosmetics.trail || cosmetics.decal) {
+      this.state.appearances.set(id, cosmetics);
+    } else {
+      this.state.appearances.delete(id);
+    }
+  }
+
+  // Cosmetics shown on a player's car, if any
+  appearance(id: number): Cosmetics | undefined {
+    return this.state.appearances.get(id);
+  }
+
+  // Replace per-player latencies from a scoreboard message
+  setLatencies(entries: { id: number; rttMs: number }[]): void {
+    this.state.latencies.clear();
+    for (const { id, rttMs } of entries) {
+      this.state.latencies.set(id, rttMs);
+    }
+  }
+
+  // Name of a player in the room (undefined if unknown)
+  playerName(id: number): string | undefined {
+    if (id === this.state.localPlayer.id) {
+      return this.state.localPlayer.name;
+    }
+    return this.state.remotePlayers.get(id)?.name;
+  }
+
   // Remove remote player
   removeRemotePlayer(id: number): void {
     this.state.remotePlayers.delete(id);
   }
 
+  // Move everything along the road by dy (world rebasing)
+  shiftY(dy: number): void {
+    this.state.localPlayer.y += dy;
+    this.state.remotePlayers.forEach((p) => {
+      p.y += dy;
+      p.packetY += dy;
+      p.currentY += dy;
+      for (const packet of p.packets) {
+        packet.y += dy;
+      }
+    });
+    for (const p of this.state.particles) {
+      p.y += dy;
+    }
+  }
+
   // Clear all remote players
   clearRemotePlayers(): void {
     this.state.remotePlayers.clear();
+    this.state.appearances.clear();
   }
 
   // Explode player
   explodePlayer(): void {
     this.state.localPlayer.exploded = true;
     this.state.localPlayer.rating = 0;
+    this.state.localPlayer.airborne = false;
+    this.state.localPlayer.height = 0;
+    this.state.localPlayer.velZ = 0;
   }
 
   // Respawn player at road center
@@ -192,6 +295,9 @@ export class GameStateManager {
     this.state.localPlayer.speed = 0;
     this.state.localPlayer.angle = 0;
     this.state.localPlayer.x = getRoadCurve(this.state.localPlayer.y);
+    if (this.state.localPlayer.fuel !== undefined) {
+      this.state.localPlayer.fuel = CONFIG.FUEL_CAPACITY; // A new tank with the new run
+    }
   }
 
   // Add particles
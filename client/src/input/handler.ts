@@ -22,6 +22,9 @@ export class InputHandler {
 
   // Callbacks
   private onControlModeChange?: (mode: ControlMode) => void;
+  private onReset?: () => void;
+  private onPrestige?: () => void;
+  private onClip?: () => void;
 
   constructor(stateManager: GameStateManager, canvas: HTMLCanvasElement) {
     this.stateManager = stateManager;
@@ -87,6 +90,21 @@ export class InputHandler {
     this.onControlModeChange = callback;
   }
 
+  // Set reset (R key) callback
+  setOnReset(callback: () => void): void {
+    this.onReset = callback;
+  }
+
+  // Set prestige (P key) callback
+  setOnPrestige(callback: () => void): void {
+    this.onPrestige = callback;
+  }
+
+  // Set highlight clip (C key) callback
+  setOnClip(callback: () => void): void {
+    this.onClip = callback;
+  }
+
   // Handle key down
   private handleKeyDown(e: KeyboardEvent): void {
     const mappedKey = this.keyMap[e.code];
@@ -115,6 +133,21 @@ export class InputHandler {
 
       this.onControlModeChange?.(newMode);
     }
+
+    // Back to the start line (practice rooms)
+    if (e.code === 'KeyR' && !e.repeat) {
+      this.onReset?.();
+    }
+
+    // Reset the run for prestige (endless mode)
+    if (e.code === 'KeyP' && !e.repeat) {
+      this.onPrestige?.();
+    }
+
+    // Save a highlight clip
+    if (e.code === 'KeyC' && !e.repeat) {
+      this.onClip?.();
+    }
   }
 
   // Handle key up
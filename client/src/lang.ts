@@ -16,6 +16,8 @@ export const LANG = {
   controlHint: (mode: string) => `Управление: ${mode} (Пробел для переключения)`,
   currentRating: 'Текущий рейтинг',
   speedUnit: 'км/ч',
+  fuel: 'Топливо',
+  msUnit: 'мс',
   turnRight: 'ПРАВО',
   turnLeft: 'ЛЕВО',
 
@@ -39,6 +41,28 @@ export const LANG = {
   ratingFalls: 'РЕЙТИНГ ПАДАЕТ ДО НУЛЯ',
   controls: 'Управление',
   igniteEngine: 'Запустить двигатель',
+  practice: 'Тренировка',
+  practiceReplay: 'Призрак лучшей попытки',
+  practiceTrack: 'Трасса (ID, необязательно)',
+  tutorial: 'Обучение',
+  tutorialStep: (step: number, steps: number) => `Шаг ${step} из ${steps}`,
+  tutorialDone: 'Готово',
+
+  // Round results
+  roundResults: (round: number) => `Итоги раунда ${round}`,
+  awardMVP: (rating: number) => `MVP: ${rating.toLocaleString()} очков`,
+  awardOvertakes: (count: number) => `Больше всех обгонов: ${count}`,
+  awardCleanest: (perKm: number) => `Самый аккуратный: ${perKm.toFixed(2)} касаний на 1000`,
+  awardSurvival: (seconds: number) => `Дольше всех без аварий: ${seconds} с`,
+  awardFastestSector: (seconds: number) => `Быстрейший сектор: ${seconds.toFixed(2)} с`,
+
+  // Tournament rooms
+  eventSecretPrompt: 'Секретный код турнира',
+  eventSecretWrong: 'Неверный код турнира, попробуйте ещё раз',
+
+  // Endless mode announcements
+  milestoneDistance: (name: string, count: number, bonus: number) => `${name}: рубеж ${count} (+${bonus} очков)`,
+  milestonePrestige: (name: string, count: number, score: number) => `${name}: престиж ${count}, в копилку ${score.toLocaleString()} очков`,
 
   // Control legend (desktop)
   controlArrows: 'Стрелки / WASD',
@@ -47,6 +71,9 @@ export const LANG = {
   controlMove: 'Движение',
   controlSteer: 'Руление и газ',
   controlToggle: 'Переключение режима',
+  controlReset: 'На старт (тренировка)',
+  controlPrestige: 'Престиж (от 20 000 очков)',
+  controlClip: 'Сохранить клип (30 секунд)',
 
   // Control legend (mobile)
   controlJoystick: 'Джойстик',
@@ -58,6 +85,12 @@ export const LANG = {
   topTen: 'Топ 10',
   noPlayers: 'Нет игроков',
 
+  // Errors
+  rejoinCooldown: (seconds: number) => `Вы были исключены. Повторный вход через ${seconds} с`,
+
+  // Join queue
+  queuePosition: (position: number, length: number) => `Сервер заполнен. Вы в очереди: ${position} из ${length}`,
+
   // Welcome
   welcome: (name: string) => `Добро пожаловать, ${name}`,
 
@@ -74,7 +107,7 @@ export const LANG = {
   ],
 } as const;
 
-import { ControlMode } from './types';
+import { AwardKind, ControlMode, Milestone, MilestoneKind, RoundAward } from './types';
 
 // Get localized control mode name
 export function getControlModeName(mode: ControlMode): string {
@@ -85,3 +118,24 @@ export function getControlModeName(mode: ControlMode): string {
     case 'tilt': return LANG.modeTilt;
   }
 }
+
+// Get the localized text of an endless-mode milestone
+export function getMilestoneText(milestone: Milestone, name: string): string {
+  switch (milestone.kind) {
+    case MilestoneKind.Distance: return LANG.milestoneDistance(name, milestone.count, Math.floor(milestone.value));
+    case MilestoneKind.Prestige: return LANG.milestonePrestige(name, milestone.count, Math.floor(milestone.value));
+    default: return '';
+  }
+}
+
+// Get the localized text of a round award
+export function getAwardText(award: RoundAward): string {
+  switch (award.kind) {
+    case AwardKind.MVP: return LANG.awardMVP(Math.floor(award.value));
+    case AwardKind.Overtakes: return LANG.awardOvertakes(award.value);
+    case AwardKind.Cleanest: return LANG.awardCleanest(award.value);
+    case AwardKind.Survival: return LANG.awardSurvival(Math.floor(award.value));
+    case AwardKind.FastestSector: return LANG.awardFastestSector(award.value);
+    default: return '';
+  }
+}
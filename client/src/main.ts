@@ -1,6 +1,6 @@
 import './styles/main.css';
 
-import { CONFIG, getRoadCurve } from './config';
+import { CONFIG, getCosmetics, getRoadCurve, saveCosmetics, saveName, setRoadOrigin, setTrack } from './config';
 import { gameState, GameStateManager } from './game/state';
 import { Physics } from './game/physics';
 import { Renderer } from './render/renderer';
@@ -10,7 +10,7 @@ import { protocol } from './network/protocol';
 import { HUD } from './ui/hud';
 import { Leaderboard } from './ui/leaderboard';
 import { Screens } from './ui/screens';
-import { NetworkPlayerData } from './types';
+import { CollisionEvent, Cosmetics, DirectorShot, JoinOptions, Milestone, NetworkPlayerData, OwnState, PrivateRoomRef, RaceStanding, RaceStart, RelayTeam, RoomSettings, RoundAward, TrackDefinition, TrackRef } from './types';
 import { LANG } from './lang';
 
 class Game {
@@ -25,10 +25,12 @@ class Game {
   private screens: Screens;
 
   private lastTime = 0;
+  private fps = 60; // Smoothed render frame rate, reported to the server
   private lastSyncTime = 0;
   private animationFrameId: number | null = null;
   private physicsAccumulator = 0;
-  private readonly PHYSICS_STEP = 1 / 60; // Fixed 60Hz physics
+  private physicsStep = 1 / 60; // Fixed-step physics, 60Hz unless the room announces another rate
+  private practice = false; // In a private practice room (R resets to the start)
 
   constructor() {
     // Get canvas
@@ -69,7 +71,7 @@ class Game {
         this.screens.showStartScreen();
       },
 
-      onStateUpdate: (_tick: number, players: NetworkPlayerData[]) => {
+      onStateUpdate: (_tick: number, players: NetworkPlayerData[], partial: boolean) => {
         // Update remote players from server state
         const activeIds = new Set<number>();
 
@@ -79,13 +81,23 @@ class Game {
             // Only update position if significantly different (anti-cheat correction)
             // Smooth correction toward server position (no hard snaps)
             const local = this.stateManager.localPlayer;
-            const correctionSpeed = 0.1; // 10% per update toward server
-            local.x += (p.x - local.x) * correctionSpeed;
-            local.y += (p.y - local.y) * correctionSpeed;
+            if (local.parked) {
+              // A relay teammate drives, or we wait on a race's grid: the
+              // server places our car
+              local.x = p.x;
+              local.y = p.y;
+              local.speed = p.speed;
+              local.angle = p.angle;
+            } else {
+              const correctionSpeed = 0.1; // 10% per update toward server
+              local.x += (p.x - local.x) * correctionSpeed;
+              local.y += (p.y - local.y) * correctionSpeed;
+            }
 
             // Always sync rating from server
             local.rating = p.rating;
             local.exploded = protocol.isExploded(p.flags);
+            local.ghost = protocol.isGhost(p.flags);
           } else {
             // Remote player
             activeIds.add(p.id);
@@ -97,21 +109,38 @@ class Game {
               rating: p.rating,
               color: protocol.getColorHex(p.color),
               exploded: protocol.isExploded(p.flags),
+              ghost: protocol.isGhost(p.flags),
+              airborne: protocol.isAirborne(p.flags),
+              velX: p.velX,
+              velY: p.velY,
             });
           }
         });
 
-        // Remove players not in update
-        this.stateManager.remotePlayers.forEach((_, id) => {
-          if (!activeIds.has(id) && id !== this.stateManager.localPlayer.id) {
-            this.stateManager.removeRemotePlayer(id);
-          }
-        });
+        // Remove players not in update (partial updates leave out players
+        // whose motion is predictable; those leave via PlayerLeave instead)
+        if (!partial) {
+          this.stateManager.remotePlayers.forEach((_, id) => {
+            if (!activeIds.has(id) && id !== this.stateManager.localPlayer.id) {
+              this.stateManager.removeRemotePlayer(id);
+            }
+          });
+        }
 
         // Update leaderboard
         this.leaderboard.update();
       },
 
+      onOwnState: (own: OwnState) => {
+        // Fuel is the server's alone: take its level, and predict from there
+        const local = this.stateManager.localPlayer;
+        local.fuel = own.fuel !== undefined ? own.fuel * CONFIG.FUEL_CAPACITY : undefined;
+        // Inputs after the ack aren't in the server's state yet
+        if (own.ack !== undefined) {
+          local.inputAck = own.ack;
+        }
+      },
+
       onPlayerJoin: (id: number, name: string, color: number) => {
         this.stateManager.updateRemotePlayer(id, {
           name,
@@ -121,11 +150,15 @@ class Game {
 
       onPlayerLeave: (id: number) => {
         this.stateManager.removeRemotePlayer(id);
+        this.stateManager.setAppearance(id, { trail: 0, decal: 0 }); // IDs are reused
         this.leaderboard.update();
       },
 
-      onRoomInfo: (roomId: string, _playerCount: number, _maxPlayers: number, yourId: number) => {
+      onRoomInfo: (roomId: string, _playerCount: number, _maxPlayers: number, yourId: number, collisions: number) => {
         this.stateManager.setPlayerId(yourId);
+        this.stateManager.setCollisions(collisions);
+        setTrack(null); // Built-in road unless a Track message follows
+        setRoadOrigin(0); // Start of the road unless a Rebase message follows
         this.hud.setStatus(`${LANG.room}: ${roomId.slice(0, 8)}`);
       },
 
@@ -133,6 +166,146 @@ class Game {
         this.screens.showError(message);
       },
 
+      // Private rooms: the page shows host controls while
+      // "vracer:host-change" says we're the host
+      onHostChange: (hostId: number) => {
+        this.stateManager.gameState.hostId = hostId;
+        const you = hostId !== 0 && hostId === this.stateManager.localPlayer.id;
+        window.dispatchEvent(new CustomEvent('vracer:host-change', { detail: { hostId, you } }));
+      },
+
+      onScoreboard: (entries: { id: number; rttMs: number }[]) => {
+        this.stateManager.setLatencies(entries);
+      },
+
+      onQueueStatus: (position: number, length: number) => {
+        this.hud.setStatus(LANG.queuePosition(position, length));
+      },
+
+      onTickRate: (physicsRate: number, _broadcastRate: number) => {
+        // Predict at the room's rate so local physics matches the server
+        this.physicsStep = 1 / physicsRate;
+      },
+
+      onTrack: (track: TrackDefinition) => {
+        setTrack(track);
+      },
+
+      onResults: (round: number, awards: RoundAward[]) => {
+        this.hud.showResults(round, awards, (id) => this.stateManager.playerName(id));
+      },
+
+      onRebase: (origin: number, shift: number) => {
+        setRoadOrigin(origin);
+        this.stateManager.shiftY(-shift);
+      },
+
+      onInterpDelay: (delayMs: number) => {
+        this.stateManager.setInterpDelay(delayMs);
+      },
+
+      onLinked: (account: string) => {
+        this.stateManager.setName(account);
+        saveName(account);
+        this.screens.setPlayerName(account);
+      },
+
+      onSignal: (fromId: number, kind: number, payload: string) => {
+        window.dispatchEvent(new CustomEvent('vracer:signal', { detail: { fromId, kind, payload } }));
+      },
+
+      onMutes: (names: string[]) => {
+        window.dispatchEvent(new CustomEvent('vracer:mutes', { detail: { names } }));
+      },
+
+      onRoomCode: (code: string) => {
+        window.dispatchEvent(new CustomEvent('vracer:room-code', { detail: { code } }));
+      },
+
+      onRoomSettings: (settings: RoomSettings) => {
+        window.dispatchEvent(new CustomEvent('vracer:room-settings', { detail: settings }));
+      },
+
+      // Relay rooms: we drive only while we hold the baton; the page shows
+      // the team's legs and distance from "vracer:relay"
+      onRelay: (team: RelayTeam) => {
+        this.stateManager.localPlayer.parked = team.active !== this.stateManager.localPlayer.id;
+        window.dispatchEvent(new CustomEvent('vracer:relay', { detail: team }));
+      },
+
+      // Race rooms: our car waits on the grid through the countdown; the
+      // page shows the race from "vracer:race-start", "vracer:checkpoint",
+      // "vracer:race-finished" and "vracer:leaderboard"
+      onRaceStart: (race: RaceStart) => {
+        this.stateManager.localPlayer.parked = race.entered && race.countdownMs > 0;
+        window.dispatchEvent(new CustomEvent('vracer:race-start', { detail: race }));
+      },
+
+      onCheckpoint: (standing: RaceStanding) => {
+        window.dispatchEvent(new CustomEvent('vracer:checkpoint', { detail: standing }));
+      },
+
+      onRaceFinished: (playerId: number, place: number, timeMs: number) => {
+        window.dispatchEvent(new CustomEvent('vracer:race-finished', { detail: { playerId, place, timeMs } }));
+      },
+
+      onLeaderboard: (race: number, final: boolean, standings: RaceStanding[]) => {
+        window.dispatchEvent(new CustomEvent('vracer:leaderboard', { detail: { race, final, standings } }));
+      },
+
+      // Highlight clips: the page shares the clip of "vracer:clip", served
+      // at /api/clips/<id>
+      onClipSaved: (id: string) => {
+        window.dispatchEvent(new CustomEvent('vracer:clip', { detail: { id } }));
+      },
+
+      onNearby: (ids: number[]) => {
+        this.stateManager.setNearby(ids);
+        window.dispatchEvent(new CustomEvent('vracer:nearby', { detail: { ids } }));
+      },
+
+      onAppearance: (id: number, cosmetics: Cosmetics) => {
+        this.stateManager.setAppearance(id, cosmetics);
+        window.dispatchEvent(new CustomEvent('vracer:appearance', { detail: { id, ...cosmetics } }));
+      },
+
+      // Only spectators get the director's shots (broadcast overlays)
+      onDirector: (shot: DirectorShot) => {
+        window.dispatchEvent(new CustomEvent('vracer:director', { detail: shot }));
+      },
+
+      onMilestone: (milestone: Milestone) => {
+        this.hud.showMilestone(milestone, this.stateManager.playerName(milestone.playerId) ?? '?');
+      },
+
+      // Shake the camera as hard as the local car was hit; the page can
+      // scale crash sounds with "vracer:collision"
+      onCollision: (collision: CollisionEvent) => {
+        const id = this.stateManager.localPlayer.id;
+        if (collision.a === id || collision.b === id) {
+          const shake = Math.min(CONFIG.IMPACT_SHAKE_MAX, collision.impact * CONFIG.IMPACT_SHAKE);
+          this.stateManager.shakeCamera(Math.random() * shake * 2 - shake, Math.random() * shake * 2 - shake);
+        }
+        window.dispatchEvent(new CustomEvent('vracer:collision', { detail: collision }));
+      },
+
+      onTutorial: (step: number, steps: number, text: string) => {
+        this.hud.setTutorial(step, steps, text);
+      },
+
+      onCooldown: (remainingMs: number, _offenses: number) => {
+        this.screens.showError(LANG.rejoinCooldown(Math.ceil(remainingMs / 1000)));
+      },
+
+      // Tournament rooms ask for the event's secret code, handed out to
+      // the registered players
+      onChallenge: (retry: boolean) => {
+        const secret = window.prompt(retry ? LANG.eventSecretWrong : LANG.eventSecretPrompt);
+        if (secret) {
+          this.network.setEventSecret(secret);
+        }
+      },
+
       onLatencyUpdate: (_latency: number) => {
         // Could display latency in UI if needed
       },
@@ -149,15 +322,97 @@ class Game {
       this.stateManager.setColor(colorIndex);
     });
 
-    // Join button
-    this.screens.setOnJoin((colorIndex) => {
+    // Join and practice buttons
+    this.screens.setOnJoin((colorIndex, options, track) => {
       this.stateManager.setColor(colorIndex);
-      this.startGame();
+      this.startGame(options, track);
+    });
+
+    // R: back to the start line in a practice room
+    this.inputHandler.setOnReset(() => {
+      if (!this.practice || !this.stateManager.isRunning) return;
+      this.network.resetPosition();
+      this.stateManager.startGame();
+      this.screens.hideWastedScreen();
+    });
+
+    // P: reset the run for prestige (endless mode; the server checks the rating)
+    this.inputHandler.setOnPrestige(() => {
+      if (this.practice || !this.stateManager.isRunning) return;
+      this.network.prestige();
+    });
+
+    // C: save a clip of the last 30 seconds around our car
+    this.inputHandler.setOnClip(() => {
+      if (!this.stateManager.isRunning) return;
+      this.network.requestClip();
+    });
+
+    // Testers in a QA room degrade their connection from the console or a
+    // debug panel with "vracer:netsim" ({ latencyMs, jitterMs, loss })
+    window.addEventListener('vracer:netsim', (e) => {
+      const { latencyMs = 0, jitterMs = 0, loss = 0 } = (e as CustomEvent<{ latencyMs?: number; jitterMs?: number; loss?: number }>).detail ?? {};
+      this.network.simulateNetwork(latencyMs, jitterMs, loss);
+    });
+
+    // The page's account widget hands over link tokens: after a guest
+    // registers or logs in, it dispatches "vracer:link" with the token
+    window.addEventListener('vracer:link', (e) => {
+      const token = (e as CustomEvent<{ token?: string }>).detail?.token;
+      if (token) this.network.linkAccount(token);
+    });
+
+    // The page's shop or locker picks the car's cosmetics with
+    // "vracer:cosmetics" ({ trail, decal } wire IDs); they apply from the
+    // next join and the server shows only those the player owns
+    window.addEventListener('vracer:cosmetics', (e) => {
+      const detail = (e as CustomEvent<Partial<Cosmetics>>).detail;
+      saveCosmetics({ trail: detail?.trail ?? 0, decal: detail?.decal ?? 0 });
+    });
+
+    // The page's voice chat widget runs the WebRTC peer connections: it gets
+    // relayed signaling as "vracer:signal" events and sends its own with
+    // "vracer:signal-send" ({ targetId, kind, payload })
+    window.addEventListener('vracer:signal-send', (e) => {
+      const detail = (e as CustomEvent<{ targetId?: number; kind?: number; payload?: string }>).detail;
+      if (detail?.targetId === undefined || detail.kind === undefined) return;
+      this.network.sendSignal(detail.targetId, detail.kind, detail.payload ?? '');
+    });
+
+    // It mutes players with "vracer:mute" ({ op, targetId }, see MuteOp);
+    // the server keeps the mutes and stops relaying signaling between muted
+    // players, and every change comes back as "vracer:mutes" ({ names })
+    window.addEventListener('vracer:mute', (e) => {
+      const detail = (e as CustomEvent<{ op?: number; targetId?: number }>).detail;
+      if (detail?.op === undefined) return;
+      this.network.sendMute(detail.op, detail.targetId ?? 0);
+    });
+
+    // The page's friends widget plays in private rooms: "vracer:private"
+    // ({ password }) opens one and ({ code, password }) joins one by its
+    // code; once in, the room's code comes back as "vracer:room-code"
+    // ({ code }) to share
+    window.addEventListener('vracer:private', (e) => {
+      const detail = (e as CustomEvent<{ code?: string; password?: string }>).detail;
+      this.startGame(0, undefined, { code: detail?.code, password: detail?.password ?? '' });
+    });
+
+    // The host's controls: "vracer:host" ({ startRace: true }) starts the
+    // next race, ({ closed, password }) changes who may join; the room's
+    // settings come back as "vracer:room-settings" ({ closed, password })
+    window.addEventListener('vracer:host', (e) => {
+      const detail = (e as CustomEvent<{ startRace?: boolean; closed?: boolean; password?: string }>).detail;
+      if (detail?.startRace) {
+        this.network.hostStartRace();
+      } else if (detail?.closed !== undefined) {
+        this.network.hostSettings(detail.closed, detail.password ?? '');
+      }
     });
 
     // Control mode change
     this.inputHandler.setOnControlModeChange((mode) => {
       this.hud.setControlMode(mode);
+      this.network.setControlMode(mode);
     });
 
     // Initialize input handler
@@ -165,7 +420,10 @@ class Game {
   }
 
   // Start the game
-  private startGame(): void {
+  private startGame(options = 0, track?: TrackRef, privateRoom?: PrivateRoomRef): void {
+    this.practice = (options & (JoinOptions.Solo | JoinOptions.Tutorial)) !== 0;
+    this.hud.hideTutorial();
+
     // Connect to server if not connected
     if (!this.stateManager.gameState.connected) {
       this.network.connect();
@@ -174,7 +432,13 @@ class Game {
     // Join room
     const name = this.stateManager.localPlayer.name;
     const colorIndex = this.stateManager.getColorIndex();
-    this.network.joinRoom(name, colorIndex);
+    const cosmetics = getCosmetics();
+    if (privateRoom) {
+      this.network.joinPrivateRoom(privateRoom, name, colorIndex, cosmetics);
+    } else {
+      if (cosmetics.trail || cosmetics.decal) options |= JoinOptions.Cosmetics;
+      this.network.joinRoom(name, colorIndex, options, track, cosmetics);
+    }
 
     // Start game state
     this.stateManager.startGame();
@@ -190,6 +454,7 @@ class Game {
     // Hide start screen
     this.screens.hideStartScreen();
     this.hud.setControlMode(this.stateManager.controlMode);
+    this.network.setControlMode(this.stateManager.controlMode);
 
     // Show mobile controls if on mobile
     this.inputHandler.showMobileControls();
@@ -220,16 +485,21 @@ class Game {
 
     // Calculate delta time and accumulate
     const frameTime = Math.min((timestamp - this.lastTime) / 1000, 0.1);
+    this.network.recordFrame(timestamp - this.lastTime);
     this.lastTime = timestamp;
+    if (frameTime > 0) {
+      this.fps += (1 / frameTime - this.fps) * 0.05;
+      this.network.setFrameRate(this.fps);
+    }
     this.physicsAccumulator += frameTime;
 
     // Handle explosion and respawn
     this.handleExplosionState();
 
     // Fixed timestep physics - run multiple steps if needed
-    while (this.physicsAccumulator >= this.PHYSICS_STEP) {
-      this.physics.update(this.PHYSICS_STEP, this.canvas);
-      this.physicsAccumulator -= this.PHYSICS_STEP;
+    while (this.physicsAccumulator >= this.physicsStep) {
+      this.physics.update(this.physicsStep, this.canvas);
+      this.physicsAccumulator -= this.physicsStep;
     }
 
     // These can run at variable rate
@@ -259,6 +529,9 @@ class Game {
       return;
     }
     this.lastSyncTime = timestamp;
+    if (this.stateManager.localPlayer.parked) {
+      return; // The server drops a parked relay car's input, and input on a race's grid
+    }
 
     const steering = this.inputHandler.getSteering();
     const throttle = this.inputHandler.getThrottle();
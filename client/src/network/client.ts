@@ -1,17 +1,45 @@
-import { CONFIG } from '@/config';
+import { CONFIG, getRoadOrigin } from '@/config';
 import { protocol } from './protocol';
-import { MessageType, NetworkPlayerData } from '@/types';
+import { CollisionEvent, ControlMode, Cosmetics, DirectorShot, MessageType, Milestone, NetworkPlayerData, OwnState, PrivateRoomRef, RaceStanding, RaceStart, RelayTeam, RoomSettings, RoundAward, TrackDefinition, TrackRef } from '@/types';
 
 export type ConnectionState = 'disconnected' | 'connecting' | 'connected';
 
 export interface NetworkCallbacks {
   onConnect: () => void;
   onDisconnect: () => void;
-  onStateUpdate: (tick: number, players: NetworkPlayerData[]) => void;
+  onStateUpdate: (tick: number, players: NetworkPlayerData[], partial: boolean) => void;
+  onOwnState: (own: OwnState) => void;
   onPlayerJoin: (id: number, name: string, color: number) => void;
   onPlayerLeave: (id: number) => void;
-  onRoomInfo: (roomId: string, playerCount: number, maxPlayers: number, yourId: number) => void;
+  onRoomInfo: (roomId: string, playerCount: number, maxPlayers: number, yourId: number, collisions: number) => void;
   onError: (code: number, message: string) => void;
+  onCooldown: (remainingMs: number, offenses: number) => void;
+  onScoreboard: (entries: { id: number; rttMs: number }[]) => void;
+  onHostChange: (hostId: number) => void;
+  onQueueStatus: (position: number, length: number) => void;
+  onTickRate: (physicsRate: number, broadcastRate: number) => void;
+  onTutorial: (step: number, steps: number, text: string) => void;
+  onTrack: (track: TrackDefinition) => void;
+  onResults: (round: number, awards: RoundAward[]) => void;
+  onRebase: (origin: number, shift: number) => void;
+  onInterpDelay: (delayMs: number) => void;
+  onLinked: (account: string) => void;
+  onSignal: (fromId: number, kind: number, payload: string) => void;
+  onNearby: (ids: number[]) => void;
+  onAppearance: (id: number, cosmetics: Cosmetics) => void;
+  onDirector: (shot: DirectorShot) => void;
+  onMilestone: (milestone: Milestone) => void;
+  onCollision: (collision: CollisionEvent) => void;
+  onChallenge: (retry: boolean) => void;
+  onMutes: (names: string[]) => void;
+  onRoomCode: (code: string) => void;
+  onRoomSettings: (settings: RoomSettings) => void;
+  onRelay: (team: RelayTeam) => void;
+  onRaceStart: (race: RaceStart) => void;
+  onCheckpoint: (standing: RaceStanding) => void;
+  onRaceFinished: (playerId: number, place: number, timeMs: number) => void;
+  onLeaderboard: (race: number, final: boolean, standings: RaceStanding[]) => void;
+  onClipSaved: (id: string) => void;
   onLatencyUpdate: (latency: number) => void;
 }
 
@@ -24,6 +52,27 @@ export class NetworkClient {
   private reconnectDelay = 1000;
   private pingInterval: number | null = null;
   private lastLatency = 0;
+  private protocolVersion = 1; // Negotiated via Hello/HelloAck
+
+  // Performance report sent with pings
+  private fps = 0;
+  private jitterMs = 0;
+  private interpDelayMs = 0; // Recommended by the server (protocol v11)
+  private lastStateTime = 0;
+  private broadcastIntervalMs: number = CONFIG.BROADCAST_INTERVAL_MS; // Updated by TickRate
+
+  // Token of the last room joined, presented when reconnecting so the server
+  // puts us back where we left if it is soon enough (protocol v28)
+  private sessionToken: string | null = null;
+
+  // Tournament handshake (protocol v26)
+  private eventSecret: string | null = null;
+  private challengeNonce: Uint8Array | null = null; // Waiting for the secret
+  private challengeAnswered = false; // On this connection
+
+  // Telemetry asked for by probes (protocol v33)
+  private frameTimes: number[] = []; // Recent render frame times in ms, oldest first
+  private inputDevice = 1; // Class of the control mode: 1 keyboard and mouse, 2 touch
 
   constructor(callbacks: NetworkCallbacks) {
     this.callbacks = callbacks;
@@ -37,16 +86,35 @@ export class NetworkClient {
     return this.lastLatency;
   }
 
+  get version(): number {
+    return this.protocolVersion;
+  }
+
   connect(): void {
     if (this.state !== 'disconnected') {
       return;
     }
 
     this.state = 'connecting';
-    console.log('Connecting to', CONFIG.SERVER_URL);
+    this.challengeAnswered = false;
+    // Tournament players open the game with the match ticket in the page URL
+    const params = new URLSearchParams();
+    if (CONFIG.TENANT) {
+      params.set('tenant', CONFIG.TENANT);
+    }
+    const ticket = new URLSearchParams(window.location.search).get('ticket');
+    if (ticket) {
+      params.set('ticket', ticket);
+    }
+    if (this.sessionToken) {
+      params.set('session', this.sessionToken);
+    }
+    const query = params.toString();
+    const url = query ? `${CONFIG.SERVER_URL}?${query}` : CONFIG.SERVER_URL;
+    console.log('Connecting to', url);
 
     try {
-      this.ws = new WebSocket(CONFIG.SERVER_URL);
+      this.ws = new WebSocket(url, CONFIG.SUBPROTOCOL);
       this.ws.binaryType = 'arraybuffer';
 
       this.ws.onopen = this.handleOpen.bind(this);
@@ -69,18 +137,43 @@ export class NetworkClient {
     this.state = 'disconnected';
   }
 
-  joinRoom(name: string, colorIndex: number): void {
-    console.log('joinRoom called:', { name, colorIndex, state: this.state, ws: !!this.ws });
+  joinRoom(name: string, colorIndex: number, options = 0, track?: TrackRef, cosmetics?: Cosmetics): void {
+    console.log('joinRoom called:', { name, colorIndex, options, track, cosmetics, state: this.state, ws: !!this.ws });
     if (this.state !== 'connected' || !this.ws) {
       console.warn('Cannot join room: not connected');
       return;
     }
 
-    const message = protocol.encodeJoin(name, colorIndex);
+    const message = protocol.encodeJoin(name, colorIndex, options, track, cosmetics);
     console.log('Sending join message, bytes:', new Uint8Array(message));
     this.ws.send(message);
   }
 
+  // Open a private room, or join one by its code (protocol v30); the
+  // server answers with the room's code once we're in
+  joinPrivateRoom(room: PrivateRoomRef, name: string, colorIndex: number, cosmetics?: Cosmetics): void {
+    if (this.state !== 'connected' || !this.ws || this.protocolVersion < 30) {
+      return;
+    }
+
+    this.ws.send(
+      room.code
+        ? protocol.encodeJoinByCode(room.code, room.password, name, colorIndex, cosmetics)
+        : protocol.encodeCreateRoom(room.password, name, colorIndex, cosmetics)
+    );
+  }
+
+  // Watch a public room of this server without a car (protocol v32): the
+  // room info comes back with our ID 0, then the room's cars at a reduced
+  // rate. Leave stops watching.
+  spectate(roomId: string): void {
+    if (this.state !== 'connected' || !this.ws || this.protocolVersion < 32) {
+      return;
+    }
+
+    this.ws.send(protocol.encodeSpectate(roomId));
+  }
+
   sendInput(
     keys: { ArrowUp: boolean; ArrowDown: boolean; ArrowLeft: boolean; ArrowRight: boolean },
     steering: number,
@@ -94,6 +187,161 @@ export class NetworkClient {
     this.ws.send(message);
   }
 
+  // Report the render frame rate (smoothed by the caller)
+  setFrameRate(fps: number): void {
+    this.fps = fps;
+  }
+
+  // Keep a render frame's time for telemetry probes
+  recordFrame(ms: number): void {
+    this.frameTimes.push(ms);
+    if (this.frameTimes.length > CONFIG.TELEMETRY_FRAMES_KEPT) {
+      this.frameTimes.shift();
+    }
+  }
+
+  // Set the control mode, reported as an input device class in telemetry
+  setControlMode(mode: ControlMode): void {
+    this.inputDevice = mode === 'joystick' || mode === 'tilt' ? 2 : 1;
+  }
+
+  // Host only: remove another player from the room
+  kickPlayer(targetId: number): void {
+    if (this.state !== 'connected' || !this.ws) {
+      return;
+    }
+
+    this.ws.send(protocol.encodeHostKick(targetId));
+  }
+
+  // Host only: start the next race of a race room now (protocol v38)
+  hostStartRace(): void {
+    if (this.state !== 'connected' || !this.ws || this.protocolVersion < 38) {
+      return;
+    }
+
+    this.ws.send(protocol.encodeHostStartRace());
+  }
+
+  // Host only: close our private room to new players or change its
+  // password, empty for none (protocol v38)
+  hostSettings(closed: boolean, password: string): void {
+    if (this.state !== 'connected' || !this.ws || this.protocolVersion < 38) {
+      return;
+    }
+
+    this.ws.send(protocol.encodeHostSettings(closed, password));
+  }
+
+  // Go back to the start line of a practice room
+  resetPosition(): void {
+    if (this.state !== 'connected' || !this.ws) {
+      return;
+    }
+
+    this.ws.send(protocol.encodeReset());
+  }
+
+  // Reset the run for prestige, banking its score (protocol v23, endless mode)
+  prestige(): void {
+    if (this.state !== 'connected' || !this.ws || this.protocolVersion < 23) {
+      return;
+    }
+
+    this.ws.send(protocol.encodePrestige());
+  }
+
+  // Save a clip of the last seconds around our car (protocol v36); the ID
+  // comes back in a ClipSaved message
+  requestClip(): void {
+    if (this.state !== 'connected' || !this.ws || this.protocolVersion < 36) {
+      return;
+    }
+
+    this.ws.send(protocol.encodeClip());
+  }
+
+  // Have the server simulate network conditions on what it sends us
+  // (protocol v25, QA rooms): extra latency and jitter in ms, loss in percent
+  // of state updates; all zero clears them
+  simulateNetwork(latencyMs: number, jitterMs: number, loss: number): void {
+    if (this.state !== 'connected' || !this.ws || this.protocolVersion < 25) {
+      return;
+    }
+
+    this.ws.send(protocol.encodeNetSim(latencyMs, jitterMs, loss));
+  }
+
+  // Set the secret of the tournament event, handed out to its players, and
+  // answer the challenge waiting for it
+  setEventSecret(secret: string): void {
+    this.eventSecret = secret;
+    void this.answerChallenge();
+  }
+
+  // Answer the tournament challenge with the HMAC-SHA256 of its nonce keyed
+  // with the event secret (protocol v26). The secret never leaves the page.
+  private async answerChallenge(): Promise<void> {
+    const nonce = this.challengeNonce;
+    const secret = this.eventSecret;
+    if (!nonce || !secret) {
+      return;
+    }
+    this.challengeNonce = null;
+    this.challengeAnswered = true;
+    const key = await crypto.subtle.importKey('raw', new TextEncoder().encode(secret), { name: 'HMAC', hash: 'SHA-256' }, false, ['sign']);
+    const mac = await crypto.subtle.sign('HMAC', key, nonce);
+    this.ws?.send(protocol.encodeEventAuth(mac));
+  }
+
+  // Answer a telemetry probe with our last frame times, signed with the
+  // telemetry key of the build (protocol v33)
+  private async answerProbe(nonce: Uint8Array, count: number): Promise<void> {
+    const frames = this.frameTimes.slice(-count).map((ms) => Math.min(0xffff, Math.round(ms * 10)));
+    const body = protocol.telemetryBody(nonce, this.inputDevice, frames);
+    const key = await crypto.subtle.importKey('raw', new TextEncoder().encode(CONFIG.TELEMETRY_KEY), { name: 'HMAC', hash: 'SHA-256' }, false, ['sign']);
+    const mac = await crypto.subtle.sign('HMAC', key, body);
+    this.ws?.send(protocol.encodeTelemetry(body, mac));
+  }
+
+  // Upgrade the guest session to the account of a link token (protocol v12)
+  linkAccount(token: string): void {
+    if (this.state !== 'connected' || !this.ws || this.protocolVersion < 12) {
+      return;
+    }
+
+    this.ws.send(protocol.encodeLink(token));
+  }
+
+  // Send voice chat signaling to another player in the room (protocol v13)
+  sendSignal(targetId: number, kind: number, payload: string): void {
+    if (this.state !== 'connected' || !this.ws || this.protocolVersion < 13) {
+      return;
+    }
+
+    this.ws.send(protocol.encodeSignal(targetId, kind, payload));
+  }
+
+  // Mute, unmute, clear or list the players muted for voice chat (protocol
+  // v27); the server answers with the list
+  sendMute(op: number, targetId = 0): void {
+    if (this.state !== 'connected' || !this.ws || this.protocolVersion < 27) {
+      return;
+    }
+
+    this.ws.send(protocol.encodeMute(op, targetId));
+  }
+
+  // Change name and color without leaving the room (protocol v29); the
+  // room announces the change as a PlayerJoin of our car
+  updateProfile(name: string, colorIndex: number): void {
+    if (this.state !== 'connected' || !this.ws || this.protocolVersion < 29) {
+      return;
+    }
+
+    this.ws.send(protocol.encodeUpdateProfile(name, colorIndex));
+  }
+
   leaveRoom(): void {
     if (this.state !== 'connected' || !this.ws) {
       return;
@@ -107,6 +355,7 @@ export class NetworkClient {
     console.log('Connected to server');
     this.state = 'connected';
     this.reconnectAttempts = 0;
+    this.ws?.send(protocol.encodeHello(CONFIG.PROTOCOL_VERSION));
     this.startPingInterval();
     this.callbacks.onConnect();
   }
@@ -130,8 +379,19 @@ export class NetworkClient {
 
     switch (msgType) {
       case MessageType.StateUpdate: {
-        const { tick, players } = protocol.decodeStateUpdate(data);
-        this.callbacks.onStateUpdate(tick, players);
+        this.trackJitter();
+        const { tick, baseY, players, own } = protocol.decodeStateUpdate(data, this.protocolVersion);
+        // From protocol v10 on, every update says where the road starts
+        const origin = getRoadOrigin();
+        if (baseY !== undefined && baseY !== origin) {
+          this.callbacks.onRebase(baseY, baseY - origin);
+        }
+        // From protocol v3 on, players missing from an update are unchanged
+        this.callbacks.onStateUpdate(tick, players, this.protocolVersion >= 3);
+        // From protocol v20 on, the update ends with our own extended state
+        if (own) {
+          this.callbacks.onOwnState(own);
+        }
         break;
       }
 
@@ -148,8 +408,8 @@ export class NetworkClient {
       }
 
       case MessageType.RoomInfo: {
-        const { roomId, playerCount, maxPlayers, yourId } = protocol.decodeRoomInfo(data);
-        this.callbacks.onRoomInfo(roomId, playerCount, maxPlayers, yourId);
+        const { roomId, playerCount, maxPlayers, yourId, collisions } = protocol.decodeRoomInfo(data);
+        this.callbacks.onRoomInfo(roomId, playerCount, maxPlayers, yourId, collisions);
         break;
       }
 
@@ -160,6 +420,179 @@ export class NetworkClient {
         break;
       }
 
+      case MessageType.HelloAck: {
+        this.protocolVersion = protocol.decodeHelloAck(data).version;
+        break;
+      }
+
+      // A new challenge after our answer means the secret was wrong
+      case MessageType.Challenge: {
+        this.challengeNonce = protocol.decodeChallenge(data);
+        const retry = this.challengeAnswered;
+        if (retry) {
+          this.eventSecret = null;
+        }
+        if (this.eventSecret) {
+          void this.answerChallenge();
+        } else {
+          this.callbacks.onChallenge(retry);
+        }
+        break;
+      }
+
+      case MessageType.HostChange: {
+        this.callbacks.onHostChange(protocol.decodeHostChange(data).hostId);
+        break;
+      }
+
+      case MessageType.Scoreboard: {
+        this.callbacks.onScoreboard(protocol.decodeScoreboard(data));
+        break;
+      }
+
+      case MessageType.QueueStatus: {
+        const { position, length } = protocol.decodeQueueStatus(data);
+        this.callbacks.onQueueStatus(position, length);
+        break;
+      }
+
+      case MessageType.TickRate: {
+        const { physicsRate, broadcastRate } = protocol.decodeTickRate(data);
+        this.broadcastIntervalMs = 1000 / broadcastRate;
+        this.callbacks.onTickRate(physicsRate, broadcastRate);
+        break;
+      }
+
+      case MessageType.Track: {
+        this.callbacks.onTrack(protocol.decodeTrack(data, this.protocolVersion));
+        break;
+      }
+
+      case MessageType.Results: {
+        const { round, awards } = protocol.decodeResults(data);
+        this.callbacks.onResults(round, awards);
+        break;
+      }
+
+      case MessageType.Rebase: {
+        const { origin, shift } = protocol.decodeRebase(data);
+        this.callbacks.onRebase(origin, shift);
+        break;
+      }
+
+      case MessageType.InterpDelay: {
+        this.interpDelayMs = protocol.decodeInterpDelay(data);
+        this.callbacks.onInterpDelay(this.interpDelayMs);
+        break;
+      }
+
+      case MessageType.Linked: {
+        this.callbacks.onLinked(protocol.decodeLinked(data));
+        break;
+      }
+
+      case MessageType.SignalRelay: {
+        const { fromId, kind, payload } = protocol.decodeSignalRelay(data);
+        this.callbacks.onSignal(fromId, kind, payload);
+        break;
+      }
+
+      case MessageType.Session: {
+        this.sessionToken = protocol.decodeSession(data);
+        break;
+      }
+
+      case MessageType.RoomCode: {
+        this.callbacks.onRoomCode(protocol.decodeRoomCode(data));
+        break;
+      }
+
+      case MessageType.RoomSettings: {
+        this.callbacks.onRoomSettings(protocol.decodeRoomSettings(data));
+        break;
+      }
+
+      case MessageType.Relay: {
+        this.callbacks.onRelay(protocol.decodeRelay(data));
+        break;
+      }
+
+      case MessageType.RaceStart: {
+        this.callbacks.onRaceStart(protocol.decodeRaceStart(data));
+        break;
+      }
+
+      case MessageType.CheckpointPassed: {
+        this.callbacks.onCheckpoint(protocol.decodeCheckpointPassed(data));
+        break;
+      }
+
+      case MessageType.RaceFinished: {
+        const { playerId, place, timeMs } = protocol.decodeRaceFinished(data);
+        this.callbacks.onRaceFinished(playerId, place, timeMs);
+        break;
+      }
+
+      case MessageType.Leaderboard: {
+        const { race, final, standings } = protocol.decodeLeaderboard(data);
+        this.callbacks.onLeaderboard(race, final, standings);
+        break;
+      }
+
+      case MessageType.ClipSaved: {
+        this.callbacks.onClipSaved(protocol.decodeClipSaved(data));
+        break;
+      }
+
+      case MessageType.Probe: {
+        const { nonce, frames } = protocol.decodeProbe(data);
+        void this.answerProbe(nonce, frames);
+        break;
+      }
+
+      case MessageType.Mutes: {
+        this.callbacks.onMutes(protocol.decodeMutes(data));
+        break;
+      }
+
+      case MessageType.Nearby: {
+        this.callbacks.onNearby(protocol.decodeNearby(data));
+        break;
+      }
+
+      case MessageType.Appearance: {
+        const { id, trail, decal } = protocol.decodeAppearance(data);
+        this.callbacks.onAppearance(id, { trail, decal });
+        break;
+      }
+
+      case MessageType.Director: {
+        this.callbacks.onDirector(protocol.decodeDirector(data));
+        break;
+      }
+
+      case MessageType.Milestone: {
+        this.callbacks.onMilestone(protocol.decodeMilestone(data));
+        break;
+      }
+
+      case MessageType.Collision: {
+        this.callbacks.onCollision(protocol.decodeCollision(data));
+        break;
+      }
+
+      case MessageType.Tutorial: {
+        const { step, steps, text } = protocol.decodeTutorial(data);
+        this.callbacks.onTutorial(step, steps, text);
+        break;
+      }
+
+      case MessageType.Cooldown: {
+        const { remainingMs, offenses } = protocol.decodeCooldown(data);
+        this.callbacks.onCooldown(remainingMs, offenses);
+        break;
+      }
+
       case MessageType.Error: {
         const { code, message } = protocol.decodeError(data);
         this.callbacks.onError(code, message);
@@ -168,10 +601,20 @@ export class NetworkClient {
     }
   }
 
+  // Track state update jitter as a smoothed deviation from the broadcast interval
+  private trackJitter(): void {
+    const now = performance.now();
+    if (this.lastStateTime > 0) {
+      const deviation = Math.abs(now - this.lastStateTime - this.broadcastIntervalMs);
+      this.jitterMs += (deviation - this.jitterMs) * 0.1;
+    }
+    this.lastStateTime = now;
+  }
+
   private startPingInterval(): void {
     this.pingInterval = window.setInterval(() => {
       if (this.ws && this.state === 'connected') {
-        const ping = protocol.encodePing();
+        const ping = protocol.encodePing({ fps: this.fps, interpDelayMs: this.interpDelayMs, jitterMs: this.jitterMs });
         this.ws.send(ping);
       }
     }, 5000);
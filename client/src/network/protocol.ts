@@ -1,14 +1,43 @@
-import { MessageType, NetworkPlayerData, KeyFlags, PlayerFlags, ColorPalette } from '@/types';
+import { MessageType, KeyFlags, PlayerFlags, TrackVariant, ColorPalette, JoinOptions, Collisions, RoomSettingsFlags } from '@/types';
+import type {
+  NetworkPlayerData,
+  OwnState,
+  TrackRef,
+  TrackCurve,
+  TrackDefinition,
+  RoundAward,
+  Cosmetics,
+  DirectorShot,
+  Milestone,
+  CollisionEvent,
+  RelayTeam,
+  RaceStart,
+  RaceStanding,
+  RoomSettings,
+} from '@/types';
 
 // Binary protocol encoder/decoder
 
 export class Protocol {
   private sequenceNumber = 0;
 
-  // Encode join room message
-  encodeJoin(name: string, colorIndex: number): ArrayBuffer {
+  // Encode protocol handshake
+  encodeHello(version: number): ArrayBuffer {
+    const buffer = new ArrayBuffer(2);
+    const view = new DataView(buffer);
+    view.setUint8(0, MessageType.Hello);
+    view.setUint8(1, version);
+    return buffer;
+  }
+
+  // Encode join room message, with JoinOptions bits if any, the custom
+  // track for JoinOptions.Track and the cosmetics for JoinOptions.Cosmetics
+  encodeJoin(name: string, colorIndex: number, options = 0, track?: TrackRef, cosmetics?: Cosmetics): ArrayBuffer {
     const nameBytes = new TextEncoder().encode(name);
-    const buffer = new ArrayBuffer(3 + nameBytes.length);
+    const trackBytes = track && options & JoinOptions.Track ? new TextEncoder().encode(track.id) : null;
+    const trackSize = trackBytes ? 1 + trackBytes.length + 2 : 0;
+    const withCosmetics = cosmetics !== undefined && (options & JoinOptions.Cosmetics) !== 0;
+    const buffer = new ArrayBuffer(3 + nameBytes.length + (options ? 1 : 0) + trackSize + (withCosmetics ? 2 : 0));
     const view = new DataView(buffer);
     const arr = new Uint8Array(buffer);
 
@@ -16,10 +45,50 @@ export class Protocol {
     view.setUint8(1, nameBytes.length);
     arr.set(nameBytes, 2);
     view.setUint8(2 + nameBytes.length, colorIndex);
+    if (options) {
+      view.setUint8(3 + nameBytes.length, options);
+    }
+    if (trackBytes && track) {
+      const offset = 4 + nameBytes.length;
+      view.setUint8(offset, trackBytes.length);
+      arr.set(trackBytes, offset + 1);
+      view.setUint16(offset + 1 + trackBytes.length, track.version, true);
+    }
+    if (withCosmetics && cosmetics) {
+      const offset = 4 + nameBytes.length + trackSize;
+      view.setUint8(offset, cosmetics.trail);
+      view.setUint8(offset + 1, cosmetics.decal);
+    }
 
     return buffer;
   }
 
+  // Encode a private room to open (protocol v30): [pwLen][password]
+  // followed by a join's fields; of the options only Cosmetics applies
+  encodeCreateRoom(password: string, name: string, colorIndex: number, cosmetics?: Cosmetics): ArrayBuffer {
+    return this.encodePrivateJoin([MessageType.CreateRoom], password, name, colorIndex, cosmetics);
+  }
+
+  // Encode a join of a private room by its code (protocol v30):
+  // [codeLen][code][pwLen][password] followed by a join's fields
+  encodeJoinByCode(code: string, password: string, name: string, colorIndex: number, cosmetics?: Cosmetics): ArrayBuffer {
+    const codeBytes = new TextEncoder().encode(code);
+    return this.encodePrivateJoin([MessageType.JoinByCode, codeBytes.length, ...codeBytes], password, name, colorIndex, cosmetics);
+  }
+
+  // The password and join fields after the head of a private room message
+  private encodePrivateJoin(head: number[], password: string, name: string, colorIndex: number, cosmetics?: Cosmetics): ArrayBuffer {
+    const passwordBytes = new TextEncoder().encode(password);
+    const options = cosmetics && (cosmetics.trail || cosmetics.decal) ? JoinOptions.Cosmetics : 0;
+    const join = new Uint8Array(this.encodeJoin(name, colorIndex, options, undefined, cosmetics)).subarray(1);
+    const arr = new Uint8Array(head.length + 1 + passwordBytes.length + join.length);
+    arr.set(head, 0);
+    arr[head.length] = passwordBytes.length;
+    arr.set(passwordBytes, head.length + 1);
+    arr.set(join, head.length + 1 + passwordBytes.length);
+    return arr.buffer;
+  }
+
   // Encode input message (6 bytes)
   encodeInput(
     keys: { ArrowUp: boolean; ArrowDown: boolean; ArrowLeft: boolean; ArrowRight: boolean },
@@ -47,9 +116,9 @@ export class Protocol {
     return buffer;
   }
 
-  // Encode ping message
-  encodePing(): ArrayBuffer {
-    const buffer = new ArrayBuffer(9);
+  // Encode ping message, optionally with a performance report
+  encodePing(perf?: { fps: number; interpDelayMs: number; jitterMs: number }): ArrayBuffer {
+    const buffer = new ArrayBuffer(perf ? 14 : 9);
     const view = new DataView(buffer);
     const timestamp = Date.now();
 
@@ -57,9 +126,166 @@ export class Protocol {
     // Write timestamp as 8 bytes (little endian)
     view.setBigUint64(1, BigInt(timestamp), true);
 
+    if (perf) {
+      view.setUint8(9, Math.min(255, Math.round(perf.fps)));
+      view.setUint16(10, Math.min(0xffff, Math.round(perf.interpDelayMs)), true);
+      view.setUint16(12, Math.min(0xffff, Math.round(perf.jitterMs)), true);
+    }
+
+    return buffer;
+  }
+
+  // Encode host kick request
+  encodeHostKick(targetId: number): ArrayBuffer {
+    const buffer = new ArrayBuffer(3);
+    const view = new DataView(buffer);
+    view.setUint8(0, MessageType.HostKick);
+    view.setUint16(1, targetId, true);
+    return buffer;
+  }
+
+  // Encode host request to start the next race now (protocol v38)
+  encodeHostStartRace(): ArrayBuffer {
+    const buffer = new ArrayBuffer(1);
+    new DataView(buffer).setUint8(0, MessageType.HostStartRace);
+    return buffer;
+  }
+
+  // Encode host settings of a private room (protocol v38):
+  // [flags][pwLen][password], an empty password for none
+  encodeHostSettings(closed: boolean, password: string): ArrayBuffer {
+    const passwordBytes = new TextEncoder().encode(password);
+    const arr = new Uint8Array(3 + passwordBytes.length);
+    arr[0] = MessageType.HostSettings;
+    arr[1] = closed ? RoomSettingsFlags.Closed : 0;
+    arr[2] = passwordBytes.length;
+    arr.set(passwordBytes, 3);
+    return arr.buffer;
+  }
+
+  // Encode reset message (practice rooms: back to the start line)
+  encodeReset(): ArrayBuffer {
+    const buffer = new ArrayBuffer(1);
+    new DataView(buffer).setUint8(0, MessageType.Reset);
+    return buffer;
+  }
+
+  // Encode prestige reset request (protocol v23, endless mode)
+  encodePrestige(): ArrayBuffer {
+    const buffer = new ArrayBuffer(1);
+    new DataView(buffer).setUint8(0, MessageType.Prestige);
+    return buffer;
+  }
+
+  // Encode highlight clip request (protocol v36)
+  encodeClip(): ArrayBuffer {
+    const buffer = new ArrayBuffer(1);
+    new DataView(buffer).setUint8(0, MessageType.Clip);
+    return buffer;
+  }
+
+  // Encode simulated network conditions request (protocol v25, QA rooms)
+  encodeNetSim(latencyMs: number, jitterMs: number, loss: number): ArrayBuffer {
+    const buffer = new ArrayBuffer(6);
+    const view = new DataView(buffer);
+    view.setUint8(0, MessageType.NetSim);
+    view.setUint16(1, latencyMs, true);
+    view.setUint16(3, jitterMs, true);
+    view.setUint8(5, loss);
+    return buffer;
+  }
+
+  // Encode the answer to a tournament challenge (protocol v26): the
+  // HMAC-SHA256 of its nonce keyed with the event secret
+  encodeEventAuth(mac: ArrayBuffer): ArrayBuffer {
+    const buffer = new ArrayBuffer(1 + mac.byteLength);
+    const bytes = new Uint8Array(buffer);
+    bytes[0] = MessageType.EventAuth;
+    bytes.set(new Uint8Array(mac), 1);
+    return buffer;
+  }
+
+  // Encode account link request with a link token (protocol v12)
+  encodeLink(token: string): ArrayBuffer {
+    const tokenBytes = new TextEncoder().encode(token);
+    const buffer = new ArrayBuffer(3 + tokenBytes.length);
+    const view = new DataView(buffer);
+    view.setUint8(0, MessageType.Link);
+    view.setUint16(1, tokenBytes.length, true);
+    new Uint8Array(buffer).set(tokenBytes, 3);
+    return buffer;
+  }
+
+  // Encode voice chat signaling for another player (protocol v13)
+  encodeSignal(targetId: number, kind: number, payload: string): ArrayBuffer {
+    const payloadBytes = new TextEncoder().encode(payload);
+    const buffer = new ArrayBuffer(6 + payloadBytes.length);
+    const view = new DataView(buffer);
+    view.setUint8(0, MessageType.Signal);
+    view.setUint16(1, targetId, true);
+    view.setUint8(3, kind);
+    view.setUint16(4, payloadBytes.length, true);
+    new Uint8Array(buffer).set(payloadBytes, 6);
+    return buffer;
+  }
+
+  // Encode a voice chat mute request (protocol v27); the target is ignored
+  // by Clear and List
+  encodeMute(op: number, targetId: number): ArrayBuffer {
+    const buffer = new ArrayBuffer(4);
+    const view = new DataView(buffer);
+    view.setUint8(0, MessageType.Mute);
+    view.setUint8(1, op);
+    view.setUint16(2, targetId, true);
+    return buffer;
+  }
+
+  // Encode a name and color change in the room (protocol v29); an empty
+  // name keeps the current one
+  encodeUpdateProfile(name: string, colorIndex: number): ArrayBuffer {
+    const nameBytes = new TextEncoder().encode(name);
+    const buffer = new ArrayBuffer(3 + nameBytes.length);
+    const view = new DataView(buffer);
+    view.setUint8(0, MessageType.UpdateProfile);
+    view.setUint8(1, nameBytes.length);
+    new Uint8Array(buffer).set(nameBytes, 2);
+    view.setUint8(2 + nameBytes.length, colorIndex);
     return buffer;
   }
 
+  // Encode a request to watch a public room without a car (protocol v32)
+  encodeSpectate(roomId: string): ArrayBuffer {
+    const idBytes = new TextEncoder().encode(roomId);
+    const arr = new Uint8Array(2 + idBytes.length);
+    arr[0] = MessageType.Spectate;
+    arr[1] = idBytes.length;
+    arr.set(idBytes, 2);
+    return arr.buffer;
+  }
+
+  // The signed part of a telemetry sample (protocol v33): the probe's
+  // nonce, our input device class and frame times in tenths of a
+  // millisecond
+  telemetryBody(nonce: Uint8Array, device: number, frames: number[]): Uint8Array {
+    const body = new Uint8Array(nonce.length + 2 + frames.length * 2);
+    const view = new DataView(body.buffer);
+    body.set(nonce, 0);
+    view.setUint8(nonce.length, device);
+    view.setUint8(nonce.length + 1, frames.length);
+    frames.forEach((f, i) => view.setUint16(nonce.length + 2 + i * 2, f, true));
+    return body;
+  }
+
+  // Encode the answer to a telemetry probe (protocol v33): its body and
+  // the HMAC-SHA256 of the body keyed with the telemetry key
+  encodeTelemetry(body: Uint8Array, mac: ArrayBuffer): ArrayBuffer {
+    const arr = new Uint8Array(1 + body.length + mac.byteLength);
+    arr[0] = MessageType.Telemetry;
+    arr.set(body, 1);
+    arr.set(new Uint8Array(mac), 1 + body.length);
+    return arr.buffer;
+  }
+
   // Encode leave message
   encodeLeave(): ArrayBuffer {
     const buffer = new ArrayBuffer(1);
@@ -75,17 +301,24 @@ export class Protocol {
   }
 
   // Decode state update message
-  decodeStateUpdate(data: ArrayBuffer): { tick: number; players: NetworkPlayerData[] } {
+  // Protocol v2 records carry 4 extra bytes of velocity hints, v17 records
+  // the lane after them. Protocol v20 updates end with the receiver's own
+  // extended state
+  decodeStateUpdate(data: ArrayBuffer, version = 1): { tick: number; baseY?: number; players: NetworkPlayerData[]; own?: OwnState } {
     const view = new DataView(data);
 
     const tick = view.getUint16(1, true);
     const playerCount = view.getUint8(3);
+    const recordSize = version >= 17 ? 21 : version >= 2 ? 20 : 16;
+
+    // Protocol v10: road distance of y = 0, which records are relative to
+    const baseY = version >= 10 ? Number(view.getBigInt64(4, true)) : undefined;
 
     const players: NetworkPlayerData[] = [];
-    let offset = 4;
+    let offset = version >= 10 ? 12 : 4;
 
     for (let i = 0; i < playerCount; i++) {
-      players.push({
+      const player: NetworkPlayerData = {
         id: view.getUint16(offset, true),
         x: view.getInt16(offset + 2, true) / 10, // Scaled by 10
         y: view.getInt32(offset + 4, true),
@@ -96,11 +329,34 @@ export class Protocol {
                 (view.getUint8(offset + 13) << 16), // 24-bit
         flags: view.getUint8(offset + 14),
         color: view.getUint8(offset + 15),
-      });
-      offset += 16;
+      };
+      if (recordSize >= 20) {
+        player.velX = view.getInt16(offset + 16, true) / 10; // Scaled by 10
+        player.velY = view.getInt16(offset + 18, true) / 10;
+      }
+      if (recordSize === 21) {
+        player.lane = view.getUint8(offset + 20);
+      }
+      players.push(player);
+      offset += recordSize;
+    }
+
+    // Protocol v20: [size:1] and the fields the size covers, none outside
+    // fuel mode; v35 adds our input ack after the fuel, 0xffff outside fuel
+    // mode
+    let own: OwnState | undefined;
+    if (version >= 20) {
+      const size = view.getUint8(offset);
+      own = {};
+      if (size >= 2 && view.getUint16(offset + 1, true) !== 0xffff) {
+        own.fuel = view.getUint16(offset + 1, true) / 1000; // Thousandths of a full tank
+      }
+      if (size >= 3) {
+        own.ack = view.getUint8(offset + 3);
+      }
     }
 
-    return { tick, players };
+    return { tick, baseY, players, own };
   }
 
   // Decode player join message
@@ -121,8 +377,8 @@ export class Protocol {
     return { id: view.getUint16(1, true) };
   }
 
-  // Decode room info message
-  decodeRoomInfo(data: ArrayBuffer): { roomId: string; playerCount: number; maxPlayers: number; yourId: number } {
+  // Decode room info message; protocol v37 ends it with the room's collisions
+  decodeRoomInfo(data: ArrayBuffer): { roomId: string; playerCount: number; maxPlayers: number; yourId: number; collisions: number } {
     const view = new DataView(data);
     const roomIdLen = view.getUint8(1);
     const roomIdBytes = new Uint8Array(data, 2, roomIdLen);
@@ -134,6 +390,7 @@ export class Protocol {
       playerCount: view.getUint8(offset),
       maxPlayers: view.getUint8(offset + 1),
       yourId: view.getUint16(offset + 2, true),
+      collisions: data.byteLength > offset + 4 ? view.getUint8(offset + 4) : Collisions.On,
     };
   }
 
@@ -145,6 +402,351 @@ export class Protocol {
     return { timestamp, latency };
   }
 
+  // Decode handshake response
+  decodeHelloAck(data: ArrayBuffer): { version: number } {
+    const view = new DataView(data);
+    return { version: view.getUint8(1) };
+  }
+
+  // Decode host change message
+  decodeHostChange(data: ArrayBuffer): { hostId: number } {
+    const view = new DataView(data);
+    return { hostId: view.getUint16(1, true) };
+  }
+
+  // Decode join queue position message
+  decodeQueueStatus(data: ArrayBuffer): { position: number; length: number } {
+    const view = new DataView(data);
+    return {
+      position: view.getUint16(1, true),
+      length: view.getUint16(3, true),
+    };
+  }
+
+  // Decode room tick rates (protocol v4)
+  decodeTickRate(data: ArrayBuffer): { physicsRate: number; broadcastRate: number } {
+    const view = new DataView(data);
+    return {
+      physicsRate: view.getUint8(1),
+      broadcastRate: view.getUint8(2),
+    };
+  }
+
+  // Decode custom track road (protocol v7); v17 appends the lane count,
+  // v18 the banking and elevation curves, v19 the jump ramps
+  decodeTrack(data: ArrayBuffer, version = 7): TrackDefinition {
+    const view = new DataView(data);
+    const curves = this.decodeTrackCurves(view, 3);
+    const track: TrackDefinition = { width: view.getUint16(1, true), curves };
+    let offset = 4 + curves.length * 13;
+    if (version >= 17) {
+      track.lanes = view.getUint8(offset);
+      offset += 1;
+    }
+    if (version >= 18) {
+      track.banking = view.getFloat32(offset, true);
+      track.elevation = this.decodeTrackCurves(view, offset + 4);
+      offset += 5 + track.elevation.length * 13;
+    }
+    if (version >= 19) {
+      const count = view.getUint8(offset);
+      offset += 1;
+      track.ramps = [];
+      for (let i = 0; i < count; i++) {
+        track.ramps.push({
+          offset: view.getFloat32(offset, true),
+          spacing: view.getFloat32(offset + 4, true),
+          length: view.getFloat32(offset + 8, true),
+          height: view.getFloat32(offset + 12, true),
+        });
+        offset += 16;
+      }
+    }
+    // Protocol v21: the room's variant. No curves means the built-in road
+    if (version >= 21) {
+      const variant = view.getUint8(offset);
+      track.mirror = (variant & TrackVariant.Mirror) !== 0;
+      track.night = (variant & TrackVariant.Night) !== 0;
+      offset += 1;
+    }
+    // Protocol v22: how the bends grow down the road
+    if (version >= 22) {
+      track.escalationRate = view.getFloat32(offset, true);
+      track.escalationMax = view.getFloat32(offset + 4, true);
+    }
+    return track;
+  }
+
+  // Decode a count byte at offset and that many track curves after it
+  private decodeTrackCurves(view: DataView, offset: number): TrackCurve[] {
+    const count = view.getUint8(offset);
+    const curves: TrackCurve[] = [];
+    offset += 1;
+    for (let i = 0; i < count; i++) {
+      curves.push({
+        amplitude: view.getFloat32(offset, true),
+        wavelength: view.getFloat32(offset + 4, true),
+        phase: view.getFloat32(offset + 8, true),
+        sharpness: view.getUint8(offset + 12),
+      });
+      offset += 13;
+    }
+    return curves;
+  }
+
+  // Decode round awards (protocol v8)
+  decodeResults(data: ArrayBuffer): { round: number; awards: RoundAward[] } {
+    const view = new DataView(data);
+    const count = view.getUint8(3);
+    const awards: RoundAward[] = [];
+    let offset = 4;
+    for (let i = 0; i < count; i++) {
+      awards.push({
+        kind: view.getUint8(offset),
+        playerId: view.getUint16(offset + 1, true),
+        value: view.getFloat32(offset + 3, true),
+      });
+      offset += 7;
+    }
+    return { round: view.getUint16(1, true), awards };
+  }
+
+  // Decode world rebase (protocol v9): the road starts at origin, and every
+  // position moved back by shift
+  decodeRebase(data: ArrayBuffer): { origin: number; shift: number } {
+    const view = new DataView(data);
+    return {
+      origin: view.getFloat64(1, true),
+      shift: view.getFloat64(9, true),
+    };
+  }
+
+  // Decode recommended interpolation delay in ms (protocol v11)
+  decodeInterpDelay(data: ArrayBuffer): number {
+    return new DataView(data).getUint16(1, true);
+  }
+
+  // Decode account link confirmation: the account's name (protocol v12)
+  decodeLinked(data: ArrayBuffer): string {
+    const nameLen = new DataView(data).getUint8(1);
+    return new TextDecoder().decode(new Uint8Array(data, 2, nameLen));
+  }
+
+  // Decode voice chat signaling relayed from another player (protocol v13)
+  decodeSignalRelay(data: ArrayBuffer): { fromId: number; kind: number; payload: string } {
+    const view = new DataView(data);
+    const payloadLen = view.getUint16(4, true);
+    return {
+      fromId: view.getUint16(1, true),
+      kind: view.getUint8(3),
+      payload: new TextDecoder().decode(new Uint8Array(data, 6, payloadLen)),
+    };
+  }
+
+  // Decode the session token that rejoins the room (protocol v28)
+  decodeSession(data: ArrayBuffer): string {
+    const tokenLen = new DataView(data).getUint16(1, true);
+    return new TextDecoder().decode(new Uint8Array(data, 3, tokenLen));
+  }
+
+  // Decode the join code of the private room we're in (protocol v30)
+  decodeRoomCode(data: ArrayBuffer): string {
+    const codeLen = new DataView(data).getUint8(1);
+    return new TextDecoder().decode(new Uint8Array(data, 2, codeLen));
+  }
+
+  // Decode who may join the private room we're in (protocol v38)
+  decodeRoomSettings(data: ArrayBuffer): RoomSettings {
+    const flags = new DataView(data).getUint8(1);
+    return {
+      closed: (flags & RoomSettingsFlags.Closed) !== 0,
+      password: (flags & RoomSettingsFlags.Password) !== 0,
+    };
+  }
+
+  // Decode the retrieval ID of a highlight clip we asked for (protocol v36)
+  decodeClipSaved(data: ArrayBuffer): string {
+    const idLen = new DataView(data).getUint8(1);
+    return new TextDecoder().decode(new Uint8Array(data, 2, idLen));
+  }
+
+  // Decode our relay team (protocol v31)
+  decodeRelay(data: ArrayBuffer): RelayTeam {
+    const view = new DataView(data);
+    const count = view.getUint8(18);
+    const members: number[] = [];
+    for (let i = 0; i < count; i++) {
+      members.push(view.getUint16(19 + i * 2, true));
+    }
+    return {
+      team: view.getUint8(1),
+      active: view.getUint16(2, true),
+      legs: view.getUint16(4, true),
+      leg: view.getFloat32(6, true),
+      legLength: view.getFloat32(10, true),
+      distance: view.getFloat32(14, true),
+      members,
+    };
+  }
+
+  // Decode the announcement of a race (protocol v34)
+  decodeRaceStart(data: ArrayBuffer): RaceStart {
+    const view = new DataView(data);
+    return {
+      race: view.getUint16(1, true),
+      laps: view.getUint8(3),
+      checkpoints: view.getUint8(4),
+      lapLength: view.getFloat32(5, true),
+      countdownMs: view.getUint16(9, true),
+      entered: view.getUint8(11) !== 0,
+    };
+  }
+
+  // Decode a racer passing a checkpoint (protocol v34)
+  decodeCheckpointPassed(data: ArrayBuffer): RaceStanding {
+    return this.raceStanding(new DataView(data), 1);
+  }
+
+  // Decode a racer finishing (protocol v34)
+  decodeRaceFinished(data: ArrayBuffer): { playerId: number; place: number; timeMs: number } {
+    const view = new DataView(data);
+    return { playerId: view.getUint16(1, true), place: view.getUint8(3), timeMs: view.getUint32(4, true) };
+  }
+
+  // Decode the standings of a race, leader first (protocol v34)
+  decodeLeaderboard(data: ArrayBuffer): { race: number; final: boolean; standings: RaceStanding[] } {
+    const view = new DataView(data);
+    const count = view.getUint8(4);
+    const standings: RaceStanding[] = [];
+    for (let i = 0; i < count; i++) {
+      standings.push(this.raceStanding(view, 5 + i * 8));
+    }
+    return { race: view.getUint16(1, true), final: view.getUint8(3) !== 0, standings };
+  }
+
+  // A racer's standing at offset: [id:2][lap:1][checkpoint:1][timeMs:4]
+  private raceStanding(view: DataView, offset: number): RaceStanding {
+    return {
+      playerId: view.getUint16(offset, true),
+      lap: view.getUint8(offset + 2),
+      checkpoint: view.getUint8(offset + 3),
+      timeMs: view.getUint32(offset + 4, true),
+    };
+  }
+
+  // Decode the names of the players muted for voice chat (protocol v27)
+  decodeMutes(data: ArrayBuffer): string[] {
+    const view = new DataView(data);
+    const count = view.getUint8(1);
+    const names: string[] = [];
+    let offset = 2;
+    for (let i = 0; i < count; i++) {
+      const len = view.getUint8(offset);
+      names.push(new TextDecoder().decode(new Uint8Array(data, offset + 1, len)));
+      offset += 1 + len;
+    }
+    return names;
+  }
+
+  // Decode the IDs of the cars near the local one (protocol v14)
+  decodeNearby(data: ArrayBuffer): number[] {
+    const view = new DataView(data);
+    const count = view.getUint8(1);
+    const ids: number[] = [];
+    for (let i = 0; i < count; i++) {
+      ids.push(view.getUint16(2 + i * 2, true));
+    }
+    return ids;
+  }
+
+  // Decode the cosmetics shown on a player's car (protocol v15)
+  decodeAppearance(data: ArrayBuffer): { id: number } & Cosmetics {
+    const view = new DataView(data);
+    return {
+      id: view.getUint16(1, true),
+      trail: view.getUint8(3),
+      decal: view.getUint8(4),
+    };
+  }
+
+  // Decode the broadcast director's shot (protocol v16, spectators only)
+  decodeDirector(data: ArrayBuffer): DirectorShot {
+    const view = new DataView(data);
+    return {
+      target: view.getUint16(1, true),
+      second: view.getUint16(3, true),
+      reason: view.getUint8(5),
+    };
+  }
+
+  // Decode endless-mode milestone announcement (protocol v23)
+  decodeMilestone(data: ArrayBuffer): Milestone {
+    const view = new DataView(data);
+    return {
+      playerId: view.getUint16(1, true),
+      kind: view.getUint8(3),
+      count: view.getUint16(4, true),
+      value: view.getFloat32(6, true),
+    };
+  }
+
+  // Decode collision event with its impact (protocol v24)
+  decodeCollision(data: ArrayBuffer): CollisionEvent {
+    const view = new DataView(data);
+    return {
+      a: view.getUint16(1, true),
+      b: view.getUint16(3, true),
+      impact: view.getFloat32(5, true),
+    };
+  }
+
+  // Decode telemetry probe (protocol v33): its 16-byte nonce and the
+  // number of frames wanted
+  decodeProbe(data: ArrayBuffer): { nonce: Uint8Array; frames: number } {
+    return { nonce: new Uint8Array(data.slice(1, 17)), frames: new DataView(data).getUint8(17) };
+  }
+
+  // Decode tournament challenge (protocol v26): its 16-byte nonce
+  decodeChallenge(data: ArrayBuffer): Uint8Array {
+    return new Uint8Array(data.slice(1, 17));
+  }
+
+  // Decode tutorial prompt (protocol v6); step === steps when finished
+  decodeTutorial(data: ArrayBuffer): { step: number; steps: number; text: string } {
+    const view = new DataView(data);
+    const textLen = view.getUint8(3);
+    return {
+      step: view.getUint8(1),
+      steps: view.getUint8(2),
+      text: new TextDecoder().decode(new Uint8Array(data, 4, textLen)),
+    };
+  }
+
+  // Decode latency scoreboard message
+  decodeScoreboard(data: ArrayBuffer): { id: number; rttMs: number }[] {
+    const view = new DataView(data);
+    const count = view.getUint8(1);
+    const entries: { id: number; rttMs: number }[] = [];
+    let offset = 2;
+    for (let i = 0; i < count; i++) {
+      entries.push({
+        id: view.getUint16(offset, true),
+        rttMs: view.getUint16(offset + 2, true),
+      });
+      offset += 4;
+    }
+    return entries;
+  }
+
+  // Decode rejoin cooldown message
+  decodeCooldown(data: ArrayBuffer): { remainingMs: number; offenses: number } {
+    const view = new DataView(data);
+    return {
+      remainingMs: view.getUint32(1, true),
+      offenses: view.getUint8(5),
+    };
+  }
+
   // Decode error message
   decodeError(data: ArrayBuffer): { code: number; message: string } {
     const view = new DataView(data);
@@ -160,6 +762,16 @@ export class Protocol {
     return (flags & PlayerFlags.Exploded) !== 0;
   }
 
+  // Check if player is a ghost (collisions off)
+  isGhost(flags: number): boolean {
+    return (flags & PlayerFlags.Ghost) !== 0;
+  }
+
+  // Check if player is in the air off a jump ramp
+  isAirborne(flags: number): boolean {
+    return (flags & PlayerFlags.Airborne) !== 0;
+  }
+
   // Get color hex from index
   getColorHex(colorIndex: number): string {
     return ColorPalette[colorIndex % ColorPalette.length];
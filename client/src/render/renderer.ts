@@ -1,5 +1,6 @@
-import { CONFIG, getRoadCurve } from '@/config';
+import { CONFIG, getRoadCurve, getRoadGrade, getRoadLanes, getRoadRampRise, getRoadWidth, inPit, isNight } from '@/config';
 import { GameStateManager } from '@/game/state';
+import type { Cosmetics } from '@/types';
 
 export class Renderer {
   private canvas: HTMLCanvasElement;
@@ -42,23 +43,42 @@ export class Renderer {
     this.stateManager.remotePlayers.forEach((remote) => {
       const screen = project(remote.currentX, remote.currentY);
       if (screen.y > -50 && screen.y < this.canvas.height + 50) {
-        this.drawCar(screen.x, screen.y, remote.angle, remote.color, false, remote.name);
+        const look = this.stateManager.appearance(remote.id);
+        this.drawCar(screen.x, screen.y, remote.angle, remote.color, false, remote.ghost, remote.airborne, remote.name, look);
       }
     });
 
     // Draw local player
     const localScreen = project(localPlayer.x, localPlayer.y);
-    this.drawCar(localScreen.x, localScreen.y, localPlayer.angle, localPlayer.color, true);
+    const localLook = this.stateManager.appearance(localPlayer.id);
+    this.drawCar(localScreen.x, localScreen.y, localPlayer.angle, localPlayer.color, true, localPlayer.ghost, localPlayer.airborne, undefined, localLook);
 
     // Draw particles
     this.drawParticles(camX, camY);
 
+    // Night variant: dark beyond the local car's headlights
+    if (isNight()) {
+      this.drawNight(localScreen.x, localScreen.y);
+    }
+
     // Draw mouse cursor in mouse mode
     if (this.stateManager.controlMode === 'mouse') {
       this.drawMouseCursor();
     }
   }
 
+  // Darken everything but a pool of light reaching ahead of the car at
+  // (x, y)
+  private drawNight(x: number, y: number): void {
+    const reach = CONFIG.NIGHT_VISIBILITY;
+    const cy = y - reach * 0.4;
+    const light = this.ctx.createRadialGradient(x, cy, reach * 0.2, x, cy, reach);
+    light.addColorStop(0, 'rgba(0, 0, 0, 0)');
+    light.addColorStop(1, 'rgba(0, 0, 0, 0.92)');
+    this.ctx.fillStyle = light;
+    this.ctx.fillRect(0, 0, this.canvas.width, this.canvas.height);
+  }
+
   // Draw the road
   private drawRoad(camY: number): void {
     const totalHeight = this.canvas.height;
@@ -76,6 +96,8 @@ export class Renderer {
     this.ctx.fillStyle = '#064e3b';
     this.ctx.fillRect(0, 0, this.canvas.width, this.canvas.height);
 
+    const roadWidth = getRoadWidth();
+    const lanes = getRoadLanes();
     for (let y = startY; y < startY + drawDistance; y += segmentHeight) {
       const relY = y - useCamY;
       const screenY = this.canvas.height * CONFIG.CAMERA_Y_OFFSET - relY;
@@ -91,33 +113,75 @@ export class Renderer {
 
       // Road edge (curb)
       this.ctx.fillStyle = isDark ? '#b91c1c' : '#f3f4f6';
-      this.ctx.fillRect(drawX - CONFIG.ROAD_WIDTH / 2 - 25, drawY - segmentHeight, CONFIG.ROAD_WIDTH + 50, segmentHeight + 1);
+      this.ctx.fillRect(drawX - roadWidth / 2 - 25, drawY - segmentHeight, roadWidth + 50, segmentHeight + 1);
 
-      // Road surface
+      // Road surface, lit uphill and shaded downhill
       this.ctx.fillStyle = isDark ? '#1f2937' : '#374151';
-      this.ctx.fillRect(drawX - CONFIG.ROAD_WIDTH / 2, drawY - segmentHeight, CONFIG.ROAD_WIDTH, segmentHeight + 1);
+      this.ctx.fillRect(drawX - roadWidth / 2, drawY - segmentHeight, roadWidth, segmentHeight + 1);
+      const grade = getRoadGrade(y);
+      if (grade !== 0) {
+        const shade = Math.min(0.35, Math.abs(grade) * 1.5);
+        this.ctx.fillStyle = grade > 0 ? `rgba(255, 255, 255, ${shade})` : `rgba(0, 0, 0, ${shade})`;
+        this.ctx.fillRect(drawX - roadWidth / 2, drawY - segmentHeight, roadWidth, segmentHeight + 1);
+      }
 
-      // Center line
+      // Jump ramps, brighter toward the lip
+      const rise = getRoadRampRise(y);
+      if (rise > 0) {
+        this.ctx.fillStyle = `rgba(251, 146, 60, ${0.2 + rise * 0.5})`;
+        this.ctx.fillRect(drawX - roadWidth / 2, drawY - segmentHeight, roadWidth, segmentHeight + 1);
+      }
+
+      // Pit zones, in fuel mode
+      if (localPlayer.fuel !== undefined && inPit(y)) {
+        this.ctx.fillStyle = 'rgba(56, 189, 248, 0.25)';
+        this.ctx.fillRect(drawX - roadWidth / 2, drawY - segmentHeight, roadWidth, segmentHeight + 1);
+      }
+
+      // Center line and lane lines
       if (segmentIndex % 4 < 2) {
         this.ctx.fillStyle = '#fbbf24';
         this.ctx.fillRect(drawX - 2, drawY - segmentHeight, 4, segmentHeight + 1);
+        this.ctx.fillStyle = 'rgba(243, 244, 246, 0.5)';
+        for (let i = 1; i < lanes; i++) {
+          const laneX = Math.round(drawX - roadWidth / 2 + (i * roadWidth) / lanes);
+          if (Math.abs(laneX - drawX) > 2) {
+            this.ctx.fillRect(laneX - 1, drawY - segmentHeight, 2, segmentHeight + 1);
+          }
+        }
       }
     }
   }
 
   // Draw a car
-  private drawCar(x: number, y: number, angle: number, color: string, isLocal: boolean, name?: string): void {
+  private drawCar(
+    x: number,
+    y: number,
+    angle: number,
+    color: string,
+    isLocal: boolean,
+    ghost: boolean,
+    airborne: boolean,
+    name?: string,
+    look?: Cosmetics
+  ): void {
 
     if (isLocal && this.stateManager.localPlayer.exploded) return;
 
     this.ctx.save();
+    if (ghost) this.ctx.globalAlpha = CONFIG.GHOST_ALPHA;
     this.ctx.translate(x, y);
     this.ctx.rotate((angle * Math.PI) / 180);
+    if (airborne) this.ctx.scale(1.15, 1.15); // Closer to the camera
+
+    // Trail behind the car
+    if (look?.trail) this.drawTrail(look.trail);
 
-    // Shadow
+    // Shadow, further off in the air
+    const shadowOffset = airborne ? 14 : 4;
     this.ctx.fillStyle = 'rgba(0,0,0,0.5)';
     this.ctx.beginPath();
-    this.ctx.roundRect(-CONFIG.CAR_WIDTH / 2 + 4, -CONFIG.CAR_HEIGHT / 2 + 4, CONFIG.CAR_WIDTH, CONFIG.CAR_HEIGHT, 4);
+    this.ctx.roundRect(-CONFIG.CAR_WIDTH / 2 + shadowOffset, -CONFIG.CAR_HEIGHT / 2 + shadowOffset, CONFIG.CAR_WIDTH, CONFIG.CAR_HEIGHT, 4);
     this.ctx.fill();
 
     // Body
@@ -130,6 +194,9 @@ export class Renderer {
     this.ctx.fillStyle = 'rgba(0,0,0,0.2)';
     this.ctx.fillRect(-4, -CONFIG.CAR_HEIGHT / 2, 8, CONFIG.CAR_HEIGHT);
 
+    // Decal on the hood and roof
+    if (look?.decal) this.drawDecal(look.decal);
+
     // Windshield
     this.ctx.fillStyle = '#111827';
     this.ctx.beginPath();
@@ -171,6 +238,80 @@ export class Renderer {
     }
   }
 
+  // Draw a trail fading out behind the car (car coordinates, nose up)
+  private drawTrail(trail: number): void {
+    const color = CONFIG.TRAIL_COLORS[trail];
+    if (!color) return; // Newer than this client
+    const top = CONFIG.CAR_HEIGHT / 2;
+    const gradient = this.ctx.createLinearGradient(0, top, 0, top + CONFIG.TRAIL_LENGTH);
+    if (color === 'rainbow') {
+      ['#ef4444', '#f59e0b', '#22c55e', '#3b82f6', '#a855f7'].forEach((c, i) => gradient.addColorStop(i / 5, c));
+    } else {
+      gradient.addColorStop(0, color);
+    }
+    gradient.addColorStop(1, 'rgba(0,0,0,0)');
+    this.ctx.fillStyle = gradient;
+    this.ctx.beginPath();
+    this.ctx.moveTo(-CONFIG.CAR_WIDTH / 2 + 3, top);
+    this.ctx.lineTo(CONFIG.CAR_WIDTH / 2 - 3, top);
+    this.ctx.lineTo(0, top + CONFIG.TRAIL_LENGTH);
+    this.ctx.closePath();
+    this.ctx.fill();
+  }
+
+  // Draw a decal on the car body (car coordinates, nose up)
+  private drawDecal(decal: number): void {
+    const w = CONFIG.CAR_WIDTH;
+    const h = CONFIG.CAR_HEIGHT;
+    this.ctx.fillStyle = 'rgba(255,255,255,0.7)';
+    switch (decal) {
+      case 1: // Stripes
+        this.ctx.fillRect(-w / 2 + 2, -h / 2, 2, h);
+        this.ctx.fillRect(w / 2 - 4, -h / 2, 2, h);
+        break;
+      case 2: // Checker
+        for (let row = 0; row < 2; row++) {
+          for (let col = 0; col < 4; col++) {
+            if ((row + col) % 2 === 0) this.ctx.fillRect(-w / 2 + col * (w / 4), h / 2 - 10 + row * 4, w / 4, 4);
+          }
+        }
+        break;
+      case 3: // Flames
+        this.ctx.fillStyle = '#f97316';
+        this.ctx.beginPath();
+        for (let i = 0; i < 3; i++) {
+          const fx = -w / 2 + 2 + i * ((w - 4) / 3);
+          this.ctx.moveTo(fx, -h / 2 + 2);
+          this.ctx.lineTo(fx + (w - 4) / 6, -h / 2 + 12);
+          this.ctx.lineTo(fx + (w - 4) / 3, -h / 2 + 2);
+        }
+        this.ctx.fill();
+        break;
+      case 4: // Lightning
+        this.ctx.fillStyle = '#facc15';
+        this.ctx.beginPath();
+        this.ctx.moveTo(2, h / 2 - 14);
+        this.ctx.lineTo(-4, h / 2 - 6);
+        this.ctx.lineTo(0, h / 2 - 6);
+        this.ctx.lineTo(-2, h / 2);
+        this.ctx.lineTo(4, h / 2 - 8);
+        this.ctx.lineTo(0, h / 2 - 8);
+        this.ctx.closePath();
+        this.ctx.fill();
+        break;
+      case 5: // Star
+        this.ctx.beginPath();
+        for (let i = 0; i < 10; i++) {
+          const r = i % 2 === 0 ? 5 : 2;
+          const a = (i * Math.PI) / 5 - Math.PI / 2;
+          this.ctx.lineTo(Math.cos(a) * r, h / 2 - 8 + Math.sin(a) * r);
+        }
+        this.ctx.closePath();
+        this.ctx.fill();
+        break;
+    }
+  }
+
   // Check if player is braking
   private isBraking(): boolean {
     const { keys, mouse, controlMode } = this.stateManager.gameState;
@@ -9,10 +9,17 @@ export interface PlayerState {
   angle: number;
   rating: number;
   exploded: boolean;
+  ghost: boolean; // Collisions off (server-controlled), drawn translucent
+  airborne: boolean; // In the air off a jump ramp (protocol v19)
 }
 
 export interface LocalPlayer extends PlayerState {
   lastSync: number;
+  height: number; // Above the road, predicted: airborne while > 0
+  velZ: number; // Vertical velocity, units per second
+  fuel?: number; // Fuel mode only (protocol v20): left in the tank, predicted
+  parked?: boolean; // Relay mode (protocol v31): a teammate has the baton; race mode (v34): on the grid
+  inputAck?: number; // Protocol v35: sequence number of our last input the server's state reflects
 }
 
 export interface RemotePlayer extends PlayerState {
@@ -21,6 +28,18 @@ export interface RemotePlayer extends PlayerState {
   currentX: number;
   currentY: number;
   lastPacketTime: number;
+  velX?: number; // Velocity hints (protocol v2), units per second
+  velY?: number;
+  packets: RemotePacket[]; // Recent positions, oldest first, for delayed rendering
+}
+
+// A remote player's position as received in a state update
+export interface RemotePacket {
+  time: number; // Date.now() at arrival
+  x: number;
+  y: number;
+  velX?: number;
+  velY?: number;
 }
 
 // Input types
@@ -66,6 +85,12 @@ export interface GameState {
   particles: Particle[];
   camera: Camera;
   connected: boolean;
+  latencies: Map<number, number>; // Player ID -> server-measured RTT (ms)
+  hostId: number; // Room host (0 in public rooms)
+  interpDelayMs: number; // Render remote cars this far behind (server-recommended, protocol v11)
+  nearby: Set<number>; // Cars within the server's proximity radius (protocol v14)
+  appearances: Map<number, Cosmetics>; // Player ID -> cosmetics shown on the car (protocol v15)
+  collisions: number; // How the room's cars collide (Collisions, protocol v37)
 }
 
 // Network message types
@@ -75,6 +100,23 @@ export enum MessageType {
   JoinRoom = 0x02,
   LeaveRoom = 0x03,
   Ping = 0x04,
+  Hello = 0x05,
+  HostKick = 0x06,
+  Reset = 0x07,
+  Link = 0x08,
+  Signal = 0x09,
+  Prestige = 0x0a,
+  NetSim = 0x0b,
+  EventAuth = 0x0c,
+  Mute = 0x0d,
+  UpdateProfile = 0x0e,
+  CreateRoom = 0x0f,
+  JoinByCode = 0x40, // Client types go on after the server's 0x10-0x3f
+  Spectate = 0x41,
+  Telemetry = 0x42,
+  Clip = 0x43,
+  HostStartRace = 0x44, // Host commands of private rooms (protocol v38)
+  HostSettings = 0x45,
 
   // Server -> Client
   StateUpdate = 0x10,
@@ -83,6 +125,36 @@ export enum MessageType {
   PlayerDeath = 0x13,
   RoomInfo = 0x14,
   Pong = 0x15,
+  Cooldown = 0x16,
+  HelloAck = 0x17,
+  Scoreboard = 0x18,
+  HostChange = 0x19,
+  QueueStatus = 0x1a,
+  TickRate = 0x1b,
+  Tutorial = 0x1c,
+  Track = 0x1d,
+  Results = 0x1e,
+  Rebase = 0x1f,
+  InterpDelay = 0x20,
+  Linked = 0x21,
+  SignalRelay = 0x22,
+  Nearby = 0x23,
+  Appearance = 0x24,
+  Director = 0x25,
+  Milestone = 0x26,
+  Collision = 0x27,
+  Challenge = 0x28,
+  Mutes = 0x29,
+  Session = 0x2a,
+  RoomCode = 0x2b,
+  Relay = 0x2c,
+  Probe = 0x2d,
+  RaceStart = 0x2e,
+  CheckpointPassed = 0x2f,
+  RaceFinished = 0x30,
+  Leaderboard = 0x31,
+  ClipSaved = 0x32,
+  RoomSettings = 0x33,
   Error = 0xff,
 }
 
@@ -96,6 +168,15 @@ export interface NetworkPlayerData {
   rating: number;
   flags: number;
   color: number;
+  velX?: number; // Protocol v2 only
+  velY?: number;
+  lane?: number; // Protocol v17 only: 1 at the left edge, 0 off the road
+}
+
+// The receiver's own extended state, at the end of state updates (protocol v20)
+export interface OwnState {
+  fuel?: number; // Fuel mode only: share of a full tank, 0 to 1
+  ack?: number; // Protocol v35: sequence number of our last input the update reflects
 }
 
 // Key flags for binary protocol
@@ -106,10 +187,198 @@ export const KeyFlags = {
   Right: 1 << 3,
 } as const;
 
+// Join options (protocol v5)
+export const JoinOptions = {
+  Solo: 1 << 0, // Private practice room
+  Replay: 1 << 1, // Ghost car replays the best attempt
+  Tutorial: 1 << 2, // Private tutorial room (protocol v6)
+  Track: 1 << 3, // Practice room on a custom track (protocol v7)
+  Cosmetics: 1 << 4, // Trail and decal picks follow (protocol v15)
+} as const;
+
+// Private room settings bits of the HostSettings and RoomSettings messages
+// (protocol v38)
+export const RoomSettingsFlags = {
+  Closed: 1 << 0, // No one new may join
+  Password: 1 << 1, // Joining takes a password (RoomSettings only)
+} as const;
+
+// Who may join the private room we're in (protocol v38)
+export interface RoomSettings {
+  closed: boolean;
+  password: boolean;
+}
+
+// Cosmetics picked for the car, by wire ID (0 = none), see GET /api/cosmetics
+export interface Cosmetics {
+  trail: number;
+  decal: number;
+}
+
+// Custom track selected for a practice room (version 0: the latest)
+export interface TrackRef {
+  id: string;
+  version: number;
+}
+
+// Private room to open (no code) or to join by its code (protocol v30);
+// password "" for none
+export interface PrivateRoomRef {
+  code?: string;
+  password: string;
+}
+
+// Custom track road (protocol v7 Track message), see the server's track package
+export interface TrackCurve {
+  amplitude: number;
+  wavelength: number;
+  phase: number;
+  sharpness: number; // Odd exponent
+}
+
+export interface TrackDefinition {
+  width: number;
+  lanes?: number; // Protocol v17 only
+  curves: TrackCurve[];
+  banking?: number; // Protocol v18 only: 0..1
+  elevation?: TrackCurve[]; // Protocol v18 only: height profile
+  ramps?: TrackRamp[]; // Protocol v19 only
+  mirror?: boolean; // Protocol v21 only: bends flipped left to right
+  night?: boolean; // Protocol v21 only: draw less of the road ahead
+  escalationRate?: number; // Protocol v22 only: growth of the bends per unit of road
+  escalationMax?: number; // Protocol v22 only: cap of that growth
+}
+
+// Jump ramp across the road, repeating every spacing units from offset
+export interface TrackRamp {
+  offset: number; // Road distance of the first ramp's foot
+  spacing: number;
+  length: number;
+  height: number;
+}
+
+// Award kinds of the Results message (protocol v8)
+export const AwardKind = {
+  MVP: 0, // Most rating earned in the round
+  Overtakes: 1, // Most overtakes
+  Cleanest: 2, // Fewest contacts and crashes per 1000 units
+  Survival: 3, // Longest run without a crash, seconds
+  FastestSector: 4, // Fastest sector, seconds
+} as const;
+
+// Voice chat signal kinds (protocol v13)
+export const SignalKind = {
+  Offer: 0, // SDP offer
+  Answer: 1, // SDP answer
+  Candidate: 2, // ICE candidate
+  Hangup: 3, // Peer connection closed (empty payload)
+} as const;
+
+// Voice chat mute operations (protocol v27 Mute message)
+export const MuteOp = {
+  Mute: 0, // Mute the target
+  Unmute: 1, // Unmute the target
+  Clear: 2, // Unmute everyone
+  List: 3, // Change nothing, only send the list
+} as const;
+
+// Why the broadcast director picked a shot (protocol v16 Director message)
+export const DirectorReason = {
+  Leader: 0, // Following the leader of the standings
+  LeadChange: 1, // Target just took the lead from second
+  Collision: 2, // Target and second are about to collide
+  Battle: 3, // Target and second fight for the lead at the end of the round
+  Crash: 4, // Target, near the top of the standings, exploded
+} as const;
+
+// Car the broadcast camera should follow (0: none) and the other car of the shot
+export interface DirectorShot {
+  target: number;
+  second: number;
+  reason: number;
+}
+
+// How a room's cars collide (protocol v37 RoomInfo)
+export const Collisions = {
+  On: 0, // Cars push each other
+  Soft: 1, // Weaker pushes
+  Off: 2, // Cars drive through each other
+} as const;
+
+// Endless-mode announcement kinds (protocol v23 Milestone message)
+export const MilestoneKind = {
+  Distance: 0, // The run reached a milestone: value is the rating bonus
+  Prestige: 1, // The run was reset for prestige: value is the banked score
+} as const;
+
+// Our relay team (protocol v31 Relay message): members in driving order,
+// the one with the baton, and distances in world units
+export interface RelayTeam {
+  team: number;
+  active: number;
+  legs: number;
+  leg: number;
+  legLength: number;
+  distance: number;
+  members: number[];
+}
+
+// A race of a race room (protocol v34 RaceStart message): sent at the
+// countdown (countdownMs > 0) and at the start (0); entered if we race
+export interface RaceStart {
+  race: number;
+  laps: number;
+  checkpoints: number;
+  lapLength: number;
+  countdownMs: number;
+  entered: boolean;
+}
+
+// A racer's last checkpoint passed (protocol v34 CheckpointPassed and
+// Leaderboard messages): lap and checkpoint count from 1 (0: none yet),
+// timeMs is race time, the finish's once finished
+export interface RaceStanding {
+  playerId: number;
+  lap: number;
+  checkpoint: number;
+  timeMs: number;
+}
+
+// Endless-mode announcement: count is the milestone's number in the run,
+// or the session's number of prestige resets
+export interface Milestone {
+  playerId: number;
+  kind: number;
+  count: number;
+  value: number;
+}
+
+// Two cars hit each other (protocol v24 Collision message); impact is their
+// relative speed at contact, units/s
+export interface CollisionEvent {
+  a: number;
+  b: number;
+  impact: number;
+}
+
+export interface RoundAward {
+  kind: number;
+  playerId: number;
+  value: number;
+}
+
 // Player flags
 export const PlayerFlags = {
   Exploded: 1 << 0,
   Respawning: 1 << 1,
+  Ghost: 1 << 2, // Collisions off (ramming penalty)
+  Airborne: 1 << 3, // In the air off a jump ramp
+} as const;
+
+// Track variant bits of the Track message (protocol v21)
+export const TrackVariant = {
+  Mirror: 1 << 0,
+  Night: 1 << 1,
 } as const;
 
 // Color palette (matches server)
@@ -137,4 +406,5 @@ export interface LeaderboardEntry {
   name: string;
   rating: number;
   isLocal: boolean;
+  rtt: number; // 0 if not measured yet
 }
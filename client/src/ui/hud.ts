@@ -1,6 +1,7 @@
+import { CONFIG } from '@/config';
 import { GameStateManager } from '@/game/state';
-import { ControlMode } from '@/types';
-import { LANG, getControlModeName } from '@/lang';
+import { ControlMode, Milestone, RoundAward } from '@/types';
+import { LANG, getAwardText, getControlModeName, getMilestoneText } from '@/lang';
 
 export class HUD {
   private stateManager: GameStateManager;
@@ -9,9 +10,17 @@ export class HUD {
   private statusText: HTMLElement;
   private ratingDisplay: HTMLElement;
   private speedDisplay: HTMLElement;
+  private fuelDisplay: HTMLElement;
   private controlModeDisplay: HTMLElement;
   private turnIndicator: HTMLElement;
   private turnDirection: HTMLElement;
+  private tutorialPrompt: HTMLElement;
+  private tutorialStep: HTMLElement;
+  private tutorialText: HTMLElement;
+  private roundResults: HTMLElement;
+  private roundResultsTimer: number | null = null;
+  private milestoneToast: HTMLElement;
+  private milestoneTimer: number | null = null;
 
   constructor(stateManager: GameStateManager) {
     this.stateManager = stateManager;
@@ -20,9 +29,15 @@ export class HUD {
     this.statusText = document.getElementById('status-txt')!;
     this.ratingDisplay = document.getElementById('rating-display')!;
     this.speedDisplay = document.getElementById('speed-display')!;
+    this.fuelDisplay = document.getElementById('fuel-display')!;
     this.controlModeDisplay = document.getElementById('control-mode-display')!;
     this.turnIndicator = document.getElementById('turn-indicator')!;
     this.turnDirection = document.getElementById('turn-direction')!;
+    this.tutorialPrompt = document.getElementById('tutorial-prompt')!;
+    this.tutorialStep = document.getElementById('tutorial-step')!;
+    this.tutorialText = document.getElementById('tutorial-text')!;
+    this.roundResults = document.getElementById('round-results')!;
+    this.milestoneToast = document.getElementById('milestone-toast')!;
   }
 
   // Update HUD display
@@ -40,6 +55,16 @@ export class HUD {
       this.speedDisplay.classList.remove('speed-fast');
     }
 
+    // Update fuel (fuel mode only)
+    if (localPlayer.fuel !== undefined) {
+      const percent = Math.ceil(localPlayer.fuel / CONFIG.FUEL_CAPACITY * 100);
+      this.fuelDisplay.textContent = `${LANG.fuel}: ${percent}%`;
+      this.fuelDisplay.classList.toggle('fuel-low', percent <= 20);
+      this.fuelDisplay.classList.remove('hidden');
+    } else {
+      this.fuelDisplay.classList.add('hidden');
+    }
+
     // Update rating
     this.ratingDisplay.textContent = Math.floor(localPlayer.rating).toLocaleString();
   }
@@ -64,4 +89,52 @@ export class HUD {
       this.turnIndicator.classList.add('hidden');
     }
   }
+
+  // Show a tutorial prompt from the server (step === steps when finished)
+  setTutorial(step: number, steps: number, text: string): void {
+    this.tutorialPrompt.classList.remove('hidden');
+    this.tutorialStep.textContent = step < steps ? LANG.tutorialStep(step + 1, steps) : LANG.tutorialDone;
+    this.tutorialText.textContent = text;
+  }
+
+  // Show the awards of a finished round for a few seconds
+  showResults(round: number, awards: RoundAward[], nameOf: (id: number) => string | undefined): void {
+    const title = document.createElement('div');
+    title.className = 'round-results-title';
+    title.textContent = LANG.roundResults(round);
+    const lines = awards.map((award) => {
+      const line = document.createElement('div');
+      line.textContent = `${getAwardText(award)} — ${nameOf(award.playerId) ?? '?'}`;
+      return line;
+    });
+    this.roundResults.replaceChildren(title, ...lines);
+    this.roundResults.classList.remove('hidden');
+
+    if (this.roundResultsTimer !== null) {
+      clearTimeout(this.roundResultsTimer);
+    }
+    this.roundResultsTimer = window.setTimeout(() => {
+      this.roundResults.classList.add('hidden');
+      this.roundResultsTimer = null;
+    }, 10000);
+  }
+
+  // Announce an endless-mode milestone for a few seconds
+  showMilestone(milestone: Milestone, name: string): void {
+    this.milestoneToast.textContent = getMilestoneText(milestone, name);
+    this.milestoneToast.classList.remove('hidden');
+
+    if (this.milestoneTimer !== null) {
+      clearTimeout(this.milestoneTimer);
+    }
+    this.milestoneTimer = window.setTimeout(() => {
+      this.milestoneToast.classList.add('hidden');
+      this.milestoneTimer = null;
+    }, 4000);
+  }
+
+  // Hide the tutorial prompt
+  hideTutorial(): void {
+    this.tutorialPrompt.classList.add('hidden');
+  }
 }
@@ -13,7 +13,7 @@ export class Leaderboard {
 
   // Update leaderboard display
   update(): void {
-    const { localPlayer, remotePlayers } = this.stateManager.gameState;
+    const { localPlayer, remotePlayers, latencies } = this.stateManager.gameState;
 
     // Build player list
     const players: LeaderboardEntry[] = [];
@@ -24,6 +24,7 @@ export class Leaderboard {
         name: localPlayer.name,
         rating: localPlayer.rating,
         isLocal: true,
+        rtt: latencies.get(localPlayer.id) ?? 0,
       });
     }
 
@@ -33,6 +34,7 @@ export class Leaderboard {
         name: p.name,
         rating: p.rating,
         isLocal: false,
+        rtt: latencies.get(p.id) ?? 0,
       });
     });
 
@@ -54,6 +56,7 @@ export class Leaderboard {
           <div class="player-info">
             <span class="rank">${i + 1}</span>
             <span class="name">${this.escapeHtml(p.name)}</span>
+            ${this.formatRtt(p.rtt)}
           </div>
           <span class="rating">${Math.floor(p.rating).toLocaleString()}</span>
         </li>
@@ -70,6 +73,7 @@ export class Leaderboard {
           <div class="player-info">
             <span class="rank">${myRank + 1}</span>
             <span class="name">${this.escapeHtml(me.name)}</span>
+            ${this.formatRtt(me.rtt)}
           </div>
           <span class="rating">${Math.floor(me.rating).toLocaleString()}</span>
         </li>
@@ -79,6 +83,11 @@ export class Leaderboard {
     this.element.innerHTML = html || `<li class="placeholder">${LANG.noPlayers}</li>`;
   }
 
+  // Format a player's RTT badge (empty until measured)
+  private formatRtt(rtt: number): string {
+    return rtt > 0 ? `<span class="rtt">${rtt}${LANG.msUnit}</span>` : '';
+  }
+
   // Escape HTML to prevent XSS
   private escapeHtml(text: string): string {
     const div = document.createElement('div');
@@ -1,4 +1,4 @@
-import { ColorPalette } from '@/types';
+import { ColorPalette, JoinOptions, TrackRef } from '@/types';
 import { LANG } from '@/lang';
 
 export class Screens {
@@ -8,10 +8,14 @@ export class Screens {
   private welcomeName: HTMLElement;
   private colorSelector: HTMLElement;
   private joinButton: HTMLElement;
+  private practiceButton: HTMLElement;
+  private practiceReplay: HTMLInputElement;
+  private tutorialButton: HTMLElement;
+  private practiceTrack: HTMLInputElement;
   private errorMessage: HTMLElement;
 
   // Callbacks
-  private onJoin?: (colorIndex: number) => void;
+  private onJoin?: (colorIndex: number, options: number, track?: TrackRef) => void;
   private onColorChange?: (colorIndex: number) => void;
 
   // State
@@ -23,6 +27,10 @@ export class Screens {
     this.welcomeName = document.getElementById('welcome-name')!;
     this.colorSelector = document.getElementById('color-selector')!;
     this.joinButton = document.getElementById('join-btn')!;
+    this.practiceButton = document.getElementById('practice-btn')!;
+    this.practiceReplay = document.getElementById('practice-replay') as HTMLInputElement;
+    this.tutorialButton = document.getElementById('tutorial-btn')!;
+    this.practiceTrack = document.getElementById('practice-track') as HTMLInputElement;
     this.errorMessage = document.getElementById('error-msg')!;
 
     this.setupColorSelector();
@@ -55,11 +63,27 @@ export class Screens {
     this.onColorChange?.(index);
   }
 
-  // Set up join button
+  // Set up join, practice and tutorial buttons
   private setupJoinButton(): void {
     this.joinButton.addEventListener('click', () => {
-      this.onJoin?.(this.selectedColorIndex);
+      this.onJoin?.(this.selectedColorIndex, 0);
     });
+    this.practiceButton.addEventListener('click', () => {
+      const replay = this.practiceReplay.checked ? JoinOptions.Replay : 0;
+      const track = this.parseTrack(this.practiceTrack.value);
+      const custom = track ? JoinOptions.Track : 0;
+      this.onJoin?.(this.selectedColorIndex, JoinOptions.Solo | replay | custom, track);
+    });
+    this.tutorialButton.addEventListener('click', () => {
+      this.onJoin?.(this.selectedColorIndex, JoinOptions.Tutorial);
+    });
+  }
+
+  // Parse a track reference: "id" (latest version) or "id@version"
+  private parseTrack(value: string): TrackRef | undefined {
+    const match = value.trim().toLowerCase().match(/^([a-z0-9-]{1,16})(?:@(\d{1,5}))?$/);
+    if (!match) return undefined;
+    return { id: match[1], version: match[2] ? Math.min(Number(match[2]), 65535) : 0 };
   }
 
   // Set player name in welcome message
@@ -101,8 +125,8 @@ export class Screens {
     this.errorMessage.classList.add('hidden');
   }
 
-  // Set join callback
-  setOnJoin(callback: (colorIndex: number) => void): void {
+  // Set join callback (options: JoinOptions bits)
+  setOnJoin(callback: (colorIndex: number, options: number, track?: TrackRef) => void): void {
     this.onJoin = callback;
   }
 
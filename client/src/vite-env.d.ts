@@ -2,6 +2,8 @@
 
 interface ImportMetaEnv {
   readonly VITE_SERVER_URL: string;
+  readonly VITE_TENANT: string;
+  readonly VITE_TELEMETRY_KEY: string;
 }
 
 interface ImportMeta {
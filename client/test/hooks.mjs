@@ -0,0 +1,17 @@
+// Resolves the client's module specifiers the way Vite does for the tests:
+// "@/..." from src, and relative imports without their .ts extension.
+const src = new URL('../src/', import.meta.url);
+
+export async function resolve(specifier, context, nextResolve) {
+  if (specifier.startsWith('@/')) {
+    specifier = new URL(specifier.slice(2), src).href;
+  }
+  if ((specifier.startsWith('.') || specifier.startsWith('file:')) && !/\.[cm]?[jt]s$|\.json$/.test(specifier)) {
+    try {
+      return await nextResolve(`${specifier}.ts`, context);
+    } catch {
+      // Not a module of the client: resolve as given
+    }
+  }
+  return nextResolve(specifier, context);
+}
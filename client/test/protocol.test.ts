@@ -0,0 +1,220 @@
+// The golden vectors of protocol/vectors.json, generated from the server's
+// codec by server/cmd/protovectors: the client must encode every client
+// message from its fields to the recorded bytes, and decode every server
+// message to the recorded fields.
+//
+// Run with npm test (Node 22.18 or later).
+import { test } from 'node:test';
+import assert from 'node:assert/strict';
+import { Protocol } from '@/network/protocol';
+import { TrackVariant } from '@/types';
+import vectorFile from '../../protocol/vectors.json' with { type: 'json' };
+
+type Fields = Record<string, any>;
+
+interface Vector {
+  name: string;
+  direction: 'client' | 'server';
+  type: number;
+  hex: string;
+  fields: Fields;
+}
+
+const vectors = vectorFile.vectors as Vector[];
+
+function fromHex(hex: string): Uint8Array {
+  return new Uint8Array(hex.match(/../g)?.map((b) => parseInt(b, 16)) ?? []);
+}
+
+function toHex(data: ArrayBuffer | Uint8Array): string {
+  return Array.from(data instanceof Uint8Array ? data : new Uint8Array(data), (b) => b.toString(16).padStart(2, '0')).join('');
+}
+
+// The message a vector holds: its name up to the variant
+function messageName(v: Vector): string {
+  return v.name.split('/')[0];
+}
+
+// The protocol version the vector was encoded for, which the vectors of
+// versioned layouts record with their fields
+function versionOf(v: Vector): number {
+  return v.fields.version ?? 1;
+}
+
+const arrowKeys = (keys: number) => ({
+  ArrowUp: (keys & 1) !== 0,
+  ArrowDown: (keys & 2) !== 0,
+  ArrowLeft: (keys & 4) !== 0,
+  ArrowRight: (keys & 8) !== 0,
+});
+
+const cosmetics = (f: Fields) => (f.trail !== undefined ? { trail: f.trail, decal: f.decal } : undefined);
+
+// Client encoders by message, from a vector's fields
+const encoders: Record<string, (p: Protocol, f: Fields) => ArrayBuffer> = {
+  hello: (p, f) => p.encodeHello(f.version),
+  input: (p, f) => {
+    // The sequence number counts the inputs sent
+    for (let i = 0; i < f.sequence; i++) {
+      p.encodeInput(arrowKeys(0), 0, 0);
+    }
+    return p.encodeInput(arrowKeys(f.keys), f.steering / 127, f.throttle / 127, f.flags);
+  },
+  join: (p, f) =>
+    p.encodeJoin(f.name, f.color, f.options, f.trackId !== undefined ? { id: f.trackId, version: f.trackVersion } : undefined, cosmetics(f)),
+  'create-room': (p, f) => p.encodeCreateRoom(f.password, f.name, f.color, cosmetics(f)),
+  'join-by-code': (p, f) => p.encodeJoinByCode(f.code, f.password, f.name, f.color, cosmetics(f)),
+  ping: (p, f) => (f.fps !== undefined ? p.encodePing({ fps: f.fps, interpDelayMs: f.interpDelayMs, jitterMs: f.jitterMs }) : p.encodePing()),
+  leave: (p) => p.encodeLeave(),
+  reset: (p) => p.encodeReset(),
+  prestige: (p) => p.encodePrestige(),
+  clip: (p) => p.encodeClip(),
+  'net-sim': (p, f) => p.encodeNetSim(f.latencyMs, f.jitterMs, f.loss),
+  'event-auth': (p, f) => p.encodeEventAuth(fromHex(f.mac).buffer),
+  mute: (p, f) => p.encodeMute(f.op, f.targetId),
+  'update-profile': (p, f) => p.encodeUpdateProfile(f.name, f.color),
+  spectate: (p, f) => p.encodeSpectate(f.room),
+  telemetry: (p, f) => p.encodeTelemetry(p.telemetryBody(fromHex(f.nonce), f.device, f.frames), fromHex(f.mac).buffer),
+  'host-kick': (p, f) => p.encodeHostKick(f.targetId),
+  'host-start-race': (p) => p.encodeHostStartRace(),
+  'host-settings': (p, f) => p.encodeHostSettings(f.closed, f.password),
+  link: (p, f) => p.encodeLink(f.token),
+  signal: (p, f) => p.encodeSignal(f.targetId, f.kind, f.payload),
+};
+
+// Server decoders by message, to a vector's fields: the wire's units where
+// the client scales them
+const decoders: Record<string, (p: Protocol, data: ArrayBuffer, version: number) => unknown> = {
+  state: (p, data, version) => {
+    const { tick, baseY, players, own } = p.decodeStateUpdate(data, version);
+    return {
+      tick,
+      baseY,
+      players: players.map((pl) => ({
+        ...pl,
+        x: Math.round(pl.x * 10),
+        speed: Math.round(pl.speed * 10),
+        angle: Math.round((pl.angle * 127) / 25),
+        velX: pl.velX === undefined ? undefined : Math.round(pl.velX * 10),
+        velY: pl.velY === undefined ? undefined : Math.round(pl.velY * 10),
+      })),
+      own: own && { ...own, fuel: own.fuel === undefined ? undefined : Math.round(own.fuel * 1000) },
+    };
+  },
+  'player-join': (p, data) => p.decodePlayerJoin(data),
+  'player-leave': (p, data) => p.decodePlayerLeave(data),
+  'room-info': (p, data, version) => {
+    const info: Fields = p.decodeRoomInfo(data);
+    // Before protocol v37 rooms always collide, which the client assumes
+    if (version < 37) {
+      delete info.collisions;
+    }
+    return info;
+  },
+  pong: (p, data) => ({ timestamp: p.decodePong(data).timestamp }),
+  cooldown: (p, data) => p.decodeCooldown(data),
+  'hello-ack': (p, data) => p.decodeHelloAck(data),
+  scoreboard: (p, data) => ({ entries: p.decodeScoreboard(data) }),
+  'host-change': (p, data) => p.decodeHostChange(data),
+  'queue-status': (p, data) => p.decodeQueueStatus(data),
+  'tick-rate': (p, data) => p.decodeTickRate(data),
+  tutorial: (p, data) => p.decodeTutorial(data),
+  track: (p, data, version) => {
+    const { mirror, night, ...track } = p.decodeTrack(data, version);
+    if (version < 21) {
+      return track;
+    }
+    return { ...track, variant: (mirror ? TrackVariant.Mirror : 0) | (night ? TrackVariant.Night : 0) };
+  },
+  results: (p, data) => p.decodeResults(data),
+  rebase: (p, data) => p.decodeRebase(data),
+  'interp-delay': (p, data) => ({ delayMs: p.decodeInterpDelay(data) }),
+  linked: (p, data) => ({ account: p.decodeLinked(data) }),
+  'signal-relay': (p, data) => p.decodeSignalRelay(data),
+  nearby: (p, data) => ({ ids: p.decodeNearby(data) }),
+  appearance: (p, data) => p.decodeAppearance(data),
+  director: (p, data) => p.decodeDirector(data),
+  milestone: (p, data) => p.decodeMilestone(data),
+  collision: (p, data) => p.decodeCollision(data),
+  challenge: (p, data) => ({ nonce: toHex(p.decodeChallenge(data)) }),
+  mutes: (p, data) => ({ names: p.decodeMutes(data) }),
+  session: (p, data) => ({ token: p.decodeSession(data) }),
+  'room-code': (p, data) => ({ code: p.decodeRoomCode(data) }),
+  'room-settings': (p, data) => p.decodeRoomSettings(data),
+  probe: (p, data) => {
+    const { nonce, frames } = p.decodeProbe(data);
+    return { nonce: toHex(nonce), frames };
+  },
+  relay: (p, data) => p.decodeRelay(data),
+  'race-start': (p, data) => p.decodeRaceStart(data),
+  'checkpoint-passed': (p, data) => p.decodeCheckpointPassed(data),
+  'race-finished': (p, data) => p.decodeRaceFinished(data),
+  leaderboard: (p, data) => p.decodeLeaderboard(data),
+  'clip-saved': (p, data) => ({ id: p.decodeClipSaved(data) }),
+  error: (p, data) => p.decodeError(data),
+};
+
+// Server messages the client ignores, so has no decoder for
+const ignored = new Set(['player-death']);
+
+// snap returns got with the numbers that match want's replaced by them:
+// float32 fields, which JSON records at float32 precision, and 64-bit
+// timestamps, which JSON records as strings and the client holds as numbers
+function snap(got: unknown, want: unknown): unknown {
+  if (typeof got === 'number') {
+    if (typeof want === 'number' && Math.fround(got) === Math.fround(want)) {
+      return want;
+    }
+    if (typeof want === 'string' && Number(want) === got) {
+      return want;
+    }
+    return got;
+  }
+  if (Array.isArray(got) && Array.isArray(want)) {
+    return got.map((g, i) => snap(g, want[i]));
+  }
+  if (got && want && typeof got === 'object' && typeof want === 'object') {
+    return Object.fromEntries(Object.entries(got).map(([k, g]) => [k, snap(g, (want as Fields)[k])]));
+  }
+  return got;
+}
+
+for (const v of vectors.filter((v) => v.direction === 'client')) {
+  test(`encodes ${v.name}`, (t) => {
+    const encode = encoders[messageName(v)];
+    assert.ok(encode, `no encoder for ${messageName(v)}`);
+    // The client sends the time in ms, which no number beyond 2^53 holds
+    if (v.fields.timestamp !== undefined) {
+      const timestamp = BigInt(v.fields.timestamp);
+      if (timestamp > BigInt(Number.MAX_SAFE_INTEGER)) {
+        t.skip('timestamp beyond the client clock');
+        return;
+      }
+      t.mock.method(Date, 'now', () => Number(timestamp));
+    }
+    assert.equal(toHex(encode(new Protocol(), v.fields)), v.hex);
+  });
+}
+
+for (const v of vectors.filter((v) => v.direction === 'server')) {
+  test(`decodes ${v.name}`, (t) => {
+    if (ignored.has(messageName(v))) {
+      t.skip('ignored by the client');
+      return;
+    }
+    const decode = decoders[messageName(v)];
+    assert.ok(decode, `no decoder for ${messageName(v)}`);
+
+    const protocol = new Protocol();
+    const data = fromHex(v.hex).buffer;
+    assert.equal(protocol.getMessageType(data), v.type);
+
+    // Drop the fields the client leaves undefined, as JSON does
+    const got = JSON.parse(JSON.stringify(decode(protocol, data, versionOf(v))));
+    const want: Fields = { ...v.fields };
+    if (!('version' in got)) {
+      delete want.version;
+    }
+    assert.deepEqual(snap(got, want), want);
+  });
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/game"
+	"github.com/race/server/internal/network"
+)
+
+// encoder encodes the state updates of one receiver, in order
+type encoder interface {
+	encode(u update) []byte
+}
+
+var protocol = network.NewProtocol()
+
+// version is the record format the candidates build on
+const version = network.ProtocolVersionMax
+
+// rawEncoder sends every record
+type rawEncoder struct{}
+
+func newRaw([]update) encoder { return rawEncoder{} }
+
+func (rawEncoder) encode(u update) []byte {
+	return protocol.EncodeStateUpdateBase(version, uint16(u.tick), u.origin, u.records)
+}
+
+// sentState is the last record of a car a receiver got
+type sentState struct {
+	data network.PlayerStateData
+	tick uint64
+}
+
+// deltaFilter leaves out the records a dead reckoning receiver predicts,
+// as the room's broadcast does for a receiver whose own car isn't among
+// them
+type deltaFilter struct {
+	sent    map[uint16]sentState
+	records []network.PlayerStateData
+}
+
+func (f *deltaFilter) filter(u update) []network.PlayerStateData {
+	if f.sent == nil || u.start {
+		f.sent = make(map[uint16]sentState)
+	}
+	f.records = f.records[:0]
+	for _, data := range u.records {
+		rec, ok := f.sent[data.ID]
+		if ok && game.Predictable(rec.data, data, rec.tick, u.tick, config.PhysicsTickRate) {
+			continue
+		}
+		f.sent[data.ID] = sentState{data: data, tick: u.tick}
+		f.records = append(f.records, data)
+	}
+	return f.records
+}
+
+func (f *deltaFilter) message(u update) []byte {
+	return protocol.EncodeStateUpdateBase(version, uint16(u.tick), u.origin, f.filter(u))
+}
+
+// deltaEncoder sends dead reckoning records
+type deltaEncoder struct {
+	deltaFilter
+}
+
+func newDelta([]update) encoder { return &deltaEncoder{} }
+
+func (e *deltaEncoder) encode(u update) []byte {
+	return e.message(u)
+}
+
+// deflateEncoder compresses every delta message on its own, with a preset
+// dictionary if it has one
+type deflateEncoder struct {
+	deltaFilter
+	w   *flate.Writer
+	out bytes.Buffer
+}
+
+// deflateLevel is the compression level of permessage-deflate's writers
+const deflateLevel = flate.BestSpeed
+
+func newDeflate([]update) encoder {
+	w, _ := flate.NewWriter(nil, deflateLevel)
+	return &deflateEncoder{w: w}
+}
+
+// newDeflateDict trains the dictionary on the delta messages of the
+// training updates, the latest last as DEFLATE prefers
+func newDeflateDict(training []update) encoder {
+	var f deltaFilter
+	var dict []byte
+	for _, u := range training {
+		dict = append(dict, f.message(u)...)
+	}
+	const window = 32 * 1024 // DEFLATE looks back no further
+	if len(dict) > window {
+		dict = dict[len(dict)-window:]
+	}
+	// Only the best compression matches short messages against the
+	// dictionary
+	w, _ := flate.NewWriterDict(nil, flate.BestCompression, dict)
+	return &deflateEncoder{w: w}
+}
+
+func (e *deflateEncoder) encode(u update) []byte {
+	e.out.Reset()
+	e.w.Reset(&e.out)
+	e.w.Write(e.message(u))
+	e.w.Flush()
+	// permessage-deflate leaves out the empty block that ends the flush
+	return bytes.TrimSuffix(e.out.Bytes(), []byte{0x00, 0x00, 0xff, 0xff})
+}
+
+// packedEncoder bit-packs the delta records: the header carries, for each
+// record field, the smallest value in the update and the bits that the
+// widest difference to it needs, followed by the records' differences at
+// those widths
+type packedEncoder struct {
+	deltaFilter
+	buf []byte
+}
+
+func newPacked([]update) encoder { return &packedEncoder{} }
+
+// packedFieldCount is the number of fields of a packed record
+const packedFieldCount = 11
+
+func packedFields(d network.PlayerStateData) [packedFieldCount]int64 {
+	return [packedFieldCount]int64{
+		int64(d.ID), int64(d.X), int64(d.Y), int64(d.Speed), int64(d.Angle), int64(d.Rating),
+		int64(d.Flags), int64(d.Color), int64(d.VelX), int64(d.VelY), int64(d.Lane),
+	}
+}
+
+func (e *packedEncoder) encode(u update) []byte {
+	records := e.filter(u)
+	buf := e.buf[:0]
+	buf = append(buf, network.MsgTypeStateUpdate)
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(u.tick))
+	buf = append(buf, uint8(min(len(records), 255)))
+	buf = binary.AppendVarint(buf, u.origin)
+
+	var lo, hi [packedFieldCount]int64
+	for i, data := range records {
+		fields := packedFields(data)
+		for f, v := range fields {
+			if i == 0 || v < lo[f] {
+				lo[f] = v
+			}
+			if i == 0 || v > hi[f] {
+				hi[f] = v
+			}
+		}
+	}
+	var width [packedFieldCount]uint
+	for f := range width {
+		for hi[f]-lo[f] >= 1<<width[f] {
+			width[f]++
+		}
+		buf = binary.AppendVarint(buf, lo[f])
+		buf = append(buf, uint8(width[f]))
+	}
+
+	var acc uint64
+	var bits uint
+	for _, data := range records {
+		for f, v := range packedFields(data) {
+			acc |= uint64(v-lo[f]) << bits
+			bits += width[f]
+			for bits >= 8 {
+				buf = append(buf, uint8(acc))
+				acc >>= 8
+				bits -= 8
+			}
+		}
+	}
+	if bits > 0 {
+		buf = append(buf, uint8(acc))
+	}
+	e.buf = buf
+	return buf
+}
@@ -0,0 +1,258 @@
+// Command codecbench compares candidate codecs of the state broadcast, the
+// bulk of what the server sends, on recorded gameplay: how many bytes each
+// takes per state update and how long it takes to encode one. Traces are
+// JSON lines of game.InspectFrame, as the console's inspect command prints
+// them (or an agent receives them, see agent.go); other lines are skipped.
+// Without a trace, a room of bots is recorded first, which drives less
+// erratically than people do.
+//
+//	go run ./cmd/codecbench room-1.jsonl room-2.jsonl
+//	go run ./cmd/codecbench -seconds 300 -bots 40   # a bots-only recording
+//
+// The candidates:
+//
+//	raw                 every record in every update (ProtocolV1 and V2 clients)
+//	delta               dead reckoning records (ProtocolV3 clients and up)
+//	delta+deflate       delta, compressed per message as permessage-deflate
+//	                    does without context takeover
+//	delta+packed        delta with each record field bit-packed at the width
+//	                    its range in the update needs
+//	delta+deflate-dict  delta compressed per message at the best compression,
+//	                    with a preset dictionary trained on the start of the
+//	                    traces
+//
+// The codecs a client can decode today are selected with STATE_CODEC (see
+// the server's README); the others need client support and a protocol
+// version first, which their numbers here can justify or not. zstd is not
+// among the module's dependencies, so DEFLATE's preset dictionary stands in
+// for a zstd dictionary: the same idea with a smaller window.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/game"
+	"github.com/race/server/internal/network"
+)
+
+// update is one state update of a trace: every car's record
+type update struct {
+	start   bool // First update of its trace: receivers start over
+	tick    uint64
+	origin  int64
+	records []network.PlayerStateData
+}
+
+// candidate is a codec under comparison
+type candidate struct {
+	name    string
+	setting string // STATE_CODEC that sends it ("": clients can't decode it)
+	new     func(training []update) encoder
+}
+
+var candidates = []candidate{
+	{name: "raw", setting: "records", new: newRaw},
+	{name: "delta", setting: "records", new: newDelta},
+	{name: "delta+deflate", setting: "deflate", new: newDeflate},
+	{name: "delta+packed", new: newPacked},
+	{name: "delta+deflate-dict", new: newDeflateDict},
+}
+
+// result is a candidate's measurement
+type result struct {
+	candidate
+	updates int
+	bytes   int
+	largest int
+	took    time.Duration // Encoding every update once
+}
+
+func main() {
+	seconds := flag.Int("seconds", 120, "seconds of play to record without a trace")
+	bots := flag.Int("bots", 30, "bots of the room recorded without a trace")
+	train := flag.Float64("train", 0.1, "share of the updates that trains dictionaries instead of being measured")
+	rounds := flag.Int("rounds", 5, "encoding passes over the updates to time")
+	verbose := flag.Bool("v", false, "show the game's own logging (joins, explosions, ...)")
+	flag.Parse()
+
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	var updates []update
+	if flag.NArg() == 0 {
+		fmt.Printf("Recording %d s of a room of %d bots\n", *seconds, *bots)
+		updates = record(*seconds, *bots)
+	}
+	for _, path := range flag.Args() {
+		trace, err := readTrace(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "codecbench: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s: %d state updates\n", path, len(trace))
+		updates = append(updates, trace...)
+	}
+
+	split := min(len(updates), int(float64(len(updates))**train))
+	training, measured := updates[:split], updates[split:]
+	if len(measured) == 0 {
+		fmt.Fprintln(os.Stderr, "codecbench: no state updates to measure")
+		os.Exit(1)
+	}
+	measured[0].start = true
+
+	results := make([]result, 0, len(candidates))
+	for _, c := range candidates {
+		results = append(results, measure(c, training, measured, max(1, *rounds)))
+	}
+	report(results, len(training))
+}
+
+// measure encodes the updates with a candidate: sizes in the first round,
+// time over all of them
+func measure(c candidate, training, updates []update, rounds int) result {
+	res := result{candidate: c, updates: len(updates)}
+	for round := 0; round < rounds; round++ {
+		enc := c.new(training)
+		started := time.Now()
+		for _, u := range updates {
+			msg := enc.encode(u)
+			if round == 0 {
+				res.bytes += len(msg)
+				res.largest = max(res.largest, len(msg))
+			}
+		}
+		res.took += time.Since(started)
+	}
+	res.took /= time.Duration(rounds)
+	return res
+}
+
+// report prints the results, smallest first
+func report(results []result, trained int) {
+	raw := results[0]
+	sort.SliceStable(results, func(i, j int) bool { return results[i].bytes < results[j].bytes })
+
+	fmt.Printf("\n%d state updates measured, %d trained on; per receiver at %d Hz:\n\n",
+		raw.updates, trained, config.NetworkBroadcastRate)
+	fmt.Printf("%-20s %-12s %10s %8s %8s %10s %10s\n",
+		"codec", "STATE_CODEC", "B/update", "largest", "of raw", "KiB/s", "ns/update")
+	for _, r := range results {
+		setting := r.setting
+		if setting == "" {
+			setting = "-"
+		}
+		mean := float64(r.bytes) / float64(r.updates)
+		fmt.Printf("%-20s %-12s %10.1f %8d %7.1f%% %10.2f %10d\n",
+			r.name, setting, mean, r.largest,
+			100*float64(r.bytes)/float64(raw.bytes),
+			mean*config.NetworkBroadcastRate/1024,
+			r.took.Nanoseconds()/int64(r.updates))
+	}
+}
+
+// readTrace reads the state updates of a trace, one every broadcast
+// interval of its physics ticks
+func readTrace(path string) ([]update, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	every := uint64(config.PhysicsTickRate / config.NetworkBroadcastRate)
+	var updates []update
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Bytes()
+		if len(text) == 0 || text[0] != '{' {
+			continue // Console chatter
+		}
+		var frame game.InspectFrame
+		if err := json.Unmarshal(text, &frame); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		if len(frame.Cars) == 0 {
+			continue
+		}
+		if n := len(updates); n > 0 && frame.Tick < updates[n-1].tick+every {
+			continue
+		}
+		updates = append(updates, updateOf(frame))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(updates) > 0 {
+		updates[0].start = true
+	}
+	return updates, nil
+}
+
+// record records a room of bots driving for the given seconds
+func record(seconds, bots int) []update {
+	room := game.NewRoom("codecbench")
+	profiles := make([]game.BotProfile, 0, len(game.DefaultBotProfiles))
+	for _, p := range game.DefaultBotProfiles {
+		profiles = append(profiles, p)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	for i := 0; i < bots; i++ {
+		if _, err := room.AddBot(profiles[i%len(profiles)]); err != nil {
+			log.Fatalf("Failed to add bot: %v", err)
+		}
+	}
+
+	frames, stop := room.Inspect(1)
+	defer stop()
+
+	every := config.PhysicsTickRate / config.NetworkBroadcastRate
+	dt := 1.0 / float64(config.PhysicsTickRate)
+	var updates []update
+	for tick := 0; tick < seconds*config.PhysicsTickRate; tick++ {
+		room.Step(dt, false)
+		frame := <-frames
+		if tick%every == 0 {
+			updates = append(updates, updateOf(frame))
+		}
+	}
+	if len(updates) > 0 {
+		updates[0].start = true
+	}
+	return updates
+}
+
+// updateOf builds the records the broadcast sends for an inspect frame.
+// Frames don't carry colors, which never change anyway.
+func updateOf(frame game.InspectFrame) update {
+	u := update{
+		tick:    frame.Tick,
+		origin:  int64(frame.Origin),
+		records: make([]network.PlayerStateData, len(frame.Cars)),
+	}
+	for i, car := range frame.Cars {
+		data := network.ConvertToPlayerStateData(car.ID, car.X, car.Y, car.Speed, car.Angle, car.Rating, car.Exploded, 0)
+		data.VelX = network.ScaleVelocity(car.VelX)
+		data.VelY = network.ScaleVelocity(car.VelY)
+		data.Lane = uint8(car.Lane)
+		if car.Ghost {
+			data.Flags |= network.FlagGhost
+		}
+		if car.Height > 0 {
+			data.Flags |= network.FlagAirborne
+		}
+		u.records[i] = data
+	}
+	return u
+}
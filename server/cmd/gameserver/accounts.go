@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/game"
+	"github.com/race/server/internal/network"
+	"github.com/race/server/internal/storage"
+	"github.com/race/server/internal/token"
+)
+
+// Account links
+//
+//   POST /admin/accounts/link - issue a link token for an account (admin token)
+//
+// Players join as guests under any name. Registered accounts are kept by an
+// account service outside the game server: when a guest registers or logs
+// in mid-play, the service asks for a link token naming the account and
+// hands it to the game client, which sends it in a Link message (protocol
+// v12). The server then moves what is stored under the guest name to the
+// account (profile totals, achievements, matches; see history/account.go)
+// in one storage transaction that also spends the token, moves the guest's
+// entry on every tenant's live leaderboard, and renames the car in its room
+// so the run and round in progress count for the account. The client gets a
+// Linked message with the account name, the room a PlayerJoin with the new
+// name. Link tokens are valid for config.LinkTokenTTL and work once.
+
+// linkRequest is the body of POST /admin/accounts/link
+type linkRequest struct {
+	Account string `json:"account"` // Account name, a valid player name
+}
+
+// linkResponse carries the token for the game client's Link message
+type linkResponse struct {
+	Token   string    `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+// handleAdminAccountLink issues a link token.
+func (s *GameServer) handleAdminAccountLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req linkRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		http.Error(w, "invalid link request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Accounts play under their name, so it must pass as one unchanged
+	if name, _ := s.moderator.SanitizeName(r.Context(), req.Account, 20, ""); name == "" || name != req.Account {
+		http.Error(w, "invalid link request: account must be a valid player name", http.StatusBadRequest)
+		return
+	}
+
+	expires := time.Now().Add(config.LinkTokenTTL)
+	issued, err := s.tokens.Issue(token.PurposeLink, req.Account, "", config.LinkTokenTTL)
+	if err != nil {
+		log.Printf("Failed to issue link token: %v", err)
+		http.Error(w, "failed to issue link token", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, linkResponse{Token: issued, Expires: expires.UTC()})
+}
+
+// handleLink upgrades the connection's guest session to the account of a
+// link token.
+func (c *ClientConnection) handleLink(m *message) {
+	msg, err := c.server.protocol.DecodeLink(m.data)
+	if err != nil {
+		return
+	}
+	c.server.linkAccount(c, m.player, m.room, msg.Token)
+}
+
+// linkAccount moves a guest's data to the account of a link token and
+// renames the player in the room.
+func (s *GameServer) linkAccount(c *ClientConnection, player *game.Player, room *game.Room, link string) {
+	var txn storage.Txn
+	claims, err := s.tokens.RedeemIn(&txn, token.PurposeLink, link)
+	if err != nil {
+		c.Send(s.protocol.EncodeError(network.ErrorCodeNotAllowed, "Link failed: "+err.Error()))
+		return
+	}
+	guest, account := player.GetName(), claims.Subject
+	if s.sessionRefused(c, account) {
+		s.metrics.sessionsRefused.Add(1)
+		c.Send(s.protocol.EncodeError(network.ErrorCodeNotAllowed, "Link failed: account is logged in elsewhere"))
+		return
+	}
+
+	profile, err := s.history.Link(&txn, guest, account)
+	if errors.Is(err, storage.ErrConflict) {
+		c.Send(s.protocol.EncodeError(network.ErrorCodeNotAllowed, "Link failed: "+token.ErrReplayed.Error()))
+		return
+	}
+	if err != nil {
+		// Nothing was written and the token is still good: the client may retry
+		log.Printf("Failed to link %s to account %s: %v", c.info, account, err)
+		c.Send(s.protocol.EncodeError(network.ErrorCodeServerError, "Failed to link account"))
+		return
+	}
+
+	for _, t := range s.tenants {
+		t.leaderboard.Merge(guest, account)
+	}
+	s.profiles.drop(guest)
+	s.profiles.drop(account)
+	s.fingerprints.Rename(guest, account)
+	if err := room.Rename(player.ID, account); err != nil {
+		log.Printf("Linked %s to account %s after leaving room %s", c.info, account, room.ID)
+	}
+	c.info.SetAccount(account)
+	c.mu.Lock()
+	previous := c.account
+	c.account = account
+	look := c.look
+	c.mu.Unlock()
+	s.sessions.release(previous, c)
+	if elsewhere := s.sessions.take(account, c); elsewhere != nil {
+		s.kickSession(elsewhere, account) // Also if two links raced past the policy
+	}
+	c.Send(s.protocol.EncodeLinked(account))
+
+	// Cosmetics of the join that the account owns show from now on, and a
+	// practice room stops recording if the account opted out
+	s.dress(c, room, player, look, account)
+	if room.Practice() && !s.replaysAllowed(c) {
+		room.DisableReplays()
+	}
+
+	log.Printf("Guest '%s' linked to account '%s' (%d rounds)", guest, account, profile.Rounds)
+}
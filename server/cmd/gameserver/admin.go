@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/race/server/internal/game"
+	"github.com/race/server/internal/moderation"
+	"github.com/race/server/internal/routines"
+)
+
+// Admin API
+//
+// Endpoints under /admin/ are for operators and moderators. They are only
+// registered when ADMIN_TOKEN is set, and every request must carry
+// "Authorization: Bearer <token>".
+
+// registerAdminRoutes registers the admin endpoints if an admin token is configured.
+func (s *GameServer) registerAdminRoutes(mux *http.ServeMux) {
+	if s.config.AdminToken == "" {
+		return
+	}
+
+	mux.HandleFunc("/admin/moderation", s.requireAdmin(s.handleAdminModeration))
+	mux.HandleFunc("/admin/rooms/", s.requireAdmin(s.handleAdminRoom))
+	mux.HandleFunc("/admin/rooms/import", s.requireAdmin(s.handleAdminRoomImport))
+	mux.HandleFunc("/admin/tracks/", s.requireAdmin(s.handleAdminTrackApproval))
+	mux.HandleFunc("/admin/public-track", s.requireAdmin(s.handleAdminPublicTrack))
+	mux.HandleFunc("/admin/players/", s.requireAdmin(s.handleAdminPlayer))
+	mux.HandleFunc("/admin/placement", s.requireAdmin(s.handleAdminPlacement))
+	mux.HandleFunc("/admin/placement/", s.requireAdmin(s.handleAdminPlacement))
+	mux.HandleFunc("/admin/allocations", s.requireAdmin(s.handleAllocations))
+	mux.HandleFunc("/admin/accounts/link", s.requireAdmin(s.handleAdminAccountLink))
+	mux.HandleFunc("/admin/accounts/", s.requireAdmin(s.handleAdminAccount))
+	mux.HandleFunc("/admin/fingerprints", s.requireAdmin(s.handleAdminFingerprints))
+	mux.HandleFunc("/admin/honeypot", s.requireAdmin(s.handleAdminHoneypot))
+	mux.HandleFunc("/admin/agent", s.requireAdmin(s.handleAdminAgent))
+	mux.HandleFunc("/admin/connections", s.requireAdmin(s.handleAdminConnections))
+	mux.HandleFunc("/admin/reports", s.requireAdmin(s.handleAdminReports))
+	mux.HandleFunc("/debug/goroutines", s.requireAdmin(s.handleDebugGoroutines))
+}
+
+// requireAdmin wraps a handler with bearer token authentication.
+func (s *GameServer) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.isAdmin(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// isAdmin reports whether a request carries the admin token (false when no
+// token is configured).
+func (s *GameServer) isAdmin(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return s.config.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken)) == 1
+}
+
+// writeJSON writes v as a JSON response.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// handleDebugGoroutines reports goroutines per owner, or with ?stacks=1 the
+// grouped stacks of every goroutine (to find the owner of a leak).
+func (s *GameServer) handleDebugGoroutines(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("stacks") == "1" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		pprof.Lookup("goroutine").WriteTo(w, 1)
+		return
+	}
+	writeJSON(w, http.StatusOK, routines.Snapshot())
+}
+
+// handleAdminModeration returns (GET) or replaces (PUT) the moderation rules.
+func (s *GameServer) handleAdminModeration(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.moderator.Rules())
+
+	case http.MethodPut:
+		var rules moderation.Rules
+		if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+			http.Error(w, "invalid rules: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.moderator.SetRules(rules); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.moderator.Rules())
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminRoom routes /admin/rooms/{id}/bots[/{botId}],
+// /admin/rooms/{id}/config, /admin/rooms/{id}/director and
+// /admin/rooms/{id}/players/{playerId}/ghost.
+func (s *GameServer) handleAdminRoom(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/rooms/"), "/"), "/")
+	if len(parts) < 2 || (parts[1] != "bots" && parts[1] != "config" && parts[1] != "players" && parts[1] != "snapshot" && parts[1] != "director") {
+		http.NotFound(w, r)
+		return
+	}
+
+	_, room := s.findRoom(parts[0])
+	if room == nil {
+		http.Error(w, "room not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case parts[1] == "config" && len(parts) == 2:
+		s.handleAdminRoomConfig(w, r, room)
+	case parts[1] == "config":
+		http.NotFound(w, r)
+	case parts[1] == "snapshot" && len(parts) == 2:
+		s.handleAdminRoomSnapshot(w, r, room)
+	case parts[1] == "snapshot":
+		http.NotFound(w, r)
+	case parts[1] == "director" && len(parts) == 2:
+		s.handleAdminDirector(w, r, room)
+	case parts[1] == "director":
+		http.NotFound(w, r)
+	case parts[1] == "players" && len(parts) == 4 && parts[3] == "ghost":
+		id, err := strconv.ParseUint(parts[2], 10, 16)
+		if err != nil {
+			http.Error(w, "invalid player id", http.StatusBadRequest)
+			return
+		}
+		s.handleAdminGhost(w, r, room, uint16(id))
+	case parts[1] == "players":
+		http.NotFound(w, r)
+	case len(parts) == 2:
+		s.handleAdminBots(w, r, room)
+	case len(parts) == 3 && r.Method == http.MethodDelete:
+		id, err := strconv.ParseUint(parts[2], 10, 16)
+		if err != nil {
+			http.Error(w, "invalid bot id", http.StatusBadRequest)
+			return
+		}
+		if err := room.RemoveBot(uint16(id)); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminRoomConfig returns (GET) a room's tick rates or changes (PATCH
+// {"broadcastRate": 10}) its broadcast rate. The physics rate is fixed for
+// the life of a room.
+func (s *GameServer) handleAdminRoomConfig(w http.ResponseWriter, r *http.Request, room *game.Room) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, room.Config())
+
+	case http.MethodPatch:
+		var req struct {
+			BroadcastRate int `json:"broadcastRate"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := room.SetBroadcastRate(req.BroadcastRate); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, room.Config())
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminGhost turns a player's collisions off (PUT {"seconds": 30}, 0
+// or no body: until removed) or back on (DELETE). Ghosts set for other
+// reasons, like ramming penalties, are not affected.
+func (s *GameServer) handleAdminGhost(w http.ResponseWriter, r *http.Request, room *game.Room, playerID uint16) {
+	var err error
+	switch r.Method {
+	case http.MethodPut:
+		var req struct {
+			Seconds float64 `json:"seconds"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = room.SetGhost(playerID, game.GhostAdmin, time.Duration(req.Seconds*float64(time.Second)))
+
+	case http.MethodDelete:
+		err = room.ClearGhost(playerID, game.GhostAdmin)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAdminBots lists (GET) or adds (POST {"profile": "..."}) bots in a room.
+func (s *GameServer) handleAdminBots(w http.ResponseWriter, r *http.Request, room *game.Room) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, room.Bots())
+
+	case http.MethodPost:
+		var req struct {
+			Profile string `json:"profile"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		profile, ok := s.botProfiles[req.Profile]
+		if !ok {
+			http.Error(w, "unknown bot profile", http.StatusBadRequest)
+			return
+		}
+		bot, err := room.AddBot(profile)
+		if errors.Is(err, game.ErrRoomFull) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusCreated, game.BotInfo{ID: bot.ID, Name: bot.Name, Profile: profile.Name})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
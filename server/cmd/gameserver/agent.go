@@ -0,0 +1,390 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/race/server/config"
+	"github.com/race/server/internal/game"
+	"github.com/race/server/internal/routines"
+)
+
+// Agent API
+//
+//   GET /admin/agent - a WebSocket for an agent session (admin token)
+//
+// Programs such as reinforcement learning agents drive a car on the real
+// server physics through an agent session: they observe a room at full
+// fidelity, every physics tick, and set the input of one car in it, a bot
+// slot of their own (see game/agent.go). Messages are JSON text frames.
+//
+//   ?room=<id>      live: the agent's car joins a running room, whose frames
+//                   stream at its physics rate; frames the agent falls
+//                   behind on are dropped and counted in the next frame,
+//                   and a room without human players sends none (it is
+//                   suspended)
+//   ?bots=<n>       training (without room): a private room set up like
+//                   the public rooms, with n bots, that only advances when
+//                   the agent steps it, as fast as both can go, and never
+//                   reaches leaderboards or match history
+//   ?name=<name>    the car's name ("Agent" by default)
+//   ?tenant=<key>   the game of the room
+//
+// Server to agent:
+//
+//   {"type":"hello","mode":"live"|"train","room":"...","agentId":3,"physicsTickRate":60}
+//   {"type":"frame","tick":...,"cars":[...],"verdicts":[...],...}
+//   {"type":"error","error":"..."}
+//
+// Frames are the console's inspect frames (game.InspectFrame): every car's
+// exact state and input and the corrections anti-cheat made in the tick.
+//
+// Agent to server:
+//
+//   {"type":"input","steering":0.5,"throttle":1,"keys":0}
+//   {"type":"step","steering":0.5,"throttle":1,"keys":0,"ticks":4}
+//   {"type":"reset"}
+//
+// input drives the car with the input until the next one. In training,
+// step does the same then advances the room ticks physics ticks (1 by
+// default, config.AgentStepMax at most) and answers with the frame of the
+// last one, and reset starts over in a new room, answered with a new
+// hello. At most config.AgentSessionsMax sessions are open at once; the
+// agent's car leaves with its session.
+
+// Agent session modes
+const (
+	agentModeLive  = "live"
+	agentModeTrain = "train"
+)
+
+// agentSessions tracks the open agent sessions
+type agentSessions struct {
+	mu       sync.Mutex
+	sessions map[*websocket.Conn]bool
+	closed   bool
+}
+
+// track adds a session, unless there are too many or the server is
+// shutting down
+func (a *agentSessions) track(ws *websocket.Conn) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed || len(a.sessions) >= config.AgentSessionsMax {
+		return false
+	}
+	if a.sessions == nil {
+		a.sessions = make(map[*websocket.Conn]bool)
+	}
+	a.sessions[ws] = true
+	return true
+}
+
+func (a *agentSessions) untrack(ws *websocket.Conn) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.sessions, ws)
+	ws.Close()
+}
+
+func (a *agentSessions) count() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.sessions)
+}
+
+// closeAll ends the open sessions and refuses new ones, for shutdown
+func (a *agentSessions) closeAll() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.closed = true
+	for ws := range a.sessions {
+		ws.Close()
+	}
+}
+
+// agentMessage is a message from an agent
+type agentMessage struct {
+	Type     string  `json:"type"`
+	Steering float64 `json:"steering"`
+	Throttle float64 `json:"throttle"`
+	Keys     uint8   `json:"keys"`
+	Ticks    int     `json:"ticks"` // step only
+}
+
+// agentHello opens a session, and a training room after reset
+type agentHello struct {
+	Type            string `json:"type"`
+	Mode            string `json:"mode"`
+	Room            string `json:"room"`
+	AgentID         uint16 `json:"agentId"`
+	PhysicsTickRate int    `json:"physicsTickRate"`
+}
+
+// agentFrame is an observation sent to an agent
+type agentFrame struct {
+	Type string `json:"type"`
+	game.InspectFrame
+}
+
+// agentError reports a refused message or the end of a session
+type agentError struct {
+	Type  string `json:"type"`
+	Error string `json:"error"`
+}
+
+// agentSession is one agent's connection
+type agentSession struct {
+	s      *GameServer
+	ws     *websocket.Conn
+	tenant *tenant
+	name   string
+	live   bool // A live session, else training
+	bots   int  // Training rooms' bots
+
+	writeMu sync.Mutex // The session and a live session's stream both write
+
+	// The room and the agent's car in it
+	room   *game.Room
+	id     uint16
+	frames <-chan game.InspectFrame
+	stop   func() // Ends the inspection
+}
+
+// handleAdminAgent opens an agent session.
+func (s *GameServer) handleAdminAgent(w http.ResponseWriter, r *http.Request) {
+	t := s.tenantOf(r)
+	if t == nil {
+		http.Error(w, "unknown tenant", http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+	a := &agentSession{s: s, tenant: t, name: query.Get("name")}
+	if a.name == "" {
+		a.name = "Agent"
+	}
+	if len(a.name) > 20 {
+		http.Error(w, "name must be at most 20 bytes", http.StatusBadRequest)
+		return
+	}
+
+	var live *game.Room
+	if id := query.Get("room"); id != "" {
+		if live = t.matchmaker.GetRoom(id); live == nil {
+			http.Error(w, "room not found", http.StatusNotFound)
+			return
+		}
+	} else if bots := query.Get("bots"); bots != "" {
+		n, err := strconv.Atoi(bots)
+		if err != nil || n < 0 || n >= config.MaxPlayersPerRoom {
+			http.Error(w, fmt.Sprintf("bots must be 0 to %d", config.MaxPlayersPerRoom-1), http.StatusBadRequest)
+			return
+		}
+		a.bots = n
+	}
+	a.live = live != nil
+	if s.agents.count() >= config.AgentSessionsMax {
+		http.Error(w, "too many agent sessions", http.StatusServiceUnavailable)
+		return
+	}
+
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Agent WebSocket upgrade failed: %v", err)
+		return
+	}
+	if !s.agents.track(ws) {
+		ws.Close()
+		return
+	}
+	ws.SetReadLimit(1024)
+	a.ws = ws
+
+	routines.Go("agent.session", func() {
+		defer s.agents.untrack(ws)
+		if a.live {
+			a.runLive(live)
+		} else {
+			a.runTraining()
+		}
+	})
+}
+
+// runLive drives the agent's car in a running room until the agent or the
+// room goes
+func (a *agentSession) runLive(room *game.Room) {
+	if err := a.enter(room); err != nil {
+		a.send(agentError{Type: "error", Error: err.Error()})
+		return
+	}
+	defer a.leave()
+	log.Printf("Agent %s joined room %s", a.name, room.ID)
+	if a.hello(agentModeLive) != nil {
+		return
+	}
+
+	// The room's pace, not the agent's
+	frames := a.frames
+	routines.Go("agent.stream", func() {
+		for f := range frames {
+			if a.send(agentFrame{Type: "frame", InspectFrame: f}) != nil {
+				break
+			}
+		}
+		// Stopped, or the agent is gone: either way the session ends
+		a.send(agentError{Type: "error", Error: "session ended"})
+		a.ws.Close()
+	})
+
+	for {
+		var msg agentMessage
+		if err := a.ws.ReadJSON(&msg); err != nil {
+			return
+		}
+		switch msg.Type {
+		case "input":
+			if !a.drive(msg) {
+				return
+			}
+		default:
+			a.send(agentError{Type: "error", Error: fmt.Sprintf("%q is not a live session message", msg.Type)})
+		}
+	}
+}
+
+// runTraining steps private rooms for the agent until it goes
+func (a *agentSession) runTraining() {
+	defer a.leave()
+	if a.reset() != nil {
+		return
+	}
+	dt := 1 / float64(a.room.Config().PhysicsTickRate)
+
+	for {
+		var msg agentMessage
+		if err := a.ws.ReadJSON(&msg); err != nil {
+			return
+		}
+		switch msg.Type {
+		case "input":
+			if !a.drive(msg) {
+				return
+			}
+		case "step":
+			if msg.Ticks == 0 {
+				msg.Ticks = 1
+			}
+			if msg.Ticks < 0 || msg.Ticks > config.AgentStepMax {
+				a.send(agentError{Type: "error", Error: fmt.Sprintf("ticks must be 1 to %d", config.AgentStepMax)})
+				continue
+			}
+			if !a.drive(msg) {
+				return
+			}
+			var last game.InspectFrame
+			for i := 0; i < msg.Ticks; i++ {
+				a.room.Step(dt, false)
+				select {
+				case last = <-a.frames:
+				default:
+				}
+			}
+			if a.send(agentFrame{Type: "frame", InspectFrame: last}) != nil {
+				return
+			}
+		case "reset":
+			if a.reset() != nil {
+				return
+			}
+		default:
+			a.send(agentError{Type: "error", Error: fmt.Sprintf("%q is not a training session message", msg.Type)})
+		}
+	}
+}
+
+// reset starts the agent over in a new training room
+func (a *agentSession) reset() error {
+	a.leave()
+	room := a.tenant.matchmaker.TrainingRoom()
+	profiles := a.s.botProfilesByName()
+	for i := 0; i < a.bots && len(profiles) > 0; i++ {
+		if _, err := room.AddBot(profiles[i%len(profiles)]); err != nil {
+			break
+		}
+	}
+	if err := a.enter(room); err != nil {
+		a.send(agentError{Type: "error", Error: err.Error()})
+		return err
+	}
+	return a.hello(agentModeTrain)
+}
+
+// enter adds the agent's car to a room and starts inspecting it
+func (a *agentSession) enter(room *game.Room) error {
+	p, err := room.AddAgent(a.name)
+	if err != nil {
+		return err
+	}
+	// A training room is stepped on this goroutine and drained after every
+	// tick, so one frame of buffer is enough
+	buffer := 1
+	if a.live {
+		buffer = config.AgentFrameBuffer
+	}
+	a.room, a.id = room, p.ID
+	a.frames, a.stop = room.Inspect(buffer)
+	return nil
+}
+
+// leave takes the agent's car out of its room
+func (a *agentSession) leave() {
+	if a.room == nil {
+		return
+	}
+	a.stop()
+	a.room.RemoveBot(a.id)
+	if a.live {
+		log.Printf("Agent %s left room %s", a.name, a.room.ID)
+	} else {
+		log.Printf("Agent %s finished training room %s at tick %d", a.name, a.room.ID, a.room.Tick())
+	}
+	a.room = nil
+}
+
+// drive applies an agent's input. Returns false if the car is gone (removed
+// from the room, or the room stopped), which ends the session.
+func (a *agentSession) drive(msg agentMessage) bool {
+	err := a.room.DriveAgent(a.id, game.PlayerInput{Keys: msg.Keys, Steering: msg.Steering, Throttle: msg.Throttle})
+	if err != nil {
+		a.send(agentError{Type: "error", Error: "the agent's car left the room"})
+		return false
+	}
+	return true
+}
+
+func (a *agentSession) hello(mode string) error {
+	return a.send(agentHello{
+		Type:            "hello",
+		Mode:            mode,
+		Room:            a.room.ID,
+		AgentID:         a.id,
+		PhysicsTickRate: a.room.Config().PhysicsTickRate,
+	})
+}
+
+// send writes a message to the agent
+func (a *agentSession) send(v interface{}) error {
+	a.writeMu.Lock()
+	defer a.writeMu.Unlock()
+
+	a.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return a.ws.WriteJSON(v)
+}
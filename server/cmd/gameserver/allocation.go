@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/token"
+)
+
+// Match allocation
+//
+//   POST /admin/allocations - reserve a room for a match (admin token)
+//
+// An external matchmaker, typically an Open Match director, books a room
+// for the players of a match it formed: the request lists its ticket IDs,
+// the response carries a signed ticket token for each of them, to be handed
+// to the players along with the server's public address. A player connects
+// to /ws?ticket=<token> and the next join puts them in the reserved room
+// (see matchmaker/reserve.go). Tickets and the reservation are valid for
+// config.AllocationTTL; afterwards the room fills up like any public room.
+// An allocation with an eventSecret books a tournament room, whose players
+// must also prove the secret (see tournament.go).
+//
+// With AGONES_ENABLED, the server reports to the Agones sidecar: Ready once
+// it listens, health pings while it runs, and Allocated with the first
+// reservation, so a fleet autoscaler doesn't scale away a server holding a
+// match.
+
+// allocationRequest is the body of POST /admin/allocations
+type allocationRequest struct {
+	Tickets []string `json:"tickets"`          // Matchmaker ticket IDs, one per player
+	Tenant  string   `json:"tenant,omitempty"` // Tenant of the match ("" for the default)
+
+	// EventSecret makes the room a tournament room: players must also
+	// prove the secret (see tournament.go)
+	EventSecret string `json:"eventSecret,omitempty"`
+}
+
+// allocationResponse tells the matchmaker where the players go
+type allocationResponse struct {
+	Room       string            `json:"room"`
+	Instance   string            `json:"instance"`
+	PublicAddr string            `json:"publicAddr,omitempty"`
+	Expires    time.Time         `json:"expires"`
+	Tickets    map[string]string `json:"tickets"` // Ticket ID -> token for /ws?ticket=
+}
+
+// handleAllocations reserves a room for a match.
+func (s *GameServer) handleAllocations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req allocationRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		http.Error(w, "invalid allocation: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Tickets) == 0 || len(req.Tickets) > config.MaxPlayersPerRoom {
+		http.Error(w, "invalid allocation: one ticket per player, at most a room's worth", http.StatusBadRequest)
+		return
+	}
+	if req.EventSecret != "" && len(req.EventSecret) < config.EventSecretMinLen {
+		http.Error(w, fmt.Sprintf("invalid allocation: event secrets have at least %d bytes", config.EventSecretMinLen), http.StatusBadRequest)
+		return
+	}
+	t := s.tenants[req.Tenant]
+	if t == nil {
+		http.Error(w, "tenant not found", http.StatusNotFound)
+		return
+	}
+	if s.draining.Load() {
+		http.Error(w, "server draining", http.StatusServiceUnavailable)
+		return
+	}
+
+	expires := time.Now().Add(config.AllocationTTL)
+	room := t.matchmaker.ReserveRoom(expires)
+	if room == nil {
+		http.Error(w, "no room available", http.StatusServiceUnavailable)
+		return
+	}
+
+	if req.EventSecret != "" {
+		s.events.set(room.ID, []byte(req.EventSecret))
+	}
+
+	resp := allocationResponse{
+		Room:       room.ID,
+		Instance:   s.config.InstanceID,
+		PublicAddr: s.config.PublicAddr,
+		Expires:    expires.UTC(),
+		Tickets:    make(map[string]string, len(req.Tickets)),
+	}
+	for _, ticket := range req.Tickets {
+		issued, err := s.tokens.Issue(token.PurposeTicket, room.ID, ticket, config.AllocationTTL)
+		if err != nil {
+			t.matchmaker.RemoveRoom(room.ID)
+			http.Error(w, "failed to issue tickets", http.StatusInternalServerError)
+			return
+		}
+		resp.Tickets[ticket] = issued
+	}
+
+	if s.agones != nil {
+		if err := s.agones.Allocate(); err != nil {
+			log.Printf("Failed to report the allocation to Agones: %v", err)
+		}
+	}
+
+	kind := "match"
+	if req.EventSecret != "" {
+		kind = "tournament match"
+	}
+	log.Printf("Room %s reserved for a %s of %d players", room.ID, kind, len(req.Tickets))
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// ticketRoom returns the reserved room a /ws?ticket= token is for, or "" if
+// the request carries no ticket
+func (s *GameServer) ticketRoom(r *http.Request) (string, error) {
+	ticket := r.URL.Query().Get("ticket")
+	if ticket == "" {
+		return "", nil
+	}
+	// Tickets may be presented again until they expire, so a player whose
+	// connection drops can rejoin the match
+	claims, err := s.tokens.Verify(token.PurposeTicket, ticket)
+	if err != nil {
+		return "", err
+	}
+	return claims.Subject, nil
+}
+
+// agonesLoop marks the server Ready, then sends health pings until shutdown.
+// Not paused by hibernation: Agones restarts servers that stop pinging.
+func (s *GameServer) agonesLoop() {
+	ticker := time.NewTicker(config.AgonesHealthInterval)
+	defer ticker.Stop()
+
+	ready := false
+	for {
+		if !ready {
+			if err := s.agones.Ready(); err != nil {
+				log.Printf("Failed to mark the server Ready in Agones: %v", err)
+			} else {
+				ready = true
+				log.Printf("Agones: server Ready")
+			}
+		}
+		if err := s.agones.Health(); err != nil {
+			log.Printf("Agones health ping failed: %v", err)
+		}
+
+		select {
+		case <-s.quit:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/race/server/internal/leaderboard"
+)
+
+// Public API
+//
+// JSON endpoints for websites and clients:
+//   GET /api/leaderboard       - current season and its top entries (?limit=N)
+//   GET /api/seasons           - archived seasons, newest first
+//   GET /api/seasons/{id}      - final standings and rewards of a season
+//   /api/tracks...             - custom tracks from the map editor (see tracks.go)
+//   GET /api/players/{name}    - a player's profile (see players.go)
+//   GET /api/servers           - the cluster directory (see lifecycle.go)
+//   GET /api/route             - the server to connect to (see lifecycle.go)
+//   GET /api/cosmetics         - the cosmetics catalog (see cosmetics.go)
+//   GET /api/certification     - the results signing key (see certification.go)
+//   GET /api/matches/{id}      - a certified match (see certification.go)
+//   GET /api/payload-key       - the payload encryption key (see encryption.go)
+//   GET /api/clips/{id}        - a highlight clip (see clips.go)
+//
+// The leaderboard and season endpoints serve the default tenant's board, or
+// another tenant's with ?tenant=<key> (see tenants.go).
+
+// registerAPIRoutes registers the public API endpoints.
+func (s *GameServer) registerAPIRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/api/leaderboard", s.handleLeaderboard)
+	mux.HandleFunc("/api/seasons", s.handleSeasons)
+	mux.HandleFunc("/api/seasons/", s.handleSeason)
+	mux.HandleFunc("/api/tracks", s.handleTracks)
+	mux.HandleFunc("/api/tracks/", s.handleTrack)
+	mux.HandleFunc("/api/players/", s.handlePlayer)
+	mux.HandleFunc("/api/servers", s.handleServers)
+	mux.HandleFunc("/api/route", s.handleRoute)
+	mux.HandleFunc("/api/cosmetics", s.handleCosmetics)
+	mux.HandleFunc("/api/certification", s.handleCertification)
+	mux.HandleFunc("/api/matches/", s.handleMatch)
+	mux.HandleFunc("/api/payload-key", s.handlePayloadKey)
+	mux.HandleFunc("/api/clips/", s.handleClipGet)
+}
+
+// handleLeaderboard returns the live board of the current season.
+func (s *GameServer) handleLeaderboard(w http.ResponseWriter, r *http.Request) {
+	t := s.tenantOf(r)
+	if t == nil {
+		http.Error(w, "tenant not found", http.StatusNotFound)
+		return
+	}
+	limit := 100
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= 1000 {
+		limit = l
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"season":  t.leaderboard.Season(),
+		"entries": t.leaderboard.Top(limit),
+	})
+}
+
+// handleSeasons lists archived seasons.
+func (s *GameServer) handleSeasons(w http.ResponseWriter, r *http.Request) {
+	t := s.tenantOf(r)
+	if t == nil {
+		http.Error(w, "tenant not found", http.StatusNotFound)
+		return
+	}
+	seasons, err := t.leaderboard.Seasons()
+	if err != nil {
+		http.Error(w, "failed to list seasons", http.StatusInternalServerError)
+		return
+	}
+	if seasons == nil {
+		seasons = []leaderboard.Season{}
+	}
+	writeJSON(w, http.StatusOK, seasons)
+}
+
+// handleSeason returns a single archived season.
+func (s *GameServer) handleSeason(w http.ResponseWriter, r *http.Request) {
+	t := s.tenantOf(r)
+	if t == nil {
+		http.Error(w, "tenant not found", http.StatusNotFound)
+		return
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/seasons/"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid season id", http.StatusBadRequest)
+		return
+	}
+
+	season, err := t.leaderboard.ArchivedSeason(id)
+	if errors.Is(err, leaderboard.ErrSeasonNotFound) {
+		http.Error(w, "season not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to load season", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, season)
+}
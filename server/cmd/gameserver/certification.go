@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/certify"
+	"github.com/race/server/internal/storage"
+)
+
+// Results certification
+//
+//   GET /api/certification   - the public key results are signed with
+//   GET /api/matches/{id}    - a stored match with its certificate
+//
+// Sites that show rounds and scores from the API can check they weren't
+// fabricated: every stored match and every leaderboard entry carries a
+// certificate (see internal/certify) signed with the server's results key,
+// RESULTS_SIGNING_KEY. A match certificate covers the whole round, including
+// its inputs digest (see internal/game/round.go) and its ID, which names the
+// match stored here for as long as the match history keeps it. A leaderboard
+// entry's certificate covers its board, season, name, score and time.
+//
+// The key should be the same on every server of a cluster; without one each
+// server signs with a random key, and its certificates can't be checked
+// once it restarts.
+
+// newSigner returns the results signer of the config
+func newSigner(cfg *config.ServerConfig) *certify.Signer {
+	if cfg.ResultsSigningKey == "" {
+		if cfg.StoreBackend != storage.BackendMemory {
+			log.Printf("RESULTS_SIGNING_KEY not set: results certificates will only verify with this server's key")
+		}
+		return certify.RandomSigner()
+	}
+	signer, err := certify.NewSigner(cfg.ResultsSigningKey)
+	if err != nil {
+		log.Fatalf("Invalid RESULTS_SIGNING_KEY: %v", err)
+	}
+	return signer
+}
+
+// handleCertification returns the public key certificates are verified with.
+func (s *GameServer) handleCertification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.signer.PublicKey())
+}
+
+// handleMatch returns a stored match.
+func (s *GameServer) handleMatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/api/matches/")
+	if id == "" || len(id) > 32 || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	m, found, err := s.history.Get(id)
+	if err != nil {
+		http.Error(w, "failed to load match", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "match not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/race/server/config"
+)
+
+// Client addresses
+//
+// Kick cooldowns, fingerprints and the connection limits key on the
+// client's address. By default it is the peer's: a client that connects
+// directly could put any address in X-Real-IP or X-Forwarded-For and
+// dodge them all. Behind a reverse proxy every peer is the proxy, so
+// TRUST_PROXY_HEADERS=true takes the address from those headers instead,
+// but only of connections from TRUSTED_PROXIES (loopback by default, where
+// the bundled nginx runs). X-Forwarded-For is read from the right: the
+// first address that isn't a trusted proxy's is the one the proxies saw
+// connect, and anything left of it came from the client.
+
+// newTrustedProxies returns the networks of the proxies whose headers are
+// believed, nil if none are
+func newTrustedProxies(cfg *config.ServerConfig) []netip.Prefix {
+	if !cfg.TrustProxyHeaders {
+		return nil
+	}
+	var proxies []netip.Prefix
+	for _, cidr := range strings.Split(cfg.TrustedProxies, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies
+}
+
+// trustedProxy reports whether addr is one of the trusted proxies'
+func (s *GameServer) trustedProxy(addr string) bool {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, prefix := range s.proxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP extracts the client's IP address from the request: the peer's,
+// or the one a trusted proxy passed on
+func (s *GameServer) clientIP(r *http.Request) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	if !s.trustedProxy(peer) {
+		return peer
+	}
+
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+		return ip
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		if hop := strings.TrimSpace(hops[i]); hop != "" && !s.trustedProxy(hop) {
+			return hop
+		}
+	}
+	return peer
+}
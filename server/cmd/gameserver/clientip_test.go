@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/race/server/config"
+)
+
+// TestClientIP checks whose address a connection is keyed on: the peer's,
+// unless the peer is a trusted proxy
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		trust   bool
+		remote  string
+		realIP  string
+		forward string
+		want    string
+	}{
+		{name: "direct", remote: "198.51.100.4:5000", want: "198.51.100.4"},
+		{name: "direct spoofing, trust off", remote: "198.51.100.4:5000", realIP: "192.0.2.1", forward: "192.0.2.2", want: "198.51.100.4"},
+		{name: "proxy, trust off", remote: "127.0.0.1:5000", realIP: "203.0.113.7", want: "127.0.0.1"},
+		{name: "direct spoofing", trust: true, remote: "198.51.100.4:5000", realIP: "192.0.2.1", forward: "192.0.2.2", want: "198.51.100.4"},
+		{name: "proxy", trust: true, remote: "127.0.0.1:5000", realIP: "203.0.113.7", want: "203.0.113.7"},
+		{name: "IPv6 proxy", trust: true, remote: "[::1]:5000", realIP: "203.0.113.7", want: "203.0.113.7"},
+		{name: "forwarded", trust: true, remote: "127.0.0.1:5000", forward: "192.0.2.1, 203.0.113.7, 127.0.0.2", want: "203.0.113.7"},
+		{name: "proxy without headers", trust: true, remote: "127.0.0.1:5000", want: "127.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultServerConfig()
+			cfg.TrustProxyHeaders = tt.trust
+			s := &GameServer{config: cfg, proxies: newTrustedProxies(cfg)}
+
+			r := httptest.NewRequest("GET", "/ws", nil)
+			r.RemoteAddr = tt.remote
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if tt.forward != "" {
+				r.Header.Set("X-Forwarded-For", tt.forward)
+			}
+			if got := s.clientIP(r); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
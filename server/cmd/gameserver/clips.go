@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/network"
+)
+
+// Highlight clips
+//
+//   GET /api/clips/{id} - a saved clip, as JSON
+//
+// A player in a room (protocol v36) asks for a clip of the last
+// config.ClipLength with a Clip message. The room cuts its recent frames
+// down to the player's vicinity (see game/clip.go) and a store writer (see
+// writer.go) saves the clip under "clip:<id>" for config.ClipTTL, then
+// answers with a ClipSaved message of the ID, or an error if the write
+// failed or the writers were backed up. Clips have their own rate limit
+// (config.ClipRequestRate); /stats counts them (clipsSaved).
+
+// storeClip is the store writer kind of a clip
+const storeClip = "clip"
+
+// clipIDLen is the length of a clip ID in hex digits
+const clipIDLen = 24
+
+// handleClip saves a clip of the player's vicinity.
+func (c *ClientConnection) handleClip(m *message) {
+	clip, err := m.room.Clip(m.player.ID)
+	if err != nil {
+		c.Send(c.server.protocol.EncodeError(network.ErrorCodeNotAllowed, err.Error()))
+		return
+	}
+
+	s, id := c.server, newClipID()
+	queued := s.writer.submit(storeClip, func() error {
+		data, err := json.Marshal(clip)
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err = s.store.Set(ctx, clipKey(id), data, config.ClipTTL)
+		}
+		if err != nil {
+			c.Send(s.protocol.EncodeError(network.ErrorCodeServerError, "Failed to save the clip"))
+			return fmt.Errorf("clip %s of %s: %w", id, clip.Player, err)
+		}
+		s.metrics.clipsSaved.Add(1)
+		c.Send(s.protocol.EncodeClipSaved(id))
+		log.Printf("Saved clip %s of %s in room %s (%d frames)", id, clip.Player, clip.Room, len(clip.Frames))
+		return nil
+	})
+	if !queued {
+		c.Send(s.protocol.EncodeError(network.ErrorCodeServerError, "Server busy, try again"))
+	}
+}
+
+// handleClipGet serves a saved clip.
+func (s *GameServer) handleClipGet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/api/clips/")
+	if len(id) != clipIDLen || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	data, ok, err := s.store.Get(ctx, clipKey(id))
+	if err != nil {
+		http.Error(w, "failed to load clip", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "clip not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// newClipID returns the retrieval ID of a new clip
+func newClipID() string {
+	b := make([]byte, clipIDLen/2)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// clipKey returns the store key of a clip
+func clipKey(id string) string {
+	return "clip:" + id
+}
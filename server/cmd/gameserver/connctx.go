@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+)
+
+// Connection context
+//
+//   GET /admin/connections - open connections and their metadata (admin token)
+//
+// Every connection has a context from the WebSocket upgrade until it closes,
+// carrying its metadata (network.ConnInfo): an ID that tags its log lines,
+// the account it plays under, the negotiated protocol and the client's
+// locale. cleanup cancels the context: both pumps stop, and work done on the
+// connection's behalf, like the moderation API check and track lookup of a
+// join, is abandoned instead of running out its timeout for a client that is
+// gone. Work that must outlive the connection, like recording a kick, doesn't
+// use it.
+
+// connSnapshot is a connection in /admin/connections
+type connSnapshot struct {
+	ID          uint64 `json:"id"`
+	IP          string `json:"ip"`
+	Account     string `json:"account,omitempty"`
+	Version     uint8  `json:"version"`
+	Subprotocol string `json:"subprotocol,omitempty"`
+	Locale      string `json:"locale,omitempty"`
+	Tenant      string `json:"tenant,omitempty"`
+}
+
+// handleAdminConnections lists the open connections, oldest first.
+func (s *GameServer) handleAdminConnections(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.connMu.Lock()
+	conns := make([]connSnapshot, 0, len(s.connections))
+	for conn := range s.connections {
+		conns = append(conns, connSnapshot{
+			ID:          conn.info.ID,
+			IP:          conn.info.IP,
+			Account:     conn.info.Account(),
+			Version:     conn.info.Version(),
+			Subprotocol: conn.info.Subprotocol,
+			Locale:      conn.info.Locale,
+			Tenant:      conn.tenant.key,
+		})
+	}
+	s.connMu.Unlock()
+
+	sort.Slice(conns, func(i, j int) bool { return conns[i].ID < conns[j].ID })
+	writeJSON(w, http.StatusOK, map[string]interface{}{"connections": conns})
+}
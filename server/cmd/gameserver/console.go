@@ -0,0 +1,673 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/race/server/internal/game"
+	"github.com/race/server/internal/routines"
+)
+
+// Server console
+//
+// With CONSOLE set, operators type commands to the running server: inspect
+// rooms, watch a car live or a whole room tick by tick, spawn bots, change
+// settings and trigger events (explosions, kicks, the end of a round).
+// CONSOLE=stdin reads the terminal the server runs in, for development; any
+// other value is the path of a unix socket (mode 0600) that takes any number
+// of sessions, e.g.
+//
+//	socat READLINE UNIX-CONNECT:/run/vracer/console.sock
+//
+// The socket is only reachable by local users allowed to open it, so unlike
+// the admin API it takes no token. Room IDs may be shortened to a unique
+// prefix. Type "help" for the commands.
+
+// consoleWatchRate is the default rate of the watch command (Hz)
+const consoleWatchRate = 5
+
+// consoleInspectBuffer is how many frames (about a second at 60 Hz) the
+// inspect command's output may fall behind before the room drops frames
+const consoleInspectBuffer = 64
+
+// consoleCommand is a console command
+type consoleCommand struct {
+	name  string
+	usage string // Arguments
+	help  string
+	run   func(c *consoleSession, args []string) error
+}
+
+var consoleCommands = []consoleCommand{
+	{"stats", "", "rooms, players, connections, simulation load and trends", (*consoleSession).stats},
+	{"rooms", "", "list the rooms of every tenant", (*consoleSession).rooms},
+	{"room", "<room>", "a room's config and cars", (*consoleSession).room},
+	{"watch", "<room> <player> [hz]", "print a car's state live until Enter", (*consoleSession).watch},
+	{"inspect", "<room> [player]", "every physics tick with anti-cheat internals as JSON lines until Enter", (*consoleSession).inspect},
+	{"snapshot", "<room>", "a room's full state as JSON", (*consoleSession).snapshot},
+	{"bot", "<room> <profile>", "add a bot", (*consoleSession).bot},
+	{"unbot", "<room> <bot>", "remove a bot", (*consoleSession).unbot},
+	{"ghost", "<room> <player> [seconds]", "turn a car's collisions off (no seconds: until unghost)", (*consoleSession).ghost},
+	{"unghost", "<room> <player>", "turn a car's collisions back on", (*consoleSession).unghost},
+	{"explode", "<room> <player>", "blow up a car", (*consoleSession).explode},
+	{"kick", "<room> <player> [reason]", "kick a player (starts a rejoin cooldown)", (*consoleSession).kick},
+	{"endround", "<room>", "end a room's round now and hand out the awards", (*consoleSession).endRound},
+	{"rate", "<room> <hz>", "change a room's broadcast rate", (*consoleSession).rate},
+	{"config", "", "settings of new rooms", (*consoleSession).config},
+	{"set", "<setting> <value>", "change a setting of new rooms (see config)", (*consoleSession).set},
+}
+
+// consoleServer runs the console's sessions
+type consoleServer struct {
+	mu       sync.Mutex
+	listener net.Listener       // nil for stdin
+	sessions map[io.Closer]bool // Open socket sessions
+	closed   bool
+}
+
+// startConsole opens the console configured by CONSOLE
+func (s *GameServer) startConsole() error {
+	s.console = &consoleServer{sessions: make(map[io.Closer]bool)}
+	if s.config.Console == "stdin" {
+		routines.Go("console.session", func() {
+			s.runConsole(os.Stdin, os.Stdout)
+		})
+		log.Printf("Console reading stdin")
+		return nil
+	}
+
+	// A socket left behind by a previous run would make Listen fail
+	if info, err := os.Lstat(s.config.Console); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(s.config.Console)
+	}
+	listener, err := net.Listen("unix", s.config.Console)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(s.config.Console, 0o600); err != nil {
+		listener.Close()
+		return err
+	}
+	s.console.listener = listener
+	log.Printf("Console listening on %s", s.config.Console)
+
+	routines.Go("console.listen", func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return // Closed by closeConsole
+			}
+			if !s.console.track(conn) {
+				conn.Close()
+				return
+			}
+			routines.Go("console.session", func() {
+				defer s.console.untrack(conn)
+				s.runConsole(conn, conn)
+			})
+		}
+	})
+	return nil
+}
+
+// closeConsole stops accepting console sessions and ends the open ones.
+// A stdin session ends with the process.
+func (s *GameServer) closeConsole() {
+	if s.console == nil {
+		return
+	}
+	cs := s.console
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cs.closed = true
+	if cs.listener != nil {
+		cs.listener.Close() // Removes the socket file
+	}
+	for conn := range cs.sessions {
+		conn.Close()
+	}
+}
+
+func (cs *consoleServer) track(conn io.Closer) bool {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if cs.closed {
+		return false
+	}
+	cs.sessions[conn] = true
+	return true
+}
+
+func (cs *consoleServer) untrack(conn io.Closer) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	delete(cs.sessions, conn)
+	conn.Close()
+}
+
+// consoleSession is one operator's console
+type consoleSession struct {
+	s     *GameServer
+	out   io.Writer
+	lines <-chan string // Input lines, closed at EOF
+}
+
+// runConsole reads commands from in until EOF, writing the output to out
+func (s *GameServer) runConsole(in io.Reader, out io.Writer) {
+	lines := make(chan string)
+	routines.Go("console.input", func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	})
+
+	c := &consoleSession{s: s, out: out, lines: lines}
+	fmt.Fprintf(out, "Vector Racer console (%s), type help for the commands\n> ", s.config.InstanceID)
+	for line := range lines {
+		if err := c.exec(strings.Fields(line)); err != nil {
+			fmt.Fprintf(out, "error: %v\n", err)
+		}
+		fmt.Fprint(out, "> ")
+	}
+}
+
+// exec runs a command line
+func (c *consoleSession) exec(fields []string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	name, args := fields[0], fields[1:]
+	if name == "help" {
+		c.help()
+		return nil
+	}
+	for _, cmd := range consoleCommands {
+		if cmd.name == name {
+			if err := cmd.run(c, args); errors.Is(err, errConsoleUsage) {
+				return fmt.Errorf("usage: %s %s", cmd.name, cmd.usage)
+			} else if err != nil {
+				return err
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown command %q, type help for the commands", name)
+}
+
+var errConsoleUsage = errors.New("usage")
+
+func (c *consoleSession) help() {
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	for _, cmd := range consoleCommands {
+		fmt.Fprintf(w, "%s %s\t%s\n", cmd.name, cmd.usage, cmd.help)
+	}
+	w.Flush()
+}
+
+func (c *consoleSession) stats(args []string) error {
+	stats := c.s.roomStats()
+	sim := c.s.scheduler.Stats()
+	fmt.Fprintf(c.out, "rooms %d (%d practice, %d reserved), players %d, connections %d\n",
+		stats.TotalRooms, stats.PracticeRooms, c.s.reservedRooms(), stats.TotalPlayers, c.s.connectionCount())
+	fmt.Fprintf(c.out, "simulation: %d workers, %d rooms (%d suspended), %.0f%% load, %d late ticks\n",
+		sim.Workers, sim.Rooms, sim.Parked, sim.Load*100, sim.Late)
+
+	windows := c.s.trends.windows(c.s.trendSample())
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "WINDOW\tPLAYERS avg/max\tROOMS avg/max\tJOINS/s\tKICKS\tMESSAGES/s\tDROPPED/s")
+	for _, tw := range trendWindows {
+		t := windows[tw.name]
+		fmt.Fprintf(w, "%s\t%.1f/%d\t%.1f/%d\t%.2f\t%d\t%.1f\t%.1f\n", tw.name,
+			t.Players.Avg, t.Players.Max, t.Rooms.Avg, t.Rooms.Max, t.JoinsPerSec, t.Kicks, t.MessagesPerSec, t.DroppedPerSec+t.ThrottledPerSec)
+	}
+	return w.Flush()
+}
+
+func (c *consoleSession) rooms(args []string) error {
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TENANT\tROOM\tPLAYERS\tKIND\tTRACK\tSTATE")
+	for _, key := range c.s.tenantKeys() {
+		for _, rs := range c.s.tenants[key].matchmaker.GetStats().Rooms {
+			kind := "public"
+			switch {
+			case rs.Tutorial:
+				kind = "tutorial"
+			case rs.Practice:
+				kind = "practice"
+			case rs.Reserved:
+				kind = "reserved"
+			case rs.Private:
+				kind = "private"
+			}
+			state := "running"
+			if rs.Suspended {
+				state = "suspended"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d/%d\t%s\t%s\t%s\n", tenantLabel(key), rs.ID, rs.PlayerCount, rs.MaxPlayers, kind, orDash(rs.Track), state)
+		}
+	}
+	return w.Flush()
+}
+
+func (c *consoleSession) room(args []string) error {
+	if len(args) != 1 {
+		return errConsoleUsage
+	}
+	room, err := c.findRoom(args[0])
+	if err != nil {
+		return err
+	}
+
+	cfg := room.Config()
+	fmt.Fprintf(c.out, "room %s: tick %d, physics %d Hz, broadcast %d Hz, track %s, suspended %v\n",
+		room.ID, room.Tick(), cfg.PhysicsTickRate, cfg.BroadcastRate, orDash(room.Track().Label()), room.Suspended())
+
+	bots := make(map[uint16]string)
+	for _, b := range room.Bots() {
+		bots[b.ID] = b.Profile
+	}
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tX\tY\tSPEED\tRATING\tSTATE\tDRIVER")
+	for _, p := range room.PlayerStates() {
+		who := "human"
+		if profile, ok := bots[p.ID]; ok {
+			who = "bot " + profile
+		}
+		if p.ID == room.HostID() {
+			who += ", host"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%.0f\t%.0f\t%.0f\t%.0f\t%s\t%s\n", p.ID, p.Name, p.X, p.Y, p.Speed, p.Rating, carFlags(p), who)
+	}
+	return w.Flush()
+}
+
+func (c *consoleSession) watch(args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return errConsoleUsage
+	}
+	room, id, err := c.findPlayer(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	rate := consoleWatchRate
+	if len(args) == 3 {
+		if rate, err = strconv.Atoi(args[2]); err != nil || rate < 1 || rate > room.Config().PhysicsTickRate {
+			return fmt.Errorf("rate must be 1-%d Hz", room.Config().PhysicsTickRate)
+		}
+	}
+
+	ticker := time.NewTicker(time.Second / time.Duration(rate))
+	defer ticker.Stop()
+	fmt.Fprintf(c.out, "watching player %d in room %s at %d Hz, press Enter to stop\n", id, room.ID, rate)
+	for {
+		state, ok := playerState(room, id)
+		if !ok {
+			fmt.Fprintln(c.out, "player left")
+			return nil
+		}
+		fmt.Fprintf(c.out, "tick %-8d x %7.1f  y %9.1f  speed %6.1f  angle %5.2f  vel %6.1f,%6.1f  rating %6.1f %s\n",
+			room.Tick(), state.X, state.Y, state.Speed, state.Angle, state.VelX, state.VelY, state.Rating, carFlags(state))
+
+		select {
+		case <-ticker.C:
+		case <-c.lines:
+			return nil // Enter, or the session ended
+		}
+	}
+}
+
+func (c *consoleSession) inspect(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errConsoleUsage
+	}
+	var room *game.Room
+	var only uint16 // 0: every car
+	var err error
+	if len(args) == 2 {
+		room, only, err = c.findPlayer(args[0], args[1])
+	} else {
+		room, err = c.findRoom(args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	frames, stop := room.Inspect(consoleInspectBuffer)
+	defer stop()
+	fmt.Fprintf(c.out, "inspecting room %s at every physics tick, press Enter to stop\n", room.ID)
+	enc := json.NewEncoder(c.out)
+	for {
+		select {
+		case f, ok := <-frames:
+			if !ok {
+				fmt.Fprintln(c.out, "room stopped")
+				return nil
+			}
+			if only != 0 {
+				f.Cars = slices.DeleteFunc(f.Cars, func(car game.InspectCar) bool { return car.ID != only })
+				f.Verdicts = slices.DeleteFunc(f.Verdicts, func(v game.InspectVerdict) bool { return v.ID != only })
+			}
+			if err := enc.Encode(f); err != nil {
+				return err
+			}
+		case <-c.lines:
+			return nil // Enter, or the session ended
+		}
+	}
+}
+
+func (c *consoleSession) snapshot(args []string) error {
+	if len(args) != 1 {
+		return errConsoleUsage
+	}
+	room, err := c.findRoom(args[0])
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(room.Snapshot(), "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "%s\n", data)
+	return nil
+}
+
+func (c *consoleSession) bot(args []string) error {
+	if len(args) != 2 {
+		return errConsoleUsage
+	}
+	room, err := c.findRoom(args[0])
+	if err != nil {
+		return err
+	}
+	profile, ok := c.s.botProfiles[args[1]]
+	if !ok {
+		names := make([]string, 0, len(c.s.botProfiles))
+		for name := range c.s.botProfiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown bot profile %q (%s)", args[1], strings.Join(names, ", "))
+	}
+	bot, err := room.AddBot(profile)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "added %s (ID %d)\n", bot.Name, bot.ID)
+	return nil
+}
+
+func (c *consoleSession) unbot(args []string) error {
+	if len(args) != 2 {
+		return errConsoleUsage
+	}
+	room, id, err := c.findPlayer(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	return room.RemoveBot(id)
+}
+
+func (c *consoleSession) ghost(args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return errConsoleUsage
+	}
+	room, id, err := c.findPlayer(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	var d time.Duration
+	if len(args) == 3 {
+		seconds, err := strconv.ParseFloat(args[2], 64)
+		if err != nil || seconds < 0 {
+			return errConsoleUsage
+		}
+		d = time.Duration(seconds * float64(time.Second))
+	}
+	return room.SetGhost(id, game.GhostAdmin, d)
+}
+
+func (c *consoleSession) unghost(args []string) error {
+	if len(args) != 2 {
+		return errConsoleUsage
+	}
+	room, id, err := c.findPlayer(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	return room.ClearGhost(id, game.GhostAdmin)
+}
+
+func (c *consoleSession) explode(args []string) error {
+	if len(args) != 2 {
+		return errConsoleUsage
+	}
+	room, id, err := c.findPlayer(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	return room.Explode(id)
+}
+
+func (c *consoleSession) kick(args []string) error {
+	if len(args) < 2 {
+		return errConsoleUsage
+	}
+	room, id, err := c.findPlayer(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	reason := "Kicked by an operator"
+	if len(args) > 2 {
+		reason = strings.Join(args[2:], " ")
+	}
+	return room.Kick(id, reason)
+}
+
+func (c *consoleSession) endRound(args []string) error {
+	if len(args) != 1 {
+		return errConsoleUsage
+	}
+	room, err := c.findRoom(args[0])
+	if err != nil {
+		return err
+	}
+	if err := room.EndRound(); err != nil {
+		return err
+	}
+	if room.Suspended() {
+		fmt.Fprintln(c.out, "the room is suspended: the round ends when it resumes")
+	}
+	return nil
+}
+
+func (c *consoleSession) rate(args []string) error {
+	if len(args) != 2 {
+		return errConsoleUsage
+	}
+	room, err := c.findRoom(args[0])
+	if err != nil {
+		return err
+	}
+	rate, err := strconv.Atoi(args[1])
+	if err != nil {
+		return errConsoleUsage
+	}
+	return room.SetBroadcastRate(rate)
+}
+
+func (c *consoleSession) config(args []string) error {
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TENANT\tphysics-rate\tbroadcast-rate\trebase-distance\tsuspend-empty\tsequence-mode")
+	for _, key := range c.s.tenantKeys() {
+		mm := c.s.tenants[key].matchmaker
+		cfg := mm.RoomConfig()
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.0f\t%v\t%v\n", tenantLabel(key),
+			cfg.PhysicsTickRate, cfg.BroadcastRate, cfg.RebaseDistance, mm.SuspendEmptyRooms(), mm.SequenceMode())
+	}
+	return w.Flush()
+}
+
+// set changes a setting of every tenant's new rooms. Running rooms keep
+// theirs (see rate for the broadcast rate of a running room).
+func (c *consoleSession) set(args []string) error {
+	if len(args) != 2 {
+		return errConsoleUsage
+	}
+	key, value := args[0], args[1]
+
+	var apply func(t *tenant) error
+	switch key {
+	case "physics-rate", "broadcast-rate", "rebase-distance":
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a number", key, value)
+		}
+		apply = func(t *tenant) error {
+			cfg := t.matchmaker.RoomConfig()
+			switch key {
+			case "physics-rate":
+				cfg.PhysicsTickRate = int(n)
+			case "broadcast-rate":
+				cfg.BroadcastRate = int(n)
+			default:
+				cfg.RebaseDistance = n
+			}
+			if problems := validateRoomConfig(key, cfg); len(problems) > 0 {
+				return problems[0]
+			}
+			return t.matchmaker.SetRoomConfig(cfg)
+		}
+	case "suspend-empty":
+		suspend, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not true or false", key, value)
+		}
+		apply = func(t *tenant) error {
+			t.matchmaker.SetSuspendEmptyRooms(suspend)
+			return nil
+		}
+	case "sequence-mode":
+		mode, err := game.ParseSequenceMode(value)
+		if err != nil {
+			return err
+		}
+		apply = func(t *tenant) error {
+			t.matchmaker.SetSequenceMode(mode)
+			return nil
+		}
+	default:
+		return fmt.Errorf("unknown setting %q (physics-rate, broadcast-rate, rebase-distance, suspend-empty, sequence-mode)", key)
+	}
+
+	for _, k := range c.s.tenantKeys() {
+		if err := apply(c.s.tenants[k]); err != nil {
+			return fmt.Errorf("tenant %s: %w", tenantLabel(k), err)
+		}
+	}
+	log.Printf("Console: %s set to %s for new rooms", key, value)
+	return nil
+}
+
+// findRoom looks a room up by ID or a unique prefix of it
+func (c *consoleSession) findRoom(prefix string) (*game.Room, error) {
+	if _, room := c.s.findRoom(prefix); room != nil {
+		return room, nil
+	}
+	var found *game.Room
+	for _, t := range c.s.tenants {
+		for _, rs := range t.matchmaker.GetStats().Rooms {
+			if !strings.HasPrefix(rs.ID, prefix) {
+				continue
+			}
+			if found != nil {
+				return nil, fmt.Errorf("room %q is ambiguous", prefix)
+			}
+			found = t.matchmaker.GetRoom(rs.ID)
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("room %q not found", prefix)
+	}
+	return found, nil
+}
+
+// findPlayer looks a room up and parses a player ID in it
+func (c *consoleSession) findPlayer(roomPrefix, player string) (*game.Room, uint16, error) {
+	room, err := c.findRoom(roomPrefix)
+	if err != nil {
+		return nil, 0, err
+	}
+	id, err := strconv.ParseUint(player, 10, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid player ID %q", player)
+	}
+	if _, ok := playerState(room, uint16(id)); !ok {
+		return nil, 0, game.ErrPlayerNotFound
+	}
+	return room, uint16(id), nil
+}
+
+// playerState returns the state of a player of the room
+func playerState(room *game.Room, id uint16) (game.PlayerState, bool) {
+	for _, state := range room.PlayerStates() {
+		if state.ID == id {
+			return state, true
+		}
+	}
+	return game.PlayerState{}, false
+}
+
+// carFlags describes a car's exploded and ghost state
+func carFlags(p game.PlayerState) string {
+	var flags []string
+	if p.Exploded {
+		flags = append(flags, "exploded")
+	}
+	if p.Ghost {
+		flags = append(flags, "ghost")
+	}
+	return strings.Join(flags, " ")
+}
+
+// tenantKeys returns the tenant keys, the default tenant first
+func (s *GameServer) tenantKeys() []string {
+	keys := make([]string, 0, len(s.tenants))
+	for key := range s.tenants {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func tenantLabel(key string) string {
+	if key == "" {
+		return "default"
+	}
+	return key
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/race/server/internal/cosmetics"
+	"github.com/race/server/internal/game"
+	"github.com/race/server/internal/network"
+	"github.com/race/server/internal/routines"
+)
+
+// Cosmetics
+//
+//   GET  /api/cosmetics                     - the catalog (kinds, names, wire IDs)
+//   GET  /admin/accounts/{name}/cosmetics   - what an account owns (admin token)
+//   POST /admin/accounts/{name}/cosmetics   - grant and revoke items (admin token)
+//
+// Cars can wear a trail and a decal besides their color (see
+// internal/cosmetics). The shop or account service grants items to
+// accounts through the admin API. Clients pick theirs in the join
+// (protocol v15); the server shows only what the player may use: free
+// items for everyone, granted ones for the account the connection proved
+// with a Link and only while the car plays under that name. Anything else
+// is dropped and counted in /stats as cosmeticsRefused. A guest's picks are
+// checked again when the connection links, so a player who logs in
+// mid-play gets their cosmetics without rejoining.
+
+// cosmeticsRequest is the body of POST /admin/accounts/{name}/cosmetics
+type cosmeticsRequest struct {
+	Grant  []string `json:"grant"` // Item keys ("trail/neon")
+	Revoke []string `json:"revoke"`
+}
+
+// cosmeticsResponse lists what an account owns
+type cosmeticsResponse struct {
+	Account string   `json:"account"`
+	Owned   []string `json:"owned"`
+}
+
+// joinLook returns the cosmetics a join asks for (none before ProtocolV15)
+func joinLook(version uint8, msg *network.JoinMessage) game.Appearance {
+	if version < network.ProtocolV15 || msg.Options&network.JoinCosmetics == 0 {
+		return game.Appearance{}
+	}
+	return game.Appearance{Trail: msg.Trail, Decal: msg.Decal}
+}
+
+// linkedAccountUnlocked returns the account the connection linked if the
+// player plays under its name, "" otherwise.
+// IMPORTANT: Caller must hold c.mu.
+func (c *ClientConnection) linkedAccountUnlocked(name string) string {
+	if c.account != "" && c.account == name {
+		return c.account
+	}
+	return ""
+}
+
+// dress shows the cosmetics of look that account ("" for a guest) may use
+// on the player's car. Ownership is read in the background so the join
+// never waits on the store.
+func (s *GameServer) dress(c *ClientConnection, room *game.Room, player *game.Player, look game.Appearance, account string) {
+	if look == (game.Appearance{}) {
+		return
+	}
+	routines.Go("server.cosmetics", func() {
+		allowed := game.Appearance{
+			Trail: s.allowCosmetic(c, account, cosmetics.KindTrail, look.Trail),
+			Decal: s.allowCosmetic(c, account, cosmetics.KindDecal, look.Decal),
+		}
+		room.SetAppearance(player.ID, allowed) // Gone: nothing to show
+	})
+}
+
+// allowCosmetic returns id if account may use the item, 0 otherwise
+func (s *GameServer) allowCosmetic(c *ClientConnection, account, kind string, id uint8) uint8 {
+	if id == 0 {
+		return 0
+	}
+	item, err := cosmetics.ByID(kind, id)
+	if err != nil {
+		s.metrics.cosmeticsRefused.Add(1)
+		return 0
+	}
+	owned, err := s.inventory.Owns(c.ctx, account, item)
+	if err != nil {
+		if c.ctx.Err() == nil {
+			log.Printf("Failed to check cosmetics of %s: %v", c.info, err)
+		}
+		return 0
+	}
+	if !owned {
+		s.metrics.cosmeticsRefused.Add(1)
+		return 0
+	}
+	return id
+}
+
+// handleCosmetics returns the catalog.
+func (s *GameServer) handleCosmetics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, cosmetics.Catalog)
+}
+
+// handleAdminAccount routes /admin/accounts/{name}/cosmetics and
+// /admin/accounts/{name}/privacy (see privacy.go).
+func (s *GameServer) handleAdminAccount(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/admin/accounts/")
+	account, section, ok := strings.Cut(path, "/")
+	if !ok || account == "" || len(account) > 255 || (section != "cosmetics" && section != "privacy") {
+		http.NotFound(w, r)
+		return
+	}
+	if section == "privacy" {
+		s.handleAdminPrivacy(w, r, account)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req cosmeticsRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+			http.Error(w, "invalid cosmetics request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		grant, err := lookupItems(req.Grant)
+		if err == nil {
+			var revoke []cosmetics.Item
+			if revoke, err = lookupItems(req.Revoke); err == nil {
+				err = s.updateInventory(r.Context(), account, grant, revoke)
+			}
+		}
+		if errors.Is(err, cosmetics.ErrUnknownItem) {
+			http.Error(w, "invalid cosmetics request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to update cosmetics of %s: %v", account, err)
+			http.Error(w, "failed to update cosmetics", http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	owned, err := s.inventory.Owned(r.Context(), account)
+	if err != nil {
+		log.Printf("Failed to read cosmetics of %s: %v", account, err)
+		http.Error(w, "failed to read cosmetics", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, cosmeticsResponse{Account: account, Owned: owned})
+}
+
+// lookupItems returns the catalog items of keys
+func lookupItems(keys []string) ([]cosmetics.Item, error) {
+	items := make([]cosmetics.Item, 0, len(keys))
+	for _, key := range keys {
+		item, err := cosmetics.Lookup(key)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// updateInventory grants and revokes items of an account
+func (s *GameServer) updateInventory(ctx context.Context, account string, grant, revoke []cosmetics.Item) error {
+	for _, item := range grant {
+		if err := s.inventory.Grant(ctx, account, item); err != nil {
+			return err
+		}
+	}
+	for _, item := range revoke {
+		if err := s.inventory.Revoke(ctx, account, item); err != nil {
+			return err
+		}
+	}
+	if len(grant)+len(revoke) > 0 {
+		log.Printf("Cosmetics of %s: granted %d, revoked %d", account, len(grant), len(revoke))
+	}
+	return nil
+}
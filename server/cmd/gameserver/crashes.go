@@ -0,0 +1,39 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/crash"
+)
+
+// Crash reports
+//
+// A panic in any of the server's goroutines is reported before it ends the
+// process (see internal/crash): to CRASH_REPORT_DSN, a Sentry-compatible
+// project DSN, or else as a JSON line appended to CRASH_REPORT_FILE
+// (crashes.jsonl in DATA_DIR). Reports carry the stack, the goroutine's
+// owner, the build and the context: a room's tick names the room, and a
+// message handler the connection, its account and the message type. The
+// same panic is reported once per config.CrashReportWindow however often
+// the supervisor restarts the server into it.
+
+// newCrashReporter returns the reporter of the config's sink
+func newCrashReporter(cfg *config.ServerConfig) *crash.Reporter {
+	var sink crash.Sink
+	if cfg.CrashReportDSN != "" {
+		sentry, err := crash.NewSentrySink(cfg.CrashReportDSN)
+		if err != nil {
+			log.Fatalf("Invalid CRASH_REPORT_DSN: %v", err)
+		}
+		sink = sentry
+	} else {
+		path := cfg.CrashReportFile
+		if path == "" {
+			path = filepath.Join(cfg.DataDir, "crashes.jsonl")
+		}
+		sink = crash.FileSink{Path: path}
+	}
+	return crash.NewReporter(sink, cfg.InstanceID, cfg.DataDir)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/game"
+	"github.com/race/server/internal/network"
+	"github.com/race/server/internal/token"
+)
+
+// Broadcast director
+//
+//   POST /admin/rooms/{id}/director - a spectate token for the room (admin token)
+//
+// Tournament broadcasts watch a room without a car: the production crew
+// gets a spectate token for the room and its broadcast client connects to
+// /ws?spectate=<token>. After Hello (protocol v16), any JoinRoom makes the
+// connection a spectator of that room (see game/director.go): it gets the
+// room's cars and state like a player, and Director messages naming the car
+// to follow. LeaveRoom stops watching. Tokens are valid for
+// config.SpectateTokenTTL and may be presented again, so a broadcast that
+// loses its connection comes back; a room takes at most
+// config.MaxSpectatorsPerRoom spectators, who are disconnected when the
+// room closes.
+
+// spectateResponse hands out a spectate token
+type spectateResponse struct {
+	Room    string    `json:"room"`
+	Expires time.Time `json:"expires"`
+	Token   string    `json:"token"` // For /ws?spectate=
+}
+
+// handleAdminDirector issues a spectate token for a room
+func (s *GameServer) handleAdminDirector(w http.ResponseWriter, r *http.Request, room *game.Room) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	issued, err := s.tokens.Issue(token.PurposeSpectate, room.ID, "", config.SpectateTokenTTL)
+	if err != nil {
+		http.Error(w, "failed to issue token", http.StatusInternalServerError)
+		return
+	}
+	expires := time.Now().Add(config.SpectateTokenTTL)
+	writeJSON(w, http.StatusCreated, spectateResponse{Room: room.ID, Expires: expires.UTC(), Token: issued})
+}
+
+// spectateRoom returns the room a /ws?spectate= token is for, or "" if the
+// request carries no spectate token
+func (s *GameServer) spectateRoom(r *http.Request) (string, error) {
+	spectate := r.URL.Query().Get("spectate")
+	if spectate == "" {
+		return "", nil
+	}
+	claims, err := s.tokens.Verify(token.PurposeSpectate, spectate)
+	if err != nil {
+		return "", err
+	}
+	return claims.Subject, nil
+}
+
+// watch makes the connection a spectator of its spectate token's room.
+func (c *ClientConnection) watch() {
+	room := c.tenant.matchmaker.GetRoom(c.spectate)
+	if room == nil {
+		c.Send(c.server.protocol.EncodeError(network.ErrorCodeRoomFull, "Room closed"))
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || c.watched == room {
+		return
+	}
+	if err := room.AddSpectator(c); err != nil {
+		code := network.ErrorCodeRoomFull
+		if err == game.ErrSpectateUnsupported {
+			code = network.ErrorCodeUnsupportedVersion
+		}
+		c.Send(c.server.protocol.EncodeError(code, err.Error()))
+		return
+	}
+	c.watched = room
+	log.Printf("%s spectating room %s", c.info, room.ID)
+}
@@ -0,0 +1,559 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/race/server/config"
+	"github.com/race/server/internal/network"
+	"github.com/race/server/internal/routines/routinestest"
+)
+
+// TestEndToEnd checks the game server over real WebSockets. It serves the
+// server's endpoints with httptest, connects two clients that speak the
+// binary protocol and drives them through the life of a race: handshake,
+// join, input, state updates, ping, explosion, respawn and leave, with a
+// third client speaking the JSON subprotocol on the side. Afterwards it
+// checks through /stats that the players are gone and that the process is
+// back to its baseline goroutine count, with no connection pumps left, and
+// finally that the server shuts down cleanly and its goroutines end.
+//
+//	go test ./cmd/gameserver -run EndToEnd -v
+func TestEndToEnd(t *testing.T) {
+	if testing.Short() {
+		t.Skip("drives a race for several seconds")
+	}
+	addr, shutdown := startServer(t)
+
+	baseline, err := fetchStats(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name string
+		fn   func(*scenario) error
+	}{
+		{"handshake", (*scenario).handshake},
+		{"join", (*scenario).join},
+		{"state", (*scenario).state},
+		{"drive", (*scenario).drive},
+		{"ping", (*scenario).ping},
+		{"json", (*scenario).json},
+		{"explode", (*scenario).explode},
+		{"respawn", (*scenario).respawn},
+		{"leave", (*scenario).leave},
+	}
+
+	s := &scenario{addr: addr}
+	defer s.close()
+	for _, step := range steps {
+		ok := t.Run(step.name, func(t *testing.T) {
+			if err := step.fn(s); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if !ok {
+			return
+		}
+	}
+	s.close()
+
+	// Not in subtests, whose goroutines would count against the baseline
+	if err := checkLeaks(addr, baseline); err != nil {
+		t.Fatalf("leaks: %v", err)
+	}
+	routinestest.CheckZero(t, "conn.read", "conn.write")
+	if err := shutdown(); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+}
+
+// messageTimeout bounds the wait for any single expected message
+const messageTimeout = 5 * time.Second
+
+// httpClient doesn't keep connections open, so idle connections don't show
+// up in the server's goroutine count
+var httpClient = &http.Client{
+	Timeout:   5 * time.Second,
+	Transport: &http.Transport{DisableKeepAlives: true},
+}
+
+// startServer serves a game server configured as by the environment, with
+// its data in a temporary directory and an in-memory store, on a local port.
+// Returns its address and a function that shuts it down, which the test's
+// cleanup calls too; only the first call shuts down. The test fails if any
+// of the server's goroutines outlive the shutdown.
+func startServer(t *testing.T) (string, func() error) {
+	t.Helper()
+	routinestest.CheckZeroAtCleanup(t) // Runs after the shutdown below
+	t.Setenv("DATA_DIR", t.TempDir())
+	t.Setenv("STORE_BACKEND", "memory")
+	cfg := loadConfig()
+	if problems := validateConfig(cfg); len(problems) > 0 {
+		t.Fatalf("invalid configuration: %v", problems)
+	}
+
+	server := NewGameServer(cfg)
+	handler, err := server.run()
+	if err != nil {
+		t.Fatalf("run server: %v", err)
+	}
+	ts := httptest.NewServer(handler)
+
+	var once sync.Once
+	var shutdownErr error
+	shutdown := func() error {
+		once.Do(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+			defer cancel()
+			shutdownErr = server.Shutdown(ctx)
+			ts.Close()
+		})
+		return shutdownErr
+	}
+	t.Cleanup(func() { shutdown() })
+	return ts.Listener.Addr().String(), shutdown
+}
+
+// stats is the part of /stats the leak check looks at
+type stats struct {
+	Rooms      int `json:"rooms"`
+	Players    int `json:"players"`
+	Goroutines struct {
+		Total int `json:"total"`
+	} `json:"goroutines"`
+	Simulation struct {
+		Rooms int `json:"rooms"`
+	} `json:"simulation"`
+	Sizes map[string]int `json:"sizes"`
+}
+
+func fetchStats(addr string) (stats, error) {
+	var s stats
+	resp, err := httpClient.Get("http://" + addr + "/stats")
+	if err != nil {
+		return s, err
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(&s)
+	return s, err
+}
+
+// checkLeaks waits for the server to drop the players and for the process
+// to get back to its goroutine count before them.
+// Rooms stay registered (with a suspended loop) until the cleanup sweep.
+func checkLeaks(addr string, baseline stats) error {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s, err := fetchStats(addr)
+		if err != nil {
+			return err
+		}
+		err = leaked(s, baseline)
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// leaked compares a stats sample to the baseline taken before any client
+// connected.
+func leaked(s, baseline stats) error {
+	switch {
+	case s.Players != 0:
+		return fmt.Errorf("%d players left", s.Players)
+	case s.Simulation.Rooms != s.Rooms:
+		return fmt.Errorf("scheduler runs %d rooms for %d rooms", s.Simulation.Rooms, s.Rooms)
+	case s.Goroutines.Total > baseline.Goroutines.Total:
+		// Rooms run on the scheduler's workers, started with the server
+		return fmt.Errorf("%d goroutines left (baseline %d)", s.Goroutines.Total, baseline.Goroutines.Total)
+	}
+	return nil
+}
+
+// scenario is the state shared by the steps
+type scenario struct {
+	addr  string
+	alice *client
+	bob   *client
+}
+
+func (s *scenario) close() {
+	if s.alice != nil {
+		s.alice.close()
+	}
+	if s.bob != nil {
+		s.bob.close()
+	}
+}
+
+// handshake negotiates the newest protocol version
+func (s *scenario) handshake() error {
+	var err error
+	if s.alice, err = dial(s.addr); err != nil {
+		return err
+	}
+	if s.bob, err = dial(s.addr); err != nil {
+		return err
+	}
+
+	for _, c := range []*client{s.alice, s.bob} {
+		c.send([]byte{network.MsgTypeHello, network.ProtocolVersionMax})
+		data, err := c.expect(network.MsgTypeHelloAck, nil)
+		if err != nil {
+			return err
+		}
+		if len(data) < 2 || data[1] != network.ProtocolVersionMax {
+			return fmt.Errorf("negotiated %v, want version %d", data[1:], network.ProtocolVersionMax)
+		}
+		c.version = data[1]
+	}
+	return nil
+}
+
+// join puts both players into the same room and checks they see each other
+func (s *scenario) join() error {
+	for _, c := range []*client{s.alice, s.bob} {
+		name := "Alice"
+		if c == s.bob {
+			name = "Bob"
+		}
+		c.send(encodeJoin(name, 3))
+
+		data, err := c.expect(network.MsgTypeRoomInfo, nil)
+		if err != nil {
+			return err
+		}
+		if len(data) < 2 || len(data) < 2+int(data[1])+4 {
+			return fmt.Errorf("short room info %x", data)
+		}
+		c.id = binary.LittleEndian.Uint16(data[2+int(data[1])+2:])
+
+		if _, err := c.expect(network.MsgTypeTickRate, nil); err != nil {
+			return err
+		}
+	}
+
+	isJoinOf := func(id uint16) func([]byte) bool {
+		return func(data []byte) bool {
+			return len(data) >= 3 && binary.LittleEndian.Uint16(data[1:3]) == id
+		}
+	}
+	if _, err := s.alice.expect(network.MsgTypePlayerJoin, isJoinOf(s.bob.id)); err != nil {
+		return fmt.Errorf("alice never saw bob join: %w", err)
+	}
+	if _, err := s.bob.expect(network.MsgTypePlayerJoin, isJoinOf(s.alice.id)); err != nil {
+		return fmt.Errorf("bob was never told about alice: %w", err)
+	}
+	return nil
+}
+
+// state waits for a state update that carries both players
+func (s *scenario) state() error {
+	_, err := s.alice.expect(network.MsgTypeStateUpdate, func(data []byte) bool {
+		records, err := decodeState(data, s.alice.version)
+		if err != nil {
+			return false
+		}
+		_, hasAlice := records[s.alice.id]
+		_, hasBob := records[s.bob.id]
+		return hasAlice && hasBob
+	})
+	return err
+}
+
+// drive accelerates and checks the server moves the car forward
+func (s *scenario) drive() error {
+	stop := s.alice.hold(0, 127)
+	defer stop()
+
+	_, err := s.alice.expectRecord(func(r record) bool {
+		return r.speed > 100 && r.y > 50
+	})
+	return err
+}
+
+// ping checks the pong echoes the timestamp
+func (s *scenario) ping() error {
+	ts := uint64(time.Now().UnixMilli())
+	ping := make([]byte, 9)
+	ping[0] = network.MsgTypePing
+	binary.LittleEndian.PutUint64(ping[1:], ts)
+	s.alice.send(ping)
+
+	_, err := s.alice.expect(network.MsgTypePong, func(data []byte) bool {
+		return len(data) >= 9 && binary.LittleEndian.Uint64(data[1:9]) == ts
+	})
+	return err
+}
+
+// json speaks the JSON subprotocol and checks unsupported subprotocols are
+// refused before the upgrade
+func (s *scenario) json() error {
+	u := url.URL{Scheme: "ws", Host: s.addr, Path: "/ws"}
+	dialer := websocket.Dialer{HandshakeTimeout: messageTimeout, Subprotocols: []string{"vracer.v0.xml"}}
+	ws, resp, err := dialer.Dial(u.String(), nil)
+	if err == nil {
+		ws.Close()
+		return errors.New("unsupported subprotocol accepted")
+	}
+	if resp == nil || resp.StatusCode != http.StatusBadRequest {
+		return fmt.Errorf("unsupported subprotocol: %w", err)
+	}
+
+	dialer.Subprotocols = []string{network.SubprotocolJSON}
+	ws, _, err = dialer.Dial(u.String(), nil)
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+	if ws.Subprotocol() != network.SubprotocolJSON {
+		return fmt.Errorf("negotiated subprotocol %q", ws.Subprotocol())
+	}
+	ws.SetReadDeadline(time.Now().Add(messageTimeout))
+
+	var reply struct {
+		Type      string `json:"type"`
+		Version   uint8  `json:"version"`
+		Timestamp string `json:"timestamp"`
+	}
+	ws.WriteJSON(map[string]interface{}{"type": "hello", "version": network.ProtocolVersionMax})
+	if err := ws.ReadJSON(&reply); err != nil {
+		return err
+	}
+	if reply.Type != "hello-ack" || reply.Version != network.ProtocolVersionMax {
+		return fmt.Errorf("hello answered with %+v", reply)
+	}
+
+	ws.WriteJSON(map[string]interface{}{"type": "ping", "timestamp": "1700000000000"})
+	if err := ws.ReadJSON(&reply); err != nil {
+		return err
+	}
+	if reply.Type != "pong" || reply.Timestamp != "1700000000000" {
+		return fmt.Errorf("ping answered with %+v", reply)
+	}
+	return nil
+}
+
+// explode steers off the road at full throttle until the car explodes
+func (s *scenario) explode() error {
+	stop := s.alice.hold(127, 127)
+	defer stop()
+
+	_, err := s.alice.expectRecord(func(r record) bool {
+		return r.flags&network.FlagExploded != 0
+	})
+	return err
+}
+
+// respawn waits for the automatic respawn on the road
+func (s *scenario) respawn() error {
+	s.alice.seq++
+	s.alice.send(encodeInput(s.alice.seq, 0, 0))
+
+	deadline := time.Now().Add(config.RespawnDelay + messageTimeout)
+	for {
+		r, err := s.alice.expectRecord(nil)
+		if err != nil {
+			return err
+		}
+		if r.flags&network.FlagExploded == 0 {
+			if dist := r.x - config.GetRoadCurve(r.y); dist > config.RoadWidth/2 || dist < -config.RoadWidth/2 {
+				return fmt.Errorf("respawned off the road (%.0f from the center)", dist)
+			}
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("no respawn")
+		}
+	}
+}
+
+// leave has bob leave and checks alice is told
+func (s *scenario) leave() error {
+	bobID := s.bob.id
+	s.bob.send([]byte{network.MsgTypeLeaveRoom})
+
+	_, err := s.alice.expect(network.MsgTypePlayerLeave, func(data []byte) bool {
+		return len(data) >= 3 && binary.LittleEndian.Uint16(data[1:3]) == bobID
+	})
+	return err
+}
+
+// client is a minimal protocol client
+type client struct {
+	ws      *websocket.Conn
+	msgs    chan []byte
+	writeMu sync.Mutex
+	version uint8
+	id      uint16
+	seq     uint8
+}
+
+func dial(addr string) (*client, error) {
+	u := url.URL{Scheme: "ws", Host: addr, Path: "/ws"}
+	ws, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &client{ws: ws, msgs: make(chan []byte, 1024), version: network.ProtocolV1}
+	go func() {
+		defer close(c.msgs)
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			if len(data) > 0 {
+				c.msgs <- data
+			}
+		}
+	}()
+	return c, nil
+}
+
+func (c *client) send(data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	return c.ws.WriteMessage(websocket.BinaryMessage, data)
+}
+
+func (c *client) close() {
+	c.ws.Close()
+}
+
+// expect skips messages until one of msgType matches (nil matches all).
+// Server errors fail the wait.
+func (c *client) expect(msgType uint8, match func([]byte) bool) ([]byte, error) {
+	timeout := time.After(messageTimeout)
+	for {
+		select {
+		case data, ok := <-c.msgs:
+			if !ok {
+				return nil, errors.New("connection closed")
+			}
+			if data[0] == network.MsgTypeError && msgType != network.MsgTypeError {
+				return nil, fmt.Errorf("server error: %q", data[2:])
+			}
+			if data[0] == msgType && (match == nil || match(data)) {
+				return data, nil
+			}
+		case <-timeout:
+			return nil, fmt.Errorf("no message 0x%02x within %v", msgType, messageTimeout)
+		}
+	}
+}
+
+// expectRecord waits for a state update with the client's own record that
+// matches (nil matches all).
+func (c *client) expectRecord(match func(record) bool) (record, error) {
+	var found record
+	_, err := c.expect(network.MsgTypeStateUpdate, func(data []byte) bool {
+		records, err := decodeState(data, c.version)
+		if err != nil {
+			return false
+		}
+		r, ok := records[c.id]
+		if ok && (match == nil || match(r)) {
+			found = r
+			return true
+		}
+		return false
+	})
+	return found, err
+}
+
+// hold sends the same input at the client sync rate until stop is called
+func (c *client) hold(steering, throttle int8) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(config.SyncRateMS * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			c.seq++
+			c.send(encodeInput(c.seq, steering, throttle))
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// record is a decoded player state record
+type record struct {
+	x, y, speed float64
+	flags       uint8
+}
+
+// decodeState decodes the records of a state update by player ID
+func decodeState(data []byte, version uint8) (map[uint16]record, error) {
+	header := 4
+	if version >= network.ProtocolV10 {
+		header = 12 // [baseY:8]
+	}
+	if len(data) < header {
+		return nil, errors.New("short state update")
+	}
+	size := 16
+	switch {
+	case version >= network.ProtocolV17:
+		size = 21 // [lane:1]
+	case version >= network.ProtocolV2:
+		size = 20
+	}
+	count := int(data[3])
+	end := header + count*size
+	if version >= network.ProtocolV20 && len(data) > end {
+		end += 1 + int(data[end]) // Own state: [size:1] + fields
+	}
+	if len(data) != end {
+		return nil, fmt.Errorf("state update of %d bytes for %d players", len(data), count)
+	}
+
+	records := make(map[uint16]record, count)
+	for i := 0; i < count; i++ {
+		b := data[header+i*size:]
+		records[binary.LittleEndian.Uint16(b[0:2])] = record{
+			x:     float64(int16(binary.LittleEndian.Uint16(b[2:4]))) / 10,
+			y:     float64(int32(binary.LittleEndian.Uint32(b[4:8]))),
+			speed: float64(int16(binary.LittleEndian.Uint16(b[8:10]))) / 10,
+			flags: b[14],
+		}
+	}
+	return records, nil
+}
+
+func encodeJoin(name string, color uint8) []byte {
+	buf := []byte{network.MsgTypeJoinRoom, uint8(len(name))}
+	buf = append(buf, name...)
+	return append(buf, color)
+}
+
+func encodeInput(seq uint8, steering, throttle int8) []byte {
+	return []byte{network.MsgTypeInput, seq, 0, uint8(steering), uint8(throttle), 0}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/network"
+)
+
+// Payload encryption
+//
+//   GET /api/payload-key   - the server's public key, for clients to pin
+//
+// For deployments whose TLS ends at an untrusted edge: with PAYLOAD_KEY set
+// (a base64 32-byte X25519 private key, e.g. `head -c 32 /dev/urandom |
+// base64`), a client may make a key for the connection and present its
+// public half, base64url, as /ws?box=<key>. Everything on the connection is
+// then sealed end to end (see network/encryption.go) by a codec wrapping
+// the negotiated one; rooms and handlers see the same messages as ever.
+// PAYLOAD_REQUIRED=true refuses connections without a key (403).
+//
+// The edge can read /api/payload-key too: clients that must not trust it
+// pin the key in their build instead of fetching it. The key should be the
+// same on every server of a cluster. Admin agents (see agent.go) connect
+// in the clear. /stats counts the encryptedConns so far.
+
+// newPayloadKey returns the payload key of the config (nil: encryption off)
+func newPayloadKey(cfg *config.ServerConfig) *network.PayloadKey {
+	if cfg.PayloadKey == "" {
+		return nil
+	}
+	key, err := network.NewPayloadKey(cfg.PayloadKey)
+	if err != nil {
+		log.Fatalf("Invalid PAYLOAD_KEY: %v", err)
+	}
+	return key
+}
+
+var (
+	errPayloadOff      = errors.New("payload encryption is not enabled on this server")
+	errPayloadKey      = errors.New("invalid box key: want a base64url X25519 public key")
+	errPayloadRequired = errors.New("payload encryption required: connect with ?box=<key>")
+)
+
+// payloadCipherOf returns the cipher of a connection that asked for
+// encrypted payloads (nil if it didn't), or the error and HTTP status to
+// refuse it with
+func (s *GameServer) payloadCipherOf(r *http.Request) (*network.PayloadCipher, int, error) {
+	box := r.URL.Query().Get("box")
+	if box == "" {
+		if s.config.PayloadRequired {
+			return nil, http.StatusForbidden, errPayloadRequired
+		}
+		return nil, 0, nil
+	}
+	if s.payloadKey == nil {
+		return nil, http.StatusBadRequest, errPayloadOff
+	}
+	client, err := base64.RawURLEncoding.DecodeString(box)
+	if err != nil || len(client) != network.PayloadKeyLen {
+		return nil, http.StatusBadRequest, errPayloadKey
+	}
+	cipher, err := network.ServerPayloadCipher(s.payloadKey, client)
+	if err != nil {
+		return nil, http.StatusBadRequest, errPayloadKey
+	}
+	return cipher, 0, nil
+}
+
+// handlePayloadKey returns the public key clients encrypt payloads with.
+func (s *GameServer) handlePayloadKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.payloadKey == nil {
+		http.Error(w, errPayloadOff.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"algorithm": "X25519-HKDF-SHA256-AES256GCM",
+		"key":       base64.RawURLEncoding.EncodeToString(s.payloadKey.Public()),
+	})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/race/server/internal/moderation"
+)
+
+// Client fingerprints
+//
+//   GET /admin/fingerprints - kicked players' fingerprints and the
+//                             connections that resembled them (admin token)
+//
+// Every connection collects a fingerprint from passive signals: the header
+// set of its handshake, how fast it says Hello and joins, and the cadence
+// of its inputs (see moderation/fingerprint.go). Kicks keep the kicked
+// player's fingerprint. Once a connection has sent enough inputs to measure
+// its cadence, it is compared with the kicked players; a close match under
+// another name or from another address is logged and listed with its score
+// for moderators, who decide whether it is a ban evader. Nothing is blocked
+// automatically. Records are kept in memory for config.FingerprintRetention.
+
+// fingerprintReport is the response of /admin/fingerprints
+type fingerprintReport struct {
+	Kicked  []moderation.FingerprintRecord `json:"kicked"`  // Newest first
+	Matches []moderation.FingerprintMatch  `json:"matches"` // Newest first
+}
+
+// handleAdminFingerprints lists kick fingerprints and matches.
+func (s *GameServer) handleAdminFingerprints(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, fingerprintReport{
+		Kicked:  s.fingerprints.Records(),
+		Matches: s.fingerprints.Matches(),
+	})
+}
+
+// matchFingerprint compares a connection whose cadence sample just completed
+// with the kicked players.
+func (s *GameServer) matchFingerprint(c *ClientConnection, name string) {
+	match, ok := s.fingerprints.Match(name, c.info.IP, c.fingerprint.Fingerprint(), time.Now())
+	if !ok {
+		return
+	}
+	log.Printf("Player %s (%s) resembles %s (%s), kicked %s ago: %.0f%% (%s)",
+		name, c.info.IP, match.Kicked.Name, match.Kicked.IP,
+		time.Since(match.Kicked.Kicked).Round(time.Second), match.Score*100, match.Kicked.Reason)
+}
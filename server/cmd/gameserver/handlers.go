@@ -0,0 +1,150 @@
+package main
+
+import (
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/game"
+	"github.com/race/server/internal/network"
+)
+
+// Message handlers
+//
+// Client messages are dispatched through a registry: each message type maps
+// to its handler, wrapped in the middleware that checks what the message
+// needs before the handler runs, so handlers only deal with the message
+// itself. To add a message type, register it in newMessageHandlers with its
+// middleware:
+//
+//   - since(version): the type exists from this protocol version on; older
+//     clients' messages of the type are ignored
+//   - limited(class): the connection's rate limit for the class (on top of
+//     the connection-wide flood protection)
+//   - inRoom: the client must be in a room; fills in the message's session
+//   - notInRoom: the client must not be in a room (see sessions.go)
+//
+// Middleware runs in the order given. Message types without a handler are
+// ignored.
+
+// message is a client message on its way to its handler
+type message struct {
+	data []byte
+
+	// Session, set by inRoom
+	player *game.Player
+	room   *game.Room
+}
+
+// messageHandler handles a client message
+type messageHandler func(c *ClientConnection, m *message)
+
+// middleware wraps a message handler with a precondition
+type middleware func(next messageHandler) messageHandler
+
+// rateClass groups message types that share a per-connection rate limit
+type rateClass int
+
+const (
+	rateControl    rateClass = iota // Session changes: hello, join, leave, reset, host kick, link, prestige, net sim, event auth, mute, spectate, telemetry
+	rateSignal                      // Voice chat signaling
+	rateProfile                     // Name and color changes in a room
+	rateRoomCode                    // Private rooms: creating them and joining by code
+	rateClip                        // Highlight clips
+	rateClassCount                  // Number of classes
+)
+
+// newRateLimiters returns a connection's limiters, one per rate class
+func newRateLimiters() [rateClassCount]*network.RateLimiter {
+	return [rateClassCount]*network.RateLimiter{
+		rateControl:  network.NewRateLimiter(config.ControlMessageRate, config.ControlMessageBurst),
+		rateSignal:   network.NewRateLimiter(config.SignalMessageRate, config.SignalMessageBurst),
+		rateProfile:  network.NewRateLimiter(config.ProfileUpdateRate, config.ProfileUpdateBurst),
+		rateRoomCode: network.NewRateLimiter(config.RoomCodeRate, config.RoomCodeBurst),
+		rateClip:     network.NewRateLimiter(config.ClipRequestRate, config.ClipRequestBurst),
+	}
+}
+
+// messageHandlers is the registry, by message type
+var messageHandlers = newMessageHandlers()
+
+// newMessageHandlers builds the registry
+func newMessageHandlers() map[uint8]messageHandler {
+	handlers := make(map[uint8]messageHandler)
+	register := func(msgType uint8, handler messageHandler, mw ...middleware) {
+		for i := len(mw) - 1; i >= 0; i-- {
+			handler = mw[i](handler)
+		}
+		handlers[msgType] = handler
+	}
+
+	register(network.MsgTypeHello, (*ClientConnection).handleHello, limited(rateControl))
+	register(network.MsgTypeJoinRoom, (*ClientConnection).handleJoin, limited(rateControl), notInRoom)
+	register(network.MsgTypeInput, (*ClientConnection).handleInput, inRoom)
+	register(network.MsgTypePing, (*ClientConnection).handlePing)
+	register(network.MsgTypeLeaveRoom, (*ClientConnection).handleLeave, limited(rateControl))
+	register(network.MsgTypeHostKick, (*ClientConnection).handleHostKick, limited(rateControl), inRoom)
+	register(network.MsgTypeReset, (*ClientConnection).handleReset, since(network.ProtocolV5), limited(rateControl), inRoom)
+	register(network.MsgTypeLink, (*ClientConnection).handleLink, since(network.ProtocolV12), limited(rateControl), inRoom)
+	register(network.MsgTypeSignal, (*ClientConnection).handleSignal, since(network.ProtocolV13), limited(rateSignal), inRoom)
+	register(network.MsgTypePrestige, (*ClientConnection).handlePrestige, since(network.ProtocolV23), limited(rateControl), inRoom)
+	register(network.MsgTypeNetSim, (*ClientConnection).handleNetSim, since(network.ProtocolV25), limited(rateControl), inRoom)
+	register(network.MsgTypeEventAuth, (*ClientConnection).handleEventAuth, since(network.ProtocolV26), limited(rateControl))
+	register(network.MsgTypeMute, (*ClientConnection).handleMute, since(network.ProtocolV27), limited(rateControl))
+	register(network.MsgTypeUpdateProfile, (*ClientConnection).handleUpdateProfile, since(network.ProtocolV29), limited(rateProfile), inRoom)
+	register(network.MsgTypeCreateRoom, (*ClientConnection).handleCreateRoom, since(network.ProtocolV30), limited(rateRoomCode), notInRoom)
+	register(network.MsgTypeJoinByCode, (*ClientConnection).handleJoinByCode, since(network.ProtocolV30), limited(rateRoomCode), notInRoom)
+	register(network.MsgTypeSpectate, (*ClientConnection).handleSpectate, since(network.ProtocolV32), limited(rateControl), notInRoom)
+	register(network.MsgTypeTelemetry, (*ClientConnection).handleTelemetry, since(network.ProtocolV33), limited(rateControl), inRoom)
+	register(network.MsgTypeClip, (*ClientConnection).handleClip, since(network.ProtocolV36), limited(rateClip), inRoom)
+	register(network.MsgTypeHostStartRace, (*ClientConnection).handleHostStartRace, since(network.ProtocolV38), limited(rateControl), inRoom)
+	register(network.MsgTypeHostSettings, (*ClientConnection).handleHostSettings, since(network.ProtocolV38), limited(rateControl), inRoom)
+	return handlers
+}
+
+// since ignores the message unless the client negotiated at least version
+func since(version uint8) middleware {
+	return func(next messageHandler) messageHandler {
+		return func(c *ClientConnection, m *message) {
+			if c.ProtocolVersion() < version {
+				return
+			}
+			next(c, m)
+		}
+	}
+}
+
+// limited drops the message if the connection is over the rate of its class
+func limited(class rateClass) middleware {
+	return func(next messageHandler) messageHandler {
+		return func(c *ClientConnection, m *message) {
+			if !c.classLimiters[class].Allow(time.Now()) {
+				c.server.metrics.messagesThrottled.Add(1)
+				return
+			}
+			next(c, m)
+		}
+	}
+}
+
+// inRoom ignores the message unless the client is in a room
+func inRoom(next messageHandler) messageHandler {
+	return func(c *ClientConnection, m *message) {
+		m.player, m.room = c.session()
+		if m.player == nil || m.room == nil {
+			return
+		}
+		next(c, m)
+	}
+}
+
+// notInRoom refuses the message if the client is in a room already
+func notInRoom(next messageHandler) messageHandler {
+	return func(c *ClientConnection, m *message) {
+		if player, _ := c.session(); player != nil {
+			c.server.metrics.doubleJoins.Add(1)
+			c.Send(c.server.protocol.EncodeError(network.ErrorCodeNotAllowed, errAlreadyJoined.Error()))
+			return
+		}
+		next(c, m)
+	}
+}
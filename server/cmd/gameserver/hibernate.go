@@ -0,0 +1,150 @@
+package main
+
+import (
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/race/server/internal/storage"
+)
+
+// Idle hibernation
+//
+// With HIBERNATE_AFTER set, a server that has had no connection at all for
+// that long hibernates: it persists the leaderboard, stops every room (bot
+// rooms included), drops its caches, pauses the background tasks with their
+// tickers stopped and returns freed memory to the OS. The next WebSocket
+// connection wakes it before the upgrade, so the player lands on a fully
+// running server. HTTP requests (health, stats, API) are served while
+// hibernating and don't wake the server.
+
+// hibernation is the idle state of the server
+type hibernation struct {
+	mu     sync.Mutex
+	gen    uint64        // Bumped on every connection; stale timers don't fire
+	timer  *time.Timer   // Pending hibernation
+	asleep bool          // Hibernating
+	awake  chan struct{} // Closed on wake-up
+	count  uint64        // Times hibernated
+}
+
+// scheduleHibernation starts the idle countdown if there is no connection.
+// Called at startup and whenever a connection closes or fails to open.
+func (s *GameServer) scheduleHibernation() {
+	after := s.config.HibernateAfter
+	if after <= 0 {
+		return
+	}
+
+	h := &s.hibernation
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.timer != nil || h.asleep || s.connectionCount() > 0 {
+		return
+	}
+	gen := h.gen
+	h.timer = time.AfterFunc(after, func() { s.hibernate(gen) })
+}
+
+// wakeUp cancels a pending hibernation and, if the server hibernates,
+// resumes it. Called for every incoming WebSocket connection.
+func (s *GameServer) wakeUp() {
+	h := &s.hibernation
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.gen++
+	if h.timer != nil {
+		h.timer.Stop()
+		h.timer = nil
+	}
+	if h.asleep {
+		h.asleep = false
+		close(h.awake)
+		log.Printf("Waking up from hibernation")
+	}
+}
+
+// hibernate releases the server's resources unless a connection came in
+// since the countdown started. The release runs under the lock, so a
+// connection waking the server waits until it is complete.
+func (s *GameServer) hibernate(gen uint64) {
+	h := &s.hibernation
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.gen != gen || h.timer == nil {
+		return
+	}
+	h.timer = nil
+	select {
+	case <-s.quit:
+		return
+	default:
+	}
+	if s.connectionCount() > 0 {
+		return
+	}
+	if s.reservedRooms() > 0 {
+		// Players of an allocated match are on their way: try again later
+		h.timer = time.AfterFunc(s.config.HibernateAfter, func() { s.hibernate(gen) })
+		return
+	}
+
+	h.asleep = true
+	h.awake = make(chan struct{})
+	h.count++
+
+	s.tickLeaderboards()
+	s.stopRooms()
+	s.profiles.purge()
+	s.penalties.Sweep()
+	s.fingerprints.Sweep()
+	if store, ok := s.store.(storage.Sweeper); ok {
+		store.Sweep()
+	}
+	debug.FreeOSMemory()
+	log.Printf("Hibernating: no connections for %v", s.config.HibernateAfter)
+}
+
+// hibernating returns a channel closed on wake-up while the server
+// hibernates, or nil
+func (s *GameServer) hibernating() <-chan struct{} {
+	h := &s.hibernation
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.asleep {
+		return nil
+	}
+	return h.awake
+}
+
+// hibernations returns whether the server hibernates and how many times it has
+func (s *GameServer) hibernations() (bool, uint64) {
+	h := &s.hibernation
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.asleep, h.count
+}
+
+// pause blocks a background task with its ticker stopped while the server
+// hibernates. Returns false if the server shuts down meanwhile.
+func (s *GameServer) pause(ticker *time.Ticker, period time.Duration) bool {
+	awake := s.hibernating()
+	if awake == nil {
+		return true
+	}
+
+	ticker.Stop()
+	select {
+	case <-s.quit:
+		return false
+	case <-awake:
+	}
+	ticker.Reset(period)
+	return true
+}
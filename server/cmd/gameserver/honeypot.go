@@ -0,0 +1,114 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/race/server/internal/game"
+)
+
+// Honeypot rooms
+//
+//   GET /admin/honeypot - the honeypot rooms and the evidence they collected
+//                         (admin token)
+//
+// With ANTICHEAT_POLICY=honeypot, players anti-cheat would kick are moved
+// instead to a honeypot room of their game (see game/honeypot.go): a room
+// of bots and other flagged players that matchmaking never sends anyone
+// else to. They get the new room's RoomInfo as if they had joined it, and
+// no error. Anti-cheat goes on watching them there, and what it catches is
+// kept as evidence for moderators. A player who can't be placed, because
+// the server has no room to spare, is kicked as under the default policy
+// ("kick").
+
+// Anti-cheat policies (ANTICHEAT_POLICY)
+const (
+	policyKick     = "kick"
+	policyHoneypot = "honeypot"
+)
+
+// honeypotRoom is a honeypot room in /admin/honeypot
+type honeypotRoom struct {
+	Tenant   string               `json:"tenant"`
+	Room     string               `json:"room"`
+	Players  int                  `json:"players"` // Bots included
+	Evidence []game.CheatEvidence `json:"evidence"`
+}
+
+// divertCheater moves a player anti-cheat caught to a honeypot room of
+// their game. Returns false if there is none to spare.
+func (s *GameServer) divertCheater(player *game.Player, reason string) bool {
+	conn, ok := player.Connection.(*ClientConnection)
+	if !ok {
+		return false
+	}
+	room := conn.tenant.matchmaker.HoneypotRoom(s.botProfilesByName())
+	if room == nil {
+		return false
+	}
+	defer s.wakeQueue() // Their slot in the room they left
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.closed || conn.player != player {
+		return true // Left meanwhile
+	}
+	conn.player, conn.room = nil, nil
+	if err := conn.joinUnlocked(room, player.GetName(), player.Color); err != nil {
+		log.Printf("Failed to move %s to honeypot room %s: %v", conn.info, room.ID, err)
+		return false
+	}
+	room.Refer(conn.player.ID, conn.player.GetName(), reason)
+	s.metrics.honeypotted.Add(1)
+	return true
+}
+
+// botProfilesByName returns the bot personalities in a fixed order, for
+// the bots of honeypot rooms and agent training rooms
+func (s *GameServer) botProfilesByName() []game.BotProfile {
+	names := make([]string, 0, len(s.botProfiles))
+	for name := range s.botProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	profiles := make([]game.BotProfile, len(names))
+	for i, name := range names {
+		profiles[i] = s.botProfiles[name]
+	}
+	return profiles
+}
+
+// handleAdminHoneypot lists the honeypot rooms of every game.
+func (s *GameServer) handleAdminHoneypot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rooms := make([]honeypotRoom, 0)
+	for _, t := range s.tenants {
+		for _, room := range t.matchmaker.GetStats().Rooms {
+			if !room.Honeypot {
+				continue
+			}
+			hp := t.matchmaker.GetRoom(room.ID)
+			if hp == nil {
+				continue // Closed meanwhile
+			}
+			rooms = append(rooms, honeypotRoom{
+				Tenant:   t.key,
+				Room:     room.ID,
+				Players:  room.PlayerCount,
+				Evidence: hp.HoneypotEvidence(),
+			})
+		}
+	}
+	sort.Slice(rooms, func(i, j int) bool {
+		if rooms[i].Tenant != rooms[j].Tenant {
+			return rooms[i].Tenant < rooms[j].Tenant
+		}
+		return rooms[i].Room < rooms[j].Room
+	})
+	writeJSON(w, http.StatusOK, rooms)
+}
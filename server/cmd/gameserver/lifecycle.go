@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/cluster"
+)
+
+// Orchestration
+//
+//   GET /api/servers - the cluster directory: every server sharing the store
+//   GET /api/route   - the server a client should connect to (?room=<id>)
+//   GET /autoscale   - how many servers the cluster wants (cluster.Scaling)
+//
+// Each server announces itself in the cluster directory every
+// config.DirectoryHeartbeat under its instance ID (INSTANCE_ID, else the
+// Kubernetes POD_NAME, else the host name) with the address clients reach it
+// at (PUBLIC_ADDR, when that differs from the bind address, e.g. behind a
+// load balancer or node port). It keeps announcing while it hibernates:
+// the next connection wakes it.
+//
+// With each announcement it publishes its rooms and their players in the
+// room registry (see cluster/rooms.go), whose claims keep room IDs unique
+// across the cluster, and across restarts. /api/route sends a client to
+// the server of the room it asks for, if one plays it and takes
+// connections, and otherwise to the least loaded server: the one using
+// the smallest share of its MAX_CONNECTIONS.
+//
+// With DRAIN_TIMEOUT set, SIGTERM (sent by Kubernetes after the preStop
+// hook, and by docker stop) drains the server before shutting it down: it
+// is marked draining in the directory, /health answers 503 so readiness
+// probes take it out of the load balancer, new connections are refused,
+// and the rooms play on until their players leave or the timeout passes.
+// A second signal cuts the drain short. SIGINT shuts down right away.
+//
+// An external autoscaler (a Kubernetes controller, KEDA's metrics API
+// scaler, a cron job) polls /autoscale on any server: it sizes the cluster
+// from the directory (see cluster/autoscale.go) so the servers run at
+// AUTOSCALE_TARGET percent of their MAX_CONNECTIONS, counting the joins
+// waiting in their queues, and answers with the replicas wanted and the
+// delta from those taking connections. Servers announce their queues with
+// the rest, so the signal is at most config.DirectoryHeartbeat old; the
+// answering server's own numbers are current.
+
+// defaultInstanceID identifies the server when INSTANCE_ID is not set
+func defaultInstanceID() string {
+	if pod := os.Getenv("POD_NAME"); pod != "" {
+		return pod
+	}
+	if host, err := os.Hostname(); err == nil && host != "" {
+		return host
+	}
+	return "gameserver"
+}
+
+// instance describes this server for the cluster directory
+func (s *GameServer) instance() cluster.Instance {
+	stats := s.roomStats()
+	queued := 0
+	for _, t := range s.tenants {
+		queued += t.queue.Len()
+	}
+	return cluster.Instance{
+		ID:          s.config.InstanceID,
+		PublicAddr:  s.config.PublicAddr,
+		Connections: s.connectionCount(),
+		Capacity:    s.config.MaxConnections,
+		Queued:      queued,
+		Rooms:       stats.TotalRooms,
+		Players:     stats.TotalPlayers,
+		Draining:    s.draining.Load(),
+		Updated:     time.Now().UTC(),
+	}
+}
+
+// registeredRooms describes this server's rooms for the room registry
+func (s *GameServer) registeredRooms() []cluster.RoomEntry {
+	var rooms []cluster.RoomEntry
+	for key, t := range s.tenants {
+		for _, rs := range t.matchmaker.GetStats().Rooms {
+			rooms = append(rooms, cluster.RoomEntry{
+				ID:         rs.ID,
+				Tenant:     key,
+				Players:    rs.PlayerCount,
+				MaxPlayers: rs.MaxPlayers,
+				Public:     !rs.Practice && !rs.Honeypot && !rs.Quarantined && !rs.Reserved && !rs.Private,
+			})
+		}
+	}
+	return rooms
+}
+
+// announce refreshes this server's directory entry and its rooms
+func (s *GameServer) announce() {
+	if err := s.directory.Announce(s.instance()); err != nil {
+		log.Printf("Failed to announce in the cluster directory: %v", err)
+	}
+	if err := s.roomRegistry.Publish(s.registeredRooms()); err != nil {
+		log.Printf("Failed to publish the rooms: %v", err)
+	}
+}
+
+// Drain stops taking connections and waits until the last one closed or ctx
+// is done. The server keeps running; call Shutdown afterwards.
+func (s *GameServer) Drain(ctx context.Context) {
+	s.draining.Store(true)
+	s.announce()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for s.connectionCount() > 0 {
+		select {
+		case <-ctx.Done():
+			log.Printf("Drain timed out with %d connections", s.connectionCount())
+			return
+		case <-s.quit:
+			return
+		case <-ticker.C:
+		}
+	}
+	log.Printf("Drained: no connections left")
+}
+
+// handleServers lists the servers of the cluster.
+func (s *GameServer) handleServers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	servers, err := s.directory.List()
+	if err != nil {
+		log.Printf("Failed to list servers: %v", err)
+		http.Error(w, "failed to list servers", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, servers)
+}
+
+// handleAutoscale answers with the cluster's scaling signal.
+func (s *GameServer) handleAutoscale(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	servers, err := s.directory.List()
+	if err != nil {
+		log.Printf("Failed to list servers: %v", err)
+		http.Error(w, "failed to list servers", http.StatusInternalServerError)
+		return
+	}
+
+	// This server's own entry is as fresh as can be
+	self := s.instance()
+	i := 0
+	for i < len(servers) && servers[i].ID != self.ID {
+		i++
+	}
+	if i == len(servers) {
+		servers = append(servers, self)
+	}
+	servers[i] = self
+
+	writeJSON(w, http.StatusOK, cluster.Autoscale(servers, cluster.ScalingPolicy{
+		Target:      s.config.AutoscaleTarget,
+		Hysteresis:  config.AutoscaleHysteresis,
+		MinReplicas: s.config.AutoscaleMin,
+		MaxReplicas: s.config.AutoscaleMax,
+	}))
+}
+
+// routeResponse names the server a client should connect to
+type routeResponse struct {
+	Instance   string `json:"instance"`
+	PublicAddr string `json:"publicAddr,omitempty"`
+	Room       string `json:"room,omitempty"` // The room asked for, if the server plays it
+}
+
+// handleRoute picks the server for a client: the one playing the room it
+// asks for, else the least loaded one.
+func (s *GameServer) handleRoute(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	servers, err := s.directory.List()
+	if err != nil {
+		log.Printf("Failed to list servers: %v", err)
+		http.Error(w, "failed to list servers", http.StatusInternalServerError)
+		return
+	}
+
+	if id := r.URL.Query().Get("room"); id != "" {
+		room, ok, err := s.roomRegistry.Lookup(id)
+		if err != nil {
+			log.Printf("Failed to look up room %s: %v", id, err)
+		}
+		for _, inst := range servers {
+			if ok && inst.ID == room.Instance && !inst.Draining {
+				writeJSON(w, http.StatusOK, routeResponse{Instance: inst.ID, PublicAddr: inst.PublicAddr, Room: room.ID})
+				return
+			}
+		}
+	}
+
+	inst, ok := cluster.LeastLoaded(servers)
+	if !ok {
+		http.Error(w, "no server takes connections", http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, http.StatusOK, routeResponse{Instance: inst.ID, PublicAddr: inst.PublicAddr})
+}
+
+// directoryLoop announces this server until shutdown (which removes it).
+// Not paused by hibernation, so a hibernating server stays listed.
+func (s *GameServer) directoryLoop() {
+	ticker := time.NewTicker(config.DirectoryHeartbeat)
+	defer ticker.Stop()
+
+	s.announce()
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-ticker.C:
+			s.announce()
+		}
+	}
+}
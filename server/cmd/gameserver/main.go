@@ -15,40 +15,182 @@
 package main
 
 import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/race/server/config"
+	"github.com/race/server/internal/agones"
+	"github.com/race/server/internal/certify"
+	"github.com/race/server/internal/cluster"
+	"github.com/race/server/internal/cosmetics"
+	"github.com/race/server/internal/crash"
 	"github.com/race/server/internal/game"
+	"github.com/race/server/internal/history"
+	"github.com/race/server/internal/leaderboard"
 	"github.com/race/server/internal/matchmaker"
+	"github.com/race/server/internal/moderation"
 	"github.com/race/server/internal/network"
+	"github.com/race/server/internal/outbox"
+	"github.com/race/server/internal/plugin"
+	"github.com/race/server/internal/routines"
+	"github.com/race/server/internal/rules"
+	"github.com/race/server/internal/storage"
+	"github.com/race/server/internal/token"
+	"github.com/race/server/internal/track"
 )
 
 // GameServer is the main server instance that manages all connections and rooms.
 // It handles WebSocket upgrades and routes messages to appropriate handlers.
 type GameServer struct {
-	config      *config.ServerConfig   // Server configuration (host, port, etc.)
-	matchmaker  *matchmaker.Matchmaker // Manages game rooms and player assignment
-	protocol    *network.Protocol      // Binary protocol encoder/decoder
-	upgrader    websocket.Upgrader     // HTTP to WebSocket upgrader
-	connections map[*ClientConnection]bool // Active client connections
+	config       *config.ServerConfig         // Server configuration (host, port, etc.)
+	matchmaker   *matchmaker.Matchmaker       // Rooms of the default tenant
+	tenants      map[string]*tenant           // Isolated games by key ("" default); fixed after start
+	protocol     *network.Protocol            // Binary protocol encoder/decoder
+	upgrader     websocket.Upgrader           // HTTP to WebSocket upgrader
+	connMu       sync.Mutex                   // Protects connections
+	connections  map[*ClientConnection]bool   // Active client connections
+	penalties    *moderation.PenaltyStore     // Rejoin cooldowns after kicks
+	fingerprints *moderation.FingerprintStore // Kicked players' fingerprints (see fingerprints.go)
+	moderator    *moderation.Moderator        // Name/chat moderation policy
+	metrics      serverMetrics                // Counters exposed via /stats
+	leaderboard  *leaderboard.Board           // Seasonal high-score board of the default tenant
+	store        storage.Store                // Shared state backend
+	tokens       *token.Service               // Signed session/invite/ticket tokens
+	signer       *certify.Signer              // Certifies results (see certification.go)
+	payloadKey   *network.PayloadKey          // Of payload encryption (nil: off, see encryption.go)
+	priorities   *network.Priorities          // Classes of outbound messages (see outqueue.go)
+	proxies      []netip.Prefix               // Whose proxy headers are believed (see clientip.go)
+	tracks       *track.Registry              // Custom tracks from the map editor
+	submits      *submitLimiter               // Track submissions per client address
+	history      *history.History             // Finished rounds for player profiles
+	outbox       *outbox.Outbox               // Store writes waiting for retry (see outbox.go)
+	writer       *storeWriter                 // Makes the store writes rooms hand off (see writer.go)
+	inventory    *cosmetics.Inventory         // Cosmetics owned by accounts (see cosmetics.go)
+	profiles     *profileCache                // Recently served player profiles
+	queue        *matchmaker.JoinQueue        // Joins waiting for capacity (default tenant)
+	queueWake    chan struct{}                // Signals that capacity may have freed
+	botProfiles  map[string]game.BotProfile   // Bot personalities for the admin API
+	rules        game.RulesLibrary            // Game modes and rules scripts of public rooms (see internal/plugin, internal/rules)
+	scoring      game.Scorer                  // Scoring policy of every tenant's rooms (nil: the built-in one)
+	antiCheat    []game.AntiCheatRule         // Extra anti-cheat checks of every tenant's rooms
+	hibernation  hibernation                  // Idle state (HIBERNATE_AFTER)
+	directory    *cluster.Directory           // Servers sharing the store
+	roomRegistry *cluster.Rooms               // This server's rooms in the cluster (see lifecycle.go)
+	draining     atomic.Bool                  // Set by Drain; refuses new connections
+	agones       *agones.SDK                  // Agones sidecar (nil unless AGONES_ENABLED)
+	scheduler    *game.Scheduler              // Runs the game loops of every tenant's rooms
+	console      *consoleServer               // Operator console (nil unless CONSOLE is set, see console.go)
+	trends       trends                       // Load samples for the rolling windows of /stats (see trends.go)
+	daily        dailyReports                 // Report of the day (see report.go)
+	events       eventSecrets                 // Secrets of the tournament rooms (see tournament.go)
+	agents       agentSessions                // Open agent API sessions (see agent.go)
+	sessions     accountSessions              // Connections of the linked accounts (see sessions.go)
+
+	// Shared-store leaderboard archives by tenant, for outbox replays
+	archives map[string]leaderboard.Archive
+
+	httpServer   *http.Server  // Set by Start
+	quit         chan struct{} // Closed by Shutdown; stops the background tasks
+	shutdownOnce sync.Once
+}
+
+// serverMetrics holds process-wide counters for tuning and monitoring.
+type serverMetrics struct {
+	messagesReceived  atomic.Uint64 // Inbound messages accepted by the rate limiter
+	messagesDropped   atomic.Uint64 // Inbound messages dropped by the rate limiter
+	floodDisconnects  atomic.Uint64 // Connections closed for persistent flooding
+	messagesThrottled atomic.Uint64 // Messages dropped by a rate class limit (see handlers.go)
+	joins             atomic.Uint64 // Players who joined a room
+	matches           atomic.Uint64 // Rounds played to the end (see game/round.go)
+	rejoins           atomic.Uint64 // Joins that went back to the room of a session token (see rejoin.go)
+	kicks             atomic.Uint64 // Players kicked by a room (anti-cheat, sequence checks, operators)
+	honeypotted       atomic.Uint64 // Players anti-cheat sent to a honeypot room instead (see honeypot.go)
+	quarantines       atomic.Uint64 // Rooms the frame-budget watchdog quarantined (see quarantine.go)
+	signalsRelayed    atomic.Uint64 // Voice chat signaling messages relayed to another player (see voice.go)
+	signalsMuted      atomic.Uint64 // Voice chat signaling messages held back by a mute (see voice.go)
+	cosmeticsRefused  atomic.Uint64 // Cosmetics claimed in joins by players who don't own them (see cosmetics.go)
+	doubleJoins       atomic.Uint64 // Joins refused because the connection was in a room already (see sessions.go)
+	sessionsReplaced  atomic.Uint64 // Account sessions ended by a login elsewhere (SESSION_POLICY=kick)
+	sessionsRefused   atomic.Uint64 // Links refused because the account was logged in elsewhere (SESSION_POLICY=reject)
+	encryptedConns    atomic.Uint64 // Connections with encrypted payloads (see encryption.go)
+	profileUpdates    atomic.Uint64 // Name and color changes in rooms (see profile.go)
+	messagesShed      atomic.Uint64 // Outbound state and cosmetic messages dropped for a slow client (see outqueue.go)
+	slowClients       atomic.Uint64 // Connections closed for a full critical or high outbound queue
+	probesSent        atomic.Uint64 // Telemetry samples asked of players (see telemetry.go)
+	probesMissed      atomic.Uint64 // Telemetry samples that didn't come in time
+	probesImplausible atomic.Uint64 // Telemetry samples that were forged or implausible
+	clipsSaved        atomic.Uint64 // Highlight clips written to the store (see clips.go)
 }
 
 // ClientConnection represents a single connected client.
 // Each client has its own goroutines for reading and writing messages.
 type ClientConnection struct {
-	ws       *websocket.Conn // The underlying WebSocket connection
-	server   *GameServer     // Reference to parent server
-	player   *game.Player    // Player instance (nil until joined a room)
-	room     *game.Room      // Room instance (nil until joined a room)
-	sendChan chan []byte     // Buffered channel for outgoing messages
-	done     chan struct{}   // Signal channel for graceful shutdown
+	ws          *websocket.Conn                // The underlying WebSocket connection
+	server      *GameServer                    // Reference to parent server
+	out         *network.OutQueue              // Outgoing messages by priority (see outqueue.go)
+	ctx         context.Context                // Lives until the connection closes (see connctx.go)
+	cancel      context.CancelFunc             // Cancels ctx; called by Close
+	info        *network.ConnInfo              // Metadata, also carried by ctx; info.IP is used for penalties
+	reserved    string                         // Room the connection holds a match ticket for ("" if none)
+	spectate    string                         // Room the connection may watch (spectate token, see director.go)
+	sessionKey  string                         // Session of the player, kept across connections (see rejoin.go)
+	resume      string                         // Room the session token names ("" if none)
+	tenant      *tenant                        // Game the client plays (chosen in the handshake)
+	codec       network.Codec                  // Wire format of the negotiated subprotocol
+	encrypted   bool                           // Payloads are encrypted (see encryption.go)
+	fingerprint *moderation.FingerprintSampler // Passive client signals (see fingerprints.go)
+
+	// Session state. Mostly used by readPump, but the join queue admits
+	// waiting connections from its own goroutine, hence the mutex.
+	mu      sync.Mutex
+	player  *game.Player         // Player instance (nil until joined a room)
+	room    *game.Room           // Room instance (nil until joined a room)
+	pending *network.JoinMessage // Join waiting in the queue (nil if not queued)
+	closed  bool                 // Set by cleanup; no more joins after this
+	look    game.Appearance      // Cosmetics of the last join (see cosmetics.go)
+	account string               // Account of a Link on this connection ("" for guests)
+	watched *game.Room           // Room the connection spectates (nil if none)
+	mutes   map[string]bool      // Names of the players muted for voice chat (see voice.go)
+
+	// Inbound flood protection (only touched by readPump)
+	limiter         *network.RateLimiter
+	classLimiters   [rateClassCount]*network.RateLimiter // Per rate class (see handlers.go)
+	floodWindowFrom time.Time                            // Start of the current drop-counting window
+	floodDrops      int                                  // Messages dropped in the current window
+
+	// Tournament handshake (only touched by readPump, see tournament.go)
+	nonce        []byte // Of the challenge awaiting an answer (nil if none)
+	eventAuthed  bool   // Proved the event secret of the reserved room
+	authFailures int    // Wrong answers
+
+	// Telemetry sampling (only touched by readPump, see telemetry.go)
+	probe        []byte       // Nonce of the probe awaiting an answer (nil if none)
+	probed       time.Time    // When it went out
+	probedPlayer *game.Player // Who it went to
+	nextProbe    time.Time    // When the next one goes out (zero: not yet scheduled)
+
+	// Smoothed round-trip time from WebSocket ping/pong (RFC 6298 style)
+	srtt   atomic.Int64 // Smoothed RTT in nanoseconds
+	rttVar atomic.Int64 // RTT variation in nanoseconds
+
+	// Simulated network conditions of QA rooms (see netsim.go)
+	netsim netShaper
 }
 
 func main() {
@@ -57,9 +199,18 @@ func main() {
 
 	// Load configuration from environment variables
 	cfg := loadConfig()
+	if problems := validateConfig(cfg); len(problems) > 0 {
+		refuseToStart("Invalid configuration", problems)
+	}
+	crash.SetReporter(newCrashReporter(cfg))
 
 	// Create and start the game server
 	server := NewGameServer(cfg)
+	if cfg.SelfTest {
+		if problems := server.selfTest(); len(problems) > 0 {
+			refuseToStart("Self-test failed", problems)
+		}
+	}
 
 	// Print startup banner with configuration
 	log.Printf("=================================")
@@ -67,16 +218,77 @@ func main() {
 	log.Printf("=================================")
 	log.Printf("  Host: %s", cfg.Host)
 	log.Printf("  Port: %d", cfg.Port)
-	log.Printf("  Physics Rate: %d Hz", config.PhysicsTickRate)
-	log.Printf("  Broadcast Rate: %d Hz", config.NetworkBroadcastRate)
+	log.Printf("  Physics Rate: %d Hz (%d workers)", cfg.PhysicsTickRate, server.scheduler.Stats().Workers)
+	log.Printf("  Broadcast Rate: %d Hz", cfg.BroadcastRate)
+	log.Printf("  World Rebase Distance: %.0f", cfg.RebaseDistance)
 	log.Printf("  Max Players/Room: %d", config.MaxPlayersPerRoom)
 	log.Printf("  Max Rooms: %d", config.MaxRoomsPerServer)
+	log.Printf("  Store: %s", cfg.StoreBackend)
+	log.Printf("  Instance: %s", cfg.InstanceID)
+	if len(server.tenants) > 1 {
+		log.Printf("  Tenants: %d besides the default", len(server.tenants)-1)
+	}
+	if cfg.PublicAddr != "" {
+		log.Printf("  Public Address: %s", cfg.PublicAddr)
+	}
+	if cfg.HibernateAfter > 0 {
+		log.Printf("  Hibernate After: %v idle", cfg.HibernateAfter)
+	}
+	if cfg.SpectatorDelay > 0 {
+		log.Printf("  Spectator Delay: %ds", cfg.SpectatorDelay)
+	}
+	if cfg.FuelMode {
+		log.Printf("  Fuel Mode: on")
+	}
+	if cfg.RelayMode {
+		log.Printf("  Relay Mode: on (teams of %d)", config.RelayTeamSize)
+	}
+	if cfg.RaceMode {
+		log.Printf("  Race Mode: on (%d laps)", config.RaceLaps)
+	}
+	if cfg.Collisions != "on" {
+		log.Printf("  Collisions: %s", cfg.Collisions)
+	}
+	if !cfg.ClientTelemetry {
+		log.Printf("  Client Telemetry: off")
+	}
 	log.Printf("=================================")
 
+	// Shut down cleanly on SIGINT/SIGTERM
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		sig := <-signals
+		if sig == syscall.SIGTERM && cfg.DrainTimeout > 0 {
+			log.Printf("Received %v, draining for up to %v", sig, cfg.DrainTimeout)
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.DrainTimeout)
+			go func() {
+				// A second signal cuts the drain short
+				select {
+				case <-signals:
+				case <-ctx.Done():
+				}
+				cancel()
+			}()
+			server.Drain(ctx)
+			cancel()
+		}
+		log.Printf("Received %v, shutting down", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Shutdown: %v", err)
+		}
+	}()
+
 	// Start the server (blocks until error or shutdown)
 	if err := server.Start(); err != nil {
 		log.Fatalf("Server error: %v", err)
 	}
+	<-stopped
+	log.Printf("Server stopped")
 }
 
 // loadConfig reads configuration from environment variables.
@@ -100,74 +312,576 @@ func loadConfig() *config.ServerConfig {
 		cfg.EnableCORS = false
 	}
 
+	// Proxy headers must not be trusted when clients connect directly
+	if trust := os.Getenv("TRUST_PROXY_HEADERS"); trust == "true" {
+		cfg.TrustProxyHeaders = true
+	}
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		cfg.TrustedProxies = proxies
+	}
+
+	cfg.AdminToken = os.Getenv("ADMIN_TOKEN")
+	cfg.ModerationRulesFile = os.Getenv("MODERATION_RULES_FILE")
+	cfg.ModerationAPIURL = os.Getenv("MODERATION_API_URL")
+	cfg.BotProfilesFile = os.Getenv("BOT_PROFILES_FILE")
+	cfg.TenantsFile = os.Getenv("TENANTS_FILE")
+	cfg.RulesDir = os.Getenv("RULES_DIR")
+	cfg.RoomRules = os.Getenv("ROOM_RULES")
+	cfg.ScoringPolicy = os.Getenv("SCORING_POLICY")
+	for _, name := range strings.Split(os.Getenv("ANTICHEAT_RULES"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			cfg.AntiCheatRules = append(cfg.AntiCheatRules, name)
+		}
+	}
+
+	if n, err := strconv.Atoi(os.Getenv("MAX_CONNECTIONS")); err == nil && n > 0 {
+		cfg.MaxConnections = n
+	}
+
+	if dir := os.Getenv("DATA_DIR"); dir != "" {
+		cfg.DataDir = dir
+	}
+
+	if backend := os.Getenv("STORE_BACKEND"); backend != "" {
+		cfg.StoreBackend = backend
+	}
+	cfg.StoreURL = os.Getenv("STORE_URL")
+	if d, err := time.ParseDuration(os.Getenv("STORE_FAULT_DELAY")); err == nil && d >= 0 {
+		cfg.StoreFaultDelay = d
+	}
+	cfg.TokenSecret = os.Getenv("TOKEN_SECRET")
+	cfg.ResultsSigningKey = os.Getenv("RESULTS_SIGNING_KEY")
+	cfg.PayloadKey = os.Getenv("PAYLOAD_KEY")
+	if required := os.Getenv("PAYLOAD_REQUIRED"); required == "true" {
+		cfg.PayloadRequired = true
+	}
+
+	if n, err := strconv.Atoi(os.Getenv("PHYSICS_TICK_RATE")); err == nil {
+		cfg.PhysicsTickRate = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("BROADCAST_RATE")); err == nil {
+		cfg.BroadcastRate = n
+	}
+
+	if d, err := strconv.ParseFloat(os.Getenv("WORLD_REBASE_DISTANCE"), 64); err == nil {
+		cfg.RebaseDistance = d
+	}
+	if n, err := strconv.Atoi(os.Getenv("SPECTATOR_DELAY")); err == nil {
+		cfg.SpectatorDelay = n
+	}
+	if fuel := os.Getenv("FUEL_MODE"); fuel == "true" {
+		cfg.FuelMode = true
+	}
+	if relay := os.Getenv("RELAY_MODE"); relay == "true" {
+		cfg.RelayMode = true
+	}
+	if race := os.Getenv("RACE_MODE"); race == "true" {
+		cfg.RaceMode = true
+	}
+	if collisions := os.Getenv("COLLISIONS"); collisions != "" {
+		cfg.Collisions = collisions
+	}
+
+	if suspend := os.Getenv("SUSPEND_EMPTY_ROOMS"); suspend == "false" {
+		cfg.SuspendEmptyRooms = false
+	}
+	if n, err := strconv.Atoi(os.Getenv("SIM_WORKERS")); err == nil && n >= 0 {
+		cfg.SimulationWorkers = n
+	}
+	if mode := os.Getenv("INPUT_SEQUENCE_MODE"); mode != "" {
+		cfg.InputSequenceMode = mode
+	}
+	if policy := os.Getenv("ANTICHEAT_POLICY"); policy != "" {
+		cfg.AntiCheatPolicy = policy
+	}
+	if telemetry := os.Getenv("CLIENT_TELEMETRY"); telemetry == "false" {
+		cfg.ClientTelemetry = false
+	}
+	if key := os.Getenv("TELEMETRY_KEY"); key != "" {
+		cfg.TelemetryKey = key
+	}
+	if policy := os.Getenv("SESSION_POLICY"); policy != "" {
+		cfg.SessionPolicy = policy
+	}
+	if codec := os.Getenv("STATE_CODEC"); codec != "" {
+		cfg.StateCodec = codec
+	}
+
+	cfg.CrashReportDSN = os.Getenv("CRASH_REPORT_DSN")
+	cfg.CrashReportFile = os.Getenv("CRASH_REPORT_FILE")
+
+	cfg.MessagePriorities = os.Getenv("MESSAGE_PRIORITIES")
+
+	// Optional push of the metrics to a statsd agent
+	cfg.StatsdAddr = os.Getenv("STATSD_ADDR")
+	if prefix, ok := os.LookupEnv("STATSD_PREFIX"); ok {
+		cfg.StatsdPrefix = prefix
+	}
+	for _, tag := range strings.Split(os.Getenv("STATSD_TAGS"), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			cfg.StatsdTags = append(cfg.StatsdTags, tag)
+		}
+	}
+	if d, err := time.ParseDuration(os.Getenv("STATSD_INTERVAL")); err == nil && d > 0 {
+		cfg.StatsdInterval = d
+	}
+
+	// Daily report (see report.go)
+	cfg.DailyReportWebhook = os.Getenv("DAILY_REPORT_WEBHOOK")
+
+	// Rooms the frame-budget watchdog quarantines
+	cfg.QuarantineWebhook = os.Getenv("QUARANTINE_WEBHOOK")
+	cfg.QuarantineTraceDir = os.Getenv("QUARANTINE_TRACE_DIR")
+	if migrate := os.Getenv("QUARANTINE_MIGRATE"); migrate == "true" {
+		cfg.QuarantineMigrate = true
+	}
+
+	// Optional join queue while the server is at capacity
+	if n, err := strconv.Atoi(os.Getenv("JOIN_QUEUE_LENGTH")); err == nil && n >= 0 {
+		cfg.JoinQueueLength = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("JOIN_QUEUE_TIMEOUT")); err == nil && d > 0 {
+		cfg.JoinQueueTimeout = d
+	}
+
+	if d, err := time.ParseDuration(os.Getenv("HIBERNATE_AFTER")); err == nil && d >= 0 {
+		cfg.HibernateAfter = d
+	}
+
+	// Agones sidecar (the port is set by Agones on the game server container)
+	if enabled := os.Getenv("AGONES_ENABLED"); enabled == "true" {
+		cfg.Agones = true
+	}
+	if n, err := strconv.Atoi(os.Getenv("AGONES_SDK_HTTP_PORT")); err == nil && n > 0 {
+		cfg.AgonesPort = n
+	}
+
+	// Orchestration: instance identity, public address and SIGTERM drain
+	cfg.InstanceID = os.Getenv("INSTANCE_ID")
+	if cfg.InstanceID == "" {
+		cfg.InstanceID = defaultInstanceID()
+	}
+	cfg.PublicAddr = os.Getenv("PUBLIC_ADDR")
+	if d, err := time.ParseDuration(os.Getenv("DRAIN_TIMEOUT")); err == nil && d >= 0 {
+		cfg.DrainTimeout = d
+	}
+	if n, err := strconv.Atoi(os.Getenv("AUTOSCALE_TARGET")); err == nil {
+		cfg.AutoscaleTarget = float64(n) / 100
+	}
+	if n, err := strconv.Atoi(os.Getenv("AUTOSCALE_MIN")); err == nil {
+		cfg.AutoscaleMin = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("AUTOSCALE_MAX")); err == nil {
+		cfg.AutoscaleMax = n
+	}
+
+	cfg.Console = os.Getenv("CONSOLE")
+	if selfTest := os.Getenv("SELF_TEST"); selfTest == "false" {
+		cfg.SelfTest = false
+	}
+
 	return cfg
 }
 
 // NewGameServer creates and initializes a new game server instance.
 func NewGameServer(cfg *config.ServerConfig) *GameServer {
-	return &GameServer{
-		config:     cfg,
-		matchmaker: matchmaker.NewMatchmaker(),
-		protocol:   network.NewProtocol(),
+	s := &GameServer{
+		config:   cfg,
+		protocol: network.NewProtocol(),
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
+			Subprotocols:    network.Subprotocols,
+			// See statecodec.go
+			EnableCompression: cfg.StateCodec == stateCodecDeflate,
 			// CheckOrigin controls CORS for WebSocket connections.
 			// In production, consider implementing a whitelist of allowed origins.
 			CheckOrigin: func(r *http.Request) bool {
 				return cfg.EnableCORS
 			},
 		},
-		connections: make(map[*ClientConnection]bool),
+		connections:  make(map[*ClientConnection]bool),
+		submits:      newSubmitLimiter(),
+		penalties:    moderation.NewPenaltyStore(config.KickCooldownBase, config.KickCooldownMax, config.KickOffenseDecay),
+		fingerprints: moderation.NewFingerprintStore(config.FingerprintThreshold, config.FingerprintRetention, config.FingerprintMaxRecords),
+		queueWake:    make(chan struct{}, 1),
+		profiles:     newProfileCache(),
+		quit:         make(chan struct{}),
+	}
+
+	// Name/chat moderation: rules file plus optional external API
+	var external moderation.Policy
+	if cfg.ModerationAPIURL != "" {
+		external = moderation.NewExternalPolicy(cfg.ModerationAPIURL, 500*time.Millisecond, 10*time.Minute, 10000)
+	}
+	s.moderator = moderation.NewModerator(external)
+	if cfg.ModerationRulesFile != "" {
+		rules, err := moderation.LoadRules(cfg.ModerationRulesFile)
+		if err == nil {
+			err = s.moderator.SetRules(rules)
+		}
+		if err != nil {
+			log.Printf("Failed to load moderation rules: %v", err)
+		}
+	}
+
+	// Bot personalities: built-in defaults, optionally extended from a file
+	s.botProfiles = game.DefaultBotProfiles
+	if cfg.BotProfilesFile != "" {
+		profiles, err := game.LoadBotProfiles(cfg.BotProfilesFile)
+		if err != nil {
+			log.Printf("Failed to load bot profiles: %v", err)
+		} else {
+			s.botProfiles = profiles
+		}
+	}
+
+	// Shared state: in memory when standalone, Redis/SQL when clustered
+	store, err := plugin.OpenStore(cfg.StoreBackend, cfg.StoreURL)
+	if err != nil {
+		log.Fatalf("Failed to open %s store: %v", cfg.StoreBackend, err)
+	}
+	if cfg.StoreFaultDelay > 0 {
+		log.Printf("STORE_FAULT_DELAY: every store call is slowed down by %v", cfg.StoreFaultDelay)
+		store = storage.NewSlowStore(store, cfg.StoreFaultDelay)
+	}
+	s.store = store
+
+	// Tokens are verifiable on any server sharing the secret and store
+	secret := []byte(cfg.TokenSecret)
+	if len(secret) == 0 {
+		if cfg.StoreBackend != storage.BackendMemory {
+			log.Printf("TOKEN_SECRET not set: tokens will only be valid on this server")
+		}
+		secret = token.RandomSecret()
+	}
+	s.tokens = token.NewService(secret, store)
+	s.signer = newSigner(cfg)
+	s.payloadKey = newPayloadKey(cfg)
+	s.priorities = newPriorities(cfg)
+	s.proxies = newTrustedProxies(cfg)
+	s.tracks = track.NewRegistry(store)
+	s.history = history.New(store)
+	s.history.SetSigner(s.signer)
+	s.inventory = cosmetics.NewInventory(store)
+	s.history.SetOnFlag(s.onPlacementFlag)
+	if s.outbox, err = s.openOutbox(); err != nil {
+		log.Fatalf("Failed to open outbox: %v", err)
+	}
+	s.writer = newStoreWriter()
+	s.directory = cluster.New(store, config.DirectoryTTL)
+	s.roomRegistry = cluster.NewRooms(store, cfg.InstanceID, config.DirectoryTTL)
+	if cfg.Agones {
+		s.agones = agones.New(cfg.AgonesPort)
+	}
+
+	// Rules scripts public rooms can run (see internal/rules), behind the
+	// game modes of plugins
+	lib := &rules.Library{}
+	if cfg.RulesDir != "" {
+		lib, err = rules.LoadDir(cfg.RulesDir)
+		if err != nil {
+			log.Fatalf("Failed to load rules: %v", err)
+		}
+		log.Printf("Loaded rules: %v", lib.Names())
+	}
+	s.rules = plugin.Modes(lib)
+
+	// Extensions compiled in (see plugins.go), used where configured
+	for _, ext := range plugin.Extensions() {
+		log.Printf("Plugin %s: %s", ext.Kind, ext.Name)
+	}
+	if cfg.ScoringPolicy != "" {
+		policy, ok := plugin.LookupScoringPolicy(cfg.ScoringPolicy)
+		if !ok {
+			log.Fatalf("Unknown SCORING_POLICY %q", cfg.ScoringPolicy)
+		}
+		s.scoring = policy
+	}
+	if s.antiCheat, err = plugin.AntiCheatRules(cfg.AntiCheatRules); err != nil {
+		log.Fatalf("Invalid ANTICHEAT_RULES: %v", err)
+	}
+
+	// Room physics of all tenants shares one worker pool
+	s.scheduler = game.NewScheduler(cfg.SimulationWorkers)
+
+	// Matchmaking pools and leaderboards: the default tenant, configured
+	// through the environment, plus those of the tenants file
+	def := s.newTenant("", "", game.RoomConfig{})
+	s.matchmaker = def.matchmaker
+	s.queue = def.queue
+	s.leaderboard = def.leaderboard
+	s.tenants = map[string]*tenant{"": def}
+	if cfg.TenantsFile != "" {
+		tenants, err := loadTenants(cfg.TenantsFile)
+		if err != nil {
+			log.Fatalf("Failed to load tenants: %v", err)
+		}
+		for _, tc := range tenants {
+			s.tenants[tc.Key] = s.newTenant(tc.Key, tc.Name, tc.RoomConfig)
+		}
+	}
+
+	return s
+}
+
+// onSeasonRewards grants the rewards of a tenant's finished season: each
+// goes to the player's profile, through the outbox.
+func (s *GameServer) onSeasonRewards(tenant string, season leaderboard.Season, rewards []leaderboard.Reward) {
+	for _, r := range rewards {
+		log.Printf("Season %d reward: #%d %s -> %s", season.ID, r.Rank, r.Name, r.Award)
+	}
+	if err := s.outbox.Do(outboxRewards, "", rewardsWrite{Tenant: tenant, Season: season.ID, Rewards: rewards}); err != nil {
+		log.Printf("Failed to grant season %d rewards: %v", season.ID, err)
+	}
+}
+
+// wakeQueue lets the join queue know that a slot may have freed up.
+func (s *GameServer) wakeQueue() {
+	select {
+	case s.queueWake <- struct{}{}:
+	default:
 	}
 }
 
+// onPlayerKick records a rejoin cooldown for the kicked player's address.
+func (s *GameServer) onPlayerKick(player *game.Player, reason string) {
+	s.metrics.kicks.Add(1)
+	conn, ok := player.Connection.(*ClientConnection)
+	if !ok {
+		return
+	}
+
+	cooldown := s.penalties.RecordKick(conn.info.IP)
+	log.Printf("Rejoin cooldown for %s: %v (%s)", conn.info, cooldown, reason)
+	s.fingerprints.RecordKick(moderation.FingerprintRecord{
+		Name:        player.GetName(),
+		IP:          conn.info.IP,
+		Reason:      reason,
+		Kicked:      time.Now(),
+		Fingerprint: conn.fingerprint.Fingerprint(),
+	})
+
+	// Recorded even if the player disconnects first, so not under the
+	// connection's context
+	w := kickWrite{Name: player.GetName(), Reason: reason, Cooldown: cooldown, At: time.Now()}
+	s.writer.submit(outboxKick, func() error { return s.outbox.Do(outboxKick, "", w) })
+}
+
 // Start begins listening for connections and runs background tasks.
 // This method blocks until the server is shut down.
 func (s *GameServer) Start() error {
+	handler, err := s.run()
+	if err != nil {
+		return err
+	}
+
+	// Start HTTP server
+	addr := fmt.Sprintf("%s:%d", s.config.Host, s.config.Port)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("Server listening on %s", addr)
+
+	// Background task: Report to Agones once connections are accepted
+	if s.agones != nil {
+		routines.Go("server.agones", s.agonesLoop)
+	}
+
+	s.httpServer = &http.Server{Addr: addr, Handler: handler}
+	err = s.httpServer.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// run starts the background tasks and returns the handler of the HTTP
+// endpoints, which Start serves (and tests, with httptest)
+func (s *GameServer) run() (http.Handler, error) {
+	// Background tasks run until Shutdown closes s.quit.
+	// They are started through routines.Go so /stats can account for them.
+
 	// Background task: Clean up empty rooms every 30 seconds
 	// This prevents memory leaks from abandoned rooms
-	go func() {
+	routines.Go("server.cleanup", func() {
 		ticker := time.NewTicker(30 * time.Second)
 		defer ticker.Stop()
 
-		for range ticker.C {
-			removed := s.matchmaker.CleanupEmptyRooms()
+		for s.wait(ticker, 30*time.Second) {
+			removed := 0
+			for _, t := range s.tenants {
+				removed += t.matchmaker.CleanupEmptyRooms()
+			}
 			if removed > 0 {
 				log.Printf("Cleaned up %d empty rooms", removed)
 			}
+			s.penalties.Sweep()
+			s.fingerprints.Sweep()
+			s.submits.sweep()
+			s.events.sweep(func(id string) bool {
+				_, room := s.findRoom(id)
+				return room != nil
+			})
+			if store, ok := s.store.(storage.Sweeper); ok {
+				store.Sweep()
+			}
 		}
-	}()
+	})
 
 	// Background task: Log server statistics every 5 minutes (only when active)
-	go func() {
+	routines.Go("server.stats", func() {
 		ticker := time.NewTicker(5 * time.Minute)
 		defer ticker.Stop()
 
-		for range ticker.C {
-			stats := s.matchmaker.GetStats()
+		for s.wait(ticker, 5*time.Minute) {
+			stats := s.roomStats()
 			if stats.TotalRooms > 0 || stats.TotalPlayers > 0 {
 				log.Printf("Stats: %d rooms, %d total players", stats.TotalRooms, stats.TotalPlayers)
 			}
+			for _, room := range stats.Rooms {
+				if room.Quality.Flagged {
+					log.Printf("Room %s flagged (%s): %.0f fps, %.0fms jitter over %d clients",
+						room.ID, room.Quality.Reason, room.Quality.AvgFPS, room.Quality.AvgJitterMS, room.Quality.Samples)
+				}
+			}
 		}
-	}()
+	})
+
+	// Background task: Persist the leaderboards and roll seasons over
+	routines.Go("server.leaderboard", func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for s.wait(ticker, time.Minute) {
+			s.tickLeaderboards()
+		}
+	})
+
+	// Background task: Make the store writes rooms hand off
+	s.writer.start(s.quit)
+
+	// Background task: Retry the store writes that failed
+	routines.Go("server.outbox", s.replayOutbox)
+
+	// Background task: Sample the load for the trends of /stats
+	routines.Go("server.trends", s.sampleTrends)
+
+	// Background task: Push the metrics to a statsd agent
+	if s.config.StatsdAddr != "" {
+		routines.Go("server.statsd", s.pushStatsd)
+	}
+
+	// Background task: Keep this server listed in the cluster directory
+	routines.Go("server.directory", s.directoryLoop)
+
+	// Background task: Admit queued joins as capacity frees up
+	if s.queue.Enabled() {
+		routines.Go("server.queue", func() {
+			ticker := time.NewTicker(time.Second)
+			defer ticker.Stop()
+
+			for s.pause(ticker, time.Second) {
+				select {
+				case <-s.quit:
+					return
+				case <-ticker.C:
+				case <-s.queueWake:
+				}
+				for _, t := range s.tenants {
+					t.queue.Process(t.matchmaker, time.Now())
+				}
+			}
+		})
+	}
 
 	// Register HTTP endpoints
-	http.HandleFunc("/ws", s.handleWebSocket)       // WebSocket game connections
-	http.HandleFunc("/health", s.handleHealth)      // Health check for load balancers
-	http.HandleFunc("/stats", s.handleStats)        // Server statistics endpoint
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", s.handleWebSocket)               // WebSocket game connections
+	mux.HandleFunc("/health", s.handleHealth)              // Health check for load balancers
+	mux.HandleFunc("/stats", s.handleStats)                // Server statistics endpoint
+	mux.HandleFunc("/stats/history", s.handleStatsHistory) // Load samples of the last hour
+	mux.HandleFunc("/autoscale", s.handleAutoscale)        // Scaling signal of the cluster
+	s.registerAPIRoutes(mux)                               // Public leaderboard and track API
+	s.registerAdminRoutes(mux)                             // Operator API (if ADMIN_TOKEN set)
 
-	// Start HTTP server
-	addr := fmt.Sprintf("%s:%d", s.config.Host, s.config.Port)
-	log.Printf("Server listening on %s", addr)
+	// Hibernate if nobody connects (HIBERNATE_AFTER)
+	s.scheduleHibernation()
 
-	return http.ListenAndServe(addr, nil)
+	// Operator console (CONSOLE)
+	if s.config.Console != "" {
+		if err := s.startConsole(); err != nil {
+			return nil, fmt.Errorf("console: %w", err)
+		}
+	}
+	return mux, nil
+}
+
+// wait blocks until the ticker fires (true) or the server shuts down (false).
+// While the server hibernates, the ticker is stopped until it wakes up.
+func (s *GameServer) wait(ticker *time.Ticker, period time.Duration) bool {
+	if !s.pause(ticker, period) {
+		return false
+	}
+	select {
+	case <-s.quit:
+		return false
+	case <-ticker.C:
+		return true
+	}
+}
+
+// Shutdown stops accepting connections, stops the background tasks, closes
+// all client connections and stops every room. Safe to call more than once.
+func (s *GameServer) Shutdown(ctx context.Context) error {
+	var err error
+	s.shutdownOnce.Do(func() {
+		close(s.quit)
+		s.closeConsole()
+		s.agents.closeAll()
+		if s.httpServer != nil {
+			err = s.httpServer.Shutdown(ctx)
+		}
+
+		// WebSockets are hijacked, so the HTTP server doesn't close them.
+		// Closing cancels their contexts and ends both pumps, whose cleanup
+		// removes the player.
+		s.connMu.Lock()
+		conns := make([]*ClientConnection, 0, len(s.connections))
+		for conn := range s.connections {
+			conns = append(conns, conn)
+		}
+		s.connMu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+
+		s.stopRooms()
+		s.scheduler.Stop()
+		s.writer.drain()
+		s.tickLeaderboards()
+		s.saveDailyReport()
+		if err := s.directory.Remove(s.config.InstanceID); err != nil {
+			log.Printf("Failed to leave the cluster directory: %v", err)
+		}
+		if err := s.roomRegistry.Clear(); err != nil {
+			log.Printf("Failed to leave the room registry: %v", err)
+		}
+	})
+	return err
 }
 
 // handleHealth responds to health check requests.
 // Used by load balancers and container orchestrators (Docker, Kubernetes).
 func (s *GameServer) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+	if s.draining.Load() {
+		// Take the server out of the load balancer while it drains
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"status":"draining"}`))
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(`{"status":"ok"}`))
 }
@@ -175,68 +889,259 @@ func (s *GameServer) handleHealth(w http.ResponseWriter, r *http.Request) {
 // handleStats returns current server statistics as JSON.
 // Useful for monitoring dashboards.
 func (s *GameServer) handleStats(w http.ResponseWriter, r *http.Request) {
-	stats := s.matchmaker.GetStats()
+	stats := s.roomStats()
+
+	flaggedRooms := make([]map[string]interface{}, 0)
+	for _, room := range stats.Rooms {
+		if room.Quality.Flagged {
+			flaggedRooms = append(flaggedRooms, map[string]interface{}{
+				"id":             room.ID,
+				"reason":         room.Quality.Reason,
+				"avgFps":         room.Quality.AvgFPS,
+				"avgJitter":      room.Quality.AvgJitterMS,
+				"avgInterpDelay": room.Quality.AvgInterpDelayMS,
+				"samples":        room.Quality.Samples,
+			})
+		}
+	}
+
+	latency := make([]map[string]interface{}, 0, len(stats.Rooms))
+	for _, room := range stats.Rooms {
+		players := make([]map[string]interface{}, 0, len(room.Latency.Players))
+		for _, p := range room.Latency.Players {
+			players = append(players, map[string]interface{}{
+				"id":    p.ID,
+				"name":  p.Name,
+				"rttMs": p.RTT.Milliseconds(),
+			})
+		}
+		latency = append(latency, map[string]interface{}{
+			"room":     room.ID,
+			"avgRttMs": room.Latency.Avg.Milliseconds(),
+			"maxRttMs": room.Latency.Max.Milliseconds(),
+			"players":  players,
+		})
+	}
+
+	hibernating, _ := s.hibernations()
+
+	fields := statsFields{
+		"instance":      s.config.InstanceID,
+		"publicAddr":    s.config.PublicAddr,
+		"draining":      s.draining.Load(),
+		"reservedRooms": s.reservedRooms(),
+		"tenants":       s.tenantStats(),
+		"flaggedRooms":  flaggedRooms,
+		"latency":       latency,
+		"sizes":         s.sizes(stats),
+		"goroutines":    routines.Snapshot(),
+		"storeWriter":   s.writer.stats(),
+		"simulation":    s.scheduler.Stats(),
+		"inputSequence": map[string]interface{}{
+			"mode":       s.config.InputSequenceMode,
+			"inputs":     stats.Sequence.Inputs,
+			"gaps":       stats.Sequence.Gaps,
+			"duplicates": stats.Sequence.Duplicates,
+			"reordered":  stats.Sequence.Reordered,
+			"dropped":    stats.Sequence.Dropped,
+			"kicks":      stats.Sequence.Kicks,
+		},
+		"hibernating": hibernating,
+		"trends":      s.trends.windows(s.trendSample()),
+	}
+	s.reportMetrics(fields, stats)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, `{"rooms":%d,"players":%d}`, stats.TotalRooms, stats.TotalPlayers)
+	json.NewEncoder(w).Encode(fields)
+}
+
+// sizes reports the sizes of long-lived maps and queues, to spot leaks.
+func (s *GameServer) sizes(stats matchmaker.MatchmakerStats) map[string]int {
+	gridCells := 0
+	for _, room := range stats.Rooms {
+		gridCells += room.GridCells
+	}
+	leaderboardEntries, queuedJoins := 0, 0
+	for _, t := range s.tenants {
+		leaderboardEntries += t.leaderboard.Len()
+		queuedJoins += t.queue.Len()
+	}
+
+	return map[string]int{
+		"connections":        s.connectionCount(),
+		"penaltyEntries":     s.penalties.Len(),
+		"fingerprints":       s.fingerprints.Len(),
+		"leaderboardEntries": leaderboardEntries,
+		"queuedJoins":        queuedJoins,
+		"gridCells":          gridCells,
+		"cachedProfiles":     s.profiles.len(),
+	}
 }
 
 // handleWebSocket upgrades HTTP connections to WebSocket and manages client lifecycle.
 // Each client gets two goroutines: one for reading, one for writing.
 func (s *GameServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	// A hibernating server resumes before the upgrade
+	s.wakeUp()
+
+	// Refuse new connections while draining or at the limit before paying
+	// for the upgrade
+	if s.draining.Load() {
+		http.Error(w, "server draining", http.StatusServiceUnavailable)
+		return
+	}
+	if s.connectionCount() >= s.config.MaxConnections {
+		http.Error(w, "too many connections", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Clients name their tenant; players of an allocated match present
+	// their ticket and play in the tenant of the reserved room
+	t := s.tenantOf(r)
+	if t == nil {
+		http.Error(w, "tenant not found", http.StatusNotFound)
+		s.scheduleHibernation()
+		return
+	}
+	reserved, err := s.ticketRoom(r)
+	if err != nil {
+		http.Error(w, "invalid ticket: "+err.Error(), http.StatusForbidden)
+		s.scheduleHibernation()
+		return
+	}
+	if rt, _ := s.findRoom(reserved); rt != nil {
+		t = rt
+	}
+	spectate, err := s.spectateRoom(r)
+	if err != nil {
+		http.Error(w, "invalid spectate token: "+err.Error(), http.StatusForbidden)
+		s.scheduleHibernation()
+		return
+	}
+	if rt, _ := s.findRoom(spectate); rt != nil {
+		t = rt
+	}
+	sessionKey, resume, err := s.sessionOf(r)
+	if err != nil {
+		http.Error(w, "invalid session token: "+err.Error(), http.StatusForbidden)
+		s.scheduleHibernation()
+		return
+	}
+	payload, status, err := s.payloadCipherOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		s.scheduleHibernation()
+		return
+	}
+	if rt, _ := s.findRoom(resume); rt != nil && reserved == "" && spectate == "" {
+		t = rt
+	}
+
+	// Clients that ask for subprotocols must share one with the server;
+	// clients that don't ask speak the binary protocol
+	if requested := websocket.Subprotocols(r); len(requested) > 0 && !supportsSubprotocol(requested) {
+		http.Error(w, "unsupported subprotocol: this server speaks "+strings.Join(network.Subprotocols, ", "), http.StatusBadRequest)
+		s.scheduleHibernation()
+		return
+	}
+
 	// Upgrade HTTP connection to WebSocket
 	ws, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade failed: %v", err)
+		s.scheduleHibernation()
 		return
 	}
+	codec, _ := network.CodecFor(ws.Subprotocol())
+	if payload != nil {
+		codec = network.EncryptedCodec(codec, payload)
+		s.metrics.encryptedConns.Add(1)
+	}
+	info := network.NewConnInfo(s.clientIP(r), ws.Subprotocol(), r.Header.Get("Accept-Language"))
+	ctx, cancel := context.WithCancel(network.WithConnInfo(context.Background(), info))
 
-	// Create new client connection with buffered send channel
-	// Buffer size of 256 prevents blocking on slow clients
+	// Create new client connection with its outbound queues
 	conn := &ClientConnection{
-		ws:       ws,
-		server:   s,
-		sendChan: make(chan []byte, 256),
-		done:     make(chan struct{}),
+		ws:            ws,
+		server:        s,
+		out:           newOutQueue(s.priorities),
+		ctx:           ctx,
+		cancel:        cancel,
+		info:          info,
+		reserved:      reserved,
+		spectate:      spectate,
+		sessionKey:    sessionKey,
+		resume:        resume,
+		tenant:        t,
+		codec:         codec,
+		encrypted:     payload != nil,
+		limiter:       network.NewRateLimiter(config.InboundMessageRate, config.InboundMessageBurst),
+		classLimiters: newRateLimiters(),
+		fingerprint:   moderation.NewFingerprintSampler(r.Header, time.Now(), config.FingerprintInputs),
 	}
 
 	// Track connection (for future features like broadcasting to all)
+	s.connMu.Lock()
 	s.connections[conn] = true
+	s.connMu.Unlock()
 
-	log.Printf("New connection from %s", ws.RemoteAddr())
+	log.Printf("New connection %s", info)
 
 	// Start read and write goroutines
 	// These run until the connection is closed
-	go conn.writePump()
-	go conn.readPump()
+	routines.Go("conn.write", conn.writePump)
+	routines.Go("conn.read", conn.readPump)
+}
+
+// supportsSubprotocol reports whether the server speaks one of the requested
+// subprotocols.
+func supportsSubprotocol(requested []string) bool {
+	for _, p := range requested {
+		if _, ok := network.CodecFor(p); ok && p != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// connectionCount returns the number of open client connections.
+func (s *GameServer) connectionCount() int {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+
+	return len(s.connections)
 }
 
 // Send queues data to be sent to the client.
-// Non-blocking: drops message if buffer is full (prevents slow clients from blocking server).
+// Non-blocking: a slow client loses its oldest state or cosmetic messages,
+// or the connection if events pile up (see outqueue.go).
 func (c *ClientConnection) Send(data []byte) error {
-	select {
-	case c.sendChan <- data:
-		return nil
-	case <-c.done:
+	if c.ctx.Err() != nil {
 		return fmt.Errorf("connection closed")
-	default:
-		// Buffer full - drop message to prevent blocking
-		// This is acceptable for game state updates (client will get next update)
-		return nil
 	}
+	if len(data) >= 3 && data[0] == network.MsgTypeError {
+		c.server.daily.countError(data[1], string(data[3:]))
+	}
+	dropped, err := c.out.Push(data)
+	if dropped {
+		c.server.metrics.messagesShed.Add(1)
+	}
+	if err != nil {
+		c.closeSlow(err)
+		return err
+	}
+	return nil
 }
 
-// Close gracefully shuts down the connection.
+// Close gracefully shuts down the connection, cancelling its context.
 // Safe to call multiple times.
 func (c *ClientConnection) Close() error {
-	select {
-	case <-c.done:
+	if c.ctx.Err() != nil {
 		// Already closed
 		return nil
-	default:
-		close(c.done)
 	}
+	c.cancel()
 	return c.ws.Close()
 }
 
@@ -245,32 +1150,90 @@ func (c *ClientConnection) RemoteAddr() string {
 	return c.ws.RemoteAddr().String()
 }
 
+// ProtocolVersion returns the negotiated protocol version.
+func (c *ClientConnection) ProtocolVersion() uint8 {
+	return c.info.Version()
+}
+
+// RTT returns the smoothed round-trip time (0 until the first pong).
+func (c *ClientConnection) RTT() time.Duration {
+	return time.Duration(c.srtt.Load())
+}
+
+// RTTVar returns the round-trip time variation.
+func (c *ClientConnection) RTTVar() time.Duration {
+	return time.Duration(c.rttVar.Load())
+}
+
+// observeRTT folds a round-trip sample into the smoothed estimate.
+// Only called from readPump (the pong handler), so plain load/store is safe.
+func (c *ClientConnection) observeRTT(sample time.Duration) {
+	srtt := time.Duration(c.srtt.Load())
+	if srtt == 0 {
+		c.srtt.Store(int64(sample))
+		c.rttVar.Store(int64(sample / 2))
+		return
+	}
+
+	diff := srtt - sample
+	if diff < 0 {
+		diff = -diff
+	}
+	rttVar := time.Duration(c.rttVar.Load())
+	c.rttVar.Store(int64(rttVar - rttVar/4 + diff/4))
+	c.srtt.Store(int64(srtt - srtt/8 + sample/8))
+}
+
 // writePump handles sending messages to the client.
-// Runs in its own goroutine. Also sends periodic pings to detect dead connections.
+// Runs in its own goroutine. Also sends periodic pings to detect dead connections
+// and measure round-trip time.
 func (c *ClientConnection) writePump() {
-	// Ping every 30 seconds to keep connection alive and detect disconnects
-	ticker := time.NewTicker(30 * time.Second)
+	// Ping frames carry the send time so the pong handler can measure RTT
+	ticker := time.NewTicker(config.RTTPingInterval)
 	defer ticker.Stop()
+	defer c.netsim.stop()
 	defer c.cleanup()
 
 	for {
 		select {
-		case <-c.done:
+		case <-c.ctx.Done():
 			return
 
-		case message := <-c.sendChan:
-			// Set write deadline to prevent hanging on slow/dead connections
-			c.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if err := c.ws.WriteMessage(websocket.BinaryMessage, message); err != nil {
+		case <-c.out.Ready():
+			message, ok := c.out.Pop()
+			if !ok {
+				continue
+			}
+			frameType, frame, err := c.codec.Encode(c.ProtocolVersion(), message)
+			if err != nil {
+				log.Printf("Failed to encode a message for %s: %v", c.info, err)
+				continue
+			}
+			if c.netsim.hold(frameType, frame, message[0] == network.MsgTypeStateUpdate, time.Now()) {
+				continue
+			}
+			if err := c.writeFrame(frameType, frame); err != nil {
 				return
 			}
 
 		case <-ticker.C:
-			// Send WebSocket ping frame
-			c.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if err := c.ws.WriteMessage(websocket.PingMessage, nil); err != nil {
+			// Send WebSocket ping frame with the current time as payload
+			now := time.Now()
+			payload := make([]byte, 8)
+			binary.LittleEndian.PutUint64(payload, uint64(now.UnixNano()))
+			if c.netsim.hold(websocket.PingMessage, payload, false, now) {
+				continue
+			}
+			if err := c.writeFrame(websocket.PingMessage, payload); err != nil {
 				return
 			}
+
+		case now := <-c.netsim.due():
+			for _, f := range c.netsim.release(now) {
+				if err := c.writeFrame(f.frameType, f.data); err != nil {
+					return
+				}
+			}
 		}
 	}
 }
@@ -280,38 +1243,82 @@ func (c *ClientConnection) writePump() {
 func (c *ClientConnection) readPump() {
 	defer c.cleanup()
 
-	// Limit message size to prevent memory exhaustion attacks
-	c.ws.SetReadLimit(512)
+	// Limit message size to prevent memory exhaustion attacks.
+	// Per-type limits are enforced in handleMessage; sealing a frame adds
+	// to it.
+	limit := network.MaxMessageSize
+	if c.encrypted {
+		limit += network.PayloadOverhead
+	}
+	c.ws.SetReadLimit(int64(limit))
 	// Set initial read deadline (extended on each pong)
 	c.ws.SetReadDeadline(time.Now().Add(60 * time.Second))
-	// Handle pong messages by extending the read deadline
-	c.ws.SetPongHandler(func(string) error {
-		c.ws.SetReadDeadline(time.Now().Add(60 * time.Second))
+	// Handle pong messages by extending the read deadline and measuring RTT
+	c.ws.SetPongHandler(func(appData string) error {
+		now := time.Now()
+		c.ws.SetReadDeadline(now.Add(60 * time.Second))
+		if len(appData) == 8 {
+			sent := time.Unix(0, int64(binary.LittleEndian.Uint64([]byte(appData))))
+			if rtt := now.Sub(sent); rtt > 0 {
+				c.observeRTT(rtt)
+			}
+		}
 		return nil
 	})
 
 	// Main read loop
 	for {
-		select {
-		case <-c.done:
+		if c.ctx.Err() != nil {
 			return
-		default:
 		}
 
-		_, message, err := c.ws.ReadMessage()
+		frameType, frame, err := c.ws.ReadMessage()
 		if err != nil {
 			// Only log unexpected errors (not normal disconnects)
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("Read error: %v", err)
+				log.Printf("Read error from %s: %v", c.info, err)
 			}
 			return
 		}
 
+		// Connection-level flood protection: drop messages over the rate limit
+		// and disconnect clients that keep flooding
+		if !c.allowMessage(time.Now()) {
+			if c.floodDrops > config.FloodDropLimit {
+				c.server.metrics.floodDisconnects.Add(1)
+				log.Printf("Disconnecting %s: inbound message flood", c.info)
+				return
+			}
+			continue
+		}
+
+		// Translate the negotiated wire format to the binary protocol
+		message, err := c.codec.Decode(frameType, frame)
+		if err != nil {
+			c.Send(c.server.protocol.EncodeError(network.ErrorCodeInvalidMessage, err.Error()))
+			continue
+		}
 		c.handleMessage(message)
 	}
 }
 
-// handleMessage dispatches incoming messages to appropriate handlers based on message type.
+// allowMessage applies the inbound rate limit and tracks drops per flood window.
+func (c *ClientConnection) allowMessage(now time.Time) bool {
+	if c.limiter.Allow(now) {
+		c.server.metrics.messagesReceived.Add(1)
+		return true
+	}
+
+	c.server.metrics.messagesDropped.Add(1)
+	if now.Sub(c.floodWindowFrom) > config.FloodWindow {
+		c.floodWindowFrom = now
+		c.floodDrops = 0
+	}
+	c.floodDrops++
+	return false
+}
+
+// handleMessage dispatches incoming messages to their handlers (see handlers.go).
 // Message type is always the first byte of the binary message.
 func (c *ClientConnection) handleMessage(data []byte) {
 	if len(data) == 0 {
@@ -321,105 +1328,399 @@ func (c *ClientConnection) handleMessage(data []byte) {
 	// First byte is always the message type
 	msgType := data[0]
 
-	switch msgType {
-	case network.MsgTypeJoinRoom:
-		c.handleJoin(data)
+	// Reject oversize messages with an error instead of dropping the connection
+	if limit := network.MessageSizeLimit(c.ProtocolVersion(), msgType); len(data) > limit {
+		c.Send(c.server.protocol.EncodeMessageTooLarge(msgType, limit))
+		return
+	}
 
-	case network.MsgTypeInput:
-		c.handleInput(data)
+	if handler, ok := messageHandlers[msgType]; ok {
+		defer func() {
+			if r := recover(); r != nil {
+				panic(crash.With(r, "conn", strconv.FormatUint(c.info.ID, 10), "account", c.info.Account(),
+					"message", fmt.Sprintf("0x%02x", msgType)))
+			}
+		}()
+		handler(c, &message{data: data})
+	}
+}
 
-	case network.MsgTypePing:
-		c.handlePing(data)
+// handleHello negotiates the protocol version with the client.
+func (c *ClientConnection) handleHello(m *message) {
+	msg, err := c.server.protocol.DecodeHello(m.data)
+	if err != nil {
+		return
+	}
+	c.fingerprint.Hello(time.Now())
 
-	case network.MsgTypeLeaveRoom:
-		c.handleLeave()
+	version, ok := network.NegotiateVersion(msg.Version)
+	if !ok {
+		c.Send(c.server.protocol.EncodeError(network.ErrorCodeUnsupportedVersion,
+			fmt.Sprintf("protocol version %d not supported", msg.Version)))
+		return
 	}
+
+	c.info.SetVersion(version)
+	c.Send(c.server.protocol.EncodeHelloAck(version))
+	c.challenge()
 }
 
 // handleJoin processes a player's request to join a game room.
 // Validates the player name, finds/creates a room, and sends room info back.
-func (c *ClientConnection) handleJoin(data []byte) {
+func (c *ClientConnection) handleJoin(m *message) {
 	// Decode the join message
-	msg, err := c.server.protocol.DecodeJoin(data)
+	msg, err := c.server.protocol.DecodeJoin(m.data)
 	if err != nil {
-		log.Printf("Invalid join message from %s: %v", c.RemoteAddr(), err)
+		log.Printf("Invalid join message from %s: %v", c.info, err)
+		return
+	}
+	c.fingerprint.Join(time.Now())
+
+	// Broadcast clients watch the room of their spectate token instead
+	if c.spectate != "" {
+		c.watch()
+		return
+	}
+
+	name, ok := c.checkJoin(msg)
+	if !ok {
 		return
 	}
 
-	// Validate player name (basic sanitization)
-	name := strings.TrimSpace(msg.Name)
-	if name == "" {
-		name = "Player"
+	// Practice and tutorial rooms are created on demand and never queued
+	if msg.Options&(network.JoinSolo|network.JoinTutorial) != 0 {
+		var room *game.Room
+		switch {
+		case msg.Options&network.JoinTutorial != 0:
+			if c.ProtocolVersion() < network.ProtocolV6 {
+				c.Send(c.server.protocol.EncodeError(network.ErrorCodeUnsupportedVersion, game.ErrTutorialUnsupported.Error()))
+				return
+			}
+			room = c.tenant.matchmaker.CreateTutorialRoom()
+		case msg.Options&network.JoinTrack != 0:
+			if c.ProtocolVersion() < network.ProtocolV7 {
+				c.Send(c.server.protocol.EncodeError(network.ErrorCodeUnsupportedVersion, game.ErrTrackUnsupported.Error()))
+				return
+			}
+			// Any version of a custom track, approved or not
+			t, err := c.server.tracks.GetContext(c.ctx, msg.TrackID, int(msg.TrackVersion))
+			if errors.Is(err, track.ErrNotFound) {
+				c.Send(c.server.protocol.EncodeError(network.ErrorCodeInvalidMessage, err.Error()))
+				return
+			}
+			if c.ctx.Err() != nil {
+				return // Disconnected during the lookup
+			}
+			if err != nil {
+				log.Printf("Failed to load track %s for %s: %v", msg.TrackID, c.info, err)
+				c.Send(c.server.protocol.EncodeError(network.ErrorCodeServerError, "Failed to load track"))
+				return
+			}
+			room = c.tenant.matchmaker.CreatePracticeRoom(msg.Options&network.JoinReplay != 0, &t)
+		default:
+			room = c.tenant.matchmaker.CreatePracticeRoom(msg.Options&network.JoinReplay != 0, nil)
+		}
+		if room == nil {
+			c.Send(c.server.protocol.EncodeError(network.ErrorCodeRoomFull, "No practice room available"))
+			return
+		}
+		if !c.server.replaysAllowed(c) {
+			room.DisableReplays() // See privacy.go
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if err := c.joinUnlocked(room, name, msg.Color); err != nil {
+			c.Send(c.server.protocol.EncodeError(network.ErrorCodeRoomFull, err.Error()))
+		}
+		return
+	}
+
+	// A custom public track needs a client that can draw it
+	if !c.tenant.matchmaker.PublicTrack().IsDefault() && c.ProtocolVersion() < network.ProtocolV7 {
+		c.Send(c.server.protocol.EncodeError(network.ErrorCodeUnsupportedVersion, game.ErrTrackUnsupported.Error()))
+		return
+	}
+
+	// Players with a match ticket go to the room reserved for the match,
+	// once they proved the event secret of a tournament room
+	if c.reserved != "" {
+		if code, reason := c.eventRefusal(); reason != "" {
+			c.Send(c.server.protocol.EncodeError(code, reason))
+			return
+		}
+		room := c.tenant.matchmaker.GetRoom(c.reserved)
+		if room == nil {
+			c.Send(c.server.protocol.EncodeError(network.ErrorCodeRoomFull, "Match room closed"))
+			return
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if err := c.joinUnlocked(room, name, msg.Color); err != nil {
+			c.Send(c.server.protocol.EncodeError(network.ErrorCodeRoomFull, err.Error()))
+		}
+		return
 	}
-	// Limit name length to prevent abuse
-	if len(name) > 20 {
-		name = name[:20]
+
+	// Players with a session token go back to the room they left, ahead of
+	// the queue, if it kept their place
+	if c.rejoin(name, msg.Color) {
+		return
 	}
 
-	// Find an available room or create a new one
-	room := c.server.matchmaker.FindRoom()
+	// Find an available room or create a new one.
+	// While others are waiting, new joins line up behind them.
+	var room *game.Room
+	if c.tenant.queue.Len() == 0 {
+		room = c.tenant.matchmaker.FindRoom()
+	}
 	if room == nil {
-		// Server is at capacity
+		// Server is at capacity: wait in the queue if enabled
+		msg.Name = name
+		c.mu.Lock()
+		c.pending = msg
+		c.mu.Unlock()
+		if c.tenant.queue.Enabled() && c.tenant.queue.Enqueue(c) {
+			log.Printf("Player '%s' queued for a slot", name)
+			return
+		}
+		c.mu.Lock()
+		c.pending = nil
+		c.mu.Unlock()
+
 		errMsg := c.server.protocol.EncodeError(network.ErrorCodeRoomFull, "Server full")
 		c.Send(errMsg)
 		return
 	}
 
-	// Add player to the room
-	player, err := room.AddPlayer(c.RemoteAddr(), name, msg.Color, c)
-	if err != nil {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.joinUnlocked(room, name, msg.Color); err != nil {
 		errMsg := c.server.protocol.EncodeError(network.ErrorCodeRoomFull, err.Error())
 		c.Send(errMsg)
-		return
+	}
+}
+
+// checkJoin runs the checks of every join: it validates the player's name
+// and takes their looks, unless the address is in a cooldown, the player is
+// already queued or the rooms need a newer client. Returns the name, or
+// false if the join was refused.
+func (c *ClientConnection) checkJoin(msg *network.JoinMessage) (string, bool) {
+	// Validate player name: normalization, length limit and moderation policy
+	name, verdict := c.server.moderator.SanitizeName(c.ctx, msg.Name, 20, "Player")
+	if c.ctx.Err() != nil {
+		return "", false // Disconnected while the name was checked
+	}
+	if verdict.Reason != "" {
+		log.Printf("Name from %s moderated: %s", c.info, verdict.Reason)
+	}
+
+	// Recently kicked addresses must wait out their cooldown
+	if remaining, offenses := c.server.penalties.Remaining(c.info.IP); remaining > 0 {
+		if offenses > 255 {
+			offenses = 255
+		}
+		c.Send(c.server.protocol.EncodeCooldown(uint32(remaining.Milliseconds()), uint8(offenses)))
+		return "", false
+	}
+
+	c.mu.Lock()
+	queued := c.pending != nil
+	if !queued {
+		c.look = joinLook(c.ProtocolVersion(), msg)
+	}
+	c.mu.Unlock()
+	if queued {
+		return "", false // Already waiting for a slot
 	}
 
-	// Store references for this connection
+	// Rooms running at a non-standard physics rate or on fuel need a client
+	// that adapts
+	if !c.tenant.matchmaker.RoomConfig().SupportsClient(c.ProtocolVersion()) {
+		c.Send(c.server.protocol.EncodeError(network.ErrorCodeUnsupportedVersion, game.ErrClientUnsupported.Error()))
+		return "", false
+	}
+	return name, true
+}
+
+// joinUnlocked adds the player to a room and stores the session references.
+// Fails if the connection is in a room already.
+// IMPORTANT: Caller must hold c.mu.
+func (c *ClientConnection) joinUnlocked(room *game.Room, name string, color uint8) error {
+	if c.player != nil {
+		return errAlreadyJoined
+	}
+	room.RemoveSession(c.sessionKey) // A previous connection of the session makes way
+	player, err := room.AddPlayer(c.sessionKey, name, color, c)
+	if err != nil {
+		return err
+	}
+
+	// Store references for this connection; a viewer stops watching
 	c.player = player
 	c.room = room
+	if c.watched != nil {
+		c.watched.RemoveSpectator(c)
+		c.watched = nil
+	}
+	c.netsim.set(netConditions{}) // Simulated conditions stay in their room
+	c.info.SetAccount(name)
+	c.server.metrics.joins.Add(1)
+	player.SetSuspicion(c.fingerprint.Fingerprint().Suspicion) // Follows the connection (see telemetry.go)
+	c.server.calibrateOnJoin(room, player)
+	c.server.dress(c, room, player, c.look, c.linkedAccountUnlocked(name))
+	c.server.sendSessionToken(c, room)
 
 	log.Printf("Player '%s' (ID: %d) joined room %s", name, player.ID, room.ID)
+	return nil
 }
 
-// handleInput processes player control input (steering, throttle, keys).
-// Input is validated by the room's anti-cheat system before being applied.
-func (c *ClientConnection) handleInput(data []byte) {
-	// Ignore input from clients not in a room
-	if c.player == nil || c.room == nil {
-		return
+// session returns the connection's player and room (nil if not in a room).
+func (c *ClientConnection) session() (*game.Player, *game.Room) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.player, c.room
+}
+
+// Admit implements matchmaker.Waiter: joins the queued player into room.
+func (c *ClientConnection) Admit(room *game.Room) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed || c.pending == nil {
+		return true // Gone or left the queue; give the slot to the next in line
+	}
+	if err := c.joinUnlocked(room, c.pending.Name, c.pending.Color); err != nil {
+		return false
+	}
+	c.pending = nil
+	return true
+}
+
+// QueueUpdate implements matchmaker.Waiter: reports the queue position.
+func (c *ClientConnection) QueueUpdate(position, length int) {
+	c.Send(c.server.protocol.EncodeQueueStatus(clampUint16(position), clampUint16(length)))
+}
+
+// QueueTimeout implements matchmaker.Waiter: gives up on a queued join.
+func (c *ClientConnection) QueueTimeout() {
+	c.mu.Lock()
+	c.pending = nil
+	c.mu.Unlock()
+
+	errMsg := c.server.protocol.EncodeError(network.ErrorCodeRoomFull, "Server full: queue timeout")
+	c.Send(errMsg)
+}
+
+// clampUint16 converts a count for the wire, saturating at the maximum.
+func clampUint16(n int) uint16 {
+	if n > 0xFFFF {
+		return 0xFFFF
 	}
+	return uint16(n)
+}
 
+// handleInput processes player control input (steering, throttle, keys).
+// Input is validated by the room's anti-cheat system before being applied.
+func (c *ClientConnection) handleInput(m *message) {
 	// Decode input message
-	msg, err := c.server.protocol.DecodeInput(data)
+	msg, err := c.server.protocol.DecodeInput(m.data)
 	if err != nil {
 		return
 	}
 
+	// Once its input cadence is known, compare the client with kicked players
+	now := time.Now()
+	if c.fingerprint.Input(now) {
+		c.server.matchFingerprint(c, m.player.GetName())
+	}
+	c.sampleTelemetry(m.player, now)
+
 	// Forward to room for processing (includes anti-cheat validation)
-	c.room.HandleInput(c.player.ID, msg)
+	m.room.HandleInput(m.player.ID, msg)
 }
 
 // handlePing responds to client ping with a pong containing the same timestamp.
-// Used by clients to measure round-trip latency.
-func (c *ClientConnection) handlePing(data []byte) {
-	// Ping message format: [type:1][timestamp:8]
-	if len(data) >= 9 {
-		// Extract timestamp (little-endian uint64)
-		var timestamp uint64
-		for i := 0; i < 8; i++ {
-			timestamp |= uint64(data[1+i]) << (i * 8)
-		}
-		// Send pong with same timestamp
-		pong := c.server.protocol.EncodePong(timestamp)
-		c.Send(pong)
+// Used by clients to measure round-trip latency. Pings may also carry a client
+// performance report, which feeds the room's quality metrics.
+func (c *ClientConnection) handlePing(m *message) {
+	// Ping message format: [type:1][timestamp:8][fps:1][interp_delay_ms:2][jitter_ms:2]
+	msg, err := c.server.protocol.DecodePing(m.data)
+	if err != nil {
+		return
+	}
+
+	// Send pong with same timestamp
+	pong := c.server.protocol.EncodePong(msg.Timestamp)
+	c.Send(pong)
+
+	if player, _ := c.session(); msg.HasPerf && player != nil {
+		player.ReportPerf(game.ClientPerf{
+			FPS:           msg.FPS,
+			InterpDelayMS: msg.InterpDelayMS,
+			JitterMS:      msg.JitterMS,
+			ReportedAt:    time.Now(),
+		})
+	}
+}
+
+// handleHostKick lets the room host remove another player.
+func (c *ClientConnection) handleHostKick(m *message) {
+	msg, err := c.server.protocol.DecodeHostKick(m.data)
+	if err != nil {
+		return
+	}
+
+	if err := m.room.HostKick(m.player.ID, msg.TargetID); err != nil {
+		c.Send(c.server.protocol.EncodeError(network.ErrorCodeNotAllowed, err.Error()))
+	}
+}
+
+// handleHostStartRace lets the room host start the next race now.
+func (c *ClientConnection) handleHostStartRace(m *message) {
+	if err := m.room.HostStartRace(m.player.ID); err != nil {
+		c.Send(c.server.protocol.EncodeError(network.ErrorCodeNotAllowed, err.Error()))
+	}
+}
+
+// handleReset puts the player back on the start line of their practice room.
+func (c *ClientConnection) handleReset(m *message) {
+	if err := m.room.ResetPlayer(m.player.ID); err != nil {
+		c.Send(c.server.protocol.EncodeError(network.ErrorCodeNotAllowed, err.Error()))
+	}
+}
+
+// handlePrestige resets the player's run for prestige (endless mode).
+func (c *ClientConnection) handlePrestige(m *message) {
+	if err := m.room.Prestige(m.player.ID); err != nil {
+		c.Send(c.server.protocol.EncodeError(network.ErrorCodeNotAllowed, err.Error()))
 	}
 }
 
 // handleLeave processes a player's request to leave the current room.
-func (c *ClientConnection) handleLeave() {
-	if c.room != nil && c.player != nil {
-		c.room.RemovePlayer(c.player.ID)
-		c.player = nil
-		c.room = nil
+func (c *ClientConnection) handleLeave(m *message) {
+	c.leave()
+}
+
+// leave removes the player from their room or the join queue, or stops
+// spectating.
+func (c *ClientConnection) leave() {
+	c.mu.Lock()
+	player, room, queued, watched := c.player, c.room, c.pending != nil, c.watched
+	c.player, c.room, c.pending, c.watched = nil, nil, nil, nil
+	c.mu.Unlock()
+	c.netsim.set(netConditions{})
+
+	if queued {
+		c.tenant.queue.Remove(c)
+	}
+	if watched != nil {
+		watched.RemoveSpectator(c)
+	}
+	if room != nil && player != nil {
+		room.RemovePlayer(player.ID)
+		c.server.wakeQueue()
 	}
 }
 
@@ -427,13 +1728,19 @@ func (c *ClientConnection) handleLeave() {
 // Called when connection is closed (either gracefully or due to error).
 func (c *ClientConnection) cleanup() {
 	// Remove from server's connection map
+	c.server.connMu.Lock()
 	delete(c.server.connections, c)
+	c.server.connMu.Unlock()
 
-	// Remove player from room if they were in one
-	if c.room != nil && c.player != nil {
-		c.room.RemovePlayer(c.player.ID)
-	}
+	// Remove player from their room or the join queue
+	c.mu.Lock()
+	c.closed = true
+	account := c.account
+	c.mu.Unlock()
+	c.leave()
+	c.server.sessions.release(account, c)
 
 	c.Close()
-	log.Printf("Connection closed: %s", c.RemoteAddr())
+	log.Printf("Connection closed: %s", c.info)
+	c.server.scheduleHibernation()
 }
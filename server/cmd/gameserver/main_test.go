@@ -0,0 +1,18 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"os"
+	"testing"
+)
+
+// TestMain keeps the server's logging out of the results unless -v
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+	}
+	os.Exit(m.Run())
+}
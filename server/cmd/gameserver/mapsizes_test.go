@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/race/server/internal/network"
+	"github.com/race/server/internal/routines/routinestest"
+)
+
+// TestConnectionCycles has a thousand clients connect, join, leave and
+// disconnect, and checks the long-lived maps /stats reports the sizes of
+// are back to their size before them
+func TestConnectionCycles(t *testing.T) {
+	const cycles = 1000
+
+	addr, _ := startServer(t)
+	baseline, err := fetchStats(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < cycles; i++ {
+		c, err := dial(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.send([]byte{network.MsgTypeHello, network.ProtocolVersionMax})
+		if _, err := c.expect(network.MsgTypeHelloAck, nil); err != nil {
+			t.Fatalf("cycle %d: %v", i, err)
+		}
+		c.send(encodeJoin(fmt.Sprintf("Racer %d", i), 3))
+		if _, err := c.expect(network.MsgTypeRoomInfo, nil); err != nil {
+			t.Fatalf("cycle %d: %v", i, err)
+		}
+		c.send([]byte{network.MsgTypeLeaveRoom})
+		c.close()
+	}
+
+	// Connections are torn down by their pumps
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s, err := fetchStats(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if maps.Equal(s.Sizes, baseline.Sizes) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("sizes after %d cycles: %v, before: %v", cycles, s.Sizes, baseline.Sizes)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	routinestest.CheckZero(t, "conn.read", "conn.write")
+}
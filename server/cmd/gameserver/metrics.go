@@ -0,0 +1,73 @@
+package main
+
+import (
+	"github.com/race/server/internal/matchmaker"
+)
+
+// Metrics
+//
+// The server's flat counters and gauges are reported through a metricsSink,
+// so every way out carries the same ones: /stats puts them among its JSON
+// fields, and the statsd emitter (see statsd.go) pushes them to an agent.
+// A metric added here shows in both. Counters are totals since startup; a
+// sink that wants increments takes the differences itself.
+
+// metricsSink receives the server's metrics
+type metricsSink interface {
+	Counter(name string, total uint64)
+	Gauge(name string, value float64)
+}
+
+// reportMetrics reports the server's metrics to a sink, the rooms' taken
+// from stats
+func (s *GameServer) reportMetrics(sink metricsSink, stats matchmaker.MatchmakerStats) {
+	sink.Gauge("rooms", float64(stats.TotalRooms))
+	sink.Gauge("players", float64(stats.TotalPlayers))
+	sink.Gauge("spectators", float64(stats.Spectators))
+	sink.Gauge("viewers", float64(stats.Viewers))
+	sink.Gauge("suspendedRooms", float64(stats.SuspendedRooms))
+	sink.Gauge("practiceRooms", float64(stats.PracticeRooms))
+	sink.Gauge("honeypotRooms", float64(stats.HoneypotRooms))
+	sink.Gauge("quarantinedRooms", float64(stats.QuarantinedRooms))
+	sink.Gauge("privateRooms", float64(stats.PrivateRooms))
+	sink.Gauge("agentSessions", float64(s.agents.count()))
+	sink.Gauge("accountSessions", float64(s.sessions.count()))
+	sink.Gauge("outboxPending", float64(s.outbox.Len()))
+	sink.Counter("tickOverruns", stats.TickOverruns)
+
+	m := &s.metrics
+	sink.Counter("messagesReceived", m.messagesReceived.Load())
+	sink.Counter("messagesDropped", m.messagesDropped.Load())
+	sink.Counter("floodDisconnects", m.floodDisconnects.Load())
+	sink.Counter("messagesThrottled", m.messagesThrottled.Load())
+	sink.Counter("signalsRelayed", m.signalsRelayed.Load())
+	sink.Counter("signalsMuted", m.signalsMuted.Load())
+	sink.Counter("cosmeticsRefused", m.cosmeticsRefused.Load())
+	sink.Counter("doubleJoins", m.doubleJoins.Load())
+	sink.Counter("sessionsReplaced", m.sessionsReplaced.Load())
+	sink.Counter("sessionsRefused", m.sessionsRefused.Load())
+	sink.Counter("encryptedConns", m.encryptedConns.Load())
+	sink.Counter("profileUpdates", m.profileUpdates.Load())
+	sink.Counter("messagesShed", m.messagesShed.Load())
+	sink.Counter("slowClients", m.slowClients.Load())
+	sink.Counter("probesSent", m.probesSent.Load())
+	sink.Counter("probesMissed", m.probesMissed.Load())
+	sink.Counter("probesImplausible", m.probesImplausible.Load())
+	sink.Counter("clipsSaved", m.clipsSaved.Load())
+	sink.Counter("joins", m.joins.Load())
+	sink.Counter("matches", m.matches.Load())
+	sink.Counter("rejoins", m.rejoins.Load())
+	sink.Counter("kicks", m.kicks.Load())
+	sink.Counter("honeypotted", m.honeypotted.Load())
+	sink.Counter("quarantines", m.quarantines.Load())
+
+	_, hibernations := s.hibernations()
+	sink.Counter("hibernations", hibernations)
+}
+
+// statsFields is the sink of /stats: fields of its JSON object
+type statsFields map[string]interface{}
+
+func (f statsFields) Counter(name string, total uint64) { f[name] = total }
+
+func (f statsFields) Gauge(name string, value float64) { f[name] = value }
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/game"
+	"github.com/race/server/internal/network"
+)
+
+// Network condition simulation (QA rooms)
+//
+// In a QA room (RoomConfig.QA) a tester can have the server degrade their
+// own connection with a NetSim message (ProtocolV25), to compare fairness
+// mechanisms (interpolation delay, dead reckoning, lag compensation) under
+// poor conditions without network shaping tools. Everything the server
+// sends them is held back by the latency plus up to the jitter, pings
+// included so the RTT the server measures shows it, and the loss drops that
+// share of state updates, the only messages the game copes with losing.
+// Frames stay in order, like on the WebSocket's TCP stream. What the client
+// sends is not delayed. The conditions last until changed (all zero clears
+// them) or the player leaves the room or joins another.
+
+// netConditions are the network conditions simulated on a connection
+type netConditions struct {
+	latency time.Duration
+	jitter  time.Duration
+	loss    float64 // Chance of dropping a state update
+}
+
+// netShaper holds back and drops the frames written to a connection to
+// simulate its network conditions. Conditions are set by readPump; the rest
+// is only touched by writePump.
+type netShaper struct {
+	mu   sync.Mutex
+	cond netConditions
+
+	held  []heldFrame // In order of release
+	last  time.Time   // Release time of the last frame held
+	timer *time.Timer // Fires when the first held frame is due
+}
+
+// heldFrame is a frame held back until at
+type heldFrame struct {
+	at        time.Time
+	frameType int
+	data      []byte
+}
+
+// set changes the simulated conditions. Frames already held keep their time.
+func (s *netShaper) set(cond netConditions) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cond = cond
+}
+
+// conditions returns the simulated conditions
+func (s *netShaper) conditions() netConditions {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cond
+}
+
+// hold decides the fate of a frame about to be written at now: false to
+// write it right away, true if it was dropped or is held back (see due).
+// lossy frames may be dropped.
+func (s *netShaper) hold(frameType int, data []byte, lossy bool, now time.Time) bool {
+	cond := s.conditions()
+	if cond == (netConditions{}) && len(s.held) == 0 {
+		return false
+	}
+	if lossy && cond.loss > 0 && rand.Float64() < cond.loss {
+		return true
+	}
+
+	at := now.Add(cond.latency)
+	if cond.jitter > 0 {
+		at = at.Add(time.Duration(rand.Int63n(int64(cond.jitter) + 1)))
+	}
+	if at.Before(s.last) {
+		at = s.last // Never overtakes an earlier frame
+	}
+	if !at.After(now) && len(s.held) == 0 {
+		return false
+	}
+	s.last = at
+	s.held = append(s.held, heldFrame{at: at, frameType: frameType, data: data})
+	if len(s.held) == 1 {
+		s.arm(now)
+	}
+	return true
+}
+
+// due returns a channel that fires when the first held frame is due; nil,
+// which never fires, while none are held
+func (s *netShaper) due() <-chan time.Time {
+	if len(s.held) == 0 {
+		return nil
+	}
+	return s.timer.C
+}
+
+// release returns the held frames due at now, in order
+func (s *netShaper) release(now time.Time) []heldFrame {
+	n := 0
+	for n < len(s.held) && !s.held[n].at.After(now) {
+		n++
+	}
+	due := append([]heldFrame(nil), s.held[:n]...)
+	s.held = append(s.held[:0], s.held[n:]...)
+	if len(s.held) > 0 {
+		s.arm(now)
+	}
+	return due
+}
+
+// arm sets the timer for the first held frame
+func (s *netShaper) arm(now time.Time) {
+	wait := s.held[0].at.Sub(now)
+	if s.timer == nil {
+		s.timer = time.NewTimer(wait)
+		return
+	}
+	s.timer.Reset(wait)
+}
+
+// stop releases the timer. Called when writePump exits.
+func (s *netShaper) stop() {
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+}
+
+// handleNetSim sets the network conditions simulated on the connection,
+// capped at the config.NetSimMax* limits (QA rooms only).
+func (c *ClientConnection) handleNetSim(m *message) {
+	msg, err := c.server.protocol.DecodeNetSim(m.data)
+	if err != nil {
+		return
+	}
+	if !m.room.QA() {
+		c.Send(c.server.protocol.EncodeError(network.ErrorCodeNotAllowed, game.ErrNotQA.Error()))
+		return
+	}
+
+	cond := netConditions{
+		latency: min(time.Duration(msg.LatencyMS)*time.Millisecond, config.NetSimMaxLatency),
+		jitter:  min(time.Duration(msg.JitterMS)*time.Millisecond, config.NetSimMaxJitter),
+		loss:    float64(min(msg.Loss, config.NetSimMaxLoss)) / 100,
+	}
+	c.netsim.set(cond)
+	log.Printf("%s in QA room %s simulates %v latency, %v jitter, %.0f%% loss",
+		c.info, m.room.ID, cond.latency, cond.jitter, cond.loss*100)
+}
+
+// writeFrame writes a frame to the WebSocket
+func (c *ClientConnection) writeFrame(frameType int, data []byte) error {
+	// Set write deadline to prevent hanging on slow/dead connections
+	c.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return c.ws.WriteMessage(frameType, data)
+}
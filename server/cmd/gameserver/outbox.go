@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/history"
+	"github.com/race/server/internal/leaderboard"
+	"github.com/race/server/internal/outbox"
+)
+
+// Store outbox
+//
+// Match records, moderation records (kicks), prestige, leaderboard seasons
+// and their rewards are written to the shared store through the outbox (see
+// internal/outbox): while the store is unavailable they are kept in
+// outbox.jsonl in the data directory and replayed every
+// config.OutboxRetryInterval, in the order they were made, once it is back.
+// Live leaderboard snapshots supersede each other, so only the latest of a
+// tenant waits. The number of pending writes is in /stats (outboxPending).
+
+// Kinds of outbox writes
+const (
+	outboxMatch    = "match"
+	outboxKick     = "kick"
+	outboxPrestige = "prestige"
+	outboxSeason   = "leaderboard.season"
+	outboxLive     = "leaderboard.live"
+	outboxRewards  = "leaderboard.rewards"
+)
+
+// kickWrite is the data of an outbox kick
+type kickWrite struct {
+	Name     string        `json:"name"`
+	Reason   string        `json:"reason"`
+	Cooldown time.Duration `json:"cooldown"`
+	At       time.Time     `json:"at"`
+}
+
+// prestigeWrite is the data of an outbox prestige
+type prestigeWrite struct {
+	Name   string    `json:"name"`
+	Resets int       `json:"resets,omitempty"` // Prestige resets banked at once (0: one)
+	Score  float64   `json:"score"`            // Total of their runs
+	At     time.Time `json:"at"`               // Of the last
+}
+
+// seasonWrite is the data of an outbox leaderboard write
+type seasonWrite struct {
+	Tenant string                     `json:"tenant"`
+	Season leaderboard.ArchivedSeason `json:"season"`
+}
+
+// rewardsWrite is the data of an outbox write of a season's rewards
+type rewardsWrite struct {
+	Tenant  string               `json:"tenant"`
+	Season  int64                `json:"season"`
+	Rewards []leaderboard.Reward `json:"rewards"`
+}
+
+// openOutbox opens the outbox of the data directory and sets its handlers
+func (s *GameServer) openOutbox() (*outbox.Outbox, error) {
+	box, err := outbox.Open(filepath.Join(s.config.DataDir, "outbox.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	box.Handle(outboxMatch, func(data []byte) error {
+		var m history.Match
+		if err := json.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		return s.history.Save(m)
+	})
+	box.Handle(outboxKick, func(data []byte) error {
+		var k kickWrite
+		if err := json.Unmarshal(data, &k); err != nil {
+			return err
+		}
+		return s.history.RecordKick(k.Name, k.Reason, k.Cooldown, k.At)
+	})
+	box.Handle(outboxPrestige, func(data []byte) error {
+		var p prestigeWrite
+		if err := json.Unmarshal(data, &p); err != nil {
+			return err
+		}
+		return s.history.RecordPrestige(p.Name, max(p.Resets, 1), p.Score, p.At)
+	})
+	box.Handle(outboxSeason, func(data []byte) error {
+		return s.replaySeason(data, leaderboard.Archive.SaveSeason)
+	})
+	box.Handle(outboxLive, func(data []byte) error {
+		return s.replaySeason(data, leaderboard.Archive.SaveLive)
+	})
+	box.Handle(outboxRewards, func(data []byte) error {
+		var w rewardsWrite
+		if err := json.Unmarshal(data, &w); err != nil {
+			return err
+		}
+		// Players rewarded before a failure keep theirs once
+		for _, r := range w.Rewards {
+			reward := history.SeasonReward{Tenant: w.Tenant, Season: w.Season, Rank: r.Rank, Award: r.Award}
+			if err := s.history.RecordSeasonReward(r.Name, reward); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if n := box.Len(); n > 0 {
+		log.Printf("Outbox: %d writes pending from a previous run", n)
+	}
+	return box, nil
+}
+
+// replaySeason performs an outbox leaderboard write on the archive of its
+// tenant
+func (s *GameServer) replaySeason(data []byte, save func(leaderboard.Archive, leaderboard.ArchivedSeason) error) error {
+	var w seasonWrite
+	if err := json.Unmarshal(data, &w); err != nil {
+		return err
+	}
+	archive, ok := s.archives[w.Tenant]
+	if !ok {
+		return fmt.Errorf("no leaderboard archive for tenant %q", w.Tenant)
+	}
+	return save(archive, w.Season)
+}
+
+// replayOutbox retries the pending writes of the outbox. Runs in the
+// background until shutdown.
+func (s *GameServer) replayOutbox() {
+	ticker := time.NewTicker(config.OutboxRetryInterval)
+	defer ticker.Stop()
+	for s.wait(ticker, config.OutboxRetryInterval) {
+		if s.outbox.Len() == 0 {
+			continue
+		}
+		if n := s.outbox.Replay(); n > 0 {
+			log.Printf("Outbox: replayed %d writes, %d pending", n, s.outbox.Len())
+		}
+	}
+}
+
+// outboxArchive saves the seasons of a tenant's leaderboard through the
+// outbox. Reads go to the archive directly.
+type outboxArchive struct {
+	leaderboard.Archive
+	box    *outbox.Outbox
+	tenant string
+}
+
+// SaveSeason implements leaderboard.Archive
+func (a *outboxArchive) SaveSeason(season leaderboard.ArchivedSeason) error {
+	key := fmt.Sprintf("season:%s:%d", a.tenant, season.Season.ID)
+	return a.box.Do(outboxSeason, key, seasonWrite{Tenant: a.tenant, Season: season})
+}
+
+// SaveLive implements leaderboard.Archive
+func (a *outboxArchive) SaveLive(season leaderboard.ArchivedSeason) error {
+	return a.box.Do(outboxLive, "live:"+a.tenant, seasonWrite{Tenant: a.tenant, Season: season})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/network"
+)
+
+// Outbound queues
+//
+// A connection's messages wait in network.OutQueue, one queue per priority
+// class, so a client that falls behind keeps getting errors and room events
+// while its state updates pile up. MESSAGE_PRIORITIES moves message types
+// between classes ("0x26=high,0x27=normal"). A slow client loses its oldest
+// state and cosmetic messages first, counted in /stats (messagesShed); if
+// its critical or high queue fills up too, it is disconnected (slowClients)
+// rather than left to play on without events it relies on.
+// outqueue_test.go checks all of this against a client on a saturated link
+// and one that stops reading.
+
+// outQueueCapacity is the capacity of each class's queue
+var outQueueCapacity = [network.PriorityCount]int{
+	network.PriorityCritical: config.OutboundCriticalQueue,
+	network.PriorityHigh:     config.OutboundHighQueue,
+	network.PriorityNormal:   config.OutboundNormalQueue,
+	network.PriorityLow:      config.OutboundLowQueue,
+}
+
+// newOutQueue returns an empty outbound queue for a connection
+func newOutQueue(priorities *network.Priorities) *network.OutQueue {
+	return network.NewOutQueue(priorities, outQueueCapacity, config.OutboundStarvationLimit)
+}
+
+// newPriorities returns the classes of outbound messages of the config
+func newPriorities(cfg *config.ServerConfig) *network.Priorities {
+	priorities, err := network.ParsePriorities(cfg.MessagePriorities)
+	if err != nil {
+		log.Fatalf("Invalid MESSAGE_PRIORITIES: %v", err)
+	}
+	return priorities
+}
+
+// closeSlow disconnects a client whose outbound queue can't take a message
+// it relies on.
+func (c *ClientConnection) closeSlow(err error) {
+	if c.ctx.Err() != nil {
+		return
+	}
+	c.server.metrics.slowClients.Add(1)
+	log.Printf("Disconnecting %s, too slow to keep up: %v", c.info, err)
+	c.Close()
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/game"
+	"github.com/race/server/internal/network"
+)
+
+// saturatedConn is the connection of a player who can't keep up. It queues
+// what the room and the test send it, remembering the test's own events.
+type saturatedConn struct {
+	protocol   *network.Protocol
+	priorities *network.Priorities
+	queue      *network.OutQueue
+	now        time.Duration // Simulated time
+	events     map[*byte]outEvent
+	shed       [network.PriorityCount]int // Messages dropped by the queue
+	err        error                      // Of the first push that failed: the server disconnects
+}
+
+// outEvent is one of the test's own messages
+type outEvent struct {
+	class network.Priority
+	seq   int
+	at    time.Duration // Queued
+}
+
+func newSaturatedConn() *saturatedConn {
+	priorities := network.DefaultPriorities()
+	return &saturatedConn{
+		protocol:   network.NewProtocol(),
+		priorities: priorities,
+		queue:      newOutQueue(priorities),
+		events:     make(map[*byte]outEvent),
+	}
+}
+
+func (c *saturatedConn) Send(data []byte) error {
+	if c.err != nil {
+		return c.err
+	}
+	dropped, err := c.queue.Push(data)
+	if dropped {
+		c.shed[c.priorities[data[0]]]++
+	}
+	c.err = err
+	return err
+}
+
+func (c *saturatedConn) Close() error           { return nil }
+func (c *saturatedConn) RemoteAddr() string     { return "saturated" }
+func (c *saturatedConn) RTT() time.Duration     { return 0 }
+func (c *saturatedConn) RTTVar() time.Duration  { return 0 }
+func (c *saturatedConn) ProtocolVersion() uint8 { return network.ProtocolVersionMax }
+
+// send queues one of the test's events
+func (c *saturatedConn) send(class network.Priority, seq int) error {
+	var data []byte
+	switch class {
+	case network.PriorityCritical:
+		data = c.protocol.EncodeCooldown(uint32(seq), 0)
+	case network.PriorityHigh:
+		data = c.protocol.EncodePlayerJoin(uint16(seq), "saturated", 0)
+	default:
+		data = c.protocol.EncodeMilestone(uint16(seq), 0, 0, 0)
+	}
+	c.events[&data[0]] = outEvent{class: class, seq: seq, at: c.now}
+	return c.Send(data)
+}
+
+// saturatedRoom returns a room of bots that c plays in
+func saturatedRoom(t *testing.T, bots int, c *saturatedConn) *game.Room {
+	t.Helper()
+	room := game.NewRoom("saturated")
+	profiles := make([]game.BotProfile, 0, len(game.DefaultBotProfiles))
+	for _, p := range game.DefaultBotProfiles {
+		profiles = append(profiles, p)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	for i := 0; i < bots; i++ {
+		if _, err := room.AddBot(profiles[i%len(profiles)]); err != nil {
+			t.Fatalf("add bot: %v", err)
+		}
+	}
+	if _, err := room.AddPlayer("saturated", "Saturated", 0, c); err != nil {
+		t.Fatalf("add player: %v", err)
+	}
+	return room
+}
+
+// outTally is what arrived of a class's events
+type outTally struct {
+	sent, received int
+	last           int // Sequence number of the last event received
+	outOfOrder     int
+	maxDelay       time.Duration // Longest wait of an event
+	maxGap         time.Duration // Longest time without a message of the class
+	lastAt         time.Duration
+}
+
+// eventClasses are the classes of the test's events
+var eventClasses = []network.Priority{network.PriorityCritical, network.PriorityHigh, network.PriorityLow}
+
+// TestSaturatedClient has a room of bots broadcast its state to a player
+// whose link carries less than the room sends, while events of every other
+// class are sent to it at a steady rate, for a minute of simulated play.
+// State updates and cosmetic events must be shed, and nothing else; every
+// critical and high event must arrive, in order, within the deadline, and
+// cosmetic events never stop arriving for longer.
+func TestSaturatedClient(t *testing.T) {
+	const (
+		bots     = 40
+		seconds  = 60
+		link     = 6 * 1024 // Bytes per second
+		rate     = 5        // Events per second of each class besides state
+		deadline = 3 * time.Second
+	)
+
+	c := newSaturatedConn()
+	room := saturatedRoom(t, bots, c)
+	tick := time.Second / time.Duration(config.PhysicsTickRate)
+	broadcastEvery := config.PhysicsTickRate / config.NetworkBroadcastRate
+	eventEvery := config.PhysicsTickRate / rate
+	budget := link / config.PhysicsTickRate // Bytes the link carries a tick
+
+	var tallies [network.PriorityCount]outTally
+	credit := 0
+	for i := 0; i < seconds*config.PhysicsTickRate; i++ {
+		c.now = time.Duration(i) * tick
+		room.Step(tick.Seconds(), i%broadcastEvery == 0)
+		if i%eventEvery == 0 {
+			for _, class := range eventClasses {
+				tallies[class].sent++
+				c.send(class, tallies[class].sent)
+			}
+		}
+		if c.err != nil {
+			t.Fatalf("%v: disconnected while the link still carried %d B/s: %v", c.now, link, c.err)
+		}
+
+		// The link takes what the budget allows
+		for credit += budget; credit > 0; {
+			data, ok := c.queue.Pop()
+			if !ok {
+				credit = 0
+				break
+			}
+			credit -= len(data)
+			class := &tallies[c.priorities[data[0]]]
+			class.maxGap = max(class.maxGap, c.now-class.lastAt)
+			class.lastAt = c.now
+			e, ours := c.events[&data[0]]
+			if !ours {
+				continue
+			}
+			delete(c.events, &data[0])
+			tally := &tallies[e.class]
+			tally.received++
+			if e.seq <= tally.last {
+				tally.outOfOrder++
+			}
+			tally.last = e.seq
+			tally.maxDelay = max(tally.maxDelay, c.now-e.at)
+		}
+	}
+
+	if c.shed[network.PriorityNormal] == 0 {
+		t.Fatal("no state update was shed: the link wasn't saturated")
+	}
+	for _, class := range eventClasses {
+		tally := tallies[class]
+		if tally.outOfOrder > 0 {
+			t.Errorf("%s: %d events out of order", class, tally.outOfOrder)
+		}
+		if class.Lossy() {
+			// Cosmetic events may be shed, but the class must keep moving
+			if tally.maxGap > deadline {
+				t.Errorf("%s: no message for %v", class, tally.maxGap)
+			}
+			continue
+		}
+		if c.shed[class] > 0 {
+			t.Errorf("%s: %d messages shed", class, c.shed[class])
+		}
+		// Events of the last tick may still be queued, nothing else
+		if tally.sent-tally.received > 1 {
+			t.Errorf("%s: %d of %d events arrived", class, tally.received, tally.sent)
+		}
+		if tally.maxDelay > deadline {
+			t.Errorf("%s: an event waited %v", class, tally.maxDelay)
+		}
+	}
+}
+
+// TestStalledClient has a player stop reading altogether while the room
+// broadcasts and events of every class are sent to it, critical ones too
+// few to fill their queue. The state and cosmetic queues must drop their
+// oldest messages and keep the newest, while every critical and high event
+// stays queued; the player must be disconnected once the high queue is
+// full, and not before. Once it reads again, every control message it was
+// sent comes out, in order.
+func TestStalledClient(t *testing.T) {
+	c := newSaturatedConn()
+	room := saturatedRoom(t, 10, c)
+
+	var sent [network.PriorityCount]int
+	for i := 0; c.err == nil; i++ {
+		if i%3 == 0 {
+			room.Step(1.0/float64(config.PhysicsTickRate), true)
+		}
+		for _, class := range eventClasses {
+			if c.err == nil && (class != network.PriorityCritical || i%8 == 0) {
+				sent[class]++
+				c.send(class, sent[class])
+			}
+		}
+	}
+
+	if !errors.Is(c.err, network.ErrOutQueueFull) {
+		t.Fatalf("disconnected with %v, want %v", c.err, network.ErrOutQueueFull)
+	}
+	if n := c.queue.Len(network.PriorityHigh); n != config.OutboundHighQueue {
+		t.Errorf("disconnected with %d high messages queued, want %d", n, config.OutboundHighQueue)
+	}
+	for class, capacity := range outQueueCapacity {
+		if class := network.Priority(class); class.Lossy() {
+			if c.shed[class] == 0 || c.queue.Len(class) != capacity {
+				t.Errorf("%s: %d shed, %d queued, want the queue's %d", class, c.shed[class], c.queue.Len(class), capacity)
+			}
+		} else if c.shed[class] > 0 {
+			t.Errorf("%s: %d shed", class, c.shed[class])
+		}
+	}
+
+	// The client reads again: every control message comes out, in order,
+	// and of the low ones only the newest
+	var received [network.PriorityCount][]int
+	for {
+		data, ok := c.queue.Pop()
+		if !ok {
+			break
+		}
+		if e, ours := c.events[&data[0]]; ours {
+			received[e.class] = append(received[e.class], e.seq)
+		}
+	}
+	for _, class := range eventClasses {
+		got := received[class]
+		want := sent[class]
+		if class == network.PriorityHigh {
+			want-- // The one that didn't fit
+		}
+		first := 1
+		if class.Lossy() {
+			first = want - outQueueCapacity[class] + 1
+		}
+		if len(got) != want-first+1 {
+			t.Errorf("%s: %d events received, want %d", class, len(got), want-first+1)
+			continue
+		}
+		for i, seq := range got {
+			if seq != first+i {
+				t.Errorf("%s: event %d received as the %dth, want %d through %d in order", class, seq, i+1, first, want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/race/server/internal/game"
+	"github.com/race/server/internal/history"
+	"github.com/race/server/internal/routines"
+)
+
+// Placement review
+//
+//   GET    /admin/placement        - players flagged in their placement
+//                                    rounds, awaiting review (newest first)
+//   DELETE /admin/placement/{name} - clear a player's flag after review
+//
+// The match history flags new players whose first rounds are far above
+// what a new driver does (see history/placement.go). The server then
+// calibrates the player's skill in the room the round ended in, and again
+// in every room the player joins later, so a smurf's rating stops
+// inflating the leaderboard scores of everyone racing them. Clearing a
+// flag only takes the player off the review list: the calibration stays,
+// as the skill estimate is right either way.
+
+// onPlacementFlag calibrates the skill of a player flagged by a round, if
+// they are still in the room
+func (s *GameServer) onPlacementFlag(m history.Match, player game.RoundPlayer) {
+	log.Printf("Player %s flagged in placement (round %d of room %s)", player.Name, m.Round, m.Room)
+	if _, room := s.findRoom(m.Room); room != nil {
+		room.CalibrateSkill(player.PlayerID) // Gone: calibrated when they join again
+	}
+}
+
+// calibrateOnJoin calibrates the skill of a joining player who was flagged
+// in placement. The profile is read in the background so the join never
+// waits on the store.
+func (s *GameServer) calibrateOnJoin(room *game.Room, player *game.Player) {
+	name := player.GetName()
+	routines.Go("server.history", func() {
+		profile, found, err := s.history.Profile(name)
+		if err != nil {
+			log.Printf("Failed to load profile of %s: %v", name, err)
+			return
+		}
+		if found && profile.Moderation != nil && profile.Moderation.Placement != nil && !profile.Moderation.Placement.Flagged.IsZero() {
+			room.CalibrateSkill(player.ID)
+		}
+	})
+}
+
+// handleAdminPlacement routes /admin/placement[/{name}].
+func (s *GameServer) handleAdminPlacement(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/admin/placement"), "/")
+	switch {
+	case name == "" && r.Method == http.MethodGet:
+		flagged, err := s.history.PendingReview()
+		if err != nil {
+			log.Printf("Failed to list placement flags: %v", err)
+			http.Error(w, "failed to list placement flags", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, flagged)
+	case name != "" && r.Method == http.MethodDelete:
+		if len(name) > 255 || strings.Contains(name, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		cleared, err := s.history.Review(name)
+		if err != nil {
+			log.Printf("Failed to clear placement flag of %s: %v", name, err)
+			http.Error(w, "failed to clear placement flag", http.StatusInternalServerError)
+			return
+		}
+		if !cleared {
+			http.Error(w, "no pending placement flag", http.StatusNotFound)
+			return
+		}
+		s.profiles.drop(name)
+		log.Printf("Placement flag of %s cleared", name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/history"
+)
+
+// Player profiles
+//
+//   GET /api/players/{name} - lifetime totals, achievements, current season
+//                             rank and recent rounds of a player
+//
+// Players are identified by name. The moderation record (kicks and rejoin
+// cooldown) is only included for requests with the admin token. Profiles
+// are assembled from the store and the leaderboard, and cached for
+// config.ProfileCacheTTL so popular profiles don't hit the store on every
+// request.
+
+// playerProfile is the response of /api/players/{name}
+type playerProfile struct {
+	history.Profile
+	Achievements  []string        `json:"achievements"`
+	Rank          int             `json:"rank,omitempty"`       // Current season (0: no run yet)
+	SeasonBest    float64         `json:"seasonBest,omitempty"` // Best score of the current season
+	RecentMatches []history.Match `json:"recentMatches"`
+}
+
+// profileCache keeps assembled profiles for a short time
+type profileCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedProfile
+}
+
+type cachedProfile struct {
+	profile playerProfile
+	found   bool
+	expires time.Time
+}
+
+func newProfileCache() *profileCache {
+	return &profileCache{entries: make(map[string]cachedProfile)}
+}
+
+// get returns a cached profile that has not expired
+func (c *profileCache) get(name string, now time.Time) (cachedProfile, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.entries[name]
+	if !ok || now.After(cached.expires) {
+		return cachedProfile{}, false
+	}
+	return cached, true
+}
+
+// put caches a profile, making room by dropping expired entries (or all of
+// them if none expired)
+func (c *profileCache) put(name string, cached cachedProfile, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= config.ProfileCacheMax {
+		for key, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, key)
+			}
+		}
+		if len(c.entries) >= config.ProfileCacheMax {
+			c.entries = make(map[string]cachedProfile)
+		}
+	}
+	c.entries[name] = cached
+}
+
+// drop removes a cached profile
+func (c *profileCache) drop(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, name)
+}
+
+// purge removes every cached profile
+func (c *profileCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]cachedProfile)
+}
+
+// len returns the number of cached profiles
+func (c *profileCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.entries)
+}
+
+// handlePlayer returns a player's profile.
+func (s *GameServer) handlePlayer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/api/players/")
+	if name == "" || len(name) > 255 || strings.Contains(name, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	now := time.Now()
+	cached, ok := s.profiles.get(name, now)
+	if !ok {
+		var err error
+		cached, err = s.loadProfile(name)
+		if err != nil {
+			log.Printf("Failed to load profile of %s: %v", name, err)
+			http.Error(w, "failed to load profile", http.StatusInternalServerError)
+			return
+		}
+		cached.expires = now.Add(config.ProfileCacheTTL)
+		s.profiles.put(name, cached, now)
+	}
+	if !cached.found {
+		http.Error(w, "player not found", http.StatusNotFound)
+		return
+	}
+
+	profile := cached.profile
+	if !s.isAdmin(r) {
+		profile.Moderation = nil
+	}
+	writeJSON(w, http.StatusOK, profile)
+}
+
+// loadProfile assembles a profile from the match history and the leaderboard
+func (s *GameServer) loadProfile(name string) (cachedProfile, error) {
+	stored, found, err := s.history.Profile(name)
+	if err != nil {
+		return cachedProfile{}, err
+	}
+	recent, err := s.history.Recent(name, config.ProfileRecentRounds)
+	if err != nil {
+		return cachedProfile{}, err
+	}
+
+	profile := playerProfile{
+		Profile:       stored,
+		Achievements:  stored.Achievements(),
+		RecentMatches: recent,
+	}
+	if entry, rank, ok := s.leaderboard.Rank(name); ok {
+		profile.Rank = rank
+		profile.SeasonBest = entry.Score
+		found = true
+	}
+	return cachedProfile{profile: profile, found: found || len(recent) > 0}, nil
+}
@@ -0,0 +1,7 @@
+package main
+
+// Plugins compiled into the server (see internal/plugin). A fork adds its
+// own with a blank import here, or in a file of its own next to this one.
+import (
+	_ "github.com/race/server/plugins/example"
+)
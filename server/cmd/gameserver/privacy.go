@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/race/server/internal/history"
+	"github.com/race/server/internal/leaderboard"
+)
+
+// Personal data requests
+//
+//   GET    /admin/players/{name}/export     - everything stored about a player
+//   DELETE /admin/players/{name}            - anonymize a player
+//   GET    /admin/accounts/{name}/privacy   - an account's privacy preferences
+//   PUT    /admin/accounts/{name}/privacy   - replace them
+//
+// There are no accounts: players are identified by the name they drive
+// under, so these are admin endpoints for an operator handling a verified
+// request rather than self-service ones. Deletion replaces the name with a
+// random alias in stored matches and on the leaderboards of every tenant (so
+// standings and the other drivers' rounds keep their stats) and drops the
+// profile, match list and owned cosmetics; kick fingerprints keep their
+// moderation value under the alias. Practice replays live in the room's
+// memory only and are never stored.
+//
+// Accounts can opt out of replay recording (see history.Privacy); the
+// account service sets the preference from its settings page. A practice
+// room then records nothing of a connection linked to the account, from
+// the join or the Link on, and a change takes effect in the account's
+// practice rooms right away. If the preference can't be read, the room
+// doesn't record.
+
+// playerExport is the response of /admin/players/{name}/export
+type playerExport struct {
+	Name     string                       `json:"name"`
+	Exported time.Time                    `json:"exported"`
+	Profile  *history.Profile             `json:"profile"` // Nil: no recorded round or kick
+	Matches  []history.Match              `json:"matches"` // Newest first
+	Seasons  []leaderboard.ArchivedSeason `json:"seasons"` // Own entries and rewards only
+	Replays  string                       `json:"replays"`
+	Privacy  history.Privacy              `json:"privacy"`
+
+	// Seasons of the other tenants the player raced in, by tenant key
+	TenantSeasons map[string][]leaderboard.ArchivedSeason `json:"tenantSeasons,omitempty"`
+
+	Cosmetics []string `json:"cosmetics"` // Owned items (see cosmetics.go)
+}
+
+// playerDeletion is the response of DELETE /admin/players/{name}
+type playerDeletion struct {
+	Name    string `json:"name"`
+	Alias   string `json:"alias"`
+	Matches int    `json:"matches"` // Matches rewritten with the alias
+}
+
+// handleAdminPlayer routes /admin/players/{name}[/export].
+func (s *GameServer) handleAdminPlayer(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/admin/players/")
+	name, export := strings.CutSuffix(path, "/export")
+	if name == "" || len(name) > 255 || strings.Contains(name, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case export && r.Method == http.MethodGet:
+		s.handlePlayerExport(w, name)
+	case !export && r.Method == http.MethodDelete:
+		s.handlePlayerDeletion(w, name)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handlePlayerExport returns everything stored about a player as JSON.
+func (s *GameServer) handlePlayerExport(w http.ResponseWriter, name string) {
+	export := playerExport{
+		Name:     name,
+		Exported: time.Now().UTC(),
+		Replays:  "practice replays are kept in memory for the room's lifetime only and never stored",
+	}
+
+	profile, found, err := s.history.Profile(name)
+	if err == nil && found {
+		export.Profile = &profile
+	}
+	if err == nil {
+		export.Privacy, err = s.history.Privacy(context.Background(), name)
+	}
+	if err == nil {
+		export.Matches, err = s.history.Recent(name, math.MaxInt)
+	}
+	if err == nil {
+		export.Seasons, err = s.leaderboard.PlayerSeasons(name)
+	}
+	if err == nil {
+		export.TenantSeasons, err = s.tenantSeasons(name)
+	}
+	if err == nil {
+		export.Cosmetics, err = s.inventory.Owned(context.Background(), name)
+	}
+	if err != nil {
+		log.Printf("Failed to export data of %s: %v", name, err)
+		http.Error(w, "failed to export player data", http.StatusInternalServerError)
+		return
+	}
+	if export.Profile == nil && len(export.Matches) == 0 && len(export.Seasons) == 0 && len(export.TenantSeasons) == 0 && len(export.Cosmetics) == 0 {
+		http.Error(w, "player not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="player-data.json"`)
+	writeJSON(w, http.StatusOK, export)
+}
+
+// tenantSeasons returns a player's seasons on the leaderboards of the
+// tenants other than the default one, by tenant key
+func (s *GameServer) tenantSeasons(name string) (map[string][]leaderboard.ArchivedSeason, error) {
+	var seasons map[string][]leaderboard.ArchivedSeason
+	for key, t := range s.tenants {
+		if key == "" {
+			continue
+		}
+		own, err := t.leaderboard.PlayerSeasons(name)
+		if err != nil {
+			return nil, err
+		}
+		if len(own) > 0 {
+			if seasons == nil {
+				seasons = make(map[string][]leaderboard.ArchivedSeason)
+			}
+			seasons[key] = own
+		}
+	}
+	return seasons, nil
+}
+
+// handlePlayerDeletion anonymizes a player's stored data.
+func (s *GameServer) handlePlayerDeletion(w http.ResponseWriter, name string) {
+	b := make([]byte, 4)
+	rand.Read(b)
+	alias := "deleted-" + hex.EncodeToString(b)
+
+	matches, err := s.history.Anonymize(name, alias)
+	for _, t := range s.tenants {
+		if err == nil {
+			err = t.leaderboard.Rename(name, alias)
+		}
+	}
+	if err == nil {
+		err = s.inventory.Delete(context.Background(), name)
+	}
+	s.profiles.drop(name)
+	s.fingerprints.Rename(name, alias)
+	if err != nil {
+		// Safe to retry: whatever was renamed no longer matches the name
+		log.Printf("Failed to delete data of %s: %v", name, err)
+		http.Error(w, "failed to delete player data", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Deleted data of a player (alias %s, %d matches)", alias, matches)
+	writeJSON(w, http.StatusOK, playerDeletion{Name: name, Alias: alias, Matches: matches})
+}
+
+// privacyResponse is an account's privacy preferences
+type privacyResponse struct {
+	Account string `json:"account"`
+	history.Privacy
+}
+
+// handleAdminPrivacy reads (GET) or replaces (PUT) an account's privacy
+// preferences.
+func (s *GameServer) handleAdminPrivacy(w http.ResponseWriter, r *http.Request, account string) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		prefs := history.DefaultPrivacy
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&prefs); err != nil {
+			http.Error(w, "invalid privacy preferences: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.history.SetPrivacy(r.Context(), account, prefs); err != nil {
+			log.Printf("Failed to set privacy preferences of %s: %v", account, err)
+			http.Error(w, "failed to set privacy preferences", http.StatusInternalServerError)
+			return
+		}
+		if !prefs.RecordReplays {
+			s.disableReplays(account)
+		}
+		log.Printf("Privacy preferences of %s: %+v", account, prefs)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	prefs, err := s.history.Privacy(r.Context(), account)
+	if err != nil {
+		log.Printf("Failed to read privacy preferences of %s: %v", account, err)
+		http.Error(w, "failed to read privacy preferences", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, privacyResponse{Account: account, Privacy: prefs})
+}
+
+// replaysAllowed reports whether practice rooms may record a connection:
+// guests yes, accounts as their preferences say
+func (s *GameServer) replaysAllowed(c *ClientConnection) bool {
+	c.mu.Lock()
+	account := c.account
+	c.mu.Unlock()
+	if account == "" {
+		return true
+	}
+
+	prefs, err := s.history.Privacy(c.ctx, account)
+	if err != nil {
+		if c.ctx.Err() == nil {
+			log.Printf("Failed to read privacy preferences of %s: %v", account, err)
+		}
+		return false
+	}
+	return prefs.RecordReplays
+}
+
+// disableReplays stops recording in the practice rooms of an account's
+// connections
+func (s *GameServer) disableReplays(account string) {
+	s.connMu.Lock()
+	conns := make([]*ClientConnection, 0, len(s.connections))
+	for c := range s.connections {
+		conns = append(conns, c)
+	}
+	s.connMu.Unlock()
+
+	for _, c := range conns {
+		c.mu.Lock()
+		room := c.room
+		linked := c.account == account
+		c.mu.Unlock()
+		if linked && room != nil && room.Practice() {
+			room.DisableReplays()
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/race/server/internal/game"
+	"github.com/race/server/internal/matchmaker"
+	"github.com/race/server/internal/network"
+)
+
+// Private rooms
+//
+// A player (protocol v30) opens a private room with a CreateRoom message,
+// optionally with a password, and joins it right away; the server answers
+// with a RoomCode message carrying the room's join code, which the player
+// shares with their friends. They join with a JoinByCode message of the
+// code and the password. Both messages carry the join's name, color and
+// cosmetics and go through the same checks as a join. Players joining by
+// code get the RoomCode too, so anyone in the room can pass it on. The
+// creator is the room's host (see game/host.go) until they leave. The host
+// (protocol v38) can close the room to new players or change its password
+// with a HostSettings message; the room's players get RoomSettings with who
+// may join, as do players joining it.
+//
+// Matchmaking never puts anyone in a private room (see
+// matchmaker/private.go), and the cluster's room registry lists it as not
+// public. Codes are only known to the server of the room. Creating rooms
+// and joining by code share a rate limit (config.RoomCodeRate) that keeps
+// guessing codes slow; /stats counts the rooms (privateRooms).
+
+// handleCreateRoom opens a private room and joins the player to it.
+func (c *ClientConnection) handleCreateRoom(m *message) {
+	msg, err := c.server.protocol.DecodeCreateRoom(m.data)
+	if err != nil {
+		log.Printf("Invalid create room message from %s: %v", c.info, err)
+		return
+	}
+	c.fingerprint.Join(time.Now())
+
+	if c.spectate != "" {
+		c.watch()
+		return
+	}
+	name, ok := c.checkJoin(msg.Join)
+	if !ok {
+		return
+	}
+
+	room, code := c.tenant.matchmaker.CreatePrivateRoom(msg.Password)
+	if room == nil {
+		c.Send(c.server.protocol.EncodeError(network.ErrorCodeRoomFull, "No private room available"))
+		return
+	}
+	log.Printf("Player '%s' opened private room %s", name, room.ID)
+	c.joinPrivate(room, code, name, msg.Join.Color)
+}
+
+// handleJoinByCode joins the player to the private room of a code.
+func (c *ClientConnection) handleJoinByCode(m *message) {
+	msg, err := c.server.protocol.DecodeJoinByCode(m.data)
+	if err != nil {
+		log.Printf("Invalid join by code message from %s: %v", c.info, err)
+		return
+	}
+	c.fingerprint.Join(time.Now())
+
+	if c.spectate != "" {
+		c.watch()
+		return
+	}
+	name, ok := c.checkJoin(msg.Join)
+	if !ok {
+		return
+	}
+
+	room, err := c.tenant.matchmaker.PrivateRoom(msg.Code, msg.Password)
+	if errors.Is(err, matchmaker.ErrRoomWrongPassword) || errors.Is(err, matchmaker.ErrRoomClosed) {
+		c.Send(c.server.protocol.EncodeError(network.ErrorCodeNotAllowed, err.Error()))
+		return
+	}
+	if err != nil {
+		c.Send(c.server.protocol.EncodeError(network.ErrorCodeRoomFull, err.Error()))
+		return
+	}
+	c.joinPrivate(room, msg.Code, name, msg.Join.Color)
+}
+
+// joinPrivate joins the player to a private room and tells them its code.
+func (c *ClientConnection) joinPrivate(room *game.Room, code, name string, color uint8) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.joinUnlocked(room, name, color); err != nil {
+		c.Send(c.server.protocol.EncodeError(network.ErrorCodeRoomFull, err.Error()))
+		return
+	}
+	c.Send(c.server.protocol.EncodeRoomCode(code))
+	if c.ProtocolVersion() >= network.ProtocolV38 {
+		closed, locked := c.tenant.matchmaker.PrivateAccess(room.ID)
+		c.Send(c.server.protocol.EncodeRoomSettings(closed, locked))
+	}
+}
+
+// handleHostSettings lets the host of a private room close it to new
+// players or change its password.
+func (c *ClientConnection) handleHostSettings(m *message) {
+	msg, err := c.server.protocol.DecodeHostSettings(m.data)
+	if err != nil {
+		log.Printf("Invalid host settings message from %s: %v", c.info, err)
+		return
+	}
+
+	if !m.room.IsHost(m.player.ID) {
+		c.Send(c.server.protocol.EncodeError(network.ErrorCodeNotAllowed, game.ErrNotHost.Error()))
+		return
+	}
+	if err := c.tenant.matchmaker.SetPrivateAccess(m.room.ID, msg.Closed, msg.Password); err != nil {
+		c.Send(c.server.protocol.EncodeError(network.ErrorCodeNotAllowed, err.Error()))
+		return
+	}
+	log.Printf("Host %d of private room %s: closed %v, password %v", m.player.ID, m.room.ID, msg.Closed, msg.Password != "")
+	m.room.AnnounceAccess(msg.Closed, msg.Password != "")
+}
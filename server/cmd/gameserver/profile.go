@@ -0,0 +1,61 @@
+package main
+
+import (
+	"log"
+
+	"github.com/race/server/internal/moderation"
+	"github.com/race/server/internal/network"
+)
+
+// Profile changes
+//
+// A player in a room (protocol v29) changes their name and color with an
+// UpdateProfile message instead of leaving and joining again, which would
+// cost them their run. The name goes through the same checks as a join's;
+// an empty one keeps the current name. A player under a linked account's
+// name keeps it and may only change color. The room applies the change on
+// its next tick and broadcasts it as a PlayerJoin of the car, which clients
+// take as an update of a player they know. Changes have their own rate
+// limit (config.ProfileUpdateRate), as each one goes to the whole room;
+// /stats counts them (profileUpdates).
+//
+// Mutes are kept by name (see voice.go), so they follow a renamed player.
+
+// handleUpdateProfile changes the player's name and color.
+func (c *ClientConnection) handleUpdateProfile(m *message) {
+	msg, err := c.server.protocol.DecodeUpdateProfile(m.data)
+	if err != nil {
+		return
+	}
+
+	current := m.player.GetName()
+	name := current
+	if msg.Name != "" {
+		var verdict moderation.Verdict
+		name, verdict = c.server.moderator.SanitizeName(c.ctx, msg.Name, 20, "Player")
+		if c.ctx.Err() != nil {
+			return // Disconnected while the name was checked
+		}
+		if verdict.Reason != "" {
+			log.Printf("Name from %s moderated: %s", c.info, verdict.Reason)
+		}
+	}
+
+	c.mu.Lock()
+	linked := c.linkedAccountUnlocked(current) != ""
+	c.mu.Unlock()
+	if linked && name != current {
+		c.Send(c.server.protocol.EncodeError(network.ErrorCodeNotAllowed, "Linked accounts play under their account name"))
+		return
+	}
+
+	if err := m.room.SetProfile(m.player.ID, name, msg.Color); err != nil {
+		c.Send(c.server.protocol.EncodeError(network.ErrorCodeNotAllowed, err.Error()))
+		return
+	}
+	c.server.metrics.profileUpdates.Add(1)
+	if name != current {
+		c.info.SetAccount(name)
+		c.server.renameMuted(current, name)
+	}
+}
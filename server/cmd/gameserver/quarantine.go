@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/trace"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/game"
+	"github.com/race/server/internal/network"
+	"github.com/race/server/internal/routines"
+)
+
+// Room quarantine
+//
+// When the frame-budget watchdog quarantines a room (see game/watchdog.go),
+// the server, off the room's tick:
+//
+//  1. takes an execution trace of the process for
+//     config.QuarantineTraceLength while the room still runs, into
+//     QUARANTINE_TRACE_DIR (the temp directory by default) as
+//     quarantine-<room>-<unix time>.trace, for `go tool trace`
+//  2. POSTs the watchdog's report, the trace's path and the instance ID to
+//     QUARANTINE_WEBHOOK, if set
+//  3. with QUARANTINE_MIGRATE=true, moves the room's players to other rooms
+//     of their game: they get the new room's RoomInfo as if they had joined
+//     it. Practice, honeypot and reserved rooms keep their players, and so
+//     does every room when the server has no other room to spare.
+//
+// A process runs one execution trace at a time, so a quarantine during
+// another one's trace goes without. /stats counts the quarantinedRooms open
+// and the quarantines so far.
+
+// quarantineEvent is the webhook call of a quarantine
+type quarantineEvent struct {
+	Event    string                `json:"event"` // "room.quarantined"
+	Instance string                `json:"instance"`
+	Tenant   string                `json:"tenant"`
+	Report   game.TickBudgetReport `json:"report"`
+	Trace    string                `json:"trace,omitempty"` // Path on the server ("": none taken)
+}
+
+var webhookClient = &http.Client{Timeout: config.WebhookTimeout}
+
+// onQuarantine handles the quarantine of one of a tenant's rooms. Called
+// by the room's tick.
+func (s *GameServer) onQuarantine(t *tenant, report game.TickBudgetReport) {
+	s.metrics.quarantines.Add(1)
+	routines.Go("server.quarantine", func() {
+		event := quarantineEvent{
+			Event:    "room.quarantined",
+			Instance: s.config.InstanceID,
+			Tenant:   t.key,
+			Report:   report,
+			Trace:    s.traceQuarantine(report.Room),
+		}
+		if s.config.QuarantineWebhook != "" {
+			if err := postWebhook(s.config.QuarantineWebhook, event); err != nil {
+				log.Printf("Quarantine webhook for room %s failed: %v", report.Room, err)
+			}
+		}
+		if s.config.QuarantineMigrate {
+			s.migrateRoom(t, report.Room)
+		}
+	})
+}
+
+// traceQuarantine takes the execution trace of a quarantine and returns
+// its path ("" if none was taken)
+func (s *GameServer) traceQuarantine(roomID string) string {
+	dir := s.config.QuarantineTraceDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	path := filepath.Join(dir, fmt.Sprintf("quarantine-%s-%d.trace", roomID, time.Now().Unix()))
+	f, err := os.Create(path)
+	if err != nil {
+		log.Printf("No trace of quarantined room %s: %v", roomID, err)
+		return ""
+	}
+	defer f.Close()
+	if err := trace.Start(f); err != nil {
+		log.Printf("No trace of quarantined room %s: %v", roomID, err)
+		os.Remove(path)
+		return ""
+	}
+
+	timer := time.NewTimer(config.QuarantineTraceLength)
+	select {
+	case <-timer.C:
+	case <-s.quit:
+		timer.Stop()
+	}
+	trace.Stop()
+	log.Printf("Traced quarantined room %s to %s", roomID, path)
+	return path
+}
+
+// postWebhook POSTs v as JSON to url
+func postWebhook(url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// migrateRoom moves the players of a quarantined room to other rooms of
+// its tenant
+func (s *GameServer) migrateRoom(t *tenant, roomID string) {
+	room := t.matchmaker.GetRoom(roomID)
+	if room == nil {
+		return // Closed meanwhile
+	}
+	if room.Practice() || room.Honeypot() || t.matchmaker.Reserved(roomID) || t.matchmaker.RoomCode(roomID) != "" {
+		log.Printf("Players of quarantined room %s stay: it is private", roomID)
+		return
+	}
+
+	s.connMu.Lock()
+	conns := make([]*ClientConnection, 0, len(s.connections))
+	for conn := range s.connections {
+		conns = append(conns, conn)
+	}
+	s.connMu.Unlock()
+
+	moved, stayed := 0, 0
+	for _, conn := range conns {
+		if _, r := conn.session(); r != room {
+			continue
+		}
+		target := t.matchmaker.FindRoom() // Never the quarantined room
+		if target == nil {
+			stayed++
+			continue
+		}
+		if s.migrate(conn, room, target) {
+			moved++
+		}
+	}
+	log.Printf("Moved %d players out of quarantined room %s; %d stayed for lack of another room", moved, roomID, stayed)
+}
+
+// migrate moves a connection's player from room to target. Returns false
+// if they left meanwhile.
+func (s *GameServer) migrate(conn *ClientConnection, room, target *game.Room) bool {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	player := conn.player
+	if conn.closed || conn.room != room || player == nil || room.HandOff(player.ID) == nil {
+		return false
+	}
+	conn.player, conn.room = nil, nil
+	if err := conn.joinUnlocked(target, player.GetName(), player.Color); err != nil {
+		log.Printf("Failed to move %s out of quarantined room %s: %v", conn.info, room.ID, err)
+		conn.Send(s.protocol.EncodeError(network.ErrorCodeRoomFull, "Room closed"))
+		return false
+	}
+	return true
+}
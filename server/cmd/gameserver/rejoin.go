@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/game"
+	"github.com/race/server/internal/network"
+	"github.com/race/server/internal/token"
+)
+
+// Rejoining
+//
+// Every connection plays one session, named by a random key. Rooms keep the
+// progress of a session's player for a while after they leave (see
+// game/rejoin.go), and a player who comes back to the room in time carries
+// on where they left. Leaving a room ends the connection, so coming back
+// takes a new one: after every join, ProtocolV28 clients get a Session
+// message with a session token (valid for config.SessionTokenTTL) naming
+// the room and the session, and reconnect with /ws?session=<token>. The new
+// connection takes over the session, and its JoinRoom goes back to the
+// room, ahead of the join queue, if the room is still open and kept the
+// player's place; otherwise the join is matched as usual. A player whose
+// previous connection is still in the room (it dropped without the server
+// noticing yet) takes over the car. Practice rooms hand out no tokens.
+// /stats counts the rejoins.
+
+// newSessionKey returns the key of a new session
+func newSessionKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// sessionOf returns the session a /ws?session= token continues and the room
+// it names, or a new session and "" if the request carries no token
+func (s *GameServer) sessionOf(r *http.Request) (key, room string, err error) {
+	session := r.URL.Query().Get("session")
+	if session == "" {
+		return newSessionKey(), "", nil
+	}
+	// Presented again at every reconnect until it expires; the room decides
+	// whether the player's place is still kept
+	claims, err := s.tokens.Verify(token.PurposeSession, session)
+	if err != nil {
+		return "", "", err
+	}
+	return claims.Data, claims.Subject, nil
+}
+
+// sendSessionToken gives a client that joined a room the token to rejoin it
+func (s *GameServer) sendSessionToken(c *ClientConnection, room *game.Room) {
+	if c.ProtocolVersion() < network.ProtocolV28 || room.Practice() {
+		return
+	}
+	issued, err := s.tokens.Issue(token.PurposeSession, room.ID, c.sessionKey, config.SessionTokenTTL)
+	if err != nil {
+		log.Printf("Failed to issue a session token for %s: %v", c.info, err)
+		return
+	}
+	c.Send(s.protocol.EncodeSession(issued))
+}
+
+// rejoin joins the player back into the room of their session token, if it
+// kept their place. Returns false if the join is to be matched as usual.
+func (c *ClientConnection) rejoin(name string, color uint8) bool {
+	if c.resume == "" {
+		return false
+	}
+	room := c.tenant.matchmaker.GetRoom(c.resume)
+	if room == nil || !room.Resumable(c.sessionKey) {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.joinUnlocked(room, name, color); err != nil {
+		log.Printf("%s could not rejoin room %s: %v", c.info, room.ID, err)
+		return false
+	}
+	c.server.metrics.rejoins.Add(1)
+	return true
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/race/server/config"
+)
+
+// Daily report
+//
+//   GET /admin/reports - this server's daily reports, newest first (?days=N)
+//
+// A health digest for operators without a metrics stack: the trend samples
+// (see trends.go) also feed a report of the current UTC day, with the peak
+// players, connections and rooms, the joins and matches played, the
+// average and slowest room tick, the anti-cheat kicks and honeypot
+// diversions, and the errors sent to clients most often. When the day is
+// over the report is stored under "report:<instance>:<date>" for
+// config.DailyReportRetention and POSTed to DAILY_REPORT_WEBHOOK, if set.
+// The report of the day so far is saved every config.DailyReportSave and at
+// shutdown, and a restarted server carries on with it.
+
+// dailyReport summarizes a server's UTC day
+type dailyReport struct {
+	Date        string    `json:"date"` // 2006-01-02
+	Instance    string    `json:"instance"`
+	From        time.Time `json:"from"` // First and last sample of the day
+	To          time.Time `json:"to"`
+	Partial     bool      `json:"partial,omitempty"` // The day isn't over
+	PeakPlayers int       `json:"peakPlayers"`
+	PeakConns   int       `json:"peakConnections"`
+	PeakRooms   int       `json:"peakRooms"`
+	PeakAt      time.Time `json:"peakAt"` // Of the peak players
+	Joins       uint64    `json:"joins"`
+	Matches     uint64    `json:"matches"`
+	Ticks       uint64    `json:"ticks"`
+	BusyMS      float64   `json:"busyMs"` // Time spent in the ticks
+	AvgTickMS   float64   `json:"avgTickMs"`
+	MaxTickMS   float64   `json:"maxTickMs"` // Slowest tick seen by the samples
+	Kicks       uint64    `json:"kicks"`
+	Honeypotted uint64    `json:"honeypotted"`
+
+	Errors    map[string]uint64 `json:"errors,omitempty"` // Sent to clients, by code and message
+	TopErrors []errorCount      `json:"topErrors,omitempty"`
+}
+
+// errorCount is how often an error went to clients
+type errorCount struct {
+	Error string `json:"error"`
+	Count uint64 `json:"count"`
+}
+
+// reportSample is what the report takes of the server at a sample. Counters
+// are totals since startup.
+type reportSample struct {
+	trendSample
+	Matches     uint64
+	Honeypotted uint64
+	Ticks       uint64
+	BusyMS      float64
+	MaxTickMS   float64
+}
+
+// dailyReports builds the report of the day
+type dailyReports struct {
+	mu    sync.Mutex
+	day   *dailyReport
+	last  *reportSample // Previous sample of this process, the counters' baseline
+	saved time.Time
+}
+
+// add takes a sample into the report of its day and returns the report of
+// the previous day if the sample started a new one
+func (d *dailyReports) add(instance string, sample reportSample) *dailyReport {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var done *dailyReport
+	date := sample.Time.UTC().Format(time.DateOnly)
+	if d.day != nil && d.day.Date != date {
+		done = d.day
+		done.Partial = false
+		done.rankErrors()
+		d.day = nil
+	}
+	if d.day == nil {
+		d.day = &dailyReport{Date: date, Instance: instance, From: sample.Time.UTC(), Partial: true}
+	}
+
+	r := d.day
+	r.To = sample.Time.UTC()
+	if sample.Players > r.PeakPlayers || r.PeakAt.IsZero() {
+		r.PeakPlayers, r.PeakAt = sample.Players, sample.Time.UTC()
+	}
+	r.PeakConns = max(r.PeakConns, sample.Connections)
+	r.PeakRooms = max(r.PeakRooms, sample.Rooms)
+	r.MaxTickMS = max(r.MaxTickMS, sample.MaxTickMS)
+	if last := d.last; last != nil {
+		r.Joins += sample.Joins - last.Joins
+		r.Matches += sample.Matches - last.Matches
+		r.Kicks += sample.Kicks - last.Kicks
+		r.Honeypotted += sample.Honeypotted - last.Honeypotted
+		r.Ticks += sample.Ticks - last.Ticks
+		r.BusyMS += sample.BusyMS - last.BusyMS
+	}
+	if r.Ticks > 0 {
+		r.AvgTickMS = r.BusyMS / float64(r.Ticks)
+	}
+	d.last = &sample
+	return done
+}
+
+// countError counts an error sent to a client
+func (d *dailyReports) countError(code uint8, message string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.day == nil {
+		return // Before the first sample
+	}
+	if d.day.Errors == nil {
+		d.day.Errors = make(map[string]uint64)
+	}
+	key := fmt.Sprintf("%d: %s", code, message)
+	if _, ok := d.day.Errors[key]; !ok && len(d.day.Errors) >= config.DailyReportErrorKinds {
+		key = "other"
+	}
+	d.day.Errors[key]++
+}
+
+// current returns a copy of the report of the day so far (nil before the
+// first sample)
+func (d *dailyReports) current() *dailyReport {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.day == nil {
+		return nil
+	}
+	r := *d.day
+	r.Errors = make(map[string]uint64, len(d.day.Errors))
+	for k, n := range d.day.Errors {
+		r.Errors[k] = n
+	}
+	r.rankErrors()
+	return &r
+}
+
+// resume carries on with a report of the day saved by a previous process
+func (d *dailyReports) resume(r *dailyReport) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.day == nil {
+		d.day = r
+	}
+}
+
+// rankErrors lists the most frequent errors
+func (r *dailyReport) rankErrors() {
+	r.TopErrors = make([]errorCount, 0, len(r.Errors))
+	for e, n := range r.Errors {
+		r.TopErrors = append(r.TopErrors, errorCount{Error: e, Count: n})
+	}
+	sort.Slice(r.TopErrors, func(i, j int) bool {
+		if r.TopErrors[i].Count != r.TopErrors[j].Count {
+			return r.TopErrors[i].Count > r.TopErrors[j].Count
+		}
+		return r.TopErrors[i].Error < r.TopErrors[j].Error
+	})
+	if len(r.TopErrors) > config.DailyReportTopErrors {
+		r.TopErrors = r.TopErrors[:config.DailyReportTopErrors]
+	}
+}
+
+func reportKey(instance, date string) string {
+	return "report:" + instance + ":" + date
+}
+
+func reportsSet(instance string) string {
+	return "reports:" + instance
+}
+
+// reportSample samples the server for the daily report
+func (s *GameServer) reportSample(trend trendSample) reportSample {
+	sched := s.scheduler.Stats()
+	return reportSample{
+		trendSample: trend,
+		Matches:     s.metrics.matches.Load(),
+		Honeypotted: s.metrics.honeypotted.Load(),
+		Ticks:       sched.Ticks,
+		BusyMS:      sched.BusyMS,
+		MaxTickMS:   sched.MaxTickMS,
+	}
+}
+
+// sampleReport takes a trend sample into the daily report, storing the
+// reports of days that ended
+func (s *GameServer) sampleReport(trend trendSample) {
+	if done := s.daily.add(s.config.InstanceID, s.reportSample(trend)); done != nil {
+		s.saveReport(done)
+		log.Printf("Daily report of %s: peak %d players, %d matches, %.2fms average tick, %d kicks",
+			done.Date, done.PeakPlayers, done.Matches, done.AvgTickMS, done.Kicks)
+		if s.config.DailyReportWebhook != "" {
+			if err := postWebhook(s.config.DailyReportWebhook, done); err != nil {
+				log.Printf("Daily report webhook for %s failed: %v", done.Date, err)
+			}
+		}
+	}
+
+	s.daily.mu.Lock()
+	due := trend.Time.Sub(s.daily.saved) >= config.DailyReportSave
+	if due {
+		s.daily.saved = trend.Time
+	}
+	s.daily.mu.Unlock()
+	if due {
+		s.saveDailyReport()
+	}
+}
+
+// saveDailyReport stores the report of the day so far
+func (s *GameServer) saveDailyReport() {
+	if r := s.daily.current(); r != nil {
+		s.saveReport(r)
+	}
+}
+
+// saveReport stores a report
+func (s *GameServer) saveReport(r *dailyReport) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		log.Printf("Failed to encode the daily report of %s: %v", r.Date, err)
+		return
+	}
+	day, _ := time.Parse(time.DateOnly, r.Date)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = s.store.Set(ctx, reportKey(r.Instance, r.Date), data, config.DailyReportRetention)
+	if err == nil {
+		err = s.store.ZAdd(ctx, reportsSet(r.Instance), r.Date, float64(day.Unix()))
+	}
+	if err != nil {
+		log.Printf("Failed to store the daily report of %s: %v", r.Date, err)
+	}
+}
+
+// resumeDailyReport picks up the report of today a previous process of this
+// server saved
+func (s *GameServer) resumeDailyReport() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	date := time.Now().UTC().Format(time.DateOnly)
+	data, ok, err := s.store.Get(ctx, reportKey(s.config.InstanceID, date))
+	if err != nil {
+		log.Printf("Failed to load the daily report of %s: %v", date, err)
+		return
+	}
+	if !ok {
+		return
+	}
+	var r dailyReport
+	if err := json.Unmarshal(data, &r); err != nil {
+		log.Printf("Failed to load the daily report of %s: %v", date, err)
+		return
+	}
+	s.daily.resume(&r)
+}
+
+// handleAdminReports lists this server's stored daily reports, newest
+// first, and the report of today so far.
+func (s *GameServer) handleAdminReports(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	days := 7
+	if n, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && n > 0 && n <= 366 {
+		days = n
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	instance := s.config.InstanceID
+	today := s.daily.current()
+	dates, err := s.store.ZRevRange(ctx, reportsSet(instance), 0, days)
+	if err != nil {
+		http.Error(w, "failed to list reports", http.StatusInternalServerError)
+		return
+	}
+	reports := make([]*dailyReport, 0, days)
+	for _, d := range dates {
+		if today != nil && d.Member == today.Date || len(reports) == days {
+			continue // Stored so far; served live instead
+		}
+		data, ok, err := s.store.Get(ctx, reportKey(instance, d.Member))
+		if err != nil {
+			http.Error(w, "failed to read reports", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			s.store.ZRem(ctx, reportsSet(instance), d.Member) // Expired
+			continue
+		}
+		var report dailyReport
+		if err := json.Unmarshal(data, &report); err != nil {
+			continue
+		}
+		reports = append(reports, &report)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"today":   today,
+		"reports": reports,
+	})
+}
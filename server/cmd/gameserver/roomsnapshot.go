@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/game"
+	"github.com/race/server/internal/token"
+)
+
+// Room snapshots
+//
+//   GET  /admin/rooms/{id}/snapshot       - a room's full state as JSON (admin token)
+//   POST /admin/rooms/import?tenant=name  - restore a snapshot into a new room (admin token)
+//
+// Support exports the room of a bug report from production and imports it
+// on a local or staging server to reproduce the situation exactly (see
+// game/roomsnapshot.go for what is captured). The imported room is reserved
+// like a match allocation: it is only joined with the ticket in the
+// response, at /ws?ticket=<token>, within config.AllocationTTL. The
+// snapshot's humans are stand-ins holding their last input, and the room
+// holds still until the ticket holder joins.
+
+// importResponse tells support where the restored room is
+type importResponse struct {
+	Room    string    `json:"room"`
+	Expires time.Time `json:"expires"`
+	Ticket  string    `json:"ticket"` // Token for /ws?ticket=
+}
+
+// handleAdminRoomSnapshot returns a room's snapshot
+func (s *GameServer) handleAdminRoomSnapshot(w http.ResponseWriter, r *http.Request, room *game.Room) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, room.Snapshot())
+}
+
+// handleAdminRoomImport restores a snapshot into a new reserved room
+func (s *GameServer) handleAdminRoomImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var snap game.RoomSnapshot
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, config.SnapshotBodyMax)).Decode(&snap); err != nil {
+		http.Error(w, "invalid snapshot: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	t := s.tenants[r.URL.Query().Get("tenant")]
+	if t == nil {
+		http.Error(w, "tenant not found", http.StatusNotFound)
+		return
+	}
+	if s.draining.Load() {
+		http.Error(w, "server draining", http.StatusServiceUnavailable)
+		return
+	}
+
+	expires := time.Now().Add(config.AllocationTTL)
+	room, err := t.matchmaker.RestoreRoom(&snap, expires)
+	if err != nil {
+		http.Error(w, "invalid snapshot: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if room == nil {
+		http.Error(w, "no room available", http.StatusServiceUnavailable)
+		return
+	}
+	ticket, err := s.tokens.Issue(token.PurposeTicket, room.ID, "support", config.AllocationTTL)
+	if err != nil {
+		t.matchmaker.RemoveRoom(room.ID)
+		http.Error(w, "failed to issue ticket", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, importResponse{Room: room.ID, Expires: expires.UTC(), Ticket: ticket})
+}
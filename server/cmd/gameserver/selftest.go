@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/crash"
+	"github.com/race/server/internal/game"
+	"github.com/race/server/internal/moderation"
+	"github.com/race/server/internal/network"
+	"github.com/race/server/internal/plugin"
+	"github.com/race/server/internal/rules"
+	"github.com/race/server/internal/storage"
+	"github.com/race/server/internal/track"
+)
+
+// Startup self-test
+//
+// Mistakes in the configuration should stop the server at boot with a
+// message saying what to change, not surface as odd behavior once players
+// are in. Before the server listens, validateConfig checks the settings for
+// values that can't work (or can't work together) and that the configured
+// files parse, and selfTest exercises what players depend on: a round trip
+// to the store, the built-in track, and a second of headless simulation of a
+// room full of bots for every room config in use. Every problem found is
+// logged and the server refuses to start. SELF_TEST=false skips selfTest
+// (the configuration is always validated).
+
+// refuseToStart logs the problems found at startup and exits.
+func refuseToStart(what string, problems []error) {
+	log.Printf("%s:", what)
+	for _, problem := range problems {
+		log.Printf("  - %v", problem)
+	}
+	log.Fatalf("Refusing to start (%d problems)", len(problems))
+}
+
+// validateConfig checks the configuration. Every problem is reported,
+// naming the setting to change.
+func validateConfig(cfg *config.ServerConfig) []error {
+	var problems []error
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	if cfg.Port < 1 || cfg.Port > 65535 {
+		problem("PORT: %d is not a TCP port", cfg.Port)
+	}
+
+	// Rules scripts compile, and the rules rooms name exist as a script or
+	// a plugin's game mode
+	lib := &rules.Library{}
+	if cfg.RulesDir != "" {
+		loaded, err := rules.LoadDir(cfg.RulesDir)
+		if err != nil {
+			problem("RULES_DIR: %v", err)
+		} else {
+			lib = loaded
+		}
+	}
+	for _, name := range lib.Names() {
+		if _, ok := plugin.LookupGameMode(name); ok {
+			problem("RULES_DIR: %s.star is hidden by the plugin game mode %q; rename it", name, name)
+		}
+	}
+	modes := plugin.Modes(lib)
+	checkRules := func(where string, rc game.RoomConfig) {
+		if rc.Rules == "" {
+			return
+		}
+		if _, err := modes.New(rc.Rules); err != nil {
+			problem("%s: %v (RULES_DIR has %v)", where, err, lib.Names())
+		}
+	}
+
+	// Rates and rules of the default tenant's rooms, then of every other tenant's
+	defaults := tenantRoomConfig(cfg, game.RoomConfig{})
+	problems = append(problems, validateRoomConfig("PHYSICS_TICK_RATE/BROADCAST_RATE/WORLD_REBASE_DISTANCE/SPECTATOR_DELAY", defaults)...)
+	checkRules("ROOM_RULES", defaults)
+	if cfg.TenantsFile != "" {
+		tenants, err := loadTenants(cfg.TenantsFile)
+		if err != nil {
+			problem("TENANTS_FILE: %v", err)
+		}
+		for _, tc := range tenants {
+			where := fmt.Sprintf("TENANTS_FILE: tenant %q", tc.Key)
+			rc := tenantRoomConfig(cfg, tc.RoomConfig)
+			problems = append(problems, validateRoomConfig(where, rc)...)
+			checkRules(where, rc)
+		}
+	}
+
+	// Capacities
+	if config.PracticeRoomsMax > config.MaxRoomsPerServer {
+		problem("config.PracticeRoomsMax: %d practice rooms don't fit in config.MaxRoomsPerServer (%d)", config.PracticeRoomsMax, config.MaxRoomsPerServer)
+	}
+	if cfg.JoinQueueLength >= cfg.MaxConnections {
+		// Queued players hold connections too
+		problem("JOIN_QUEUE_LENGTH: %d must be below MAX_CONNECTIONS (%d)", cfg.JoinQueueLength, cfg.MaxConnections)
+	}
+	if cfg.JoinQueueLength > 0 && cfg.JoinQueueTimeout <= 0 {
+		problem("JOIN_QUEUE_TIMEOUT: must be positive when the join queue is enabled")
+	}
+	if cfg.AutoscaleTarget-config.AutoscaleHysteresis <= 0 || cfg.AutoscaleTarget > 1 {
+		problem("AUTOSCALE_TARGET: %.0f%% must be above %.0f%% and at most 100%%", cfg.AutoscaleTarget*100, config.AutoscaleHysteresis*100)
+	}
+	if cfg.AutoscaleMin < 0 || cfg.AutoscaleMax < 0 || cfg.AutoscaleMax > 0 && cfg.AutoscaleMin > cfg.AutoscaleMax {
+		problem("AUTOSCALE_MIN, AUTOSCALE_MAX: %d to %d servers is no range", cfg.AutoscaleMin, cfg.AutoscaleMax)
+	}
+
+	if _, err := game.ParseSequenceMode(cfg.InputSequenceMode); err != nil {
+		problem("INPUT_SEQUENCE_MODE: %v", err)
+	}
+	if _, err := game.ParseCollisionMode(cfg.Collisions); err != nil {
+		problem("COLLISIONS: %v", err)
+	}
+	if cfg.AntiCheatPolicy != policyKick && cfg.AntiCheatPolicy != policyHoneypot {
+		problem("ANTICHEAT_POLICY: unknown policy %q (%s or %s)", cfg.AntiCheatPolicy, policyKick, policyHoneypot)
+	}
+	if cfg.SessionPolicy != sessionPolicyKick && cfg.SessionPolicy != sessionPolicyReject {
+		problem("SESSION_POLICY: unknown policy %q (%s or %s)", cfg.SessionPolicy, sessionPolicyKick, sessionPolicyReject)
+	}
+	if cfg.PayloadRequired && cfg.PayloadKey == "" {
+		problem("PAYLOAD_REQUIRED: needs PAYLOAD_KEY")
+	}
+	if cfg.StateCodec != stateCodecRecords && cfg.StateCodec != stateCodecDeflate {
+		problem("STATE_CODEC: unknown codec %q (%s or %s)", cfg.StateCodec, stateCodecRecords, stateCodecDeflate)
+	}
+	if _, err := network.ParsePriorities(cfg.MessagePriorities); err != nil {
+		problem("MESSAGE_PRIORITIES: %v", err)
+	}
+	if cfg.CrashReportDSN != "" {
+		if _, err := crash.NewSentrySink(cfg.CrashReportDSN); err != nil {
+			problem("CRASH_REPORT_DSN: %v", err)
+		}
+	}
+	if cfg.StatsdAddr != "" {
+		if _, _, err := net.SplitHostPort(cfg.StatsdAddr); err != nil {
+			problem("STATSD_ADDR: %v", err)
+		}
+	}
+
+	// Plugins named by the config are compiled in
+	var backends []string
+	for _, ext := range plugin.Extensions() {
+		if ext.Kind == plugin.KindStorageBackend {
+			backends = append(backends, ext.Name)
+		}
+	}
+	switch cfg.StoreBackend {
+	case storage.BackendMemory:
+	case storage.BackendRedis, storage.BackendSQL:
+		if cfg.StoreURL == "" {
+			problem("STORE_URL: required by STORE_BACKEND=%s", cfg.StoreBackend)
+		}
+	default:
+		if !slices.Contains(backends, cfg.StoreBackend) {
+			problem("STORE_BACKEND: unknown backend %q (%s, %s, %s or a plugin's: %v)", cfg.StoreBackend,
+				storage.BackendMemory, storage.BackendRedis, storage.BackendSQL, backends)
+		}
+	}
+	if cfg.ScoringPolicy != "" {
+		if _, ok := plugin.LookupScoringPolicy(cfg.ScoringPolicy); !ok {
+			problem("SCORING_POLICY: no plugin scoring policy %q", cfg.ScoringPolicy)
+		}
+	}
+	if _, err := plugin.AntiCheatRules(cfg.AntiCheatRules); err != nil {
+		problem("ANTICHEAT_RULES: %v", err)
+	}
+
+	if cfg.Agones && (cfg.AgonesPort < 1 || cfg.AgonesPort > 65535) {
+		problem("AGONES_SDK_HTTP_PORT: %d is not a TCP port", cfg.AgonesPort)
+	}
+	if cfg.Console != "" && cfg.Console != "stdin" {
+		if info, err := os.Stat(filepath.Dir(cfg.Console)); err != nil || !info.IsDir() {
+			problem("CONSOLE: no directory for the socket %s", cfg.Console)
+		}
+	}
+
+	// Files loaded at startup
+	if cfg.ModerationRulesFile != "" {
+		rules, err := moderation.LoadRules(cfg.ModerationRulesFile)
+		if err == nil {
+			err = moderation.NewModerator(nil).SetRules(rules)
+		}
+		if err != nil {
+			problem("MODERATION_RULES_FILE: %v", err)
+		}
+	}
+	if cfg.BotProfilesFile != "" {
+		if _, err := game.LoadBotProfiles(cfg.BotProfilesFile); err != nil {
+			problem("BOT_PROFILES_FILE: %v", err)
+		}
+	}
+
+	return problems
+}
+
+// validateRoomConfig checks the rates of a room config. Besides the bounds,
+// the broadcast rate must divide the physics rate, or state updates leave
+// at uneven intervals and clients' interpolation stutters.
+func validateRoomConfig(where string, rc game.RoomConfig) []error {
+	if err := rc.Validate(); err != nil {
+		return []error{fmt.Errorf("%s: %v", where, err)}
+	}
+	if rc.PhysicsTickRate%rc.BroadcastRate != 0 {
+		divisor := rc.BroadcastRate
+		for rc.PhysicsTickRate%divisor != 0 {
+			divisor--
+		}
+		return []error{fmt.Errorf("%s: broadcast rate %d Hz doesn't divide physics rate %d Hz, so state updates would leave at uneven intervals (try %d Hz)",
+			where, rc.BroadcastRate, rc.PhysicsTickRate, divisor)}
+	}
+	return nil
+}
+
+// selfTest exercises the store, the built-in track and the simulation.
+func (s *GameServer) selfTest() []error {
+	var problems []error
+	began := time.Now()
+
+	if err := s.testStore(); err != nil {
+		problems = append(problems, fmt.Errorf("STORE_URL: %s store: %v", s.config.StoreBackend, err))
+	}
+
+	// Validate normalizes, so check a copy
+	road := *track.Default()
+	road.Curves = append([]track.Curve(nil), road.Curves...)
+	road.Elevation = append([]track.Curve(nil), road.Elevation...)
+	road.Ramps = append([]track.Ramp(nil), road.Ramps...)
+	if err := road.Validate(); err != nil {
+		problems = append(problems, fmt.Errorf("built-in track: %v (check the Road* and Track* constants)", err))
+	}
+
+	// Every distinct room config in use, defaults first
+	tested := make(map[game.RoomConfig]bool)
+	keys := make([]string, 0, len(s.tenants))
+	for key := range s.tenants {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		rc := s.tenants[key].matchmaker.RoomConfig()
+		if tested[rc] {
+			continue
+		}
+		tested[rc] = true
+		if err := s.simulateRoom(rc); err != nil {
+			problems = append(problems, fmt.Errorf("simulation at %d Hz: %v", rc.PhysicsTickRate, err))
+		}
+	}
+
+	if len(problems) == 0 {
+		log.Printf("Self-test passed in %v", time.Since(began).Round(time.Millisecond))
+	}
+	return problems
+}
+
+// testStore writes, reads back and deletes a key.
+func (s *GameServer) testStore() error {
+	ctx, cancel := context.WithTimeout(context.Background(), config.SelfTestStoreTimeout)
+	defer cancel()
+
+	key := "selftest:" + s.config.InstanceID
+	value := []byte(time.Now().UTC().Format(time.RFC3339Nano))
+	if err := s.store.Set(ctx, key, value, time.Minute); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	read, ok, err := s.store.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+	if !ok || !bytes.Equal(read, value) {
+		return fmt.Errorf("read back %q, wrote %q", read, value)
+	}
+	if err := s.store.Delete(ctx, key); err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+	return nil
+}
+
+// simulateRoom steps a full room of bots through config.SelfTestSimulation
+// of game time, as fast as it goes. The room must keep up with real time,
+// no bot may be kicked, and every car must stay on finite coordinates with
+// at least one of them moving.
+func (s *GameServer) simulateRoom(rc game.RoomConfig) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	// Joins and explosions of the bots aren't worth logging
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+
+	room := game.NewRoomWithConfig("selftest", rc)
+	for _, rule := range s.antiCheat {
+		room.AddAntiCheatRule(rule)
+	}
+	var kicks []string
+	room.SetOnPlayerKick(func(p *game.Player, reason string) {
+		kicks = append(kicks, fmt.Sprintf("%s: %s", p.Name, reason))
+	})
+
+	names := make([]string, 0, len(s.botProfiles))
+	for name := range s.botProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return fmt.Errorf("no bot profiles")
+	}
+	bots := make([]*game.Player, 0, config.MaxPlayersPerRoom)
+	starts := make([]float64, 0, config.MaxPlayersPerRoom)
+	for i := 0; i < config.MaxPlayersPerRoom; i++ {
+		bot, err := room.AddBot(s.botProfiles[names[i%len(names)]])
+		if err != nil {
+			return fmt.Errorf("adding bot: %w", err)
+		}
+		bots = append(bots, bot)
+		starts = append(starts, bot.GetState().Y)
+	}
+
+	dt := 1 / float64(rc.PhysicsTickRate)
+	ticks := int(config.SelfTestSimulation.Seconds() * float64(rc.PhysicsTickRate))
+	broadcastEvery := rc.PhysicsTickRate / rc.BroadcastRate
+	began := time.Now()
+	for tick := 0; tick < ticks; tick++ {
+		room.Step(dt, tick%broadcastEvery == 0)
+	}
+	if took := time.Since(began); took > config.SelfTestSimulation {
+		return fmt.Errorf("%v of a full room took %v to simulate; give the server more CPU or lower the physics rate", config.SelfTestSimulation, took.Round(time.Millisecond))
+	}
+
+	if len(kicks) > 0 {
+		return fmt.Errorf("anti-cheat kicked %d bots (%s); its limits don't fit this physics rate", len(kicks), kicks[0])
+	}
+	moved := false
+	for i, bot := range bots {
+		state := bot.GetState()
+		for _, v := range []float64{state.X, state.Y, state.Speed, state.Angle} {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return fmt.Errorf("%s left the number line (x=%v, y=%v, speed=%v)", state.Name, state.X, state.Y, state.Speed)
+			}
+		}
+		if state.Y != starts[i] {
+			moved = true
+		}
+	}
+	if !moved {
+		return fmt.Errorf("no car moved")
+	}
+	return nil
+}
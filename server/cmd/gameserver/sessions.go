@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/race/server/internal/network"
+)
+
+// Sessions
+//
+// A connection plays one player at a time: a JoinRoom while it is in a room
+// is refused (error code 7) instead of adding a second car that nobody
+// would ever remove, and the player must LeaveRoom first. A join waiting in
+// the queue is not repeated either.
+//
+// An account plays on one connection of the server at a time. A connection
+// becomes the account's session when it links the account (see
+// accounts.go), until it closes or links another one. What happens when the
+// account links on a second connection is up to SESSION_POLICY:
+//
+//   kick    the first connection leaves its room, the join queue or the
+//           room it watches, and gets an error with code 3 ("Logged in
+//           elsewhere"); it stays open as a guest's. The default.
+//   reject  the new link is refused (error code 7) without spending its
+//           token, which works once the first connection has closed
+//
+// Guests have no account to tell devices apart by, so any number of them
+// may play under the same name. Other servers of a cluster keep sessions of
+// their own.
+
+// Session policies (SESSION_POLICY)
+const (
+	sessionPolicyKick   = "kick"
+	sessionPolicyReject = "reject"
+)
+
+// errAlreadyJoined refuses a second join on a connection
+var errAlreadyJoined = errors.New("already in a room: leave it first")
+
+// accountSessions tracks the connection of every linked account
+type accountSessions struct {
+	mu        sync.Mutex
+	byAccount map[string]*ClientConnection
+}
+
+// holder returns the connection holding the account's session (nil if
+// none)
+func (a *accountSessions) holder(account string) *ClientConnection {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.byAccount[account]
+}
+
+// take makes c the account's session and returns the connection that held
+// it before (nil if none, or c)
+func (a *accountSessions) take(account string, c *ClientConnection) *ClientConnection {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.byAccount == nil {
+		a.byAccount = make(map[string]*ClientConnection)
+	}
+	previous := a.byAccount[account]
+	a.byAccount[account] = c
+	if previous == c {
+		return nil
+	}
+	return previous
+}
+
+// release ends c's session of the account, if it holds it
+func (a *accountSessions) release(account string, c *ClientConnection) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if account != "" && a.byAccount[account] == c {
+		delete(a.byAccount, account)
+	}
+}
+
+func (a *accountSessions) count() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.byAccount)
+}
+
+// sessionRefused reports whether the policy refuses c a session of the
+// account
+func (s *GameServer) sessionRefused(c *ClientConnection, account string) bool {
+	if s.config.SessionPolicy != sessionPolicyReject {
+		return false
+	}
+	holder := s.sessions.holder(account)
+	return holder != nil && holder != c
+}
+
+// kickSession ends an account's session on a connection it was taken from:
+// the connection becomes a guest's and leaves whatever it was in
+func (s *GameServer) kickSession(c *ClientConnection, account string) {
+	c.mu.Lock()
+	if c.account == account {
+		c.account = ""
+	}
+	c.mu.Unlock()
+
+	c.leave()
+	c.Send(s.protocol.EncodeError(network.ErrorCodeKicked, "Logged in elsewhere"))
+	s.metrics.sessionsReplaced.Add(1)
+	log.Printf("Ended the session of account '%s' on %s: logged in elsewhere", account, c.info)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log"
+
+	"github.com/race/server/internal/game"
+	"github.com/race/server/internal/network"
+)
+
+// Open spectating
+//
+// A client (protocol v32) watches a public room of its tenant with a
+// Spectate message of the room's ID, without a car: the room takes it as a
+// viewer (see game/spectate.go), which gets the room's info with player ID
+// 0 and then what spectators get, with state updates at a reduced rate.
+// Viewers send no input; a Leave message stops watching, and joining a
+// room as a player or watching another one stops it too. The room must be
+// on this server (GET /route?room=<id> finds it), and private rooms are
+// only for those with their code: to a viewer they don't exist. Broadcast
+// clients with a spectate token watch their token's room instead.
+
+// handleSpectate makes the connection a viewer of a room.
+func (c *ClientConnection) handleSpectate(m *message) {
+	msg, err := c.server.protocol.DecodeSpectate(m.data)
+	if err != nil {
+		log.Printf("Invalid spectate message from %s: %v", c.info, err)
+		return
+	}
+
+	if c.spectate != "" {
+		c.watch()
+		return
+	}
+
+	room := c.tenant.matchmaker.GetRoom(msg.RoomID)
+	if room == nil || c.tenant.matchmaker.RoomCode(room.ID) != "" {
+		c.Send(c.server.protocol.EncodeError(network.ErrorCodeRoomFull, "Room not found"))
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || c.watched == room {
+		return
+	}
+	if err := room.AddViewer(c); err != nil {
+		code := network.ErrorCodeRoomFull
+		if err == game.ErrNotWatchable {
+			code = network.ErrorCodeNotAllowed
+		}
+		c.Send(c.server.protocol.EncodeError(code, err.Error()))
+		return
+	}
+	if c.watched != nil {
+		c.watched.RemoveSpectator(c)
+	}
+	c.watched = room
+	log.Printf("%s watching room %s", c.info, room.ID)
+}
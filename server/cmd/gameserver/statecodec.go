@@ -0,0 +1,26 @@
+package main
+
+// State codecs
+//
+// State updates are most of what the server sends. STATE_CODEC chooses how
+// they go on the wire, among the codecs clients can decode today:
+//
+//   records  the records of the client's protocol version: every car in
+//            every update before ProtocolV3, dead reckoning records from
+//            it on. The default.
+//   deflate  the same, compressed with permessage-deflate (no context
+//            takeover) for clients that offer it, as browsers do. Costs
+//            CPU on the write pumps, and saves little on small updates;
+//            every message of such a connection is compressed, not only
+//            state updates.
+//
+// cmd/codecbench measures these and candidates that would need client
+// support (bit-packed records, preset dictionaries) on recorded gameplay,
+// so the choice, and whether a new codec is worth a protocol version, can
+// follow the numbers.
+
+// State codecs (STATE_CODEC)
+const (
+	stateCodecRecords = "records"
+	stateCodecDeflate = "deflate"
+)
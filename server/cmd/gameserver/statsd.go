@@ -0,0 +1,119 @@
+package main
+
+import (
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/race/server/config"
+)
+
+// Statsd push
+//
+// Where nothing scrapes /stats, the server can push its metrics (see
+// metrics.go) to a statsd agent instead: with STATSD_ADDR set, every
+// STATSD_INTERVAL (10s) it sends them over UDP as <prefix>.<name>, counters
+// as the increment since the last push (|c) and gauges as they are (|g).
+// STATSD_TAGS, comma separated, are added to every metric in DogStatsD's
+// |#tag,... extension; leave them out for a plain statsd agent. Pushes
+// pause while the server hibernates, and a lost datagram only loses its
+// metrics' values until the next push, as statsd has it.
+
+// statsdPacketSize bounds a datagram so it isn't fragmented on the way
+const statsdPacketSize = 1432
+
+// statsdEmitter is the sink that pushes to a statsd agent
+type statsdEmitter struct {
+	conn    net.Conn
+	prefix  string
+	suffix  string            // Tags of every metric, with their "|#"
+	last    map[string]uint64 // Counters at the last push
+	packet  []byte
+	failing bool // The last write failed (logged once until one works)
+}
+
+func newStatsdEmitter(cfg *config.ServerConfig) (*statsdEmitter, error) {
+	conn, err := net.Dial("udp", cfg.StatsdAddr)
+	if err != nil {
+		return nil, err
+	}
+	e := &statsdEmitter{conn: conn, last: make(map[string]uint64)}
+	if cfg.StatsdPrefix != "" {
+		e.prefix = cfg.StatsdPrefix + "."
+	}
+	if len(cfg.StatsdTags) > 0 {
+		e.suffix = "|#" + strings.Join(cfg.StatsdTags, ",")
+	}
+	return e, nil
+}
+
+// Counter implements metricsSink: the increment since the last push, if any
+func (e *statsdEmitter) Counter(name string, total uint64) {
+	last := e.last[name]
+	e.last[name] = total
+	if total <= last {
+		return
+	}
+	e.add(name, strconv.FormatUint(total-last, 10), "|c")
+}
+
+// Gauge implements metricsSink
+func (e *statsdEmitter) Gauge(name string, value float64) {
+	e.add(name, strconv.FormatFloat(value, 'f', -1, 64), "|g")
+}
+
+// add appends a metric to the packet, sending the packet first if it
+// would no longer fit
+func (e *statsdEmitter) add(name, value, kind string) {
+	size := len(e.prefix) + len(name) + 1 + len(value) + len(kind) + len(e.suffix)
+	if len(e.packet) > 0 && len(e.packet)+1+size > statsdPacketSize {
+		e.flush()
+	}
+	if len(e.packet) > 0 {
+		e.packet = append(e.packet, '\n')
+	}
+	e.packet = append(e.packet, e.prefix...)
+	e.packet = append(e.packet, name...)
+	e.packet = append(e.packet, ':')
+	e.packet = append(e.packet, value...)
+	e.packet = append(e.packet, kind...)
+	e.packet = append(e.packet, e.suffix...)
+}
+
+// flush sends the packet
+func (e *statsdEmitter) flush() {
+	if len(e.packet) == 0 {
+		return
+	}
+	_, err := e.conn.Write(e.packet)
+	e.packet = e.packet[:0]
+	switch {
+	case err != nil && !e.failing:
+		log.Printf("Failed to push metrics to statsd at %s: %v", e.conn.RemoteAddr(), err)
+		e.failing = true
+	case err == nil && e.failing:
+		log.Printf("Pushing metrics to statsd at %s again", e.conn.RemoteAddr())
+		e.failing = false
+	}
+}
+
+// pushStatsd pushes the metrics to the statsd agent until shutdown
+func (s *GameServer) pushStatsd() {
+	e, err := newStatsdEmitter(s.config)
+	if err != nil {
+		log.Printf("Not pushing metrics to statsd: %v", err)
+		return
+	}
+	defer e.conn.Close()
+	log.Printf("Pushing metrics to statsd at %s every %v", s.config.StatsdAddr, s.config.StatsdInterval)
+
+	ticker := time.NewTicker(s.config.StatsdInterval)
+	defer ticker.Stop()
+
+	for s.wait(ticker, s.config.StatsdInterval) {
+		s.reportMetrics(e, s.roomStats())
+		e.flush()
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"math"
+	mathrand "math/rand"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/game"
+	"github.com/race/server/internal/network"
+)
+
+// Client telemetry
+//
+// A player on protocol v33 is probed for telemetry at random, every
+// config.TelemetryIntervalMin to config.TelemetryIntervalMax, so a cheat
+// can't tell when the next probe comes. A Probe carries a random nonce and
+// the number of frames wanted (config.TelemetryFrames); the client answers
+// with a Telemetry message of the nonce, its input device class and the
+// times of its last frames, signed with the HMAC-SHA256 of the key built
+// into the official client (TELEMETRY_KEY, see network.TelemetryMAC). A
+// nonce is good for one answer.
+//
+// An answer that doesn't come within config.TelemetryTimeout is missing;
+// one with the wrong nonce or signature, an unknown device class, too few
+// frames, or frames too fast or too regular for a real client is
+// implausible. Either adds weight to the connection's suspicion, kept with
+// its fingerprint (see fingerprints.go), and the player's anti-cheat kicks
+// them after that many fewer violations (see game/anticheat.go). Plausible
+// answers take weight off again. Telemetry alone never kicks anyone.
+// Probes go out and time out as the player's inputs come in, so a player
+// who doesn't drive isn't probed. CLIENT_TELEMETRY=false turns it off;
+// /stats counts the probes (probesSent) and the answers that were missing
+// (probesMissed) or implausible (probesImplausible).
+
+// telemetryDevices names the input device classes of Telemetry messages
+var telemetryDevices = [network.TelemetryDeviceCount]string{"unknown", "keyboard", "touch", "gamepad"}
+
+// sampleTelemetry probes the player when a probe is due, and counts the
+// one awaiting an answer as missing once it is late. Called from readPump
+// on the player's inputs.
+func (c *ClientConnection) sampleTelemetry(player *game.Player, now time.Time) {
+	if !c.server.config.ClientTelemetry || c.ProtocolVersion() < network.ProtocolV33 {
+		return
+	}
+	if c.probe != nil && c.probedPlayer != player {
+		c.probe = nil // Went to the player of a room the connection left
+	}
+	if c.probe != nil {
+		if now.Sub(c.probed) > config.TelemetryTimeout {
+			c.probe = nil
+			c.server.metrics.probesMissed.Add(1)
+			c.suspect(player, config.TelemetryMissingWeight, "no answer")
+		}
+		return
+	}
+	if !c.nextProbe.IsZero() && now.Before(c.nextProbe) {
+		return
+	}
+
+	due := c.nextProbe
+	c.nextProbe = now.Add(config.TelemetryIntervalMin +
+		time.Duration(mathrand.Int63n(int64(config.TelemetryIntervalMax-config.TelemetryIntervalMin))))
+	if due.IsZero() {
+		return // First input: the first probe comes later
+	}
+	c.probe = make([]byte, network.TelemetryNonceLen)
+	rand.Read(c.probe)
+	c.probed, c.probedPlayer = now, player
+	c.server.metrics.probesSent.Add(1)
+	c.Send(c.server.protocol.EncodeProbe(c.probe, config.TelemetryFrames))
+}
+
+// handleTelemetry checks the answer to a probe. Answers nobody asked for,
+// or that came too late, are ignored.
+func (c *ClientConnection) handleTelemetry(m *message) {
+	msg, err := c.server.protocol.DecodeTelemetry(m.data)
+	if err != nil {
+		log.Printf("Invalid telemetry message from %s: %v", c.info, err)
+		return
+	}
+	nonce := c.probe
+	if nonce == nil || c.probedPlayer != m.player {
+		return
+	}
+	c.probe = nil
+
+	if problem := c.implausibility(msg, nonce); problem != "" {
+		c.server.metrics.probesImplausible.Add(1)
+		c.suspect(m.player, config.TelemetryImplausibleWeight, problem)
+		return
+	}
+	mean, jitter := frameStats(msg.Frames)
+	suspicion := c.fingerprint.Telemetry(telemetryDevices[msg.Device], mean, jitter, config.TelemetryMissingWeight)
+	m.player.SetSuspicion(suspicion)
+}
+
+// implausibility returns what gives a telemetry sample away as forged or
+// made up ("" if nothing does)
+func (c *ClientConnection) implausibility(msg *network.TelemetryMessage, nonce []byte) string {
+	mac := network.TelemetryMAC([]byte(c.server.config.TelemetryKey), msg.Nonce[:], msg.Device, msg.Frames)
+	switch {
+	case !hmac.Equal(msg.Nonce[:], nonce):
+		return "wrong nonce"
+	case !hmac.Equal(msg.MAC[:], mac):
+		return "bad signature"
+	case msg.Device >= network.TelemetryDeviceCount:
+		return fmt.Sprintf("unknown input device %d", msg.Device)
+	case len(msg.Frames) < config.TelemetryFrames:
+		return fmt.Sprintf("%d frames of %d", len(msg.Frames), config.TelemetryFrames)
+	}
+
+	// A long frame is the page in the background; a short one is no browser
+	for _, f := range msg.Frames {
+		if ms := float64(f) / 10; ms < config.TelemetryFrameMinMS {
+			return fmt.Sprintf("frame of %.1fms", ms)
+		}
+	}
+	if _, jitter := frameStats(msg.Frames); jitter < config.TelemetryFrameJitterMinMS {
+		return "frames too regular"
+	}
+	return ""
+}
+
+// suspect adds weight to the suspicion of the connection, and so of its
+// player
+func (c *ClientConnection) suspect(player *game.Player, weight float64, problem string) {
+	suspicion := c.fingerprint.Suspect(weight, config.TelemetrySuspicionMax)
+	player.SetSuspicion(suspicion)
+	log.Printf("Telemetry of player %s (%s): %s, suspicion %.1f", player.GetName(), c.info, problem, suspicion)
+}
+
+// frameStats returns the mean and standard deviation of frame times in
+// milliseconds, from tenths of a millisecond
+func frameStats(frames []uint16) (mean, jitter float64) {
+	if len(frames) == 0 {
+		return 0, 0
+	}
+	var sum, sq float64
+	for _, f := range frames {
+		ms := float64(f) / 10
+		sum += ms
+		sq += ms * ms
+	}
+	n := float64(len(frames))
+	mean = sum / n
+	return mean, math.Sqrt(math.Max(0, sq/n-mean*mean))
+}
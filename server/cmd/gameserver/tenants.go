@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/game"
+	"github.com/race/server/internal/leaderboard"
+	"github.com/race/server/internal/matchmaker"
+	"github.com/race/server/internal/storage"
+)
+
+// Tenants
+//
+// One server process can host several games side by side: staging next to
+// production, or white-label deployments with their own branding. Each
+// tenant has its own matchmaking pool (rooms, practice rooms, join queue),
+// room config and leaderboard, so players of one tenant never meet or rank
+// against players of another. Clients pick their tenant in the WebSocket
+// handshake (/ws?tenant=<key>) and the public leaderboard API takes the same
+// parameter; without it they get the default tenant, which is configured
+// through the environment as before. Further tenants come from
+// TENANTS_FILE, a JSON array like
+//
+//	[{"key": "staging", "name": "Vector Racer (staging)", "broadcastRate": 30}]
+//
+// A tenant is also the template of its public rooms: with "rules": "tag"
+// they run the tag script of RULES_DIR (see internal/rules). Room config
+// fields left out take the server's defaults. Player profiles,
+// match history and moderation are shared: players are identified by name
+// across tenants. /stats reports totals plus a breakdown per tenant.
+
+// tenantKeyPattern restricts keys to what fits in URLs and store keys
+var tenantKeyPattern = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+
+// tenantConfig is an entry of TENANTS_FILE
+type tenantConfig struct {
+	Key  string `json:"key"`
+	Name string `json:"name"` // Display name (branding)
+	game.RoomConfig
+}
+
+// tenant is an isolated game on this server
+type tenant struct {
+	key         string // "" for the default tenant
+	name        string
+	matchmaker  *matchmaker.Matchmaker
+	queue       *matchmaker.JoinQueue
+	leaderboard *leaderboard.Board
+}
+
+// loadTenants reads and validates a tenants file
+func loadTenants(path string) ([]tenantConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tenants []tenantConfig
+	if err := json.Unmarshal(data, &tenants); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(tenants))
+	for _, t := range tenants {
+		if !tenantKeyPattern.MatchString(t.Key) {
+			return nil, fmt.Errorf("invalid tenant key %q (lowercase letters, digits and dashes)", t.Key)
+		}
+		if t.Key == "default" {
+			return nil, fmt.Errorf("tenant key %q is reserved for the default tenant", t.Key)
+		}
+		if seen[t.Key] {
+			return nil, fmt.Errorf("duplicate tenant key %q", t.Key)
+		}
+		seen[t.Key] = true
+	}
+	return tenants, nil
+}
+
+// newTenant creates a tenant's matchmaking pool and leaderboard.
+// Zero fields of roomConfig take the server's defaults.
+func (s *GameServer) newTenant(key, name string, roomConfig game.RoomConfig) *tenant {
+	t := &tenant{
+		key:         key,
+		name:        name,
+		matchmaker:  matchmaker.NewMatchmaker(),
+		queue:       matchmaker.NewJoinQueue(s.config.JoinQueueLength, s.config.JoinQueueTimeout),
+		leaderboard: s.newLeaderboard(key),
+	}
+
+	t.matchmaker.SetRulesLibrary(s.rules)
+	t.matchmaker.SetScoringPolicy(s.scoring)
+	t.matchmaker.SetAntiCheatRules(s.antiCheat)
+	if err := t.matchmaker.SetRoomConfig(tenantRoomConfig(s.config, roomConfig)); err != nil {
+		log.Fatalf("Invalid room config of tenant %q: %v", key, err)
+	}
+
+	sequenceMode, err := game.ParseSequenceMode(s.config.InputSequenceMode)
+	if err != nil {
+		log.Fatalf("Invalid INPUT_SEQUENCE_MODE: %v", err)
+	}
+	t.matchmaker.SetSequenceMode(sequenceMode)
+
+	// Every anti-cheat kick starts (or escalates) a rejoin cooldown for the address
+	t.matchmaker.SetOnPlayerKick(s.onPlayerKick)
+	if s.config.AntiCheatPolicy == policyHoneypot {
+		t.matchmaker.SetOnCheater(s.divertCheater)
+	}
+	t.matchmaker.SetOnRunEnd(t.onRunEnd)
+	t.matchmaker.SetOnRoundEnd(s.onRoundEnd)
+	t.matchmaker.SetOnPrestige(s.onPrestige)
+	t.matchmaker.SetOnQuarantine(func(report game.TickBudgetReport) { s.onQuarantine(t, report) })
+	t.matchmaker.SetSuspendEmptyRooms(s.config.SuspendEmptyRooms)
+	t.matchmaker.SetScheduler(s.scheduler)
+	t.matchmaker.SetRoomIDs(s.roomRegistry.NewRoomID)
+	return t
+}
+
+// tenantRoomConfig fills the zero fields of a tenant's room config with the
+// server's defaults
+func tenantRoomConfig(cfg *config.ServerConfig, roomConfig game.RoomConfig) game.RoomConfig {
+	if roomConfig.PhysicsTickRate == 0 {
+		roomConfig.PhysicsTickRate = cfg.PhysicsTickRate
+	}
+	if roomConfig.BroadcastRate == 0 {
+		roomConfig.BroadcastRate = cfg.BroadcastRate
+	}
+	if roomConfig.RebaseDistance == 0 {
+		roomConfig.RebaseDistance = cfg.RebaseDistance
+	}
+	if roomConfig.Rules == "" {
+		roomConfig.Rules = cfg.RoomRules
+	}
+	if roomConfig.SpectatorDelay == 0 {
+		roomConfig.SpectatorDelay = cfg.SpectatorDelay
+	}
+	if !roomConfig.Fuel {
+		roomConfig.Fuel = cfg.FuelMode
+	}
+	if !roomConfig.Relay {
+		roomConfig.Relay = cfg.RelayMode
+	}
+	if !roomConfig.Race {
+		roomConfig.Race = cfg.RaceMode
+	}
+	if roomConfig.Collisions == "" {
+		roomConfig.Collisions = cfg.Collisions
+	}
+	return roomConfig
+}
+
+// newLeaderboard creates the leaderboard of a tenant. Seasons live in the
+// shared store, saved through the outbox (see outbox.go); a standalone
+// server archives them to the data directory instead so they survive
+// restarts.
+func (s *GameServer) newLeaderboard(tenant string) *leaderboard.Board {
+	var archive leaderboard.Archive
+	if s.config.StoreBackend == storage.BackendMemory {
+		dir := filepath.Join(s.config.DataDir, "leaderboard")
+		if tenant != "" {
+			dir = filepath.Join(s.config.DataDir, "leaderboard-"+tenant)
+		}
+		fileArchive, err := leaderboard.NewFileArchive(dir)
+		if err != nil {
+			log.Fatalf("Failed to open leaderboard archive: %v", err)
+		}
+		archive = fileArchive
+	} else {
+		if s.archives == nil {
+			s.archives = make(map[string]leaderboard.Archive)
+		}
+		s.archives[tenant] = leaderboard.NewStoreArchiveNamespace(s.store, tenant)
+		archive = &outboxArchive{Archive: s.archives[tenant], box: s.outbox, tenant: tenant}
+	}
+
+	board := leaderboard.NewBoard(config.LeaderboardSeasonLength, time.Unix(0, 0).UTC(), archive)
+	board.OnRewards = func(season leaderboard.Season, rewards []leaderboard.Reward) {
+		s.onSeasonRewards(tenant, season, rewards)
+	}
+	board.Signer, board.Tenant = s.signer, tenant
+	return board
+}
+
+// onRunEnd submits a finished run to the tenant's leaderboard.
+func (t *tenant) onRunEnd(player *game.Player, score float64) {
+	t.leaderboard.Submit(player.GetName(), score)
+}
+
+// tenantOf returns the tenant a request names with ?tenant= (the default
+// tenant without it), or nil if there is no such tenant
+func (s *GameServer) tenantOf(r *http.Request) *tenant {
+	return s.tenants[r.URL.Query().Get("tenant")]
+}
+
+// findRoom looks a room up in every tenant
+func (s *GameServer) findRoom(roomID string) (*tenant, *game.Room) {
+	for _, t := range s.tenants {
+		if room := t.matchmaker.GetRoom(roomID); room != nil {
+			return t, room
+		}
+	}
+	return nil, nil
+}
+
+// stopRooms stops every room of every tenant
+func (s *GameServer) stopRooms() {
+	for _, t := range s.tenants {
+		t.matchmaker.StopAll()
+	}
+}
+
+// tickLeaderboards persists the leaderboards and rolls their seasons over
+func (s *GameServer) tickLeaderboards() {
+	now := time.Now()
+	for _, t := range s.tenants {
+		t.leaderboard.Tick(now)
+	}
+}
+
+// reservedRooms returns the number of rooms waiting for the players of a match
+func (s *GameServer) reservedRooms() int {
+	now := time.Now()
+	n := 0
+	for _, t := range s.tenants {
+		n += t.matchmaker.ReservedRooms(now)
+	}
+	return n
+}
+
+// roomStats returns the matchmaking stats of all tenants combined
+func (s *GameServer) roomStats() matchmaker.MatchmakerStats {
+	var stats matchmaker.MatchmakerStats
+	for _, t := range s.tenants {
+		stats.Add(t.matchmaker.GetStats())
+	}
+	return stats
+}
+
+// tenantStats reports each tenant's share of the server for /stats
+func (s *GameServer) tenantStats() map[string]interface{} {
+	tenants := make(map[string]interface{}, len(s.tenants))
+	for key, t := range s.tenants {
+		stats := t.matchmaker.GetStats()
+		if key == "" {
+			key = "default"
+		}
+		tenants[key] = map[string]interface{}{
+			"name":               t.name,
+			"rooms":              stats.TotalRooms,
+			"players":            stats.TotalPlayers,
+			"practiceRooms":      stats.PracticeRooms,
+			"queuedJoins":        t.queue.Len(),
+			"leaderboardEntries": t.leaderboard.Len(),
+		}
+	}
+	return tenants
+}
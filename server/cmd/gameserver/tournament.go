@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"log"
+	"sync"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/network"
+)
+
+// Tournament rooms
+//
+// A match allocated with an eventSecret (see allocation.go) is a tournament
+// room: a ticket for it is not enough to race there, so a leaked ticket or
+// room code doesn't let anyone in. The organizers hand the event's secret
+// to the registered participants out of band. Right after HelloAck, a
+// protocol v26 connection holding a ticket for a tournament room gets a
+// Challenge with a random nonce, and answers with EventAuth: the
+// HMAC-SHA256 of the nonce keyed with the secret (network.EventAuthMAC).
+// The secret itself never crosses the wire, and a nonce is good for one
+// answer, so a captured answer can't be replayed. The room refuses the
+// joins of connections that haven't answered, and older clients can't join
+// it. A wrong answer gets an error and a new challenge, up to
+// config.EventAuthMaxFailures per connection. Secrets are only kept in
+// memory, for as long as their room exists.
+
+// eventSecrets holds the secrets of the tournament rooms by room ID
+type eventSecrets struct {
+	mu     sync.Mutex
+	byRoom map[string][]byte
+}
+
+// set makes a room a tournament room of an event
+func (e *eventSecrets) set(roomID string, secret []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.byRoom == nil {
+		e.byRoom = make(map[string][]byte)
+	}
+	e.byRoom[roomID] = secret
+}
+
+// get returns the event secret of a room, or nil if it isn't a tournament
+// room
+func (e *eventSecrets) get(roomID string) []byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.byRoom[roomID]
+}
+
+// sweep forgets the secrets of rooms that no longer exist
+func (e *eventSecrets) sweep(exists func(roomID string) bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for id := range e.byRoom {
+		if !exists(id) {
+			delete(e.byRoom, id)
+		}
+	}
+}
+
+// challenge sends a new challenge to a connection holding a ticket for a
+// tournament room, unless it has proved the secret or failed too often.
+// Called from readPump.
+func (c *ClientConnection) challenge() {
+	if c.reserved == "" || c.eventAuthed || c.ProtocolVersion() < network.ProtocolV26 ||
+		c.authFailures >= config.EventAuthMaxFailures || c.server.events.get(c.reserved) == nil {
+		return
+	}
+	c.nonce = make([]byte, network.EventNonceLen)
+	rand.Read(c.nonce)
+	c.Send(c.server.protocol.EncodeChallenge(c.nonce))
+}
+
+// handleEventAuth checks the answer to a tournament challenge
+func (c *ClientConnection) handleEventAuth(m *message) {
+	msg, err := c.server.protocol.DecodeEventAuth(m.data)
+	if err != nil || c.nonce == nil {
+		return
+	}
+	secret := c.server.events.get(c.reserved)
+	if secret == nil {
+		return
+	}
+	nonce := c.nonce
+	c.nonce = nil
+	if hmac.Equal(msg.MAC[:], network.EventAuthMAC(secret, nonce)) {
+		c.eventAuthed = true
+		log.Printf("%s proved the event secret of room %s", c.info, c.reserved)
+		return
+	}
+
+	c.authFailures++
+	log.Printf("%s failed the event challenge of room %s (%d)", c.info, c.reserved, c.authFailures)
+	c.Send(c.server.protocol.EncodeError(network.ErrorCodeNotAllowed, "Wrong event secret"))
+	c.challenge()
+}
+
+// eventRefusal returns why the connection can't join its reserved room
+// yet, or "" if it can
+func (c *ClientConnection) eventRefusal() (code uint8, reason string) {
+	if c.eventAuthed || c.server.events.get(c.reserved) == nil {
+		return 0, ""
+	}
+	if c.ProtocolVersion() < network.ProtocolV26 {
+		return network.ErrorCodeUnsupportedVersion, "Tournament rooms need protocol v26"
+	}
+	return network.ErrorCodeNotAllowed, "Event secret required"
+}
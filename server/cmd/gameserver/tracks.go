@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/network"
+	"github.com/race/server/internal/track"
+)
+
+// Custom tracks
+//
+// The map editor submits track definitions through the public API:
+//   GET  /api/tracks                 - latest version of each track (?limit=N)
+//   POST /api/tracks                 - create a track; returns it with its edit key
+//   GET  /api/tracks/{id}            - all versions of a track, newest first
+//   POST /api/tracks/{id}            - add a version ("X-Edit-Key: <key>")
+//   GET  /api/tracks/{id}/{version}  - a single version
+//
+// Any version can be driven in a practice room. Operators approve versions
+// for public matchmaking and pick the public track through the admin API.
+//
+// Submitting needs no account, so each client address gets a token bucket
+// of submissions (config.TrackSubmitRate), and no track is created while
+// config.TrackUnapprovedMax await approval; either answers 429.
+
+// submitLimiter holds the submission buckets of client addresses
+type submitLimiter struct {
+	mu    sync.Mutex
+	addrs map[string]*network.RateLimiter
+}
+
+func newSubmitLimiter() *submitLimiter {
+	return &submitLimiter{addrs: make(map[string]*network.RateLimiter)}
+}
+
+// allow takes a submission from addr's bucket
+func (l *submitLimiter) allow(addr string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limiter, ok := l.addrs[addr]
+	if !ok {
+		limiter = network.NewRateLimiter(config.TrackSubmitRate, config.TrackSubmitBurst)
+		l.addrs[addr] = limiter
+	}
+	return limiter.Allow(time.Now())
+}
+
+// sweep forgets the addresses whose buckets have refilled
+func (l *submitLimiter) sweep() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for addr, limiter := range l.addrs {
+		if limiter.Full(now) {
+			delete(l.addrs, addr)
+		}
+	}
+}
+
+// allowSubmit applies the submission limit of the request's client; on
+// refusal it has answered.
+func (s *GameServer) allowSubmit(w http.ResponseWriter, r *http.Request) bool {
+	if s.submits.allow(s.clientIP(r)) {
+		return true
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(1/config.TrackSubmitRate))))
+	http.Error(w, "too many track submissions, try again later", http.StatusTooManyRequests)
+	return false
+}
+
+// handleTracks lists (GET) or creates (POST) tracks.
+func (s *GameServer) handleTracks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		limit := 100
+		if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= 1000 {
+			limit = l
+		}
+		tracks, err := s.tracks.List(limit)
+		if err != nil {
+			log.Printf("Failed to list tracks: %v", err)
+			http.Error(w, "failed to list tracks", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, tracks)
+
+	case http.MethodPost:
+		if !s.allowSubmit(w, r) {
+			return
+		}
+		t, ok := decodeTrack(w, r)
+		if !ok {
+			return
+		}
+		created, key, err := s.tracks.Create(t)
+		if err != nil {
+			writeTrackError(w, err)
+			return
+		}
+		log.Printf("Track %s (%q) created", created.Label(), created.Name)
+		writeJSON(w, http.StatusCreated, map[string]interface{}{
+			"track":   created,
+			"editKey": key,
+		})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleTrack routes /api/tracks/{id} and /api/tracks/{id}/{version}.
+func (s *GameServer) handleTrack(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/tracks/"), "/"), "/")
+	id := parts[0]
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		versions, err := s.tracks.Versions(id)
+		if err != nil {
+			writeTrackError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, versions)
+
+	case len(parts) == 1 && r.Method == http.MethodPost:
+		if !s.allowSubmit(w, r) {
+			return
+		}
+		t, ok := decodeTrack(w, r)
+		if !ok {
+			return
+		}
+		added, err := s.tracks.AddVersion(id, r.Header.Get("X-Edit-Key"), t)
+		if err != nil {
+			writeTrackError(w, err)
+			return
+		}
+		log.Printf("Track %s (%q) created", added.Label(), added.Name)
+		writeJSON(w, http.StatusCreated, added)
+
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		version, err := strconv.Atoi(parts[1])
+		if err != nil || version <= 0 {
+			http.Error(w, "invalid version", http.StatusBadRequest)
+			return
+		}
+		t, err := s.tracks.Get(id, version)
+		if err != nil {
+			writeTrackError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, t)
+
+	case len(parts) <= 2:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// decodeTrack reads a submitted definition; on failure it has answered.
+func decodeTrack(w http.ResponseWriter, r *http.Request) (track.Track, bool) {
+	var t track.Track
+	r.Body = http.MaxBytesReader(w, r.Body, config.TrackBodyMax)
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		http.Error(w, "invalid track: "+err.Error(), http.StatusBadRequest)
+		return t, false
+	}
+	return t, true
+}
+
+// writeTrackError answers a registry error with its HTTP status.
+func writeTrackError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, track.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, track.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, track.ErrForbidden):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, track.ErrTooManyVersions), errors.Is(err, track.ErrConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, track.ErrTooManyPending):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	default:
+		log.Printf("Track registry error: %v", err)
+		http.Error(w, "track storage failed", http.StatusInternalServerError)
+	}
+}
+
+// handleAdminTrackApproval approves (PUT) or revokes (DELETE) a track
+// version for public matchmaking: /admin/tracks/{id}/{version}/approval.
+func (s *GameServer) handleAdminTrackApproval(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/tracks/"), "/"), "/")
+	if len(parts) != 3 || parts[2] != "approval" {
+		http.NotFound(w, r)
+		return
+	}
+	version, err := strconv.Atoi(parts[1])
+	if err != nil || version <= 0 {
+		http.Error(w, "invalid version", http.StatusBadRequest)
+		return
+	}
+
+	var approved bool
+	switch r.Method {
+	case http.MethodPut:
+		approved = true
+	case http.MethodDelete:
+		approved = false
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	t, err := s.tracks.SetApproved(parts[0], version, approved)
+	if err != nil {
+		writeTrackError(w, err)
+		return
+	}
+	log.Printf("Track %s approval set to %v", t.Label(), approved)
+	writeJSON(w, http.StatusOK, t)
+}
+
+// handleAdminPublicTrack returns (GET), sets (PUT {"id": "...", "version": N})
+// or resets to the built-in road (DELETE) the track of new public rooms.
+// Only approved versions can be set; running rooms keep their road.
+func (s *GameServer) handleAdminPublicTrack(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+
+	case http.MethodPut:
+		var req struct {
+			ID      string `json:"id"`
+			Version int    `json:"version"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Version <= 0 {
+			http.Error(w, "invalid version", http.StatusBadRequest)
+			return
+		}
+		t, err := s.tracks.Get(req.ID, req.Version)
+		if err != nil {
+			writeTrackError(w, err)
+			return
+		}
+		if err := s.matchmaker.SetPublicTrack(&t); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		log.Printf("Public track set to %s", t.Label())
+
+	case http.MethodDelete:
+		s.matchmaker.SetPublicTrack(nil)
+		log.Printf("Public track reset to the built-in road")
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.matchmaker.PublicTrack())
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/storage"
+	"github.com/race/server/internal/track"
+)
+
+// submitTrack posts a track from addr and returns the response's status and
+// the created track's ID
+func submitTrack(s *GameServer, addr string) (int, string) {
+	r := httptest.NewRequest("POST", "/api/tracks", strings.NewReader(`{"name": "Test", "width": 400, "curves": [{"amplitude": 300, "wavelength": 8000}]}`))
+	r.RemoteAddr = addr + ":5000"
+	w := httptest.NewRecorder()
+	s.handleTracks(w, r)
+
+	var created struct {
+		Track track.Track `json:"track"`
+	}
+	json.NewDecoder(w.Body).Decode(&created)
+	return w.Code, created.Track.ID
+}
+
+// TestTrackSubmitLimits checks that an address can't submit more than its
+// burst, and that no track is created while too many await approval, until
+// one is approved
+func TestTrackSubmitLimits(t *testing.T) {
+	cfg := config.DefaultServerConfig()
+	s := &GameServer{config: cfg, tracks: track.NewRegistry(storage.NewMemoryStore()), submits: newSubmitLimiter()}
+
+	for i := 0; i < config.TrackSubmitBurst; i++ {
+		if code, _ := submitTrack(s, "198.51.100.1"); code != http.StatusCreated {
+			t.Fatalf("submission %d: status %d, want %d", i+1, code, http.StatusCreated)
+		}
+	}
+	if code, _ := submitTrack(s, "198.51.100.1"); code != http.StatusTooManyRequests {
+		t.Fatalf("submission past the burst: status %d, want %d", code, http.StatusTooManyRequests)
+	}
+
+	// Other addresses fill the rest
+	var id string
+	for i := config.TrackSubmitBurst; i < config.TrackUnapprovedMax; i++ {
+		code, created := submitTrack(s, fmt.Sprintf("10.0.%d.%d", i/256, i%256))
+		if code != http.StatusCreated {
+			t.Fatalf("track %d: status %d, want %d", i+1, code, http.StatusCreated)
+		}
+		id = created
+	}
+	if code, _ := submitTrack(s, "203.0.113.1"); code != http.StatusTooManyRequests {
+		t.Fatalf("track past the cap: status %d, want %d", code, http.StatusTooManyRequests)
+	}
+
+	if _, err := s.tracks.SetApproved(id, 1, true); err != nil {
+		t.Fatalf("approve: %v", err)
+	}
+	if code, _ := submitTrack(s, "203.0.113.1"); code != http.StatusCreated {
+		t.Fatalf("track after an approval: status %d, want %d", code, http.StatusCreated)
+	}
+	// Revoked, the track awaits approval again
+	if _, err := s.tracks.SetApproved(id, 1, false); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	if code, _ := submitTrack(s, "203.0.113.2"); code != http.StatusTooManyRequests {
+		t.Fatalf("track after a revocation: status %d, want %d", code, http.StatusTooManyRequests)
+	}
+}
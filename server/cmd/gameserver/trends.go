@@ -0,0 +1,190 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/race/server/config"
+)
+
+// Stats trends
+//
+//   GET /stats/history - the load samples of the last hour
+//
+// /stats reports the server as it is; trends show where it is going without
+// an external metrics stack. Every config.TrendSampleInterval the server
+// samples its players, rooms and connections and its join, kick and message
+// counters, keeping config.TrendHistory of samples in memory. /stats
+// summarizes them over rolling windows ("trends": 1m, 5m and 1h): the
+// minimum, average and maximum of the counts, and the rate of each counter.
+// Samples pause while the server hibernates; rates are taken over the time
+// the samples actually span. The daily report takes the same samples (see
+// report.go).
+
+// trendWindows are the rolling windows of /stats
+var trendWindows = []struct {
+	name string
+	span time.Duration
+}{
+	{"1m", time.Minute},
+	{"5m", 5 * time.Minute},
+	{"1h", time.Hour},
+}
+
+// trendSample is the server's load at one moment. Counters are totals since
+// startup.
+type trendSample struct {
+	Time        time.Time `json:"time"`
+	Players     int       `json:"players"`
+	Rooms       int       `json:"rooms"`
+	Connections int       `json:"connections"`
+	Joins       uint64    `json:"joins"`
+	Kicks       uint64    `json:"kicks"`
+	Messages    uint64    `json:"messages"`  // Inbound messages accepted
+	Dropped     uint64    `json:"dropped"`   // Dropped by the flood limiter
+	Throttled   uint64    `json:"throttled"` // Dropped by a rate class limit
+}
+
+// trendGauge summarizes a count over a window
+type trendGauge struct {
+	Min int     `json:"min"`
+	Avg float64 `json:"avg"`
+	Max int     `json:"max"`
+}
+
+func (g *trendGauge) add(n, i int) {
+	if i == 0 || n < g.Min {
+		g.Min = n
+	}
+	if n > g.Max {
+		g.Max = n
+	}
+	g.Avg += (float64(n) - g.Avg) / float64(i+1)
+}
+
+// trendWindow summarizes the samples of a window
+type trendWindow struct {
+	Samples         int        `json:"samples"`
+	Span            float64    `json:"span"` // Seconds covered by the rates
+	Players         trendGauge `json:"players"`
+	Rooms           trendGauge `json:"rooms"`
+	Connections     trendGauge `json:"connections"`
+	Joins           uint64     `json:"joins"`
+	JoinsPerSec     float64    `json:"joinsPerSec"`
+	Kicks           uint64     `json:"kicks"`
+	MessagesPerSec  float64    `json:"messagesPerSec"`
+	DroppedPerSec   float64    `json:"droppedPerSec"`
+	ThrottledPerSec float64    `json:"throttledPerSec"`
+}
+
+// trends keeps the load samples
+type trends struct {
+	mu      sync.Mutex
+	samples []trendSample // Oldest first, at most config.TrendHistory old
+}
+
+// record adds a sample and forgets the ones older than config.TrendHistory
+func (t *trends) record(sample trendSample) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cutoff := sample.Time.Add(-config.TrendHistory)
+	old := 0
+	for old < len(t.samples) && t.samples[old].Time.Before(cutoff) {
+		old++
+	}
+	if old > 0 {
+		t.samples = t.samples[:copy(t.samples, t.samples[old:])]
+	}
+	t.samples = append(t.samples, sample)
+}
+
+// history returns a copy of the samples
+func (t *trends) history() []trendSample {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]trendSample(nil), t.samples...)
+}
+
+// windows summarizes the samples of each window, ending with now (a sample
+// taken for the request)
+func (t *trends) windows(now trendSample) map[string]trendWindow {
+	samples := append(t.history(), now)
+
+	windows := make(map[string]trendWindow, len(trendWindows))
+	for _, tw := range trendWindows {
+		// The counters' baseline is the last sample at or before the
+		// window's start, or the oldest one for a younger server
+		from := now.Time.Add(-tw.span)
+		first := 0
+		for i, sample := range samples {
+			if sample.Time.After(from) {
+				break
+			}
+			first = i
+		}
+		base := samples[first]
+
+		var w trendWindow
+		for _, sample := range samples[first:] {
+			if sample.Time.Before(from) {
+				continue // The baseline
+			}
+			w.Players.add(sample.Players, w.Samples)
+			w.Rooms.add(sample.Rooms, w.Samples)
+			w.Connections.add(sample.Connections, w.Samples)
+			w.Samples++
+		}
+		w.Joins = now.Joins - base.Joins
+		w.Kicks = now.Kicks - base.Kicks
+		if span := now.Time.Sub(base.Time).Seconds(); span > 0 {
+			w.Span = span
+			w.JoinsPerSec = float64(w.Joins) / span
+			w.MessagesPerSec = float64(now.Messages-base.Messages) / span
+			w.DroppedPerSec = float64(now.Dropped-base.Dropped) / span
+			w.ThrottledPerSec = float64(now.Throttled-base.Throttled) / span
+		}
+		windows[tw.name] = w
+	}
+	return windows
+}
+
+// trendSample samples the server's load
+func (s *GameServer) trendSample() trendSample {
+	stats := s.roomStats()
+	return trendSample{
+		Time:        time.Now(),
+		Players:     stats.TotalPlayers,
+		Rooms:       stats.TotalRooms,
+		Connections: s.connectionCount(),
+		Joins:       s.metrics.joins.Load(),
+		Kicks:       s.metrics.kicks.Load(),
+		Messages:    s.metrics.messagesReceived.Load(),
+		Dropped:     s.metrics.messagesDropped.Load(),
+		Throttled:   s.metrics.messagesThrottled.Load(),
+	}
+}
+
+// sampleTrends records a sample every config.TrendSampleInterval until
+// shutdown. Runs as a background task.
+func (s *GameServer) sampleTrends() {
+	ticker := time.NewTicker(config.TrendSampleInterval)
+	defer ticker.Stop()
+
+	s.resumeDailyReport()
+	for ok := true; ok; ok = s.wait(ticker, config.TrendSampleInterval) {
+		sample := s.trendSample()
+		s.trends.record(sample)
+		s.sampleReport(sample)
+	}
+}
+
+// handleStatsHistory returns the samples behind the trends of /stats
+func (s *GameServer) handleStatsHistory(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"interval": config.TrendSampleInterval.Seconds(),
+		"samples":  s.trends.history(),
+	})
+}
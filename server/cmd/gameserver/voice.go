@@ -0,0 +1,118 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/game"
+	"github.com/race/server/internal/network"
+)
+
+// Voice chat signaling
+//
+// Proximity voice chat runs peer to peer over WebRTC. Clients in the same
+// room (protocol v13) set up their peer connections by sending Signal
+// messages naming another player; the room relays each payload unchanged
+// in a SignalRelay message naming the sender (see game/signal.go). The
+// server never reads the payloads: it only checks that both players are in
+// the room and limits each connection to config.SignalMessageRate.
+//
+// Mutes are kept by the server (protocol v27): a Mute message mutes or
+// unmutes a player of the room, clears every mute or only asks for the
+// list, and is answered with the Mutes list. A connection mutes players by
+// name, at most config.MutesMax, until it closes, so a mute follows the
+// muted player into other rooms and through name changes (see profile.go).
+// No signaling is relayed between two players when either muted the other,
+// so they can't set up a peer connection; messages held back are counted
+// in /stats (signalsMuted).
+
+// handleSignal relays a signaling payload to another player in the room.
+func (c *ClientConnection) handleSignal(m *message) {
+	msg, err := c.server.protocol.DecodeSignal(m.data)
+	if err != nil {
+		return
+	}
+
+	if err := m.room.RelaySignal(m.player.ID, msg.TargetID, msg.Kind, msg.Payload); err != nil {
+		if err == game.ErrMuted {
+			c.server.metrics.signalsMuted.Add(1)
+			return
+		}
+		c.Send(c.server.protocol.EncodeError(network.ErrorCodeNotAllowed, err.Error()))
+		return
+	}
+	c.server.metrics.signalsRelayed.Add(1)
+}
+
+// handleMute changes the connection's mutes and sends back the list.
+func (c *ClientConnection) handleMute(m *message) {
+	msg, err := c.server.protocol.DecodeMute(m.data)
+	if err != nil {
+		return
+	}
+
+	var name string
+	if msg.Op == network.MuteOpMute || msg.Op == network.MuteOpUnmute {
+		_, room := c.session()
+		if room == nil {
+			return
+		}
+		if name, err = room.PlayerName(msg.TargetID); err != nil {
+			c.Send(c.server.protocol.EncodeError(network.ErrorCodeNotAllowed, err.Error()))
+			return
+		}
+	}
+
+	c.mu.Lock()
+	switch msg.Op {
+	case network.MuteOpMute:
+		if c.mutes == nil {
+			c.mutes = make(map[string]bool)
+		}
+		if !c.mutes[name] && len(c.mutes) >= config.MutesMax {
+			c.mu.Unlock()
+			c.Send(c.server.protocol.EncodeError(network.ErrorCodeNotAllowed, "Too many players muted"))
+			return
+		}
+		c.mutes[name] = true
+	case network.MuteOpUnmute:
+		delete(c.mutes, name)
+	case network.MuteOpClear:
+		c.mutes = nil
+	}
+	names := make([]string, 0, len(c.mutes))
+	for muted := range c.mutes {
+		names = append(names, muted)
+	}
+	c.mu.Unlock()
+
+	sort.Strings(names)
+	c.Send(c.server.protocol.EncodeMutes(names))
+}
+
+// Mutes implements game.Muter.
+func (c *ClientConnection) Mutes(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.mutes[name]
+}
+
+// renameMuted moves the mutes of a player who changed their name to the
+// new one, so that renaming doesn't lift them
+func (s *GameServer) renameMuted(name, renamed string) {
+	s.connMu.Lock()
+	conns := make([]*ClientConnection, 0, len(s.connections))
+	for conn := range s.connections {
+		conns = append(conns, conn)
+	}
+	s.connMu.Unlock()
+
+	for _, conn := range conns {
+		conn.mu.Lock()
+		if conn.mutes[name] {
+			delete(conn.mutes, name)
+			conn.mutes[renamed] = true
+		}
+		conn.mu.Unlock()
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/game"
+	"github.com/race/server/internal/routines"
+)
+
+// Store writers
+//
+// Room game loops never wait on the store. What they hand off (finished
+// rounds, prestige, kicks) is queued for config.StoreWriters writer
+// goroutines, which make the writes through the outbox (see outbox.go).
+// Handing off never blocks: the queue holds config.StoreWriteQueue writes,
+// and when it is full a match or kick record is dropped, logged and
+// counted. Prestige banked by a player whose previous prestige is still
+// queued is added to that write instead of taking a place of its own.
+// Leaderboard runs only touch the in-memory board, which server.leaderboard
+// persists once a minute.
+//
+// /stats reports the writers as storeWriter: queued, written, dropped and
+// merged writes and the slowest recent write, next to simulation.maxTickMs,
+// the slowest recent room tick. STORE_FAULT_DELAY slows every store call
+// down to check that a slow store shows in the former and not the latter.
+
+// storeWrite is a write handed off by a room
+type storeWrite struct {
+	kind string // Outbox kind, for logs
+	do   func() error
+}
+
+// storeWriter queues the writes handed off by rooms for the writer
+// goroutines
+type storeWriter struct {
+	queue chan storeWrite
+
+	mu       sync.Mutex
+	prestige map[string]*prestigeWrite // Queued prestige by name
+
+	written atomic.Uint64
+	dropped atomic.Uint64
+	merged  atomic.Uint64 // Prestige added to a queued write
+	slowest atomic.Int64  // Nanoseconds of the slowest write in the sampling window
+
+	// Slowest write over the last sampling window (see stats), guarded by mu
+	sampledAt time.Time
+	maxWrite  time.Duration
+}
+
+// storeWriterStats is the storeWriter section of /stats
+type storeWriterStats struct {
+	Queued     int     `json:"queued"`
+	Written    uint64  `json:"written"`
+	Dropped    uint64  `json:"dropped"` // Queue full
+	Merged     uint64  `json:"merged"`
+	MaxWriteMS float64 `json:"maxWriteMs"` // Slowest write recently
+}
+
+func newStoreWriter() *storeWriter {
+	return &storeWriter{
+		queue:    make(chan storeWrite, config.StoreWriteQueue),
+		prestige: make(map[string]*prestigeWrite),
+	}
+}
+
+// start runs the writer goroutines until quit is closed
+func (w *storeWriter) start(quit <-chan struct{}) {
+	for i := 0; i < config.StoreWriters; i++ {
+		routines.Go("server.writer", func() {
+			for {
+				select {
+				case <-quit:
+					return
+				case job := <-w.queue:
+					w.write(job)
+				}
+			}
+		})
+	}
+}
+
+// submit queues a write, or drops it if the queue is full. Never blocks.
+func (w *storeWriter) submit(kind string, do func() error) bool {
+	select {
+	case w.queue <- storeWrite{kind: kind, do: do}:
+		return true
+	default:
+		w.dropped.Add(1)
+		log.Printf("Store writes backed up: dropped a %s write", kind)
+		return false
+	}
+}
+
+// write makes a queued write
+func (w *storeWriter) write(job storeWrite) {
+	began := time.Now()
+	if err := job.do(); err != nil {
+		log.Printf("Failed %s write: %v", job.kind, err)
+	}
+	took := int64(time.Since(began))
+	w.written.Add(1)
+	for slowest := w.slowest.Load(); took > slowest && !w.slowest.CompareAndSwap(slowest, took); {
+		slowest = w.slowest.Load()
+	}
+}
+
+// drain makes the queued writes on the calling goroutine, for shutdown
+func (w *storeWriter) drain() {
+	for {
+		select {
+		case job := <-w.queue:
+			w.write(job)
+		default:
+			return
+		}
+	}
+}
+
+// stats returns the writer's counters. The slowest write is measured over
+// the time since the previous sample, taken at most once a second.
+func (w *storeWriter) stats() storeWriterStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if elapsed := time.Since(w.sampledAt); elapsed >= time.Second {
+		w.sampledAt = w.sampledAt.Add(elapsed)
+		w.maxWrite = time.Duration(w.slowest.Swap(0))
+	}
+	return storeWriterStats{
+		Queued:     len(w.queue),
+		Written:    w.written.Load(),
+		Dropped:    w.dropped.Load(),
+		Merged:     w.merged.Load(),
+		MaxWriteMS: float64(w.maxWrite) / float64(time.Millisecond),
+	}
+}
+
+// onRoundEnd records a finished round in the match history
+func (s *GameServer) onRoundEnd(result game.RoundResult) {
+	s.metrics.matches.Add(1)
+	s.writer.submit(outboxMatch, func() error {
+		m, err := s.history.NewMatch(result)
+		if err == nil {
+			err = s.outbox.Do(outboxMatch, "", m)
+		}
+		if err != nil {
+			return fmt.Errorf("round %d of room %s: %w", result.Round, result.Room, err)
+		}
+		return nil
+	})
+}
+
+// onPrestige banks the score of a run reset for prestige to the player's
+// profile
+func (s *GameServer) onPrestige(player *game.Player, score float64) {
+	w, name := s.writer, player.GetName()
+	w.mu.Lock()
+	if queued, ok := w.prestige[name]; ok {
+		queued.Resets++
+		queued.Score += score
+		queued.At = time.Now()
+		w.mu.Unlock()
+		w.merged.Add(1)
+		return
+	}
+	w.prestige[name] = &prestigeWrite{Name: name, Resets: 1, Score: score, At: time.Now()}
+	w.mu.Unlock()
+
+	take := func() prestigeWrite {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		queued := *w.prestige[name]
+		delete(w.prestige, name)
+		return queued
+	}
+	if !w.submit(outboxPrestige, func() error { return s.outbox.Do(outboxPrestige, "", take()) }) {
+		take()
+	}
+}
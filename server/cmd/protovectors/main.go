@@ -0,0 +1,879 @@
+// Command protovectors generates the golden binary protocol test vectors shared
+// by the Go server and the TypeScript client.
+//
+// Every vector records the exact wire bytes of one message together with the
+// field values they represent. The server side produces the bytes with
+// network.Protocol; the client side decodes the same bytes and compares the
+// fields, so any encode/decode drift between the two implementations shows up
+// as a mismatch instead of a silent desync.
+//
+// Usage:
+//
+//	go run ./cmd/protovectors            # regenerate ../protocol/vectors.json
+//	go run ./cmd/protovectors -check     # fail if the checked-in file is stale
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/race/server/internal/network"
+)
+
+// VectorFile is the on-disk format of the vectors file.
+type VectorFile struct {
+	Comment string   `json:"comment"`
+	Vectors []Vector `json:"vectors"`
+}
+
+// Vector is a single golden message.
+type Vector struct {
+	Name      string                 `json:"name"`
+	Direction string                 `json:"direction"` // "client" (client->server) or "server" (server->client)
+	Type      uint8                  `json:"type"`
+	Hex       string                 `json:"hex"`
+	Fields    map[string]interface{} `json:"fields"`
+}
+
+func main() {
+	out := flag.String("out", "../protocol/vectors.json", "path of the vectors file")
+	check := flag.Bool("check", false, "verify the vectors file is up to date instead of writing it")
+	flag.Parse()
+
+	vectors, err := buildVectors()
+	if err != nil {
+		log.Fatalf("Failed to build vectors: %v", err)
+	}
+
+	data, err := marshalVectors(vectors)
+	if err != nil {
+		log.Fatalf("Failed to marshal vectors: %v", err)
+	}
+
+	if *check {
+		existing, err := os.ReadFile(*out)
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", *out, err)
+		}
+		if !bytes.Equal(existing, data) {
+			log.Fatalf("%s is out of date: run 'go run ./cmd/protovectors' and commit the result", *out)
+		}
+		fmt.Printf("%s is up to date (%d vectors)\n", *out, len(vectors))
+		return
+	}
+
+	if err := os.WriteFile(*out, data, 0o644); err != nil {
+		log.Fatalf("Failed to write %s: %v", *out, err)
+	}
+	fmt.Printf("Wrote %d vectors to %s\n", len(vectors), *out)
+}
+
+// marshalVectors returns the contents of the vectors file
+func marshalVectors(vectors []Vector) ([]byte, error) {
+	data, err := json.MarshalIndent(VectorFile{
+		Comment: "Generated by server/cmd/protovectors. Do not edit by hand.",
+		Vectors: vectors,
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// buildVectors produces every vector. Client->server messages are built from
+// raw bytes and run through the server decoders, so the recorded fields are
+// what the server actually understands.
+func buildVectors() ([]Vector, error) {
+	proto := network.NewProtocol()
+	var vectors []Vector
+
+	// Nonce of the tournament challenge and the answer to it
+	eventNonce := []byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f}
+
+	// --- Client -> Server ---
+
+	inputs := []struct {
+		name string
+		data []byte
+	}{
+		{"input/idle", []byte{network.MsgTypeInput, 0, 0, 0, 0, 0}},
+		{"input/all-keys", []byte{network.MsgTypeInput, 1, network.KeyUp | network.KeyDown | network.KeyLeft | network.KeyRight, 0, 0, 0}},
+		{"input/analog-max", []byte{network.MsgTypeInput, 255, network.KeyUp, 127, 127, 0xFF}},
+		{"input/analog-min", []byte{network.MsgTypeInput, 128, network.KeyDown, 0x81, 0x81, 0}},
+		{"input/analog-int8-min", []byte{network.MsgTypeInput, 42, 0, 0x80, 0x80, 0}},
+	}
+	for _, in := range inputs {
+		msg, err := proto.DecodeInput(in.data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", in.name, err)
+		}
+		vectors = append(vectors, clientVector(in.name, in.data, map[string]interface{}{
+			"sequence": msg.Sequence,
+			"keys":     msg.Keys,
+			"steering": msg.Steering,
+			"throttle": msg.Throttle,
+			"flags":    msg.Flags,
+		}))
+	}
+
+	joins := []struct {
+		name  string
+		pname string
+		color uint8
+	}{
+		{"join/empty-name", "", 0},
+		{"join/ascii", "Racer", 3},
+		{"join/utf8", "Гонщик🏎", 15},
+		{"join/max-name", strings.Repeat("x", 255), 255},
+	}
+	for _, j := range joins {
+		data := encodeJoin(j.pname, j.color)
+		msg, err := proto.DecodeJoin(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", j.name, err)
+		}
+		vectors = append(vectors, clientVector(j.name, data, map[string]interface{}{
+			"name":    msg.Name,
+			"color":   msg.Color,
+			"options": msg.Options,
+		}))
+	}
+
+	// ProtocolV5 join options
+	soloJoin := append(encodeJoin("Racer", 3), network.JoinSolo|network.JoinReplay)
+	solo, err := proto.DecodeJoin(soloJoin)
+	if err != nil {
+		return nil, fmt.Errorf("join/solo-replay: %w", err)
+	}
+	vectors = append(vectors, clientVector("join/solo-replay", soloJoin, map[string]interface{}{
+		"name":    solo.Name,
+		"color":   solo.Color,
+		"options": solo.Options,
+	}))
+
+	// ProtocolV6 tutorial join
+	tutorialJoin := append(encodeJoin("Racer", 3), network.JoinTutorial)
+	tutorial, err := proto.DecodeJoin(tutorialJoin)
+	if err != nil {
+		return nil, fmt.Errorf("join/tutorial: %w", err)
+	}
+	vectors = append(vectors, clientVector("join/tutorial", tutorialJoin, map[string]interface{}{
+		"name":    tutorial.Name,
+		"color":   tutorial.Color,
+		"options": tutorial.Options,
+	}))
+
+	// ProtocolV7 practice room on a custom track
+	trackJoin := append(encodeJoin("Racer", 3), network.JoinSolo|network.JoinTrack, 8)
+	trackJoin = append(trackJoin, "018cb2fb"...)
+	trackJoin = binary.LittleEndian.AppendUint16(trackJoin, 2)
+	custom, err := proto.DecodeJoin(trackJoin)
+	if err != nil {
+		return nil, fmt.Errorf("join/track: %w", err)
+	}
+	vectors = append(vectors, clientVector("join/track", trackJoin, map[string]interface{}{
+		"name":         custom.Name,
+		"color":        custom.Color,
+		"options":      custom.Options,
+		"trackId":      custom.TrackID,
+		"trackVersion": custom.TrackVersion,
+	}))
+
+	// ProtocolV15 cosmetics, after the track if there is one
+	dressedJoin := append(encodeJoin("Racer", 3), network.JoinCosmetics, 3, 1)
+	dressed, err := proto.DecodeJoin(dressedJoin)
+	if err != nil {
+		return nil, fmt.Errorf("join/cosmetics: %w", err)
+	}
+	vectors = append(vectors, clientVector("join/cosmetics", dressedJoin, map[string]interface{}{
+		"name":    dressed.Name,
+		"color":   dressed.Color,
+		"options": dressed.Options,
+		"trail":   dressed.Trail,
+		"decal":   dressed.Decal,
+	}))
+	dressedTrackJoin := append(encodeJoin("Racer", 3), network.JoinSolo|network.JoinTrack|network.JoinCosmetics, 8)
+	dressedTrackJoin = append(dressedTrackJoin, "018cb2fb"...)
+	dressedTrackJoin = binary.LittleEndian.AppendUint16(dressedTrackJoin, 2)
+	dressedTrackJoin = append(dressedTrackJoin, 5, 0)
+	dressedTrack, err := proto.DecodeJoin(dressedTrackJoin)
+	if err != nil {
+		return nil, fmt.Errorf("join/track-cosmetics: %w", err)
+	}
+	vectors = append(vectors, clientVector("join/track-cosmetics", dressedTrackJoin, map[string]interface{}{
+		"name":         dressedTrack.Name,
+		"color":        dressedTrack.Color,
+		"options":      dressedTrack.Options,
+		"trackId":      dressedTrack.TrackID,
+		"trackVersion": dressedTrack.TrackVersion,
+		"trail":        dressedTrack.Trail,
+		"decal":        dressedTrack.Decal,
+	}))
+
+	for _, ts := range []uint64{0, 1700000000000, ^uint64(0)} {
+		data := make([]byte, 9)
+		data[0] = network.MsgTypePing
+		binary.LittleEndian.PutUint64(data[1:], ts)
+		vectors = append(vectors, clientVector(fmt.Sprintf("ping/%d", ts), data, map[string]interface{}{
+			"timestamp": fmt.Sprint(ts), // string: exceeds JSON's safe integer range
+		}))
+	}
+
+	perfPing := []byte{network.MsgTypePing, 0, 0, 0, 0, 0, 0, 0, 0, 60, 100, 0, 0xFF, 0xFF}
+	binary.LittleEndian.PutUint64(perfPing[1:], 1700000000000)
+	ping, err := proto.DecodePing(perfPing)
+	if err != nil {
+		return nil, fmt.Errorf("ping/perf: %w", err)
+	}
+	vectors = append(vectors, clientVector("ping/perf", perfPing, map[string]interface{}{
+		"timestamp":     fmt.Sprint(ping.Timestamp),
+		"fps":           ping.FPS,
+		"interpDelayMs": ping.InterpDelayMS,
+		"jitterMs":      ping.JitterMS,
+	}))
+
+	vectors = append(vectors, clientVector("leave", []byte{network.MsgTypeLeaveRoom}, map[string]interface{}{}))
+	vectors = append(vectors, clientVector("reset", []byte{network.MsgTypeReset}, map[string]interface{}{}))
+	vectors = append(vectors, clientVector("prestige", []byte{network.MsgTypePrestige}, map[string]interface{}{}))
+	vectors = append(vectors, clientVector("clip", []byte{network.MsgTypeClip}, map[string]interface{}{}))
+	vectors = append(vectors, clientVector("net-sim", []byte{network.MsgTypeNetSim, 0x2C, 0x01, 0x32, 0x00, 5}, map[string]interface{}{
+		"latencyMs": 300,
+		"jitterMs":  50,
+		"loss":      5,
+	}))
+	eventMAC := network.EventAuthMAC([]byte("grand-prix-2026-secret"), eventNonce)
+	vectors = append(vectors, clientVector("event-auth", append([]byte{network.MsgTypeEventAuth}, eventMAC...), map[string]interface{}{
+		"mac": hex.EncodeToString(eventMAC),
+	}))
+	vectors = append(vectors, clientVector("mute", []byte{network.MsgTypeMute, network.MuteOpMute, 0x07, 0x00}, map[string]interface{}{
+		"op":       network.MuteOpMute,
+		"targetId": 7,
+	}))
+	vectors = append(vectors, clientVector("update-profile", append(append([]byte{network.MsgTypeUpdateProfile, 5}, "Alice"...), 3), map[string]interface{}{
+		"name":  "Alice",
+		"color": 3,
+	}))
+
+	// ProtocolV30 private rooms: the join fields follow the code and password
+	createRoom := append([]byte{network.MsgTypeCreateRoom, 0}, encodeJoin("Racer", 3)[1:]...)
+	created, err := proto.DecodeCreateRoom(createRoom)
+	if err != nil {
+		return nil, fmt.Errorf("create-room: %w", err)
+	}
+	vectors = append(vectors, clientVector("create-room", createRoom, map[string]interface{}{
+		"password": created.Password,
+		"name":     created.Join.Name,
+		"color":    created.Join.Color,
+	}))
+	lockedRoom := append([]byte{network.MsgTypeCreateRoom, 6}, "hunter"...)
+	lockedRoom = append(append(lockedRoom, encodeJoin("Racer", 3)[1:]...), network.JoinCosmetics, 3, 1)
+	locked, err := proto.DecodeCreateRoom(lockedRoom)
+	if err != nil {
+		return nil, fmt.Errorf("create-room/password: %w", err)
+	}
+	vectors = append(vectors, clientVector("create-room/password", lockedRoom, map[string]interface{}{
+		"password": locked.Password,
+		"name":     locked.Join.Name,
+		"color":    locked.Join.Color,
+		"options":  locked.Join.Options,
+		"trail":    locked.Join.Trail,
+		"decal":    locked.Join.Decal,
+	}))
+	byCode := append([]byte{network.MsgTypeJoinByCode, network.RoomCodeLen}, "K7WQ2M"...)
+	byCode = append(append(byCode, 6), "hunter"...)
+	byCode = append(byCode, encodeJoin("Alice", 5)[1:]...)
+	joined, err := proto.DecodeJoinByCode(byCode)
+	if err != nil {
+		return nil, fmt.Errorf("join-by-code: %w", err)
+	}
+	vectors = append(vectors, clientVector("join-by-code", byCode, map[string]interface{}{
+		"code":     joined.Code,
+		"password": joined.Password,
+		"name":     joined.Join.Name,
+		"color":    joined.Join.Color,
+	}))
+
+	// ProtocolV32 open spectating
+	spectate := append([]byte{network.MsgTypeSpectate, 16}, "9f86d081884c7d65"...)
+	watched, err := proto.DecodeSpectate(spectate)
+	if err != nil {
+		return nil, fmt.Errorf("spectate: %w", err)
+	}
+	vectors = append(vectors, clientVector("spectate", spectate, map[string]interface{}{
+		"room": watched.RoomID,
+	}))
+
+	// ProtocolV33 client telemetry, signed with the default key
+	telemetryNonce := []byte("0123456789abcdef")
+	frames := []uint16{166, 167, 168, 166}
+	telemetryMAC := network.TelemetryMAC([]byte("vracer-telemetry-v1"), telemetryNonce, network.TelemetryDeviceKeyboard, frames)
+	telemetry := append([]byte{network.MsgTypeTelemetry}, telemetryNonce...)
+	telemetry = append(telemetry, network.TelemetryDeviceKeyboard, uint8(len(frames)))
+	for _, f := range frames {
+		telemetry = binary.LittleEndian.AppendUint16(telemetry, f)
+	}
+	telemetry = append(telemetry, telemetryMAC...)
+	sample, err := proto.DecodeTelemetry(telemetry)
+	if err != nil {
+		return nil, fmt.Errorf("telemetry: %w", err)
+	}
+	vectors = append(vectors, clientVector("telemetry", telemetry, map[string]interface{}{
+		"nonce":  hex.EncodeToString(sample.Nonce[:]),
+		"device": sample.Device,
+		"frames": sample.Frames,
+		"mac":    hex.EncodeToString(sample.MAC[:]),
+	}))
+
+	for _, v := range []uint8{network.ProtocolV1, network.ProtocolV2, network.ProtocolV3, network.ProtocolV4, network.ProtocolV5, network.ProtocolV6, network.ProtocolV7, network.ProtocolV8, network.ProtocolV9, network.ProtocolV10, network.ProtocolV11, network.ProtocolV12, network.ProtocolV13, network.ProtocolV14, network.ProtocolV15, network.ProtocolV16, network.ProtocolV17, network.ProtocolV18, network.ProtocolV19, network.ProtocolV20, network.ProtocolV21, network.ProtocolV22, network.ProtocolV23, network.ProtocolV24, network.ProtocolV25, network.ProtocolV26, network.ProtocolV27, network.ProtocolV28, network.ProtocolV29, network.ProtocolV30, network.ProtocolV31, network.ProtocolV32, network.ProtocolV33, network.ProtocolV34, network.ProtocolV35, network.ProtocolV36, network.ProtocolV37, network.ProtocolV38, 255} {
+		data := []byte{network.MsgTypeHello, v}
+		msg, err := proto.DecodeHello(data)
+		if err != nil {
+			return nil, fmt.Errorf("hello/%d: %w", v, err)
+		}
+		vectors = append(vectors, clientVector(fmt.Sprintf("hello/%d", v), data, map[string]interface{}{
+			"version": msg.Version,
+		}))
+	}
+
+	hostKick := []byte{network.MsgTypeHostKick, 0x34, 0x12}
+	kick, err := proto.DecodeHostKick(hostKick)
+	if err != nil {
+		return nil, fmt.Errorf("host-kick: %w", err)
+	}
+	vectors = append(vectors, clientVector("host-kick", hostKick, map[string]interface{}{
+		"targetId": kick.TargetID,
+	}))
+
+	// ProtocolV38 host commands of private rooms
+	vectors = append(vectors, clientVector("host-start-race", []byte{network.MsgTypeHostStartRace}, map[string]interface{}{}))
+	hostSettings := append([]byte{network.MsgTypeHostSettings, network.RoomSettingsClosed, 6}, "s3cret"...)
+	settings, err := proto.DecodeHostSettings(hostSettings)
+	if err != nil {
+		return nil, fmt.Errorf("host-settings: %w", err)
+	}
+	vectors = append(vectors, clientVector("host-settings", hostSettings, map[string]interface{}{
+		"closed":   settings.Closed,
+		"password": settings.Password,
+	}))
+
+	// ProtocolV12 account link (the token is opaque to the client)
+	linkToken := "eyJwIjoibGluayIsInMiOiJSYWNlciJ9.c2lnbmF0dXJl"
+	linkData := binary.LittleEndian.AppendUint16([]byte{network.MsgTypeLink}, uint16(len(linkToken)))
+	linkData = append(linkData, linkToken...)
+	link, err := proto.DecodeLink(linkData)
+	if err != nil {
+		return nil, fmt.Errorf("link: %w", err)
+	}
+	vectors = append(vectors, clientVector("link", linkData, map[string]interface{}{
+		"token": link.Token,
+	}))
+
+	// ProtocolV13 voice chat signaling (payloads are opaque to the server)
+	for _, s := range []struct {
+		name    string
+		kind    uint8
+		payload string
+	}{
+		{"signal/candidate", network.SignalCandidate, `{"candidate":"candidate:1 1 udp 2122260223 10.0.0.2 54321 typ host","sdpMid":"0"}`},
+		{"signal/hangup", network.SignalHangup, ""},
+	} {
+		data := binary.LittleEndian.AppendUint16([]byte{network.MsgTypeSignal}, 0x1234)
+		data = append(data, s.kind)
+		data = binary.LittleEndian.AppendUint16(data, uint16(len(s.payload)))
+		data = append(data, s.payload...)
+		msg, err := proto.DecodeSignal(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s.name, err)
+		}
+		vectors = append(vectors, clientVector(s.name, data, map[string]interface{}{
+			"targetId": msg.TargetID,
+			"kind":     msg.Kind,
+			"payload":  string(msg.Payload),
+		}))
+	}
+
+	// --- Server -> Client ---
+
+	moving := network.ConvertToPlayerStateData(3, -120.5, 90000, 1350, -8, 777, false, 7)
+	moving.VelX = network.ScaleVelocity(-210.25)
+	moving.VelY = network.ScaleVelocity(1349.9)
+	fast := network.ConvertToPlayerStateData(4, 0, 0, 0, 0, 0, false, 1)
+	fast.VelX = network.ScaleVelocity(-1e6)
+	fast.VelY = network.ScaleVelocity(1e6)
+	laned := moving
+	laned.Lane = 3
+
+	states := []struct {
+		name    string
+		version uint8
+		tick    uint16
+		baseY   int64
+		players []network.PlayerStateData
+		own     network.OwnState
+	}{
+		{"state/empty", network.ProtocolV1, 0, 0, nil, network.OwnState{}},
+		{"state/single", network.ProtocolV1, 1234, 0, []network.PlayerStateData{
+			network.ConvertToPlayerStateData(1, 12.34, 5678, 900, 12.5, 4321, false, 2),
+		}, network.OwnState{}},
+		{"state/edges", network.ProtocolV1, 0xFFFF, 0, []network.PlayerStateData{
+			network.ConvertToPlayerStateData(0xFFFF, -3276.7, 2147483647, -280, -25, 0xFFFFFF+1000, true, 15),
+			network.ConvertToPlayerStateData(2, 3276.7, -1000, 1400, 25, 0, false, 0),
+		}, network.OwnState{}},
+		{"state/v2-velocity", network.ProtocolV2, 42, 0, []network.PlayerStateData{moving, fast}, network.OwnState{}},
+		{"state/v10-base", network.ProtocolV10, 7, 9007199254740000, []network.PlayerStateData{moving}, network.OwnState{}},
+		{"state/v17-lane", network.ProtocolV17, 8, 0, []network.PlayerStateData{laned, fast}, network.OwnState{}},
+		{"state/v20-no-own", network.ProtocolV20, 9, 0, []network.PlayerStateData{laned}, network.OwnState{}},
+		{"state/v20-own-fuel", network.ProtocolV20, 10, 0, []network.PlayerStateData{laned}, network.OwnState{HasFuel: true, Fuel: network.ScaleFuel(0.4375)}},
+		{"state/v20-no-ack", network.ProtocolV20, 11, 0, []network.PlayerStateData{laned}, network.OwnState{HasAck: true, Ack: 200}},
+		{"state/v35-ack", network.ProtocolV35, 12, 0, []network.PlayerStateData{laned}, network.OwnState{HasAck: true, Ack: 200}},
+		{"state/v35-fuel-ack", network.ProtocolV35, 13, 0, []network.PlayerStateData{laned}, network.OwnState{HasFuel: true, Fuel: network.ScaleFuel(0.4375), HasAck: true, Ack: 7}},
+	}
+	for _, s := range states {
+		data := proto.EncodeStateUpdateOwn(s.version, s.tick, s.baseY, s.players, s.own)
+		players := make([]interface{}, 0, len(s.players))
+		for _, ps := range s.players {
+			rating := ps.Rating
+			if rating > 0xFFFFFF {
+				rating = 0xFFFFFF
+			}
+			player := map[string]interface{}{
+				"id":     ps.ID,
+				"x":      ps.X,
+				"y":      ps.Y,
+				"speed":  ps.Speed,
+				"angle":  ps.Angle,
+				"rating": rating,
+				"flags":  ps.Flags,
+				"color":  ps.Color,
+			}
+			if s.version >= network.ProtocolV2 {
+				player["velX"] = ps.VelX
+				player["velY"] = ps.VelY
+			}
+			if s.version >= network.ProtocolV17 {
+				player["lane"] = ps.Lane
+			}
+			players = append(players, player)
+		}
+		fields := map[string]interface{}{
+			"version": s.version,
+			"tick":    s.tick,
+			"players": players,
+		}
+		if s.version >= network.ProtocolV10 {
+			fields["baseY"] = s.baseY
+		}
+		if s.version >= network.ProtocolV20 {
+			own := map[string]interface{}{}
+			if s.own.HasFuel {
+				own["fuel"] = s.own.Fuel
+			}
+			if s.own.HasAck && s.version >= network.ProtocolV35 {
+				own["ack"] = s.own.Ack
+			}
+			fields["own"] = own
+		}
+		vectors = append(vectors, serverVector(s.name, data, fields))
+	}
+
+	for _, j := range []struct {
+		name  string
+		id    uint16
+		pname string
+		color uint8
+	}{
+		{"player-join/ascii", 1, "Racer", 0},
+		{"player-join/utf8", 0xFFFF, "Гонщик🏎", 15},
+	} {
+		vectors = append(vectors, serverVector(j.name, proto.EncodePlayerJoin(j.id, j.pname, j.color), map[string]interface{}{
+			"id":    j.id,
+			"name":  j.pname,
+			"color": j.color,
+		}))
+	}
+
+	for _, id := range []uint16{1, 0xFFFF} {
+		vectors = append(vectors,
+			serverVector(fmt.Sprintf("player-leave/%d", id), proto.EncodePlayerLeave(id), map[string]interface{}{"id": id}),
+			serverVector(fmt.Sprintf("player-death/%d", id), proto.EncodePlayerDeath(id), map[string]interface{}{"id": id}),
+		)
+	}
+
+	vectors = append(vectors, serverVector("room-info/basic",
+		proto.EncodeRoomInfo("0123456789abcdef", 1, 100, 1), map[string]interface{}{
+			"roomId":      "0123456789abcdef",
+			"playerCount": 1,
+			"maxPlayers":  100,
+			"yourId":      1,
+		}))
+	for _, c := range []struct {
+		name       string
+		collisions uint8
+	}{{"on", network.CollisionsOn}, {"soft", network.CollisionsSoft}, {"off", network.CollisionsOff}} {
+		vectors = append(vectors, serverVector("room-info/v37-collisions-"+c.name,
+			proto.EncodeRoomInfoVersion(network.ProtocolV37, network.RoomInfoMessage{RoomID: "0123456789abcdef", PlayerCount: 2, MaxPlayers: 100, YourPlayerID: 2, Collisions: c.collisions}), map[string]interface{}{
+				"version":     network.ProtocolV37,
+				"roomId":      "0123456789abcdef",
+				"playerCount": 2,
+				"maxPlayers":  100,
+				"yourId":      2,
+				"collisions":  c.collisions,
+			}))
+	}
+
+	for _, ts := range []uint64{0, 1700000000000, ^uint64(0)} {
+		vectors = append(vectors, serverVector(fmt.Sprintf("pong/%d", ts), proto.EncodePong(ts), map[string]interface{}{
+			"timestamp": fmt.Sprint(ts),
+		}))
+	}
+
+	for _, c := range []struct {
+		remainingMS uint32
+		offenses    uint8
+	}{
+		{30000, 1},
+		{0xFFFFFFFF, 255},
+	} {
+		vectors = append(vectors, serverVector(fmt.Sprintf("cooldown/%d", c.offenses), proto.EncodeCooldown(c.remainingMS, c.offenses), map[string]interface{}{
+			"remainingMs": c.remainingMS,
+			"offenses":    c.offenses,
+		}))
+	}
+
+	vectors = append(vectors, serverVector("hello-ack/1", proto.EncodeHelloAck(network.ProtocolV1), map[string]interface{}{
+		"version": network.ProtocolV1,
+	}))
+
+	vectors = append(vectors, serverVector("scoreboard/basic", proto.EncodeScoreboard([]network.ScoreboardEntry{
+		{ID: 1, RTTMS: 42},
+		{ID: 0xFFFF, RTTMS: 0xFFFF},
+	}), map[string]interface{}{
+		"entries": []interface{}{
+			map[string]interface{}{"id": 1, "rttMs": 42},
+			map[string]interface{}{"id": 0xFFFF, "rttMs": 0xFFFF},
+		},
+	}))
+
+	vectors = append(vectors, serverVector("host-change/1", proto.EncodeHostChange(1), map[string]interface{}{
+		"hostId": 1,
+	}))
+
+	vectors = append(vectors, serverVector("queue-status/3-of-10", proto.EncodeQueueStatus(3, 10), map[string]interface{}{
+		"position": 3,
+		"length":   10,
+	}))
+
+	vectors = append(vectors, serverVector("tick-rate/60-10", proto.EncodeTickRate(60, 10), map[string]interface{}{
+		"physicsRate":   60,
+		"broadcastRate": 10,
+	}))
+
+	for _, t := range []struct {
+		name        string
+		step, steps uint8
+		text        string
+	}{
+		{"tutorial/first-step", 0, 5, "Разгонитесь до 80 км/ч"},
+		{"tutorial/done", 5, 5, "Done"},
+	} {
+		vectors = append(vectors, serverVector(t.name, proto.EncodeTutorial(t.step, t.steps, t.text), map[string]interface{}{
+			"step":  t.step,
+			"steps": t.steps,
+			"text":  t.text,
+		}))
+	}
+
+	trackCurves := []network.TrackCurve{
+		{Amplitude: 500, Wavelength: 5000, Phase: 0, Sharpness: 3},
+		{Amplitude: -200, Wavelength: 2000, Phase: 1.5, Sharpness: 1},
+	}
+	curves := make([]map[string]interface{}, len(trackCurves))
+	for i, c := range trackCurves {
+		curves[i] = map[string]interface{}{
+			"amplitude":  c.Amplitude,
+			"wavelength": c.Wavelength,
+			"phase":      c.Phase,
+			"sharpness":  c.Sharpness,
+		}
+	}
+	vectors = append(vectors, serverVector("track/two-curves", proto.EncodeTrack(360, trackCurves), map[string]interface{}{
+		"width":  360,
+		"curves": curves,
+	}))
+	vectors = append(vectors, serverVector("track/v17-lanes", proto.EncodeTrackVersion(network.ProtocolV17, network.TrackMessage{Width: 360, Curves: trackCurves, Lanes: 3}), map[string]interface{}{
+		"version": network.ProtocolV17,
+		"width":   360,
+		"lanes":   3,
+		"curves":  curves,
+	}))
+	hill := network.TrackCurve{Amplitude: 150, Wavelength: 8000, Phase: 0.5, Sharpness: 1}
+	vectors = append(vectors, serverVector("track/v18-elevation", proto.EncodeTrackVersion(network.ProtocolV18, network.TrackMessage{Width: 360, Curves: trackCurves[:1], Lanes: 3, Banking: 0.75, Elevation: []network.TrackCurve{hill}}), map[string]interface{}{
+		"version": network.ProtocolV18,
+		"width":   360,
+		"lanes":   3,
+		"curves":  curves[:1],
+		"banking": 0.75,
+		"elevation": []map[string]interface{}{{
+			"amplitude":  hill.Amplitude,
+			"wavelength": hill.Wavelength,
+			"phase":      hill.Phase,
+			"sharpness":  hill.Sharpness,
+		}},
+	}))
+	ramp := network.TrackRamp{Offset: 1500, Spacing: 6000, Length: 240, Height: 60}
+	vectors = append(vectors, serverVector("track/v19-ramps", proto.EncodeTrackVersion(network.ProtocolV19, network.TrackMessage{Width: 360, Curves: trackCurves[:1], Lanes: 3, Ramps: []network.TrackRamp{ramp}}), map[string]interface{}{
+		"version":   network.ProtocolV19,
+		"width":     360,
+		"lanes":     3,
+		"curves":    curves[:1],
+		"banking":   0,
+		"elevation": []map[string]interface{}{},
+		"ramps": []map[string]interface{}{{
+			"offset":  ramp.Offset,
+			"spacing": ramp.Spacing,
+			"length":  ramp.Length,
+			"height":  ramp.Height,
+		}},
+	}))
+	vectors = append(vectors, serverVector("track/v21-mirror-night", proto.EncodeTrackVersion(network.ProtocolV21, network.TrackMessage{Width: 360, Curves: trackCurves[:1], Lanes: 3, Variant: network.TrackVariantMirror | network.TrackVariantNight}), map[string]interface{}{
+		"version":   network.ProtocolV21,
+		"width":     360,
+		"lanes":     3,
+		"curves":    curves[:1],
+		"banking":   0,
+		"elevation": []map[string]interface{}{},
+		"ramps":     []map[string]interface{}{},
+		"variant":   network.TrackVariantMirror | network.TrackVariantNight,
+	}))
+	vectors = append(vectors, serverVector("track/v21-builtin-mirror", proto.EncodeTrackVersion(network.ProtocolV21, network.TrackMessage{Width: 400, Lanes: 4, Variant: network.TrackVariantMirror}), map[string]interface{}{
+		"version":   network.ProtocolV21,
+		"width":     400,
+		"lanes":     4,
+		"curves":    []map[string]interface{}{},
+		"banking":   0,
+		"elevation": []map[string]interface{}{},
+		"ramps":     []map[string]interface{}{},
+		"variant":   network.TrackVariantMirror,
+	}))
+	vectors = append(vectors, serverVector("track/v22-escalation", proto.EncodeTrackVersion(network.ProtocolV22, network.TrackMessage{Width: 400, Lanes: 4, EscalationRate: 0.000002, EscalationMax: 0.75}), map[string]interface{}{
+		"version":        network.ProtocolV22,
+		"width":          400,
+		"lanes":          4,
+		"curves":         []map[string]interface{}{},
+		"banking":        0,
+		"elevation":      []map[string]interface{}{},
+		"ramps":          []map[string]interface{}{},
+		"variant":        0,
+		"escalationRate": float32(0.000002),
+		"escalationMax":  0.75,
+	}))
+
+	resultAwards := []network.ResultAward{
+		{Kind: network.AwardMVP, PlayerID: 3, Value: 5120},
+		{Kind: network.AwardFastestSector, PlayerID: 0x0102, Value: 4.25},
+	}
+	awards := make([]map[string]interface{}, len(resultAwards))
+	for i, a := range resultAwards {
+		awards[i] = map[string]interface{}{
+			"kind":     a.Kind,
+			"playerId": a.PlayerID,
+			"value":    a.Value,
+		}
+	}
+	vectors = append(vectors, serverVector("results/two-awards", proto.EncodeResults(7, resultAwards), map[string]interface{}{
+		"round":  7,
+		"awards": awards,
+	}))
+	vectors = append(vectors, serverVector("results/none", proto.EncodeResults(1, nil), map[string]interface{}{
+		"round":  1,
+		"awards": []map[string]interface{}{},
+	}))
+
+	vectors = append(vectors, serverVector("rebase/shift", proto.EncodeRebase(3000000, 1000000), map[string]interface{}{
+		"origin": 3000000.0,
+		"shift":  1000000.0,
+	}))
+	vectors = append(vectors, serverVector("rebase/join", proto.EncodeRebase(1000000, 0), map[string]interface{}{
+		"origin": 1000000.0,
+		"shift":  0.0,
+	}))
+	vectors = append(vectors, serverVector("interp-delay/120ms", proto.EncodeInterpDelay(120), map[string]interface{}{
+		"delayMs": 120,
+	}))
+	vectors = append(vectors, serverVector("interp-delay/none", proto.EncodeInterpDelay(0), map[string]interface{}{
+		"delayMs": 0,
+	}))
+	vectors = append(vectors, serverVector("linked", proto.EncodeLinked("Racer"), map[string]interface{}{
+		"account": "Racer",
+	}))
+	offer := "v=0\r\no=- 4611731400430051336 2 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\nm=audio 9 UDP/TLS/RTP/SAVPF 111\r\n"
+	vectors = append(vectors, serverVector("signal-relay/offer", proto.EncodeSignalRelay(7, network.SignalOffer, []byte(offer)), map[string]interface{}{
+		"fromId":  7,
+		"kind":    network.SignalOffer,
+		"payload": offer,
+	}))
+	vectors = append(vectors, serverVector("nearby", proto.EncodeNearby([]uint16{2, 5, 0x1234}), map[string]interface{}{
+		"ids": []uint16{2, 5, 0x1234},
+	}))
+	vectors = append(vectors, serverVector("nearby/empty", proto.EncodeNearby(nil), map[string]interface{}{
+		"ids": []uint16{},
+	}))
+	vectors = append(vectors, serverVector("appearance", proto.EncodeAppearance(7, 3, 1), map[string]interface{}{
+		"id":    7,
+		"trail": 3,
+		"decal": 1,
+	}))
+	vectors = append(vectors, serverVector("director/collision", proto.EncodeDirector(0x0102, 9, network.DirectorCollision), map[string]interface{}{
+		"target": 0x0102,
+		"second": 9,
+		"reason": network.DirectorCollision,
+	}))
+	vectors = append(vectors, serverVector("director/leader", proto.EncodeDirector(4, 0, network.DirectorLeader), map[string]interface{}{
+		"target": 4,
+		"second": 0,
+		"reason": network.DirectorLeader,
+	}))
+	vectors = append(vectors, serverVector("milestone/distance", proto.EncodeMilestone(0x0102, network.MilestoneDistance, 3, 500), map[string]interface{}{
+		"playerId": 0x0102,
+		"kind":     network.MilestoneDistance,
+		"count":    3,
+		"value":    500,
+	}))
+	vectors = append(vectors, serverVector("milestone/prestige", proto.EncodeMilestone(7, network.MilestonePrestige, 2, 21500.5), map[string]interface{}{
+		"playerId": 7,
+		"kind":     network.MilestonePrestige,
+		"count":    2,
+		"value":    21500.5,
+	}))
+	vectors = append(vectors, serverVector("collision", proto.EncodeCollision(3, 0x0102, 412.5), map[string]interface{}{
+		"a":      3,
+		"b":      0x0102,
+		"impact": 412.5,
+	}))
+	vectors = append(vectors, serverVector("challenge", proto.EncodeChallenge(eventNonce), map[string]interface{}{
+		"nonce": hex.EncodeToString(eventNonce),
+	}))
+	vectors = append(vectors, serverVector("mutes", proto.EncodeMutes([]string{"Bob", "Zoë"}), map[string]interface{}{
+		"names": []string{"Bob", "Zoë"},
+	}))
+	sessionToken := "eyJwIjoic2Vzc2lvbiIsInMiOiJyb29tLTEifQ.c2lnbmF0dXJl"
+	vectors = append(vectors, serverVector("session", proto.EncodeSession(sessionToken), map[string]interface{}{
+		"token": sessionToken,
+	}))
+	vectors = append(vectors, serverVector("room-code", proto.EncodeRoomCode("K7WQ2M"), map[string]interface{}{
+		"code": "K7WQ2M",
+	}))
+	vectors = append(vectors, serverVector("room-settings", proto.EncodeRoomSettings(true, true), map[string]interface{}{
+		"closed":   true,
+		"password": true,
+	}))
+	probeNonce := []byte("fedcba9876543210")
+	vectors = append(vectors, serverVector("probe", proto.EncodeProbe(probeNonce, 30), map[string]interface{}{
+		"nonce":  hex.EncodeToString(probeNonce),
+		"frames": 30,
+	}))
+	vectors = append(vectors, serverVector("relay", proto.EncodeRelay(network.RelayTeam{
+		Team: 2, Active: 0x0102, Legs: 3, Leg: 1250.5, LegLength: 5000, Distance: 16250.5, Members: []uint16{7, 0x0102, 9},
+	}), map[string]interface{}{
+		"team":      2,
+		"active":    0x0102,
+		"legs":      3,
+		"leg":       1250.5,
+		"legLength": 5000,
+		"distance":  16250.5,
+		"members":   []uint16{7, 0x0102, 9},
+	}))
+	vectors = append(vectors, serverVector("race-start/countdown", proto.EncodeRaceStart(network.RaceStart{
+		Race: 3, Laps: 3, Checkpoints: 4, LapLength: 25000, CountdownMS: 5000, Entered: true,
+	}), map[string]interface{}{
+		"race":        3,
+		"laps":        3,
+		"checkpoints": 4,
+		"lapLength":   25000,
+		"countdownMs": 5000,
+		"entered":     true,
+	}))
+	vectors = append(vectors, serverVector("race-start/started", proto.EncodeRaceStart(network.RaceStart{
+		Race: 3, Laps: 3, Checkpoints: 4, LapLength: 25000,
+	}), map[string]interface{}{
+		"race":        3,
+		"laps":        3,
+		"checkpoints": 4,
+		"lapLength":   25000,
+		"countdownMs": 0,
+		"entered":     false,
+	}))
+	vectors = append(vectors, serverVector("checkpoint-passed", proto.EncodeCheckpointPassed(network.RaceStanding{
+		PlayerID: 0x0102, Lap: 2, Checkpoint: 3, TimeMS: 95250,
+	}), map[string]interface{}{
+		"playerId":   0x0102,
+		"lap":        2,
+		"checkpoint": 3,
+		"timeMs":     95250,
+	}))
+	vectors = append(vectors, serverVector("race-finished", proto.EncodeRaceFinished(7, 1, 151875), map[string]interface{}{
+		"playerId": 7,
+		"place":    1,
+		"timeMs":   151875,
+	}))
+	vectors = append(vectors, serverVector("leaderboard", proto.EncodeLeaderboard(3, true, []network.RaceStanding{
+		{PlayerID: 7, Lap: 3, Checkpoint: 4, TimeMS: 151875},
+		{PlayerID: 0x0102, Lap: 2, Checkpoint: 3, TimeMS: 95250},
+	}), map[string]interface{}{
+		"race":  3,
+		"final": true,
+		"standings": []map[string]interface{}{
+			{"playerId": 7, "lap": 3, "checkpoint": 4, "timeMs": 151875},
+			{"playerId": 0x0102, "lap": 2, "checkpoint": 3, "timeMs": 95250},
+		},
+	}))
+	vectors = append(vectors, serverVector("clip-saved", proto.EncodeClipSaved("5f0c2a9e41d3b7c86a1e0f42"), map[string]interface{}{
+		"id": "5f0c2a9e41d3b7c86a1e0f42",
+	}))
+
+	longMsg := strings.Repeat("e", 300)
+	for _, e := range []struct {
+		name string
+		code uint8
+		msg  string
+		want string
+	}{
+		{"error/room-full", network.ErrorCodeRoomFull, "Server full", "Server full"},
+		{"error/kicked", network.ErrorCodeKicked, "Speed hack detected", "Speed hack detected"},
+		{"error/truncated", network.ErrorCodeServerError, longMsg, longMsg[:255]},
+		{"error/too-large", network.ErrorCodeMessageTooLarge, "message 0x02 exceeds 258 bytes", "message 0x02 exceeds 258 bytes"},
+	} {
+		vectors = append(vectors, serverVector(e.name, proto.EncodeError(e.code, e.msg), map[string]interface{}{
+			"code":    e.code,
+			"message": e.want,
+		}))
+	}
+
+	return vectors, nil
+}
+
+// encodeJoin mirrors the client's join encoding.
+func encodeJoin(name string, color uint8) []byte {
+	nameBytes := []byte(name)
+	data := make([]byte, 3+len(nameBytes))
+	data[0] = network.MsgTypeJoinRoom
+	data[1] = uint8(len(nameBytes))
+	copy(data[2:], nameBytes)
+	data[2+len(nameBytes)] = color
+	return data
+}
+
+func clientVector(name string, data []byte, fields map[string]interface{}) Vector {
+	return Vector{Name: name, Direction: "client", Type: data[0], Hex: hex.EncodeToString(data), Fields: fields}
+}
+
+func serverVector(name string, data []byte, fields map[string]interface{}) Vector {
+	return Vector{Name: name, Direction: "server", Type: data[0], Hex: hex.EncodeToString(data), Fields: fields}
+}
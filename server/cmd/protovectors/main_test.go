@@ -0,0 +1,27 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+// TestVectorsUpToDate re-encodes every vector with network.Protocol and
+// checks the checked-in file matches, as -check does
+func TestVectorsUpToDate(t *testing.T) {
+	vectors, err := buildVectors()
+	if err != nil {
+		t.Fatalf("build vectors: %v", err)
+	}
+	data, err := marshalVectors(vectors)
+	if err != nil {
+		t.Fatalf("marshal vectors: %v", err)
+	}
+	existing, err := os.ReadFile("../../../protocol/vectors.json")
+	if err != nil {
+		t.Fatalf("read vectors: %v", err)
+	}
+	if !bytes.Equal(existing, data) {
+		t.Error("protocol/vectors.json is out of date: run 'go run ./cmd/protovectors' and commit the result")
+	}
+}
@@ -0,0 +1,207 @@
+// Command soak runs a headless stability test: rooms populated entirely by
+// bots are simulated at accelerated time for as long as requested, while the
+// process watches heap growth, goroutine counts and physics tick overruns.
+//
+// Rooms are stepped directly instead of by their real-time game loop, so an
+// hour of play takes a fraction of an hour. Bots are churned (removed and
+// re-added) to exercise the join/leave paths as well as the physics loop.
+// The command exits non-zero if memory or goroutines keep growing past the
+// configured limits.
+//
+// Usage:
+//
+//	go run ./cmd/soak -duration 2h -rooms 20 -bots 30
+//	go run ./cmd/soak -duration 10m -speed 4   # 4x real time instead of flat out
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/game"
+)
+
+// out is the soak's own report log, independent of the game's logging
+var out = log.New(os.Stdout, "", log.LstdFlags)
+
+// sample is one periodic resource measurement
+type sample struct {
+	at         time.Duration // Wall time since start
+	simulated  time.Duration // Simulated game time since start
+	heapMB     float64
+	goroutines int
+	overruns   uint64
+}
+
+func main() {
+	duration := flag.Duration("duration", time.Hour, "wall-clock time to run")
+	rooms := flag.Int("rooms", 10, "number of rooms")
+	bots := flag.Int("bots", 20, "bots per room")
+	speed := flag.Float64("speed", 0, "simulation speed as a multiple of real time (0 = as fast as possible)")
+	churn := flag.Float64("churn", 0.05, "probability per simulated second that a room replaces one bot")
+	report := flag.Duration("report", time.Minute, "interval between resource reports")
+	maxHeapGrowth := flag.Float64("max-heap-growth", 64, "fail if the heap grows by more than this many MB")
+	maxGoroutineGrowth := flag.Int("max-goroutine-growth", 10, "fail if goroutines grow by more than this")
+	verbose := flag.Bool("v", false, "show the game's own logging (joins, explosions, ...)")
+	flag.Parse()
+
+	// The game logs every join and explosion; keep the soak report readable
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	if *rooms <= 0 || *bots <= 0 || *bots > config.MaxPlayersPerRoom {
+		out.Fatalf("Invalid room setup: %d rooms x %d bots (max %d per room)", *rooms, *bots, config.MaxPlayersPerRoom)
+	}
+
+	profiles := make([]game.BotProfile, 0, len(game.DefaultBotProfiles))
+	for _, p := range game.DefaultBotProfiles {
+		profiles = append(profiles, p)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	// Populate rooms. They are never started: the soak loop steps them.
+	roomList := make([]*game.Room, *rooms)
+	for i := range roomList {
+		room := game.NewRoom(fmt.Sprintf("soak-%d", i))
+		for b := 0; b < *bots; b++ {
+			if _, err := room.AddBot(profiles[rng.Intn(len(profiles))]); err != nil {
+				out.Fatalf("Failed to add bot: %v", err)
+			}
+		}
+		roomList[i] = room
+	}
+
+	const dt = 1.0 / float64(config.PhysicsTickRate)
+	tickInterval := time.Second / time.Duration(config.PhysicsTickRate)
+	broadcastEvery := config.PhysicsTickRate / config.NetworkBroadcastRate
+	churnPerTick := *churn * dt
+
+	out.Printf("Soak: %d rooms x %d bots for %v (speed %s)", *rooms, *bots, *duration, speedLabel(*speed))
+
+	start := time.Now()
+	var samples []sample
+	var ticks, overruns uint64
+	var worstTick time.Duration
+	nextReport := *report
+
+	for {
+		elapsed := time.Since(start)
+		if elapsed >= *duration {
+			break
+		}
+
+		// One physics tick for every room, broadcasting at the network rate
+		tickStart := time.Now()
+		broadcast := ticks%uint64(broadcastEvery) == 0
+		for _, room := range roomList {
+			room.Step(dt, broadcast)
+
+			if rng.Float64() < churnPerTick {
+				churnBot(room, profiles[rng.Intn(len(profiles))], rng)
+			}
+		}
+		ticks++
+
+		// A tick that takes longer than the real tick interval would make a
+		// live server fall behind
+		if took := time.Since(tickStart); took > tickInterval {
+			overruns++
+			if took > worstTick {
+				worstTick = took
+			}
+		}
+
+		if elapsed >= nextReport {
+			s := measure(elapsed, ticks, overruns)
+			samples = append(samples, s)
+			out.Printf("t=%v sim=%v heap=%.1fMB goroutines=%d overruns=%d",
+				s.at.Round(time.Second), s.simulated.Round(time.Second), s.heapMB, s.goroutines, s.overruns)
+			nextReport += *report
+		}
+
+		// Throttle to the requested speed
+		if *speed > 0 {
+			simulated := time.Duration(float64(ticks) * float64(tickInterval) / *speed)
+			if ahead := simulated - time.Since(start); ahead > 0 {
+				time.Sleep(ahead)
+			}
+		}
+	}
+
+	samples = append(samples, measure(time.Since(start), ticks, overruns))
+	if !evaluate(samples, worstTick, *maxHeapGrowth, *maxGoroutineGrowth) {
+		os.Exit(1)
+	}
+}
+
+// churnBot replaces a random bot so join/leave paths are exercised.
+func churnBot(room *game.Room, profile game.BotProfile, rng *rand.Rand) {
+	bots := room.Bots()
+	if len(bots) == 0 {
+		return
+	}
+	room.RemoveBot(bots[rng.Intn(len(bots))].ID)
+	if _, err := room.AddBot(profile); err != nil {
+		out.Printf("Failed to re-add bot: %v", err)
+	}
+}
+
+// measure takes a resource sample after a garbage collection, so heap
+// numbers reflect live memory rather than garbage.
+func measure(elapsed time.Duration, ticks, overruns uint64) sample {
+	runtime.GC()
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	return sample{
+		at:         elapsed,
+		simulated:  time.Duration(ticks) * time.Second / time.Duration(config.PhysicsTickRate),
+		heapMB:     float64(mem.HeapAlloc) / (1 << 20),
+		goroutines: runtime.NumGoroutine(),
+		overruns:   overruns,
+	}
+}
+
+// evaluate prints the summary and reports whether the run passed.
+// Growth is measured from the first sample, after the rooms warmed up.
+func evaluate(samples []sample, worstTick time.Duration, maxHeapGrowth float64, maxGoroutineGrowth int) bool {
+	first, last := samples[0], samples[len(samples)-1]
+	heapGrowth := last.heapMB - first.heapMB
+	goroutineGrowth := last.goroutines - first.goroutines
+
+	fmt.Printf("Simulated %v in %v\n", last.simulated.Round(time.Second), last.at.Round(time.Second))
+	fmt.Printf("Heap: %.1fMB -> %.1fMB (%+.1fMB)\n", first.heapMB, last.heapMB, heapGrowth)
+	fmt.Printf("Goroutines: %d -> %d (%+d)\n", first.goroutines, last.goroutines, goroutineGrowth)
+	fmt.Printf("Tick overruns: %d (worst %v)\n", last.overruns, worstTick)
+
+	ok := true
+	if heapGrowth > maxHeapGrowth {
+		fmt.Printf("FAIL: heap grew by %.1fMB (limit %.1fMB)\n", heapGrowth, maxHeapGrowth)
+		ok = false
+	}
+	if goroutineGrowth > maxGoroutineGrowth {
+		fmt.Printf("FAIL: goroutines grew by %d (limit %d)\n", goroutineGrowth, maxGoroutineGrowth)
+		ok = false
+	}
+	if ok {
+		fmt.Println("PASS")
+	}
+	return ok
+}
+
+func speedLabel(speed float64) string {
+	if speed <= 0 {
+		return "max"
+	}
+	return fmt.Sprintf("%gx", speed)
+}
@@ -38,11 +38,82 @@ const (
 	SpeedDiffMultiplier = 3.5
 	SpeedDiffThreshold  = 200.0
 	CollisionRadius     = CarWidth * 1.4
+	SoftCollisionFactor = 0.3 // Of the push and slowdown, in rooms with soft collisions
+
+	// Collision events (ProtocolV24): contacts with an impact (relative
+	// speed) of CollisionEventMinImpact or more are announced, at most once
+	// per CollisionEventCooldown for each car
+	CollisionEventMinImpact = 40.0 // Units per second
+	CollisionEventCooldown  = 250 * time.Millisecond
 
 	// Road Generation
 	RoadScale     = 0.001
 	RoadAmplitude = 600.0
 
+	// Lanes (see track.Track.Lane): the built-in road is split into RoadLanes
+	// lanes of equal width. A car changes lanes once its center is
+	// LaneHysteresis past the lane line, so driving on the line doesn't flap.
+	RoadLanes      = 4
+	LaneHysteresis = CarWidth / 2
+
+	// Elevation and banking (see track.Track.Grade and Bank): a car on a
+	// grade g loses Gravity*g of speed per second (gains it downhill); in a
+	// bend banked b, understeer and the speed lost to steering shrink by the
+	// fraction b. Bends are fully banked from a curvature (d²X/dY² of the
+	// road center) of BankCurvatureFull. Must match the client.
+	Gravity           = 900.0
+	BankCurvatureFull = 0.0005
+
+	// Jump ramps (see track.Track.Launch): a car leaves a ramp's lip at the
+	// ramp's height, climbing at its speed times the ramp's grade, and falls
+	// under Gravity until it lands. Landing costs LandingLoss of the speed per
+	// unit of vertical speed, at most LandingLossMax. Must match the client.
+	LandingLoss    = 0.0005
+	LandingLossMax = 0.5
+
+	// Fuel mode (see game/fuel.go): a full tank holds FuelCapacity and full
+	// throttle burns FuelBurn per second. Pit zones, the last PitLength units
+	// of every PitSpacing units of road, refill FuelRefill per second to cars
+	// on the road in them. With the tank empty a car is held to FuelDrySpeed.
+	// Must match the client.
+	FuelCapacity = 100.0
+	FuelBurn     = 4.0
+	FuelRefill   = 60.0
+	FuelDrySpeed = 250.0
+	PitSpacing   = 25000.0
+	PitLength    = 800.0
+
+	// Endless mode (see game/milestones.go): every MilestoneDistance units of
+	// a run earn MilestoneBonus rating, and a run rated PrestigeMinRating or
+	// more can be reset for prestige
+	MilestoneDistance = 25000.0
+	MilestoneBonus    = 500.0
+	PrestigeMinRating = 20000.0
+
+	// Relay mode (see game/relay.go): teams of up to RelayTeamSize humans
+	// take turns driving one car's legs of RelayLegDistance units each
+	RelayTeamSize    = 3
+	RelayLegDistance = 5000.0
+
+	// Race mode (see game/race.go): a race is RaceLaps laps of RaceLapLength
+	// units of road, each split by RaceCheckpoints checkpoints, the lap's
+	// line last. It starts once RaceMinPlayers humans are in the room, after
+	// a RaceCountdown on the grid (rows RaceGridSpacing apart), and ends
+	// once every racer has finished, RaceFinishGrace after the first
+	// finish, or after RaceTimeLimit. Standings go out every
+	// RaceLeaderboardInterval; the next countdown starts RaceResultsTime
+	// after the end.
+	RaceLaps                = 3
+	RaceLapLength           = 25000.0
+	RaceCheckpoints         = 4
+	RaceMinPlayers          = 2
+	RaceCountdown           = 5 * time.Second
+	RaceGridSpacing         = 60.0
+	RaceFinishGrace         = 30 * time.Second
+	RaceTimeLimit           = 5 * time.Minute
+	RaceResultsTime         = 10 * time.Second
+	RaceLeaderboardInterval = time.Second
+
 	// Room settings
 	MaxPlayersPerRoom = 100
 	MaxRoomsPerServer = 50
@@ -50,8 +121,443 @@ const (
 	// Anti-cheat
 	MaxViolations      = 5
 	SpeedTolerance     = 1.1 // 10% tolerance
+	SteeringTolerance  = 1.1 // Over full-lock sideways movement per tick
 	MaxInputsPerTick   = 3
 
+	// Input sequence numbers (see game/sequence.go): a jump ahead by more than
+	// InputSequenceMaxGap is a gap; in kick mode, more than
+	// InputSequenceAnomalyLimit gaps, duplicates and reordered inputs within
+	// InputSequenceWindow get the player kicked
+	InputSequenceMaxGap       = 32
+	InputSequenceAnomalyLimit = 20
+	InputSequenceWindow       = 10 * time.Second
+
+	// Honeypot rooms (see game/honeypot.go, ANTICHEAT_POLICY=honeypot): each
+	// starts with HoneypotBots bots and keeps the evidence of its last
+	// HoneypotEvidenceMax flagged players. Past HoneypotRoomsMax honeypot
+	// rooms, or with every one full, cheaters are kicked.
+	HoneypotBots        = 6
+	HoneypotRoomsMax    = 5
+	HoneypotEvidenceMax = 50
+
+	// Frame-budget watchdog (see game/watchdog.go): a room whose ticks
+	// overran their interval in TickBudgetOverrunPercent percent of a window
+	// of TickBudgetWindow ticks is quarantined. QuarantineTraceLength is the
+	// execution trace taken of the process then, and WebhookTimeout bounds
+	// the call to QUARANTINE_WEBHOOK.
+	TickBudgetWindow         = 600 // 10 s at 60 Hz
+	TickBudgetOverrunPercent = 50
+	QuarantineTraceLength    = 2 * time.Second
+	WebhookTimeout           = 5 * time.Second
+
+	// Inbound flood protection (per connection, all message types)
+	InboundMessageRate  = 30  // Sustained messages per second
+	InboundMessageBurst = 60  // Token bucket size
+	FloodDropLimit      = 150 // Dropped messages per FloodWindow before disconnect
+	FloodWindow         = 10 * time.Second
+
+	// Session changes (hello, join, leave, reset, host kick) have their own
+	// per-connection limit, see cmd/gameserver/handlers.go
+	ControlMessageRate  = 2 // Sustained messages per second
+	ControlMessageBurst = 10
+
+	// Voice chat signaling (protocol v13) relayed between players has its own
+	// per-connection limit; setting up a peer connection sends a burst of
+	// ICE candidates
+	SignalMessageRate  = 10 // Sustained messages per second
+	SignalMessageBurst = 40
+
+	// Name and color changes in a room (protocol v29) have their own
+	// per-connection limit: each one is broadcast to the whole room
+	ProfileUpdateRate  = 0.2 // Sustained changes per second
+	ProfileUpdateBurst = 3
+
+	// Creating private rooms and joining them by code (protocol v30) have
+	// their own per-connection limit, which keeps guessing codes slow
+	RoomCodeRate  = 0.5 // Sustained attempts per second
+	RoomCodeBurst = 5
+
+	// Highlight clips (protocol v36) have their own per-connection limit:
+	// each one is written to the store
+	ClipRequestRate  = 0.1 // Sustained clips per second
+	ClipRequestBurst = 2
+
+	// Outbound queues of a connection, by priority class (see
+	// network/outqueue.go): their capacities, and how many messages of
+	// higher classes a waiting class lets go first
+	OutboundCriticalQueue   = 64
+	OutboundHighQueue       = 256
+	OutboundNormalQueue     = 64
+	OutboundLowQueue        = 16
+	OutboundStarvationLimit = 8
+
+	// Players a connection can mute for voice chat (protocol v27)
+	MutesMax = 100
+
+	// Agent sessions (see cmd/gameserver/agent.go): open at once, ticks a
+	// training step may advance, and frames a live session's stream may
+	// fall behind before the room drops frames
+	AgentSessionsMax = 8
+	AgentStepMax     = 600
+	AgentFrameBuffer = 64
+
+	// Kick penalties: rejoin cooldown doubles with each kick inside the decay window
+	KickCooldownBase = 30 * time.Second
+	KickCooldownMax  = 1 * time.Hour
+	KickOffenseDecay = 24 * time.Hour
+
+	// Client fingerprints (see moderation/fingerprint.go): input cadence is
+	// sampled over FingerprintInputs intervals; connections at least
+	// FingerprintThreshold alike a kicked player are flagged for moderators
+	FingerprintInputs     = 32
+	FingerprintThreshold  = 0.85
+	FingerprintRetention  = 7 * 24 * time.Hour
+	FingerprintMaxRecords = 1000
+
+	// Client telemetry (see cmd/gameserver/telemetry.go): a player is probed
+	// at random every TelemetryIntervalMin to TelemetryIntervalMax for the
+	// times of their last TelemetryFrames frames, and has TelemetryTimeout
+	// to answer. A missing answer adds TelemetryMissingWeight to the
+	// connection's suspicion, an implausible one TelemetryImplausibleWeight,
+	// up to TelemetrySuspicionMax; a plausible one takes
+	// TelemetryMissingWeight off. Plausible frames take at least
+	// TelemetryFrameMinMS and vary by at least TelemetryFrameJitterMinMS.
+	TelemetryIntervalMin       = 20 * time.Second
+	TelemetryIntervalMax       = 60 * time.Second
+	TelemetryTimeout           = 5 * time.Second
+	TelemetryFrames            = 30
+	TelemetryMissingWeight     = 1.0
+	TelemetryImplausibleWeight = 2.0
+	TelemetrySuspicionMax      = 4.0
+	TelemetryFrameMinMS        = 1.0
+	TelemetryFrameJitterMinMS  = 0.01
+
+	// DefaultTelemetryKey is the key the official client signs telemetry
+	// with, unless the deployment builds its client with another one
+	DefaultTelemetryKey = "vracer-telemetry-v1"
+
+	// Latency: the server pings each connection to measure smoothed RTT,
+	// and rooms broadcast a scoreboard with every player's RTT
+	RTTPingInterval    = 2 * time.Second
+	ScoreboardInterval = 2 * time.Second
+
+	// Interpolation delay recommended to clients (protocol v11): InterpJitterFactor
+	// times their jitter, in InterpDelayStep steps up to InterpDelayMax; none
+	// below InterpMinJitter. Re-sent when it moves by more than a step.
+	InterpJitterFactor = 2.0
+	InterpMinJitter    = 10 * time.Millisecond
+	InterpDelayStep    = 10 * time.Millisecond
+	InterpDelayMax     = 250 * time.Millisecond
+
+	// Proximity groups (protocol v14, see game/proximity.go): clients learn
+	// the cars within NearbyRadius of theirs, checked every NearbyInterval
+	NearbyRadius   = 600.0
+	NearbyInterval = 500 * time.Millisecond
+
+	// Broadcast director (protocol v16, see game/director.go): every
+	// DirectorInterval it picks the car spectators' cameras follow, holding a
+	// shot at least DirectorMinShot unless a more important one comes up.
+	// Cars within DirectorCollisionRange that would touch within
+	// DirectorCollisionHorizon are a collision shot; the two leaders within
+	// DirectorBattleGap in the last DirectorFinalStretch of a round are a
+	// battle, and crashes of the top DirectorCrashTop are shown. Spectate
+	// tokens are valid for SpectateTokenTTL and a room takes at most
+	// MaxSpectatorsPerRoom.
+	DirectorInterval         = 250 * time.Millisecond
+	DirectorMinShot          = 3 * time.Second
+	DirectorCollisionRange   = 300.0
+	DirectorCollisionHorizon = 1.0 // Seconds
+	DirectorBattleGap        = 200.0
+	DirectorFinalStretch     = 30 * time.Second
+	DirectorCrashTop         = 3
+	SpectateTokenTTL         = 12 * time.Hour
+	MaxSpectatorsPerRoom     = 16
+
+	// Open spectating (protocol v32, see game/spectate.go): any client may
+	// watch a public room, at most MaxViewersPerRoom of them besides the
+	// broadcast spectators, with state updates at up to ViewerStateRate Hz
+	MaxViewersPerRoom = 32
+	ViewerStateRate   = 10
+
+	// Longest delay spectators of a room may see it behind the racers (see
+	// game.RoomConfig); the room buffers that much of its state updates
+	MaxSpectatorDelay = 2 * time.Minute
+
+	// Highlight clips (protocol v36, see game/clip.go): each room keeps its
+	// last ClipLength of state, ClipRate frames per second. A clip holds the
+	// cars within ClipRadius (along the road) of the player asking for it
+	// and is kept in the store for ClipTTL
+	ClipLength = 30 * time.Second
+	ClipRate   = 10
+	ClipRadius = 1500.0
+	ClipTTL    = 30 * 24 * time.Hour
+
+	// Room quality (from client performance reports in pings)
+	PerfReportMaxAge   = 30 * time.Second // Older reports are ignored
+	QualityMinFPS      = 30.0             // Rooms averaging below this are flagged
+	QualityMaxJitterMS = 80.0             // Rooms averaging above this are flagged
+
+	// Bots: players closer than BotSenseRadius (along the road) influence a
+	// bot's line; bots further than BotRubberBandDistance from the nearest
+	// human slow down (ahead) or speed up (behind)
+	BotSenseRadius        = 300.0
+	BotRubberBandDistance = 800.0
+	BotRubberBandStrength = 0.5
+
+	// Bot lane changes: a bot keeps to its lane until a slower car is less
+	// than BotLaneGap ahead in it, then moves to an adjacent lane with no car
+	// within BotLaneClearance (ahead or behind); it changes lanes at most once
+	// per BotLaneChangeCooldown of simulated time
+	BotLaneGap            = 250.0
+	BotLaneClearance      = 120.0
+	BotLaneChangeCooldown = 2 * time.Second
+
+	// Bot pit stops (fuel mode): a bot with less than BotPitFuel of a tank
+	// slows to BotPitSpeed through a pit zone until its tank is BotPitFull
+	BotPitFuel  = 0.5
+	BotPitFull  = 0.95
+	BotPitSpeed = 300.0
+
+	// Leaderboard
+	LeaderboardSeasonLength = 28 * 24 * time.Hour // Seasons are aligned to the Unix epoch
+	LeaderboardMaxEntries   = 10000               // Live board is trimmed to the best N players
+
+	// Scoring: a run's leaderboard score is its rating times the difficulty
+	// of its room averaged over the run (see game/scoring.go). Each other car
+	// on the road (up to ScoringCrowdMax) adds ScoringCrowdStep times the
+	// skill factor: the other drivers' average skill relative to
+	// ScoringSkillBaseline, within [ScoringSkillMin, ScoringSkillMax]. A
+	// driver's skill is a running average of their run ratings; drivers
+	// without a finished run count as the baseline, bots as ScoringBotSkill.
+	ScoringCrowdBase     = 0.8  // Multiplier alone on the road
+	ScoringCrowdStep     = 0.05 // Added per other car of average skill
+	ScoringCrowdMax      = 8
+	ScoringSkillBaseline = 3000.0 // Run rating of an average driver
+	ScoringSkillMin      = 0.5
+	ScoringSkillMax      = 1.5
+	ScoringBotSkill      = 1500.0
+	ScoringSkillWeight   = 0.3 // Weight of the latest run in a driver's skill
+
+	// Rounds: public rooms close a round every RoundLength of physics time
+	// and hand out awards (see game/round.go). Sector times are measured over
+	// RoundSectorLength units of road; the cleanest driver award needs at
+	// least RoundCleanMinDistance driven. Match history keeps the latest
+	// MatchHistoryPerPlayer rounds of each player for MatchHistoryTTL.
+	RoundLength           = 3 * time.Minute
+	RoundSectorLength     = 5000.0
+	RoundCleanMinDistance = 10000.0
+	MatchHistoryPerPlayer = 20
+	MatchHistoryTTL       = 90 * 24 * time.Hour
+
+	// Player profiles (/api/players) are cached for ProfileCacheTTL, at
+	// most ProfileCacheMax of them, to spare the store
+	ProfileCacheTTL     = 30 * time.Second
+	ProfileCacheMax     = 1000
+	ProfileRecentRounds = 10
+
+	// Store outbox (see internal/outbox): writes that failed while the store
+	// was down are retried every OutboxRetryInterval, the newest
+	// OutboxMaxEntries of them for up to OutboxMaxAge
+	OutboxRetryInterval = 15 * time.Second
+	OutboxMaxEntries    = 10000
+	OutboxMaxAge        = 24 * time.Hour
+
+	// Store writers: StoreWriters goroutines make the store writes rooms
+	// hand off, which wait in a queue of StoreWriteQueue (see
+	// cmd/gameserver/writer.go)
+	StoreWriters    = 2
+	StoreWriteQueue = 256
+
+	// Placement: a name's first PlacementRounds recorded rounds place it
+	// (see history/placement.go). A placement round with PlacementMinDriving
+	// seconds on the road stands out when rating came in faster than
+	// PlacementRatingRate per second driven (sustained speed near the top)
+	// with one more sign of experience: at most PlacementMaxCrashRate
+	// crashes per 100000 units, or a speed spread (standard deviation over
+	// mean) below PlacementMaxSpeedSpread. PlacementStandouts standout rounds
+	// flag the player for review and calibrate their skill, which then
+	// weighs each run with PlacementSkillWeight. Flagged names are listed
+	// for review, newest PlacementReviewMax.
+	PlacementRounds         = 10
+	PlacementMinDriving     = 60.0 // Seconds
+	PlacementRatingRate     = 70.0 // Rating per second driven
+	PlacementMaxCrashRate   = 1.0  // Crashes per 100000 units
+	PlacementMaxSpeedSpread = 0.08
+	PlacementStandouts      = 2
+	PlacementSkillWeight    = 0.8
+	PlacementReviewMax      = 1000
+
+	// World rebasing: once every car of a room is WorldRebaseDistance down
+	// the road, the room moves its origin forward by that much (see
+	// game/rebase.go). Must be a multiple of RoundSectorLength.
+	WorldRebaseDistance = 1000000.0
+
+	// Cluster directory: servers announce themselves every
+	// DirectoryHeartbeat; an entry not refreshed within DirectoryTTL expires
+	DirectoryHeartbeat = 10 * time.Second
+	DirectoryTTL       = 30 * time.Second
+
+	// Autoscaling signal (see cluster/autoscale.go): the cluster shrinks
+	// only once its servers would stay AutoscaleHysteresis below the target
+	// occupancy
+	AutoscaleHysteresis = 0.1
+
+	// Room registry: servers publish their rooms with each announcement,
+	// and keep RoomIDsAhead IDs claimed for the rooms they open next
+	RoomIDsAhead = 4
+
+	// Match allocation: a room reserved for a match (and its tickets) stays
+	// reserved for AllocationTTL. The Agones sidecar gets a health ping
+	// every AgonesHealthInterval.
+	AllocationTTL        = 2 * time.Minute
+	AgonesHealthInterval = 5 * time.Second
+
+	// Tournament rooms (see cmd/gameserver/tournament.go): event secrets are
+	// at least EventSecretMinLen bytes, and a connection that fails the
+	// challenge EventAuthMaxFailures times gets no more challenges
+	EventSecretMinLen    = 16
+	EventAuthMaxFailures = 3
+
+	// Account links (see cmd/gameserver/accounts.go): a link token is valid
+	// for LinkTokenTTL
+	LinkTokenTTL = 10 * time.Minute
+
+	// Rejoining (see game/rejoin.go): a room keeps the progress of a player
+	// who left for RejoinWindow, for at most DepartedPlayersMax players. The
+	// session tokens that bring players back are valid for SessionTokenTTL.
+	RejoinWindow       = 60 * time.Second
+	DepartedPlayersMax = 32
+	SessionTokenTTL    = 12 * time.Hour
+
+	// Dead reckoning (protocol v3): a player's record is left out of a state
+	// update while the receiver's extrapolation of the last sent record is
+	// within DeadReckoningMaxError, but at least every DeadReckoningKeyframe.
+	// DeadReckoningLateralLimit must match the client's
+	// LATERAL_EXTRAPOLATION_LIMIT.
+	DeadReckoningMaxError     = 2.0 // World units
+	DeadReckoningKeyframe     = time.Second
+	DeadReckoningLateralLimit = 0.1 // Seconds
+
+	// Ram griefing: a collision is a ram strike against a car that drives
+	// against the traffic into another one, or stands still while a car
+	// closes in at RamMinClosingSpeed or more. RamStrikes strikes within
+	// RamWindow ghost the player for RamGhostDuration; doing it again within
+	// RamOffenseDecay gets them kicked. A contact with the same car counts
+	// once per RamContactCooldown; players are exempt for RamSpawnGrace
+	// after joining or respawning (they start at a standstill).
+	RamMinClosingSpeed = 300.0 // Units per second
+	RamParkedSpeed     = 60.0  // Forward speed below which a car counts as parked
+	RamStrikes         = 4
+	RamWindow          = 10 * time.Second
+	RamContactCooldown = time.Second
+	RamGhostDuration   = 20 * time.Second
+	RamOffenseDecay    = 5 * time.Minute
+	RamSpawnGrace      = 3 * time.Second
+
+	// Practice rooms: private rooms for one player, at most PracticeRoomsMax
+	// of them (they count towards MaxRoomsPerServer). Attempts are recorded
+	// for the replay car up to PracticeReplayMax.
+	PracticeRoomsMax  = 20
+	PracticeReplayMax = 5 * time.Minute
+
+	// Private rooms: rooms players join with a code (see
+	// matchmaker/private.go), at most PrivateRoomsMax of them (they count
+	// towards MaxRoomsPerServer)
+	PrivateRoomsMax = 50
+
+	// QA rooms: the worst network conditions a tester can have the server
+	// simulate on their connection (see cmd/gameserver/netsim.go)
+	NetSimMaxLatency = 2 * time.Second
+	NetSimMaxJitter  = 500 * time.Millisecond
+	NetSimMaxLoss    = 50 // Percent of state updates
+
+	// Custom tracks (map editor): limits of a submitted definition. A track
+	// must stay drivable: its road may not veer more than TrackSlopeMax units
+	// sideways per unit forward anywhere in the first TrackCheckLength units.
+	TrackNameMaxLen    = 32
+	TrackCurvesMax     = 6
+	TrackWidthMin      = 250.0
+	TrackWidthMax      = 600.0
+	TrackAmplitudeMax  = 1500.0
+	TrackWavelengthMin = 1000.0
+	TrackWavelengthMax = 100000.0
+	TrackSharpnessMax  = 7 // Odd exponents only: 1, 3, 5, 7
+	TrackLanesMax      = 8
+	TrackLaneWidthMin  = 2 * CarWidth
+	TrackSlopeMax      = 1.5
+	TrackCheckLength   = 200000.0
+	TrackVersionsMax   = 50
+	TrackBodyMax       = 16 << 10 // Bytes of a submission
+
+	// Submissions of custom tracks (new tracks and versions): an address may
+	// make TrackSubmitBurst in a row, then TrackSubmitRate a second, and no
+	// track is created while TrackUnapprovedMax await an operator's approval
+	TrackSubmitRate    = 1.0 / 60
+	TrackSubmitBurst   = 5
+	TrackUnapprovedMax = 500
+
+	// Elevation of custom tracks: at most TrackElevationCurvesMax curves of
+	// height, none steeper than TrackGradeMax anywhere
+	TrackElevationCurvesMax = 3
+	TrackElevationMax       = 400.0 // Height amplitude
+	TrackGradeMax           = 0.25  // Height per unit forward
+
+	// Jump ramps of custom tracks: at most TrackRampsMax, each rising to
+	// TrackRampHeightMin-Max over at least TrackRampLengthMin units, no
+	// steeper than TrackRampGradeMax, repeating every TrackRampSpacingMin
+	// units or more
+	TrackRampsMax       = 2
+	TrackRampLengthMin  = 100.0
+	TrackRampHeightMin  = 10.0
+	TrackRampHeightMax  = 150.0
+	TrackRampGradeMax   = 0.5
+	TrackRampSpacingMin = 3000.0
+
+	// Escalating roads (see track.Track.WithEscalation): the bends of rooms
+	// that escalate swing wider by TrackEscalationRate of their amplitude
+	// per unit of road, up to TrackEscalationMax more. Sent to clients.
+	TrackEscalationRate = 1.0 / 500000
+	TrackEscalationMax  = 0.75
+
+	// Startup self-test (see cmd/gameserver/selftest.go): a room full of
+	// bots is simulated headless for SelfTestSimulation before the server
+	// listens, and a store round trip must finish within SelfTestStoreTimeout
+	SelfTestSimulation   = time.Second
+	SelfTestStoreTimeout = 5 * time.Second
+
+	// Room snapshots (see game/roomsnapshot.go): a running room is captured
+	// at the end of its next tick, or right away after SnapshotWait. An
+	// imported snapshot is at most SnapshotBodyMax bytes.
+	SnapshotWait    = time.Second
+	SnapshotBodyMax = 1 << 20
+
+	// Stats trends (see cmd/gameserver/trends.go): the server samples its
+	// load every TrendSampleInterval and keeps TrendHistory of samples for
+	// the rolling windows of /stats
+	TrendSampleInterval = 10 * time.Second
+	TrendHistory        = time.Hour
+
+	// Daily report (see cmd/gameserver/report.go): the day so far is saved
+	// every DailyReportSave, and finished days are kept for
+	// DailyReportRetention. Up to DailyReportErrorKinds errors are counted
+	// apart, the rest as "other"; the report ranks the DailyReportTopErrors
+	// most frequent.
+	DailyReportSave       = 5 * time.Minute
+	DailyReportRetention  = 30 * 24 * time.Hour
+	DailyReportErrorKinds = 50
+	DailyReportTopErrors  = 10
+
+	// Crash reports (see internal/crash): one per panic signature per
+	// CrashReportWindow, at most CrashReportsPerWindow in a window
+	CrashReportWindow     = 10 * time.Minute
+	CrashReportsPerWindow = 5
+
+	// Room rules scripts (see internal/rules): each hook call may run
+	// RulesMaxSteps interpreter steps; a script ghosts a car for at most
+	// RulesGhostMax at a time
+	RulesMaxSteps = 100000
+	RulesGhostMax = 10 * time.Minute
+
 	// Respawn
 	RespawnDelay = 2500 * time.Millisecond // 2.5 seconds
 )
@@ -60,8 +566,189 @@ const (
 type ServerConfig struct {
 	Host       string
 	Port       int
-	RedisURL   string
 	EnableCORS bool
+
+	// TrustProxyHeaders takes the client address from X-Real-IP/X-Forwarded-For
+	// of connections from TrustedProxies (comma-separated CIDRs, loopback by
+	// default). Enable only when running behind a reverse proxy that sets
+	// these headers: anyone else could send any address in them.
+	TrustProxyHeaders bool
+	TrustedProxies    string
+
+	// AdminToken enables the /admin/ API (bearer token). Empty disables it.
+	AdminToken string
+
+	// Moderation: optional rules file loaded at startup, and optional
+	// external moderation API consulted for names and chat
+	ModerationRulesFile string
+	ModerationAPIURL    string
+	BotProfilesFile     string // JSON array of bot personalities (merged over defaults)
+	TenantsFile         string // JSON array of tenants besides the default one
+	RulesDir            string // Room rules scripts (*.star), see internal/rules
+
+	// DataDir holds persistent server data (leaderboard seasons)
+	DataDir string
+
+	// Shared state backend: "memory" (standalone), "redis" or "sql"
+	// (clustered), or a plugin's (see internal/plugin)
+	StoreBackend string
+	StoreURL     string // Redis URL or PostgreSQL connection string
+
+	// StoreFaultDelay slows every store call down by this much, to check
+	// that a slow store never holds up the game (0: off)
+	StoreFaultDelay time.Duration
+
+	// TokenSecret signs session, invite and matchmaking tokens. Must be the
+	// same on every server of a cluster; random per process if empty.
+	TokenSecret string
+
+	// ResultsSigningKey certifies match results and leaderboard entries
+	// (base64 Ed25519 seed, see internal/certify). Should be the same on
+	// every server of a cluster; random per process if empty.
+	ResultsSigningKey string
+
+	// PayloadKey lets clients encrypt their payloads end to end (base64
+	// X25519 private key, see network/encryption.go; "": they can't), and
+	// PayloadRequired refuses the connections that don't
+	PayloadKey      string
+	PayloadRequired bool
+
+	// MaxConnections bounds open WebSocket connections (players, queued and idle)
+	MaxConnections int
+
+	// Join queue while the server is at capacity (0 = reject with "Server full")
+	JoinQueueLength  int
+	JoinQueueTimeout time.Duration
+
+	// Default rates of new rooms (Hz). Clients before protocol v4 only
+	// support the standard physics rate.
+	PhysicsTickRate int
+	BroadcastRate   int
+
+	// RebaseDistance of new rooms in world units (0: never rebase)
+	RebaseDistance float64
+
+	// SpectatorDelay of new rooms in seconds (0: spectators watch live)
+	SpectatorDelay int
+
+	// FuelMode makes new public rooms run on fuel (see game/fuel.go)
+	FuelMode bool
+
+	// RelayMode makes new public rooms relay races (see game/relay.go)
+	RelayMode bool
+
+	// RaceMode makes new public rooms race laps to a finish (see
+	// game/race.go)
+	RaceMode bool
+
+	// Collisions is how the cars of new public rooms collide: "on", "soft"
+	// (weaker pushes) or "off" (see game/collisionmode.go)
+	Collisions string
+
+	// RoomRules names the rules script of new public rooms in RulesDir, or
+	// a plugin's game mode ("": the plain race)
+	RoomRules string
+
+	// Plugin extensions by name (see internal/plugin): the scoring policy
+	// of leaderboards ("": the built-in one) and extra anti-cheat checks
+	ScoringPolicy  string
+	AntiCheatRules []string
+
+	// SuspendEmptyRooms pauses a room's game loop while it has no human
+	// players; the next join resumes it
+	SuspendEmptyRooms bool
+
+	// SimulationWorkers caps how many rooms run physics at once, and so the
+	// CPUs the simulation takes (0: one per CPU)
+	SimulationWorkers int
+
+	// InputSequenceMode enforces input sequence numbers: "off", "monitor",
+	// "drop" (ignore duplicate and reordered inputs) or "kick"
+	InputSequenceMode string
+
+	// AntiCheatPolicy is what happens to players anti-cheat would kick from
+	// a room: "kick", or "honeypot" to move them to a honeypot room of bots
+	// and other flagged players
+	AntiCheatPolicy string
+
+	// ClientTelemetry probes players for signed telemetry samples whose
+	// absence or implausibility weighs against them in anti-cheat (see
+	// cmd/gameserver/telemetry.go); TelemetryKey is the key their client
+	// signs them with
+	ClientTelemetry bool
+	TelemetryKey    string
+
+	// SessionPolicy is what happens when an account links on a second
+	// connection: "kick" the first one, or "reject" the new link
+	SessionPolicy string
+
+	// StateCodec is how state updates go on the wire: "records", the
+	// records of the client's protocol version, or "deflate", those
+	// compressed with permessage-deflate for clients that offer it
+	StateCodec string
+
+	// StatsdAddr is a statsd or DogStatsD agent (host:port, UDP) the server
+	// pushes its metrics to every StatsdInterval ("": none), named
+	// StatsdPrefix.<metric>; StatsdTags are DogStatsD tags for every metric
+	StatsdAddr     string
+	StatsdPrefix   string
+	StatsdTags     []string
+	StatsdInterval time.Duration
+
+	// MessagePriorities changes the priority classes of outbound message
+	// types, as "0x26=high,0x27=normal" (see network/outqueue.go)
+	MessagePriorities string
+
+	// Panics are reported to CrashReportDSN, a Sentry-compatible project
+	// DSN, or else appended to CrashReportFile ("": crashes.jsonl in
+	// DataDir)
+	CrashReportDSN  string
+	CrashReportFile string
+
+	// DailyReportWebhook is POSTed the report of every day that ends ("":
+	// none, see cmd/gameserver/report.go)
+	DailyReportWebhook string
+
+	// QuarantineWebhook is POSTed the report of every room the watchdog
+	// quarantines ("": none); QuarantineTraceDir is where execution traces
+	// of the process at the time go ("": the temp directory);
+	// QuarantineMigrate moves a quarantined room's players to other rooms
+	QuarantineWebhook  string
+	QuarantineTraceDir string
+	QuarantineMigrate  bool
+
+	// HibernateAfter without connections stops every room and pauses the
+	// background tasks until the next connection (0: never hibernate)
+	HibernateAfter time.Duration
+
+	// InstanceID names the server in stats and the cluster directory;
+	// PublicAddr is where clients reach it, if not the bind address
+	InstanceID string
+	PublicAddr string
+
+	// Agones reports the server's state to the Agones SDK sidecar on
+	// localhost:AgonesPort
+	Agones     bool
+	AgonesPort int
+
+	// DrainTimeout bounds how long SIGTERM waits for players to leave before
+	// shutting down (0: shut down right away)
+	DrainTimeout time.Duration
+
+	// AutoscaleTarget is the share of the cluster's capacity /autoscale
+	// sizes it to run at, with AutoscaleMin to AutoscaleMax servers (0: no
+	// limit)
+	AutoscaleTarget float64
+	AutoscaleMin    int
+	AutoscaleMax    int
+
+	// SelfTest runs the store round trip and simulation smoke test at
+	// startup; the configuration is validated regardless
+	SelfTest bool
+
+	// Console takes operator commands from "stdin" or on a unix socket at
+	// this path ("": no console)
+	Console string
 }
 
 // DefaultServerConfig returns default server configuration
@@ -69,8 +756,30 @@ func DefaultServerConfig() *ServerConfig {
 	return &ServerConfig{
 		Host:       "0.0.0.0",
 		Port:       8080,
-		RedisURL:   "localhost:6379",
 		EnableCORS: true,
+
+		TrustedProxies:    "127.0.0.0/8,::1/128",
+		DataDir:           "data",
+		StoreBackend:      "memory",
+		JoinQueueTimeout:  2 * time.Minute,
+		MaxConnections:    5000,
+		SuspendEmptyRooms: true,
+		InputSequenceMode: "drop",
+		Collisions:        "on",
+		AntiCheatPolicy:   "kick",
+		ClientTelemetry:   true,
+		TelemetryKey:      DefaultTelemetryKey,
+		SessionPolicy:     "kick",
+		StateCodec:        "records",
+		StatsdPrefix:      "vracer",
+		StatsdInterval:    10 * time.Second,
+		PhysicsTickRate:   PhysicsTickRate,
+		BroadcastRate:     NetworkBroadcastRate,
+		RebaseDistance:    WorldRebaseDistance,
+		AgonesPort:        9358,
+		AutoscaleTarget:   0.7,
+		AutoscaleMin:      1,
+		SelfTest:          true,
 	}
 }
 
@@ -0,0 +1,79 @@
+// Package agones reports the server's state to the Agones SDK sidecar.
+//
+// Agones runs a sidecar next to every game server pod that exposes the SDK
+// as a REST API on localhost (port AGONES_SDK_HTTP_PORT, 9358 by default).
+// A game server marks itself Ready once it accepts players, sends health
+// pings while it runs, and marks itself Allocated when a matchmaker (e.g.
+// an Open Match director) hands it a match. Only the calls this server uses
+// are implemented, so the SDK module isn't a dependency.
+package agones
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// DefaultPort is the sidecar's REST port unless AGONES_SDK_HTTP_PORT says otherwise
+const DefaultPort = 9358
+
+// SDK talks to the Agones sidecar
+type SDK struct {
+	url    string
+	client *http.Client
+}
+
+// New creates an SDK client for the sidecar on localhost:port
+func New(port int) *SDK {
+	return &SDK{
+		url:    fmt.Sprintf("http://localhost:%d", port),
+		client: &http.Client{Timeout: 2 * time.Second},
+	}
+}
+
+// Ready marks the game server Ready: it can be allocated
+func (s *SDK) Ready() error {
+	return s.call(http.MethodPost, "/ready", struct{}{})
+}
+
+// Health sends a health ping. Agones restarts the game server if pings
+// stop for longer than its health check allows.
+func (s *SDK) Health() error {
+	return s.call(http.MethodPost, "/health", struct{}{})
+}
+
+// Allocate marks the game server Allocated, so Agones won't scale it down
+// or hand it to another allocation
+func (s *SDK) Allocate() error {
+	return s.call(http.MethodPost, "/allocate", struct{}{})
+}
+
+// SetLabel sets a label on the GameServer resource (prefixed "agones.dev/sdk-")
+func (s *SDK) SetLabel(key, value string) error {
+	return s.call(http.MethodPut, "/metadata/label", map[string]string{"key": key, "value": value})
+}
+
+// call sends a JSON request to the sidecar
+func (s *SDK) call(method, path string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, s.url+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("agones %s: %s", path, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+// Package certify signs results with the server's key so anyone consuming
+// the public API can check they came from a game server.
+//
+// A certificate carries the signed document itself: the payload is the
+// document's JSON exactly as signed, and the signature is Ed25519 over
+// "vracer/<kind>\n" followed by the payload, so a certificate of one kind
+// can't pass for another. Verifiers check the signature against the public
+// key (GET /api/certification) and then read the payload; they never have
+// to re-encode JSON. Every server of a cluster should share the key.
+package certify
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Certificate kinds
+const (
+	KindRound      = "round"      // A finished round of a public room (history.Match)
+	KindSubmission = "submission" // A best run on a leaderboard (leaderboard.Submission)
+)
+
+// Algorithm names the signature scheme, as published with the public key
+const Algorithm = "Ed25519"
+
+var (
+	ErrKeyID     = errors.New("certificate signed with another key")
+	ErrSignature = errors.New("invalid certificate signature")
+)
+
+// Certificate is a signed document
+type Certificate struct {
+	Kind      string `json:"kind"`
+	KeyID     string `json:"keyId"`
+	Payload   []byte `json:"payload"`   // The document's JSON (base64 in JSON)
+	Signature []byte `json:"signature"` // Ed25519 (base64 in JSON)
+}
+
+// PublicKey is the published half of a signer's key
+type PublicKey struct {
+	Algorithm string `json:"algorithm"`
+	KeyID     string `json:"keyId"`
+	Key       []byte `json:"key"` // Raw 32-byte Ed25519 public key (base64 in JSON)
+}
+
+// Signer signs certificates
+type Signer struct {
+	key   ed25519.PrivateKey
+	keyID string
+}
+
+// NewSigner creates a signer from a base64 encoded 32-byte Ed25519 seed
+func NewSigner(seed string) (*Signer, error) {
+	b, err := base64.StdEncoding.DecodeString(seed)
+	if err != nil {
+		return nil, fmt.Errorf("signing key: %w", err)
+	}
+	if len(b) != ed25519.SeedSize {
+		return nil, fmt.Errorf("signing key: %d bytes, want %d", len(b), ed25519.SeedSize)
+	}
+	return newSigner(ed25519.NewKeyFromSeed(b)), nil
+}
+
+// RandomSigner generates a key for a standalone server. Certificates signed
+// with it can't be checked after a restart, when the key is gone.
+func RandomSigner() *Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic("certify: no randomness available: " + err.Error())
+	}
+	return newSigner(key)
+}
+
+func newSigner(key ed25519.PrivateKey) *Signer {
+	return &Signer{key: key, keyID: keyID(key.Public().(ed25519.PublicKey))}
+}
+
+// keyID names a public key: the first 8 bytes of its SHA-256, in hex
+func keyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// message returns the bytes a certificate's signature covers
+func message(kind string, payload []byte) []byte {
+	msg := make([]byte, 0, len("vracer/\n")+len(kind)+len(payload))
+	msg = append(msg, "vracer/"...)
+	msg = append(msg, kind...)
+	msg = append(msg, '\n')
+	return append(msg, payload...)
+}
+
+// Sign certifies a document of the given kind
+func (s *Signer) Sign(kind string, doc interface{}) (*Certificate, error) {
+	payload, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	return &Certificate{
+		Kind:      kind,
+		KeyID:     s.keyID,
+		Payload:   payload,
+		Signature: ed25519.Sign(s.key, message(kind, payload)),
+	}, nil
+}
+
+// PublicKey returns the key certificates are verified with
+func (s *Signer) PublicKey() PublicKey {
+	return PublicKey{
+		Algorithm: Algorithm,
+		KeyID:     s.keyID,
+		Key:       s.key.Public().(ed25519.PublicKey),
+	}
+}
+
+// Verify checks a certificate against a public key and decodes its
+// document into doc (skipped if nil)
+func Verify(key PublicKey, c *Certificate, doc interface{}) error {
+	if c.KeyID != key.KeyID {
+		return ErrKeyID
+	}
+	if len(key.Key) != ed25519.PublicKeySize || !ed25519.Verify(key.Key, message(c.Kind, c.Payload), c.Signature) {
+		return ErrSignature
+	}
+	if doc == nil {
+		return nil
+	}
+	return json.Unmarshal(c.Payload, doc)
+}
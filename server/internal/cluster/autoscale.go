@@ -0,0 +1,77 @@
+package cluster
+
+import "math"
+
+// Autoscaling
+//
+// The directory carries what an external autoscaler needs to size the
+// cluster ahead of demand: every server's connections, capacity and joins
+// waiting in its queues. Autoscale turns a listing into a replica count.
+// Demand is the connections of the servers taking new ones, plus their
+// queued joins once more: a queued join holds a connection already, and
+// waits for a place in a room that only more servers give. The servers
+// wanted are those that carry the demand at the target share of their
+// average capacity. The cluster shrinks only once the servers left would
+// stay below the target by the hysteresis, so it doesn't flap at the edge.
+// Draining servers are on their way out and count for neither.
+
+// Scaling is the scaling signal of a cluster
+type Scaling struct {
+	Replicas    int     `json:"replicas"` // Servers taking connections
+	Draining    int     `json:"draining"`
+	Desired     int     `json:"desired"`
+	Delta       int     `json:"delta"` // Desired - Replicas: servers to add (> 0) or remove (< 0)
+	Connections int     `json:"connections"`
+	Queued      int     `json:"queued"`
+	Capacity    int     `json:"capacity"`  // 0 if a server has no limit: no signal
+	Occupancy   float64 `json:"occupancy"` // Demand / Capacity
+	Target      float64 `json:"target"`
+}
+
+// ScalingPolicy bounds what Autoscale asks for
+type ScalingPolicy struct {
+	Target      float64 // Share of capacity to run at (0-1]
+	Hysteresis  float64 // Below Target before shrinking
+	MinReplicas int
+	MaxReplicas int // 0: no limit
+}
+
+// Autoscale returns the scaling signal of the servers listed. Servers
+// without a capacity give no signal: the replicas wanted are those there
+// are, within the policy's bounds.
+func Autoscale(instances []Instance, policy ScalingPolicy) Scaling {
+	sc := Scaling{Target: policy.Target}
+	limited := true
+	for _, inst := range instances {
+		if inst.Draining {
+			sc.Draining++
+			continue
+		}
+		sc.Replicas++
+		sc.Connections += inst.Connections
+		sc.Queued += inst.Queued
+		sc.Capacity += inst.Capacity
+		limited = limited && inst.Capacity > 0
+	}
+	if !limited {
+		sc.Capacity = 0
+	}
+
+	sc.Desired = sc.Replicas
+	demand := float64(sc.Connections + sc.Queued)
+	if sc.Capacity > 0 {
+		sc.Occupancy = demand / float64(sc.Capacity)
+		perServer := float64(sc.Capacity) / float64(sc.Replicas)
+		sc.Desired = int(math.Ceil(demand / (perServer * policy.Target)))
+		if sc.Desired < sc.Replicas {
+			shrink := policy.Target - policy.Hysteresis
+			sc.Desired = min(sc.Replicas, int(math.Ceil(demand/(perServer*shrink))))
+		}
+	}
+	sc.Desired = max(sc.Desired, policy.MinReplicas)
+	if policy.MaxReplicas > 0 {
+		sc.Desired = min(sc.Desired, policy.MaxReplicas)
+	}
+	sc.Delta = sc.Desired - sc.Replicas
+	return sc
+}
@@ -0,0 +1,135 @@
+// Package cluster keeps the directory of the game servers sharing a store.
+//
+// Every server announces itself periodically: its Instance is stored as a
+// JSON value under "server:<id>", expiring after the directory TTL, and its
+// ID is added to the sorted set "servers" scored by the time of the
+// announcement. A server that stops announcing (crashed, or shut down
+// without removing itself) drops out of the directory once its value
+// expires; List prunes such IDs from the set. Servers register their rooms
+// the same way (see rooms.go), and an autoscaler sizes the cluster from
+// the listing (see autoscale.go).
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/race/server/internal/storage"
+)
+
+const (
+	serversSet   = "servers"
+	storeTimeout = 5 * time.Second
+)
+
+// Instance is a game server as seen by the rest of the cluster
+type Instance struct {
+	ID          string    `json:"id"`                   // Pod name or host name unless configured
+	PublicAddr  string    `json:"publicAddr,omitempty"` // Address clients connect to, if not the bind address
+	Connections int       `json:"connections"`
+	Capacity    int       `json:"capacity,omitempty"` // Connections it takes at most (0: no limit)
+	Queued      int       `json:"queued,omitempty"`   // Joins waiting in its queues (see autoscale.go)
+	Rooms       int       `json:"rooms"`
+	Players     int       `json:"players"`
+	Draining    bool      `json:"draining"` // Finishing its rooms; takes no new connections
+	Updated     time.Time `json:"updated"`
+}
+
+// Directory lists the servers of a cluster
+type Directory struct {
+	store storage.Store
+	ttl   time.Duration
+}
+
+// New creates a directory on top of a store. Entries not announced again
+// within ttl expire.
+func New(store storage.Store, ttl time.Duration) *Directory {
+	return &Directory{store: store, ttl: ttl}
+}
+
+func instanceKey(id string) string {
+	return "server:" + id
+}
+
+// Announce adds or refreshes a server's entry
+func (d *Directory) Announce(inst Instance) error {
+	data, err := json.Marshal(inst)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	if err := d.store.Set(ctx, instanceKey(inst.ID), data, d.ttl); err != nil {
+		return err
+	}
+	return d.store.ZAdd(ctx, serversSet, inst.ID, float64(inst.Updated.Unix()))
+}
+
+// Remove takes a server out of the directory
+func (d *Directory) Remove(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	if err := d.store.Delete(ctx, instanceKey(id)); err != nil {
+		return err
+	}
+	return d.store.ZRem(ctx, serversSet, id)
+}
+
+// List returns the servers of the cluster, most recently announced first
+func (d *Directory) List() ([]Instance, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	members, err := d.store.ZRevRange(ctx, serversSet, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	instances := make([]Instance, 0, len(members))
+	for _, m := range members {
+		data, ok, err := d.store.Get(ctx, instanceKey(m.Member))
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			if err := d.store.ZRem(ctx, serversSet, m.Member); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		var inst Instance
+		if err := json.Unmarshal(data, &inst); err != nil {
+			return nil, err
+		}
+		instances = append(instances, inst)
+	}
+	return instances, nil
+}
+
+// Load is the share of its capacity a server uses, or its connections if it
+// has no limit
+func (inst Instance) Load() float64 {
+	if inst.Capacity <= 0 {
+		return float64(inst.Connections)
+	}
+	return float64(inst.Connections) / float64(inst.Capacity)
+}
+
+// LeastLoaded returns the server that takes new players with the lowest
+// load, false if all of them are draining
+func LeastLoaded(instances []Instance) (Instance, bool) {
+	var best Instance
+	found := false
+	for _, inst := range instances {
+		if inst.Draining {
+			continue
+		}
+		if !found || inst.Load() < best.Load() || inst.Load() == best.Load() && inst.Players < best.Players {
+			best, found = inst, true
+		}
+	}
+	return best, found
+}
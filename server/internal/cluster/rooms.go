@@ -0,0 +1,228 @@
+package cluster
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/storage"
+)
+
+// Room registry
+//
+// Servers sharing a store register their rooms next to the directory, so
+// any of them can tell where a room plays and how full it is. Each room is
+// a JSON RoomEntry under "room:<id>", expiring after the registry TTL like
+// the server entries, and its ID is a member of the sorted set
+// "rooms:<instance>" of the server that owns it. A server publishes all of
+// its rooms with every announcement.
+//
+// Room IDs are claimed with SetIfAbsent before a room takes them, so two
+// servers never open rooms of the same ID. Claims are made ahead, while
+// publishing, so creating a room never waits for the store; a room that
+// took an unclaimed ID because the claims ran out is claimed when next
+// published, and logged if the ID turns out to be taken. A restarted
+// server's previous rooms are gone with the old process: its first
+// publication removes their entries, listed in its set, instead of leaving
+// them to expire.
+
+// RoomEntry is a room as seen by the rest of the cluster
+type RoomEntry struct {
+	ID         string    `json:"id"`
+	Instance   string    `json:"instance"`         // Server the room plays on
+	Tenant     string    `json:"tenant,omitempty"` // "" for the default tenant
+	Players    int       `json:"players"`
+	MaxPlayers int       `json:"maxPlayers"`
+	Public     bool      `json:"public"`            // Takes players from matchmaking
+	Pending    bool      `json:"pending,omitempty"` // ID claimed for a room not open yet
+	Updated    time.Time `json:"updated"`
+}
+
+// Rooms registers the rooms of one server
+type Rooms struct {
+	store    storage.Store
+	instance string
+	ttl      time.Duration
+
+	mu    sync.Mutex
+	spare []string        // IDs claimed ahead for new rooms
+	owned map[string]bool // IDs claimed by this process
+}
+
+// NewRooms creates the registry of a server's rooms on top of a store.
+// Entries not published again within ttl expire.
+func NewRooms(store storage.Store, instance string, ttl time.Duration) *Rooms {
+	return &Rooms{
+		store:    store,
+		instance: instance,
+		ttl:      ttl,
+		owned:    make(map[string]bool),
+	}
+}
+
+func roomKey(id string) string {
+	return "room:" + id
+}
+
+func (r *Rooms) set() string {
+	return "rooms:" + r.instance
+}
+
+// NewRoomID returns an ID for a new room, claimed ahead if one is left.
+// Never waits for the store.
+func (r *Rooms) NewRoomID() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if n := len(r.spare); n > 0 {
+		id := r.spare[n-1]
+		r.spare = r.spare[:n-1]
+		return id
+	}
+	return randomRoomID()
+}
+
+// Publish registers the server's open rooms, removes the entries of rooms
+// it no longer has, and claims IDs ahead for the next ones. An ID another
+// server holds is left to it and reported in the error once the rest is
+// published.
+func (r *Rooms) Publish(rooms []RoomEntry) error {
+	return r.publish(rooms, config.RoomIDsAhead)
+}
+
+// publish publishes rooms, with ahead IDs claimed ahead
+func (r *Rooms) publish(rooms []RoomEntry, ahead int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now().UTC()
+	if err := r.claimAhead(ctx, ahead, now); err != nil {
+		return err
+	}
+
+	keep := make(map[string]bool, len(rooms)+len(r.spare))
+	var taken []string
+	for _, room := range rooms {
+		room.Instance, room.Pending, room.Updated = r.instance, false, now
+		ok, err := r.put(ctx, room)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			taken = append(taken, room.ID)
+			continue
+		}
+		keep[room.ID] = true
+	}
+	for _, id := range r.spare {
+		if _, err := r.put(ctx, RoomEntry{ID: id, Instance: r.instance, Pending: true, Updated: now}); err != nil {
+			return err
+		}
+		keep[id] = true
+	}
+
+	// Rooms closed since, or left by a previous process of this server
+	members, err := r.store.ZRevRange(ctx, r.set(), 0, -1)
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		if keep[m.Member] {
+			continue
+		}
+		if err := r.store.Delete(ctx, roomKey(m.Member)); err != nil {
+			return err
+		}
+		if err := r.store.ZRem(ctx, r.set(), m.Member); err != nil {
+			return err
+		}
+		delete(r.owned, m.Member)
+	}
+
+	if len(taken) > 0 {
+		return fmt.Errorf("room IDs registered by another server: %v", taken)
+	}
+	return nil
+}
+
+// claimAhead claims IDs until ahead are spare.
+// IMPORTANT: Caller must hold r.mu.
+func (r *Rooms) claimAhead(ctx context.Context, ahead int, now time.Time) error {
+	for len(r.spare) < ahead {
+		id := randomRoomID()
+		ok, err := r.put(ctx, RoomEntry{ID: id, Instance: r.instance, Pending: true, Updated: now})
+		if err != nil {
+			return err
+		}
+		if ok {
+			r.spare = append(r.spare, id)
+		}
+	}
+	return nil
+}
+
+// put writes a room's entry, claiming its ID first unless this process
+// already did. Returns false if another server holds the ID.
+// IMPORTANT: Caller must hold r.mu.
+func (r *Rooms) put(ctx context.Context, room RoomEntry) (bool, error) {
+	data, err := json.Marshal(room)
+	if err != nil {
+		return false, err
+	}
+	if r.owned[room.ID] {
+		err = r.store.Set(ctx, roomKey(room.ID), data, r.ttl)
+	} else {
+		var ok bool
+		if ok, err = r.store.SetIfAbsent(ctx, roomKey(room.ID), data, r.ttl); err == nil && !ok {
+			return false, nil
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+	r.owned[room.ID] = true
+	return true, r.store.ZAdd(ctx, r.set(), room.ID, float64(room.Updated.Unix()))
+}
+
+// Lookup returns the entry of an open room of any server, or false if no
+// server registered it
+func (r *Rooms) Lookup(id string) (RoomEntry, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	data, ok, err := r.store.Get(ctx, roomKey(id))
+	if err != nil || !ok {
+		return RoomEntry{}, false, err
+	}
+	var room RoomEntry
+	if err := json.Unmarshal(data, &room); err != nil {
+		return RoomEntry{}, false, err
+	}
+	if room.Pending {
+		return RoomEntry{}, false, nil
+	}
+	return room, true, nil
+}
+
+// Clear removes every entry of the server, rooms and claims (shutdown)
+func (r *Rooms) Clear() error {
+	r.mu.Lock()
+	r.spare = nil
+	r.mu.Unlock()
+	return r.publish(nil, 0)
+}
+
+// randomRoomID generates a random room ID
+func randomRoomID() string {
+	bytes := make([]byte, 8)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
@@ -0,0 +1,165 @@
+// Package cosmetics keeps the catalog of car cosmetics beyond the color
+// palette (trails and decals) and what each account owns.
+//
+// Items are named "<kind>/<name>" ("trail/neon") in the APIs and travel as
+// a per-kind ID on the wire (0 is none). Free items are for everyone; the
+// others are granted to accounts by the account service or a shop through
+// the admin API and stored in the sorted set "player:<account>:cosmetics",
+// the grant time as the score. Guests own nothing, so linking a guest to an
+// account has nothing to move.
+package cosmetics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/race/server/internal/storage"
+)
+
+const storeTimeout = 5 * time.Second
+
+// Kinds of cosmetics
+const (
+	KindTrail = "trail"
+	KindDecal = "decal"
+)
+
+// Item is a cosmetic of the catalog
+type Item struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	ID   uint8  `json:"id"` // Wire ID within the kind
+	Free bool   `json:"free,omitempty"`
+}
+
+// Key returns the item's name in the APIs and the store
+func (i Item) Key() string {
+	return i.Kind + "/" + i.Name
+}
+
+// Catalog lists every cosmetic. IDs are part of the protocol: never reuse
+// or renumber one, add new items at the end of their kind.
+var Catalog = []Item{
+	{Kind: KindTrail, Name: "smoke", ID: 1, Free: true},
+	{Kind: KindTrail, Name: "sparks", ID: 2},
+	{Kind: KindTrail, Name: "neon", ID: 3},
+	{Kind: KindTrail, Name: "flame", ID: 4},
+	{Kind: KindTrail, Name: "rainbow", ID: 5},
+	{Kind: KindDecal, Name: "stripes", ID: 1, Free: true},
+	{Kind: KindDecal, Name: "checker", ID: 2},
+	{Kind: KindDecal, Name: "flames", ID: 3},
+	{Kind: KindDecal, Name: "lightning", ID: 4},
+	{Kind: KindDecal, Name: "star", ID: 5},
+}
+
+// ErrUnknownItem is returned for names and IDs that aren't in the catalog
+var ErrUnknownItem = errors.New("unknown cosmetic")
+
+// Lookup returns the item named key ("trail/neon")
+func Lookup(key string) (Item, error) {
+	for _, item := range Catalog {
+		if item.Key() == key {
+			return item, nil
+		}
+	}
+	return Item{}, fmt.Errorf("%w %q", ErrUnknownItem, key)
+}
+
+// ByID returns the item of a kind with a wire ID
+func ByID(kind string, id uint8) (Item, error) {
+	for _, item := range Catalog {
+		if item.Kind == kind && item.ID == id {
+			return item, nil
+		}
+	}
+	return Item{}, fmt.Errorf("%w %s %d", ErrUnknownItem, kind, id)
+}
+
+// Inventory stores the items accounts own
+type Inventory struct {
+	store storage.Store
+}
+
+// NewInventory creates an inventory on top of a store
+func NewInventory(store storage.Store) *Inventory {
+	return &Inventory{store: store}
+}
+
+func inventoryKey(account string) string {
+	return "player:" + account + ":cosmetics"
+}
+
+// Owned returns the keys of the items an account was granted, sorted.
+// Free items aren't listed.
+func (inv *Inventory) Owned(ctx context.Context, account string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+
+	members, err := inv.store.ZRevRange(ctx, inventoryKey(account), 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(members))
+	for _, m := range members {
+		keys = append(keys, m.Member)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Owns reports whether an account may use an item: it is free or was
+// granted to the account. Guests ("") own only the free items.
+func (inv *Inventory) Owns(ctx context.Context, account string, item Item) (bool, error) {
+	if item.Free {
+		return true, nil
+	}
+	if account == "" {
+		return false, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+
+	_, owned, err := inv.store.ZScore(ctx, inventoryKey(account), item.Key())
+	return owned, err
+}
+
+// Grant gives an account an item. Granting an owned item keeps the
+// original grant time.
+func (inv *Inventory) Grant(ctx context.Context, account string, item Item) error {
+	ctx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+
+	key := inventoryKey(account)
+	if _, owned, err := inv.store.ZScore(ctx, key, item.Key()); err != nil || owned {
+		return err
+	}
+	return inv.store.ZAdd(ctx, key, item.Key(), float64(time.Now().Unix()))
+}
+
+// Revoke takes an item from an account
+func (inv *Inventory) Revoke(ctx context.Context, account string, item Item) error {
+	ctx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+
+	return inv.store.ZRem(ctx, inventoryKey(account), item.Key())
+}
+
+// Delete removes an account's inventory (data deletion requests)
+func (inv *Inventory) Delete(ctx context.Context, account string) error {
+	owned, err := inv.Owned(ctx, account)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+
+	for _, key := range owned {
+		if err := inv.store.ZRem(ctx, inventoryKey(account), key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
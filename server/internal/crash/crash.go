@@ -0,0 +1,303 @@
+// Package crash reports the server's panics.
+//
+// Every goroutine the server starts goes through routines.Go, which defers
+// Recover: a panic is reported to the configured Sink with its stack, the
+// goroutine's owner and the build, then raised again, so the process still
+// dies and its supervisor restarts it (what a panic leaves behind, a lock
+// held or a room half ticked, can't be trusted). Code on the way up adds
+// context by recovering and raising With(value, key, value...) instead, as
+// the scheduler does with the room of a tick and connections with the
+// message they handle.
+//
+// Reports are aggregated by signature, the panic's type and the function it
+// came from: one report per signature per config.CrashReportWindow, and at
+// most config.CrashReportsPerWindow in a window overall. The ones held back
+// are counted in the next report of their signature. The counts live in a
+// state file next to the data, so a crash loop, whose every process panics
+// once, still reports once a window.
+package crash
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/race/server/config"
+)
+
+// Panic is a panic on its way up, with the context added so far
+type Panic struct {
+	Value   interface{}
+	Stack   []byte // Of the goroutine where it was first recovered
+	frames  []uintptr
+	Context []string // Key, value, key, value...
+}
+
+func (p *Panic) Error() string { return fmt.Sprint(p.Value) }
+
+// With adds context to a recovered panic value, for code that recovers only
+// to raise it again:
+//
+//	defer func() {
+//		if r := recover(); r != nil {
+//			panic(crash.With(r, "room", room.ID))
+//		}
+//	}()
+func With(v interface{}, keyvals ...string) *Panic {
+	p, ok := v.(*Panic)
+	if !ok {
+		p = &Panic{Value: v, Stack: debug.Stack(), frames: callers()}
+	}
+	p.Context = append(p.Context, keyvals...)
+	return p
+}
+
+// callers returns the frames from the panic's origin up
+func callers() []uintptr {
+	pc := make([]uintptr, 64)
+	return pc[:runtime.Callers(3, pc)]
+}
+
+// Frame is a function of a report's stack
+type Frame struct {
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+}
+
+// Build identifies the binary that panicked
+type Build struct {
+	GoVersion string `json:"goVersion"`
+	Version   string `json:"version,omitempty"`  // Of the main module
+	Revision  string `json:"revision,omitempty"` // VCS revision it was built from
+	Modified  bool   `json:"modified,omitempty"` // With uncommitted changes
+}
+
+// Report is what a sink receives of a panic
+type Report struct {
+	Time      time.Time         `json:"time"`
+	Instance  string            `json:"instance"`
+	Owner     string            `json:"owner"` // routines.Go owner of the goroutine
+	Type      string            `json:"type"`  // Of the panic value
+	Value     string            `json:"value"`
+	Signature string            `json:"signature"`
+	Context   map[string]string `json:"context,omitempty"`
+	Frames    []Frame           `json:"frames"` // Innermost first, from where it panicked
+	Stack     string            `json:"stack"`
+	Build     Build             `json:"build"`
+	Repeats   int               `json:"repeats,omitempty"` // Held back since the last report of the signature
+}
+
+// Sink receives reports
+type Sink interface {
+	Send(r *Report) error
+}
+
+// Reporter sends the reports of one process to a sink
+type Reporter struct {
+	sink     Sink
+	instance string
+	state    string // Path of the aggregation state file
+	build    Build
+
+	mu sync.Mutex
+}
+
+// NewReporter reports to sink, keeping the aggregation state in the
+// directory dir
+func NewReporter(sink Sink, instance, dir string) *Reporter {
+	return &Reporter{
+		sink:     sink,
+		instance: instance,
+		state:    filepath.Join(dir, "crash-state.json"),
+		build:    readBuild(),
+	}
+}
+
+var reporter struct {
+	mu sync.Mutex
+	r  *Reporter
+}
+
+// SetReporter makes r the reporter of Recover (nil: log only)
+func SetReporter(r *Reporter) {
+	reporter.mu.Lock()
+	reporter.r = r
+	reporter.mu.Unlock()
+}
+
+// Recover reports a panic of the goroutine owned by owner and raises it
+// again. It must be deferred directly, at the goroutine's entry point.
+func Recover(owner string) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	p := With(v)
+
+	reporter.mu.Lock()
+	r := reporter.r
+	reporter.mu.Unlock()
+	if r != nil {
+		r.Report(owner, p)
+	}
+	panic(p.Value)
+}
+
+// Report sends a report of the panic unless its aggregation holds it back
+func (r *Reporter) Report(owner string, p *Panic) {
+	report := r.report(owner, p)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state := r.load()
+	now := report.Time
+	if !state.admit(report, now) {
+		log.Printf("Crash report of %s held back (%d since the last one)", report.Signature, state.Signatures[report.Signature].Held)
+		r.save(state)
+		return
+	}
+	r.save(state) // Before sending: the send may not return
+	if err := r.sink.Send(report); err != nil {
+		log.Printf("Failed to send the crash report of %s: %v", report.Signature, err)
+		return
+	}
+	log.Printf("Crash report of %s sent", report.Signature)
+}
+
+// report builds the report of a panic
+func (r *Reporter) report(owner string, p *Panic) *Report {
+	report := &Report{
+		Time:     time.Now().UTC(),
+		Instance: r.instance,
+		Owner:    owner,
+		Type:     fmt.Sprintf("%T", p.Value),
+		Value:    fmt.Sprint(p.Value),
+		Stack:    string(p.Stack),
+		Build:    r.build,
+	}
+	if len(p.Context) > 0 {
+		report.Context = make(map[string]string, len(p.Context)/2)
+		for i := 0; i+1 < len(p.Context); i += 2 {
+			report.Context[p.Context[i]] = p.Context[i+1]
+		}
+	}
+
+	// The frames start at the panic: those of the runtime raising it and
+	// of the recovering defers come first
+	frames := runtime.CallersFrames(p.frames)
+	panicking := false
+	for {
+		f, more := frames.Next()
+		if panicking && !strings.HasPrefix(f.Function, "runtime.") {
+			report.Frames = append(report.Frames, Frame{Function: f.Function, File: f.File, Line: f.Line})
+		}
+		if f.Function == "runtime.gopanic" {
+			panicking = true
+		}
+		if !more {
+			break
+		}
+	}
+	origin := "unknown"
+	if len(report.Frames) > 0 {
+		origin = report.Frames[0].Function
+	}
+	report.Signature = report.Type + " in " + origin
+	return report
+}
+
+// aggregation is the state file: the reports of the current window
+type aggregation struct {
+	Window     time.Time                    `json:"window"` // Start of the current window
+	Sent       int                          `json:"sent"`   // Reports sent in the window
+	Signatures map[string]*signatureHistory `json:"signatures"`
+}
+
+type signatureHistory struct {
+	Sent time.Time `json:"sent"` // Last report sent
+	Held int       `json:"held"` // Panics held back since
+}
+
+// admit decides whether a report goes out, counting it either way
+func (a *aggregation) admit(report *Report, now time.Time) bool {
+	if now.Sub(a.Window) >= config.CrashReportWindow {
+		a.Window, a.Sent = now, 0
+	}
+	for sig, h := range a.Signatures {
+		if now.Sub(h.Sent) >= config.CrashReportWindow && h.Held == 0 {
+			delete(a.Signatures, sig) // Quiet for a window
+		}
+	}
+
+	h := a.Signatures[report.Signature]
+	if h == nil {
+		h = &signatureHistory{}
+		a.Signatures[report.Signature] = h
+	}
+	if now.Sub(h.Sent) < config.CrashReportWindow || a.Sent >= config.CrashReportsPerWindow {
+		h.Held++
+		return false
+	}
+	report.Repeats = h.Held
+	h.Sent, h.Held = now, 0
+	a.Sent++
+	return true
+}
+
+func (r *Reporter) load() *aggregation {
+	a := &aggregation{}
+	data, err := os.ReadFile(r.state)
+	if err == nil {
+		err = json.Unmarshal(data, a)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Crash report state %s unreadable, starting over: %v", r.state, err)
+		a = &aggregation{}
+	}
+	if a.Signatures == nil {
+		a.Signatures = make(map[string]*signatureHistory)
+	}
+	return a
+}
+
+func (r *Reporter) save(a *aggregation) {
+	data, err := json.Marshal(a)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(r.state), 0o755)
+	}
+	if err == nil {
+		err = os.WriteFile(r.state, data, 0o644)
+	}
+	if err != nil {
+		log.Printf("Failed to save the crash report state: %v", err)
+	}
+}
+
+// readBuild reads the build of the running binary
+func readBuild() Build {
+	b := Build{GoVersion: runtime.Version()}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	b.Version = info.Main.Version
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			b.Revision = s.Value
+		case "vcs.modified":
+			b.Modified = s.Value == "true"
+		}
+	}
+	return b
+}
@@ -0,0 +1,143 @@
+package crash
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileSink appends reports to a file, a JSON object per line
+type FileSink struct {
+	Path string
+}
+
+func (s FileSink) Send(r *Report) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// SentrySink sends reports as events to a Sentry-compatible endpoint
+type SentrySink struct {
+	store  string // Store endpoint of the project
+	key    string // Public key of the DSN
+	client *http.Client
+}
+
+// sentryTimeout bounds a send: the process is waiting to die
+const sentryTimeout = 5 * time.Second
+
+// NewSentrySink sends to the project of a DSN,
+// https://<key>@<host>[/<path>]/<project>
+func NewSentrySink(dsn string) (*SentrySink, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("sentry DSN: %w", err)
+	}
+	path := strings.Trim(u.Path, "/")
+	project := path[strings.LastIndex(path, "/")+1:]
+	if u.User == nil || u.User.Username() == "" || u.Host == "" || project == "" {
+		return nil, fmt.Errorf("sentry DSN: want https://<key>@<host>/<project>")
+	}
+	prefix := strings.TrimSuffix(path, project)
+	return &SentrySink{
+		store:  fmt.Sprintf("%s://%s/%sapi/%s/store/", u.Scheme, u.Host, prefix, project),
+		key:    u.User.Username(),
+		client: &http.Client{Timeout: sentryTimeout},
+	}, nil
+}
+
+func (s *SentrySink) Send(r *Report) error {
+	data, err := json.Marshal(sentryEvent(r))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.store, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", "Sentry sentry_version=7, sentry_client=vracer/1, sentry_key="+s.key)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("sentry: %s", resp.Status)
+	}
+	return nil
+}
+
+// sentryEvent is a report as a Sentry event: one exception, its frames
+// outermost first, the context as tags
+func sentryEvent(r *Report) map[string]interface{} {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	frames := make([]map[string]interface{}, 0, len(r.Frames))
+	for i := len(r.Frames) - 1; i >= 0; i-- {
+		f := r.Frames[i]
+		frames = append(frames, map[string]interface{}{
+			"function": f.Function,
+			"abs_path": f.File,
+			"filename": filepath.Base(f.File),
+			"lineno":   f.Line,
+			"in_app":   strings.HasPrefix(f.Function, "github.com/race/") || strings.HasPrefix(f.Function, "main."),
+		})
+	}
+
+	tags := map[string]string{"owner": r.Owner, "go": r.Build.GoVersion}
+	for k, v := range r.Context {
+		tags[k] = v
+	}
+	event := map[string]interface{}{
+		"event_id":    hex.EncodeToString(id),
+		"timestamp":   r.Time.Format(time.RFC3339),
+		"level":       "fatal",
+		"platform":    "go",
+		"logger":      "crash",
+		"server_name": r.Instance,
+		"fingerprint": []string{r.Signature},
+		"tags":        tags,
+		"extra":       map[string]interface{}{"repeats": r.Repeats, "stack": r.Stack, "modified": r.Build.Modified},
+		"exception": map[string]interface{}{
+			"values": []map[string]interface{}{{
+				"type":       r.Type,
+				"value":      r.Value,
+				"stacktrace": map[string]interface{}{"frames": frames},
+			}},
+		},
+	}
+	if r.Build.Revision != "" {
+		event["release"] = r.Build.Revision
+	}
+	return event
+}
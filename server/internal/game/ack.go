@@ -0,0 +1,36 @@
+package game
+
+import (
+	"github.com/race/server/config"
+	"github.com/race/server/internal/network"
+)
+
+// Input acknowledgement
+//
+// Clients predict their own car from the inputs they send, and need to know
+// which of them the server's state already reflects to replay the rest on
+// top of it (reconciliation). The physics tick that first drives with a
+// player's latest input acknowledges its sequence number (see
+// Player.beginTick); the state frame captures it with the rest of the car,
+// and ProtocolV35 state updates end their own state with it, so the ack a
+// client gets always matches the car's position in the same update. Inputs
+// the room drops (flooding, a parked relay car, a car on a race's grid)
+// are never acknowledged, nor are those a later input replaced before a
+// tick: the ack only ever names the last input applied.
+
+// ownState returns the extended state of a receiver's own car in a frame,
+// for ProtocolV20 state updates: its fuel in fuel rooms, and the input ack.
+func (r *Room) ownState(frame *stateFrame, receiver *Player) network.OwnState {
+	for i, p := range frame.players {
+		if p != receiver {
+			continue
+		}
+		state := &frame.states[i]
+		own := network.OwnState{HasAck: state.Acked, Ack: state.InputAck}
+		if r.physics.fuel {
+			own.HasFuel, own.Fuel = true, network.ScaleFuel(state.Fuel/config.FuelCapacity)
+		}
+		return own
+	}
+	return network.OwnState{}
+}
@@ -0,0 +1,44 @@
+package game
+
+import (
+	"math"
+)
+
+// Agents
+//
+// An agent is a bot slot driven from outside the server, by a program such
+// as a reinforcement learning agent: it sets the car's input with
+// DriveAgent instead of a bot personality deciding it every tick. Otherwise
+// the car is a bot like any other: the room's physics, collisions and
+// anti-cheat apply to it, it runs no leaderboard runs, gets no state
+// updates (agents observe the room with Inspect) and doesn't keep the room
+// running on its own. The input holds until the next DriveAgent.
+
+// agentProfile is the profile agents' slots show in Bots
+const agentProfile = "agent"
+
+// AddAgent adds a bot slot driven with DriveAgent.
+func (r *Room) AddAgent(name string) (*Player, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	driver := newBotDriver(BotProfile{Name: agentProfile}, r.rng.Fork())
+	driver.external = true
+	return r.addPlayerUnlocked("agent", name, uint8(driver.rng.Intn(16)), botConnection{}, driver)
+}
+
+// DriveAgent sets the input of an agent's car. Steering and throttle are
+// clamped to -1..1.
+func (r *Room) DriveAgent(playerID uint16, input PlayerInput) error {
+	r.mu.RLock()
+	p, exists := r.players[playerID]
+	r.mu.RUnlock()
+
+	if !exists || p.bot == nil || !p.bot.external {
+		return ErrPlayerNotFound
+	}
+	input.Steering = math.Max(-1, math.Min(1, input.Steering))
+	input.Throttle = math.Max(-1, math.Min(1, input.Throttle))
+	p.ApplyInput(input)
+	return nil
+}
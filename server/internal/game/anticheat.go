@@ -4,6 +4,7 @@ import (
 	"math"
 
 	"github.com/race/server/config"
+	"github.com/race/server/internal/track"
 )
 
 // ValidationResult represents the result of anti-cheat validation
@@ -17,12 +18,49 @@ const (
 	ValidationIgnoreInput
 )
 
+// AntiCheatRule is a movement check added to the built-in ones (see
+// Room.AddAntiCheatRule). Check is called from the physics tick for every
+// car that passed the built-in checks and must not block. A rubberband
+// counts as a violation like the built-in checks' do: too many and the
+// player is kicked.
+type AntiCheatRule interface {
+	Name() string
+	Check(m Movement, dt float64) ValidationResult
+}
+
+// Movement is a car's move over one physics tick
+type Movement struct {
+	Player       PlayerState
+	FromX, FromY float64 // Last valid position
+	Pushed       bool    // Touched another car this tick
+}
+
 // AntiCheat handles anti-cheat validation
-type AntiCheat struct{}
+type AntiCheat struct {
+	road  *track.Track    // The room's road
+	rules []AntiCheatRule // Extra checks, run after the built-in ones
+}
+
+// NewAntiCheat creates a new anti-cheat validator for a road
+func NewAntiCheat(road *track.Track) *AntiCheat {
+	return &AntiCheat{road: road}
+}
+
+// SetSuspicion sets the weight client telemetry puts against the player
+// (see cmd/gameserver/telemetry.go). Each whole point of it takes one off
+// the violations anti-cheat lets the player pile up before a kick.
+func (p *Player) SetSuspicion(weight float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.suspicion = weight
+}
 
-// NewAntiCheat creates a new anti-cheat validator
-func NewAntiCheat() *AntiCheat {
-	return &AntiCheat{}
+// violationLimitUnlocked returns how many violations in a row the player
+// gets away with.
+// IMPORTANT: Caller must hold the player lock.
+func (p *Player) violationLimitUnlocked() int {
+	return max(1, config.MaxViolations-int(p.suspicion))
 }
 
 // ValidatePlayerMovement validates player movement between ticks
@@ -46,10 +84,10 @@ func (ac *AntiCheat) ValidatePlayerMovement(p *Player, dt float64) ValidationRes
 	if actualDistance > maxPossibleDistance {
 		p.mu.Lock()
 		p.Violations++
-		newViolations := p.Violations
+		newViolations, limit := p.Violations, p.violationLimitUnlocked()
 		p.mu.Unlock()
 
-		if newViolations > config.MaxViolations {
+		if newViolations > limit {
 			return ValidationKick
 		}
 		return ValidationRubberband
@@ -73,18 +111,93 @@ func (ac *AntiCheat) ValidatePlayerMovement(p *Player, dt float64) ValidationRes
 	return ValidationValid
 }
 
+// ValidateSteering checks that a car moved sideways no further than full
+// lock allows at its speed: TurnSpeed scaled by the understeer of the speed,
+// as in Physics.UpdatePlayer. Collisions push cars sideways, so cars in
+// contact this tick are not checked.
+func (ac *AntiCheat) ValidateSteering(p *Player, dt float64) ValidationResult {
+	p.mu.RLock()
+	lateral := math.Abs(p.X - p.LastValidX)
+	speed := math.Abs(p.Speed)
+	bank := math.Max(ac.road.Bank(p.LastValidY), ac.road.Bank(p.Y))
+	p.mu.RUnlock()
+
+	// The turn penalty slows the car after it steered, so the current speed
+	// understeers no more than the one physics used. Physics banked the turn
+	// as the road was where the car started; allow the larger bank.
+	maxLateral := config.TurnSpeed * understeer(speed, bank) * dt * config.SteeringTolerance
+
+	if lateral > maxLateral {
+		p.mu.Lock()
+		p.Violations++
+		newViolations, limit := p.Violations, p.violationLimitUnlocked()
+		p.mu.Unlock()
+
+		if newViolations > limit {
+			return ValidationKick
+		}
+		return ValidationRubberband
+	}
+
+	return ValidationValid
+}
+
+// ValidateRules runs the extra rules on a car's move and returns the first
+// result that isn't valid, with the name of its rule
+func (ac *AntiCheat) ValidateRules(p *Player, pushed bool, dt float64) (ValidationResult, string) {
+	if len(ac.rules) == 0 {
+		return ValidationValid, ""
+	}
+	p.mu.RLock()
+	m := Movement{FromX: p.LastValidX, FromY: p.LastValidY, Pushed: pushed}
+	p.mu.RUnlock()
+	m.Player = p.GetState()
+
+	for _, rule := range ac.rules {
+		result := rule.Check(m, dt)
+		if result == ValidationValid {
+			continue
+		}
+		if result == ValidationRubberband {
+			p.mu.Lock()
+			p.Violations++
+			newViolations, limit := p.Violations, p.violationLimitUnlocked()
+			p.mu.Unlock()
+
+			if newViolations > limit {
+				result = ValidationKick
+			}
+		}
+		return result, rule.Name()
+	}
+	return ValidationValid, ""
+}
+
+// AddAntiCheatRule adds a check to the room's anti-cheat. Must be called
+// before Start.
+func (r *Room) AddAntiCheatRule(rule AntiCheatRule) {
+	r.antiCheat.rules = append(r.antiCheat.rules, rule)
+}
+
 // ValidatePosition validates player position against road boundaries
 func (ac *AntiCheat) ValidatePosition(p *Player) ValidationResult {
 	p.mu.RLock()
 	x := p.X
 	y := p.Y
+	airborne := p.Height > 0
 	p.mu.RUnlock()
 
-	roadCenter := config.GetRoadCurve(y)
+	// Cars in the air may fly past the edge; physics explodes them on
+	// landing
+	if airborne {
+		return ValidationValid
+	}
+
+	roadCenter := ac.road.Center(y)
 	distFromRoad := math.Abs(x - roadCenter)
 
 	// Check if player is way off road (cheating)
-	maxAllowedDist := config.RoadWidth*0.5 + config.RoadWidth*config.ExplosionTolerance*1.5
+	maxAllowedDist := ac.road.Width*0.5 + ac.road.Width*config.ExplosionTolerance*1.5
 
 	if distFromRoad > maxAllowedDist {
 		return ValidationExplode
@@ -0,0 +1,119 @@
+package game
+
+import (
+	"math"
+	"testing"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/network"
+	"github.com/race/server/internal/track"
+)
+
+// testTrack returns a custom track of one bend, validated as the editor's are
+func testTrack(t *testing.T, name string, amplitude, wavelength, banking float64, ramps ...track.Ramp) *track.Track {
+	t.Helper()
+	road := &track.Track{
+		ID:       name,
+		Version:  1,
+		Name:     name,
+		Width:    config.RoadWidth,
+		Curves:   []track.Curve{{Amplitude: amplitude, Wavelength: wavelength, Sharpness: 1}},
+		Banking:  banking,
+		Ramps:    ramps,
+		Approved: true,
+	}
+	if err := road.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return road
+}
+
+// TestLegalDrivingNotFlagged has a car weave from edge to edge of the road
+// at full lock for ten seconds, and checks anti-cheat flags none of it: the
+// sideways movement physics allows is within the steering check's, in
+// banked bends, where the car grips better, and in the air, where it can't
+// steer at all.
+func TestLegalDrivingNotFlagged(t *testing.T) {
+	tests := []struct {
+		name     string
+		road     func(t *testing.T) *track.Track
+		speed    float64 // At the start
+		throttle float64
+		banked   bool // The car must drive through fully banked bends
+		airborne bool // The car must fly off ramps
+	}{
+		{
+			name:     "full lock at top speed",
+			road:     func(t *testing.T) *track.Track { return testTrack(t, "straight", 50, 20000, 0) },
+			speed:    config.MaxSpeed,
+			throttle: 1,
+		},
+		{
+			name:     "full lock reversing",
+			road:     func(t *testing.T) *track.Track { return testTrack(t, "straight", 50, 20000, 0) },
+			speed:    -config.MaxSpeed * 0.2,
+			throttle: -1,
+		},
+		{
+			name:     "full lock at top speed, banked",
+			road:     func(t *testing.T) *track.Track { return testTrack(t, "banked", 80, 2000, 1) },
+			speed:    config.MaxSpeed,
+			throttle: 1,
+			banked:   true,
+		},
+		{
+			name: "full lock at top speed, airborne",
+			road: func(t *testing.T) *track.Track {
+				return testTrack(t, "ramps", 50, 20000, 0, track.Ramp{Offset: 2000, Spacing: 4000, Length: 200, Height: 50})
+			},
+			speed:    config.MaxSpeed,
+			throttle: 1,
+			airborne: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			room := NewRoom("steering")
+			room.SetTrack(tt.road(t))
+			p := addHuman(t, room, network.ProtocolVersionMax)
+
+			const startY = 10000 // Room to reverse
+			p.mu.Lock()
+			p.Y, p.X, p.Speed = startY, room.road.Center(startY), tt.speed
+			p.LastValidX, p.LastValidY = p.X, p.Y
+			p.mu.Unlock()
+
+			// Steer for the far edge, turning back a car's width short of it
+			margin := room.road.Width/2 - config.CarWidth
+			steering, bank, flights := 1.0, 0.0, 0
+			for tick := 0; tick < 10*config.PhysicsTickRate; tick++ {
+				from := p.GetState()
+				if offset := from.X - room.road.Center(from.Y); offset > margin {
+					steering = -1
+				} else if offset < -margin {
+					steering = 1
+				}
+				p.ApplyInput(PlayerInput{Sequence: uint8(tick), Steering: steering, Throttle: tt.throttle})
+				room.Step(physicsDt, false)
+
+				// A flagged car is rubberbanded back to where it was
+				s := p.GetState()
+				if (s.X == from.X && s.Y == from.Y) || s.Exploded {
+					t.Fatalf("tick %d: flagged at (%.1f, %.1f), speed %.0f, exploded %t", tick, s.X, s.Y, s.Speed, s.Exploded)
+				}
+				bank = math.Max(bank, room.road.Bank(s.Y))
+				if s.Airborne {
+					flights++
+				}
+			}
+
+			if tt.banked && bank < 1 {
+				t.Errorf("banked at most %.2f", bank)
+			}
+			if tt.airborne && flights == 0 {
+				t.Error("the car never left the ground")
+			}
+		})
+	}
+}
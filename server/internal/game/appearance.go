@@ -0,0 +1,50 @@
+package game
+
+import (
+	"github.com/race/server/internal/network"
+)
+
+// Cosmetics
+//
+// Besides its palette color, a car may wear a trail and a decal from the
+// cosmetics catalog (see internal/cosmetics). The room only shows them:
+// the server checks that the player owns them before calling
+// SetAppearance. Players who speak ProtocolV15 get an Appearance message
+// for every dressed car when they join and whenever a car's cosmetics
+// change; older clients draw plain cars.
+
+// Appearance is a car's cosmetics, as catalog IDs (0 for none)
+type Appearance struct {
+	Trail uint8
+	Decal uint8
+}
+
+// SetAppearance dresses a human player's car and shows it to the room.
+func (r *Room) SetAppearance(playerID uint16, a Appearance) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p, exists := r.players[playerID]
+	if !exists || p.IsBot() {
+		return ErrPlayerNotFound
+	}
+	if p.appearance == a {
+		return nil
+	}
+	p.appearance = a
+
+	msg := p.appearanceMessage(r.protocol)
+	for _, other := range r.players {
+		if !other.IsBot() && other.Connection.ProtocolVersion() >= network.ProtocolV15 {
+			other.Connection.Send(msg)
+		}
+	}
+	r.spectateUnlocked(msg)
+	return nil
+}
+
+// appearanceMessage encodes the player's cosmetics.
+// IMPORTANT: Caller must hold the room lock.
+func (p *Player) appearanceMessage(proto *network.Protocol) []byte {
+	return proto.EncodeAppearance(p.ID, p.appearance.Trail, p.appearance.Decal)
+}
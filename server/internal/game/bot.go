@@ -0,0 +1,372 @@
+package game
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/network"
+	"github.com/race/server/internal/track"
+)
+
+// Bots
+//
+// Bots are server-driven players used for testing and to populate quiet
+// rooms. They are normal players in the simulation (physics, collisions,
+// anti-cheat), but their input comes from a driver that follows a
+// personality profile instead of a network connection. Bots never hold host
+// privileges, never submit leaderboard runs, and don't keep a room alive.
+//
+// Bots drive in lanes (see lanes.go): each keeps to the middle of its lane
+// and, when a slower car ahead holds it up, moves to an adjacent lane that
+// is clear, preferring the left one; once past it drifts back to its
+// preferred lane. A bot that can't change lanes follows the car ahead at its
+// speed instead of running into it, unless it is aggressive.
+
+// BotProfile describes a bot personality
+type BotProfile struct {
+	Name       string  `json:"name"`
+	Speed      float64 `json:"speed"`      // Cruising speed as a fraction of MaxSpeed
+	LaneOffset float64 `json:"laneOffset"` // Preferred lane, -1 (leftmost) .. 1 (rightmost)
+	Aggression float64 `json:"aggression"` // 0..1: steering at players ahead (ramming)
+	Blocking   float64 `json:"blocking"`   // 0..1: moving into the line of players behind
+	Precision  float64 `json:"precision"`  // 0..1: steering accuracy; lower values wander
+}
+
+// DefaultBotProfiles are the built-in personalities
+var DefaultBotProfiles = map[string]BotProfile{
+	"clean":   {Name: "clean", Speed: 0.85, Precision: 0.95},
+	"blocker": {Name: "blocker", Speed: 0.75, Blocking: 0.8, Precision: 0.9},
+	"rammer":  {Name: "rammer", Speed: 0.9, Aggression: 0.8, Precision: 0.8},
+}
+
+// LoadBotProfiles reads profiles from a JSON array and merges them over the
+// defaults (a profile with a default's name replaces it).
+func LoadBotProfiles(path string) (map[string]BotProfile, error) {
+	profiles := make(map[string]BotProfile, len(DefaultBotProfiles))
+	for name, p := range DefaultBotProfiles {
+		profiles[name] = p
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var loaded []BotProfile
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for _, p := range loaded {
+		if p.Name == "" {
+			return nil, fmt.Errorf("parse %s: bot profile without name", path)
+		}
+		profiles[p.Name] = p
+	}
+	return profiles, nil
+}
+
+// BotInfo describes a bot in a room
+type BotInfo struct {
+	ID      uint16 `json:"id"`
+	Name    string `json:"name"`
+	Profile string `json:"profile"`
+}
+
+// botConnection is the PlayerConnection of a bot: messages go nowhere
+type botConnection struct{}
+
+func (botConnection) Send(data []byte) error { return nil }
+func (botConnection) Close() error           { return nil }
+func (botConnection) RemoteAddr() string     { return "bot" }
+func (botConnection) RTT() time.Duration     { return 0 }
+func (botConnection) RTTVar() time.Duration  { return 0 }
+func (botConnection) ProtocolVersion() uint8 { return network.ProtocolV1 }
+
+// botDriver produces a bot's input every physics tick
+type botDriver struct {
+	profile BotProfile
+	rng     *RNG    // Forked off the room's (see rng.go)
+	wander  float64 // Current steering error, grows as precision drops
+
+	lane         int     // Lane the bot drives in (0 until its first tick)
+	laneCooldown float64 // Simulated seconds before it may change lanes again
+
+	pitting bool // Filling up in a pit zone (fuel mode)
+
+	external bool // An agent's slot: driven with DriveAgent, not by the profile
+}
+
+func newBotDriver(profile BotProfile, rng *RNG) *botDriver {
+	return &botDriver{profile: profile, rng: rng}
+}
+
+// IsBot reports whether the player is driven by the server (a bot, a
+// practice room's replay car or a restored human's stand-in)
+func (p *Player) IsBot() bool {
+	return p.bot != nil || p.replay || p.standIn
+}
+
+// decide computes the bot's input on road from its own state and the
+// players' (its own among them is skipped). nearestHumanY is the Y of the
+// closest human player (ok is false if there are no humans), used for
+// rubber-banding. dt is the tick's simulated time.
+func (b *botDriver) decide(road *track.Track, self PlayerState, players []PlayerState, nearestHumanY float64, ok bool, dt float64) PlayerInput {
+	halfWidth := road.Width / 2.0
+	maxOffset := halfWidth - config.CarWidth
+	laneWidth := road.Width / float64(road.LaneCount())
+
+	// Keep to the lane, changing lanes to pass, looking a little ahead
+	blocker := b.chooseLane(road, self, players, dt)
+	lookahead := self.Y + math.Max(self.Speed, 100)*0.3
+	targetX := road.LaneCenter(b.lane, lookahead)
+
+	// Interact with nearby players
+	var ahead, behind *PlayerState
+	for i := range players {
+		o := &players[i]
+		dy := o.Y - self.Y
+		if o.ID == self.ID || o.Exploded || math.Abs(dy) > config.BotSenseRadius {
+			continue
+		}
+		if dy > 0 && (ahead == nil || dy < ahead.Y-self.Y) {
+			ahead = o
+		}
+		if dy <= 0 && (behind == nil || dy > behind.Y-self.Y) {
+			behind = o
+		}
+	}
+	if ahead != nil && b.profile.Aggression > 0 {
+		targetX += (ahead.X - targetX) * b.profile.Aggression
+	}
+	if behind != nil && b.profile.Blocking > 0 {
+		targetX += (behind.X - targetX) * b.profile.Blocking
+	}
+
+	// Imprecise bots wander around their line
+	b.wander += b.rng.NormFloat64() * 0.05 * (1 - b.profile.Precision)
+	b.wander = math.Max(-1, math.Min(1, b.wander*0.98))
+	targetX += b.wander * laneWidth * 0.5
+
+	// Never aim off the road
+	center := road.Center(lookahead)
+	targetX = math.Max(center-maxOffset, math.Min(center+maxOffset, targetX))
+
+	// Steer with the road (feed-forward) plus a correction towards the line,
+	// measured across the road so bends don't read as being off the line.
+	// authority is how fast the car can move sideways at full lock.
+	slope := (center - road.Center(self.Y)) / (lookahead - self.Y)
+	authority := config.TurnSpeed * understeer(self.Speed, road.Bank(self.Y))
+	offsetError := (targetX - center) - (self.X - road.Center(self.Y))
+	steering := slope*self.Speed/authority + offsetError/(halfWidth*0.5)
+	steering = math.Max(-1, math.Min(1, steering))
+
+	// Rubber-banding: ease off far ahead of the humans, push hard far behind
+	targetSpeed := b.profile.Speed * config.MaxSpeed
+	if ok {
+		gap := self.Y - nearestHumanY
+		switch {
+		case gap > config.BotRubberBandDistance:
+			excess := (gap - config.BotRubberBandDistance) / config.BotRubberBandDistance
+			targetSpeed *= math.Max(0.3, 1-config.BotRubberBandStrength*excess)
+		case gap < -config.BotRubberBandDistance:
+			targetSpeed = config.MaxSpeed
+		}
+	}
+
+	// Stuck behind a slower car: follow it rather than run into it
+	if blocker != nil && b.profile.Aggression == 0 {
+		targetSpeed = math.Min(targetSpeed, blocker.Speed)
+	}
+
+	// Slow down for bends the car could not follow at that speed
+	if s := math.Abs(slope); s > 0 {
+		grip := 0.7 * config.TurnSpeed // Leave some steering for corrections
+		cornerSpeed := grip / (s + grip*config.InertiaDampening/config.MaxSpeed)
+		targetSpeed = math.Min(targetSpeed, cornerSpeed)
+	}
+
+	// Low on fuel (fuel mode): slow down through a pit zone until nearly
+	// full
+	inPit := road.InPit(self.Y) || road.InPit(lookahead)
+	switch {
+	case !inPit || self.Fuel >= config.BotPitFull*config.FuelCapacity:
+		b.pitting = false
+	case self.Fuel < config.BotPitFuel*config.FuelCapacity:
+		b.pitting = true
+	}
+	if b.pitting {
+		targetSpeed = math.Min(targetSpeed, config.BotPitSpeed)
+	}
+
+	throttle := 0.0
+	switch {
+	case self.Speed < targetSpeed-10:
+		throttle = 1
+	case self.Speed > targetSpeed+30:
+		throttle = -0.5
+	}
+
+	return PlayerInput{Steering: steering, Throttle: throttle}
+}
+
+// chooseLane picks the lane the bot drives in: its own, or an adjacent one
+// to pass the car holding it up (returned, nil if none) or to get back to
+// its preferred lane. Lane changes are config.BotLaneChangeCooldown apart
+// in simulated time, dt a tick, so that a room plays out the same however
+// fast it is simulated.
+func (b *botDriver) chooseLane(road *track.Track, self PlayerState, players []PlayerState, dt float64) *PlayerState {
+	lanes := road.LaneCount()
+	preferred := min(int((b.profile.LaneOffset+1)/2*float64(lanes))+1, lanes)
+	preferred = max(preferred, 1)
+	if b.lane == 0 || b.lane > lanes {
+		b.lane = preferred
+	}
+
+	blocker := b.slowerAhead(self, players, b.lane)
+	if b.laneCooldown = math.Max(0, b.laneCooldown-dt); b.laneCooldown > 0 {
+		return blocker
+	}
+	switch {
+	case blocker != nil:
+		for _, lane := range [2]int{b.lane - 1, b.lane + 1} {
+			if b.laneClear(self, players, lane, lanes) {
+				b.lane, b.laneCooldown = lane, config.BotLaneChangeCooldown.Seconds()
+				return nil
+			}
+		}
+	case b.lane != preferred:
+		lane := b.lane + 1
+		if preferred < b.lane {
+			lane = b.lane - 1
+		}
+		if b.laneClear(self, players, lane, lanes) {
+			b.lane, b.laneCooldown = lane, config.BotLaneChangeCooldown.Seconds()
+		}
+	}
+	return blocker
+}
+
+// slowerAhead returns the closest car less than config.BotLaneGap ahead in
+// lane if it is more than 5% slower than the bot's cruising speed (nil if
+// none)
+func (b *botDriver) slowerAhead(self PlayerState, players []PlayerState, lane int) *PlayerState {
+	var ahead *PlayerState
+	for i := range players {
+		o := &players[i]
+		dy := o.Y - self.Y
+		if o.ID == self.ID || o.Exploded || o.Lane != lane || dy <= 0 || dy > config.BotLaneGap {
+			continue
+		}
+		if ahead == nil || dy < ahead.Y-self.Y {
+			ahead = o
+		}
+	}
+	if ahead == nil || ahead.Speed >= b.profile.Speed*config.MaxSpeed*0.95 {
+		return nil
+	}
+	return ahead
+}
+
+// laneClear reports whether the bot can move into lane: no car within
+// config.BotLaneClearance of it there and no slower car ahead to pass again
+func (b *botDriver) laneClear(self PlayerState, players []PlayerState, lane, lanes int) bool {
+	if lane < 1 || lane > lanes {
+		return false
+	}
+	for i := range players {
+		o := &players[i]
+		if o.ID != self.ID && !o.Exploded && o.Lane == lane && math.Abs(o.Y-self.Y) < config.BotLaneClearance {
+			return false
+		}
+	}
+	return b.slowerAhead(self, players, lane) == nil
+}
+
+// AddBot adds a server-driven player with the given personality.
+func (r *Room) AddBot(profile BotProfile) (*Player, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	driver := newBotDriver(profile, r.rng.Fork())
+	name := fmt.Sprintf("Bot %s", profile.Name)
+	color := uint8(driver.rng.Intn(16))
+
+	return r.addPlayerUnlocked("bot", name, color, botConnection{}, driver)
+}
+
+// RemoveBot removes a bot from the room.
+func (r *Room) RemoveBot(id uint16) error {
+	r.mu.RLock()
+	p, exists := r.players[id]
+	r.mu.RUnlock()
+
+	if !exists || !p.IsBot() {
+		return ErrPlayerNotFound
+	}
+	r.RemovePlayer(id)
+	return nil
+}
+
+// Bots lists the bots in the room.
+func (r *Room) Bots() []BotInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	bots := make([]BotInfo, 0)
+	for _, p := range r.players {
+		if p.bot != nil {
+			bots = append(bots, BotInfo{ID: p.ID, Name: p.Name, Profile: p.bot.profile.Name})
+		}
+	}
+	return bots
+}
+
+// HumanCount returns the number of non-bot players in the room.
+func (r *Room) HumanCount() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	n := 0
+	for _, p := range r.players {
+		if !p.IsBot() {
+			n++
+		}
+	}
+	return n
+}
+
+// driveBots applies bot input for this tick. Called by the physics loop.
+func (r *Room) driveBots(players []*Player, dt float64) {
+	bots := r.scratch.bots[:0]
+	for _, p := range players {
+		if p.bot != nil && !p.bot.external {
+			bots = append(bots, p)
+		}
+	}
+	r.scratch.bots = bots
+	if len(bots) == 0 {
+		return
+	}
+
+	// Every bot sees the same snapshot, whichever bot moves first
+	states := r.scratch.states[:0]
+	for _, p := range players {
+		states = append(states, p.GetState())
+	}
+	r.scratch.states = states
+
+	for _, bot := range bots {
+		self := bot.GetState()
+		nearestHumanY, haveHuman := 0.0, false
+		for i, s := range states {
+			if s.ID != self.ID && !players[i].IsBot() && !s.Exploded &&
+				(!haveHuman || math.Abs(s.Y-self.Y) < math.Abs(nearestHumanY-self.Y)) {
+				nearestHumanY, haveHuman = s.Y, true
+			}
+		}
+		bot.ApplyInput(bot.bot.decide(r.road, self, states, nearestHumanY, haveHuman, dt))
+	}
+}
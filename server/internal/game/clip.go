@@ -0,0 +1,236 @@
+package game
+
+import (
+	"time"
+
+	"github.com/race/server/config"
+)
+
+// Highlight clips
+//
+// A room keeps its last config.ClipLength of state in a ring of compact
+// frames, config.ClipRate of them a second, taken from the frames the
+// physics tick publishes (see snapshot.go). Nothing is written anywhere
+// until a player (protocol v36) asks for a clip: the room then cuts the
+// ring down to the player's vicinity, the cars within config.ClipRadius of
+// theirs along the road in each frame, and the server stores it under a
+// retrieval ID (see cmd/gameserver/clips.go). Frames from before the player
+// joined are left out.
+//
+// The ring is one slab of car slots, as many per frame as the room ever
+// had cars, so recording allocates only when the room grows past that.
+// Cars are kept relative to the world origin of their frame; a clip puts
+// them all on the origin of its first frame.
+
+// Clip is a player's vicinity over the last seconds of a room
+type Clip struct {
+	Room     string            `json:"room"`
+	PlayerID uint16            `json:"playerId"`
+	Player   string            `json:"player"`
+	Created  time.Time         `json:"created"`
+	Interval float64           `json:"interval"` // Seconds between frames
+	Radius   float64           `json:"radius"`
+	Origin   float64           `json:"origin"` // Road distance of Y=0 in every frame
+	Names    map[uint16]string `json:"names"`  // Of the cars still in the room when clipped
+	Frames   []ClipFrame       `json:"frames"`
+}
+
+// ClipFrame is the cars of a clip at a physics tick
+type ClipFrame struct {
+	Tick uint64    `json:"tick"`
+	Cars []ClipCar `json:"cars"`
+}
+
+// ClipCar is a car in a clip frame
+type ClipCar struct {
+	ID       uint16  `json:"id"`
+	X        float32 `json:"x"`
+	Y        float32 `json:"y"`
+	Speed    float32 `json:"speed"`
+	Angle    float32 `json:"angle"`
+	Exploded bool    `json:"exploded,omitempty"`
+	Ghost    bool    `json:"ghost,omitempty"`
+	Airborne bool    `json:"airborne,omitempty"`
+}
+
+// Flags of a recorded car
+const (
+	clipExploded uint8 = 1 << iota
+	clipGhost
+	clipAirborne
+)
+
+// clipCar is a car as the ring keeps it
+type clipCar struct {
+	id    uint16
+	flags uint8
+	x, y  float32 // y from the frame's origin
+	speed float32
+	angle float32
+}
+
+// clipFrame is a frame of the ring; its cars are in its slot of the slab
+type clipFrame struct {
+	tick   uint64
+	origin float64
+	cars   int
+}
+
+// clipBuffer is a room's ring of recent frames. Its lock comes after the
+// room's and nothing is locked while holding it.
+type clipBuffer struct {
+	mu     orderedMutex
+	every  uint64      // Physics ticks per frame
+	frames []clipFrame // Ring of config.ClipLength
+	cars   []clipCar   // stride slots per frame
+	stride int
+	next   int // Slot of the next frame
+	held   int // Frames in the ring
+}
+
+// newClipBuffer returns the ring of a room ticking physicsRate times a second
+func newClipBuffer(physicsRate int) clipBuffer {
+	every := max(1, physicsRate/config.ClipRate)
+	n := max(1, int(config.ClipLength.Seconds())*physicsRate/every)
+	return clipBuffer{
+		mu:     orderedMutex{class: lockClips},
+		every:  uint64(every),
+		frames: make([]clipFrame, n),
+	}
+}
+
+// record keeps a published frame if one is due. Only called by the tick,
+// holding no lock.
+func (b *clipBuffer) record(f *stateFrame) {
+	if f.tick%b.every != 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := len(f.states)
+	if n > b.stride {
+		b.growUnlocked(max(n, min(2*b.stride, config.MaxPlayersPerRoom)))
+	}
+
+	slot := b.next
+	cars := b.cars[slot*b.stride:][:n]
+	for i := range f.states {
+		s := &f.states[i]
+		var flags uint8
+		if s.Exploded {
+			flags |= clipExploded
+		}
+		if s.Ghost {
+			flags |= clipGhost
+		}
+		if s.Airborne {
+			flags |= clipAirborne
+		}
+		cars[i] = clipCar{
+			id:    s.ID,
+			flags: flags,
+			x:     float32(s.X),
+			y:     float32(s.Y),
+			speed: float32(s.Speed),
+			angle: float32(s.Angle),
+		}
+	}
+	b.frames[slot] = clipFrame{tick: f.tick, origin: f.origin, cars: n}
+	b.next = (slot + 1) % len(b.frames)
+	b.held = min(b.held+1, len(b.frames))
+}
+
+// growUnlocked gives every frame stride car slots, keeping the frames held.
+// IMPORTANT: Caller must hold the buffer's lock.
+func (b *clipBuffer) growUnlocked(stride int) {
+	cars := make([]clipCar, len(b.frames)*stride)
+	if b.stride > 0 {
+		for slot, f := range b.frames {
+			copy(cars[slot*stride:], b.cars[slot*b.stride:][:f.cars])
+		}
+	}
+	b.cars, b.stride = cars, stride
+}
+
+// Clip cuts the room's recent frames down to the vicinity of a player in
+// the room. ErrClipEmpty if no frame has their car yet.
+func (r *Room) Clip(playerID uint16) (*Clip, error) {
+	r.mu.RLock()
+	p, exists := r.players[playerID]
+	names := make(map[uint16]string, len(r.players))
+	for id, other := range r.players {
+		names[id] = other.GetName()
+	}
+	r.mu.RUnlock()
+
+	if !exists {
+		return nil, ErrPlayerNotFound
+	}
+
+	b := &r.clips
+	clip := &Clip{
+		Room:     r.ID,
+		PlayerID: playerID,
+		Player:   p.GetName(),
+		Created:  time.Now(),
+		Interval: float64(b.every) / float64(r.physicsRate),
+		Radius:   config.ClipRadius,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	oldest := (b.next - b.held + len(b.frames)) % len(b.frames)
+	for i := 0; i < b.held; i++ {
+		slot := (oldest + i) % len(b.frames)
+		f := b.frames[slot]
+		cars := b.cars[slot*b.stride:][:f.cars]
+
+		own := -1
+		for j := range cars {
+			if cars[j].id == playerID {
+				own = j
+				break
+			}
+		}
+		if own < 0 {
+			continue
+		}
+		if len(clip.Frames) == 0 {
+			clip.Origin = f.origin
+		}
+
+		frame := ClipFrame{Tick: f.tick}
+		for _, c := range cars {
+			if dy := c.y - cars[own].y; dy > config.ClipRadius || dy < -config.ClipRadius {
+				continue
+			}
+			frame.Cars = append(frame.Cars, ClipCar{
+				ID:       c.id,
+				X:        c.x,
+				Y:        float32(float64(c.y) + f.origin - clip.Origin),
+				Speed:    c.speed,
+				Angle:    c.angle,
+				Exploded: c.flags&clipExploded != 0,
+				Ghost:    c.flags&clipGhost != 0,
+				Airborne: c.flags&clipAirborne != 0,
+			})
+		}
+		clip.Frames = append(clip.Frames, frame)
+	}
+	if len(clip.Frames) == 0 {
+		return nil, ErrClipEmpty
+	}
+
+	clip.Names = make(map[uint16]string)
+	for _, f := range clip.Frames {
+		for _, c := range f.Cars {
+			if name, ok := names[c.ID]; ok {
+				clip.Names[c.ID] = name
+			}
+		}
+	}
+	return clip, nil
+}
@@ -1,7 +1,8 @@
 package game
 
 import (
-	"sync"
+	"slices"
+	"time"
 )
 
 // CellKey represents a cell in the spatial grid
@@ -10,20 +11,40 @@ type CellKey struct {
 }
 
 // SpatialGrid implements spatial partitioning for efficient collision detection
+// and proximity queries (see proximity.go)
+//
+// Cells are reused between updates and dropped as soon as they are empty, so
+// the grid only ever holds the cells currently occupied by players. The
+// memory of dropped cells goes to the next cells players move into.
 type SpatialGrid struct {
-	mu       sync.RWMutex
-	cellSize float64
-	cells    map[CellKey][]*Player
+	mu         orderedRWMutex
+	cellSize   float64
+	keepGhosts bool // Ghosts are inserted too (proximity grids)
+	noPairs    bool // GetPotentialCollisions finds none (rooms without collisions)
+	cells      map[CellKey][]*Player
+	checked    map[uint32]bool // Pair dedup scratch space, reused between ticks
+	pairs      [][2]*Player    // Result of GetPotentialCollisions, reused between ticks
+	spare      [][]*Player     // Emptied cells, reused for newly occupied ones
 }
 
 // NewSpatialGrid creates a new spatial grid
 func NewSpatialGrid(cellSize float64) *SpatialGrid {
 	return &SpatialGrid{
+		mu:       orderedRWMutex{class: lockGrid},
 		cellSize: cellSize,
 		cells:    make(map[CellKey][]*Player),
+		checked:  make(map[uint32]bool),
 	}
 }
 
+// Cells returns the number of occupied cells
+func (g *SpatialGrid) Cells() int {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return len(g.cells)
+}
+
 // getCellKey returns the cell key for a position
 func (g *SpatialGrid) getCellKey(x, y float64) CellKey {
 	return CellKey{
@@ -52,21 +73,63 @@ func (g *SpatialGrid) Insert(p *Player) {
 	g.cells[key] = append(g.cells[key], p)
 }
 
+// Remove takes a player who left out of the grid, which a room stops
+// updating while it is suspended
+func (g *SpatialGrid) Remove(p *Player) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for key, cell := range g.cells {
+		if i := slices.Index(cell, p); i >= 0 {
+			cell = slices.Delete(cell, i, i+1) // Clears the vacated slot
+			if len(cell) == 0 {
+				g.spare = append(g.spare, cell)
+				delete(g.cells, key)
+			} else {
+				g.cells[key] = cell
+			}
+		}
+	}
+}
+
 // Update rebuilds the grid with all players
 func (g *SpatialGrid) Update(players []*Player) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
-	// Clear all cells
-	g.cells = make(map[CellKey][]*Player)
+	// Empty all cells, keeping their backing arrays. Clearing the slots
+	// drops references to players that may have left the room.
+	for key, cell := range g.cells {
+		clear(cell)
+		g.cells[key] = cell[:0]
+	}
 
-	// Insert all players
+	// Insert all players. Ghosts don't collide, so collision grids leave
+	// them out and they never become part of a potential collision.
+	now := time.Now()
 	for _, p := range players {
 		p.mu.RLock()
 		key := g.getCellKey(p.X, p.Y)
+		ghost := p.ghostedUnlocked(now)
 		p.mu.RUnlock()
 
-		g.cells[key] = append(g.cells[key], p)
+		if !ghost || g.keepGhosts {
+			cell, ok := g.cells[key]
+			if !ok && len(g.spare) > 0 {
+				cell = g.spare[len(g.spare)-1]
+				g.spare = g.spare[:len(g.spare)-1]
+			}
+			g.cells[key] = append(cell, p)
+		}
+	}
+
+	// Sweep cells nobody occupies any more (players move through
+	// thousands of cells along the road)
+	for key, cell := range g.cells {
+		if len(cell) == 0 {
+			g.spare = append(g.spare, cell)
+			delete(g.cells, key)
+		}
 	}
 }
 
@@ -98,13 +161,52 @@ func (g *SpatialGrid) GetNearbyPlayers(p *Player) []*Player {
 	return nearby
 }
 
-// GetPotentialCollisions returns pairs of players that might collide
-func (g *SpatialGrid) GetPotentialCollisions() [][2]*Player {
+// GetPlayersWithin appends to buf the players within radius of p (p itself
+// excluded) and returns it. The radius must not exceed the cell size: only
+// the 3x3 cells around p are searched.
+func (g *SpatialGrid) GetPlayersWithin(p *Player, radius float64, buf []*Player) []*Player {
 	g.mu.RLock()
 	defer g.mu.RUnlock()
 
-	checked := make(map[uint32]bool)
-	var pairs [][2]*Player
+	p.mu.RLock()
+	x, y := p.X, p.Y
+	p.mu.RUnlock()
+	centerKey := g.getCellKey(x, y)
+
+	for dx := int64(-1); dx <= 1; dx++ {
+		for dy := int64(-1); dy <= 1; dy++ {
+			key := CellKey{X: centerKey.X + dx, Y: centerKey.Y + dy}
+			for _, other := range g.cells[key] {
+				if other.ID == p.ID {
+					continue
+				}
+				other.mu.RLock()
+				ox, oy := other.X-x, other.Y-y
+				other.mu.RUnlock()
+				if ox*ox+oy*oy <= radius*radius {
+					buf = append(buf, other)
+				}
+			}
+		}
+	}
+	return buf
+}
+
+// GetPotentialCollisions returns pairs of players that might collide. The
+// slice is only valid until the next call, which reuses it.
+func (g *SpatialGrid) GetPotentialCollisions() [][2]*Player {
+	if g.noPairs {
+		return nil
+	}
+
+	// Write lock: the pair dedup map and the result are shared scratch space
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	checked := g.checked
+	clear(checked)
+	clear(g.pairs) // Drop players of the previous call
+	pairs := g.pairs[:0]
 
 	for _, players := range g.cells {
 		for i := 0; i < len(players); i++ {
@@ -161,5 +263,6 @@ func (g *SpatialGrid) GetPotentialCollisions() [][2]*Player {
 		}
 	}
 
+	g.pairs = pairs
 	return pairs
 }
@@ -0,0 +1,63 @@
+package game
+
+import (
+	"fmt"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/network"
+)
+
+// Collision modes
+//
+// A room's cars push each other when they touch (see
+// Physics.CheckCollision), unless its config says otherwise: "soft"
+// collisions push and slow the cars by config.SoftCollisionFactor of the
+// usual, and "off" turns car-to-car contact off entirely, for time trials
+// through traffic. Without collisions the room never looks for touching
+// cars (see SpatialGrid.GetPotentialCollisions), so nobody rams, gets
+// penalized for it or hears of a crash, and the broadcast director has no
+// collision shots. Ghosts and airborne cars drive through the others in
+// every mode. Rooms tell ProtocolV37 clients their mode in RoomInfo, which
+// older clients can't read: as they'd predict pushes the server doesn't
+// make, they can only join rooms with collisions on.
+
+// CollisionMode is how a room's cars collide. Values are as on the wire.
+type CollisionMode uint8
+
+const (
+	CollisionsOn   = CollisionMode(network.CollisionsOn)
+	CollisionsSoft = CollisionMode(network.CollisionsSoft)
+	CollisionsOff  = CollisionMode(network.CollisionsOff)
+)
+
+// ParseCollisionMode parses a COLLISIONS value or a room config's
+// collisions ("" is on)
+func ParseCollisionMode(s string) (CollisionMode, error) {
+	switch s {
+	case "", "on":
+		return CollisionsOn, nil
+	case "soft":
+		return CollisionsSoft, nil
+	case "off":
+		return CollisionsOff, nil
+	}
+	return CollisionsOn, fmt.Errorf("unknown collision mode %q (on, soft or off)", s)
+}
+
+var collisionModeNames = [...]string{"on", "soft", "off"}
+
+func (m CollisionMode) String() string {
+	if int(m) >= len(collisionModeNames) {
+		return fmt.Sprintf("CollisionMode(%d)", uint8(m))
+	}
+	return collisionModeNames[m]
+}
+
+// push returns how much of the usual push and slowdown a collision in
+// this mode has
+func (m CollisionMode) push() float64 {
+	if m == CollisionsSoft {
+		return config.SoftCollisionFactor
+	}
+	return 1
+}
@@ -0,0 +1,81 @@
+package game
+
+import (
+	"math"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/network"
+)
+
+// Dead reckoning
+//
+// Clients that negotiated protocol v3 keep extrapolating a remote player from
+// the last record they received until a newer one arrives; a player missing
+// from a state update is "unchanged", and players only disappear with a
+// PlayerLeave message. The room tracks the last record sent to each such
+// receiver and leaves out players whose extrapolated position is still
+// within config.DeadReckoningMaxError of the real one. A receiver's own
+// record is always sent, so server corrections reach it promptly.
+
+// sentRecord is the last state record of one player sent to a receiver
+type sentRecord struct {
+	player *Player // Detects a reused ID
+	data   network.PlayerStateData
+	tick   uint64
+}
+
+// predictable reports whether a receiver that got rec at tick rec.tick can
+// extrapolate cur well enough at tick. Mirrors the client's extrapolation:
+// forward along the velocity, sideways for at most DeadReckoningLateralLimit.
+func (rec sentRecord) predictable(cur network.PlayerStateData, tick uint64, tickRate int) bool {
+	age := float64(tick-rec.tick) / float64(tickRate)
+	if age > config.DeadReckoningKeyframe.Seconds() {
+		return false
+	}
+
+	// Discrete state has to be exact
+	sent := rec.data
+	if cur.Flags != sent.Flags || cur.Color != sent.Color || cur.Angle != sent.Angle || cur.Lane != sent.Lane {
+		return false
+	}
+
+	predictedX := float64(sent.X)/10 + float64(sent.VelX)/10*math.Min(age, config.DeadReckoningLateralLimit)
+	predictedY := float64(sent.Y) + float64(sent.VelY)/10*age
+	dx := float64(cur.X)/10 - predictedX
+	dy := float64(cur.Y) - predictedY
+	return dx*dx+dy*dy <= config.DeadReckoningMaxError*config.DeadReckoningMaxError
+}
+
+// Predictable reports whether a dead reckoning receiver that got sent at
+// tick sentTick extrapolates cur well enough at tick, as the broadcast
+// decides it. For tools replaying recorded state (cmd/codecbench).
+func Predictable(sent, cur network.PlayerStateData, sentTick, tick uint64, tickRate int) bool {
+	return sentRecord{data: sent, tick: sentTick}.predictable(cur, tick, tickRate)
+}
+
+// deltaRecordsUnlocked appends the records receiver needs this tick to
+// records and remembers them as sent. players and stateData are parallel.
+// Only called from the broadcast loop, which owns receiver.sent.
+// IMPORTANT: Caller must hold the room lock (read or write).
+func (r *Room) deltaRecordsUnlocked(records []network.PlayerStateData, receiver *Player, players []*Player, stateData []network.PlayerStateData, tick uint64) []network.PlayerStateData {
+	if receiver.sent == nil {
+		receiver.sent = make(map[uint16]sentRecord, len(players))
+	}
+
+	for i, data := range stateData {
+		rec, ok := receiver.sent[data.ID]
+		if ok && rec.player == players[i] && players[i] != receiver && rec.predictable(data, tick, r.physicsRate) {
+			continue
+		}
+		receiver.sent[data.ID] = sentRecord{player: players[i], data: data, tick: tick}
+		records = append(records, data)
+	}
+
+	// Forget players that left the room
+	for id, rec := range receiver.sent {
+		if r.players[id] != rec.player {
+			delete(receiver.sent, id)
+		}
+	}
+	return records
+}
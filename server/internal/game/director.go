@@ -0,0 +1,328 @@
+package game
+
+import (
+	"math"
+	"slices"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/network"
+)
+
+// Broadcast director
+//
+// Tournament broadcasts watch a room as spectators: ProtocolV16 connections
+// without a car that get what players get of the room (joins, leaves, host
+// changes, rebases, results and state updates with every car) but never
+// drive and don't keep the room alive. Every config.DirectorInterval the
+// room's director picks the car their cameras should follow and sends a
+// Director message when the shot changes. Shots, most important first:
+//
+//   - battle: in the last config.DirectorFinalStretch of a round, the two
+//     leaders of the standings within config.DirectorBattleGap of each other
+//   - crash: one of the top config.DirectorCrashTop of the standings exploded
+//   - lead change: another car leads the standings
+//   - collision: two cars within config.DirectorCollisionRange that would
+//     touch within config.DirectorCollisionHorizon at their current
+//     velocities (the pair with the most rating between them), in rooms
+//     with collisions
+//   - leader: the leader of the standings, when nothing else happens
+//
+// The standings are the ratings of the runs in progress, as on the players'
+// leaderboards; exploded cars aren't in them. A shot is held for at least
+// config.DirectorMinShot unless a more important one comes up or its car
+// leaves, so the camera doesn't flicker between cars. The director reads
+// only the published state frame (see snapshot.go) and runs only while
+// someone watches.
+
+// directorShot is what the spectators' cameras follow
+type directorShot struct {
+	target, second uint16 // Target 0: no car to follow
+	reason         uint8  // network.Director*
+}
+
+// shotPriority ranks shot reasons: a more important shot cuts in before
+// the current one was held for config.DirectorMinShot
+var shotPriority = map[uint8]int{
+	network.DirectorLeader:     0,
+	network.DirectorCollision:  1,
+	network.DirectorLeadChange: 2,
+	network.DirectorCrash:      3,
+	network.DirectorBattle:     4,
+}
+
+// directorState is the director of a room. Only touched by the tick.
+type directorState struct {
+	shot  directorShot
+	since time.Time // Start of the shot (zero before the first)
+
+	leader uint16   // Leader of the standings at the last pass
+	top    []uint16 // Top config.DirectorCrashTop at the last pass
+	ranks  []int    // Indices of the frame's states, best first (reused)
+}
+
+// AddSpectator lets a ProtocolV16 connection watch the room without a car.
+// The spectator gets the room's info (with player ID 0) right away, and its
+// cars and the current shot after the room's spectator delay.
+func (r *Room) AddSpectator(conn PlayerConnection) error {
+	return r.addSpectator(conn, spectator{})
+}
+
+// addSpectator adds a broadcast spectator or a viewer (see spectate.go)
+func (r *Room) addSpectator(conn PlayerConnection, s spectator) error {
+	if conn.ProtocolVersion() < network.ProtocolV16 {
+		return ErrSpectateUnsupported
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.spectators[conn]; ok {
+		return nil
+	}
+	if r.spectatorsUnlocked(s.viewer) >= s.limit() {
+		return ErrSpectatorsFull
+	}
+	if r.spectators == nil {
+		r.spectators = make(map[PlayerConnection]spectator)
+	}
+	r.spectators[conn] = s
+
+	// The rest of the view waits out the room's spectator delay
+	conn.Send(r.roomInfoMessageUnlocked(conn.ProtocolVersion(), 0))
+	r.spectateToUnlocked(conn, r.tickRateMessage())
+	if r.sendsTrack() {
+		r.spectateToUnlocked(conn, r.trackMessage(conn.ProtocolVersion()))
+	}
+	if r.road.Origin() != 0 {
+		r.spectateToUnlocked(conn, r.rebaseMessage())
+	}
+	for id, p := range r.players {
+		r.spectateToUnlocked(conn, r.protocol.EncodePlayerJoin(id, p.Name, p.Color))
+		if p.appearance != (Appearance{}) {
+			r.spectateToUnlocked(conn, p.appearanceMessage(r.protocol))
+		}
+	}
+	if r.hosted {
+		r.spectateToUnlocked(conn, r.protocol.EncodeHostChange(r.hostID))
+	}
+	if r.shotMsg != nil {
+		r.spectateToUnlocked(conn, r.shotMsg)
+	}
+	return nil
+}
+
+// RemoveSpectator stops a spectator's updates. Safe to call for
+// connections that don't watch the room.
+func (r *Room) RemoveSpectator(conn PlayerConnection) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.spectators, conn)
+}
+
+// SpectatorCount returns the number of broadcast spectators watching the
+// room (viewers aside, see ViewerCount).
+func (r *Room) SpectatorCount() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.spectatorsUnlocked(false)
+}
+
+// spectateUnlocked sends a message to the spectators.
+// IMPORTANT: Caller must hold the room lock (read or write).
+func (r *Room) spectateUnlocked(data []byte) {
+	if r.delayed.delay > 0 {
+		if len(r.spectators) > 0 {
+			r.delayed.push(delayedMessage{data: data})
+		}
+		return
+	}
+	for conn := range r.spectators {
+		conn.Send(data)
+	}
+}
+
+// dismissSpectators tells the spectators the room closed and disconnects
+// them. Called when the room stops.
+func (r *Room) dismissSpectators() {
+	r.mu.Lock()
+	spectators := r.spectators
+	r.spectators, r.shotMsg = nil, nil
+	r.delayed.mu.Lock()
+	r.delayed.resetUnlocked()
+	r.delayed.mu.Unlock()
+	r.mu.Unlock()
+
+	msg := r.protocol.EncodeError(network.ErrorCodeRoomFull, "Room closed")
+	for conn := range spectators {
+		conn.Send(msg)
+		conn.Close()
+	}
+}
+
+// directTick picks the spectators' shot from the latest frame and sends it
+// if it changed. Called by the game loop.
+func (r *Room) directTick(now time.Time) {
+	r.mu.RLock()
+	watched := len(r.spectators) > 0
+	r.mu.RUnlock()
+	d := &r.director
+	if !watched {
+		started := !d.since.IsZero()
+		*d = directorState{ranks: d.ranks[:0], top: d.top[:0]}
+		if started {
+			r.mu.Lock()
+			r.shotMsg = nil // The next spectator gets a fresh shot
+			r.mu.Unlock()
+		}
+		return
+	}
+
+	states := r.frames.published().states
+	next := d.nextShot(states, r.finalStretch(), r.physics.collisions != CollisionsOff)
+
+	// Cut when the shot is more important, held long enough, or lost its car
+	cur := d.shot
+	cut := d.since.IsZero() || shotPriority[next.reason] > shotPriority[cur.reason] ||
+		now.Sub(d.since) >= config.DirectorMinShot || !inFrame(states, cur.target)
+	if next == cur || !cut {
+		return
+	}
+	d.shot, d.since = next, now
+
+	msg := r.protocol.EncodeDirector(next.target, next.second, next.reason)
+	r.mu.Lock()
+	r.shotMsg = msg
+	r.spectateUnlocked(msg)
+	r.mu.Unlock()
+}
+
+// finalStretch reports whether a public room's round is about to end.
+// Only called by the tick, which owns the round.
+func (r *Room) finalStretch() bool {
+	return r.practice == nil && r.round.elapsed >= (config.RoundLength-config.DirectorFinalStretch).Seconds()
+}
+
+// nextShot returns the most important shot of states, updating the
+// standings kept from the last pass. Collision shots need cars that collide.
+func (d *directorState) nextShot(states []PlayerState, finalStretch, collide bool) directorShot {
+	// Standings: driving cars by rating, best first
+	ranks := d.ranks[:0]
+	for i := range states {
+		if !states[i].Exploded {
+			ranks = append(ranks, i)
+		}
+	}
+	slices.SortFunc(ranks, func(a, b int) int {
+		if states[a].Rating != states[b].Rating {
+			if states[a].Rating > states[b].Rating {
+				return -1
+			}
+			return 1
+		}
+		return int(states[a].ID) - int(states[b].ID)
+	})
+	d.ranks = ranks
+
+	var leader uint16
+	if len(ranks) > 0 {
+		leader = states[ranks[0]].ID
+	}
+	lastLeader, lastTop := d.leader, d.top
+	defer func() {
+		d.leader = leader
+		top := d.top[:0]
+		for i := 0; i < len(ranks) && i < config.DirectorCrashTop; i++ {
+			top = append(top, states[ranks[i]].ID)
+		}
+		d.top = top
+	}()
+
+	if finalStretch && len(ranks) >= 2 {
+		a, b := &states[ranks[0]], &states[ranks[1]]
+		if math.Abs(a.Y-b.Y) <= config.DirectorBattleGap {
+			return directorShot{target: a.ID, second: b.ID, reason: network.DirectorBattle}
+		}
+	}
+	for _, id := range lastTop {
+		if i := stateIndex(states, id); i >= 0 && states[i].Exploded {
+			return directorShot{target: id, reason: network.DirectorCrash}
+		}
+	}
+	if leader != 0 && lastLeader != 0 && leader != lastLeader {
+		return directorShot{target: leader, second: lastLeader, reason: network.DirectorLeadChange}
+	}
+	if a, b, ok := imminentCollision(states); collide && ok {
+		return directorShot{target: a, second: b, reason: network.DirectorCollision}
+	}
+	return directorShot{target: leader, reason: network.DirectorLeader}
+}
+
+// imminentCollision returns the pair of driving cars that would touch
+// soonest within the horizon, preferring the pair with the most rating.
+// The higher rated car comes first.
+func imminentCollision(states []PlayerState) (uint16, uint16, bool) {
+	var best [2]*PlayerState
+	bestRating := -1.0
+	for i := range states {
+		a := &states[i]
+		if a.Exploded || a.Ghost {
+			continue
+		}
+		for j := i + 1; j < len(states); j++ {
+			b := &states[j]
+			if b.Exploded || b.Ghost {
+				continue
+			}
+			dx, dy := b.X-a.X, b.Y-a.Y
+			if dx*dx+dy*dy > config.DirectorCollisionRange*config.DirectorCollisionRange {
+				continue
+			}
+			if !closing(dx, dy, b.VelX-a.VelX, b.VelY-a.VelY) {
+				continue
+			}
+			if rating := a.Rating + b.Rating; rating > bestRating {
+				best, bestRating = [2]*PlayerState{a, b}, rating
+			}
+		}
+	}
+	if best[0] == nil {
+		return 0, 0, false
+	}
+	if best[1].Rating > best[0].Rating {
+		best[0], best[1] = best[1], best[0]
+	}
+	return best[0].ID, best[1].ID, true
+}
+
+// closing reports whether two cars at offset (dx, dy) moving apart at
+// (vx, vy) come within a car's width of each other within the horizon
+func closing(dx, dy, vx, vy float64) bool {
+	speed2 := vx*vx + vy*vy
+	if speed2 == 0 {
+		return false
+	}
+	t := -(dx*vx + dy*vy) / speed2 // Time of closest approach
+	if t <= 0 || t > config.DirectorCollisionHorizon {
+		return false
+	}
+	cx, cy := dx+vx*t, dy+vy*t
+	return cx*cx+cy*cy <= config.CarWidth*config.CarWidth
+}
+
+// stateIndex returns the index of a player's state, -1 if not in states
+func stateIndex(states []PlayerState, id uint16) int {
+	for i := range states {
+		if states[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// inFrame reports whether a shot's target is still in the room (the empty
+// shot always is)
+func inFrame(states []PlayerState, id uint16) bool {
+	return id == 0 || stateIndex(states, id) >= 0
+}
@@ -0,0 +1,31 @@
+package game
+
+import (
+	"math"
+
+	"github.com/race/server/config"
+)
+
+// Fuel mode
+//
+// Rooms can run on fuel (RoomConfig.Fuel): throttle burns fuel, and pit
+// zones along the road (see track.Track.InPit) refill cars on the road in
+// them, so drivers have to choose when to slow down and fill up. A car with
+// an empty tank limps on, held to config.FuelDrySpeed, until it reaches a
+// pit. Braking is free, and cars in the air neither burn nor refill. Every car starts with a full tank, and gets a new one when it
+// respawns, along with its new run. The server alone tracks fuel: ProtocolV20
+// state updates end with the receiver's own extended state, its fuel among
+// it (see ownState in ack.go), and older clients can't join fuel rooms.
+
+// fuelUnlocked refills a car in a pit and burns the fuel of the throttle it
+// asks for. An empty tank still pulls the car along: UpdatePlayer caps its
+// speed instead. onRoad is whether the car's center is on the road.
+// IMPORTANT: Caller must hold p.mu.
+func (ph *Physics) fuelUnlocked(p *Player, accForce float64, onRoad bool, dt float64) {
+	if onRoad && ph.road.InPit(p.Y) {
+		p.Fuel = math.Min(config.FuelCapacity, p.Fuel+config.FuelRefill*dt)
+	}
+	if accForce > 0 {
+		p.Fuel = math.Max(0, p.Fuel-config.FuelBurn*accForce/config.Acceleration*dt)
+	}
+}
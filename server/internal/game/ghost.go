@@ -0,0 +1,136 @@
+package game
+
+import (
+	"sort"
+	"time"
+)
+
+// Ghost mode
+//
+// A ghost's collisions are skipped: other cars drive through it and it
+// drives through them. Ghosts are left out of the spatial grid, so they
+// never show up as potential collisions, and clients get FlagGhost in state
+// records to draw them translucent. Several reasons can ghost a player at
+// once, each with its own expiry; the player is a ghost while any holds.
+
+// GhostReason says why a player is a ghost
+type GhostReason uint8
+
+const (
+	GhostPenalty  GhostReason = iota // Griefing penalty (see griefing.go)
+	GhostAdmin                       // Set through the admin API
+	GhostPractice                    // Replay car in a practice room
+	GhostRules                       // Set by the room's rules (see rules.go)
+	GhostRelay                       // Relay teammate waiting for the baton (see relay.go)
+	GhostRace                        // On a race's starting grid (see race.go)
+)
+
+// String returns the reason's name as used in the admin API
+func (g GhostReason) String() string {
+	switch g {
+	case GhostPenalty:
+		return "penalty"
+	case GhostAdmin:
+		return "admin"
+	case GhostPractice:
+		return "practice"
+	case GhostRules:
+		return "rules"
+	case GhostRelay:
+		return "relay"
+	case GhostRace:
+		return "race"
+	}
+	return "unknown"
+}
+
+// SetGhost makes the player a ghost for d, or until ClearGhost if d <= 0.
+func (p *Player) SetGhost(reason GhostReason, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var until time.Time
+	if d > 0 {
+		until = time.Now().Add(d)
+	}
+	p.setGhostUnlocked(reason, until)
+}
+
+// setGhostUnlocked ghosts the player until the given time (zero: until
+// cleared).
+// IMPORTANT: Caller must hold p.mu.
+func (p *Player) setGhostUnlocked(reason GhostReason, until time.Time) {
+	if p.ghosts == nil {
+		p.ghosts = make(map[GhostReason]time.Time, 1)
+	}
+	p.ghosts[reason] = until
+}
+
+// ClearGhost lifts one reason for the player being a ghost.
+func (p *Player) ClearGhost(reason GhostReason) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.ghosts, reason)
+}
+
+// Ghosted reports whether the player's collisions are currently off.
+func (p *Player) Ghosted() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.ghostedUnlocked(time.Now())
+}
+
+// GhostReasons lists the reasons the player is a ghost right now.
+func (p *Player) GhostReasons() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	now := time.Now()
+	reasons := make([]string, 0, len(p.ghosts))
+	for reason, until := range p.ghosts {
+		if until.IsZero() || now.Before(until) {
+			reasons = append(reasons, reason.String())
+		}
+	}
+	sort.Strings(reasons)
+	return reasons
+}
+
+// ghostedUnlocked reports whether the player is a ghost at now.
+// IMPORTANT: Caller must hold p.mu (read or write).
+func (p *Player) ghostedUnlocked(now time.Time) bool {
+	for _, until := range p.ghosts {
+		if until.IsZero() || now.Before(until) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetGhost ghosts a player in the room for d (d <= 0: until cleared).
+func (r *Room) SetGhost(playerID uint16, reason GhostReason, d time.Duration) error {
+	r.mu.RLock()
+	p, exists := r.players[playerID]
+	r.mu.RUnlock()
+
+	if !exists {
+		return ErrPlayerNotFound
+	}
+	p.SetGhost(reason, d)
+	return nil
+}
+
+// ClearGhost lifts one ghost reason from a player in the room.
+func (r *Room) ClearGhost(playerID uint16, reason GhostReason) error {
+	r.mu.RLock()
+	p, exists := r.players[playerID]
+	r.mu.RUnlock()
+
+	if !exists {
+		return ErrPlayerNotFound
+	}
+	p.ClearGhost(reason)
+	return nil
+}
@@ -0,0 +1,138 @@
+package game
+
+import (
+	"log"
+	"math"
+	"time"
+
+	"github.com/race/server/config"
+)
+
+// Ram griefing
+//
+// Some players turn around or park across the road to wreck the pack. A
+// collision counts as a ram strike against a car that drives against the
+// traffic into another car, or that stands in the road while another car
+// runs into it. Normal racing contact (both cars going the same way) never
+// counts. Penalties escalate: enough strikes within config.RamWindow ghost
+// the player (their collisions are off, see ghost.go) for
+// config.RamGhostDuration; earning a ghost again within
+// config.RamOffenseDecay of the last one gets them kicked. Bots are exempt.
+
+// ramPenalty is the consequence of a ram strike
+type ramPenalty int
+
+const (
+	ramNone ramPenalty = iota
+	ramGhost
+	ramKick
+)
+
+// ramRecord tracks a player's ram strikes
+type ramRecord struct {
+	strikes     []time.Time          // Strikes within RamWindow
+	contacts    map[uint16]time.Time // Last strike per victim
+	lastOffense time.Time            // When the player was last ghosted
+}
+
+// rams reports whether a's part in its collision with b is a ram: a closes
+// in on b against the traffic, or b runs into a standing in the road.
+func rams(a, b PlayerState) bool {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	dist := math.Hypot(dx, dy)
+	if dist == 0 {
+		return false
+	}
+	nx, ny := dx/dist, dy/dist
+
+	// Gentle contact is never a ram
+	closing := (a.VelX-b.VelX)*nx + (a.VelY-b.VelY)*ny
+	if closing < config.RamMinClosingSpeed {
+		return false
+	}
+
+	switch {
+	case a.VelY <= -config.RamParkedSpeed:
+		// Wrong way: a heads against the traffic, towards b
+		return a.VelX*nx+a.VelY*ny > 0
+	case a.VelY < config.RamParkedSpeed:
+		// Parked (or crawling sideways across the road)
+		return true
+	}
+	return false
+}
+
+// rammer returns the player whose ramming caused the collision of p1 and
+// p2, or nil if it was normal contact.
+func rammer(p1, p2 *Player, now time.Time) *Player {
+	s1, s2 := p1.GetState(), p2.GetState()
+	if rams(s1, s2) && p1.ramEligible(now) {
+		return p1
+	}
+	if rams(s2, s1) && p2.ramEligible(now) {
+		return p2
+	}
+	return nil
+}
+
+// ramEligible reports whether ram strikes can count against the player.
+// Cars that just joined or respawned stand still for a moment.
+func (p *Player) ramEligible(now time.Time) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return !p.IsBot() && !p.Exploded && now.Sub(p.spawnedAt) >= config.RamSpawnGrace
+}
+
+// addRamStrike records a ram into victim and returns the penalty it earns.
+func (p *Player) addRamStrike(victim uint16, now time.Time) ramPenalty {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	rec := &p.ram
+	if rec.contacts == nil {
+		rec.contacts = make(map[uint16]time.Time)
+	}
+	for id, at := range rec.contacts {
+		if now.Sub(at) >= config.RamContactCooldown {
+			delete(rec.contacts, id)
+		}
+	}
+	if _, ok := rec.contacts[victim]; ok {
+		return ramNone // Same contact as before
+	}
+	rec.contacts[victim] = now
+
+	kept := rec.strikes[:0]
+	for _, at := range rec.strikes {
+		if now.Sub(at) < config.RamWindow {
+			kept = append(kept, at)
+		}
+	}
+	rec.strikes = append(kept, now)
+	if len(rec.strikes) < config.RamStrikes {
+		return ramNone
+	}
+
+	rec.strikes = rec.strikes[:0]
+	if !rec.lastOffense.IsZero() && now.Sub(rec.lastOffense) < config.RamOffenseDecay {
+		return ramKick
+	}
+	rec.lastOffense = now
+	p.setGhostUnlocked(GhostPenalty, now.Add(config.RamGhostDuration))
+	return ramGhost
+}
+
+// penalizeRams escalates the penalties of players that rammed this tick.
+// Pairs are [rammer, victim].
+func (r *Room) penalizeRams(rams [][2]*Player, now time.Time) {
+	for _, ram := range rams {
+		p := ram[0]
+		switch p.addRamStrike(ram[1].ID, now) {
+		case ramGhost:
+			log.Printf("Player %s (ID: %d) ghosted for %v: ramming", p.Name, p.ID, config.RamGhostDuration)
+		case ramKick:
+			r.kickPlayer(p, "Ramming other players")
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package game
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/network"
+)
+
+// TestMain keeps the game's own logging (joins, explosions, ...) out of the
+// results unless -v
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+	}
+	os.Exit(m.Run())
+}
+
+// physicsDt is one tick at the default physics rate
+const physicsDt = 1.0 / float64(config.PhysicsTickRate)
+
+// discardConn is a client connection that drops everything sent to it
+type discardConn struct {
+	version uint8
+}
+
+func (c discardConn) Send(data []byte) error { return nil }
+func (c discardConn) Close() error           { return nil }
+func (c discardConn) RemoteAddr() string     { return "127.0.0.1:0" }
+func (c discardConn) RTT() time.Duration     { return 0 }
+func (c discardConn) RTTVar() time.Duration  { return 0 }
+func (c discardConn) ProtocolVersion() uint8 { return c.version }
+
+// humans numbers the human players of the tests, for unique sessions
+var humans atomic.Int32
+
+// addHuman adds a human player speaking the given protocol version to room
+func addHuman(tb testing.TB, room *Room, version uint8) *Player {
+	tb.Helper()
+	id := humans.Add(1)
+	p, err := room.AddPlayer(fmt.Sprintf("test-%d", id), fmt.Sprintf("Human %d", id), uint8(id%8), discardConn{version: version})
+	if err != nil {
+		tb.Fatalf("add player: %v", err)
+	}
+	return p
+}
+
+// addHumans adds n human players to room, speaking every protocol version
+// a client may negotiate in turn
+func addHumans(tb testing.TB, room *Room, n int) []*Player {
+	tb.Helper()
+	versions := int(network.ProtocolVersionMax-network.ProtocolVersionMin) + 1
+	players := make([]*Player, 0, n)
+	for i := 0; i < n; i++ {
+		players = append(players, addHuman(tb, room, network.ProtocolVersionMin+uint8(i%versions)))
+	}
+	return players
+}
+
+// addBots adds n bots of the default profiles to room, in name order
+func addBots(tb testing.TB, room *Room, n int) {
+	tb.Helper()
+	profiles := make([]BotProfile, 0, len(DefaultBotProfiles))
+	for _, p := range DefaultBotProfiles {
+		profiles = append(profiles, p)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	for i := 0; i < n; i++ {
+		if _, err := room.AddBot(profiles[i%len(profiles)]); err != nil {
+			tb.Fatalf("add bot: %v", err)
+		}
+	}
+}
+
+// runTicks steps a room that was not started by ticks physics ticks,
+// broadcasting at the default rate
+func runTicks(room *Room, ticks int) {
+	broadcastEvery := config.PhysicsTickRate / config.NetworkBroadcastRate
+	for tick := 0; tick < ticks; tick++ {
+		room.Step(physicsDt, tick%broadcastEvery == 0)
+	}
+}
@@ -0,0 +1,161 @@
+package game
+
+import (
+	"log"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/network"
+)
+
+// Honeypot rooms
+//
+// A honeypot room keeps players anti-cheat caught elsewhere away from
+// legitimate players: it holds bots and other flagged players only. Nothing
+// gives it away to them, it plays like a public room, but anti-cheat never
+// kicks there: what would be a kick rubberbands the car instead, so they
+// keep driving and showing how they cheat. Every correction is counted as
+// evidence against the player, along with the reason they were sent there
+// (HoneypotEvidence). Evidence lives in memory, as long as the room.
+//
+// Rooms with a cheater callback (SetOnCheater) hand it the players
+// anti-cheat would kick, instead of kicking them: the room forfeits their
+// run and lets them go without closing their connection or telling them,
+// and the callback moves them to a honeypot room. A player the callback
+// can't place is kicked as usual.
+
+// CheatEvidence is what a honeypot room saw of a flagged player
+type CheatEvidence struct {
+	ID         uint16         `json:"id"`
+	Name       string         `json:"name"`
+	Referral   string         `json:"referral"` // Why they were sent here
+	Arrived    time.Time      `json:"arrived"`
+	LastSeen   time.Time      `json:"lastSeen"`             // Of the last correction (Arrived if none)
+	Detections map[string]int `json:"detections,omitempty"` // Corrections by reason
+	Kicks      int            `json:"kicks"`                // Detections that would have been kicks
+}
+
+// honeypotState is the evidence of a honeypot room
+type honeypotState struct {
+	mu       orderedMutex
+	evidence []*CheatEvidence // Oldest arrival first, at most config.HoneypotEvidenceMax
+}
+
+// EnableHoneypot turns the room into a honeypot room. Must be called before
+// the first player joins.
+func (r *Room) EnableHoneypot() {
+	r.honeypot = &honeypotState{mu: orderedMutex{class: lockRoomMode}}
+}
+
+// Honeypot reports whether the room is a honeypot room.
+func (r *Room) Honeypot() bool {
+	return r.honeypot != nil
+}
+
+// SetOnCheater sets the callback that takes the players anti-cheat would
+// kick. It returns false if it couldn't place the player, who is then
+// kicked.
+func (r *Room) SetOnCheater(callback func(player *Player, reason string) bool) {
+	r.onCheater = callback
+}
+
+// Refer records why a player who just joined the honeypot room was sent
+// there.
+func (r *Room) Refer(playerID uint16, name, reason string) {
+	if r.honeypot == nil {
+		return
+	}
+	hp := r.honeypot
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+
+	now := time.Now().UTC()
+	if len(hp.evidence) >= config.HoneypotEvidenceMax {
+		hp.evidence = append(hp.evidence[:0], hp.evidence[1:]...)
+	}
+	hp.evidence = append(hp.evidence, &CheatEvidence{ID: playerID, Name: name, Referral: reason, Arrived: now, LastSeen: now})
+}
+
+// noteEvidence counts an anti-cheat correction of a honeypot room's player.
+// Called by the physics tick.
+func (r *Room) noteEvidence(p *Player, result ValidationResult, reason string) {
+	if r.honeypot == nil || result == ValidationValid || p.IsBot() {
+		return
+	}
+	hp := r.honeypot
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+
+	for _, e := range hp.evidence {
+		if e.ID != p.ID {
+			continue
+		}
+		if e.Detections == nil {
+			e.Detections = make(map[string]int)
+		}
+		e.Detections[reason]++
+		if result == ValidationKick {
+			e.Kicks++
+		}
+		e.LastSeen = time.Now().UTC()
+		return
+	}
+}
+
+// HoneypotEvidence returns the evidence the honeypot room collected, oldest
+// arrival first (nil if it isn't a honeypot room).
+func (r *Room) HoneypotEvidence() []CheatEvidence {
+	if r.honeypot == nil {
+		return nil
+	}
+	hp := r.honeypot
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+
+	evidence := make([]CheatEvidence, len(hp.evidence))
+	for i, e := range hp.evidence {
+		evidence[i] = *e
+		if e.Detections != nil {
+			evidence[i].Detections = make(map[string]int, len(e.Detections))
+			for reason, n := range e.Detections {
+				evidence[i].Detections[reason] = n
+			}
+		}
+	}
+	return evidence
+}
+
+// removeCheater takes out a player anti-cheat would kick: hands them to the
+// cheater callback, or kicks them if there is none or it can't place them.
+func (r *Room) removeCheater(p *Player, reason string) {
+	if r.onCheater == nil || p.IsBot() {
+		r.kickPlayer(p, reason)
+		return
+	}
+
+	// Already removed for something else this tick
+	r.mu.RLock()
+	inRoom := r.players[p.ID] == p
+	r.mu.RUnlock()
+	if !inRoom {
+		return
+	}
+
+	p.ForfeitRun()
+	if r.HandOff(p.ID) == nil {
+		return
+	}
+
+	if r.onCheater(p, reason) {
+		log.Printf("Player %s (ID: %d) caught in room %s and sent to a honeypot: %s", p.GetName(), p.ID, r.ID, reason)
+		return
+	}
+
+	// No honeypot room for them: kick as usual
+	log.Printf("Kicking player %s (ID: %d): %s", p.GetName(), p.ID, reason)
+	p.Connection.Send(r.protocol.EncodeError(network.ErrorCodeKicked, reason))
+	p.Connection.Close()
+	if r.onPlayerKick != nil {
+		r.onPlayerKick(p, reason)
+	}
+}
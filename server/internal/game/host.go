@@ -0,0 +1,122 @@
+package game
+
+import (
+	"log"
+
+	"github.com/race/server/internal/network"
+)
+
+// Host privileges
+//
+// Rooms created for a specific player (private rooms) can be hosted: the
+// creator gets host privileges. The host can kick players, start the next
+// race of a race room without waiting for more players or the last race's
+// results, and (ProtocolV38) close the room to new players or change its
+// password (see cmd/gameserver/private.go). When the host leaves, host
+// rights pass to the longest-connected remaining player so the room is
+// never left without one. Public matchmaking rooms are not hosted.
+
+// SetHosted enables or disables host privileges for the room.
+// Must be called before the first player joins.
+func (r *Room) SetHosted(hosted bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.hosted = hosted
+}
+
+// HostID returns the current host's player ID (0 if none).
+func (r *Room) HostID() uint16 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.hostID
+}
+
+// IsHost reports whether the player currently holds host privileges.
+func (r *Room) IsHost(playerID uint16) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.hosted && r.hostID == playerID
+}
+
+// HostKick removes a player at the host's request.
+// Unlike anti-cheat kicks this does not trigger the kick callback, so no
+// penalty is recorded against the kicked player.
+func (r *Room) HostKick(requesterID, targetID uint16) error {
+	r.mu.RLock()
+	isHost := r.hosted && r.hostID == requesterID
+	target, exists := r.players[targetID]
+	r.mu.RUnlock()
+
+	if !isHost {
+		return ErrNotHost
+	}
+	if !exists || targetID == requesterID {
+		return ErrPlayerNotFound
+	}
+
+	log.Printf("Host %d kicked player %s (ID: %d) from room %s", requesterID, target.GetName(), targetID, r.ID)
+	target.Connection.Send(r.protocol.EncodeError(network.ErrorCodeKicked, "Kicked by host"))
+	r.RemovePlayer(targetID)
+	r.forget(target.SessionID) // See rejoin.go
+	return nil
+}
+
+// HostStartRace starts the countdown of a race room's next race at the
+// host's request, with as many players as there are. The tick picks the
+// request up, and drops it if a race is on by then.
+func (r *Room) HostStartRace(requesterID uint16) error {
+	if !r.IsHost(requesterID) {
+		return ErrNotHost
+	}
+	if r.race == nil {
+		return ErrNotRace
+	}
+
+	r.race.startNow.Store(true)
+	log.Printf("Host %d started the next race in room %s", requesterID, r.ID)
+	return nil
+}
+
+// AnnounceAccess tells the room's players (ProtocolV38) who may join it,
+// once its host changed that
+func (r *Room) AnnounceAccess(closed, password bool) {
+	r.broadcastSince(network.ProtocolV38, r.protocol.EncodeRoomSettings(closed, password))
+}
+
+// assignHostUnlocked makes the player host if the room has none.
+// IMPORTANT: Caller must hold the room write lock.
+func (r *Room) assignHostUnlocked(playerID uint16) {
+	if r.hosted && r.hostID == 0 {
+		r.hostID = playerID
+	}
+}
+
+// migrateHostUnlocked picks a new host after the current one left and
+// returns the encoded host change message, or nil if nothing changed.
+// IMPORTANT: Caller must hold the room write lock.
+func (r *Room) migrateHostUnlocked(leftID uint16) []byte {
+	if !r.hosted || r.hostID != leftID {
+		return nil
+	}
+
+	r.hostID = 0
+	var oldest *Player
+	for _, p := range r.players {
+		if p.IsBot() {
+			continue
+		}
+		if oldest == nil || p.ConnectedAt.Before(oldest.ConnectedAt) {
+			oldest = p
+		}
+	}
+	if oldest == nil {
+		return nil
+	}
+
+	r.hostID = oldest.ID
+	log.Printf("Host of room %s migrated from %d to %s (ID: %d)", r.ID, leftID, oldest.Name, oldest.ID)
+	return r.protocol.EncodeHostChange(r.hostID)
+}
@@ -0,0 +1,102 @@
+//go:build !race
+
+package game
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/race/server/config"
+)
+
+// The per-tick hot paths: the 60 Hz physics tick, the 20 Hz state broadcast
+// and the collision broad phase, each on a full room in steady state. The
+// paths reuse their buffers from one tick to the next (see tickScratch), so
+// the only allocations left are the state messages, which leave the tick
+// for the connections' write pumps. Allocations are averaged over many
+// calls: a tick in which a car explodes or a round ends may allocate, the
+// steady state may not. Not built under -race, whose instrumentation
+// allocates.
+//
+//	go test ./internal/game -run HotPath -bench HotPath
+
+// hotPath is one audited operation
+type hotPath struct {
+	name   string
+	budget float64 // Allocations per call allowed on average
+	setup  func(tb testing.TB) func()
+}
+
+// hotPaths are the audited operations
+var hotPaths = []hotPath{
+	// One message per record format without delta records (v1, v2) and one
+	// per receiver with them: 20 for the 20 receivers of setupBroadcast
+	{name: "BroadcastState", budget: 20, setup: setupBroadcast},
+	{name: "UpdatePhysics100Players", budget: 0, setup: setupPhysics},
+	{name: "GetPotentialCollisions", budget: 0, setup: setupCollisions},
+}
+
+// fullRoom returns a room of humans and bots that has driven for a few
+// seconds, so its maps and buffers are in steady state
+func fullRoom(tb testing.TB, humans int) *Room {
+	tb.Helper()
+	room := NewRoom("hotpath")
+	addHumans(tb, room, humans)
+	addBots(tb, room, config.MaxPlayersPerRoom-humans)
+	runTicks(room, 5*config.PhysicsTickRate)
+	return room
+}
+
+// setupBroadcast measures a state update of a full room to 20 human
+// players on every protocol version
+func setupBroadcast(tb testing.TB) func() {
+	return fullRoom(tb, 20).broadcastState
+}
+
+// setupPhysics measures a physics tick of a room of 100 bots
+func setupPhysics(tb testing.TB) func() {
+	room := fullRoom(tb, 0)
+	return func() {
+		room.updatePhysics(physicsDt)
+	}
+}
+
+// setupCollisions measures the broad phase over 100 cars packed on the road
+func setupCollisions(tb testing.TB) func() {
+	players := make([]*Player, config.MaxPlayersPerRoom)
+	for i := range players {
+		p := NewPlayer(uint16(i+1), "", fmt.Sprintf("Car %d", i), 0, nil)
+		p.X = float64(i%5) * config.CarWidth * 2
+		p.Y = float64(i/5) * config.CarHeight * 1.5
+		players[i] = p
+	}
+	grid := NewSpatialGrid(100) // As rooms use
+	grid.Update(players)
+	return func() {
+		grid.GetPotentialCollisions()
+	}
+}
+
+func TestHotPathAllocations(t *testing.T) {
+	for _, path := range hotPaths {
+		t.Run(path.name, func(t *testing.T) {
+			op := path.setup(t)
+			if allocs := testing.AllocsPerRun(500, op); allocs > path.budget {
+				t.Errorf("%.2f allocs/op, over the budget of %g", allocs, path.budget)
+			}
+		})
+	}
+}
+
+func BenchmarkHotPath(b *testing.B) {
+	for _, path := range hotPaths {
+		b.Run(path.name, func(b *testing.B) {
+			op := path.setup(b)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				op()
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package game
+
+import (
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/network"
+)
+
+// Collision events
+//
+// Clients predict their own car's collisions, but only the server sees
+// every contact, and how hard it was. From ProtocolV24 the room announces
+// collisions with their impact, the relative speed of the two cars at
+// contact (see Physics.CheckCollision), so clients can scale crash sounds
+// and screen shake to it. Gentle contacts, below
+// config.CollisionEventMinImpact, go unannounced, and cars grinding along
+// each other are announced at most once per config.CollisionEventCooldown.
+
+// announceImpact sends a collision of the tick to the ProtocolV24 players
+// and the spectators, unless it is too gentle or either car's last one was
+// announced too recently
+func (r *Room) announceImpact(p1, p2 *Player, impact float64, now time.Time) {
+	if impact < config.CollisionEventMinImpact || !p1.impactCooled(now) || !p2.impactCooled(now) {
+		return
+	}
+	p1.impactAt, p2.impactAt = now, now
+	r.broadcastSince(network.ProtocolV24, r.protocol.EncodeCollision(p1.ID, p2.ID, float32(impact)))
+}
+
+// impactCooled reports whether the car's last announced collision is at
+// least config.CollisionEventCooldown old
+func (p *Player) impactCooled(now time.Time) bool {
+	return now.Sub(p.impactAt) >= config.CollisionEventCooldown
+}
@@ -0,0 +1,212 @@
+package game
+
+import (
+	"time"
+)
+
+// Room inspection
+//
+// Admins investigating a suspected cheat can follow a room tick by tick
+// (the console's inspect command): every physics tick rather than the
+// sampled, quantized state broadcast, with the exact floats of every car,
+// the input it drove with and what anti-cheat holds on it, plus the
+// corrections anti-cheat made in the tick. Nothing is captured while nobody
+// inspects. Each inspector gets the frames through its own buffer; one that
+// falls behind loses frames, counted in the next frame it gets, instead of
+// slowing the room down.
+
+// InspectCar is a car at the end of a physics tick
+type InspectCar struct {
+	ID       uint16  `json:"id"`
+	Name     string  `json:"name"`
+	Bot      bool    `json:"bot,omitempty"`
+	X        float64 `json:"x"`
+	Y        float64 `json:"y"`
+	Speed    float64 `json:"speed"`
+	Angle    float64 `json:"angle"`
+	VelX     float64 `json:"velX"`
+	VelY     float64 `json:"velY"`
+	Rating   float64 `json:"rating"`
+	Lane     int     `json:"lane"`
+	Height   float64 `json:"height,omitempty"` // In the air off a jump ramp
+	VelZ     float64 `json:"velZ,omitempty"`
+	Fuel     float64 `json:"fuel"`
+	Exploded bool    `json:"exploded,omitempty"`
+	Ghost    bool    `json:"ghost,omitempty"`
+
+	// Input the car drove with
+	Keys     uint8   `json:"keys"`
+	Steering float64 `json:"steering"`
+	Throttle float64 `json:"throttle"`
+	Sequence uint8   `json:"sequence"`
+	Inputs   int     `json:"inputs"` // Input messages since the tick started
+
+	// Anti-cheat
+	LastValidX float64 `json:"lastValidX"`
+	LastValidY float64 `json:"lastValidY"`
+	Violations int     `json:"violations"`
+	Anomalies  int     `json:"sequenceAnomalies"` // In the current window (see sequence.go)
+	RamStrikes int     `json:"ramStrikes"`        // Within the window (see griefing.go)
+}
+
+// InspectVerdict is a correction anti-cheat made in a tick
+type InspectVerdict struct {
+	ID     uint16 `json:"id"`
+	Result string `json:"result"` // rubberband, explode or kick
+	Reason string `json:"reason"`
+}
+
+// InspectFrame is a room at the end of a physics tick
+type InspectFrame struct {
+	Tick     uint64           `json:"tick"`
+	Time     time.Time        `json:"time"`
+	Dt       float64          `json:"dt"`     // Seconds simulated
+	Origin   float64          `json:"origin"` // Road distance of Y=0 (see rebase.go)
+	Dropped  int              `json:"dropped,omitempty"`
+	Cars     []InspectCar     `json:"cars"`
+	Verdicts []InspectVerdict `json:"verdicts,omitempty"`
+}
+
+// inspector is an admin following the room
+type inspector struct {
+	frames  chan InspectFrame
+	dropped int // Frames lost since the last one sent; only touched by the tick
+}
+
+// inspectState is a room's inspectors, under the room's lock, and the
+// tick's verdicts, only touched by the tick
+type inspectState struct {
+	inspectors map[*inspector]bool
+	verdicts   []InspectVerdict
+}
+
+var verdictNames = map[ValidationResult]string{
+	ValidationRubberband: "rubberband",
+	ValidationExplode:    "explode",
+	ValidationKick:       "kick",
+}
+
+// Inspect streams the room's physics ticks, up to buffer frames ahead of
+// the reader, until stop is called or the room stops (which closes frames).
+func (r *Room) Inspect(buffer int) (frames <-chan InspectFrame, stop func()) {
+	in := &inspector{frames: make(chan InspectFrame, buffer)}
+
+	r.mu.Lock()
+	if r.inspect.inspectors == nil {
+		r.inspect.inspectors = make(map[*inspector]bool)
+	}
+	r.inspect.inspectors[in] = true
+	r.inspecting.Add(1)
+	r.mu.Unlock()
+
+	return in.frames, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.removeInspectorUnlocked(in)
+	}
+}
+
+// removeInspectorUnlocked stops and closes an inspector's stream.
+// IMPORTANT: Caller must hold the room's write lock.
+func (r *Room) removeInspectorUnlocked(in *inspector) {
+	if !r.inspect.inspectors[in] {
+		return
+	}
+	delete(r.inspect.inspectors, in)
+	r.inspecting.Add(-1)
+	close(in.frames)
+}
+
+// closeInspectors ends every inspection. Called when the room stops.
+func (r *Room) closeInspectors() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for in := range r.inspect.inspectors {
+		r.removeInspectorUnlocked(in)
+	}
+}
+
+// noteVerdict records an anti-cheat correction of the tick for the
+// inspectors. Only called by the tick.
+func (r *Room) noteVerdict(p *Player, result ValidationResult, reason string) {
+	if result == ValidationValid || r.inspecting.Load() == 0 {
+		return
+	}
+	r.inspect.verdicts = append(r.inspect.verdicts, InspectVerdict{ID: p.ID, Result: verdictNames[result], Reason: reason})
+}
+
+// inspectTick sends the tick's frame to the inspectors. Called by the
+// physics tick at the end.
+func (r *Room) inspectTick(dt float64) {
+	if r.inspecting.Load() == 0 {
+		r.inspect.verdicts = r.inspect.verdicts[:0]
+		return
+	}
+
+	published := r.frames.published()
+	now := time.Now()
+	frame := InspectFrame{
+		Tick:     published.tick,
+		Time:     now.UTC(),
+		Dt:       dt,
+		Origin:   published.origin,
+		Cars:     make([]InspectCar, len(published.players)),
+		Verdicts: append([]InspectVerdict(nil), r.inspect.verdicts...),
+	}
+	r.inspect.verdicts = r.inspect.verdicts[:0]
+	for i, p := range published.players {
+		frame.Cars[i] = p.inspect(now)
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for in := range r.inspect.inspectors {
+		f := frame
+		f.Dropped = in.dropped
+		select {
+		case in.frames <- f:
+			in.dropped = 0
+		default:
+			in.dropped++
+		}
+	}
+}
+
+// inspect returns the car's state for an inspection
+func (p *Player) inspect(now time.Time) InspectCar {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return InspectCar{
+		ID:       p.ID,
+		Name:     p.Name,
+		Bot:      p.IsBot(),
+		X:        p.X,
+		Y:        p.Y,
+		Speed:    p.Speed,
+		Angle:    p.Angle,
+		VelX:     p.VelX,
+		VelY:     p.VelY,
+		Rating:   p.Rating,
+		Lane:     p.Lane,
+		Height:   p.Height,
+		VelZ:     p.VelZ,
+		Fuel:     p.Fuel,
+		Exploded: p.Exploded,
+		Ghost:    p.ghostedUnlocked(now),
+
+		Keys:     p.CurrentInput.Keys,
+		Steering: p.CurrentInput.Steering,
+		Throttle: p.CurrentInput.Throttle,
+		Sequence: p.CurrentInput.Sequence,
+		Inputs:   p.InputsThisTick,
+
+		LastValidX: p.LastValidX,
+		LastValidY: p.LastValidY,
+		Violations: p.Violations,
+		Anomalies:  p.sequence.anomalies,
+		RamStrikes: len(p.ram.strikes),
+	}
+}
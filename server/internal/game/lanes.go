@@ -0,0 +1,32 @@
+package game
+
+import (
+	"github.com/race/server/internal/track"
+)
+
+// Lanes
+//
+// The road is split into logical lanes (see track.Track.Lane). Every tick,
+// once anti-cheat has placed the cars, the room works out the lane of each
+// car; a car drifting over a lane line keeps its lane until it is clearly in
+// the next one. ProtocolV17 state records carry the lane, and a record
+// whose lane changed is always sent (see deadreckoning.go), so clients see
+// every lane change as it happens. The room uses lanes itself for bots,
+// which keep to a lane and change lanes to pass slower cars (see bot.go),
+// and for overtakes, which only count when made in another lane than the
+// car passed (see round.go).
+
+// updateLanes works out every car's lane. Called by the physics tick.
+func (r *Room) updateLanes(players []*Player) {
+	for _, p := range players {
+		p.updateLane(r.road)
+	}
+}
+
+// updateLane works out the car's lane on road
+func (p *Player) updateLane(road *track.Track) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.Lane = road.Lane(p.X, p.Y, p.Lane)
+}
@@ -0,0 +1,120 @@
+package game
+
+import (
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/network"
+)
+
+// LatencyStats summarizes the round-trip times of a room's players
+type LatencyStats struct {
+	Samples int             // Players with a measured RTT
+	Avg     time.Duration   // Average smoothed RTT
+	Max     time.Duration   // Highest smoothed RTT
+	Players []PlayerLatency // Per-player RTTs
+}
+
+// PlayerLatency is a single player's smoothed RTT
+type PlayerLatency struct {
+	ID   uint16
+	Name string
+	RTT  time.Duration
+}
+
+// RTT returns the player's smoothed round-trip time
+func (p *Player) RTT() time.Duration {
+	return p.Connection.RTT()
+}
+
+// LatencyStats returns RTT statistics over the room's players.
+func (r *Room) LatencyStats() LatencyStats {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	stats := LatencyStats{Players: make([]PlayerLatency, 0, len(r.players))}
+	var total time.Duration
+	for _, p := range r.players {
+		rtt := p.RTT()
+		stats.Players = append(stats.Players, PlayerLatency{ID: p.ID, Name: p.Name, RTT: rtt})
+		if rtt <= 0 {
+			continue
+		}
+		stats.Samples++
+		total += rtt
+		if rtt > stats.Max {
+			stats.Max = rtt
+		}
+	}
+
+	if stats.Samples > 0 {
+		stats.Avg = total / time.Duration(stats.Samples)
+	}
+	return stats
+}
+
+// broadcastScoreboard sends every player's RTT to the room so players can
+// see who is lagging.
+func (r *Room) broadcastScoreboard() {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.players) == 0 {
+		return
+	}
+
+	entries := make([]network.ScoreboardEntry, 0, len(r.players))
+	for id, p := range r.players {
+		rttMS := p.RTT().Milliseconds()
+		if rttMS > 0xFFFF {
+			rttMS = 0xFFFF
+		}
+		entries = append(entries, network.ScoreboardEntry{ID: id, RTTMS: uint16(rttMS)})
+	}
+
+	r.broadcastUnlocked(r.protocol.EncodeScoreboard(entries))
+}
+
+// RecommendInterpDelay returns how far behind the latest state a client with
+// the given jitter should render remote cars (0: no delay needed)
+func RecommendInterpDelay(jitter time.Duration) time.Duration {
+	if jitter < config.InterpMinJitter {
+		return 0
+	}
+	delay := time.Duration(float64(jitter) * config.InterpJitterFactor)
+	delay = (delay + config.InterpDelayStep - 1) / config.InterpDelayStep * config.InterpDelayStep
+	return min(delay, config.InterpDelayMax)
+}
+
+// jitter returns the larger of the player's round-trip time variation and
+// the state update jitter their client last reported
+func (p *Player) jitter() time.Duration {
+	jitter := p.Connection.RTTVar()
+	perf := p.GetPerf()
+	if !perf.ReportedAt.IsZero() && time.Since(perf.ReportedAt) <= config.PerfReportMaxAge {
+		jitter = max(jitter, time.Duration(perf.JitterMS)*time.Millisecond)
+	}
+	return jitter
+}
+
+// recommendInterpDelays sends ProtocolV11 clients an interpolation delay
+// matching their jitter, once measured and then whenever it moves by more
+// than a step, so clients on unstable connections smooth their rendering
+// without any tuning.
+func (r *Room) recommendInterpDelays() {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, p := range r.players {
+		if p.IsBot() || p.Connection.ProtocolVersion() < network.ProtocolV11 || p.RTT() == 0 {
+			continue
+		}
+		delay := RecommendInterpDelay(p.jitter())
+		diff := delay - p.interpDelay
+		if p.interpDelaySent && diff <= config.InterpDelayStep && diff >= -config.InterpDelayStep {
+			continue
+		}
+		p.interpDelay, p.interpDelaySent = delay, true
+		p.Connection.Send(r.protocol.EncodeInterpDelay(uint16(delay.Milliseconds())))
+	}
+}
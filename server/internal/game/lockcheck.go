@@ -0,0 +1,92 @@
+//go:build lockcheck
+
+package game
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// LockCheck reports whether lock order checking is built in
+const LockCheck = true
+
+// heldLock is a lock a goroutine holds
+type heldLock struct {
+	lock  interface{}
+	class lockClass
+	id    uint16
+}
+
+func (h heldLock) String() string {
+	if h.class == lockPlayer {
+		return fmt.Sprintf("player %d", h.id)
+	}
+	return h.class.String()
+}
+
+var (
+	heldMu sync.Mutex
+	held   = make(map[uint64][]heldLock) // By goroutine ID
+)
+
+// lockAcquire checks that a lock about to be acquired comes after every lock
+// the goroutine holds, and records it as held. Panics on a violation.
+func lockAcquire(lock interface{}, class lockClass, id uint16) {
+	if class == lockUnordered {
+		return
+	}
+	g := goroutineID()
+
+	heldMu.Lock()
+	defer heldMu.Unlock()
+
+	next := heldLock{lock: lock, class: class, id: id}
+	for _, h := range held[g] {
+		switch {
+		case h.lock == lock:
+			panic(fmt.Sprintf("lockcheck: acquiring %v again; locks held: %v", next, held[g]))
+		case h.class > class || h.class == class && h.id >= id:
+			panic(fmt.Sprintf("lockcheck: acquiring %v after %v breaks the lock order (see lockorder.go); locks held: %v", next, h, held[g]))
+		}
+	}
+	held[g] = append(held[g], next)
+}
+
+// lockRelease forgets a released lock
+func lockRelease(lock interface{}) {
+	g := goroutineID()
+
+	heldMu.Lock()
+	defer heldMu.Unlock()
+
+	locks := held[g]
+	for i := len(locks) - 1; i >= 0; i-- {
+		if locks[i].lock == lock {
+			locks = append(locks[:i], locks[i+1:]...)
+			break
+		}
+	}
+	if len(locks) == 0 {
+		delete(held, g)
+	} else {
+		held[g] = locks
+	}
+}
+
+// goroutineID parses the current goroutine's ID from its stack header
+// ("goroutine 42 [running]:"). Slow, but only built in for checking.
+func goroutineID() uint64 {
+	var buf [64]byte
+	header := buf[:runtime.Stack(buf[:], false)]
+	header = bytes.TrimPrefix(header, []byte("goroutine "))
+	field, _, _ := strings.Cut(string(header), " ")
+	id, err := strconv.ParseUint(field, 10, 64)
+	if err != nil {
+		panic("lockcheck: unexpected stack header " + strconv.Quote(string(buf[:])))
+	}
+	return id
+}
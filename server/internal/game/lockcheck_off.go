@@ -0,0 +1,10 @@
+//go:build !lockcheck
+
+package game
+
+// LockCheck reports whether lock order checking is built in
+const LockCheck = false
+
+func lockAcquire(lock interface{}, class lockClass, id uint16) {}
+
+func lockRelease(lock interface{}) {}
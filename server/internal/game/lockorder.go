@@ -0,0 +1,110 @@
+package game
+
+import "sync"
+
+// Lock ordering
+//
+// Code that holds one of the package's locks while acquiring another must
+// acquire them in this order, or two goroutines taking the same pair of locks
+// the other way round can deadlock:
+//
+//	Room.mu → practiceState.mu, tutorialState.mu, honeypotState.mu → SpatialGrid.mu → Player.mu → Scheduler.mu → spectatorDelay.mu → clipBuffer.mu
+//
+// Players' locks are acquired in ascending player ID order (see lockPair).
+// The scheduler's lock is a leaf: a join resumes its room under the room's
+// lock, and the scheduler never calls into a room while holding it. So are
+// the spectator delay buffer's (see spectatordelay.go) and the highlight
+// clip buffer's (see clip.go).
+// Locks may be skipped (a goroutine holding a room's lock may lock a player
+// directly) but never taken against the order, and a held lock is never
+// acquired again (RWMutex isn't reentrant, not even for readers once a
+// writer waits).
+//
+// Building with -tags lockcheck checks every acquisition against the locks
+// the goroutine holds and panics on the first violation, naming both locks,
+// instead of waiting for the deadlock to happen (see lockcheck.go).
+// TestLockStress (lockstress_test.go) drives rooms from many goroutines to
+// find them:
+//
+//	go test -race -tags lockcheck -run TestLockStress ./internal/game
+
+// lockClass is a lock's place in the lock order
+type lockClass uint8
+
+const (
+	lockUnordered lockClass = iota // Not checked
+	lockRoom
+	lockRoomMode // Practice, tutorial and honeypot state
+	lockGrid
+	lockPlayer
+	lockScheduler
+	lockSpectators
+	lockClips
+)
+
+var lockClassNames = [...]string{"unordered", "room", "room mode", "spatial grid", "player", "scheduler", "spectator delay", "clip buffer"}
+
+func (c lockClass) String() string {
+	return lockClassNames[c]
+}
+
+// orderedMutex is a sync.Mutex in the lock order
+type orderedMutex struct {
+	sync.Mutex
+	class lockClass
+}
+
+func (m *orderedMutex) Lock() {
+	lockAcquire(m, m.class, 0)
+	m.Mutex.Lock()
+}
+
+func (m *orderedMutex) Unlock() {
+	m.Mutex.Unlock()
+	lockRelease(m)
+}
+
+// orderedRWMutex is a sync.RWMutex in the lock order. id orders the locks
+// of one class (player IDs).
+type orderedRWMutex struct {
+	sync.RWMutex
+	class lockClass
+	id    uint16
+}
+
+func (m *orderedRWMutex) Lock() {
+	lockAcquire(m, m.class, m.id)
+	m.RWMutex.Lock()
+}
+
+func (m *orderedRWMutex) Unlock() {
+	m.RWMutex.Unlock()
+	lockRelease(m)
+}
+
+func (m *orderedRWMutex) RLock() {
+	lockAcquire(m, m.class, m.id)
+	m.RWMutex.RLock()
+}
+
+func (m *orderedRWMutex) RUnlock() {
+	m.RWMutex.RUnlock()
+	lockRelease(m)
+}
+
+// lockPair write-locks w and read-locks r, in player ID order
+func lockPair(w, r *Player) {
+	if w.ID < r.ID {
+		w.mu.Lock()
+		r.mu.RLock()
+	} else {
+		r.mu.RLock()
+		w.mu.Lock()
+	}
+}
+
+// unlockPair releases the locks taken by lockPair
+func unlockPair(w, r *Player) {
+	w.mu.Unlock()
+	r.mu.RUnlock()
+}
@@ -0,0 +1,208 @@
+//go:build lockcheck
+
+package game
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"math/rand"
+	"runtime/pprof"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/race/server/internal/network"
+	"github.com/race/server/internal/routines/routinestest"
+)
+
+var lockStressDuration = flag.Duration("lockstress", 5*time.Second, "how long TestLockStress runs")
+
+// lockStressStall is how long no operation may complete before
+// TestLockStress reports a deadlock
+const lockStressStall = 10 * time.Second
+
+// TestLockStress looks for deadlocks and data races. Rooms run live on a
+// scheduler while many goroutines act on them at once the way connections
+// and the admin API do: players join, steer, reset, turn ghost and leave,
+// hosts kick, bots come and go, and readers collect stats, take snapshots,
+// change broadcast rates and trigger explosions and the end of rounds.
+//
+// Built with the lockcheck tag, every lock acquisition is checked against
+// the lock order (see lockorder.go) and the first violation panics, naming
+// both locks. A watchdog catches the deadlocks that happen anyway: if no
+// operation completes for lockStressStall, the test fails with the
+// goroutine stacks. Run it with the race detector:
+//
+//	go test -race -tags lockcheck -run TestLockStress ./internal/game -lockstress 1m
+func TestLockStress(t *testing.T) {
+	const (
+		players = 32 // Goroutines acting as players
+		admins  = 4  // Goroutines acting as the admin API
+	)
+
+	scheduler := NewScheduler(0)
+	var rooms []*Room
+	for i := 0; i < 4; i++ {
+		room := NewRoom(fmt.Sprintf("public-%d", i))
+		room.SetHosted(true)
+		room.SetSuspendWhenEmpty(true)
+		rooms = append(rooms, room)
+	}
+	practice := NewRoom("practice")
+	practice.EnablePractice(true)
+	tutorial := NewRoom("tutorial")
+	tutorial.EnableTutorial(DefaultTutorial)
+	rooms = append(rooms, practice, tutorial)
+	for _, room := range rooms {
+		room.SetScheduler(scheduler)
+		room.Start()
+	}
+
+	var ops atomic.Uint64
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < players; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			stressPlayer(rooms, rand.New(rand.NewSource(seed)), &ops, stop)
+		}(int64(i))
+	}
+	for i := 0; i < admins; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			stressAdmin(rooms, rand.New(rand.NewSource(seed)), &ops, stop)
+		}(int64(players + i))
+	}
+
+	if !watchOps(&ops, *lockStressDuration, lockStressStall) {
+		var stacks bytes.Buffer
+		pprof.Lookup("goroutine").WriteTo(&stacks, 2)
+		t.Fatalf("no operation completed for %v; goroutines:\n%s", lockStressStall, stacks.String())
+	}
+	close(stop)
+	wg.Wait()
+	for _, room := range rooms {
+		room.Stop()
+	}
+	scheduler.Stop()
+
+	t.Logf("%d operations, %d room ticks", ops.Load(), scheduler.Stats().Ticks)
+	routinestest.CheckZero(t, "sim.clock", "sim.worker")
+}
+
+// watchOps waits out the duration, reporting false if the operation count
+// stops moving for stall (a deadlock)
+func watchOps(ops *atomic.Uint64, duration, stall time.Duration) bool {
+	deadline := time.Now().Add(duration)
+	last, lastChange := ops.Load(), time.Now()
+	for time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+		if n := ops.Load(); n != last {
+			last, lastChange = n, time.Now()
+		} else if time.Since(lastChange) > stall {
+			return false
+		}
+	}
+	return true
+}
+
+// stressPlayer joins rooms, plays a little and leaves, like a connection
+func stressPlayer(rooms []*Room, rng *rand.Rand, ops *atomic.Uint64, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		room := rooms[rng.Intn(len(rooms))]
+		p, err := room.AddPlayer(fmt.Sprintf("stress-%d", rng.Int63()), "Stress", uint8(rng.Intn(8)), discardConn{version: network.ProtocolVersionMax})
+		if err != nil {
+			continue // Full, or a practice room taken
+		}
+		ops.Add(1)
+
+		for seq, n := 0, 10+rng.Intn(100); seq < n; seq++ {
+			room.HandleInput(p.ID, &network.InputMessage{
+				MsgType:  network.MsgTypeInput,
+				Sequence: uint8(seq),
+				Steering: int8(rng.Intn(255) - 127),
+				Throttle: 127,
+			})
+			switch rng.Intn(20) {
+			case 0:
+				room.ResetPlayer(p.ID) // Practice rooms only
+			case 1:
+				room.SetGhost(p.ID, GhostAdmin, time.Second)
+			case 2:
+				room.ClearGhost(p.ID, GhostAdmin)
+			case 3:
+				if host := room.HostID(); host != 0 && host != p.ID {
+					room.HostKick(host, p.ID)
+				}
+			}
+			ops.Add(1)
+			time.Sleep(time.Duration(rng.Intn(5)) * time.Millisecond)
+		}
+		room.RemovePlayer(p.ID)
+		ops.Add(1)
+	}
+}
+
+// stressAdmin reads stats and changes rooms, like the admin API and /stats
+func stressAdmin(rooms []*Room, rng *rand.Rand, ops *atomic.Uint64, stop <-chan struct{}) {
+	profiles := make([]BotProfile, 0, len(DefaultBotProfiles))
+	for _, p := range DefaultBotProfiles {
+		profiles = append(profiles, p)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		room := rooms[rng.Intn(len(rooms))]
+		switch rng.Intn(8) {
+		case 0:
+			room.LatencyStats()
+			room.Quality()
+			room.SequenceStats()
+		case 1:
+			room.GetPlayerCount()
+			room.HumanCount()
+			room.GridCells()
+			room.Suspended()
+		case 2:
+			if !room.Practice() {
+				room.AddBot(profiles[rng.Intn(len(profiles))])
+			}
+		case 3:
+			if bots := room.Bots(); len(bots) > 0 {
+				room.RemoveBot(bots[rng.Intn(len(bots))].ID)
+			}
+		case 4:
+			room.SetBroadcastRate(10 + 10*rng.Intn(3))
+		case 5:
+			room.Config()
+			room.Track()
+		case 6:
+			room.Snapshot()
+			room.PlayerStates()
+		case 7:
+			if states := room.PlayerStates(); len(states) > 0 {
+				room.Explode(states[rng.Intn(len(states))].ID)
+			}
+			room.EndRound()
+		}
+		ops.Add(1)
+		time.Sleep(time.Millisecond)
+	}
+}
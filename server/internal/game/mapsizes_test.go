@@ -0,0 +1,94 @@
+package game
+
+import (
+	"testing"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/network"
+)
+
+// roomMapSizes returns the sizes of the maps that live as long as the room,
+// and of the delta records receiver keeps of the others' cars
+func roomMapSizes(r *Room, receiver *Player) map[string]int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return map[string]int{
+		"players":         len(r.players),
+		"profiles":        len(r.profiles),
+		"spectators":      len(r.spectators),
+		"departed":        len(r.departed),
+		"gridCells":       len(r.spatialGrid.cells),
+		"gridChecked":     len(r.spatialGrid.checked),
+		"proximityCells":  len(r.proximity.cells),
+		"roundStats":      len(r.round.stats),
+		"roundOrder":      len(r.round.order) + len(r.round.lastOrder),
+		"scratchPushed":   len(r.scratch.pushed),
+		"scratchEncoded":  len(r.scratch.encoded),
+		"receiverRecords": len(receiver.sent),
+	}
+}
+
+// TestJoinLeaveCycles has hundreds of humans join a room, drive and leave,
+// and checks the room's maps are back to their size before them. The
+// departed are kept up to config.DepartedPlayersMax, and the scratch of
+// the spatial grid and the overtakes holds the cars and pairs of the last
+// tick, which move.
+func TestJoinLeaveCycles(t *testing.T) {
+	const cycles = 500
+
+	room := NewRoom("cycles")
+	receiver := addHuman(t, room, network.ProtocolVersionMax)
+	addBots(t, room, 50)
+	runTicks(room, config.PhysicsTickRate)
+	baseline := roomMapSizes(room, receiver)
+
+	for i := 0; i < cycles; i++ {
+		p := addHuman(t, room, network.ProtocolVersionMax)
+		runTicks(room, 6)
+		room.RemovePlayer(p.ID)
+		runTicks(room, 3)
+	}
+	// Rounds keep the stats of everyone who drove in them until they end
+	if err := room.EndRound(); err != nil {
+		t.Fatal(err)
+	}
+	runTicks(room, config.PhysicsTickRate)
+	sizes := roomMapSizes(room, receiver)
+
+	cars := sizes["players"]
+	bounds := map[string]int{
+		"departed":       config.DepartedPlayersMax,
+		"gridCells":      cars,
+		"proximityCells": cars,
+		"gridChecked":    cars * (cars - 1) / 2,
+		"roundOrder":     cars * (cars - 1),
+	}
+	for name, size := range sizes {
+		if bound, ok := bounds[name]; ok {
+			if size > bound {
+				t.Errorf("%s: %d after %d joins and leaves, over %d", name, size, cycles, bound)
+			}
+		} else if size != baseline[name] {
+			t.Errorf("%s: %d after %d joins and leaves, %d before", name, size, cycles, baseline[name])
+		}
+	}
+}
+
+// TestLeaveEmptiesGrids checks the players who leave are out of the room's
+// grids at once: a room nobody is left in is suspended and stops updating
+// them
+func TestLeaveEmptiesGrids(t *testing.T) {
+	room := NewRoom("grids")
+	players := addHumans(t, room, 10)
+	runTicks(room, config.PhysicsTickRate)
+
+	for _, p := range players {
+		room.RemovePlayer(p.ID)
+	}
+	if cells := room.spatialGrid.Cells(); cells != 0 {
+		t.Errorf("%d collision grid cells left", cells)
+	}
+	if cells := room.proximity.Cells(); cells != 0 {
+		t.Errorf("%d proximity grid cells left", cells)
+	}
+}
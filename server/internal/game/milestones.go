@@ -0,0 +1,109 @@
+package game
+
+import (
+	"log"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/network"
+)
+
+// Endless mode
+//
+// The plain race of public rooms (no rules, neither practice nor tutorial
+// nor relay) is endless: a run lasts until the car crashes. For progression
+// the room tracks how far each human's run got. Every
+// config.MilestoneDistance units the run reaches a milestone worth config.MilestoneBonus rating, which the
+// room announces to its ProtocolV23 players and spectators (Milestone
+// message). A driver whose run is rated config.PrestigeMinRating or more can
+// reset it for prestige (Prestige message): the run ends as if the car had
+// crashed, its score goes to the leaderboard as usual and is banked to the
+// player's profile through the prestige callback, and a new run starts where
+// the car is, without stopping it.
+
+// endless reports whether the room runs endless mode
+func (r *Room) endless() bool {
+	return r.practice == nil && r.tutorial == nil && r.relay == nil && r.race == nil && r.Rules() == ""
+}
+
+// milestoneTick hands out the milestones the humans' runs reached this tick
+func (r *Room) milestoneTick(players []*Player) {
+	if !r.endless() {
+		return
+	}
+	for _, p := range players {
+		if p.IsBot() {
+			continue
+		}
+		if n, ok := p.reachMilestone(); ok {
+			r.broadcastSince(network.ProtocolV23, r.protocol.EncodeMilestone(p.ID, network.MilestoneDistance, uint16(n), config.MilestoneBonus))
+		}
+	}
+}
+
+// Prestige resets a player's run for prestige, banking its score (see
+// SetOnPrestige). The run must be rated config.PrestigeMinRating or more.
+func (r *Room) Prestige(playerID uint16) error {
+	if !r.endless() {
+		return ErrNotEndless
+	}
+
+	r.mu.RLock()
+	p, exists := r.players[playerID]
+	policy := r.scoring
+	r.mu.RUnlock()
+	if !exists || p.IsBot() {
+		return ErrPlayerNotFound
+	}
+
+	run, count, ok := p.prestige()
+	if !ok {
+		return ErrPrestigeTooEarly
+	}
+	score := policy.Score(run)
+	if r.onRunEnd != nil {
+		r.onRunEnd(p, score)
+	}
+	if r.onPrestige != nil {
+		r.onPrestige(p, score)
+	}
+	r.broadcastSince(network.ProtocolV23, r.protocol.EncodeMilestone(p.ID, network.MilestonePrestige, uint16(count), float32(score)))
+	log.Printf("Player %s (ID: %d) in room %s reset for prestige #%d, banking %.0f", p.GetName(), p.ID, r.ID, count, score)
+	return nil
+}
+
+// SetOnPrestige sets a callback function called with the score of every run
+// reset for prestige, to bank to the player's profile.
+func (r *Room) SetOnPrestige(callback func(player *Player, score float64)) {
+	r.onPrestige = callback
+}
+
+// reachMilestone adds the bonus of the run's next milestone once the car is
+// far enough down the road, returning the milestone's number
+func (p *Player) reachMilestone() (int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.Exploded || p.Y-p.runStartY < float64(p.milestones+1)*config.MilestoneDistance {
+		return 0, false
+	}
+	p.milestones++
+	p.Rating += config.MilestoneBonus
+	return p.milestones, true
+}
+
+// prestige ends the current run for prestige and starts a new one where the
+// car is, returning the finished run and the session's number of prestige
+// resets. Fails while exploded or below config.PrestigeMinRating.
+func (p *Player) prestige() (Run, int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.Exploded || p.Rating < config.PrestigeMinRating {
+		return Run{}, 0, false
+	}
+	run := p.takeRunUnlocked()
+	p.runStartY = p.Y
+	p.milestones = 0
+	p.prestiges++
+	return run, p.prestiges, true
+}
@@ -6,14 +6,19 @@ import (
 	"time"
 
 	"github.com/race/server/config"
+	"github.com/race/server/internal/track"
 )
 
 // Physics handles all physics calculations
-type Physics struct{}
+type Physics struct {
+	road       *track.Track  // The room's road
+	fuel       bool          // Fuel mode (see fuel.go)
+	collisions CollisionMode // How cars collide (see collisionmode.go)
+}
 
-// NewPhysics creates a new physics engine
-func NewPhysics() *Physics {
-	return &Physics{}
+// NewPhysics creates a new physics engine for a road
+func NewPhysics(road *track.Track) *Physics {
+	return &Physics{road: road}
 }
 
 // UpdatePlayer updates a single player's physics state
@@ -25,6 +30,13 @@ func (ph *Physics) UpdatePlayer(p *Player, dt float64) {
 		return
 	}
 
+	// In the air off a jump ramp: no grip, and nothing on the ground (the
+	// road's edges, the grass) touches the car until it lands
+	if p.Height > 0 {
+		ph.flyUnlocked(p, dt)
+		return
+	}
+
 	input := p.CurrentInput
 
 	// Decode input
@@ -58,24 +70,29 @@ func (ph *Physics) UpdatePlayer(p *Player, dt float64) {
 	}
 
 	// Check road boundaries
-	roadCenter := config.GetRoadCurve(p.Y)
+	roadCenter := ph.road.Center(p.Y)
 	distFromCenter := math.Abs(p.X - roadCenter)
-	roadHalfWidth := config.RoadWidth / 2.0
+	roadHalfWidth := ph.road.Width / 2.0
 	carHalfWidth := config.CarWidth / 2.0
 	edgeDist := distFromCenter - roadHalfWidth
 	isOffRoad := edgeDist > -carHalfWidth
 
 	// Explosion check
-	if edgeDist > config.RoadWidth*config.ExplosionTolerance {
+	if edgeDist > ph.road.Width*config.ExplosionTolerance {
 		if !p.Exploded {
 			p.Exploded = true
-			p.Rating = 0
+			p.endRunUnlocked()
 			p.ExplodedAt = time.Now()
 			log.Printf("Player %d exploded: X=%.0f, roadCenter=%.0f, edgeDist=%.0f", p.ID, p.X, roadCenter, edgeDist)
 		}
 		return
 	}
 
+	// Fuel mode: pits refill the tank, throttle burns it
+	if ph.fuel {
+		ph.fuelUnlocked(p, accForce, distFromCenter <= roadHalfWidth, dt)
+	}
+
 	// Friction
 	var activeFriction float64
 	if isOffRoad {
@@ -98,40 +115,94 @@ func (ph *Physics) UpdatePlayer(p *Player, dt float64) {
 		p.Speed -= p.Speed * 2.0 * dt
 	}
 
-	// Apply acceleration
+	// Apply acceleration, and gravity on hills
 	p.Speed += accForce * dt
+	if grade := ph.road.Grade(p.Y); grade != 0 {
+		p.Speed -= config.Gravity * grade * dt
+	}
 	p.Speed = math.Max(-config.MaxSpeed*0.2, math.Min(p.Speed, config.MaxSpeed))
+	if ph.fuel && p.Fuel <= 0 {
+		p.Speed = math.Min(p.Speed, config.FuelDrySpeed) // Out of fuel
+	}
 
-	// Steering with understeer
-	speedRatio := math.Abs(p.Speed) / config.MaxSpeed
-	understeerFactor := math.Max(config.MinTurnAuthority, 1.0-(speedRatio*config.InertiaDampening))
+	// Steering with understeer, less of it in banked bends
+	bank := ph.road.Bank(p.Y)
+	understeerFactor := understeer(p.Speed, bank)
 
 	if math.Abs(turnDir) > 0.01 && math.Abs(p.Speed) > 20 {
 		p.X += turnDir * config.TurnSpeed * understeerFactor * dt
 		p.Angle = turnDir * 25.0 * understeerFactor
 
 		// Speed penalty from turning
-		p.Speed *= 1.0 - (0.3 * math.Abs(turnDir) * dt)
+		p.Speed *= 1.0 - (0.3 * math.Abs(turnDir) * (1 - bank) * dt)
 	} else {
 		p.Angle *= 0.9
 	}
 
-	// Update position
+	// Update position, taking off if the car drove off a ramp's lip on the
+	// road
+	fromY := p.Y
+	p.Y += p.Speed * dt
+	if p.Speed > 0 && distFromCenter <= roadHalfWidth {
+		if height, grade, ok := ph.road.Launch(fromY, p.Y); ok {
+			p.Height, p.VelZ = height, p.Speed*grade
+		}
+	}
+
+	gainRatingUnlocked(p, dt)
+
+
+}
+
+// flyUnlocked moves a car in the air: it can't steer, throttle or brake,
+// so it keeps its speed and heading while gravity pulls it down, and loses
+// speed on landing, the more the harder it lands.
+// IMPORTANT: Caller must hold p.mu.
+func (ph *Physics) flyUnlocked(p *Player, dt float64) {
+	p.VelZ -= config.Gravity * dt
+	p.Height += p.VelZ * dt
+	if p.Height <= 0 {
+		p.Speed *= 1 - math.Min(config.LandingLossMax, -p.VelZ*config.LandingLoss)
+		p.Height, p.VelZ = 0, 0
+	}
+
 	p.Y += p.Speed * dt
+	gainRatingUnlocked(p, dt)
+}
 
-	// Update rating
+// gainRatingUnlocked updates the rating of a car that drove for dt.
+// IMPORTANT: Caller must hold p.mu.
+func gainRatingUnlocked(p *Player, dt float64) {
 	if p.Speed > 0 {
 		speedFactor := p.Speed / 100.0
 		p.Rating += (speedFactor * speedFactor) * dt * 0.5
 	}
+}
 
-
+// understeer returns the share of config.TurnSpeed a car keeps at speed in
+// a bend banked bank (see track.Track.Bank)
+func understeer(speed, bank float64) float64 {
+	speedRatio := math.Abs(speed) / config.MaxSpeed
+	return math.Max(config.MinTurnAuthority, 1.0-speedRatio*config.InertiaDampening*(1-bank))
 }
 
-// CheckCollision checks and resolves collision between two players
-func (ph *Physics) CheckCollision(p1, p2 *Player, dt float64) bool {
-	p1.mu.Lock()
-	p2.mu.RLock()
+// CheckCollision checks and resolves collision between two players. It
+// returns the impact, the relative speed of the two cars at contact in
+// units/s, and whether they collided.
+func (ph *Physics) CheckCollision(p1, p2 *Player, dt float64) (float64, bool) {
+	if ph.collisions == CollisionsOff {
+		return 0, false
+	}
+
+	lockPair(p1, p2)
+
+	// Ghosts drive through everyone, and cars in the air fly over those on
+	// the ground
+	now := time.Now()
+	if p1.ghostedUnlocked(now) || p2.ghostedUnlocked(now) || (p1.Height > 0) != (p2.Height > 0) {
+		unlockPair(p1, p2)
+		return 0, false
+	}
 
 	dx := p1.X - p2.X
 	dy := p1.Y - p2.Y
@@ -139,18 +210,20 @@ func (ph *Physics) CheckCollision(p1, p2 *Player, dt float64) bool {
 	minDist := config.CollisionRadius
 
 	if dist >= minDist || dist == 0 {
-		p1.mu.Unlock()
-		p2.mu.RUnlock()
-		return false
+		unlockPair(p1, p2)
+		return 0, false
 	}
 
+	// Velocities of the last tick, before this one's push
+	impact := math.Hypot(p1.VelX-p2.VelX, p1.VelY-p2.VelY)
+
 	// Normalize collision vector
 	nx := dx / dist
 	ny := dy / dist
 	otherSpeed := p2.Speed
 	speedDiff := p1.Speed - otherSpeed
 
-	pushPower := config.PushForce * (math.Abs(p1.Speed) + 100) * dt
+	pushPower := config.PushForce * (math.Abs(p1.Speed) + 100) * dt * ph.collisions.push()
 
 	// Speed differential amplification
 	if speedDiff > config.SpeedDiffThreshold {
@@ -159,12 +232,11 @@ func (ph *Physics) CheckCollision(p1, p2 *Player, dt float64) bool {
 
 	p1.X += nx * pushPower
 	p1.Y += ny * pushPower
-	p1.Speed *= 0.9
+	p1.Speed *= 1 - 0.1*ph.collisions.push()
 
-	p1.mu.Unlock()
-	p2.mu.RUnlock()
+	unlockPair(p1, p2)
 
-	return true
+	return impact, true
 }
 
 // Distance calculates distance between two points
@@ -2,10 +2,11 @@ package game
 
 import (
 	"log"
-	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/race/server/config"
+	"github.com/race/server/internal/track"
 )
 
 // PlayerState represents the current state of a player
@@ -19,6 +20,14 @@ type PlayerState struct {
 	Angle    float64
 	Rating   float64
 	Exploded bool
+	VelX     float64 // Units per second over the last physics tick
+	VelY     float64
+	Ghost    bool // Collisions are off
+	Lane     int  // Lane on the road, 1-based from the left (0: off the road)
+	Airborne bool // In the air off a jump ramp
+	Fuel     float64
+	InputAck uint8 // Sequence number of the last input a physics tick drove with (see ack.go)
+	Acked    bool  // InputAck is set: an input was driven with
 }
 
 // PlayerInput represents input from client
@@ -32,14 +41,15 @@ type PlayerInput struct {
 
 // Player represents a connected player
 type Player struct {
-	mu sync.RWMutex
+	mu orderedRWMutex // See lockorder.go
 
 	// Identity
 	ID         uint16
 	SessionID  string
-	Name       string
+	Name       string // Only the physics tick changes it, under mu (see Room.Rename)
 	Color      uint8
 	Connection PlayerConnection
+	appearance Appearance // Cosmetics, under the room's lock (see appearance.go)
 
 	// State
 	X        float64
@@ -48,22 +58,118 @@ type Player struct {
 	Angle    float64
 	Rating   float64
 	Exploded bool
+	VelX     float64 // Velocity from movement during the last physics tick
+	VelY     float64
+	Lane     int // See lanes.go; set by the physics tick
+
+	// Height above the road and vertical velocity: the car is in the air
+	// off a jump ramp while Height > 0 (see Physics.UpdatePlayer)
+	Height float64
+	VelZ   float64
+
+	// Fuel in the tank, 0 to config.FuelCapacity (only burned in fuel mode,
+	// see fuel.go)
+	Fuel float64
+
+	// Position at the start of the current physics tick
+	tickStartX float64
+	tickStartY float64
 
 	// Anti-cheat
 	LastValidX   float64
 	LastValidY   float64
 	Violations   int
 	InputsThisTick int
+	sequence       sequenceWindow // Recent input sequence numbers (see sequence.go)
+	suspicion      float64        // Client telemetry's weight against the player (see SetSuspicion)
 
 	// Input
 	CurrentInput PlayerInput
 	InputBuffer  []PlayerInput
 
+	// Input acknowledgement (see ack.go)
+	applied  uint8 // Sequence number of the last input applied
+	unacked  bool  // It's not driven with yet
+	inputAck uint8
+	acked    bool
+
 	// Timing
 	LastInputTime time.Time
 	ConnectedAt   time.Time
 	LastSyncTime  time.Time
 	ExplodedAt    time.Time // When player exploded (for auto-respawn)
+	spawnedAt     time.Time // Joined or last respawned
+
+	// Griefing: ram strikes
+	ram ramRecord
+
+	// Ghost mode: expiry per reason (zero: until cleared)
+	ghosts map[GhostReason]time.Time
+
+	// Client-reported performance (from pings)
+	Perf ClientPerf
+
+	// Interpolation delay last recommended to the client (see latency.go).
+	// Only touched by the room's game loop.
+	interpDelay     time.Duration
+	interpDelaySent bool
+
+	// Server-side driver (nil for human players)
+	bot *botDriver
+
+	// Replay car of a practice room, placed by the room (see practice.go)
+	replay bool
+
+	// Relay teammate waiting for the baton, placed by the room (see relay.go)
+	parked atomic.Bool
+
+	// Stand-in for a human of a restored room snapshot (see roomsnapshot.go)
+	standIn bool
+
+	// Players near this one at the last Nearby message, sorted by ID (see
+	// proximity.go). Only touched by the room's game loop.
+	nearby     []uint16
+	nearbySent bool
+
+	// Last state records sent to this player, by player ID (protocol v3
+	// dead reckoning). Only touched by the room's broadcast loop.
+	sent map[uint16]sentRecord
+
+	// Room difficulty of the current run: sum of multiplier * seconds driven
+	// (see scoring.go)
+	runDifficulty float64
+	runTime       float64
+
+	// Running average of finished run ratings (0 until the first run)
+	skill float64
+	// Skill converges faster, counting the run in progress (see Room.CalibrateSkill)
+	calibrating bool
+
+	// The run that just ended in an explosion (reported to the leaderboard)
+	finishedRun    Run
+	hasFinishedRun bool
+
+	// Endless mode (see milestones.go): where the current run started, the
+	// milestones it reached, and the prestige resets of this session
+	runStartY  float64
+	milestones int
+	prestiges  int
+
+	// Last collision of the car announced to clients (see impact.go). Only
+	// touched by the room's game loop.
+	impactAt time.Time
+}
+
+// shiftY moves the player along the road by dy, keeping the anti-cheat
+// baseline and tick start in step (world rebasing, see rebase.go)
+func (p *Player) shiftY(dy float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.Y += dy
+	p.LastValidY += dy
+	p.tickStartY += dy
+	p.runStartY += dy
 }
 
 // PlayerConnection interface for network abstraction
@@ -71,12 +177,16 @@ type PlayerConnection interface {
 	Send(data []byte) error
 	Close() error
 	RemoteAddr() string
+	RTT() time.Duration     // Smoothed round-trip time (0 if not measured yet)
+	RTTVar() time.Duration  // Round-trip time variation (0 if not measured yet)
+	ProtocolVersion() uint8 // Negotiated protocol version, selects the state record format
 }
 
 // NewPlayer creates a new player
 func NewPlayer(id uint16, sessionID, name string, color uint8, conn PlayerConnection) *Player {
 	now := time.Now()
 	return &Player{
+		mu:          orderedRWMutex{class: lockPlayer, id: id},
 		ID:          id,
 		SessionID:   sessionID,
 		Name:        name,
@@ -90,12 +200,28 @@ func NewPlayer(id uint16, sessionID, name string, color uint8, conn PlayerConnec
 		Exploded:    false,
 		ConnectedAt: now,
 		LastInputTime: now,
+		spawnedAt:   now,
+		Fuel:          config.FuelCapacity,
 		InputBuffer: make([]PlayerInput, 0, 8),
 	}
 }
 
+// GetName returns the player's name (thread-safe). Off the physics tick,
+// read the name this way, since a guest linking an account is renamed.
+func (p *Player) GetName() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.Name
+}
+
 // GetState returns a snapshot of player state (thread-safe)
 func (p *Player) GetState() PlayerState {
+	return p.stateAt(time.Now())
+}
+
+// stateAt returns a snapshot of player state, ghost mode as of now
+func (p *Player) stateAt(now time.Time) PlayerState {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
@@ -109,9 +235,43 @@ func (p *Player) GetState() PlayerState {
 		Angle:    p.Angle,
 		Rating:   p.Rating,
 		Exploded: p.Exploded,
+		VelX:     p.VelX,
+		VelY:     p.VelY,
+		Ghost:    p.ghostedUnlocked(now),
+		Lane:     p.Lane,
+		Airborne: p.Height > 0,
+		Fuel:     p.Fuel,
+		InputAck: p.inputAck,
+		Acked:    p.acked,
 	}
 }
 
+// beginTick remembers the position at the start of a physics tick and
+// acknowledges the input the tick drives with
+func (p *Player) beginTick() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.tickStartX, p.tickStartY = p.X, p.Y
+	if p.unacked {
+		p.inputAck, p.acked, p.unacked = p.applied, true, false
+	}
+}
+
+// endTick derives the velocity from the movement since beginTick.
+// Physics and collisions both count; exploded cars stand still.
+func (p *Player) endTick(dt float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.Exploded || dt <= 0 {
+		p.VelX, p.VelY = 0, 0
+		return
+	}
+	p.VelX = (p.X - p.tickStartX) / dt
+	p.VelY = (p.Y - p.tickStartY) / dt
+}
+
 // ApplyInput applies player input (thread-safe)
 func (p *Player) ApplyInput(input PlayerInput) {
 	p.mu.Lock()
@@ -119,6 +279,14 @@ func (p *Player) ApplyInput(input PlayerInput) {
 
 	p.CurrentInput = input
 	p.LastInputTime = time.Now()
+	p.applied, p.unacked = input.Sequence, true
+}
+
+// GetInput returns the input the player drives with (thread-safe)
+func (p *Player) GetInput() PlayerInput {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.CurrentInput
 }
 
 // QueueInput adds input to the buffer
@@ -147,14 +315,19 @@ func (p *Player) PopInput() (PlayerInput, bool) {
 }
 
 // Respawn respawns the player at road center
-func (p *Player) Respawn() {
+func (p *Player) Respawn(road *track.Track) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
 	p.Exploded = false
 	p.Speed = 0
 	p.Angle = 0
-	newX := config.GetRoadCurve(p.Y)
+	p.VelX, p.VelY = 0, 0
+	p.Height, p.VelZ = 0, 0
+	p.Fuel = config.FuelCapacity
+	p.spawnedAt = time.Now()
+	p.runStartY, p.milestones = p.Y, 0
+	newX := road.Center(p.Y)
 	p.X = newX
 
 	// Update anti-cheat baseline to prevent rubberband after respawn
@@ -186,11 +359,107 @@ func (p *Player) Explode() {
 	}
 
 	p.Exploded = true
-	p.Rating = 0
+	p.Height, p.VelZ = 0, 0
+	p.endRunUnlocked()
 	p.ExplodedAt = time.Now()
 	log.Printf("Player %s (ID: %d) exploded at Y=%.0f", p.Name, p.ID, p.Y)
 }
 
+// endRunUnlocked banks the current rating as a finished run and resets it.
+// IMPORTANT: Caller must hold p.mu.
+func (p *Player) endRunUnlocked() {
+	if run := p.takeRunUnlocked(); run.Rating > 0 {
+		p.finishedRun = run
+		p.hasFinishedRun = true
+	}
+}
+
+// takeRunUnlocked finishes the current run, updating the player's skill.
+// IMPORTANT: Caller must hold p.mu.
+func (p *Player) takeRunUnlocked() Run {
+	run := Run{Rating: p.Rating, Difficulty: 1}
+	if p.runTime > 0 {
+		run.Difficulty = p.runDifficulty / p.runTime
+	}
+	if run.Rating > 0 {
+		weight := config.ScoringSkillWeight
+		if p.calibrating {
+			weight = config.PlacementSkillWeight
+		}
+		if p.skill == 0 {
+			p.skill = run.Rating
+		} else {
+			p.skill += (run.Rating - p.skill) * weight
+		}
+	}
+	p.Rating = 0
+	p.runDifficulty, p.runTime = 0, 0
+	return run
+}
+
+// TakeFinishedRun returns the last finished run, once.
+func (p *Player) TakeFinishedRun() (Run, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.hasFinishedRun {
+		return Run{}, false
+	}
+	p.hasFinishedRun = false
+	return p.finishedRun, true
+}
+
+// EndRun finishes the current run (e.g. on leave) and returns it.
+func (p *Player) EndRun() Run {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.takeRunUnlocked()
+}
+
+// ForfeitRun discards the current run without reporting it (e.g. on kick).
+func (p *Player) ForfeitRun() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.runDifficulty, p.runTime = 0, 0
+	p.Rating = 0
+	p.finishedRun = Run{}
+	p.hasFinishedRun = false
+}
+
+// Skill returns the running average of the player's run ratings (0 before
+// the first finished run). While calibrating, a run in progress that is
+// already better counts too, so a driver who never crashes still converges.
+func (p *Player) Skill() float64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.calibrating && p.Rating > p.skill {
+		return p.Rating
+	}
+	return p.skill
+}
+
+// calibrate makes the player's skill converge faster from now on.
+func (p *Player) calibrate() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calibrating = true
+}
+
+// sampleDifficulty adds dt seconds at a room difficulty multiplier to the
+// current run.
+func (p *Player) sampleDifficulty(multiplier, dt float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.Exploded {
+		return
+	}
+	p.runDifficulty += multiplier * dt
+	p.runTime += dt
+}
+
 // UpdateRating updates player rating based on speed
 func (p *Player) UpdateRating(dt float64) {
 	p.mu.Lock()
@@ -0,0 +1,235 @@
+package game
+
+import (
+	"log"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/track"
+)
+
+// Practice rooms
+//
+// A practice room is a private room for one human who wants to learn the
+// road. Anti-cheat still corrects movement but never kicks, and runs don't
+// reach the leaderboard. The player can go back to the start line at any
+// time (Reset message); each start begins an attempt, which ends with the
+// first explosion. With replay on, a ghost car (see ghost.go) drives the
+// best attempt so far alongside the current one, in step with it.
+//
+// Attempts live in the room's memory only. Players who opted out of input
+// recording (DisableReplays) get no recording at all: the room drops what
+// it recorded, removes the replay car and records nothing more.
+
+// replaySample is a recorded car state, one per physics tick
+type replaySample struct {
+	x, y, speed, angle, rating float64
+}
+
+// practiceState is the practice mode state of a room
+type practiceState struct {
+	mu orderedMutex
+
+	replay    bool           // Show the best attempt as a ghost car
+	disabled  bool           // Recording disabled for good (DisableReplays)
+	recording bool           // An attempt from the start line is under way
+	attempt   []replaySample // The current attempt
+	best      []replaySample // The best finished attempt (highest rating)
+
+	// Replay car, added with the first best attempt. Only touched by the
+	// physics tick, which adds it without holding mu (see lockorder.go).
+	ghost *Player
+}
+
+// EnablePractice turns the room into a practice room. Must be called
+// before the first player joins.
+func (r *Room) EnablePractice(replay bool) {
+	r.practice = &practiceState{mu: orderedMutex{class: lockRoomMode}, replay: replay, recording: true}
+}
+
+// Practice reports whether the room is a private practice room.
+func (r *Room) Practice() bool {
+	return r.practice != nil
+}
+
+// DisableReplays stops recording the attempts of a practice room's player
+// and drops the recorded ones with the replay car.
+func (r *Room) DisableReplays() error {
+	if r.practice == nil {
+		return ErrNotPractice
+	}
+
+	pr := r.practice
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	pr.disabled, pr.recording = true, false
+	pr.attempt, pr.best = nil, nil
+	return nil
+}
+
+// maxReplaySamples is the longest attempt that is recorded in full
+func (r *Room) maxReplaySamples() int {
+	return int(config.PracticeReplayMax.Seconds()) * r.physicsRate
+}
+
+// ResetPlayer puts a player in a practice room back on the start line and
+// begins a new attempt. The current run ends without reaching the leaderboard.
+func (r *Room) ResetPlayer(playerID uint16) error {
+	if r.practice == nil {
+		return ErrNotPractice
+	}
+
+	r.mu.RLock()
+	p, exists := r.players[playerID]
+	r.mu.RUnlock()
+	if !exists || p.IsBot() {
+		return ErrPlayerNotFound
+	}
+
+	p.ForfeitRun()
+	p.resetToStart(r.road)
+
+	pr := r.practice
+	pr.mu.Lock()
+	pr.endAttemptUnlocked()
+	pr.recording = !pr.disabled
+	pr.mu.Unlock()
+
+	if r.tutorial != nil {
+		r.resetTutorial(p)
+	}
+	return nil
+}
+
+// resetToStart moves the player to the start line, at a standstill
+func (p *Player) resetToStart(road *track.Track) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.Exploded = false
+	p.Speed = 0
+	p.Angle = 0
+	p.VelX, p.VelY = 0, 0
+	p.X = road.Center(0)
+	p.Y = 0
+	p.spawnedAt = time.Now()
+
+	p.LastValidX = p.X
+	p.LastValidY = p.Y
+	p.Violations = 0
+}
+
+// endAttemptUnlocked finishes the current attempt and keeps it if it beat
+// the best one.
+// IMPORTANT: Caller must hold pr.mu.
+func (pr *practiceState) endAttemptUnlocked() {
+	if pr.recording && len(pr.attempt) > 0 {
+		last := pr.attempt[len(pr.attempt)-1]
+		if len(pr.best) == 0 || last.rating > pr.best[len(pr.best)-1].rating {
+			pr.best, pr.attempt = pr.attempt, pr.best
+		}
+	}
+	pr.attempt = pr.attempt[:0]
+	pr.recording = false
+}
+
+// practiceTick records the human's attempt and moves the replay car in step
+// with it. Called by the physics loop after movement and collisions.
+func (r *Room) practiceTick(players []*Player) {
+	var human *Player
+	for _, p := range players {
+		if !p.IsBot() {
+			human = p
+			break
+		}
+	}
+	if human == nil {
+		return
+	}
+	state := human.GetState()
+
+	// The room's lock comes before the practice state's: add the replay car
+	// first
+	pr := r.practice
+	if pr.ghost == nil && pr.hasBest() {
+		pr.ghost = r.addReplay(human)
+	}
+	if pr.ghost != nil && pr.isDisabled() {
+		r.RemovePlayer(pr.ghost.ID)
+		pr.ghost = nil
+	}
+
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	if pr.recording {
+		if state.Exploded {
+			pr.endAttemptUnlocked()
+		} else if len(pr.attempt) < r.maxReplaySamples() {
+			pr.attempt = append(pr.attempt, replaySample{
+				x: state.X, y: state.Y, speed: state.Speed, angle: state.Angle, rating: state.Rating,
+			})
+		}
+	}
+
+	if pr.ghost == nil || len(pr.best) == 0 {
+		return
+	}
+
+	// The replay waits at the start line between attempts and stops where
+	// the best attempt ended
+	i := 0
+	if pr.recording {
+		i = min(len(pr.attempt), len(pr.best)) - 1
+	}
+	if i >= 0 {
+		pr.ghost.moveTo(pr.best[i])
+	}
+}
+
+// hasBest reports whether the replay car has a best attempt to drive
+func (pr *practiceState) hasBest() bool {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	return pr.replay && len(pr.best) > 0
+}
+
+// isDisabled reports whether recording was disabled
+func (pr *practiceState) isDisabled() bool {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	return pr.disabled
+}
+
+// addReplay adds the replay car for the human's best attempt. It isn't a
+// participant: no host rights, no anti-cheat, never reported.
+func (r *Room) addReplay(human *Player) *Player {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id := r.nextPlayerID
+	r.nextPlayerID++
+
+	name := "Best run"
+	p := NewPlayer(id, "replay", name, human.Color, botConnection{})
+	p.replay = true
+	p.setGhostUnlocked(GhostPractice, time.Time{})
+	p.X = r.road.Center(0)
+	r.players[id] = p
+
+	r.broadcastUnlocked(r.protocol.EncodePlayerJoin(id, name, p.Color))
+	log.Printf("Replay car (ID: %d) added to practice room %s", id, r.ID)
+	return p
+}
+
+// moveTo places the replay car at a recorded state
+func (p *Player) moveTo(s replaySample) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.X, p.Y = s.x, s.y
+	p.Speed, p.Angle, p.Rating = s.speed, s.angle, s.rating
+}
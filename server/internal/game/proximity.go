@@ -0,0 +1,61 @@
+package game
+
+import (
+	"slices"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/network"
+)
+
+// Proximity groups
+//
+// Every config.NearbyInterval the room tells each ProtocolV14 client which
+// cars are within config.NearbyRadius of its own, in a Nearby message sent
+// only when the set changed. Clients use it to pick the peers of proximity
+// voice chat (see signal.go) and to lower the detail of far cars' audio and
+// effects. It is independent of the state updates, which still carry every
+// car.
+//
+// The neighbors come from a spatial grid of its own, with cells as large as
+// the radius so the 3x3 cells around a car cover it. Unlike the collision
+// grid it holds ghosts too: a ghost still drives among the others. Bots are
+// reported like any car; they just never answer voice signaling.
+
+// NewProximityGrid creates a spatial grid for neighbors within radius
+func NewProximityGrid(radius float64) *SpatialGrid {
+	g := NewSpatialGrid(radius)
+	g.keepGhosts = true
+	return g
+}
+
+// sendNearby sends ProtocolV14 clients the players near them, if changed
+// since the last Nearby message. Called by the game loop.
+func (r *Room) sendNearby() {
+	scratch := &r.scratch
+	scratch.proximity = r.snapshotPlayers(scratch.proximity)
+	r.proximity.Update(scratch.proximity)
+
+	for _, p := range scratch.proximity {
+		if p.IsBot() || p.Connection.ProtocolVersion() < network.ProtocolV14 {
+			continue
+		}
+		scratch.nearby = r.proximity.GetPlayersWithin(p, config.NearbyRadius, scratch.nearby[:0])
+		ids := scratch.nearbyIDs[:0]
+		for _, other := range scratch.nearby {
+			ids = append(ids, other.ID)
+		}
+		slices.Sort(ids)
+		scratch.nearbyIDs = ids
+
+		// Only the game loop touches the last sent set
+		if p.nearbySent && slices.Equal(ids, p.nearby) {
+			continue
+		}
+		p.nearby = append(p.nearby[:0], ids...)
+		p.nearbySent = true
+		p.Connection.Send(r.protocol.EncodeNearby(ids))
+	}
+
+	clear(scratch.proximity)
+	clear(scratch.nearby)
+}
@@ -0,0 +1,84 @@
+package game
+
+import (
+	"time"
+
+	"github.com/race/server/config"
+)
+
+// ClientPerf is a performance report sent by a client in its ping
+type ClientPerf struct {
+	FPS           uint8
+	InterpDelayMS uint16
+	JitterMS      uint16
+	ReportedAt    time.Time
+}
+
+// RoomQuality aggregates recent client performance reports for a room
+type RoomQuality struct {
+	Samples          int     // Players with a recent report
+	AvgFPS           float64 // Average client frame rate
+	AvgInterpDelayMS float64 // Average client interpolation delay
+	AvgJitterMS      float64 // Average perceived update jitter
+	Flagged          bool    // True if the room looks problematic
+	Reason           string  // Why the room was flagged
+}
+
+// ReportPerf stores the latest performance report from the client (thread-safe)
+func (p *Player) ReportPerf(perf ClientPerf) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.Perf = perf
+}
+
+// GetPerf returns the latest performance report (thread-safe)
+func (p *Player) GetPerf() ClientPerf {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.Perf
+}
+
+// Quality aggregates the room's recent client performance reports.
+// Rooms whose clients average a low frame rate or high jitter are flagged.
+func (r *Room) Quality() RoomQuality {
+	r.mu.RLock()
+	players := make([]*Player, 0, len(r.players))
+	for _, p := range r.players {
+		players = append(players, p)
+	}
+	r.mu.RUnlock()
+
+	var q RoomQuality
+	for _, p := range players {
+		perf := p.GetPerf()
+		if perf.ReportedAt.IsZero() || time.Since(perf.ReportedAt) > config.PerfReportMaxAge {
+			continue
+		}
+		q.Samples++
+		q.AvgFPS += float64(perf.FPS)
+		q.AvgInterpDelayMS += float64(perf.InterpDelayMS)
+		q.AvgJitterMS += float64(perf.JitterMS)
+	}
+
+	if q.Samples == 0 {
+		return q
+	}
+
+	n := float64(q.Samples)
+	q.AvgFPS /= n
+	q.AvgInterpDelayMS /= n
+	q.AvgJitterMS /= n
+
+	switch {
+	case q.AvgJitterMS > config.QualityMaxJitterMS:
+		q.Flagged = true
+		q.Reason = "high jitter"
+	case q.AvgFPS < config.QualityMinFPS:
+		q.Flagged = true
+		q.Reason = "low client frame rate"
+	}
+
+	return q
+}
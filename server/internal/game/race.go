@@ -0,0 +1,383 @@
+package game
+
+import (
+	"log"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/network"
+)
+
+// Race mode
+//
+// In a race room the humans race config.RaceLaps laps of
+// config.RaceLapLength down the road, each lap split by
+// config.RaceCheckpoints checkpoints, the lap's line last. A race starts
+// once config.RaceMinPlayers humans are in the room: they are lined up on a
+// grid across the lanes, ghosted (see ghost.go), and their input is dropped
+// for config.RaceCountdown. Players joining during the countdown take the
+// next place on the grid; those joining once the race is on drive outside
+// it until the next one. A racer's progress is the road their car covers,
+// so a crash costs the time lost, not the distance.
+//
+// Clients (ProtocolV34) get a RaceStart message when the countdown starts,
+// again at the start, and when they join during either; it tells each
+// player whether they race. Everyone hears of every checkpoint passed
+// (CheckpointPassed) and every finish (RaceFinished, with the place), and
+// gets the standings (Leaderboard) every config.RaceLeaderboardInterval.
+// The race ends once every racer has finished, config.RaceFinishGrace after
+// the first finish, or after config.RaceTimeLimit, with a final
+// Leaderboard; the next countdown starts config.RaceResultsTime later.
+// Times are physics time, so a slow tick doesn't cost anyone. Race rooms
+// aren't endless (no milestones or prestige); bots race outside the race.
+
+// racePhase is where a race room is between races
+type racePhase uint8
+
+const (
+	raceWaiting   racePhase = iota // For enough players
+	raceCountdown                  // Racers on the grid
+	raceRunning
+	raceFinished // Showing the results
+)
+
+// raceState is the race of a race room. Only touched by the tick, but for
+// countdown and startNow.
+type raceState struct {
+	countdown atomic.Bool // Racers are on the grid: their input is dropped
+	startNow  atomic.Bool // The host asked for the next countdown (see host.go)
+
+	number   uint16
+	phase    racePhase
+	elapsed  float64 // Physics seconds in the phase
+	racers   map[uint16]*racer
+	told     map[uint16]bool // Players who got the race's RaceStart
+	slots    int             // Grid places taken
+	front    float64         // Y of the grid's front row
+	finished int
+	firstAt  float64 // Race time of the first finish
+	boardDue float64 // Race time of the next Leaderboard
+
+	humans  []*Player // Scratch, reused every tick
+	present map[uint16]bool
+	board   []*racer
+}
+
+// racer is a player in a race
+type racer struct {
+	player   *Player
+	gridX    float64
+	progress float64 // Road covered since the start
+	passed   int     // Checkpoints passed
+	split    float64 // Race time of the last one
+	place    int     // 0 until finished
+	time     float64 // Race time of the finish
+}
+
+// newRaceState returns the race state of a new race room
+func newRaceState() *raceState {
+	return &raceState{
+		racers:  make(map[uint16]*racer),
+		told:    make(map[uint16]bool),
+		present: make(map[uint16]bool),
+	}
+}
+
+// standing returns the racer's place in the race for clients
+func (rc *racer) standing() network.RaceStanding {
+	s := network.RaceStanding{PlayerID: rc.player.ID, TimeMS: raceMS(rc.split)}
+	if rc.passed > 0 {
+		s.Lap = uint8((rc.passed-1)/config.RaceCheckpoints + 1)
+		s.Checkpoint = uint8((rc.passed-1)%config.RaceCheckpoints + 1)
+	}
+	if rc.place > 0 {
+		s.TimeMS = raceMS(rc.time)
+	}
+	return s
+}
+
+// raceMS converts a race time to milliseconds
+func raceMS(seconds float64) uint32 {
+	return uint32(seconds * 1000)
+}
+
+// raceTick runs the room's race: starts the countdown, holds the grid,
+// counts the racers' checkpoints and finishes and ends the race. Called by
+// the physics loop once anti-cheat has placed the cars.
+func (r *Room) raceTick(players []*Player, dt float64) {
+	rs := r.race
+	rs.elapsed += dt
+
+	// Racers who left are out of the race
+	humans := rs.humans[:0]
+	clear(rs.present)
+	for _, p := range players {
+		if !p.IsBot() {
+			humans = append(humans, p)
+			rs.present[p.ID] = true
+		}
+	}
+	rs.humans = humans
+	start := rs.startNow.Swap(false) && len(humans) > 0
+	for id := range rs.racers {
+		if !rs.present[id] {
+			delete(rs.racers, id)
+			delete(rs.told, id)
+		}
+	}
+
+	switch rs.phase {
+	case raceWaiting:
+		if len(humans) >= config.RaceMinPlayers || start {
+			r.startCountdown(humans)
+		}
+
+	case raceCountdown:
+		if len(rs.racers) == 0 {
+			r.raceSetPhase(raceWaiting)
+			break
+		}
+		for _, p := range humans {
+			if !rs.told[p.ID] {
+				r.enterGrid(p)
+				p.Connection.Send(r.raceStartMessage(true))
+			}
+		}
+		for _, rc := range rs.racers {
+			state := rc.player.GetState()
+			rc.player.follow(PlayerState{X: rc.gridX, Y: state.Y})
+		}
+		if rs.elapsed >= config.RaceCountdown.Seconds() {
+			r.startRace(humans)
+		}
+
+	case raceRunning:
+		for _, p := range humans {
+			if !rs.told[p.ID] {
+				rs.told[p.ID] = true
+				p.Connection.Send(r.raceStartMessage(false))
+			}
+		}
+		r.raceProgress()
+		if rs.elapsed >= rs.boardDue {
+			rs.boardDue += config.RaceLeaderboardInterval.Seconds()
+			r.broadcastSince(network.ProtocolV34, r.leaderboardMessage(false))
+		}
+		if r.raceOver() {
+			r.endRace()
+		}
+
+	case raceFinished:
+		if rs.elapsed < config.RaceResultsTime.Seconds() && !start {
+			break
+		}
+		if len(humans) >= config.RaceMinPlayers || start {
+			r.startCountdown(humans)
+		} else {
+			r.raceSetPhase(raceWaiting)
+		}
+	}
+}
+
+// raceSetPhase moves the race on to a phase
+func (r *Room) raceSetPhase(phase racePhase) {
+	rs := r.race
+	rs.phase, rs.elapsed = phase, 0
+	rs.countdown.Store(phase == raceCountdown)
+}
+
+// startCountdown lines the humans up on the grid of a new race and tells
+// everyone.
+func (r *Room) startCountdown(humans []*Player) {
+	rs := r.race
+	rs.number++
+	clear(rs.racers)
+	clear(rs.told)
+	rs.slots, rs.finished, rs.firstAt = 0, 0, 0
+
+	// The grid's rows are spaced down the road behind the car furthest on,
+	// and start past the origin
+	rows := (len(humans) + r.road.LaneCount() - 1) / r.road.LaneCount()
+	rs.front = float64(rows-1) * config.RaceGridSpacing
+	for _, p := range humans {
+		rs.front = max(rs.front, p.GetState().Y)
+	}
+	r.raceSetPhase(raceCountdown)
+
+	for _, p := range humans {
+		r.enterGrid(p)
+		p.Connection.Send(r.raceStartMessage(true))
+	}
+	r.spectate(r.raceStartMessage(false))
+	log.Printf("Race %d in room %s: countdown with %d racers", rs.number, r.ID, len(rs.racers))
+}
+
+// enterGrid puts a player in the race on the grid's next place. The run in
+// progress ends there.
+func (r *Room) enterGrid(p *Player) {
+	rs := r.race
+	lanes := r.road.LaneCount()
+	row, lane := rs.slots/lanes, rs.slots%lanes+1
+	rs.slots++
+
+	y := max(0, rs.front-float64(row)*config.RaceGridSpacing)
+	x := r.road.LaneCenter(lane, y)
+	r.reportRun(p, p.placeOnGrid(x, y))
+	rs.racers[p.ID] = &racer{player: p, gridX: x}
+	rs.told[p.ID] = true
+}
+
+// startRace releases the grid and tells everyone the race is on.
+func (r *Room) startRace(humans []*Player) {
+	rs := r.race
+	r.raceSetPhase(raceRunning)
+	rs.boardDue = config.RaceLeaderboardInterval.Seconds()
+
+	for _, rc := range rs.racers {
+		rc.player.ClearGhost(GhostRace)
+	}
+	for _, p := range humans {
+		_, racing := rs.racers[p.ID]
+		p.Connection.Send(r.raceStartMessage(racing))
+	}
+	r.spectate(r.raceStartMessage(false))
+	log.Printf("Race %d in room %s: started with %d racers", rs.number, r.ID, len(rs.racers))
+}
+
+// raceProgress adds the racers' progress this tick and announces the
+// checkpoints they passed and their finishes.
+func (r *Room) raceProgress() {
+	rs := r.race
+	segment := config.RaceLapLength / config.RaceCheckpoints
+	last := config.RaceLaps * config.RaceCheckpoints
+	for _, rc := range rs.racers {
+		if rc.place > 0 {
+			continue
+		}
+		rc.progress += rc.player.tickForward()
+		for rc.passed < last && rc.progress >= float64(rc.passed+1)*segment {
+			rc.passed++
+			rc.split = rs.elapsed
+			r.broadcastSince(network.ProtocolV34, r.protocol.EncodeCheckpointPassed(rc.standing()))
+		}
+		if rc.passed == last {
+			rs.finished++
+			rc.place, rc.time = rs.finished, rs.elapsed
+			if rs.finished == 1 {
+				rs.firstAt = rs.elapsed
+			}
+			r.broadcastSince(network.ProtocolV34,
+				r.protocol.EncodeRaceFinished(rc.player.ID, uint8(min(rc.place, 0xFF)), raceMS(rc.time)))
+			log.Printf("Race %d in room %s: %s finished in place %d after %.2fs",
+				rs.number, r.ID, rc.player.Name, rc.place, rc.time)
+		}
+	}
+}
+
+// raceOver reports whether the race running is over
+func (r *Room) raceOver() bool {
+	rs := r.race
+	if rs.elapsed >= config.RaceTimeLimit.Seconds() {
+		return true
+	}
+	if rs.finished > 0 && rs.elapsed-rs.firstAt >= config.RaceFinishGrace.Seconds() {
+		return true
+	}
+	for _, rc := range rs.racers {
+		if rc.place == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// endRace tells everyone the final standings.
+func (r *Room) endRace() {
+	rs := r.race
+	r.broadcastSince(network.ProtocolV34, r.leaderboardMessage(true))
+	log.Printf("Race %d in room %s: over after %.2fs, %d of %d racers finished",
+		rs.number, r.ID, rs.elapsed, rs.finished, len(rs.racers))
+	r.raceSetPhase(raceFinished)
+}
+
+// raceStartMessage returns the race's RaceStart message for a player who
+// races in it or not
+func (r *Room) raceStartMessage(entered bool) []byte {
+	rs := r.race
+	var countdown float64
+	if rs.phase == raceCountdown {
+		countdown = max(0, config.RaceCountdown.Seconds()-rs.elapsed)
+	}
+	return r.protocol.EncodeRaceStart(network.RaceStart{
+		Race:        rs.number,
+		Laps:        config.RaceLaps,
+		Checkpoints: config.RaceCheckpoints,
+		LapLength:   config.RaceLapLength,
+		CountdownMS: uint16(countdown * 1000),
+		Entered:     entered,
+	})
+}
+
+// leaderboardMessage returns the race's standings: the finishers by place,
+// then the others by checkpoints passed, sooner first, and road covered
+func (r *Room) leaderboardMessage(final bool) []byte {
+	rs := r.race
+	board := rs.board[:0]
+	for _, rc := range rs.racers {
+		board = append(board, rc)
+	}
+	rs.board = board
+	sort.Slice(board, func(i, j int) bool {
+		a, b := board[i], board[j]
+		switch {
+		case (a.place > 0) != (b.place > 0):
+			return a.place > 0
+		case a.place > 0:
+			return a.place < b.place
+		case a.passed != b.passed:
+			return a.passed > b.passed
+		case a.split != b.split:
+			return a.split < b.split
+		}
+		return a.progress > b.progress
+	})
+
+	standings := make([]network.RaceStanding, len(board))
+	for i, rc := range board {
+		standings[i] = rc.standing()
+	}
+	return r.protocol.EncodeLeaderboard(rs.number, final, standings)
+}
+
+// spectate sends a message to the room's spectators only
+func (r *Room) spectate(data []byte) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	r.spectateUnlocked(data)
+}
+
+// placeOnGrid puts the player's car on a race's grid at (x, y), at a
+// standstill and ghosted, and returns the run it ended
+func (p *Player) placeOnGrid(x, y float64) Run {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	run := p.takeRunUnlocked()
+	p.Exploded = false
+	p.Speed, p.Angle = 0, 0
+	p.VelX, p.VelY = 0, 0
+	p.Height, p.VelZ = 0, 0
+	p.Fuel = config.FuelCapacity
+	p.X, p.Y = x, y
+	p.spawnedAt = time.Now()
+	p.runStartY, p.milestones = p.Y, 0
+
+	p.LastValidX, p.LastValidY = p.X, p.Y
+	p.Violations = 0
+	p.CurrentInput = PlayerInput{}
+	p.InputBuffer = nil
+	p.setGhostUnlocked(GhostRace, time.Time{})
+	return run
+}
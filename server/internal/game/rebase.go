@@ -0,0 +1,87 @@
+package game
+
+import (
+	"log"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/network"
+)
+
+// World rebasing
+//
+// The road is endless, so in a room that runs for days positions would grow
+// past what state records carry (Y is an int32) and lose precision on
+// clients. Once every car is at least RoomConfig.RebaseDistance down the
+// road, the room moves its origin forward by that distance: every Y the
+// room keeps drops by it and the road is evaluated that much further on
+// (track.Track.Shifted), so nothing moves relative to the road or to the
+// other cars. New players start at the origin, which never passes the last
+// car.
+//
+// ProtocolV9 clients get a Rebase message ahead of the next state update.
+// Older clients can't follow a rebase, so the room doesn't rebase while one
+// is in it. Practice rooms never rebase: their attempts and replays start
+// over at the start line.
+
+// rebaseTick moves the world origin forward when every car is past the
+// rebase distance. Called by the physics loop at the end of a tick.
+func (r *Room) rebaseTick() {
+	distance := r.rebaseDistance
+	if distance <= 0 || r.practice != nil {
+		return
+	}
+
+	// Checked and shifted under the lock, so a player joining meanwhile
+	// either blocks the rebase or starts at the new origin
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.players) == 0 {
+		return
+	}
+	for _, p := range r.players {
+		if !p.IsBot() && p.Connection.ProtocolVersion() < network.ProtocolV9 {
+			return
+		}
+		if p.GetState().Y < distance {
+			return
+		}
+	}
+	r.rebaseUnlocked(distance)
+}
+
+// rebaseUnlocked moves the world origin forward by shift (a multiple of
+// config.RoundSectorLength).
+// IMPORTANT: Caller must hold the room write lock.
+func (r *Room) rebaseUnlocked(shift float64) {
+	r.road = r.road.Shifted(shift)
+	r.physics.road = r.road
+	r.antiCheat.road = r.road
+	origin := r.road.Origin()
+
+	for _, p := range r.players {
+		p.shiftY(-shift)
+	}
+
+	// Round stats only keep positions within the current round
+	sectors := int(shift / config.RoundSectorLength)
+	for _, st := range r.round.stats {
+		st.lastY -= shift
+		st.sector -= sectors
+	}
+
+	msg := r.protocol.EncodeRebase(origin, shift)
+	for _, p := range r.players {
+		if p.Connection.ProtocolVersion() >= network.ProtocolV9 {
+			p.Connection.Send(msg)
+		}
+	}
+	r.spectateUnlocked(msg)
+
+	log.Printf("Room %s rebased: origin moved %.0f forward to %.0f", r.ID, shift, origin)
+}
+
+// rebaseMessage tells a joining ProtocolV9 client where the road starts
+func (r *Room) rebaseMessage() []byte {
+	return r.protocol.EncodeRebase(r.road.Origin(), 0)
+}
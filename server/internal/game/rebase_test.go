@@ -0,0 +1,65 @@
+package game
+
+import (
+	"math"
+	"testing"
+
+	"github.com/race/server/config"
+)
+
+// precisionRoom returns a room of two bots with a fixed seed, so that two
+// such rooms drive alike
+func precisionRoom(t *testing.T, rebaseDistance float64) *Room {
+	cfg := DefaultRoomConfig()
+	cfg.RebaseDistance = rebaseDistance
+	room := NewRoomWithConfig("rebase", cfg)
+	room.seed = 42
+	room.rng = NewRNG(room.seed)
+	addBots(t, room, 2)
+	return room
+}
+
+// TestRebasePrecision drives two cars past the rebase distance several
+// times, next to the same cars in a room that never rebases, and checks
+// that every tick they are where those are, measured from the origin, and
+// that they collide alike. More cars would collide in an order that
+// differs from room to room.
+func TestRebasePrecision(t *testing.T) {
+	const (
+		distance  = 5 * config.RoundSectorLength
+		rebases   = 5
+		tolerance = 1e-6
+		maxTicks  = 600 * config.PhysicsTickRate
+	)
+	rebasing, reference := precisionRoom(t, distance), precisionRoom(t, 0)
+
+	contacts, referenceContacts := 0, 0
+	for tick := 0; rebasing.road.Origin() < rebases*distance; tick++ {
+		if tick == maxTicks {
+			t.Fatalf("rebased to %.0f in %d ticks, want %.0f", rebasing.road.Origin(), tick, rebases*distance)
+		}
+		rebasing.Step(physicsDt, false)
+		reference.Step(physicsDt, false)
+		contacts += len(rebasing.scratch.contacts)
+		referenceContacts += len(reference.scratch.contacts)
+
+		origin := rebasing.road.Origin()
+		for id, p := range rebasing.players {
+			got, want := p.GetState(), reference.players[id].GetState()
+			if dy, dx := got.Y+origin-want.Y, got.X-want.X; math.Abs(dy) > tolerance || math.Abs(dx) > tolerance {
+				t.Fatalf("tick %d, origin %.0f: car %d at (%.9f, %.9f), (%.9f, %.9f) without rebasing",
+					tick, origin, id, got.X, got.Y+origin, want.X, want.Y)
+			}
+			if got.Y >= 2*distance {
+				t.Fatalf("tick %d: car %d at %.0f from the origin, past twice the rebase distance", tick, id, got.Y)
+			}
+		}
+	}
+
+	if contacts == 0 {
+		t.Fatal("the cars never collided")
+	}
+	if contacts != referenceContacts {
+		t.Errorf("%d collisions, %d without rebasing", contacts, referenceContacts)
+	}
+}
@@ -0,0 +1,159 @@
+package game
+
+import (
+	"time"
+
+	"github.com/race/server/config"
+)
+
+// Rejoining
+//
+// A human who leaves a room, or whose connection drops, can come back to
+// it within config.RejoinWindow and carry on where they left: at the same
+// distance down the road, with the rating, run and skill they had. The room
+// keeps what it needs of every departed human by their session (the
+// sessionID of AddPlayer, which the server keeps across the player's
+// connections), and a join of the session under the same name picks it up,
+// once. Kicked players are forgotten, and practice rooms keep nobody: their
+// attempts start over at the start line anyway.
+//
+// The run is still reported when the player leaves, so a player who never
+// comes back keeps it on the leaderboard; a resumed run reports again when
+// it ends, and the better score stays. A room keeps at most
+// config.DepartedPlayersMax departed players, dropping the oldest.
+
+// departedPlayer is what a room keeps of a human who left
+type departedPlayer struct {
+	name string
+	at   time.Time
+
+	// Road distances (Y plus the origin at the time), which rebasing
+	// doesn't change
+	distance float64
+	runStart float64
+
+	rating        float64
+	runDifficulty float64
+	runTime       float64
+	skill         float64
+	milestones    int
+	prestiges     int
+}
+
+// rememberUnlocked keeps a departing player's progress for their return.
+// IMPORTANT: Caller must hold the room write lock.
+func (r *Room) rememberUnlocked(p *Player, now time.Time) {
+	if p.IsBot() || p.SessionID == "" || r.practice != nil {
+		return
+	}
+	if r.departed == nil {
+		r.departed = make(map[string]departedPlayer)
+	}
+
+	oldest := ""
+	for session, d := range r.departed {
+		if now.Sub(d.at) >= config.RejoinWindow {
+			delete(r.departed, session)
+		} else if oldest == "" || d.at.Before(r.departed[oldest].at) {
+			oldest = session
+		}
+	}
+	if _, again := r.departed[p.SessionID]; !again && len(r.departed) >= config.DepartedPlayersMax {
+		delete(r.departed, oldest)
+	}
+
+	origin := r.road.Origin()
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	r.departed[p.SessionID] = departedPlayer{
+		name:          p.Name,
+		at:            now,
+		distance:      p.Y + origin,
+		runStart:      p.runStartY + origin,
+		rating:        p.Rating,
+		runDifficulty: p.runDifficulty,
+		runTime:       p.runTime,
+		skill:         p.skill,
+		milestones:    p.milestones,
+		prestiges:     p.prestiges,
+	}
+}
+
+// resumeUnlocked puts a joining player back where their session left the
+// room, if it did so recently under the same name. Called before the
+// player is added to the room. Returns whether they resumed.
+// IMPORTANT: Caller must hold the room write lock.
+func (r *Room) resumeUnlocked(p *Player, now time.Time) bool {
+	d, ok := r.departed[p.SessionID]
+	if !ok || p.SessionID == "" {
+		return false
+	}
+	delete(r.departed, p.SessionID)
+	if d.name != p.Name || now.Sub(d.at) >= config.RejoinWindow {
+		return false
+	}
+
+	// Rebasing never passes the last car, so a player who comes back behind
+	// the origin starts at it
+	origin := r.road.Origin()
+	p.Y = max(0, d.distance-origin)
+	p.X = r.road.Center(p.Y)
+	p.runStartY = d.runStart - origin
+	p.Rating = d.rating
+	p.runDifficulty, p.runTime = d.runDifficulty, d.runTime
+	p.skill = d.skill
+	p.milestones, p.prestiges = d.milestones, d.prestiges
+	return true
+}
+
+// forget drops what the room keeps of a session, so a kicked player
+// doesn't get their progress back
+func (r *Room) forget(sessionID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.departed, sessionID)
+}
+
+// Resumable reports whether a join of the session would carry on where it
+// left the room: it left recently, or it is still in the room (its
+// connection dropped without the room noticing yet).
+func (r *Room) Resumable(sessionID string) bool {
+	if sessionID == "" {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if d, ok := r.departed[sessionID]; ok && time.Since(d.at) < config.RejoinWindow {
+		return true
+	}
+	for _, p := range r.players {
+		if p.SessionID == sessionID {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveSession removes the player of a session still in the room, closing
+// their connection, so the session can join again on another one. Their
+// progress is kept as for any departure.
+func (r *Room) RemoveSession(sessionID string) {
+	if sessionID == "" {
+		return
+	}
+	r.mu.RLock()
+	var id uint16
+	for _, p := range r.players {
+		if p.SessionID == sessionID && !p.IsBot() {
+			id = p.ID
+			break
+		}
+	}
+	r.mu.RUnlock()
+
+	if id != 0 {
+		r.RemovePlayer(id)
+	}
+}
@@ -0,0 +1,271 @@
+package game
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/network"
+)
+
+// Relay mode
+//
+// In a relay room the humans race in teams of up to config.RelayTeamSize,
+// assigned as they join: the first team with a free seat takes them, or a
+// new one. A team drives one leg at a time: only its driver, who holds the
+// baton, steers a car. The teammates' cars are parked: the room places
+// them on the driver's car every tick, ghosted (see ghost.go), and drops
+// their input. Once the driver has gone config.RelayLegDistance down the
+// road the baton passes to the next teammate in joining order, whose car
+// takes over at speed where the driver's was; a driver who crashes carries
+// on after respawning. The team scores the distance of all its legs.
+//
+// The room tells a team's players about their team (Relay message: members,
+// driver, legs and distance) when it changes hands, gains or loses a
+// member. Relay rooms aren't endless (no milestones or prestige), and a
+// round's result ranks the teams by the distance they covered in it.
+// Parked cars earn no rating or round distance of their own. Bots race
+// outside the teams.
+
+// RoundTeam is what a relay team did in a round
+type RoundTeam struct {
+	Team     uint8    `json:"team"`
+	Players  []string `json:"players"` // In driving order
+	Distance float64  `json:"distance"`
+	Legs     int      `json:"legs"`
+}
+
+// relayState is the teams of a relay room, under the room's lock
+type relayState struct {
+	teams    []*relayTeam
+	byPlayer map[uint16]*relayTeam
+}
+
+// relayTeam is a team of a relay room
+type relayTeam struct {
+	id       uint8
+	members  []*Player // In driving order
+	active   int       // Index of the driver in members
+	legs     int
+	leg      float64 // Distance of the leg in progress
+	distance float64 // Distance of all legs
+
+	roundLegs     int // Of the current round
+	roundDistance float64
+}
+
+// driver returns the team's player with the baton
+func (t *relayTeam) driver() *Player {
+	return t.members[t.active]
+}
+
+// joinTeamUnlocked seats a human joining a relay room in a team, parked
+// unless the team was empty.
+// IMPORTANT: Caller must hold the room write lock.
+func (r *Room) joinTeamUnlocked(p *Player) {
+	rs := r.relay
+	var team *relayTeam
+	for _, t := range rs.teams {
+		if len(t.members) < config.RelayTeamSize {
+			team = t
+			break
+		}
+	}
+	if team == nil {
+		team = &relayTeam{id: rs.newTeamID()}
+		rs.teams = append(rs.teams, team)
+	}
+	team.members = append(team.members, p)
+	rs.byPlayer[p.ID] = team
+	if len(team.members) > 1 {
+		p.park(team.driver().GetState())
+	}
+
+	r.sendTeamUnlocked(team)
+	log.Printf("Player %s (ID: %d) joined relay team %d in room %s", p.Name, p.ID, team.id, r.ID)
+}
+
+// leaveTeamUnlocked takes a human leaving a relay room out of their team.
+// A driver leaving passes the baton to the next teammate.
+// IMPORTANT: Caller must hold the room write lock.
+func (r *Room) leaveTeamUnlocked(p *Player) {
+	rs := r.relay
+	team, ok := rs.byPlayer[p.ID]
+	if !ok {
+		return
+	}
+	delete(rs.byPlayer, p.ID)
+	p.unpark()
+
+	i := 0
+	for team.members[i] != p {
+		i++
+	}
+	team.members = append(team.members[:i], team.members[i+1:]...)
+	if len(team.members) == 0 {
+		for j, t := range rs.teams {
+			if t == team {
+				rs.teams = append(rs.teams[:j], rs.teams[j+1:]...)
+				break
+			}
+		}
+		return
+	}
+	switch {
+	case i < team.active:
+		team.active--
+	case i == team.active:
+		team.active %= len(team.members)
+		team.driver().unpark()
+	}
+	r.sendTeamUnlocked(team)
+}
+
+// relayTick adds the drivers' progress this tick to their teams' legs,
+// passes the batons of finished legs and moves the parked cars onto their
+// drivers. Called by the physics loop once anti-cheat has placed the cars.
+func (r *Room) relayTick() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, t := range r.relay.teams {
+		driver := t.driver()
+		if dy := driver.tickForward(); dy > 0 {
+			t.leg += dy
+			t.distance += dy
+			t.roundDistance += dy
+		}
+		if t.leg >= config.RelayLegDistance {
+			r.passBatonUnlocked(t)
+			driver = t.driver()
+		}
+
+		state := driver.GetState()
+		for _, p := range t.members {
+			if p != driver {
+				p.follow(state)
+			}
+		}
+	}
+}
+
+// passBatonUnlocked finishes the team's leg and hands the car to the next
+// teammate, who carries on where the driver is.
+// IMPORTANT: Caller must hold the room write lock.
+func (r *Room) passBatonUnlocked(t *relayTeam) {
+	t.legs++
+	t.roundLegs++
+	t.leg = 0
+	if len(t.members) > 1 {
+		out := t.driver()
+		t.active = (t.active + 1) % len(t.members)
+		in := t.driver()
+		in.follow(out.GetState())
+		in.unpark()
+		out.park(out.GetState())
+		log.Printf("Relay team %d in room %s: %s passed the baton to %s after leg %d",
+			t.id, r.ID, out.Name, in.Name, t.legs)
+	}
+	r.sendTeamUnlocked(t)
+}
+
+// sendTeamUnlocked tells the team's players about their team.
+// IMPORTANT: Caller must hold the room lock.
+func (r *Room) sendTeamUnlocked(t *relayTeam) {
+	members := make([]uint16, len(t.members))
+	for i, p := range t.members {
+		members[i] = p.ID
+	}
+	msg := r.protocol.EncodeRelay(network.RelayTeam{
+		Team:      t.id,
+		Active:    t.driver().ID,
+		Legs:      uint16(min(t.legs, 0xFFFF)),
+		Leg:       float32(t.leg),
+		LegLength: config.RelayLegDistance,
+		Distance:  float32(t.distance),
+		Members:   members,
+	})
+	for _, p := range t.members {
+		p.Connection.Send(msg)
+	}
+}
+
+// relayRound returns what the teams did in the round that ended, best
+// first, and starts counting the next round
+func (r *Room) relayRound() []RoundTeam {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	teams := make([]RoundTeam, 0, len(r.relay.teams))
+	for _, t := range r.relay.teams {
+		rt := RoundTeam{Team: t.id, Distance: t.roundDistance, Legs: t.roundLegs}
+		for _, p := range t.members {
+			rt.Players = append(rt.Players, p.Name)
+		}
+		teams = append(teams, rt)
+		t.roundDistance, t.roundLegs = 0, 0
+	}
+	sort.SliceStable(teams, func(i, j int) bool { return teams[i].Distance > teams[j].Distance })
+	return teams
+}
+
+// newTeamID returns the lowest team ID no team has
+func (rs *relayState) newTeamID() uint8 {
+	id := uint8(1)
+	for taken := true; taken; {
+		taken = false
+		for _, t := range rs.teams {
+			if t.id == id {
+				taken = true
+				id++
+				break
+			}
+		}
+	}
+	return id
+}
+
+// park stops the player's car for a teammate's leg: it takes no more input
+// and is placed on the driver's car at state
+func (p *Player) park(state PlayerState) {
+	p.parked.Store(true)
+	p.follow(state)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.CurrentInput = PlayerInput{}
+	p.InputBuffer = nil
+	p.setGhostUnlocked(GhostRelay, time.Time{})
+}
+
+// unpark gives the player's car back to them where it is
+func (p *Player) unpark() {
+	p.parked.Store(false)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.ghosts, GhostRelay)
+	p.LastValidX, p.LastValidY = p.X, p.Y
+}
+
+// follow places a parked car on its driver's car
+func (p *Player) follow(state PlayerState) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.X, p.Y = state.X, state.Y
+	p.Speed, p.Angle = state.Speed, state.Angle
+	p.VelX, p.VelY = state.VelX, state.VelY
+	p.LastValidX, p.LastValidY = p.X, p.Y
+}
+
+// tickForward returns how far down the road the car went this tick
+func (p *Player) tickForward() float64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.Y - p.tickStartY
+}
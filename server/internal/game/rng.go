@@ -0,0 +1,117 @@
+package game
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Room randomness
+//
+// Everything random in a room's simulation comes from the room's RNG,
+// seeded when the room is created, never from math/rand's shared source.
+// The seed is recorded with every round (RoundResult.Seed, in the match
+// history) and the RNG's state with room snapshots, so a re-simulation
+// given the same seed and inputs draws the same numbers. Subsystems with a
+// stream of their own fork it off the room's RNG when they start: each bot
+// forks one as it joins, so what a bot draws doesn't depend on the order
+// the tick visits the cars in.
+//
+// The generator is SplitMix64: fast, statistically sound for a game, and
+// its whole state is one number, which is what makes it easy to record and
+// resume. It is not for secrets (tokens and IDs use crypto/rand).
+
+// RNG is a deterministic random number generator. Not safe for concurrent
+// use: the room's RNG is used under the room lock, a bot's by the physics
+// tick.
+type RNG struct {
+	state uint64
+}
+
+// NewRNG returns an RNG seeded with seed
+func NewRNG(seed uint64) *RNG {
+	return &RNG{state: seed}
+}
+
+// newSeed returns a seed for a new room
+func newSeed() uint64 {
+	var b [8]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint64(b[:])
+}
+
+// State returns the RNG's state: an RNG seeded with it draws what this one
+// draws next
+func (g *RNG) State() uint64 {
+	return g.state
+}
+
+// Uint64 returns a random number
+func (g *RNG) Uint64() uint64 {
+	g.state += 0x9e3779b97f4a7c15
+	z := g.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// Float64 returns a random number in [0, 1)
+func (g *RNG) Float64() float64 {
+	return float64(g.Uint64()>>11) / (1 << 53)
+}
+
+// Intn returns a random number in [0, n). Panics if n <= 0.
+func (g *RNG) Intn(n int) int {
+	if n <= 0 {
+		panic("game: RNG.Intn of a non-positive n")
+	}
+	return int(g.Uint64() % uint64(n))
+}
+
+// NormFloat64 returns a normally distributed number with mean 0 and
+// standard deviation 1 (Box-Muller)
+func (g *RNG) NormFloat64() float64 {
+	u := 1 - g.Float64() // (0, 1], keeps the log finite
+	v := g.Float64()
+	return math.Sqrt(-2*math.Log(u)) * math.Cos(2*math.Pi*v)
+}
+
+// Fork returns a new RNG seeded from this one, for a subsystem's own
+// stream
+func (g *RNG) Fork() *RNG {
+	return NewRNG(g.Uint64())
+}
+
+// FormatSeed writes a seed or RNG state the way records carry it: 16 hex
+// digits (JSON numbers lose precision past 2^53)
+func FormatSeed(seed uint64) string {
+	return fmt.Sprintf("%016x", seed)
+}
+
+// ParseSeed reads a seed written by FormatSeed
+func ParseSeed(s string) (uint64, error) {
+	seed, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("seed %q: want 16 hex digits", s)
+	}
+	return seed, nil
+}
+
+// Seed returns the seed the room's RNG started from.
+func (r *Room) Seed() uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.seed
+}
+
+// SetSeed restarts the room's RNG from seed, to re-simulate a recorded
+// room. Must be called before the first player joins.
+func (r *Room) SetSeed(seed uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.seed = seed
+	r.rng = NewRNG(seed)
+}
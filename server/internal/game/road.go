@@ -0,0 +1,125 @@
+package game
+
+import (
+	"github.com/race/server/config"
+	"github.com/race/server/internal/network"
+	"github.com/race/server/internal/track"
+)
+
+// Custom tracks
+//
+// Every room drives one road (see package track), the built-in one unless a
+// custom track is set before the room starts. Clients know the built-in road;
+// ProtocolV7 clients are sent a custom track's geometry (Track message)
+// right after RoomInfo, and older clients can't join rooms that have one.
+// ProtocolV17 clients are also told its lane count (see lanes.go), and
+// ProtocolV18 clients its elevation and banking, ProtocolV19 clients its
+// jump ramps. A track that climbs, banks its bends or has ramps drives
+// differently (see Physics.UpdatePlayer), so older clients, which would
+// mispredict their car, can't join rooms on one.
+//
+// A room may drive its road in a variant (RoomConfig.Mirror and Night, see
+// track.Variant), whichever track it is. ProtocolV21 clients are told the
+// variant in the Track message, which rooms on the built-in road with a
+// variant send too, without curves, so that both sides mirror the same road;
+// older clients can't join rooms with a variant. Casual rooms may also
+// escalate their road (RoomConfig.Escalate, see track.Track.WithEscalation):
+// ProtocolV22 clients get the escalation in the Track message the same way,
+// and older clients can't join them.
+
+// SetTrack sets the room's road, which it drives in the room's variant.
+// Must be called before the first player joins.
+func (r *Room) SetTrack(t *track.Track) {
+	t = r.roomRoad(t)
+	r.road = t
+	r.physics.road = t
+	r.antiCheat.road = t
+}
+
+// Track returns the room's road.
+func (r *Room) Track() *track.Track {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.road
+}
+
+// supportsTrack reports whether a client can drive the room's road
+func (r *Room) supportsTrack(version uint8) bool {
+	switch {
+	case r.escalate:
+		return version >= network.ProtocolV22
+	case r.variant != 0:
+		return version >= network.ProtocolV21
+	case r.road.IsDefault():
+		return true
+	case len(r.road.Ramps) > 0:
+		return version >= network.ProtocolV19
+	case !r.road.IsFlat():
+		return version >= network.ProtocolV18
+	}
+	return version >= network.ProtocolV7
+}
+
+// roomRoad returns a track as the room drives it: in its variant, and
+// escalating if the room's road does
+func (r *Room) roomRoad(t *track.Track) *track.Track {
+	t = t.WithVariant(r.variant)
+	if r.escalate {
+		t = t.WithEscalation(config.TrackEscalationRate, config.TrackEscalationMax)
+	}
+	return t
+}
+
+// sendsTrack reports whether clients are sent the room's road: a custom
+// track, or the built-in road in a variant or escalating
+func (r *Room) sendsTrack() bool {
+	return !r.road.IsDefault() || r.variant != 0 || r.escalate
+}
+
+// trackMessage encodes the room's road for clients of the given version
+// (ProtocolV7 or later). The built-in road goes without its curves, which
+// clients know.
+func (r *Room) trackMessage(version uint8) []byte {
+	m := network.TrackMessage{
+		Width:     uint16(r.road.Width),
+		Lanes:     uint8(r.road.LaneCount()),
+		Banking:   float32(r.road.Banking),
+		Elevation: trackCurves(r.road.Elevation),
+		Ramps:     trackRamps(r.road.Ramps),
+		Variant:   uint8(r.variant),
+	}
+	rate, limit := r.road.Escalation()
+	m.EscalationRate, m.EscalationMax = float32(rate), float32(limit)
+	if !r.road.IsDefault() {
+		m.Curves = trackCurves(r.road.Curves)
+	}
+	return r.protocol.EncodeTrackVersion(version, m)
+}
+
+// trackCurves converts curves to their wire format
+func trackCurves(curves []track.Curve) []network.TrackCurve {
+	wire := make([]network.TrackCurve, len(curves))
+	for i, c := range curves {
+		wire[i] = network.TrackCurve{
+			Amplitude:  float32(c.Amplitude),
+			Wavelength: float32(c.Wavelength),
+			Phase:      float32(c.Phase),
+			Sharpness:  uint8(c.Sharpness),
+		}
+	}
+	return wire
+}
+
+// trackRamps converts ramps to their wire format
+func trackRamps(ramps []track.Ramp) []network.TrackRamp {
+	wire := make([]network.TrackRamp, len(ramps))
+	for i, ramp := range ramps {
+		wire[i] = network.TrackRamp{
+			Offset:  float32(ramp.Offset),
+			Spacing: float32(ramp.Spacing),
+			Length:  float32(ramp.Length),
+			Height:  float32(ramp.Height),
+		}
+	}
+	return wire
+}
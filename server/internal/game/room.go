@@ -3,19 +3,22 @@ package game
 
 import (
 	"log"
-	"sync"
+	"slices"
+	"sort"
 	"sync/atomic"
 	"time"
 
 	"github.com/race/server/config"
 	"github.com/race/server/internal/network"
+	"github.com/race/server/internal/track"
 )
 
 // Room represents a game room where players race together.
 //
 // Each room has its own:
-// - Physics simulation running at 60Hz
-// - Network broadcast running at 20Hz
+// - Physics simulation running at 60Hz (see RoomConfig), ticked by a
+//   Scheduler shared with other rooms
+// - Network broadcast running at 20Hz, adjustable at runtime
 // - Anti-cheat validation
 // - Spatial partitioning for collision detection
 //
@@ -28,41 +31,185 @@ import (
 // hold the appropriate lock. This prevents deadlocks when calling
 // broadcast from within locked sections.
 type Room struct {
-	mu sync.RWMutex // Protects players map
+	mu orderedRWMutex // Protects players map (see lockorder.go)
 
 	ID           string             // Unique room identifier
 	players      map[uint16]*Player // Active players in this room
 	nextPlayerID uint16             // Auto-incrementing player ID
+	hosted       bool               // True if a player holds host privileges
+	hostID       uint16             // Current host (0 if none)
 
 	physics     *Physics      // Physics simulation engine
 	antiCheat   *AntiCheat    // Anti-cheat validation system
 	spatialGrid *SpatialGrid  // Spatial partitioning for collision detection
+	proximity   *SpatialGrid  // Neighbors for Nearby messages (see proximity.go)
 	protocol    *network.Protocol // Binary protocol encoder
 
+	physicsRate   int          // Physics ticks per second (fixed)
+	rebaseDistance float64     // See rebase.go (fixed)
+	broadcastRate atomic.Int32 // State broadcasts per second
+
 	tickCount uint64      // Physics tick counter
+	overruns  atomic.Uint64 // Physics ticks that took longer than the tick interval
 	running   atomic.Bool // True if game loop is running
-	stopChan  chan struct{} // Signal to stop game loop
+
+	// Game loop: run by a scheduler (see scheduler.go). loop is only touched
+	// by the tick in progress, clock only under the scheduler's lock.
+	scheduler atomic.Pointer[Scheduler]
+	clock     *roomClock
+	loop      loopState
+	scratch   tickScratch // Buffers reused by every tick, touched only by the tick in progress
+	frames    stateFrames // Player states published by the physics tick (see snapshot.go)
+	clips     clipBuffer  // Recent frames for highlight clips (see clip.go)
+
+	// Snapshot requests served by the next tick (see roomsnapshot.go)
+	snapshotWaiters []chan *RoomSnapshot
+	snapshotPending atomic.Bool // Set while snapshotWaiters isn't empty
+
+	// Profile changes applied by the next tick (see SetProfile)
+	profiles       map[uint16]profileChange
+	profilePending atomic.Bool // Set while profiles isn't empty
+
+	// Suspension: the game loop pauses while no humans are in the room
+	suspendWhenEmpty atomic.Bool
+	suspended        atomic.Bool
+	startSuspended   bool // Park the game loop on Start (set before Start)
+
+	// Input sequence checks (see sequence.go)
+	sequenceMode atomic.Int32
+	sequence     sequenceCounters
+
+	// Randomness of the simulation (see rng.go): the seed the room was
+	// created with, and the RNG under mu
+	seed uint64
+	rng  *RNG
+
+	// Road driven in this room, in the room's variant (see road.go)
+	road     *track.Track
+	variant  track.Variant
+	escalate bool
+
+	// Players may simulate network conditions (see RoomConfig.QA)
+	qa bool
+
+	// Relay teams under mu (nil unless this is a relay room, see relay.go)
+	relay *relayState
+
+	// Race of a race room, touched by the tick (nil unless this is a race
+	// room, see race.go)
+	race *raceState
+
+	// Practice mode (nil unless this is a practice room, see practice.go)
+	practice *practiceState
+
+	// Tutorial script (nil unless this is a tutorial room, see tutorial.go)
+	tutorial *tutorialState
+
+	// Evidence against flagged players (nil unless this is a honeypot room,
+	// see honeypot.go)
+	honeypot *honeypotState
+
+	// Turns finished runs into leaderboard scores (see scoring.go)
+	scoring Scorer
+
+	// Custom rules (see rules.go): state touched only by the tick, and the
+	// name for everyone else (nil without rules)
+	rules     rulesState
+	rulesName atomic.Pointer[string]
+
+	// Current round of a public room (see round.go)
+	round         roundState
+	roundEndAsked atomic.Bool // EndRound was called
+
+	// Spectators and the broadcast director (see director.go): spectators
+	// and the current Director message under mu, the director and the
+	// viewers' update count (see spectate.go) only touched by the tick
+	spectators    map[PlayerConnection]spectator
+	viewerUpdates uint64
+	shotMsg       []byte
+	director      directorState
+	delayed       spectatorDelay // See spectatordelay.go
+
+	// Admins following the room tick by tick (see inspect.go)
+	inspect    inspectState
+	inspecting atomic.Int32 // Number of inspectors
 
 	// Callbacks
 	onPlayerKick func(player *Player, reason string)
+	onRunEnd     func(player *Player, score float64) // A run ended (explosion or leave)
+	onRoundEnd   func(result RoundResult)             // A round ended (public rooms)
+	onPrestige   func(player *Player, score float64) // A run was reset for prestige (see milestones.go)
+
+	// Takes the players anti-cheat would kick (see honeypot.go)
+	onCheater func(player *Player, reason string) bool
+
+	// Frame-budget watchdog (see watchdog.go): the window touched only by
+	// the tick
+	watchdog     watchdogState
+	quarantined  atomic.Bool
+	onQuarantine func(report TickBudgetReport)
+
+	// Progress of the humans who left, by session, under mu (see rejoin.go)
+	departed map[string]departedPlayer
 }
 
-// NewRoom creates a new game room with the given ID.
+// NewRoom creates a new game room with the given ID and the default config.
 // The room is not started automatically - call Start() to begin the game loop.
 func NewRoom(id string) *Room {
-	return &Room{
+	return NewRoomWithConfig(id, DefaultRoomConfig())
+}
+
+// NewRoomWithConfig creates a new game room with custom rates.
+// The config must be valid (see RoomConfig.Validate).
+func NewRoomWithConfig(id string, cfg RoomConfig) *Room {
+	r := &Room{
+		mu:           orderedRWMutex{class: lockRoom},
 		ID:           id,
 		players:      make(map[uint16]*Player),
 		nextPlayerID: 1, // Player IDs start at 1 (0 could be used as "no player")
-		physics:      NewPhysics(),
-		antiCheat:    NewAntiCheat(),
+		variant:      cfg.trackVariant(),
+		escalate:     cfg.Escalate,
 		spatialGrid:  NewSpatialGrid(100), // 100 unit cells for spatial partitioning
+		proximity:    NewProximityGrid(config.NearbyRadius),
 		protocol:     network.NewProtocol(),
-		stopChan:     make(chan struct{}),
+		physicsRate:  cfg.PhysicsTickRate,
+		rebaseDistance: cfg.RebaseDistance,
+		scoring:      DefaultScoringPolicy(),
+	}
+	road := r.roomRoad(track.Default())
+	r.road = road
+	r.physics = NewPhysics(road)
+	r.antiCheat = NewAntiCheat(road)
+	r.physics.fuel = cfg.Fuel
+	r.physics.collisions, _ = ParseCollisionMode(cfg.Collisions)
+	r.spatialGrid.noPairs = r.physics.collisions == CollisionsOff
+	r.qa = cfg.QA
+	if cfg.Relay {
+		r.relay = &relayState{byPlayer: make(map[uint16]*relayTeam)}
 	}
+	if cfg.Race {
+		r.race = newRaceState()
+	}
+	r.seed = newSeed()
+	r.rng = NewRNG(r.seed)
+	r.broadcastRate.Store(int32(cfg.BroadcastRate))
+	r.sequenceMode.Store(int32(SequenceDrop))
+	r.delayed = spectatorDelay{
+		mu:    orderedMutex{class: lockSpectators},
+		delay: time.Duration(cfg.SpectatorDelay) * time.Second,
+	}
+	r.clips = newClipBuffer(r.physicsRate)
+	return r
+}
+
+// SetScheduler sets the scheduler that runs the room's game loop. Must be
+// called before Start; rooms started without one share a default scheduler
+// with a worker per CPU.
+func (r *Room) SetScheduler(s *Scheduler) {
+	r.scheduler.Store(s)
 }
 
-// Start begins the room's game loop in a separate goroutine.
+// Start begins the room's game loop on its scheduler.
 // Safe to call multiple times - subsequent calls are no-ops.
 func (r *Room) Start() {
 	// Atomic swap returns previous value - if it was true, room is already running
@@ -70,7 +217,10 @@ func (r *Room) Start() {
 		return
 	}
 
-	go r.gameLoop()
+	if r.scheduler.Load() == nil {
+		r.scheduler.Store(defaultScheduler())
+	}
+	r.scheduler.Load().add(r)
 	log.Printf("Room %s started", r.ID)
 }
 
@@ -82,7 +232,9 @@ func (r *Room) Stop() {
 		return
 	}
 
-	close(r.stopChan)
+	r.scheduler.Load().remove(r)
+	r.dismissSpectators()
+	r.closeInspectors()
 	log.Printf("Room %s stopped", r.ID)
 }
 
@@ -98,10 +250,36 @@ func (r *Room) AddPlayer(sessionID, name string, color uint8, conn PlayerConnect
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	return r.addPlayerUnlocked(sessionID, name, color, conn, nil)
+}
+
+// addPlayerUnlocked adds a human (bot == nil) or bot player.
+// IMPORTANT: Caller must hold the room write lock.
+func (r *Room) addPlayerUnlocked(sessionID, name string, color uint8, conn PlayerConnection, bot *botDriver) (*Player, error) {
 	// Check room capacity
 	if len(r.players) >= config.MaxPlayersPerRoom {
 		return nil, ErrRoomFull
 	}
+	if bot == nil && r.quarantined.Load() {
+		return nil, ErrQuarantined
+	}
+	if bot == nil && !r.Config().SupportsClient(conn.ProtocolVersion()) {
+		return nil, ErrClientUnsupported
+	}
+	if bot == nil && !r.supportsTrack(conn.ProtocolVersion()) {
+		return nil, ErrTrackUnsupported
+	}
+	if r.tutorial != nil && bot == nil && conn.ProtocolVersion() < network.ProtocolV6 {
+		return nil, ErrTutorialUnsupported
+	}
+	if r.practice != nil && bot == nil {
+		// Private: one human only
+		for _, p := range r.players {
+			if !p.IsBot() {
+				return nil, ErrRoomFull
+			}
+		}
+	}
 
 	// Assign unique player ID
 	id := r.nextPlayerID
@@ -109,13 +287,21 @@ func (r *Room) AddPlayer(sessionID, name string, color uint8, conn PlayerConnect
 
 	// Create player with initial state
 	player := NewPlayer(id, sessionID, name, color, conn)
+	player.bot = bot
 
-	// Position player at road center (Y=0 is the starting point)
-	player.X = config.GetRoadCurve(0)
+	// Position player at road center (Y=0 is the starting point, which moves
+	// with the world origin, see rebase.go)
+	player.X = r.road.Center(0)
 	player.Y = 0
+	// A returning player carries on where they left (see rejoin.go)
+	resumed := bot == nil && r.resumeUnlocked(player, time.Now())
 	player.SaveValidPosition() // Save for anti-cheat baseline
 
 	r.players[id] = player
+	if bot == nil {
+		r.assignHostUnlocked(id)
+		r.resume()
+	}
 
 	// Notify existing players about the new player
 	// Using unlocked version because we already hold the lock
@@ -123,18 +309,42 @@ func (r *Room) AddPlayer(sessionID, name string, color uint8, conn PlayerConnect
 	r.broadcastExceptUnlocked(joinMsg, id)
 
 	// Send room info to the new player (room ID, player count, their assigned ID)
-	roomInfo := r.protocol.EncodeRoomInfo(r.ID, uint8(len(r.players)), config.MaxPlayersPerRoom, id)
-	player.Connection.Send(roomInfo)
+	player.Connection.Send(r.roomInfoMessageUnlocked(conn.ProtocolVersion(), id))
+	if conn.ProtocolVersion() >= network.ProtocolV4 {
+		player.Connection.Send(r.tickRateMessage())
+	}
+	if r.sendsTrack() {
+		player.Connection.Send(r.trackMessage(conn.ProtocolVersion()))
+	}
+	if r.road.Origin() != 0 && conn.ProtocolVersion() >= network.ProtocolV9 {
+		player.Connection.Send(r.rebaseMessage())
+	}
 
 	// Send info about existing players to the new player
 	for existingID, existingPlayer := range r.players {
 		if existingID != id {
 			existingJoinMsg := r.protocol.EncodePlayerJoin(existingID, existingPlayer.Name, existingPlayer.Color)
 			player.Connection.Send(existingJoinMsg)
+			if existingPlayer.appearance != (Appearance{}) && conn.ProtocolVersion() >= network.ProtocolV15 {
+				player.Connection.Send(existingPlayer.appearanceMessage(r.protocol))
+			}
 		}
 	}
 
-	log.Printf("Player %s (ID: %d) joined room %s", name, id, r.ID)
+	// Tell the new player who the host is
+	if r.hosted {
+		player.Connection.Send(r.protocol.EncodeHostChange(r.hostID))
+	}
+
+	if r.relay != nil && bot == nil {
+		r.joinTeamUnlocked(player)
+	}
+
+	if resumed {
+		log.Printf("Player %s (ID: %d) rejoined room %s at Y=%.0f", name, id, r.ID, player.Y)
+	} else {
+		log.Printf("Player %s (ID: %d) joined room %s", name, id, r.ID)
+	}
 
 	return player, nil
 }
@@ -142,24 +352,71 @@ func (r *Room) AddPlayer(sessionID, name string, color uint8, conn PlayerConnect
 // RemovePlayer removes a player from the room and notifies others.
 // Safe to call with non-existent player IDs.
 func (r *Room) RemovePlayer(playerID uint16) {
+	r.removePlayer(playerID, true)
+}
+
+// HandOff takes a player out of the room for another one: their run ends as
+// if they left, but their connection stays open, and their client forgets
+// the room's cars before it hears from the next room. Returns nil if the
+// player isn't in the room.
+func (r *Room) HandOff(playerID uint16) *Player {
+	player := r.removePlayer(playerID, false)
+	if player == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for id := range r.players {
+		player.Connection.Send(r.protocol.EncodePlayerLeave(id))
+	}
+	return player
+}
+
+// removePlayer removes a player, closing their connection or leaving it
+// open for another room. Returns the player, or nil if they weren't in the
+// room.
+func (r *Room) removePlayer(playerID uint16, closeConn bool) *Player {
 	// Lock only for map modification
 	r.mu.Lock()
 	player, exists := r.players[playerID]
+	var hostChangeMsg []byte
 	if exists {
 		delete(r.players, playerID)
+		hostChangeMsg = r.migrateHostUnlocked(playerID)
+		if r.relay != nil {
+			r.leaveTeamUnlocked(player)
+		}
+		r.rememberUnlocked(player, time.Now()) // Before the run is reported
 	}
 	r.mu.Unlock()
 
 	if exists {
+		r.spatialGrid.Remove(player)
+		r.proximity.Remove(player)
+
+		// A departing player's pending and current runs both count
+		if run, ok := player.TakeFinishedRun(); ok {
+			r.reportRun(player, run)
+		}
+		r.reportRun(player, player.EndRun())
+
 		// Close connection (safe to do outside lock)
-		player.Connection.Close()
+		if closeConn {
+			player.Connection.Close()
+		}
 
 		// Notify remaining players
 		leaveMsg := r.protocol.EncodePlayerLeave(playerID)
 		r.broadcast(leaveMsg)
+		if hostChangeMsg != nil {
+			r.broadcast(hostChangeMsg)
+		}
 
-		log.Printf("Player %s (ID: %d) left room %s", player.Name, playerID, r.ID)
+		log.Printf("Player %s (ID: %d) left room %s", player.GetName(), playerID, r.ID)
+		return player
 	}
+	return nil
 }
 
 // HandleInput processes player control input.
@@ -173,6 +430,10 @@ func (r *Room) HandleInput(playerID uint16, input *network.InputMessage) {
 	if !exists {
 		return
 	}
+	// Anti-cheat: check the sequence number (detect replayed and injected input)
+	if !r.checkSequence(player, input.Sequence) {
+		return
+	}
 
 	// Anti-cheat: validate input rate (detect input flooding)
 	result := r.antiCheat.ValidateInputRate(player)
@@ -192,6 +453,12 @@ func (r *Room) HandleInput(playerID uint16, input *network.InputMessage) {
 		Flags:    input.Flags,
 	}
 
+	if player.parked.Load() {
+		return // Relay teammate without the baton (see relay.go)
+	}
+	if r.race != nil && r.race.countdown.Load() {
+		return // On the grid (see race.go)
+	}
 	player.ApplyInput(gameInput)
 }
 
@@ -202,121 +469,400 @@ func (r *Room) GetPlayerCount() int {
 	return len(r.players)
 }
 
-// IsEmpty returns true if the room has no players.
+// PlayerName returns the name of a player in the room.
+func (r *Room) PlayerName(playerID uint16) (string, error) {
+	r.mu.RLock()
+	p, exists := r.players[playerID]
+	r.mu.RUnlock()
+
+	if !exists {
+		return "", ErrPlayerNotFound
+	}
+	return p.GetName(), nil
+}
+
+// PlayerStates returns the state of every player, by ascending ID.
+func (r *Room) PlayerStates() []PlayerState {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	states := make([]PlayerState, 0, len(r.players))
+	for _, p := range r.players {
+		states = append(states, p.GetState())
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].ID < states[j].ID })
+	return states
+}
+
+// Tick returns the number of physics ticks the room has run.
+func (r *Room) Tick() uint64 {
+	return atomic.LoadUint64(&r.tickCount)
+}
+
+// IsEmpty returns true if the room has no human players.
+// Bots alone don't keep a room alive.
 func (r *Room) IsEmpty() bool {
-	return r.GetPlayerCount() == 0
-}
-
-// gameLoop is the main game loop running in its own goroutine.
-// It handles physics updates at 60Hz and network broadcasts at 20Hz.
-func (r *Room) gameLoop() {
-	// Physics runs at 60Hz (16.67ms per tick)
-	physicsTicker := time.NewTicker(time.Second / time.Duration(config.PhysicsTickRate))
-	// Network broadcasts at 20Hz (50ms per broadcast)
-	broadcastTicker := time.NewTicker(time.Second / time.Duration(config.NetworkBroadcastRate))
-	defer physicsTicker.Stop()
-	defer broadcastTicker.Stop()
-
-	lastPhysicsTime := time.Now()
-
-	for {
-		select {
-		case <-r.stopChan:
-			// Room is stopping
-			return
-
-		case now := <-physicsTicker.C:
-			// Calculate delta time since last physics update
-			dt := now.Sub(lastPhysicsTime).Seconds()
-			lastPhysicsTime = now
-
-			// Cap delta time to prevent physics explosions after pauses
-			if dt > 0.1 {
-				dt = 0.1
-			}
+	return r.HumanCount() == 0
+}
+
+// loopState is the timing of the game loop
+type loopState struct {
+	lastPhysics       time.Time
+	broadcastInterval time.Duration // At the last broadcast (the rate is adjustable)
+	lastBroadcast     time.Time
+	nextBroadcast     time.Time
+	nextScoreboard    time.Time
+	nextNearby        time.Time
+	nextDirector      time.Time
+}
 
-			r.updatePhysics(dt)
-			atomic.AddUint64(&r.tickCount, 1)
+// tickScratch holds the buffers the physics tick and the state broadcast
+// reuse from one tick to the next, so a room in steady state simulates
+// without allocating (hotpath_test.go audits this). Ticks of a room never
+// overlap, so they need no lock; nothing in them may outlive the tick.
+type tickScratch struct {
+	players  []*Player // Players of the physics tick
+	bots     []*Player
+	states   []PlayerState // What bots see (see driveBots)
+	contacts [][2]*Player
+	rams     [][2]*Player
+	pushed   map[*Player]bool
+
+	proximity []*Player // Players of the Nearby pass (see proximity.go)
+	nearby    []*Player
+	nearbyIDs []uint16
+
+	stateData []network.PlayerStateData // Records of the broadcast
+	records   []network.PlayerStateData // Delta records of one receiver
+	encoded   map[uint8][]byte          // Messages by record format, one broadcast
+}
+
+// releaseTick empties the physics tick's buffers, keeping their memory, so
+// they don't keep players who leave alive
+func (s *tickScratch) releaseTick() {
+	clear(s.players)
+	clear(s.bots)
+	clear(s.contacts)
+	clear(s.rams)
+	clear(s.pushed)
+}
+
+// snapshotPlayers copies the players into buf, reusing its backing array,
+// in the order of their IDs: collisions push the first car of a pair, so a
+// tick must not depend on the order of the map to be simulated again alike
+func (r *Room) snapshotPlayers(buf []*Player) []*Player {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	buf = buf[:0]
+	for _, p := range r.players {
+		buf = append(buf, p)
+	}
+	slices.SortFunc(buf, func(a, b *Player) int { return int(a.ID) - int(b.ID) })
+	return buf
+}
 
-		case <-broadcastTicker.C:
-			// Send state to all clients
-			r.broadcastState()
+// tick runs one iteration of the game loop: a physics tick at 60Hz by
+// default, then the state broadcast (20Hz by default), the latency
+// scoreboard, the Nearby messages and the director when they are due. The scheduler calls it when the tick is
+// due, never for two ticks of a room at once; restart is set on the first
+// tick after starting or resuming. Returns true if the room should be
+// suspended (nobody is watching).
+func (r *Room) tick(now time.Time, restart bool) bool {
+	tickInterval := time.Second / time.Duration(r.physicsRate)
+	if restart {
+		r.loop = loopState{
+			lastPhysics:    now.Add(-tickInterval),
+			lastBroadcast:  now,
+			nextScoreboard: now.Add(config.ScoreboardInterval),
+			nextNearby:     now,
+			nextDirector:   now,
 		}
 	}
+
+	// Calculate delta time since last physics update
+	dt := now.Sub(r.loop.lastPhysics).Seconds()
+	r.loop.lastPhysics = now
+
+	// Cap delta time to prevent physics explosions after pauses
+	if dt > 0.1 {
+		dt = 0.1
+	}
+
+	r.updatePhysics(dt)
+
+	// Broadcasts and scoreboards fall on physics ticks: a due time within
+	// half a tick counts as reached, or a 50ms interval would wait for the
+	// tick after 49.99ms. Admins can change the broadcast rate while the
+	// room runs; the next broadcast follows the new rate right away.
+	slack := tickInterval / 2
+	if interval := r.broadcastInterval(); interval != r.loop.broadcastInterval {
+		r.loop.broadcastInterval = interval
+		r.loop.nextBroadcast = r.loop.lastBroadcast.Add(interval)
+	}
+	if !now.Add(slack).Before(r.loop.nextBroadcast) {
+		// Send state to all clients, and to spectators what was held back
+		r.broadcastState()
+		r.releaseDelayed(now)
+		r.loop.lastBroadcast = now
+		r.loop.nextBroadcast = nextDue(r.loop.nextBroadcast, r.loop.broadcastInterval, now)
+	}
+	if !now.Add(slack).Before(r.loop.nextScoreboard) {
+		r.broadcastScoreboard()
+		r.recommendInterpDelays()
+		r.loop.nextScoreboard = nextDue(r.loop.nextScoreboard, config.ScoreboardInterval, now)
+	}
+	if !now.Add(slack).Before(r.loop.nextNearby) {
+		r.sendNearby()
+		r.loop.nextNearby = nextDue(r.loop.nextNearby, config.NearbyInterval, now)
+	}
+	if !now.Add(slack).Before(r.loop.nextDirector) {
+		r.directTick(now)
+		r.loop.nextDirector = nextDue(r.loop.nextDirector, config.DirectorInterval, now)
+	}
+
+	took := time.Since(now)
+	if took > tickInterval {
+		r.overruns.Add(1)
+	}
+	r.watchTick(took, tickInterval)
+
+	// Nobody is watching: pause until the next join
+	return r.suspendWhenEmpty.Load() && r.IsEmpty()
+}
+
+// nextDue returns the due time after due, or an interval from now if the
+// loop fell behind by more than an interval.
+func nextDue(due time.Time, interval time.Duration, now time.Time) time.Time {
+	due = due.Add(interval)
+	if due.Before(now) {
+		return now.Add(interval)
+	}
+	return due
+}
+
+// resume wakes a suspended game loop. A wake-up sent while the loop is
+// running is left pending, so a join racing with suspension is never lost;
+// the loop then just runs one more tick before checking again.
+func (r *Room) resume() {
+	if s := r.scheduler.Load(); s != nil {
+		s.resume(r)
+	}
+}
+
+// SetSuspendWhenEmpty sets whether the game loop pauses while the room has
+// no human players. Bots alone don't keep the simulation running.
+func (r *Room) SetSuspendWhenEmpty(suspend bool) {
+	r.suspendWhenEmpty.Store(suspend)
+}
+
+// Suspended reports whether the game loop is paused because the room is empty.
+func (r *Room) Suspended() bool {
+	return r.suspended.Load()
+}
+
+// Step advances a room that was not started by one physics tick of dt
+// seconds, broadcasting state if broadcast is set. Used for headless
+// simulation at accelerated time (soak tests).
+func (r *Room) Step(dt float64, broadcast bool) {
+	r.updatePhysics(dt)
+	if broadcast {
+		r.broadcastState()
+	}
+}
+
+// GridCells returns the number of occupied spatial grid cells.
+func (r *Room) GridCells() int {
+	return r.spatialGrid.Cells()
+}
+
+// Overruns returns the number of physics ticks that exceeded their interval.
+func (r *Room) Overruns() uint64 {
+	return r.overruns.Load()
 }
 
 // updatePhysics runs one physics tick for all players.
 // This includes movement, collision detection, and anti-cheat validation.
 func (r *Room) updatePhysics(dt float64) {
+	r.applyProfiles()
+
 	// Get snapshot of players (minimize lock time)
-	r.mu.RLock()
-	players := make([]*Player, 0, len(r.players))
-	for _, p := range r.players {
-		players = append(players, p)
-	}
-	r.mu.RUnlock()
+	scratch := &r.scratch
+	scratch.players = r.snapshotPlayers(scratch.players)
+	players := scratch.players
+	defer scratch.releaseTick()
 
 	// Reset input counts for anti-cheat rate limiting
 	for _, p := range players {
 		p.ResetInputCount()
 	}
 
+	// Bots decide their input for this tick
+	r.driveBots(players, dt)
+
 	// Update physics for each player (movement, road boundaries, etc.)
 	for _, p := range players {
-		r.physics.UpdatePlayer(p, dt)
+		p.beginTick()
+		if !p.replay && !p.parked.Load() {
+			r.physics.UpdatePlayer(p, dt)
+		}
 	}
 
 	// Update spatial grid for efficient collision detection
 	r.spatialGrid.Update(players)
 
-	// Check collisions between nearby players
+	// Check collisions between nearby players, noting who rammed whom
+	now := time.Now()
+	rams, contacts := scratch.rams[:0], scratch.contacts[:0]
 	pairs := r.spatialGrid.GetPotentialCollisions()
 	for _, pair := range pairs {
-		r.physics.CheckCollision(pair[0], pair[1], dt)
+		impact, hit := r.physics.CheckCollision(pair[0], pair[1], dt)
+		if !hit {
+			continue
+		}
+		contacts = append(contacts, pair)
+		r.announceImpact(pair[0], pair[1], impact, now)
+		if p := rammer(pair[0], pair[1], now); p == pair[0] {
+			rams = append(rams, pair)
+		} else if p == pair[1] {
+			rams = append(rams, [2]*Player{pair[1], pair[0]})
+		}
 	}
+	r.penalizeRams(rams, now)
+	scratch.rams, scratch.contacts = rams, contacts
 
-	// Anti-cheat validation for all players
+	// Practice rooms record the attempt and move the replay car
+	if r.practice != nil {
+		r.practiceTick(players)
+	}
+	if r.tutorial != nil {
+		r.tutorialTick(players, dt)
+	}
+
+	// Custom rules react to the tick's joins, collisions and explosions
+	r.rulesTick(players, contacts, dt)
+
+	// Velocity hints for client extrapolation, before anti-cheat corrections
+	// so a rubberband doesn't show up as a burst of speed
 	for _, p := range players {
-		// Check for speed hacks
-		result := r.antiCheat.ValidatePlayerMovement(p, dt)
+		p.endTick(dt)
+	}
+
+	// Anti-cheat validation for all players. Collisions push cars sideways,
+	// so cars in contact skip the steering check.
+	if scratch.pushed == nil {
+		scratch.pushed = make(map[*Player]bool)
+	}
+	pushed := scratch.pushed
+	for _, pair := range contacts {
+		pushed[pair[0]], pushed[pair[1]] = true, true
+	}
+	for _, p := range players {
+		if p.replay || p.parked.Load() {
+			continue // Placed by the room, not driven
+		}
+
+		// Check for speed and steering hacks. Practice and honeypot rooms
+		// never kick.
+		result, reason := r.antiCheat.ValidatePlayerMovement(p, dt), "Speed hack detected"
+		if result == ValidationValid && !pushed[p] {
+			result, reason = r.antiCheat.ValidateSteering(p, dt), "Steering hack detected"
+		}
+		if result == ValidationValid {
+			var rule string
+			if result, rule = r.antiCheat.ValidateRules(p, pushed[p], dt); result != ValidationValid {
+				reason = "Cheat detected (" + rule + ")"
+			}
+		}
+		r.noteEvidence(p, result, reason)
+		if result == ValidationKick && (r.practice != nil || r.honeypot != nil) {
+			result = ValidationRubberband
+		}
+		r.noteVerdict(p, result, reason)
 		if result == ValidationKick {
-			r.kickPlayer(p, "Speed hack detected")
+			r.removeCheater(p, reason)
 			continue
 		}
 		r.antiCheat.ApplyValidationResult(p, result)
 
 		// Check for position hacks (teleporting)
 		result = r.antiCheat.ValidatePosition(p)
+		r.noteEvidence(p, result, "Position hack detected")
+		r.noteVerdict(p, result, "Position hack detected")
 		r.antiCheat.ApplyValidationResult(p, result)
 	}
 
+	// Relay teams drive their legs and pass the baton
+	if r.relay != nil {
+		r.relayTick()
+	}
+
+	// Race rooms count checkpoints and finishes
+	if r.race != nil {
+		r.raceTick(players, dt)
+	}
+
+	// Lanes of the cars where anti-cheat left them
+	r.updateLanes(players)
+
+	// Sample the room's difficulty for the runs in progress and keep the
+	// round's stats
+	if r.practice == nil {
+		r.scoreTick(players, dt)
+		r.milestoneTick(players)
+		r.roundTick(players, pairs, contacts, dt)
+	}
+
+	// Report runs that ended in an explosion this tick
+	for _, p := range players {
+		if run, ok := p.TakeFinishedRun(); ok {
+			r.reportRun(p, run)
+		}
+	}
+
 	// Check for auto-respawn
 	for _, p := range players {
 		if p.ShouldRespawn() {
-			p.Respawn()
+			p.Respawn(r.road)
 		}
 	}
+
+	// Move the world origin once everyone is far down the road
+	r.rebaseTick()
+
+	// The tick's last change is made: publish the state for broadcasts
+	r.publishFrame(atomic.AddUint64(&r.tickCount, 1))
+	r.serveSnapshots()
+	r.inspectTick(dt)
 }
 
-// broadcastState sends the current game state to all players.
-// State includes position, speed, angle, and other player data.
-func (r *Room) broadcastState() {
-	// Get snapshot of players
-	r.mu.RLock()
-	players := make([]*Player, 0, len(r.players))
-	for _, p := range r.players {
-		players = append(players, p)
+// reportRun passes the score of a finished run (see scoring.go) to the run
+// callback. Practice runs are never reported.
+func (r *Room) reportRun(p *Player, run Run) {
+	if run.Rating > 0 && !p.IsBot() && r.practice == nil && r.onRunEnd != nil {
+		r.mu.RLock()
+		policy := r.scoring
+		r.mu.RUnlock()
+		r.onRunEnd(p, policy.Score(run))
 	}
-	r.mu.RUnlock()
+}
 
+// broadcastState sends the state of the latest physics tick (see
+// snapshot.go) to all players. State includes position, speed, angle, and
+// other player data.
+func (r *Room) broadcastState() {
+	frame := r.frames.published()
+	players := frame.players
 	if len(players) == 0 {
 		return
 	}
 
 	// Build state data array
-	stateData := make([]network.PlayerStateData, len(players))
-	for i, p := range players {
-		state := p.GetState()
+	scratch := &r.scratch
+	stateData := slices.Grow(scratch.stateData[:0], len(players))[:len(players)]
+	scratch.stateData = stateData
+	for i := range frame.states {
+		state := &frame.states[i]
 		stateData[i] = network.ConvertToPlayerStateData(
 			state.ID,
 			state.X,
@@ -327,13 +873,58 @@ func (r *Room) broadcastState() {
 			state.Exploded,
 			state.Color,
 		)
+		stateData[i].VelX = network.ScaleVelocity(state.VelX)
+		stateData[i].VelY = network.ScaleVelocity(state.VelY)
+		stateData[i].Lane = uint8(state.Lane)
+		if state.Ghost {
+			stateData[i].Flags |= network.FlagGhost
+		}
+		if state.Airborne {
+			stateData[i].Flags |= network.FlagAirborne
+		}
 	}
 
-	// Encode and broadcast
-	tick := uint16(atomic.LoadUint64(&r.tickCount) & 0xFFFF)
-	msg := r.protocol.EncodeStateUpdate(tick, stateData)
+	// Encode once per record format in use and send each player its own.
+	// Dead reckoning receivers get a message of their own.
+	tickCount := frame.tick
+	tick := uint16(tickCount & 0xFFFF)
+	if scratch.encoded == nil {
+		scratch.encoded = make(map[uint8][]byte, 2)
+	}
+	encoded := scratch.encoded
+	defer clear(encoded)
 
-	r.broadcast(msg)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, p := range r.players {
+		if p.IsBot() {
+			continue
+		}
+		version := p.Connection.ProtocolVersion()
+		if version >= network.ProtocolV3 {
+			records := r.deltaRecordsUnlocked(scratch.records[:0], p, players, stateData, tickCount)
+			scratch.records = records
+			msg := r.protocol.EncodeStateUpdateOwn(version, tick, int64(frame.origin), records, r.ownState(frame, p))
+			if err := p.Connection.Send(msg); err != nil {
+				log.Printf("Failed to send to player %d: %v", p.ID, err)
+			}
+			continue
+		}
+		msg, ok := encoded[version]
+		if !ok {
+			msg = r.protocol.EncodeStateUpdateVersion(version, tick, stateData)
+			encoded[version] = msg
+		}
+		if err := p.Connection.Send(msg); err != nil {
+			log.Printf("Failed to send to player %d: %v", p.ID, err)
+		}
+	}
+
+	// Spectators (ProtocolV16) get every record of every update
+	if len(r.spectators) > 0 {
+		r.spectateStateUnlocked(tick, int64(frame.origin), stateData, encoded)
+	}
 }
 
 // broadcast sends a message to all players in the room.
@@ -344,7 +935,7 @@ func (r *Room) broadcast(data []byte) {
 	r.broadcastUnlocked(data)
 }
 
-// broadcastUnlocked sends a message to all players.
+// broadcastUnlocked sends a message to all players and spectators.
 // IMPORTANT: Caller must hold the room lock (read or write).
 func (r *Room) broadcastUnlocked(data []byte) {
 	for _, p := range r.players {
@@ -353,6 +944,21 @@ func (r *Room) broadcastUnlocked(data []byte) {
 			log.Printf("Failed to send to player %d: %v", p.ID, err)
 		}
 	}
+	r.spectateUnlocked(data)
+}
+
+// broadcastSince sends a message to the players of at least a protocol
+// version, and to the spectators.
+func (r *Room) broadcastSince(version uint8, data []byte) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, p := range r.players {
+		if p.Connection.ProtocolVersion() >= version {
+			p.Connection.Send(data)
+		}
+	}
+	r.spectateUnlocked(data)
 }
 
 // broadcastExcept sends a message to all players except one.
@@ -363,7 +969,8 @@ func (r *Room) broadcastExcept(data []byte, exceptID uint16) {
 	r.broadcastExceptUnlocked(data, exceptID)
 }
 
-// broadcastExceptUnlocked sends a message to all players except one.
+// broadcastExceptUnlocked sends a message to all players except one, and
+// to the spectators.
 // IMPORTANT: Caller must hold the room lock (read or write).
 func (r *Room) broadcastExceptUnlocked(data []byte, exceptID uint16) {
 	for id, p := range r.players {
@@ -374,18 +981,31 @@ func (r *Room) broadcastExceptUnlocked(data []byte, exceptID uint16) {
 			log.Printf("Failed to send to player %d: %v", p.ID, err)
 		}
 	}
+	r.spectateUnlocked(data)
 }
 
 // kickPlayer removes a player from the room due to anti-cheat violation.
 func (r *Room) kickPlayer(p *Player, reason string) {
-	log.Printf("Kicking player %s (ID: %d): %s", p.Name, p.ID, reason)
+	// Already kicked for something else this tick
+	r.mu.RLock()
+	inRoom := r.players[p.ID] == p
+	r.mu.RUnlock()
+	if !inRoom {
+		return
+	}
+
+	log.Printf("Kicking player %s (ID: %d): %s", p.GetName(), p.ID, reason)
 
 	// Send error message to player
 	errMsg := r.protocol.EncodeError(network.ErrorCodeKicked, reason)
 	p.Connection.Send(errMsg)
 
-	// Remove from room
+	// A cheater's run never reaches the leaderboard
+	p.ForfeitRun()
+
+	// Remove from room, for good
 	r.RemovePlayer(p.ID)
+	r.forget(p.SessionID)
 
 	// Trigger callback if set
 	if r.onPlayerKick != nil {
@@ -393,14 +1013,143 @@ func (r *Room) kickPlayer(p *Player, reason string) {
 	}
 }
 
+// Kick removes a player the way an anti-cheat kick does: the player is told
+// the reason and the kick callback runs.
+func (r *Room) Kick(playerID uint16, reason string) error {
+	r.mu.RLock()
+	p, exists := r.players[playerID]
+	r.mu.RUnlock()
+	if !exists || p.IsBot() {
+		return ErrPlayerNotFound
+	}
+	r.kickPlayer(p, reason)
+	return nil
+}
+
+// Explode blows up a player's car as a crash would; it respawns after
+// config.RespawnDelay.
+func (r *Room) Explode(playerID uint16) error {
+	r.mu.RLock()
+	p, exists := r.players[playerID]
+	r.mu.RUnlock()
+	if !exists || p.replay {
+		return ErrPlayerNotFound
+	}
+	p.Explode()
+	return nil
+}
+
+// profileChange is a pending change of a player's name or color
+type profileChange struct {
+	name    string // "" keeps the name
+	color   uint8
+	recolor bool
+}
+
+// Rename changes a human player's name, for a guest who linked an account.
+// The tick reads names without locks, so the next tick applies it; everyone
+// in the room learns the new name from a PlayerJoin for the car.
+func (r *Room) Rename(playerID uint16, name string) error {
+	return r.changeProfile(playerID, func(c *profileChange) {
+		c.name = name
+	})
+}
+
+// SetProfile changes a human player's name and color while they play, as
+// Rename does.
+func (r *Room) SetProfile(playerID uint16, name string, color uint8) error {
+	return r.changeProfile(playerID, func(c *profileChange) {
+		c.name, c.color, c.recolor = name, color, true
+	})
+}
+
+// changeProfile queues a change of a human player's profile for the tick
+func (r *Room) changeProfile(playerID uint16, change func(c *profileChange)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p, exists := r.players[playerID]
+	if !exists || p.IsBot() {
+		return ErrPlayerNotFound
+	}
+	if r.profiles == nil {
+		r.profiles = make(map[uint16]profileChange)
+	}
+	c := r.profiles[playerID]
+	change(&c)
+	r.profiles[playerID] = c
+	r.profilePending.Store(true)
+	return nil
+}
+
+// applyProfiles changes the players of Rename and SetProfile calls, the
+// round stats included. Called by the physics tick first.
+func (r *Room) applyProfiles() {
+	if !r.profilePending.Load() {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, c := range r.profiles {
+		p, exists := r.players[id]
+		if !exists {
+			continue
+		}
+		p.mu.Lock()
+		old := p.Name
+		if c.name != "" {
+			p.Name = c.name
+		}
+		if c.recolor {
+			p.Color = c.color
+		}
+		name, color := p.Name, p.Color
+		p.mu.Unlock()
+		if st := r.round.stats[id]; st != nil {
+			st.Name = name
+		}
+
+		r.broadcastUnlocked(r.protocol.EncodePlayerJoin(id, name, color))
+		log.Printf("Player %s (ID: %d) in room %s is now %s (color %d)", old, id, r.ID, name, color)
+	}
+	r.profiles = nil
+	r.profilePending.Store(false)
+}
+
 // SetOnPlayerKick sets a callback function called when a player is kicked.
 func (r *Room) SetOnPlayerKick(callback func(player *Player, reason string)) {
 	r.onPlayerKick = callback
 }
 
+// SetOnRunEnd sets a callback function called with the rating of every
+// finished run (explosion or leaving the room).
+func (r *Room) SetOnRunEnd(callback func(player *Player, score float64)) {
+	r.onRunEnd = callback
+}
+
 // Error definitions
 var (
-	ErrRoomFull = &RoomError{message: "room is full"}
+	ErrRoomFull       = &RoomError{message: "room is full"}
+	ErrNotHost        = &RoomError{message: "only the host can do that"}
+	ErrPlayerNotFound = &RoomError{message: "player not found"}
+
+	ErrClientUnsupported   = &RoomError{message: "client does not support this room's tick rate or fuel mode"}
+	ErrNotPractice         = &RoomError{message: "not a practice room"}
+	ErrNoRounds            = &RoomError{message: "practice rooms have no rounds"}
+	ErrNotEndless          = &RoomError{message: "not an endless room"}
+	ErrPrestigeTooEarly    = &RoomError{message: "run not rated high enough for prestige"}
+	ErrNotQA               = &RoomError{message: "not a QA room"}
+	ErrTutorialUnsupported = &RoomError{message: "client does not support tutorials"}
+	ErrTrackUnsupported    = &RoomError{message: "client does not support custom tracks"}
+	ErrVoiceUnsupported    = &RoomError{message: "player does not support voice chat"}
+	ErrMuted               = &RoomError{message: "player muted"}
+	ErrSpectateUnsupported = &RoomError{message: "client does not support spectating"}
+	ErrSpectatorsFull      = &RoomError{message: "room has too many spectators"}
+	ErrNotWatchable        = &RoomError{message: "room can't be watched"}
+	ErrQuarantined         = &RoomError{message: "room is quarantined"}
+	ErrClipEmpty           = &RoomError{message: "nothing to clip yet"}
+	ErrNotRace             = &RoomError{message: "not a race room"}
 )
 
 // RoomError represents an error related to room operations.
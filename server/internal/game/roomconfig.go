@@ -0,0 +1,215 @@
+package game
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/network"
+	"github.com/race/server/internal/track"
+)
+
+// RoomConfig holds a room's simulation settings
+type RoomConfig struct {
+	PhysicsTickRate int `json:"physicsTickRate"` // Hz, fixed for the life of the room
+	BroadcastRate   int `json:"broadcastRate"`   // Hz, adjustable while the room runs
+
+	// Distance every car must be down the road before the world origin moves
+	// forward by it (see rebase.go); 0 never rebases. Fixed for the life of
+	// the room.
+	RebaseDistance float64 `json:"rebaseDistance"`
+
+	// Custom rules of public rooms, a script named by its file name in
+	// RULES_DIR (see rules.go); "" for the plain race. Fixed for the life of
+	// the room.
+	Rules string `json:"rules,omitempty"`
+
+	// Seconds spectators see the room behind the racers (see
+	// spectatordelay.go); 0 for live. Fixed for the life of the room.
+	SpectatorDelay int `json:"spectatorDelay,omitempty"`
+
+	// Throttle burns fuel, refilled in pit zones (see fuel.go). Fixed for
+	// the life of the room.
+	Fuel bool `json:"fuel,omitempty"`
+
+	// Teams of humans take turns driving, scored on their combined distance
+	// (see relay.go). Fixed for the life of the room.
+	Relay bool `json:"relay,omitempty"`
+
+	// Humans race laps from a grid to a finish (see race.go); not with
+	// Relay. Fixed for the life of the room.
+	Race bool `json:"race,omitempty"`
+
+	// How the cars collide (see collisionmode.go): "on" (""), "soft" or
+	// "off". Fixed for the life of the room.
+	Collisions string `json:"collisions,omitempty"`
+
+	// Variant of the room's road (see road.go): its bends mirrored left to
+	// right, and night, which clients draw with less of the road ahead.
+	// Fixed for the life of the room.
+	Mirror bool `json:"mirror,omitempty"`
+	Night  bool `json:"night,omitempty"`
+
+	// The road's bends swing wider the further down it (see road.go), for
+	// casual rooms where a race should get harder the longer a car
+	// survives. Fixed for the life of the room.
+	Escalate bool `json:"escalate,omitempty"`
+
+	// QA room: players may have the server simulate poor network conditions
+	// on their own connection, to compare fairness mechanisms. Fixed for the
+	// life of the room.
+	QA bool `json:"qa,omitempty"`
+}
+
+// Tick rate bounds. The wire format carries rates as one byte.
+const (
+	MinPhysicsTickRate = 10
+	MaxPhysicsTickRate = 240
+	MinBroadcastRate   = 1
+)
+
+// DefaultRoomConfig returns the standard rates, which every client supports
+func DefaultRoomConfig() RoomConfig {
+	return RoomConfig{
+		PhysicsTickRate: config.PhysicsTickRate,
+		BroadcastRate:   config.NetworkBroadcastRate,
+		RebaseDistance:  config.WorldRebaseDistance,
+	}
+}
+
+// Validate checks the rates are within bounds
+func (c RoomConfig) Validate() error {
+	if c.PhysicsTickRate < MinPhysicsTickRate || c.PhysicsTickRate > MaxPhysicsTickRate {
+		return fmt.Errorf("physics tick rate must be %d-%d Hz", MinPhysicsTickRate, MaxPhysicsTickRate)
+	}
+	// Whole sectors, so round sector times carry over a rebase
+	if c.RebaseDistance < 0 || math.Mod(c.RebaseDistance, config.RoundSectorLength) != 0 {
+		return fmt.Errorf("rebase distance must be 0 or a multiple of %.0f", config.RoundSectorLength)
+	}
+	if c.SpectatorDelay < 0 || time.Duration(c.SpectatorDelay)*time.Second > config.MaxSpectatorDelay {
+		return fmt.Errorf("spectator delay must be 0-%.0f seconds", config.MaxSpectatorDelay.Seconds())
+	}
+	if c.Race && c.Relay {
+		return fmt.Errorf("a room can't be both a race and a relay")
+	}
+	if _, err := ParseCollisionMode(c.Collisions); err != nil {
+		return err
+	}
+	return validateBroadcastRate(c.BroadcastRate, c.PhysicsTickRate)
+}
+
+func validateBroadcastRate(rate, physicsRate int) error {
+	if rate < MinBroadcastRate || rate > physicsRate {
+		return fmt.Errorf("broadcast rate must be %d-%d Hz", MinBroadcastRate, physicsRate)
+	}
+	return nil
+}
+
+// SupportsClient reports whether a client speaking the given protocol
+// version can play in a room with this config. Clients before ProtocolV4
+// don't learn the room's rates and predict at the standard physics rate;
+// any broadcast rate works for them. Clients before ProtocolV20 don't learn
+// their fuel and can't play in fuel rooms, nor clients before ProtocolV31
+// in relay rooms, as they don't learn when they hold the baton, nor
+// clients before ProtocolV34 in race rooms, as they don't learn when the
+// race starts, nor clients before ProtocolV37 in rooms with soft or no
+// collisions, as they'd predict the usual pushes.
+func (c RoomConfig) SupportsClient(version uint8) bool {
+	if c.Fuel && version < network.ProtocolV20 {
+		return false
+	}
+	if c.Relay && version < network.ProtocolV31 {
+		return false
+	}
+	if c.Race && version < network.ProtocolV34 {
+		return false
+	}
+	if mode, _ := ParseCollisionMode(c.Collisions); mode != CollisionsOn && version < network.ProtocolV37 {
+		return false
+	}
+	return version >= network.ProtocolV4 || c.PhysicsTickRate == config.PhysicsTickRate
+}
+
+// Config returns the room's current settings
+func (r *Room) Config() RoomConfig {
+	return RoomConfig{
+		PhysicsTickRate: r.physicsRate,
+		BroadcastRate:   int(r.broadcastRate.Load()),
+		RebaseDistance:  r.rebaseDistance,
+		Rules:           r.Rules(),
+		SpectatorDelay:  int(r.delayed.delay / time.Second),
+		Fuel:            r.physics.fuel,
+		Relay:           r.relay != nil,
+		Race:            r.race != nil,
+		Collisions:      r.physics.collisions.String(),
+		Mirror:          r.variant&track.Mirror != 0,
+		Night:           r.variant&track.Night != 0,
+		Escalate:        r.escalate,
+		QA:              r.qa,
+	}
+}
+
+// QA reports whether players of the room may simulate network conditions
+func (r *Room) QA() bool {
+	return r.qa
+}
+
+// trackVariant returns the variant of the road of rooms with this config
+func (c RoomConfig) trackVariant() track.Variant {
+	var v track.Variant
+	if c.Mirror {
+		v |= track.Mirror
+	}
+	if c.Night {
+		v |= track.Night
+	}
+	return v
+}
+
+// SetBroadcastRate changes the state broadcast rate of a running room,
+// e.g. to shed bandwidth on a congested server. ProtocolV4 clients are told
+// the new rate.
+func (r *Room) SetBroadcastRate(rate int) error {
+	if err := validateBroadcastRate(rate, r.physicsRate); err != nil {
+		return err
+	}
+	if int(r.broadcastRate.Swap(int32(rate))) == rate {
+		return nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	msg := r.tickRateMessage()
+	for _, p := range r.players {
+		if p.Connection.ProtocolVersion() >= network.ProtocolV4 {
+			p.Connection.Send(msg)
+		}
+	}
+	r.spectateUnlocked(msg)
+	return nil
+}
+
+// broadcastInterval returns the time between state broadcasts
+func (r *Room) broadcastInterval() time.Duration {
+	return time.Second / time.Duration(r.broadcastRate.Load())
+}
+
+// roomInfoMessage encodes the room's info for a connection speaking the
+// given protocol version, as the player yourID (0: a spectator).
+// IMPORTANT: Caller must hold the room lock (read or write).
+func (r *Room) roomInfoMessageUnlocked(version uint8, yourID uint16) []byte {
+	return r.protocol.EncodeRoomInfoVersion(version, network.RoomInfoMessage{
+		RoomID:       r.ID,
+		PlayerCount:  uint8(len(r.players)),
+		MaxPlayers:   config.MaxPlayersPerRoom,
+		YourPlayerID: yourID,
+		Collisions:   uint8(r.physics.collisions),
+	})
+}
+
+// tickRateMessage encodes the room's current rates
+func (r *Room) tickRateMessage() []byte {
+	return r.protocol.EncodeTickRate(uint8(r.physicsRate), uint8(r.broadcastRate.Load()))
+}
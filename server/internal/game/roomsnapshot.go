@@ -0,0 +1,363 @@
+package game
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/track"
+)
+
+// Room snapshots
+//
+// A snapshot is a room's full simulation state as JSON: config, road, tick
+// and every car with its position, motion, input, anti-cheat baseline,
+// ghosts and run. Support takes one from a production room where a bug was
+// reported and restores it into a fresh room elsewhere to watch the same
+// situation play out. A running room is captured at the end of a physics
+// tick, so no car is caught halfway through one.
+//
+// Restored humans are stand-ins that hold their last input. They count as
+// bots, so a restored room starts suspended, holding still until someone
+// joins to watch, and it is cleaned up once they leave. An exploded car's
+// respawn delay runs on the wall clock, also while the room holds still. The
+// room's RNG and every bot's pick up where they were (see rng.go), so bots
+// draw what the original's would have; snapshots without them start new
+// ones. A practice room's attempts and replay car, a round's awards so
+// far and the host aren't restored.
+
+// RoomSnapshotVersion is the format of RoomSnapshot
+const RoomSnapshotVersion = 1
+
+// RoomSnapshot is the state of a room at the end of a physics tick
+type RoomSnapshot struct {
+	Version  int              `json:"version"`
+	RoomID   string           `json:"roomId"`
+	Taken    time.Time        `json:"taken"`
+	Tick     uint64           `json:"tick"`
+	Config   RoomConfig       `json:"config"`
+	Track    *track.Track     `json:"track"`
+	Origin   float64          `json:"origin"` // Road distance of Y=0 (see rebase.go)
+	Practice bool             `json:"practice,omitempty"`
+	Tutorial bool             `json:"tutorial,omitempty"`
+	Hosted   bool             `json:"hosted,omitempty"`
+	HostID   uint16           `json:"hostId,omitempty"`
+	Round    int              `json:"round"`
+	RoundAge float64          `json:"roundAge"` // Physics seconds into the round
+	Players  []PlayerSnapshot `json:"players"`
+
+	// The room's RNG: its seed and its state (FormatSeed)
+	Seed string `json:"seed,omitempty"`
+	RNG  string `json:"rng,omitempty"`
+}
+
+// PlayerSnapshot is the state of a car in a RoomSnapshot
+type PlayerSnapshot struct {
+	ID       uint16      `json:"id"`
+	Name     string      `json:"name"`
+	Color    uint8       `json:"color"`
+	Bot      *BotProfile `json:"bot,omitempty"`      // Nil for humans
+	Protocol uint8       `json:"protocol,omitempty"` // Humans' negotiated protocol version
+	StandIn  bool        `json:"standIn,omitempty"`  // A human of a restored room
+	RNG      string      `json:"rng,omitempty"`      // A bot's RNG state (FormatSeed)
+
+	X        float64 `json:"x"`
+	Y        float64 `json:"y"`
+	Speed    float64 `json:"speed"`
+	Angle    float64 `json:"angle"`
+	Rating   float64 `json:"rating"`
+	Exploded bool    `json:"exploded,omitempty"`
+	// Seconds since the explosion, which times the respawn
+	ExplodedFor float64 `json:"explodedFor,omitempty"`
+	VelX        float64 `json:"velX"`
+	VelY        float64 `json:"velY"`
+
+	Input PlayerInput `json:"input"`
+
+	LastValidX float64 `json:"lastValidX"`
+	LastValidY float64 `json:"lastValidY"`
+	Violations int     `json:"violations,omitempty"`
+
+	// Ghost reasons and the seconds left (0: until cleared)
+	Ghosts map[string]float64 `json:"ghosts,omitempty"`
+
+	Skill         float64 `json:"skill,omitempty"`
+	Calibrating   bool    `json:"calibrating,omitempty"`
+	RunDifficulty float64 `json:"runDifficulty,omitempty"`
+	RunTime       float64 `json:"runTime,omitempty"`
+}
+
+// Snapshot captures the room's state. A running room is captured by its
+// next physics tick, at the end of it; a room that isn't ticking (stopped,
+// suspended, or not answering within config.SnapshotWait) is captured
+// right away.
+func (r *Room) Snapshot() *RoomSnapshot {
+	reply := make(chan *RoomSnapshot, 1)
+
+	r.mu.Lock()
+	ticking := r.running.Load() && !r.suspended.Load()
+	if ticking {
+		r.snapshotWaiters = append(r.snapshotWaiters, reply)
+		r.snapshotPending.Store(true)
+	}
+	r.mu.Unlock()
+
+	if ticking {
+		select {
+		case snap := <-reply:
+			return snap
+		case <-time.After(config.SnapshotWait):
+			// Left over in snapshotWaiters, the reply channel is buffered
+		}
+	}
+	return r.captureSnapshot()
+}
+
+// serveSnapshots answers the snapshot requests waiting for a tick. Called
+// by the physics tick at the end.
+func (r *Room) serveSnapshots() {
+	if !r.snapshotPending.Load() {
+		return
+	}
+	r.mu.Lock()
+	waiters := r.snapshotWaiters
+	r.snapshotWaiters = nil
+	r.snapshotPending.Store(false)
+	r.mu.Unlock()
+
+	snap := r.captureSnapshot()
+	for _, reply := range waiters {
+		reply <- snap
+	}
+}
+
+// captureSnapshot reads the room's state
+func (r *Room) captureSnapshot() *RoomSnapshot {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	snap := &RoomSnapshot{
+		Version:  RoomSnapshotVersion,
+		RoomID:   r.ID,
+		Taken:    now.UTC(),
+		Tick:     atomic.LoadUint64(&r.tickCount),
+		Config:   r.Config(),
+		Track:    r.road,
+		Origin:   r.road.Origin(),
+		Practice: r.practice != nil,
+		Tutorial: r.tutorial != nil,
+		Hosted:   r.hosted,
+		HostID:   r.hostID,
+		Round:    r.round.number,
+		RoundAge: r.round.elapsed,
+		Players:  make([]PlayerSnapshot, 0, len(r.players)),
+		Seed:     FormatSeed(r.seed),
+		RNG:      FormatSeed(r.rng.State()),
+	}
+	for _, p := range r.players {
+		if p.replay {
+			continue // Rebuilt from the best attempt, which isn't captured
+		}
+		snap.Players = append(snap.Players, p.snapshot(now))
+	}
+	sort.Slice(snap.Players, func(i, j int) bool { return snap.Players[i].ID < snap.Players[j].ID })
+	return snap
+}
+
+// snapshot captures a player's state
+func (p *Player) snapshot(now time.Time) PlayerSnapshot {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	ps := PlayerSnapshot{
+		ID:            p.ID,
+		Name:          p.Name,
+		Color:         p.Color,
+		X:             p.X,
+		Y:             p.Y,
+		Speed:         p.Speed,
+		Angle:         p.Angle,
+		Rating:        p.Rating,
+		Exploded:      p.Exploded,
+		VelX:          p.VelX,
+		VelY:          p.VelY,
+		Input:         p.CurrentInput,
+		LastValidX:    p.LastValidX,
+		LastValidY:    p.LastValidY,
+		Violations:    p.Violations,
+		Skill:         p.skill,
+		Calibrating:   p.calibrating,
+		RunDifficulty: p.runDifficulty,
+		RunTime:       p.runTime,
+	}
+	switch {
+	case p.bot != nil:
+		profile := p.bot.profile
+		ps.Bot = &profile
+		ps.RNG = FormatSeed(p.bot.rng.State())
+	case p.standIn:
+		ps.StandIn = true
+	default:
+		ps.Protocol = p.Connection.ProtocolVersion()
+	}
+	if p.Exploded {
+		ps.ExplodedFor = now.Sub(p.ExplodedAt).Seconds()
+	}
+	for reason, until := range p.ghosts {
+		switch {
+		case until.IsZero():
+			ps.Ghosts = ghostsWith(ps.Ghosts, reason, 0)
+		case now.Before(until):
+			ps.Ghosts = ghostsWith(ps.Ghosts, reason, until.Sub(now).Seconds())
+		}
+	}
+	return ps
+}
+
+func ghostsWith(ghosts map[string]float64, reason GhostReason, seconds float64) map[string]float64 {
+	if ghosts == nil {
+		ghosts = make(map[string]float64, 1)
+	}
+	ghosts[reason.String()] = seconds
+	return ghosts
+}
+
+// Restore puts a snapshot's state into the room, which must be fresh: not
+// started, nobody in it. The room takes the snapshot's config, road and
+// players; it keeps its own ID and callbacks.
+func (r *Room) Restore(snap *RoomSnapshot) error {
+	if snap.Version != RoomSnapshotVersion {
+		return fmt.Errorf("snapshot format %d, want %d", snap.Version, RoomSnapshotVersion)
+	}
+	if err := snap.Config.Validate(); err != nil {
+		return err
+	}
+	if len(snap.Players) > config.MaxPlayersPerRoom {
+		return fmt.Errorf("%d players, a room holds %d", len(snap.Players), config.MaxPlayersPerRoom)
+	}
+	road := track.Default()
+	if snap.Track != nil && !snap.Track.IsDefault() {
+		// Validate normalizes, so check a copy
+		road = &track.Track{}
+		*road = *snap.Track
+		road.Curves = append([]track.Curve(nil), snap.Track.Curves...)
+		road.Elevation = append([]track.Curve(nil), snap.Track.Elevation...)
+		road.Ramps = append([]track.Ramp(nil), snap.Track.Ramps...)
+		if err := road.Validate(); err != nil {
+			return err
+		}
+	}
+	ids := make(map[uint16]bool, len(snap.Players))
+	for _, ps := range snap.Players {
+		if ps.ID == 0 || ids[ps.ID] {
+			return fmt.Errorf("player ID %d invalid or repeated", ps.ID)
+		}
+		ids[ps.ID] = true
+		if ps.RNG != "" {
+			if _, err := ParseSeed(ps.RNG); err != nil {
+				return fmt.Errorf("player %d: %w", ps.ID, err)
+			}
+		}
+	}
+	var seed, state uint64
+	if snap.Seed != "" || snap.RNG != "" {
+		var err error
+		if seed, err = ParseSeed(snap.Seed); err != nil {
+			return err
+		}
+		if state, err = ParseSeed(snap.RNG); err != nil {
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.running.Load() || len(r.players) > 0 {
+		return fmt.Errorf("room %s is in use", r.ID)
+	}
+
+	r.physicsRate = snap.Config.PhysicsTickRate
+	r.broadcastRate.Store(int32(snap.Config.BroadcastRate))
+	r.rebaseDistance = snap.Config.RebaseDistance
+	r.physics.fuel = snap.Config.Fuel
+	r.variant = snap.Config.trackVariant()
+	r.escalate = snap.Config.Escalate
+	r.qa = snap.Config.QA
+	road = r.roomRoad(road.Shifted(snap.Origin))
+	r.road = road
+	r.physics.road = road
+	r.antiCheat.road = road
+	switch {
+	case snap.Tutorial:
+		r.EnableTutorial(DefaultTutorial)
+	case snap.Practice:
+		r.EnablePractice(false)
+	}
+	if snap.Seed != "" {
+		r.seed = seed
+		r.rng = NewRNG(state)
+	}
+	now := time.Now()
+	atomic.StoreUint64(&r.tickCount, snap.Tick)
+	if snap.Round > 0 {
+		r.round.resume(snap.Round, snap.RoundAge, now)
+	}
+
+	for _, ps := range snap.Players {
+		p := ps.restore(now, r.rng)
+		r.players[p.ID] = p
+		if p.ID >= r.nextPlayerID {
+			r.nextPlayerID = p.ID + 1
+		}
+	}
+	r.hosted = snap.Hosted // The first to join becomes host
+	r.startSuspended = true
+
+	log.Printf("Room %s restored from room %s at tick %d (%d players)", r.ID, snap.RoomID, snap.Tick, len(snap.Players))
+	return nil
+}
+
+// restore creates the player of a snapshot: a bot, or a human's stand-in.
+// A bot without a recorded RNG forks one off rng.
+func (ps PlayerSnapshot) restore(now time.Time, rng *RNG) *Player {
+	p := NewPlayer(ps.ID, "snapshot", ps.Name, ps.Color, botConnection{})
+	if ps.Bot != nil {
+		if state, err := ParseSeed(ps.RNG); err == nil {
+			p.bot = newBotDriver(*ps.Bot, NewRNG(state))
+		} else {
+			p.bot = newBotDriver(*ps.Bot, rng.Fork())
+		}
+	} else {
+		p.standIn = true
+	}
+
+	p.X, p.Y = ps.X, ps.Y
+	p.Speed, p.Angle, p.Rating = ps.Speed, ps.Angle, ps.Rating
+	p.Exploded = ps.Exploded
+	if ps.Exploded {
+		p.ExplodedAt = now.Add(-time.Duration(ps.ExplodedFor * float64(time.Second)))
+	}
+	p.VelX, p.VelY = ps.VelX, ps.VelY
+	p.CurrentInput = ps.Input
+	p.LastValidX, p.LastValidY = ps.LastValidX, ps.LastValidY
+	p.Violations = ps.Violations
+	p.skill, p.runDifficulty, p.runTime = ps.Skill, ps.RunDifficulty, ps.RunTime
+	p.calibrating = ps.Calibrating
+	for _, reason := range []GhostReason{GhostPenalty, GhostAdmin, GhostPractice, GhostRules} {
+		seconds, ok := ps.Ghosts[reason.String()]
+		if !ok {
+			continue
+		}
+		var until time.Time
+		if seconds > 0 {
+			until = now.Add(time.Duration(seconds * float64(time.Second)))
+		}
+		p.setGhostUnlocked(reason, until)
+	}
+	return p
+}
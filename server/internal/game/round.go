@@ -0,0 +1,439 @@
+package game
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"log"
+	"math"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/network"
+)
+
+// Rounds
+//
+// The race itself never stops, but public rooms close a round every
+// config.RoundLength of physics time. During a round the room keeps stats
+// for every human: rating earned, distance, overtakes, contacts, crashes,
+// longest run and best sector time. When the round ends it hands out the
+// awards, sends them in a Results message (ProtocolV8 clients) and passes
+// the round to the round callback for the match history.
+//
+// An overtake is a nearby car (a collision candidate pair) changing from
+// ahead to behind while both are driving; cars less than a car length apart
+// keep their previous order, so driving side by side doesn't count. The
+// pass has to be made in another lane (see lanes.go): while side by side the
+// cars must have been in different lanes of the road, so shoving through a
+// car in its lane or passing it off the road doesn't count.
+//
+// The round's inputs digest is a SHA-256 over the input every human drove
+// with at every tick of the round, in ID order: a record of the tick number
+// (uint32), player ID (uint16), keys, flags, steering and throttle (float64
+// bits), big endian. It ties the certified result (see internal/certify) to
+// the inputs that produced it; a round resumed from a snapshot digests the
+// inputs since the resume. With the room's RNG seed, also recorded, a
+// re-simulation draws the same random numbers the room drew.
+
+// Award kinds, as named in match history
+const (
+	AwardMVP           = "mvp"
+	AwardOvertakes     = "overtakes"
+	AwardCleanest      = "cleanest"
+	AwardSurvival      = "survival"
+	AwardFastestSector = "fastestSector"
+)
+
+var awardCodes = map[string]uint8{
+	AwardMVP:           network.AwardMVP,
+	AwardOvertakes:     network.AwardOvertakes,
+	AwardCleanest:      network.AwardCleanest,
+	AwardSurvival:      network.AwardSurvival,
+	AwardFastestSector: network.AwardFastestSector,
+}
+
+// Award is an award of a finished round
+type Award struct {
+	Kind     string  `json:"kind"`
+	PlayerID uint16  `json:"-"`
+	Name     string  `json:"name"`
+	Value    float64 `json:"value"` // See the network.Award* kinds for units
+}
+
+// RoundPlayer is what a player did in a round
+type RoundPlayer struct {
+	PlayerID    uint16  `json:"-"`
+	Name        string  `json:"name"`
+	Rating      float64 `json:"rating"`  // Rating earned in the round
+	BestRun     float64 `json:"bestRun"` // Highest run rating reached in the round
+	Distance    float64 `json:"distance"`
+	Overtakes   int     `json:"overtakes"`
+	Contacts    int     `json:"contacts"`
+	Crashes     int     `json:"crashes"`
+	LongestRun  float64 `json:"longestRun"`            // Seconds
+	BestSector  float64 `json:"bestSector,omitempty"`  // Seconds (0: no full sector)
+	Driving     float64 `json:"driving,omitempty"`     // Seconds on the road (not exploded)
+	SpeedSpread float64 `json:"speedSpread,omitempty"` // Standard deviation of speed over its mean while driving
+}
+
+// RoundResult is a finished round of a room
+type RoundResult struct {
+	Room    string        `json:"room"`
+	Round   int           `json:"round"`
+	Track   string        `json:"track,omitempty"` // Track label ("" for the built-in road)
+	Started time.Time     `json:"started"`
+	Ended   time.Time     `json:"ended"`
+	Players []RoundPlayer `json:"players"`
+	Awards  []Award       `json:"awards"`
+	Teams   []RoundTeam   `json:"teams,omitempty"` // Relay rooms (see relay.go), best first
+
+	InputsDigest string `json:"inputsDigest"`   // Hex SHA-256 of the round's inputs
+	Seed         string `json:"seed,omitempty"` // The room's RNG seed (FormatSeed, see rng.go)
+}
+
+// roundStats is a player's round so far
+type roundStats struct {
+	RoundPlayer
+
+	alive      bool // Driving (not exploded) at the last tick
+	lastY      float64
+	lastRating float64
+	runTime    float64 // Seconds since the last crash (or join)
+	sector     int     // Sector of lastY
+	sectorTime float64 // Time in the current sector (-1: entered mid-sector)
+
+	speedSum, speedSquares float64 // Per driving tick, for SpeedSpread
+	speedSamples           int
+}
+
+// speedSpread returns the spread of the speeds sampled so far
+func (st *roundStats) speedSpread() float64 {
+	if st.speedSamples == 0 || st.speedSum <= 0 {
+		return 0
+	}
+	mean := st.speedSum / float64(st.speedSamples)
+	variance := st.speedSquares/float64(st.speedSamples) - mean*mean
+	return math.Sqrt(math.Max(variance, 0)) / mean
+}
+
+// roundState is the current round of a room. Only touched by the physics loop.
+type roundState struct {
+	number  int
+	elapsed float64 // Physics seconds
+	started time.Time
+	stats   map[uint16]*roundStats
+
+	// Order of nearby pairs at the last tick (key: pairKey); swapped and
+	// cleared every tick
+	order, lastOrder map[uint32]pairOrder
+
+	inputs hash.Hash // Inputs digest so far
+	ticks  uint32    // Ticks digested
+	record []byte    // Scratch for one input record
+	humans []*Player // Scratch for the humans of a tick, by ID
+}
+
+// startInputs begins digesting the inputs of a new round
+func (rs *roundState) startInputs() {
+	if rs.inputs == nil {
+		rs.inputs = sha256.New()
+		rs.record = make([]byte, 24)
+	}
+	rs.inputs.Reset()
+	rs.ticks = 0
+}
+
+// digestInputs adds the humans' inputs at the current tick to the digest
+func (rs *roundState) digestInputs(players []*Player) {
+	humans := rs.humans[:0]
+	for _, p := range players {
+		if !p.IsBot() {
+			humans = append(humans, p)
+		}
+	}
+	slices.SortFunc(humans, func(a, b *Player) int { return int(a.ID) - int(b.ID) })
+	rs.humans = humans
+
+	b := rs.record
+	binary.BigEndian.PutUint32(b[0:], rs.ticks)
+	for _, p := range humans {
+		in := p.GetInput()
+		binary.BigEndian.PutUint16(b[4:], p.ID)
+		b[6], b[7] = in.Keys, in.Flags
+		binary.BigEndian.PutUint64(b[8:], math.Float64bits(in.Steering))
+		binary.BigEndian.PutUint64(b[16:], math.Float64bits(in.Throttle))
+		rs.inputs.Write(b)
+	}
+}
+
+// pairOrder is the order of a nearby pair of cars
+type pairOrder struct {
+	ahead  bool // The lower ID is ahead
+	inLane bool // Side by side in different lanes since they were last apart
+}
+
+func pairKey(a, b uint16) uint32 {
+	if a > b {
+		a, b = b, a
+	}
+	return uint32(a)<<16 | uint32(b)
+}
+
+func sectorOf(y float64) int {
+	return int(math.Floor(y / config.RoundSectorLength))
+}
+
+// SetOnRoundEnd sets a callback function called with every finished round.
+func (r *Room) SetOnRoundEnd(callback func(result RoundResult)) {
+	r.onRoundEnd = callback
+}
+
+// resume continues a round already elapsed seconds in (restored room
+// snapshots). The awards so far are lost.
+func (rs *roundState) resume(number int, elapsed float64, now time.Time) {
+	rs.number = number
+	rs.elapsed = elapsed
+	rs.started = now.Add(-time.Duration(elapsed * float64(time.Second)))
+	rs.stats = make(map[uint16]*roundStats)
+	rs.order = make(map[uint32]pairOrder)
+	rs.lastOrder = make(map[uint32]pairOrder)
+	rs.startInputs()
+}
+
+// roundTick updates the round stats with a physics tick: the nearby pairs
+// and the pairs that collided. Ends the round when its time is up.
+func (r *Room) roundTick(players []*Player, pairs, contacts [][2]*Player, dt float64) {
+	rs := &r.round
+	if rs.stats == nil {
+		rs.number++
+		rs.started = time.Now()
+		rs.stats = make(map[uint16]*roundStats)
+		rs.order = make(map[uint32]pairOrder)
+		rs.lastOrder = make(map[uint32]pairOrder)
+		rs.startInputs()
+	}
+	rs.elapsed += dt
+	rs.ticks++
+	rs.digestInputs(players)
+
+	for _, p := range players {
+		if p.IsBot() {
+			continue
+		}
+		state := p.GetState()
+		st, ok := rs.stats[p.ID]
+		if !ok || (!st.alive && !state.Exploded) {
+			// Joined or respawned: start measuring from here
+			if !ok {
+				st = &roundStats{RoundPlayer: RoundPlayer{PlayerID: p.ID, Name: p.Name}}
+				rs.stats[p.ID] = st
+			}
+			st.alive = !state.Exploded
+			st.lastY, st.lastRating = state.Y, state.Rating
+			st.runTime = 0
+			st.sector, st.sectorTime = sectorOf(state.Y), -1
+			continue
+		}
+		if state.Exploded {
+			if st.alive {
+				st.alive = false
+				st.Crashes++
+				st.BestRun = math.Max(st.BestRun, st.lastRating)
+			}
+			continue
+		}
+		if p.parked.Load() {
+			// Riding along on a relay teammate's leg (see relay.go)
+			st.lastY, st.lastRating = state.Y, state.Rating
+			st.sector, st.sectorTime = sectorOf(state.Y), -1
+			continue
+		}
+
+		if state.Y > st.lastY {
+			st.Distance += state.Y - st.lastY
+		}
+		st.lastY = state.Y
+		if state.Rating > st.lastRating {
+			st.Rating += state.Rating - st.lastRating
+		} else if state.Rating == 0 {
+			st.BestRun = math.Max(st.BestRun, st.lastRating) // Reset for prestige
+		}
+		st.lastRating = state.Rating
+		st.runTime += dt
+		st.LongestRun = math.Max(st.LongestRun, st.runTime)
+		st.Driving += dt
+		st.speedSum += state.Speed
+		st.speedSquares += state.Speed * state.Speed
+		st.speedSamples++
+
+		if st.sectorTime >= 0 {
+			st.sectorTime += dt
+		}
+		if sector := sectorOf(state.Y); sector != st.sector {
+			if sector == st.sector+1 && st.sectorTime >= 0 && (st.BestSector == 0 || st.sectorTime < st.BestSector) {
+				st.BestSector = st.sectorTime
+			}
+			st.sectorTime = -1
+			if sector == st.sector+1 {
+				st.sectorTime = 0
+			}
+			st.sector = sector
+		}
+	}
+
+	for _, pair := range contacts {
+		for _, p := range pair {
+			if st, ok := rs.stats[p.ID]; ok {
+				st.Contacts++
+			}
+		}
+	}
+
+	for _, pair := range pairs {
+		a, b := pair[0].GetState(), pair[1].GetState()
+		if a.Exploded || b.Exploded {
+			continue
+		}
+		key := pairKey(a.ID, b.ID)
+		if a.ID > b.ID {
+			a, b = b, a
+		}
+		last, seen := rs.lastOrder[key]
+		cur := pairOrder{ahead: last.ahead}
+		switch {
+		case a.Y-b.Y >= config.CarHeight:
+			cur.ahead = true
+		case b.Y-a.Y >= config.CarHeight:
+			cur.ahead = false
+		case !seen:
+			continue
+		default:
+			cur.inLane = last.inLane || (a.Lane != b.Lane && a.Lane != 0 && b.Lane != 0)
+		}
+		rs.order[key] = cur
+		if seen && cur.ahead != last.ahead && last.inLane {
+			winner := b.ID
+			if cur.ahead {
+				winner = a.ID
+			}
+			if st, ok := rs.stats[winner]; ok {
+				st.Overtakes++
+			}
+		}
+	}
+	rs.order, rs.lastOrder = rs.lastOrder, rs.order
+	clear(rs.order)
+
+	if rs.elapsed >= config.RoundLength.Seconds() || r.roundEndAsked.Swap(false) {
+		r.endRound(players)
+	}
+}
+
+// EndRound ends the current round at the next physics tick, handing out its
+// awards as if its time were up.
+func (r *Room) EndRound() error {
+	if r.practice != nil {
+		return ErrNoRounds
+	}
+	r.roundEndAsked.Store(true)
+	return nil
+}
+
+// endRound hands out the awards of the current round to the players still in
+// the room and starts the next round. The result lists everyone who drove in
+// the round, including players who left.
+func (r *Room) endRound(players []*Player) {
+	rs := &r.round
+	result := RoundResult{
+		Room:    r.ID,
+		Round:   rs.number,
+		Track:   r.Track().Label(),
+		Started: rs.started,
+		Ended:   time.Now(),
+
+		InputsDigest: hex.EncodeToString(rs.inputs.Sum(nil)),
+		Seed:         FormatSeed(r.Seed()),
+	}
+
+	present := make(map[uint16]bool, len(players))
+	for _, p := range players {
+		present[p.ID] = true
+	}
+	ids := make([]uint16, 0, len(rs.stats))
+	for id := range rs.stats {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	best := make(map[string]*Award)
+	consider := func(kind string, id uint16, name string, value float64, better func(a, b float64) bool) {
+		if a, ok := best[kind]; !ok || better(value, a.Value) {
+			best[kind] = &Award{Kind: kind, PlayerID: id, Name: name, Value: value}
+		}
+	}
+	more := func(a, b float64) bool { return a > b }
+	less := func(a, b float64) bool { return a < b }
+	for _, id := range ids {
+		st := rs.stats[id]
+		if st.alive {
+			st.BestRun = math.Max(st.BestRun, st.lastRating)
+		}
+		st.SpeedSpread = st.speedSpread()
+		result.Players = append(result.Players, st.RoundPlayer)
+		if !present[id] {
+			continue
+		}
+		if st.Rating > 0 {
+			consider(AwardMVP, id, st.Name, st.Rating, more)
+		}
+		if st.Overtakes > 0 {
+			consider(AwardOvertakes, id, st.Name, float64(st.Overtakes), more)
+		}
+		if st.Distance >= config.RoundCleanMinDistance {
+			consider(AwardCleanest, id, st.Name, float64(st.Contacts+st.Crashes)*1000/st.Distance, less)
+		}
+		if st.LongestRun > 0 {
+			consider(AwardSurvival, id, st.Name, st.LongestRun, more)
+		}
+		if st.BestSector > 0 {
+			consider(AwardFastestSector, id, st.Name, st.BestSector, less)
+		}
+	}
+
+	wire := make([]network.ResultAward, 0, len(best))
+	for _, kind := range []string{AwardMVP, AwardOvertakes, AwardCleanest, AwardSurvival, AwardFastestSector} {
+		if a, ok := best[kind]; ok {
+			result.Awards = append(result.Awards, *a)
+			wire = append(wire, network.ResultAward{Kind: awardCodes[kind], PlayerID: a.PlayerID, Value: float32(a.Value)})
+		}
+	}
+
+	if r.relay != nil {
+		result.Teams = r.relayRound()
+	}
+
+	rs.stats = nil
+	rs.elapsed = 0
+	if len(result.Players) == 0 {
+		return
+	}
+
+	msg := r.protocol.EncodeResults(uint16(result.Round), wire)
+	r.mu.RLock()
+	for _, p := range r.players {
+		if p.Connection.ProtocolVersion() >= network.ProtocolV8 {
+			p.Connection.Send(msg)
+		}
+	}
+	r.spectateUnlocked(msg)
+	r.mu.RUnlock()
+
+	log.Printf("Room %s round %d ended: %d players, %d awards", r.ID, result.Round, len(result.Players), len(result.Awards))
+	if r.onRoundEnd != nil {
+		r.onRoundEnd(result)
+	}
+}
@@ -0,0 +1,198 @@
+package game
+
+import (
+	"log"
+	"time"
+)
+
+// Room rules
+//
+// Rooms can run custom rules on top of the race: community-made modes such
+// as tag, convoy or king of the road. Rules are a RoomRules attached to the
+// room before it starts (in practice a script, see internal/rules); the
+// physics tick calls its hooks after collisions, in this order:
+//
+//   - OnJoin for every car that appeared since the last tick
+//   - OnCollision for every pair of cars in contact this tick
+//   - OnExplosion for every car that exploded since the last tick
+//   - OnTick once
+//
+// Hooks run on the tick, so they see the cars between the physics and the
+// anti-cheat passes and may change them through the RulesAPI: explosions,
+// rating and ghosting. A hook that fails detaches the rules from the room,
+// which races on without them.
+
+// RoomRules are the hooks of a room's custom rules
+type RoomRules interface {
+	Name() string
+	OnJoin(api RulesAPI, p PlayerState) error
+	OnCollision(api RulesAPI, a, b PlayerState) error
+	OnExplosion(api RulesAPI, p PlayerState) error
+	OnTick(api RulesAPI, dt float64) error
+}
+
+// RulesAPI is what rule hooks can do to the room's cars
+type RulesAPI interface {
+	Players() []PlayerState
+	Explode(playerID uint16) error
+	AddRating(playerID uint16, points float64) error
+	Ghost(playerID uint16, d time.Duration) error // d <= 0 lifts it
+}
+
+// RulesLibrary creates rules by name (see RoomConfig.Rules)
+type RulesLibrary interface {
+	New(name string) (RoomRules, error)
+}
+
+// rulesState tracks what the hooks have seen, touched only by the tick
+type rulesState struct {
+	rules    RoomRules
+	seen     map[uint16]bool // Cars OnJoin was called for
+	exploded map[uint16]bool // Cars exploded as of the last tick
+}
+
+// SetRules attaches custom rules to the room. Must be called before Start.
+func (r *Room) SetRules(rules RoomRules) {
+	name := rules.Name()
+	r.rulesName.Store(&name)
+	r.rules = rulesState{
+		rules:    rules,
+		seen:     make(map[uint16]bool),
+		exploded: make(map[uint16]bool),
+	}
+}
+
+// Rules returns the name of the room's rules ("" if none).
+func (r *Room) Rules() string {
+	if rules := r.rulesName.Load(); rules != nil {
+		return *rules
+	}
+	return ""
+}
+
+// rulesTick runs the rule hooks of one physics tick. contacts are the
+// tick's collisions.
+func (r *Room) rulesTick(players []*Player, contacts [][2]*Player, dt float64) {
+	rs := &r.rules
+	if rs.rules == nil {
+		return
+	}
+	api := roomRulesAPI{room: r, players: players}
+
+	err := func() error {
+		for _, p := range players {
+			if rs.seen[p.ID] {
+				continue
+			}
+			rs.seen[p.ID] = true
+			if err := rs.rules.OnJoin(api, p.GetState()); err != nil {
+				return err
+			}
+		}
+		for _, pair := range contacts {
+			if err := rs.rules.OnCollision(api, pair[0].GetState(), pair[1].GetState()); err != nil {
+				return err
+			}
+		}
+		for _, p := range players {
+			state := p.GetState()
+			was := rs.exploded[p.ID]
+			rs.exploded[p.ID] = state.Exploded
+			if state.Exploded && !was {
+				if err := rs.rules.OnExplosion(api, state); err != nil {
+					return err
+				}
+			}
+		}
+		return rs.rules.OnTick(api, dt)
+	}()
+
+	// Forget cars that left
+	if len(rs.seen) > len(players) {
+		present := make(map[uint16]bool, len(players))
+		for _, p := range players {
+			present[p.ID] = true
+		}
+		for id := range rs.seen {
+			if !present[id] {
+				delete(rs.seen, id)
+				delete(rs.exploded, id)
+			}
+		}
+	}
+
+	if err != nil {
+		log.Printf("Rules %s of room %s failed, detached: %v", rs.rules.Name(), r.ID, err)
+		r.rules = rulesState{}
+		r.rulesName.Store(nil)
+	}
+}
+
+// roomRulesAPI gives rule hooks the cars of the tick in progress
+type roomRulesAPI struct {
+	room    *Room
+	players []*Player
+}
+
+func (a roomRulesAPI) Players() []PlayerState {
+	states := make([]PlayerState, 0, len(a.players))
+	for _, p := range a.players {
+		states = append(states, p.GetState())
+	}
+	return states
+}
+
+// player finds a car of the tick
+func (a roomRulesAPI) player(id uint16) (*Player, error) {
+	for _, p := range a.players {
+		if p.ID == id {
+			return p, nil
+		}
+	}
+	return nil, ErrPlayerNotFound
+}
+
+func (a roomRulesAPI) Explode(playerID uint16) error {
+	p, err := a.player(playerID)
+	if err != nil {
+		return err
+	}
+	if p.replay {
+		return nil // Placed by the room
+	}
+	p.Explode()
+	return nil
+}
+
+func (a roomRulesAPI) AddRating(playerID uint16, points float64) error {
+	p, err := a.player(playerID)
+	if err != nil {
+		return err
+	}
+	p.addRating(points)
+	return nil
+}
+
+func (a roomRulesAPI) Ghost(playerID uint16, d time.Duration) error {
+	p, err := a.player(playerID)
+	if err != nil {
+		return err
+	}
+	if d <= 0 {
+		p.ClearGhost(GhostRules)
+	} else {
+		p.SetGhost(GhostRules, d)
+	}
+	return nil
+}
+
+// addRating changes the rating of the run in progress by points, never
+// below 0. Exploded cars have no run in progress.
+func (p *Player) addRating(points float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.Exploded {
+		p.Rating = max(0, p.Rating+points)
+	}
+}
@@ -0,0 +1,367 @@
+package game
+
+import (
+	"container/heap"
+	"log"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/race/server/internal/crash"
+	"github.com/race/server/internal/routines"
+)
+
+// Scheduler runs the game loops of rooms on a bounded pool of workers
+// instead of a goroutine per room.
+//
+// A clock goroutine keeps the running rooms ordered by their next physics
+// tick and queues each room when its tick is due; workers take the queue in
+// order. A room is on at most one worker at a time and its ticks run in
+// sequence, so every room simulates exactly as it would on its own; rooms
+// only share the CPUs. A room whose previous tick is still queued or running
+// when the next one is due loses that tick, like a ticker's reader that falls
+// behind (the next tick's dt covers the time).
+//
+// The worker count caps how many CPUs the simulation takes, and the
+// scheduler is where its cost is measured (see SchedulerStats).
+type Scheduler struct {
+	workers int
+
+	mu       orderedMutex
+	work     *sync.Cond   // Wakes workers: runQueue has rooms, or stopping
+	clocks   clockHeap    // Running rooms by next tick
+	runQueue []*roomClock // Due rooms waiting for a worker, in due order
+	rooms    int          // Rooms added and not removed
+	parked   int          // Suspended rooms
+	stopped  bool
+
+	wakeClock chan struct{} // The earliest tick may have moved (buffered, 1)
+	quit      chan struct{}
+
+	ticks atomic.Uint64 // Room ticks run
+	late  atomic.Uint64 // Room ticks lost waiting for a worker
+	busy  atomic.Int64  // Nanoseconds workers spent in room ticks
+
+	slowest atomic.Int64 // Nanoseconds of the slowest room tick in the sampling window
+
+	// Load and slowest tick over the last sampling window (see Stats),
+	// guarded by mu
+	sampledAt   time.Time
+	sampledBusy int64
+	load        float64
+	maxTick     time.Duration
+}
+
+// roomClock is a room's place in the scheduler. Guarded by Scheduler.mu.
+type roomClock struct {
+	room     *Room
+	interval time.Duration // Physics tick interval
+	due      time.Time     // Next physics tick
+	index    int           // Position in the heap (-1 while parked or removed)
+
+	queued  bool // In the run queue or on a worker
+	restart bool // First tick after starting or resuming
+	parked  bool // Suspended until resume
+	woken   bool // A resume arrived while running (see Room.resume)
+	removed bool // Stopped
+}
+
+// SchedulerStats reports the simulation's cost
+type SchedulerStats struct {
+	Workers int     `json:"workers"`
+	Rooms   int     `json:"rooms"`  // Running rooms, suspended ones included
+	Parked  int     `json:"parked"` // Suspended rooms
+	Ticks   uint64  `json:"ticks"`
+	Late    uint64  `json:"late"`   // Ticks lost because no worker was free in time
+	BusyMS  float64 `json:"busyMs"` // Total time workers spent in room ticks
+	Load    float64 `json:"load"`   // Share of the workers' time spent in room ticks recently, 0-1
+
+	MaxTickMS float64 `json:"maxTickMs"` // Slowest room tick recently
+}
+
+// NewScheduler starts a scheduler with the given number of workers
+// (0: one per CPU, runtime.GOMAXPROCS).
+func NewScheduler(workers int) *Scheduler {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	s := &Scheduler{
+		mu:        orderedMutex{class: lockScheduler},
+		workers:   workers,
+		wakeClock: make(chan struct{}, 1),
+		quit:      make(chan struct{}),
+		sampledAt: time.Now(),
+	}
+	s.work = sync.NewCond(&s.mu)
+
+	routines.Go("sim.clock", s.clock)
+	for i := 0; i < workers; i++ {
+		routines.Go("sim.worker", s.worker)
+	}
+	return s
+}
+
+// defaultScheduler runs the rooms started without a scheduler of their own
+var defaultScheduler = sync.OnceValue(func() *Scheduler { return NewScheduler(0) })
+
+// Stop ends the clock and the workers. Rooms still added stop ticking.
+// Safe to call more than once.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		return
+	}
+	s.stopped = true
+	close(s.quit)
+	s.work.Broadcast()
+}
+
+// Stats returns the scheduler's counters. Load and the slowest tick are
+// measured over the time since the previous sample, taken at most once a
+// second.
+func (s *Scheduler) Stats() SchedulerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	busy := s.busy.Load()
+	if elapsed := time.Since(s.sampledAt); elapsed >= time.Second {
+		s.load = float64(busy-s.sampledBusy) / (float64(elapsed) * float64(s.workers))
+		s.sampledAt = s.sampledAt.Add(elapsed)
+		s.sampledBusy = busy
+		s.maxTick = time.Duration(s.slowest.Swap(0))
+	}
+
+	return SchedulerStats{
+		Workers: s.workers,
+		Rooms:   s.rooms,
+		Parked:  s.parked,
+		Ticks:   s.ticks.Load(),
+		Late:    s.late.Load(),
+		BusyMS:  float64(busy) / float64(time.Millisecond),
+		Load:    s.load,
+
+		MaxTickMS: float64(s.maxTick) / float64(time.Millisecond),
+	}
+}
+
+// add starts ticking a room, or parks it right away if it starts suspended
+// (a restored snapshot, see roomsnapshot.go)
+func (s *Scheduler) add(r *Room) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	interval := time.Second / time.Duration(r.physicsRate)
+	c := &roomClock{room: r, interval: interval, due: time.Now().Add(interval), restart: true}
+	r.clock = c
+	s.rooms++
+	if r.startSuspended {
+		c.index = -1
+		c.parked = true
+		s.parked++
+		r.suspended.Store(true)
+		return
+	}
+	heap.Push(&s.clocks, c)
+	s.wake()
+}
+
+// remove stops ticking a room. A tick already on a worker finishes.
+func (s *Scheduler) remove(r *Room) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := r.clock
+	if c == nil || c.removed {
+		return
+	}
+	c.removed = true
+	if c.parked {
+		s.parked--
+	} else {
+		heap.Remove(&s.clocks, c.index)
+	}
+	s.rooms--
+}
+
+// resume wakes a suspended room, or leaves the wake-up pending if the room
+// is running.
+func (s *Scheduler) resume(r *Room) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := r.clock
+	if c == nil || c.removed {
+		return
+	}
+	if !c.parked {
+		c.woken = true
+		return
+	}
+
+	c.parked = false
+	s.parked--
+	c.due = time.Now().Add(c.interval)
+	c.restart = true
+	heap.Push(&s.clocks, c)
+	r.suspended.Store(false)
+	s.wake()
+	log.Printf("Room %s resumed", r.ID)
+}
+
+// wake tells the clock the earliest tick may have moved.
+// IMPORTANT: Caller must hold s.mu.
+func (s *Scheduler) wake() {
+	select {
+	case s.wakeClock <- struct{}{}:
+	default:
+	}
+}
+
+// clock queues rooms as their ticks come due.
+func (s *Scheduler) clock() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		s.mu.Lock()
+		now := time.Now()
+		queued := false
+		for len(s.clocks) > 0 && !s.clocks[0].due.After(now) {
+			c := s.clocks[0]
+			if c.queued {
+				s.late.Add(1)
+			} else {
+				c.queued = true
+				s.runQueue = append(s.runQueue, c)
+				queued = true
+			}
+			c.due = c.due.Add(c.interval)
+			if c.due.Before(now) {
+				// The clock itself fell behind; drop the missed ticks
+				c.due = now.Add(c.interval)
+			}
+			heap.Fix(&s.clocks, 0)
+		}
+		wait := time.Hour
+		if len(s.clocks) > 0 {
+			wait = s.clocks[0].due.Sub(now)
+		}
+		s.mu.Unlock()
+		if queued {
+			s.work.Broadcast()
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+		select {
+		case <-s.quit:
+			return
+		case <-s.wakeClock:
+		case <-timer.C:
+		}
+	}
+}
+
+// worker runs due room ticks until the scheduler stops.
+func (s *Scheduler) worker() {
+	for {
+		s.mu.Lock()
+		for len(s.runQueue) == 0 && !s.stopped {
+			s.work.Wait()
+		}
+		if s.stopped {
+			s.mu.Unlock()
+			return
+		}
+		c := s.runQueue[0]
+		s.runQueue[0] = nil
+		s.runQueue = s.runQueue[1:]
+		restart, removed := c.restart, c.removed
+		c.restart = false
+		s.mu.Unlock()
+
+		suspend := false
+		if !removed {
+			began := time.Now()
+			suspend = s.tick(c, began, restart)
+			took := int64(time.Since(began))
+			s.busy.Add(took)
+			s.ticks.Add(1)
+			for slowest := s.slowest.Load(); took > slowest && !s.slowest.CompareAndSwap(slowest, took); {
+				slowest = s.slowest.Load()
+			}
+		}
+
+		s.mu.Lock()
+		c.queued = false
+		parked := false
+		if suspend && !c.removed {
+			if c.woken {
+				// A join raced with the tick: run one more before checking again
+				c.woken = false
+			} else {
+				heap.Remove(&s.clocks, c.index)
+				c.parked = true
+				s.parked++
+				c.room.suspended.Store(true)
+				parked = true
+			}
+		}
+		s.mu.Unlock()
+		if parked {
+			log.Printf("Room %s suspended (empty)", c.room.ID)
+		}
+	}
+}
+
+// tick runs one tick of a room, naming the room in the report of a panic
+func (s *Scheduler) tick(c *roomClock, now time.Time, restart bool) bool {
+	defer func() {
+		if r := recover(); r != nil {
+			panic(crash.With(r, "room", c.room.ID))
+		}
+	}()
+	return c.room.tick(now, restart)
+}
+
+// clockHeap orders room clocks by due time, then room ID, so rooms due at
+// the same time are always queued in the same order
+type clockHeap []*roomClock
+
+func (h clockHeap) Len() int { return len(h) }
+
+func (h clockHeap) Less(i, j int) bool {
+	if h[i].due.Equal(h[j].due) {
+		return h[i].room.ID < h[j].room.ID
+	}
+	return h[i].due.Before(h[j].due)
+}
+
+func (h clockHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *clockHeap) Push(x interface{}) {
+	c := x.(*roomClock)
+	c.index = len(*h)
+	*h = append(*h, c)
+}
+
+func (h *clockHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	old[len(old)-1] = nil
+	c.index = -1
+	*h = old[:len(old)-1]
+	return c
+}
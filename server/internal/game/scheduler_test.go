@@ -0,0 +1,33 @@
+package game
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/race/server/internal/routines/routinestest"
+)
+
+// TestSchedulerTeardown runs rooms of humans and bots on a scheduler, stops
+// them and the scheduler, and checks the clock and workers end
+func TestSchedulerTeardown(t *testing.T) {
+	scheduler := NewScheduler(2)
+	rooms := make([]*Room, 4)
+	for i := range rooms {
+		rooms[i] = NewRoom(fmt.Sprintf("teardown-%d", i))
+		rooms[i].SetScheduler(scheduler)
+		addHumans(t, rooms[i], 2)
+		addBots(t, rooms[i], 10)
+		rooms[i].Start()
+	}
+	time.Sleep(200 * time.Millisecond)
+	if ticks := rooms[0].Tick(); ticks == 0 {
+		t.Fatal("rooms never ticked")
+	}
+
+	for _, room := range rooms {
+		room.Stop()
+	}
+	scheduler.Stop()
+	routinestest.CheckZero(t, "sim.clock", "sim.worker")
+}
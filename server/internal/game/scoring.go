@@ -0,0 +1,139 @@
+package game
+
+import (
+	"math"
+
+	"github.com/race/server/config"
+)
+
+// Scoring
+//
+// Rating accrues with speed alone, so it piles up faster on an empty road
+// than in a full room of good drivers. To keep leaderboard scores from
+// different rooms comparable, the room samples its difficulty for every run
+// in progress each physics tick, and the ScoringPolicy scales the run's
+// rating by the difficulty averaged over the run. Ratings shown in the room
+// stay unscaled: everyone there drives the same room. Another Scorer can
+// replace the policy (see internal/plugin).
+
+// RoomDifficulty is what a driver faces in a room
+type RoomDifficulty struct {
+	Others int     // Other cars on the road, bots included
+	Skill  float64 // Average skill of the other drivers (0 with no others)
+}
+
+// Run is a finished run of a player
+type Run struct {
+	Rating     float64 // Rating at the end of the run
+	Difficulty float64 // Room difficulty multiplier averaged over the run
+}
+
+// Scorer turns runs into leaderboard scores. Its methods are called from
+// the physics tick and must not block.
+type Scorer interface {
+	// Multiplier returns the score multiplier of a room difficulty,
+	// sampled every tick and averaged into Run.Difficulty
+	Multiplier(d RoomDifficulty) float64
+	// Score returns the leaderboard score of a run
+	Score(run Run) float64
+}
+
+// ScoringPolicy is the built-in Scorer
+type ScoringPolicy struct {
+	CrowdBase     float64 // Multiplier alone on the road
+	CrowdStep     float64 // Added per other car (up to CrowdMax), times the skill factor
+	CrowdMax      int
+	SkillBaseline float64 // Skill of an average driver (skill factor 1)
+	SkillMin      float64 // Bounds of the skill factor
+	SkillMax      float64
+	BotSkill      float64 // Skill of a bot
+}
+
+// DefaultScoringPolicy returns the policy from the config.Scoring* constants
+func DefaultScoringPolicy() ScoringPolicy {
+	return ScoringPolicy{
+		CrowdBase:     config.ScoringCrowdBase,
+		CrowdStep:     config.ScoringCrowdStep,
+		CrowdMax:      config.ScoringCrowdMax,
+		SkillBaseline: config.ScoringSkillBaseline,
+		SkillMin:      config.ScoringSkillMin,
+		SkillMax:      config.ScoringSkillMax,
+		BotSkill:      config.ScoringBotSkill,
+	}
+}
+
+// Multiplier returns the score multiplier of a room difficulty
+func (s ScoringPolicy) Multiplier(d RoomDifficulty) float64 {
+	skill := 1.0
+	if s.SkillBaseline > 0 {
+		skill = math.Max(s.SkillMin, math.Min(s.SkillMax, d.Skill/s.SkillBaseline))
+	}
+	return s.CrowdBase + s.CrowdStep*float64(min(d.Others, s.CrowdMax))*skill
+}
+
+// Score returns the leaderboard score of a run
+func (s ScoringPolicy) Score(run Run) float64 {
+	return run.Rating * run.Difficulty
+}
+
+// skillOf returns a driver's skill for difficulty sampling
+func (s ScoringPolicy) skillOf(p *Player) float64 {
+	if p.IsBot() {
+		return s.BotSkill
+	}
+	if skill := p.Skill(); skill > 0 {
+		return skill
+	}
+	return s.SkillBaseline
+}
+
+// CalibrateSkill makes a human player's skill converge faster: runs weigh
+// config.PlacementSkillWeight in it instead of config.ScoringSkillWeight,
+// and a better run in progress counts before it ends. For new players who
+// drive far above the skill they are assumed to have (see
+// history/placement.go). Lasts as long as the player stays in the room.
+func (r *Room) CalibrateSkill(playerID uint16) error {
+	r.mu.RLock()
+	p, exists := r.players[playerID]
+	r.mu.RUnlock()
+	if !exists || p.IsBot() {
+		return ErrPlayerNotFound
+	}
+	p.calibrate()
+	return nil
+}
+
+// SetScoringPolicy replaces the room's scoring policy
+func (r *Room) SetScoringPolicy(policy Scorer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.scoring = policy
+}
+
+// scoreTick samples the room's difficulty for every human's run in
+// progress. Called by the physics loop; practice runs are never scored.
+func (r *Room) scoreTick(players []*Player, dt float64) {
+	r.mu.RLock()
+	policy := r.scoring
+	r.mu.RUnlock()
+
+	// Other scorers get the skills the default policy estimates
+	skills, ok := policy.(ScoringPolicy)
+	if !ok {
+		skills = DefaultScoringPolicy()
+	}
+	total := 0.0
+	for _, p := range players {
+		total += skills.skillOf(p)
+	}
+	for _, p := range players {
+		if p.IsBot() {
+			continue
+		}
+		d := RoomDifficulty{Others: len(players) - 1}
+		if d.Others > 0 {
+			d.Skill = (total - skills.skillOf(p)) / float64(d.Others)
+		}
+		p.sampleDifficulty(policy.Multiplier(d), dt)
+	}
+}
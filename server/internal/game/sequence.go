@@ -0,0 +1,225 @@
+package game
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/race/server/config"
+)
+
+// Input sequence numbers
+//
+// Every input carries a one-byte sequence number that the client increments
+// with each input it sends. WebSockets deliver in order and never duplicate,
+// so an honest client's inputs arrive with consecutive numbers. Each player
+// keeps a window of the last 64 numbers seen:
+//   - a number already in the window is a duplicate (a replayed packet)
+//   - a number behind the window's head that wasn't seen is reordered (a
+//     late or injected input)
+//   - a jump ahead by more than config.InputSequenceMaxGap is a gap (inputs
+//     generated outside the client, or a client resetting its counter)
+//
+// What happens then depends on the room's SequenceMode. Rooms count every
+// anomaly for /stats either way.
+
+// SequenceMode is how a room enforces input sequence numbers
+type SequenceMode int32
+
+const (
+	SequenceOff     SequenceMode = iota // Don't check sequence numbers
+	SequenceMonitor                     // Count and log anomalies, apply every input
+	SequenceDrop                        // Also ignore duplicate and reordered inputs
+	SequenceKick                        // Also kick players over the anomaly limit
+)
+
+// ParseSequenceMode parses an INPUT_SEQUENCE_MODE value
+func ParseSequenceMode(s string) (SequenceMode, error) {
+	switch s {
+	case "off":
+		return SequenceOff, nil
+	case "monitor":
+		return SequenceMonitor, nil
+	case "drop":
+		return SequenceDrop, nil
+	case "kick":
+		return SequenceKick, nil
+	}
+	return SequenceOff, fmt.Errorf("unknown input sequence mode %q (off, monitor, drop or kick)", s)
+}
+
+var sequenceModeNames = [...]string{"off", "monitor", "drop", "kick"}
+
+func (m SequenceMode) String() string {
+	if m < 0 || int(m) >= len(sequenceModeNames) {
+		return fmt.Sprintf("SequenceMode(%d)", int32(m))
+	}
+	return sequenceModeNames[m]
+}
+
+// sequenceVerdict classifies an input's sequence number
+type sequenceVerdict int
+
+const (
+	sequenceInOrder sequenceVerdict = iota
+	sequenceGap
+	sequenceDuplicate
+	sequenceReordered
+)
+
+// sequenceWindow tracks the sequence numbers a player sent recently
+type sequenceWindow struct {
+	started bool
+	head    uint8  // Highest sequence number seen
+	seen    uint64 // Bit i set: head-i was seen
+
+	anomalies   int       // Anomalies since windowStart
+	windowStart time.Time // Start of the anomaly counting window
+}
+
+// check records a sequence number and classifies it
+func (w *sequenceWindow) check(seq uint8) sequenceVerdict {
+	if !w.started {
+		w.started, w.head, w.seen = true, seq, 1
+		return sequenceInOrder
+	}
+
+	// Distance from the head, modulo the wrap-around of the byte
+	step := int(int8(seq - w.head))
+	switch {
+	case step > 0:
+		if step >= 64 {
+			w.seen = 0
+		} else {
+			w.seen <<= uint(step)
+		}
+		w.seen |= 1
+		w.head = seq
+		if step > config.InputSequenceMaxGap {
+			return sequenceGap
+		}
+		return sequenceInOrder
+
+	case step == 0:
+		return sequenceDuplicate
+	}
+
+	back := -step
+	if back < 64 {
+		if w.seen&(1<<uint(back)) != 0 {
+			return sequenceDuplicate
+		}
+		w.seen |= 1 << uint(back)
+	}
+	return sequenceReordered
+}
+
+// SequenceStats counts a room's inputs by sequence verdict
+type SequenceStats struct {
+	Inputs     uint64 // Inputs checked
+	Gaps       uint64 // Inputs that jumped too far ahead
+	Duplicates uint64 // Inputs whose sequence number was seen before
+	Reordered  uint64 // Inputs that arrived behind later ones
+	Dropped    uint64 // Inputs ignored for their sequence number
+	Kicks      uint64 // Players kicked over the anomaly limit
+}
+
+// Add adds the counts of another room
+func (s *SequenceStats) Add(other SequenceStats) {
+	s.Inputs += other.Inputs
+	s.Gaps += other.Gaps
+	s.Duplicates += other.Duplicates
+	s.Reordered += other.Reordered
+	s.Dropped += other.Dropped
+	s.Kicks += other.Kicks
+}
+
+// sequenceCounters are a room's SequenceStats, updated from the connections'
+// read goroutines
+type sequenceCounters struct {
+	inputs, gaps, duplicates, reordered, dropped, kicks atomic.Uint64
+}
+
+// count records a verdict
+func (c *sequenceCounters) count(verdict sequenceVerdict) {
+	c.inputs.Add(1)
+	switch verdict {
+	case sequenceGap:
+		c.gaps.Add(1)
+	case sequenceDuplicate:
+		c.duplicates.Add(1)
+	case sequenceReordered:
+		c.reordered.Add(1)
+	}
+}
+
+// checkSequence records an input's sequence number and returns the verdict
+// and the player's anomalies in the current counting window
+func (p *Player) checkSequence(seq uint8, now time.Time) (sequenceVerdict, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	w := &p.sequence
+	verdict := w.check(seq)
+	if verdict == sequenceInOrder {
+		return verdict, w.anomalies
+	}
+	if now.Sub(w.windowStart) > config.InputSequenceWindow {
+		w.windowStart, w.anomalies = now, 0
+	}
+	w.anomalies++
+	return verdict, w.anomalies
+}
+
+// SetSequenceMode sets how the room enforces input sequence numbers
+func (r *Room) SetSequenceMode(mode SequenceMode) {
+	r.sequenceMode.Store(int32(mode))
+}
+
+// SequenceStats returns the room's input sequence counts
+func (r *Room) SequenceStats() SequenceStats {
+	return SequenceStats{
+		Inputs:     r.sequence.inputs.Load(),
+		Gaps:       r.sequence.gaps.Load(),
+		Duplicates: r.sequence.duplicates.Load(),
+		Reordered:  r.sequence.reordered.Load(),
+		Dropped:    r.sequence.dropped.Load(),
+		Kicks:      r.sequence.kicks.Load(),
+	}
+}
+
+// checkSequence enforces the room's sequence mode on an input. Returns false
+// if the input must be ignored.
+func (r *Room) checkSequence(p *Player, seq uint8) bool {
+	mode := SequenceMode(r.sequenceMode.Load())
+	if mode == SequenceOff {
+		return true
+	}
+
+	verdict, anomalies := p.checkSequence(seq, time.Now())
+	r.sequence.count(verdict)
+	if verdict == sequenceInOrder {
+		return true
+	}
+
+	if anomalies == config.InputSequenceAnomalyLimit+1 {
+		log.Printf("Player %s (ID: %d) in room %s: over %d input sequence anomalies",
+			p.GetName(), p.ID, r.ID, config.InputSequenceAnomalyLimit)
+	}
+
+	switch {
+	case mode == SequenceMonitor:
+		return true
+	case mode == SequenceKick && anomalies > config.InputSequenceAnomalyLimit && r.practice == nil:
+		r.sequence.kicks.Add(1)
+		r.kickPlayer(p, "Input replay detected")
+		return false
+	case verdict == sequenceGap:
+		return true // The newest input, whatever came before it
+	}
+
+	// Repeated and late inputs would undo the newer ones already applied
+	r.sequence.dropped.Add(1)
+	return false
+}
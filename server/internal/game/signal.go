@@ -0,0 +1,48 @@
+package game
+
+import (
+	"github.com/race/server/internal/network"
+)
+
+// Voice chat signaling
+//
+// Players in the same room can talk over proximity voice chat. The audio
+// flows peer to peer over WebRTC; the room only relays the signaling that
+// sets up each peer connection (SDP offers and answers, ICE candidates and
+// hangups, see network.SignalMessage) from one player to another. Payloads
+// are opaque to the server and forwarded unchanged. Both players must speak
+// ProtocolV13; bots have no voice. Nothing is relayed between two players
+// when either muted the other (see Muter).
+
+// Muter is implemented by the connections of players who can mute others
+type Muter interface {
+	// Mutes reports whether the player muted the player of that name
+	Mutes(name string) bool
+}
+
+// RelaySignal forwards a signaling payload from one player to another in
+// the room.
+func (r *Room) RelaySignal(fromID, targetID uint16, kind uint8, payload []byte) error {
+	r.mu.RLock()
+	from, fromExists := r.players[fromID]
+	target, exists := r.players[targetID]
+	r.mu.RUnlock()
+
+	if !fromExists || !exists || targetID == fromID || target.IsBot() {
+		return ErrPlayerNotFound
+	}
+	if target.Connection.ProtocolVersion() < network.ProtocolV13 {
+		return ErrVoiceUnsupported
+	}
+	if mutes(from, target) || mutes(target, from) {
+		return ErrMuted
+	}
+
+	return target.Connection.Send(r.protocol.EncodeSignalRelay(fromID, kind, payload))
+}
+
+// mutes reports whether a player muted another
+func mutes(p, other *Player) bool {
+	m, ok := p.Connection.(Muter)
+	return ok && m.Mutes(other.GetName())
+}
@@ -0,0 +1,61 @@
+package game
+
+import (
+	"slices"
+	"time"
+)
+
+// State frames
+//
+// Physics works on the players' fields under their locks, one player at a
+// time, and other goroutines change players between ticks too (a respawn
+// from a reset request, a join). A broadcast that read the players one by
+// one could mix states from different moments: one car already respawned
+// and another still exploding, or a car before the world rebase next to one
+// after it. So the physics tick ends by publishing a frame: the state of
+// every player, captured in one pass after the tick's last change. The state
+// broadcast encodes the latest frame and takes no player locks at all.
+//
+// Frames are double-buffered: the tick captures into the back frame, then
+// swaps it to the front. A published frame is never written until it is the
+// back frame again, two publications later. Only the room's own ticks read
+// frames, and ticks of a room never overlap (see Scheduler), so a frame is
+// never read while it is captured.
+
+// stateFrame is the state of a room's players at the end of a physics tick
+type stateFrame struct {
+	tick    uint64        // Physics tick the frame ends
+	origin  float64       // Road distance of Y=0 (see rebase.go)
+	players []*Player     // Parallel to states
+	states  []PlayerState // As of the end of the tick
+}
+
+// stateFrames is a room's front (published) and back frame
+type stateFrames struct {
+	buffers [2]stateFrame
+	front   int
+}
+
+// published returns the latest frame (empty before the first tick)
+func (f *stateFrames) published() *stateFrame {
+	return &f.buffers[f.front]
+}
+
+// publishFrame captures the players into the back frame and publishes it.
+// Called at the end of the physics tick, by the tick; players kicked in the
+// tick are already gone.
+func (r *Room) publishFrame(tick uint64) {
+	back := &r.frames.buffers[1-r.frames.front]
+	clear(back.players) // Don't keep players who left alive
+	back.players = r.snapshotPlayers(back.players)
+	back.tick = tick
+	back.origin = r.road.Origin()
+	back.states = slices.Grow(back.states[:0], len(back.players))[:len(back.players)]
+
+	now := time.Now()
+	for i, p := range back.players {
+		back.states[i] = p.stateAt(now)
+	}
+	r.frames.front = 1 - r.frames.front
+	r.clips.record(back)
+}
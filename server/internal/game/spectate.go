@@ -0,0 +1,67 @@
+package game
+
+import (
+	"github.com/race/server/config"
+)
+
+// Open spectating
+//
+// Besides the broadcast spectators of director.go, any ProtocolV32 client
+// may watch a public room (Spectate message): a viewer. Viewers are
+// spectators in every way, with no car, no input and no place among the
+// room's config.MaxPlayersPerRoom, but they are counted apart, up to
+// config.MaxViewersPerRoom, so a crowd of them never keeps a tournament
+// broadcast out. They get state updates at up to config.ViewerStateRate:
+// every Nth update the room sends its spectators, N following the room's
+// broadcast rate. Practice, tutorial and honeypot rooms can't be watched.
+
+// spectator is a connection watching the room
+type spectator struct {
+	viewer bool // Watches through a Spectate message, at the viewers' rate
+}
+
+// limit returns how many spectators of the kind a room takes
+func (s spectator) limit() int {
+	if s.viewer {
+		return config.MaxViewersPerRoom
+	}
+	return config.MaxSpectatorsPerRoom
+}
+
+// AddViewer lets a connection watch the room as a viewer. Like a broadcast
+// spectator it gets the room's info (with player ID 0) right away and the
+// rest after the room's spectator delay.
+func (r *Room) AddViewer(conn PlayerConnection) error {
+	if r.practice != nil || r.tutorial != nil || r.honeypot != nil {
+		return ErrNotWatchable
+	}
+	return r.addSpectator(conn, spectator{viewer: true})
+}
+
+// ViewerCount returns the number of viewers watching the room.
+func (r *Room) ViewerCount() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.spectatorsUnlocked(true)
+}
+
+// spectatorsUnlocked counts the viewers, or the broadcast spectators.
+// IMPORTANT: Caller must hold the room lock (read or write).
+func (r *Room) spectatorsUnlocked(viewers bool) int {
+	n := 0
+	for _, s := range r.spectators {
+		if s.viewer == viewers {
+			n++
+		}
+	}
+	return n
+}
+
+// viewersDue counts a state update sent to the spectators and reports
+// whether the viewers get it. Only called by the tick.
+func (r *Room) viewersDue() bool {
+	rate := uint64(r.broadcastRate.Load())
+	every := max(1, (rate+config.ViewerStateRate-1)/config.ViewerStateRate)
+	r.viewerUpdates++
+	return r.viewerUpdates%every == 0
+}
@@ -0,0 +1,165 @@
+package game
+
+import (
+	"time"
+
+	"github.com/race/server/internal/network"
+)
+
+// Spectator delay
+//
+// Competitive rooms can hold back what spectators see by the room's
+// SpectatorDelay, so a broadcast can't tell a racer watching it where the
+// rivals are (stream sniping). Racers keep real-time state. Everything bound
+// for spectators, state updates and events alike, goes through the room's
+// delay buffer and is released in order once it is as old as the delay,
+// when the room broadcasts state. A new spectator's view of the room (its
+// cars, track, host and shot) queues up like the rest, so it sees the room
+// as it was the delay ago and everything after that in order: it gets only
+// the RoomInfo until the delay has passed. State updates are buffered as
+// their records and encoded for each spectator's protocol version on
+// release. The buffer is kept only while someone watches.
+
+// delayedMessage is a message to spectators held back until due
+type delayedMessage struct {
+	due   time.Time
+	conn  PlayerConnection // Only for this spectator (nil: every spectator)
+	data  []byte           // Nil for a state update
+	state delayedState
+}
+
+// delayedState is a buffered state update
+type delayedState struct {
+	tick    uint16
+	origin  int64
+	records []network.PlayerStateData
+}
+
+// spectatorDelay is a room's delay buffer. Its lock comes after the room's
+// and nothing is locked while holding it.
+type spectatorDelay struct {
+	mu      orderedMutex
+	delay   time.Duration // Fixed for the life of the room; 0 sends right away
+	queue   []delayedMessage
+	spare   [][]network.PlayerStateData // Records of released updates, for reuse
+	encoded map[uint8][]byte            // Scratch of release
+}
+
+// spectateToUnlocked sends a message to one spectator, after the delay.
+// IMPORTANT: Caller must hold the room lock (read or write).
+func (r *Room) spectateToUnlocked(conn PlayerConnection, data []byte) {
+	if r.delayed.delay == 0 {
+		conn.Send(data)
+		return
+	}
+	r.delayed.push(delayedMessage{conn: conn, data: data})
+}
+
+// spectateStateUnlocked sends a state update with every record to the
+// spectators, after the delay.
+// IMPORTANT: Caller must hold the room lock (read or write).
+func (r *Room) spectateStateUnlocked(tick uint16, origin int64, records []network.PlayerStateData, encoded map[uint8][]byte) {
+	if r.delayed.delay > 0 {
+		r.delayed.pushState(tick, origin, records)
+		return
+	}
+	viewers := r.viewersDue()
+	for conn, s := range r.spectators {
+		if s.viewer && !viewers {
+			continue
+		}
+		version := conn.ProtocolVersion()
+		msg, ok := encoded[version]
+		if !ok {
+			msg = r.protocol.EncodeStateUpdateBase(version, tick, origin, records)
+			encoded[version] = msg
+		}
+		conn.Send(msg)
+	}
+}
+
+// releaseDelayed sends the spectators what has been held back for the
+// delay. Called by the game loop when it broadcasts state.
+func (r *Room) releaseDelayed(now time.Time) {
+	d := &r.delayed
+	if d.delay == 0 {
+		return
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(r.spectators) == 0 {
+		d.resetUnlocked()
+		return
+	}
+	if d.encoded == nil {
+		d.encoded = make(map[uint8][]byte, 1)
+	}
+	n := 0
+	for ; n < len(d.queue) && !now.Before(d.queue[n].due); n++ {
+		m := &d.queue[n]
+		switch {
+		case m.conn != nil:
+			if _, ok := r.spectators[m.conn]; ok {
+				m.conn.Send(m.data)
+			}
+		case m.data != nil:
+			for conn := range r.spectators {
+				conn.Send(m.data)
+			}
+		default:
+			viewers := r.viewersDue()
+			for conn, s := range r.spectators {
+				if s.viewer && !viewers {
+					continue
+				}
+				version := conn.ProtocolVersion()
+				msg, ok := d.encoded[version]
+				if !ok {
+					msg = r.protocol.EncodeStateUpdateBase(version, m.state.tick, m.state.origin, m.state.records)
+					d.encoded[version] = msg
+				}
+				conn.Send(msg)
+			}
+			clear(d.encoded)
+			d.spare = append(d.spare, m.state.records[:0])
+		}
+		*m = delayedMessage{}
+	}
+	d.queue = d.queue[:copy(d.queue, d.queue[n:])]
+}
+
+// push queues a message for the spectators
+func (d *spectatorDelay) push(m delayedMessage) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	m.due = time.Now().Add(d.delay)
+	d.queue = append(d.queue, m)
+}
+
+// pushState queues a copy of a state update's records
+func (d *spectatorDelay) pushState(tick uint16, origin int64, records []network.PlayerStateData) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var buf []network.PlayerStateData
+	if n := len(d.spare); n > 0 {
+		buf, d.spare = d.spare[n-1], d.spare[:n-1]
+	}
+	d.queue = append(d.queue, delayedMessage{
+		due:   time.Now().Add(d.delay),
+		state: delayedState{tick: tick, origin: origin, records: append(buf, records...)},
+	})
+}
+
+// resetUnlocked drops everything held back, once nobody watches.
+// IMPORTANT: Caller must hold d.mu.
+func (d *spectatorDelay) resetUnlocked() {
+	clear(d.queue)
+	d.queue = d.queue[:0]
+	d.spare = nil
+}
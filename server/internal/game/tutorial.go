@@ -0,0 +1,196 @@
+package game
+
+import (
+	"log"
+	"time"
+)
+
+// Tutorial rooms
+//
+// A tutorial room is a practice room (see practice.go) that walks a new
+// player through a script of steps. Each step sends a prompt (Tutorial
+// message) and waits until its check passes on the server's own state of
+// the car, so the client can't skip ahead. A crash starts the current step
+// over and repeats its prompt; so does a reset to the start line. When the
+// last step passes the room sends the closing text and stays a practice room.
+
+// TutorialProgress is what the player did since the current step (re)started
+type TutorialProgress struct {
+	Elapsed  time.Duration // Physics time without a crash
+	Distance float64       // Distance driven forward without a crash
+}
+
+// TutorialCheck reports whether a step is done
+type TutorialCheck func(p PlayerState, progress TutorialProgress) bool
+
+// TutorialStep is one instruction of a tutorial script
+type TutorialStep struct {
+	Prompt string
+	Check  TutorialCheck
+}
+
+// TutorialScript is a sequence of steps and the text sent when all are done
+type TutorialScript struct {
+	Steps []TutorialStep
+	Done  string
+}
+
+// ReachSpeed passes once the car goes at least speed
+func ReachSpeed(speed float64) TutorialCheck {
+	return func(p PlayerState, _ TutorialProgress) bool {
+		return p.Speed >= speed
+	}
+}
+
+// DriveDistance passes once the car drove distance forward without crashing
+func DriveDistance(distance float64) TutorialCheck {
+	return func(_ PlayerState, progress TutorialProgress) bool {
+		return progress.Distance >= distance
+	}
+}
+
+// SurviveFor passes once the car stayed on the road for d
+func SurviveFor(d time.Duration) TutorialCheck {
+	return func(_ PlayerState, progress TutorialProgress) bool {
+		return progress.Elapsed >= d
+	}
+}
+
+// AllOf passes once every check passes in the same tick
+func AllOf(checks ...TutorialCheck) TutorialCheck {
+	return func(p PlayerState, progress TutorialProgress) bool {
+		for _, check := range checks {
+			if !check(p, progress) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// DefaultTutorial is the onboarding script of tutorial rooms
+var DefaultTutorial = TutorialScript{
+	Steps: []TutorialStep{
+		{Prompt: "Разгонитесь до 80 км/ч", Check: ReachSpeed(800)},
+		{Prompt: "Рулите влево и вправо, держась дороги", Check: DriveDistance(2000)},
+		{Prompt: "Впереди резкие повороты. Сбросьте скорость и пройдите их без аварии", Check: DriveDistance(4000)},
+		{Prompt: "Продержитесь 20 секунд без аварии и выйдите на 100 км/ч", Check: AllOf(SurviveFor(20*time.Second), ReachSpeed(1000))},
+		{Prompt: "Финальный рывок: разгонитесь до 130 км/ч", Check: ReachSpeed(1300)},
+	},
+	Done: "Обучение пройдено! Тренируйтесь сколько угодно или выходите в гонку",
+}
+
+// tutorialState is the script progress of a tutorial room
+type tutorialState struct {
+	mu orderedMutex
+
+	script   TutorialScript
+	playerID uint16 // Player the progress belongs to
+	started  bool   // The first prompt was sent to playerID
+	step     int    // Current step (len(script.Steps) when done)
+	progress TutorialProgress
+	lastY    float64
+	crashed  bool // Waiting for the respawn to repeat the prompt
+}
+
+// EnableTutorial turns the room into a tutorial room running script. Must be
+// called before the first player joins.
+func (r *Room) EnableTutorial(script TutorialScript) {
+	r.EnablePractice(false)
+	r.tutorial = &tutorialState{mu: orderedMutex{class: lockRoomMode}, script: script}
+}
+
+// Tutorial reports whether the room is a tutorial room.
+func (r *Room) Tutorial() bool {
+	return r.tutorial != nil
+}
+
+// tutorialTick advances the script for the room's human. Called by the
+// physics loop after movement and collisions.
+func (r *Room) tutorialTick(players []*Player, dt float64) {
+	var human *Player
+	for _, p := range players {
+		if !p.IsBot() {
+			human = p
+			break
+		}
+	}
+	if human == nil {
+		return
+	}
+	state := human.GetState()
+
+	t := r.tutorial
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.started || t.playerID != human.ID {
+		t.playerID = human.ID
+		t.started = true
+		t.step = 0
+		t.restartUnlocked(state)
+		r.sendTutorialUnlocked(human)
+		return
+	}
+	if t.step >= len(t.script.Steps) {
+		return
+	}
+
+	if state.Exploded {
+		t.restartUnlocked(state)
+		t.crashed = true
+		return
+	}
+	if t.crashed {
+		t.restartUnlocked(state)
+		r.sendTutorialUnlocked(human)
+		return
+	}
+
+	t.progress.Elapsed += time.Duration(dt * float64(time.Second))
+	if state.Y > t.lastY {
+		t.progress.Distance += state.Y - t.lastY
+	}
+	t.lastY = state.Y
+
+	if t.script.Steps[t.step].Check(state, t.progress) {
+		t.step++
+		t.restartUnlocked(state)
+		r.sendTutorialUnlocked(human)
+		if t.step == len(t.script.Steps) {
+			log.Printf("Player %s (ID: %d) finished the tutorial in room %s", human.Name, human.ID, r.ID)
+		}
+	}
+}
+
+// resetTutorial starts the current step over after a reset to the start line
+func (r *Room) resetTutorial(p *Player) {
+	t := r.tutorial
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.started || t.playerID != p.ID || t.step >= len(t.script.Steps) {
+		return
+	}
+	t.restartUnlocked(p.GetState())
+	r.sendTutorialUnlocked(p)
+}
+
+// restartUnlocked clears the progress of the current step.
+// IMPORTANT: Caller must hold t.mu.
+func (t *tutorialState) restartUnlocked(state PlayerState) {
+	t.progress = TutorialProgress{}
+	t.lastY = state.Y
+	t.crashed = false
+}
+
+// sendTutorialUnlocked sends the current step's prompt (or the closing text).
+// IMPORTANT: Caller must hold r.tutorial.mu.
+func (r *Room) sendTutorialUnlocked(p *Player) {
+	t := r.tutorial
+	text := t.script.Done
+	if t.step < len(t.script.Steps) {
+		text = t.script.Steps[t.step].Prompt
+	}
+	p.Connection.Send(r.protocol.EncodeTutorial(uint8(t.step), uint8(len(t.script.Steps)), text))
+}
@@ -0,0 +1,97 @@
+package game
+
+import (
+	"log"
+	"time"
+
+	"github.com/race/server/config"
+)
+
+// Frame-budget watchdog
+//
+// A room has one tick interval (16.67 ms at 60 Hz) to run its whole tick
+// in: physics, rules, broadcasts. A room that overruns now and then loses a
+// tick (see scheduler.go); one that overruns most of its ticks holds a
+// simulation worker for longer than its share and slows every other room
+// of the process down. The watchdog counts overruns over windows of
+// config.TickBudgetWindow ticks and quarantines a room that overran in at
+// least config.TickBudgetOverrunPercent percent of a window: the room
+// refuses new players from then on (matchmaking skips it), and hands a
+// report to the quarantine callback (SetOnQuarantine), once. The room goes
+// on running for the players in it; what becomes of them is up to the
+// callback. Headless rooms (Step) aren't watched.
+
+// TickBudgetReport is the window of ticks that got a room quarantined
+type TickBudgetReport struct {
+	Room       string    `json:"room"`
+	At         time.Time `json:"at"`
+	Ticks      int       `json:"ticks"`
+	Overruns   int       `json:"overruns"`   // Ticks that took longer than the budget
+	BudgetMS   float64   `json:"budgetMs"`   // The tick interval
+	MeanTickMS float64   `json:"meanTickMs"` // Over the window
+	MaxTickMS  float64   `json:"maxTickMs"`
+	Players    int       `json:"players"` // Bots included
+	Humans     int       `json:"humans"`
+}
+
+// watchdogState is the current window of the watchdog. Only touched by the
+// tick.
+type watchdogState struct {
+	ticks    int
+	overruns int
+	total    time.Duration
+	slowest  time.Duration
+}
+
+// SetOnQuarantine sets the callback that gets the report of the room's
+// quarantine. Called by the tick: it must not block.
+func (r *Room) SetOnQuarantine(callback func(report TickBudgetReport)) {
+	r.onQuarantine = callback
+}
+
+// Quarantined reports whether the watchdog quarantined the room.
+func (r *Room) Quarantined() bool {
+	return r.quarantined.Load()
+}
+
+// watchTick counts a tick that took took against its budget. Called by the
+// tick at the end.
+func (r *Room) watchTick(took, budget time.Duration) {
+	if r.quarantined.Load() {
+		return
+	}
+	w := &r.watchdog
+	w.ticks++
+	if took > budget {
+		w.overruns++
+	}
+	w.total += took
+	w.slowest = max(w.slowest, took)
+	if w.ticks < config.TickBudgetWindow {
+		return
+	}
+
+	window := *w
+	*w = watchdogState{}
+	if window.overruns*100 < window.ticks*config.TickBudgetOverrunPercent {
+		return
+	}
+
+	r.quarantined.Store(true)
+	report := TickBudgetReport{
+		Room:       r.ID,
+		At:         time.Now().UTC(),
+		Ticks:      window.ticks,
+		Overruns:   window.overruns,
+		BudgetMS:   float64(budget) / float64(time.Millisecond),
+		MeanTickMS: float64(window.total) / float64(window.ticks) / float64(time.Millisecond),
+		MaxTickMS:  float64(window.slowest) / float64(time.Millisecond),
+		Players:    r.GetPlayerCount(),
+		Humans:     r.HumanCount(),
+	}
+	log.Printf("Room %s quarantined: %d of its last %d ticks overran %.2fms (mean %.2fms, max %.2fms)",
+		r.ID, report.Overruns, report.Ticks, report.BudgetMS, report.MeanTickMS, report.MaxTickMS)
+	if r.onQuarantine != nil {
+		r.onQuarantine(report)
+	}
+}
@@ -0,0 +1,108 @@
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/storage"
+)
+
+// Account links
+//
+// Guests play under any name, which keys their profile and match list like
+// everyone's. Registered accounts are kept by an account service outside the
+// game server: when a guest registers or logs in mid-play, the service has
+// the server issue a link token naming the account and the game client
+// presents it (Link message). Link then moves what is stored under the guest
+// name to the account name in one storage transaction: the guest's totals
+// are added to the account's profile (achievements follow from them), the
+// guest's matches join the account's list with the name replaced, and the
+// guest's profile and match list are removed. The caller spends the link
+// token in the same transaction, so a link either happens completely, token
+// and all, or not at all.
+
+// Link moves the stored data of guest to account, committing the writes in
+// txn with whatever the caller already put there. Returns the account's
+// profile after the move.
+func (h *History) Link(txn *storage.Txn, guest, account string) (Profile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	profile, _, err := h.profile(ctx, account)
+	if err != nil {
+		return Profile{}, err
+	}
+	profile.Name = account
+	if guest != account {
+		if err := h.moveGuest(ctx, txn, guest, account, &profile); err != nil {
+			return Profile{}, err
+		}
+	}
+	if !slices.Contains(profile.Guests, guest) {
+		profile.Guests = append(profile.Guests, guest)
+	}
+
+	data, err := json.Marshal(profile)
+	if err != nil {
+		return Profile{}, err
+	}
+	txn.Set(profileKey(account), data, 0)
+	if err := h.store.Commit(ctx, txn); err != nil {
+		return Profile{}, err
+	}
+	return profile, nil
+}
+
+// moveGuest adds the writes that move guest's profile and matches to
+// account to txn, merging the guest's totals into profile
+func (h *History) moveGuest(ctx context.Context, txn *storage.Txn, guest, account string, profile *Profile) error {
+	guestProfile, found, err := h.profile(ctx, guest)
+	if err != nil {
+		return err
+	}
+	if found {
+		profile.merge(guestProfile)
+	}
+
+	entries, err := h.matchEntries(ctx, guest)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		m, ok, err := h.get(ctx, string(e.Data))
+		if err != nil {
+			return err
+		}
+		// Keep the original expiry
+		ttl := time.Until(m.Ended.Add(config.MatchHistoryTTL))
+		if !ok || ttl <= 0 {
+			continue
+		}
+		for i := range m.Players {
+			if m.Players[i].Name == guest {
+				m.Players[i].Name = account
+			}
+		}
+		for i := range m.Awards {
+			if m.Awards[i].Name == guest {
+				m.Awards[i].Name = account
+			}
+		}
+		if err := h.certify(&m); err != nil {
+			return err
+		}
+		data, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		txn.Set(matchKey(m.ID), data, ttl)
+		txn.XAdd(playerMatchesKey(account), []byte(m.ID), config.MatchHistoryPerPlayer)
+	}
+
+	txn.Delete(profileKey(guest))
+	txn.DeleteStream(playerMatchesKey(guest))
+	return nil
+}
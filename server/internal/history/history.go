@@ -0,0 +1,346 @@
+// Package history keeps finished rounds (matches) and player profiles.
+//
+// A match is stored once as a JSON value under "match:<id>", expiring after
+// config.MatchHistoryTTL, and its ID is appended to the stream
+// "player:<name>:matches" of every player in it, trimmed to the newest
+// config.MatchHistoryPerPlayer. Each player's lifetime totals are kept in
+// the JSON value "player:<name>:profile", updated with every match, kick,
+// prestige reset and season reward.
+// Players flagged in their placement rounds are listed in the stream
+// "placement:review" (see placement.go).
+// Players are identified by name, like on the leaderboard.
+//
+// With a signer (see SetSigner) every match is stored with a certificate of
+// itself (the match without its certificate), renewed when a player in it is
+// renamed (anonymized or linked to an account).
+package history
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/certify"
+	"github.com/race/server/internal/game"
+	"github.com/race/server/internal/storage"
+)
+
+const storeTimeout = 5 * time.Second
+
+// Match is a finished round of a room
+type Match struct {
+	ID string `json:"id"`
+	game.RoundResult
+
+	Certificate *certify.Certificate `json:"certificate,omitempty"` // Kind certify.KindRound
+}
+
+// History stores matches in a shared storage backend
+type History struct {
+	store  storage.Store
+	onFlag func(m Match, player game.RoundPlayer) // See SetOnFlag
+	signer *certify.Signer                        // See SetSigner
+}
+
+// New creates a match history on top of a store
+func New(store storage.Store) *History {
+	return &History{store: store}
+}
+
+// SetSigner makes the history certify the matches it records from now on
+func (h *History) SetSigner(signer *certify.Signer) {
+	h.signer = signer
+}
+
+// certify replaces a match's certificate with one of its current content
+func (h *History) certify(m *Match) error {
+	m.Certificate = nil
+	if h.signer == nil {
+		return nil
+	}
+	cert, err := h.signer.Sign(certify.KindRound, m)
+	if err != nil {
+		return err
+	}
+	m.Certificate = cert
+	return nil
+}
+
+func matchKey(id string) string {
+	return "match:" + id
+}
+
+func playerMatchesKey(name string) string {
+	return "player:" + name + ":matches"
+}
+
+func profileKey(name string) string {
+	return "player:" + name + ":profile"
+}
+
+// Record stores a finished round and adds it to the history of its players
+func (h *History) Record(result game.RoundResult) (Match, error) {
+	m, err := h.NewMatch(result)
+	if err != nil {
+		return Match{}, err
+	}
+	if err := h.Save(m); err != nil {
+		return Match{}, err
+	}
+	return m, nil
+}
+
+// NewMatch gives a finished round its match ID and certificate, for Save
+func (h *History) NewMatch(result game.RoundResult) (Match, error) {
+	b := make([]byte, 8)
+	rand.Read(b)
+	m := Match{ID: hex.EncodeToString(b), RoundResult: result}
+	if err := h.certify(&m); err != nil {
+		return Match{}, err
+	}
+	return m, nil
+}
+
+// Save stores a match and adds it to the history of its players. Saving a
+// match again overwrites it, but adds it to the histories and profiles again.
+func (h *History) Save(m Match) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	if err := h.store.Set(ctx, matchKey(m.ID), data, config.MatchHistoryTTL); err != nil {
+		return err
+	}
+	for _, p := range m.Players {
+		if _, err := h.store.XAdd(ctx, playerMatchesKey(p.Name), []byte(m.ID), config.MatchHistoryPerPlayer); err != nil {
+			return err
+		}
+		flagged := false
+		err := h.updateProfile(ctx, p.Name, func(profile *Profile) {
+			flagged = profile.assessPlacement(p, m.Ended)
+			profile.addRound(p, m.Awards, m.Ended)
+		})
+		if err != nil {
+			return err
+		}
+		if !flagged {
+			continue
+		}
+		if err := h.flag(ctx, p.Name); err != nil {
+			return err
+		}
+		if h.onFlag != nil {
+			h.onFlag(m, p)
+		}
+	}
+	return nil
+}
+
+// RecordKick adds a kick at a time to a player's moderation record; the
+// player can't rejoin for cooldown after it.
+func (h *History) RecordKick(name, reason string, cooldown time.Duration, at time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	now := at.UTC()
+	return h.updateProfile(ctx, name, func(p *Profile) {
+		if p.Moderation == nil {
+			p.Moderation = &Moderation{}
+		}
+		p.Moderation.Kicks++
+		p.Moderation.LastKick = now
+		p.Moderation.LastKickReason = reason
+		p.Moderation.CooldownUntil = now.Add(cooldown)
+	})
+}
+
+// RecordPrestige banks the total score of runs the player reset for
+// prestige, the last at a time
+func (h *History) RecordPrestige(name string, resets int, score float64, at time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	now := at.UTC()
+	return h.updateProfile(ctx, name, func(p *Profile) {
+		p.Prestiges += resets
+		p.BankedScore += score
+		if p.FirstSeen.IsZero() {
+			p.FirstSeen = now
+		}
+		p.LastSeen = now
+	})
+}
+
+// RecordSeasonReward adds a season reward to a player's profile, unless it
+// has the reward of that season already
+func (h *History) RecordSeasonReward(name string, reward SeasonReward) error {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	return h.updateProfile(ctx, name, func(p *Profile) {
+		for _, r := range p.SeasonRewards {
+			if r.Tenant == reward.Tenant && r.Season == reward.Season {
+				return
+			}
+		}
+		p.SeasonRewards = append(p.SeasonRewards, reward)
+	})
+}
+
+// Profile returns a player's lifetime totals, or false if the player has no
+// recorded round, kick, prestige or season reward
+func (h *History) Profile(name string) (Profile, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	return h.profile(ctx, name)
+}
+
+func (h *History) profile(ctx context.Context, name string) (Profile, bool, error) {
+	data, ok, err := h.store.Get(ctx, profileKey(name))
+	if err != nil || !ok {
+		return Profile{Name: name}, false, err
+	}
+	var p Profile
+	if err := json.Unmarshal(data, &p); err != nil {
+		return Profile{}, false, err
+	}
+	return p, true, nil
+}
+
+// updateProfile applies update to a player's profile. This is a plain
+// read-modify-write: if two rooms end a round with the same name at the same
+// moment, one update may be lost, which profiles (informational) tolerate.
+func (h *History) updateProfile(ctx context.Context, name string, update func(p *Profile)) error {
+	p, _, err := h.profile(ctx, name)
+	if err != nil {
+		return err
+	}
+	update(&p)
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return h.store.Set(ctx, profileKey(name), data, 0)
+}
+
+// Get returns a match by ID
+func (h *History) Get(id string) (Match, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	return h.get(ctx, id)
+}
+
+func (h *History) get(ctx context.Context, id string) (Match, bool, error) {
+	data, ok, err := h.store.Get(ctx, matchKey(id))
+	if err != nil || !ok {
+		return Match{}, false, err
+	}
+	var m Match
+	if err := json.Unmarshal(data, &m); err != nil {
+		return Match{}, false, err
+	}
+	return m, true, nil
+}
+
+// Recent returns up to n of a player's latest matches, newest first.
+// Expired matches are left out.
+func (h *History) Recent(name string, n int) ([]Match, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	entries, err := h.matchEntries(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]Match, 0, min(n, len(entries)))
+	for i := len(entries) - 1; i >= 0 && len(matches) < n; i-- {
+		m, ok, err := h.get(ctx, string(entries[i].Data))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matches = append(matches, m)
+		}
+	}
+	return matches, nil
+}
+
+// matchEntries returns a player's whole match stream, oldest first
+func (h *History) matchEntries(ctx context.Context, name string) ([]storage.StreamEntry, error) {
+	// Streams are only trimmed roughly, so page through to the end
+	var entries []storage.StreamEntry
+	after := ""
+	for {
+		page, err := h.store.XRange(ctx, playerMatchesKey(name), after, 100)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, page...)
+		if len(page) < 100 {
+			return entries, nil
+		}
+		after = page[len(page)-1].ID
+	}
+}
+
+// Anonymize deletes a player's profile, privacy preferences and match list,
+// and replaces their name with alias in the matches they drove in, so the
+// rounds keep their stats for everyone else. Returns the number of matches rewritten.
+func (h *History) Anonymize(name, alias string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	entries, err := h.matchEntries(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+
+	rewritten := 0
+	for _, e := range entries {
+		m, ok, err := h.get(ctx, string(e.Data))
+		if err != nil {
+			return rewritten, err
+		}
+		// Keep the original expiry
+		ttl := time.Until(m.Ended.Add(config.MatchHistoryTTL))
+		if !ok || ttl <= 0 {
+			continue
+		}
+		for i := range m.Players {
+			if m.Players[i].Name == name {
+				m.Players[i].Name = alias
+			}
+		}
+		for i := range m.Awards {
+			if m.Awards[i].Name == name {
+				m.Awards[i].Name = alias
+			}
+		}
+		if err := h.certify(&m); err != nil {
+			return rewritten, err
+		}
+		data, err := json.Marshal(m)
+		if err != nil {
+			return rewritten, err
+		}
+		if err := h.store.Set(ctx, matchKey(m.ID), data, ttl); err != nil {
+			return rewritten, err
+		}
+		rewritten++
+	}
+
+	if err := h.store.DeleteStream(ctx, playerMatchesKey(name)); err != nil {
+		return rewritten, err
+	}
+	if err := h.store.Delete(ctx, privacyKey(name)); err != nil {
+		return rewritten, err
+	}
+	return rewritten, h.store.Delete(ctx, profileKey(name))
+}
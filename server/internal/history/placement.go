@@ -0,0 +1,160 @@
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/game"
+	"github.com/race/server/internal/storage"
+)
+
+// Placement review
+//
+// A name's first config.PlacementRounds recorded rounds are its placement:
+// new drivers are assumed to drive like the average one, which is what the
+// room's skill sampling counts them as. An experienced player on a fresh
+// name (a smurf) drives far above that. A placement round stands out when
+// rating came in at a rate only sustained top speed gives, together with a
+// clean run (next to no crashes) or a steady one (speed barely varies).
+// After config.PlacementStandouts standout rounds the player is flagged:
+// the flag goes to the moderators' review list, and the OnFlag callback
+// lets the server calibrate the player's skill in their room so their
+// ratings stop inflating everyone else's scores. A flag is a lead for a
+// moderator, not a verdict; nothing is taken from the player.
+
+// placementReviewStream lists the names flagged for review, oldest first
+const placementReviewStream = "placement:review"
+
+// Placement is what stood out in a player's placement rounds
+type Placement struct {
+	Standouts int       `json:"standouts"`          // Placement rounds far above expectations
+	Signals   []string  `json:"signals"`            // What stood out, per standout round
+	Flagged   time.Time `json:"flagged,omitempty"`  // Flagged for review
+	Reviewed  time.Time `json:"reviewed,omitempty"` // Cleared by a moderator
+}
+
+// Pending reports whether the flag awaits review
+func (p *Placement) Pending() bool {
+	return p != nil && !p.Flagged.IsZero() && p.Reviewed.IsZero()
+}
+
+// Flagged is a player flagged in placement
+type Flagged struct {
+	Name      string    `json:"name"`
+	Rounds    int       `json:"rounds"`
+	Placement Placement `json:"placement"`
+}
+
+// SetOnFlag sets a callback function called when a round flags one of its
+// players, with the round and the player.
+func (h *History) SetOnFlag(callback func(m Match, player game.RoundPlayer)) {
+	h.onFlag = callback
+}
+
+// standout describes what stood out in a placement round, or returns ""
+// for a round within expectations
+func standout(r game.RoundPlayer) string {
+	if r.Driving < config.PlacementMinDriving || r.Distance <= 0 {
+		return ""
+	}
+	rate := r.Rating / r.Driving
+	crashRate := float64(r.Crashes) * 100000 / r.Distance
+	clean := crashRate <= config.PlacementMaxCrashRate
+	steady := r.SpeedSpread > 0 && r.SpeedSpread < config.PlacementMaxSpeedSpread
+	if rate < config.PlacementRatingRate || (!clean && !steady) {
+		return ""
+	}
+	return fmt.Sprintf("%.0f rating/s, %.1f crashes per 100k units, speed spread %.3f", rate, crashRate, r.SpeedSpread)
+}
+
+// assessPlacement checks a round of a player still in placement. Called
+// before the round is added; returns true if the round flags the player.
+func (p *Profile) assessPlacement(r game.RoundPlayer, ended time.Time) bool {
+	if p.Rounds >= config.PlacementRounds {
+		return false
+	}
+	signal := standout(r)
+	if signal == "" {
+		return false
+	}
+	if p.Moderation == nil {
+		p.Moderation = &Moderation{}
+	}
+	if p.Moderation.Placement == nil {
+		p.Moderation.Placement = &Placement{}
+	}
+	placement := p.Moderation.Placement
+	placement.Standouts++
+	placement.Signals = append(placement.Signals, signal)
+	if placement.Standouts < config.PlacementStandouts || !placement.Flagged.IsZero() {
+		return false
+	}
+	placement.Flagged = ended.UTC()
+	return true
+}
+
+// flag adds a flagged player to the review list
+func (h *History) flag(ctx context.Context, name string) error {
+	_, err := h.store.XAdd(ctx, placementReviewStream, []byte(name), config.PlacementReviewMax)
+	return err
+}
+
+// PendingReview returns the flagged players awaiting review, newest first.
+// Players deleted since are left out.
+func (h *History) PendingReview() ([]Flagged, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	var entries []storage.StreamEntry
+	after := ""
+	for {
+		page, err := h.store.XRange(ctx, placementReviewStream, after, 100)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, page...)
+		if len(page) < 100 {
+			break
+		}
+		after = page[len(page)-1].ID
+	}
+
+	flagged := []Flagged{}
+	seen := make(map[string]bool)
+	for i := len(entries) - 1; i >= 0; i-- {
+		name := string(entries[i].Data)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		p, ok, err := h.profile(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if ok && p.Moderation != nil && p.Moderation.Placement.Pending() {
+			flagged = append(flagged, Flagged{Name: name, Rounds: p.Rounds, Placement: *p.Moderation.Placement})
+		}
+	}
+	return flagged, nil
+}
+
+// Review clears a player's pending placement flag. Returns false if there
+// was none.
+func (h *History) Review(name string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	p, ok, err := h.profile(ctx, name)
+	if err != nil || !ok || p.Moderation == nil || !p.Moderation.Placement.Pending() {
+		return false, err
+	}
+	p.Moderation.Placement.Reviewed = time.Now().UTC()
+	data, err := json.Marshal(p)
+	if err != nil {
+		return false, err
+	}
+	return true, h.store.Set(ctx, profileKey(name), data, 0)
+}
@@ -0,0 +1,55 @@
+package history
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Privacy preferences
+//
+// Accounts choose what of their driving the server may record, kept as the
+// JSON value "player:<name>:privacy" and set by the account service through
+// the admin API. Names without stored preferences get DefaultPrivacy.
+// Deleting a player's data deletes their preferences too.
+
+// Privacy is what an account lets the server record
+type Privacy struct {
+	// RecordReplays lets practice rooms record the account's attempts for
+	// the replay car (in the room's memory only, never stored or shared)
+	RecordReplays bool `json:"recordReplays"`
+}
+
+// DefaultPrivacy is the preferences of names that never set any
+var DefaultPrivacy = Privacy{RecordReplays: true}
+
+func privacyKey(name string) string {
+	return "player:" + name + ":privacy"
+}
+
+// Privacy returns the privacy preferences of an account
+func (h *History) Privacy(ctx context.Context, name string) (Privacy, error) {
+	ctx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+
+	data, ok, err := h.store.Get(ctx, privacyKey(name))
+	if err != nil || !ok {
+		return DefaultPrivacy, err
+	}
+	p := DefaultPrivacy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return DefaultPrivacy, err
+	}
+	return p, nil
+}
+
+// SetPrivacy replaces the privacy preferences of an account
+func (h *History) SetPrivacy(ctx context.Context, name string, p Privacy) error {
+	ctx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return h.store.Set(ctx, privacyKey(name), data, 0)
+}
@@ -0,0 +1,161 @@
+package history
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"github.com/race/server/internal/game"
+)
+
+// Profile is a player's lifetime totals over their recorded rounds
+type Profile struct {
+	Name       string         `json:"name"`
+	Rounds     int            `json:"rounds"`
+	Distance   float64        `json:"distance"`
+	BestRating float64        `json:"bestRating"` // Highest run rating
+	Overtakes  int            `json:"overtakes"`
+	Crashes    int            `json:"crashes"`
+	Awards     map[string]int `json:"awards,omitempty"` // Count per award kind
+	FirstSeen  time.Time      `json:"firstSeen"`
+	LastSeen   time.Time      `json:"lastSeen"`
+	Guests     []string       `json:"guests,omitempty"` // Guest names linked into this account
+
+	// Endless-mode runs reset for prestige, and the sum of their scores
+	Prestiges   int     `json:"prestiges,omitempty"`
+	BankedScore float64 `json:"bankedScore,omitempty"`
+
+	// Rewards of final leaderboard standings, oldest season first
+	SeasonRewards []SeasonReward `json:"seasonRewards,omitempty"`
+
+	// For moderators only (nil without kicks or standout placement rounds)
+	Moderation *Moderation `json:"moderation,omitempty"`
+}
+
+// Moderation is a player's record of anti-cheat and host kicks, and of
+// placement rounds far above expectations
+type Moderation struct {
+	Kicks          int        `json:"kicks"`
+	LastKick       time.Time  `json:"lastKick,omitempty"`
+	LastKickReason string     `json:"lastKickReason,omitempty"`
+	CooldownUntil  time.Time  `json:"cooldownUntil,omitempty"` // Rejoin blocked until (by address)
+	Placement      *Placement `json:"placement,omitempty"`     // Nil without standout placement rounds
+}
+
+// SeasonReward is a reward for a player's final standing in a leaderboard
+// season (see leaderboard.SeasonRewards)
+type SeasonReward struct {
+	Tenant string `json:"tenant,omitempty"` // Of the leaderboard ("": the default)
+	Season int64  `json:"season"`
+	Rank   int    `json:"rank"`
+	Award  string `json:"award"`
+}
+
+// addRound adds a round the player drove in
+func (p *Profile) addRound(r game.RoundPlayer, awards []game.Award, ended time.Time) {
+	p.Rounds++
+	p.Distance += r.Distance
+	if r.BestRun > p.BestRating {
+		p.BestRating = r.BestRun
+	}
+	p.Overtakes += r.Overtakes
+	p.Crashes += r.Crashes
+	for _, a := range awards {
+		if a.Name == r.Name {
+			if p.Awards == nil {
+				p.Awards = make(map[string]int)
+			}
+			p.Awards[a.Kind]++
+		}
+	}
+	if p.FirstSeen.IsZero() {
+		p.FirstSeen = ended.UTC()
+	}
+	p.LastSeen = ended.UTC()
+}
+
+// merge adds the totals of another profile, a guest linked to this account.
+// The moderation record follows too, so a link doesn't clear kicks.
+func (p *Profile) merge(o Profile) {
+	p.Rounds += o.Rounds
+	p.Distance += o.Distance
+	p.BestRating = max(p.BestRating, o.BestRating)
+	p.Overtakes += o.Overtakes
+	p.Crashes += o.Crashes
+	p.Prestiges += o.Prestiges
+	p.BankedScore += o.BankedScore
+	p.SeasonRewards = append(p.SeasonRewards, o.SeasonRewards...)
+	slices.SortStableFunc(p.SeasonRewards, func(a, b SeasonReward) int { return cmp.Compare(a.Season, b.Season) })
+	for kind, n := range o.Awards {
+		if p.Awards == nil {
+			p.Awards = make(map[string]int)
+		}
+		p.Awards[kind] += n
+	}
+	if !o.FirstSeen.IsZero() && (p.FirstSeen.IsZero() || o.FirstSeen.Before(p.FirstSeen)) {
+		p.FirstSeen = o.FirstSeen
+	}
+	if o.LastSeen.After(p.LastSeen) {
+		p.LastSeen = o.LastSeen
+	}
+
+	if o.Moderation == nil {
+		return
+	}
+	if p.Moderation == nil {
+		p.Moderation = &Moderation{}
+	}
+	p.Moderation.Kicks += o.Moderation.Kicks
+	if o.Moderation.LastKick.After(p.Moderation.LastKick) {
+		p.Moderation.LastKick = o.Moderation.LastKick
+		p.Moderation.LastKickReason = o.Moderation.LastKickReason
+	}
+	if o.Moderation.CooldownUntil.After(p.Moderation.CooldownUntil) {
+		p.Moderation.CooldownUntil = o.Moderation.CooldownUntil
+	}
+	if p.Moderation.Placement == nil || o.Moderation.Placement.Pending() {
+		p.Moderation.Placement = o.Moderation.Placement
+	}
+}
+
+// achievements are milestones derived from a profile, in display order
+var achievements = []struct {
+	id      string
+	reached func(p Profile) bool
+}{
+	{"first-round", func(p Profile) bool { return p.Rounds >= 1 }},
+	{"veteran", func(p Profile) bool { return p.Rounds >= 100 }},
+	{"distance-100k", func(p Profile) bool { return p.Distance >= 100_000 }},
+	{"distance-1m", func(p Profile) bool { return p.Distance >= 1_000_000 }},
+	{"overtaker", func(p Profile) bool { return p.Overtakes >= 100 }},
+	{"first-award", func(p Profile) bool { return len(p.Awards) > 0 }},
+	{"mvp", func(p Profile) bool { return p.Awards[game.AwardMVP] > 0 }},
+	{"all-rounder", func(p Profile) bool { return len(p.Awards) == 5 }},
+	{"prestige", func(p Profile) bool { return p.Prestiges >= 1 }},
+	{"season-top100", func(p Profile) bool { return p.bestSeasonRank() > 0 }},
+	{"season-top10", func(p Profile) bool { return p.bestSeasonRank() > 0 && p.bestSeasonRank() <= 10 }},
+	{"season-champion", func(p Profile) bool { return p.bestSeasonRank() == 1 }},
+}
+
+// bestSeasonRank returns the best final rank of the player's season rewards
+// (0: none)
+func (p Profile) bestSeasonRank() int {
+	best := 0
+	for _, r := range p.SeasonRewards {
+		if best == 0 || r.Rank < best {
+			best = r.Rank
+		}
+	}
+	return best
+}
+
+// Achievements returns the IDs of the milestones the player reached
+func (p Profile) Achievements() []string {
+	reached := []string{}
+	for _, a := range achievements {
+		if a.reached(p) {
+			reached = append(reached, a.id)
+		}
+	}
+	return reached
+}
@@ -0,0 +1,229 @@
+package leaderboard
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/race/server/internal/storage"
+)
+
+// ErrSeasonNotFound is returned for unknown archived seasons
+var ErrSeasonNotFound = errors.New("season not found")
+
+// FileArchive stores seasons as JSON files in a directory:
+// season-<id>.json for finished seasons and live.json for the current board.
+type FileArchive struct {
+	dir string
+}
+
+// NewFileArchive creates the archive directory if needed
+func NewFileArchive(dir string) (*FileArchive, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileArchive{dir: dir}, nil
+}
+
+// SaveSeason implements Archive
+func (a *FileArchive) SaveSeason(season ArchivedSeason) error {
+	return a.write(fmt.Sprintf("season-%d.json", season.Season.ID), season)
+}
+
+// ListSeasons implements Archive, newest first
+func (a *FileArchive) ListSeasons() ([]Season, error) {
+	files, err := os.ReadDir(a.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var seasons []Season
+	for _, f := range files {
+		name := f.Name()
+		if !strings.HasPrefix(name, "season-") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		id, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(name, "season-"), ".json"), 10, 64)
+		if err != nil {
+			continue
+		}
+		archived, err := a.LoadSeason(id)
+		if err != nil {
+			return nil, err
+		}
+		seasons = append(seasons, archived.Season)
+	}
+
+	sort.Slice(seasons, func(i, j int) bool { return seasons[i].ID > seasons[j].ID })
+	return seasons, nil
+}
+
+// LoadSeason implements Archive
+func (a *FileArchive) LoadSeason(id int64) (ArchivedSeason, error) {
+	var season ArchivedSeason
+	ok, err := a.read(fmt.Sprintf("season-%d.json", id), &season)
+	if err == nil && !ok {
+		err = ErrSeasonNotFound
+	}
+	return season, err
+}
+
+// SaveLive implements Archive
+func (a *FileArchive) SaveLive(season ArchivedSeason) error {
+	return a.write("live.json", season)
+}
+
+// LoadLive implements Archive
+func (a *FileArchive) LoadLive() (ArchivedSeason, bool, error) {
+	var season ArchivedSeason
+	ok, err := a.read("live.json", &season)
+	return season, ok, err
+}
+
+// write atomically replaces a file with v encoded as JSON
+func (a *FileArchive) write(name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(a.dir, name+".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(a.dir, name))
+}
+
+// read decodes a file into v. Returns false if the file does not exist.
+func (a *FileArchive) read(name string, v interface{}) (bool, error) {
+	data, err := os.ReadFile(filepath.Join(a.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+// StoreArchive stores seasons in a shared storage backend, so every server
+// of a cluster sees the same archive. Seasons are JSON values under
+// "leaderboard:season:<id>", indexed by the sorted set "leaderboard:seasons".
+type StoreArchive struct {
+	store  storage.Store
+	prefix string // "leaderboard:", or "leaderboard:<namespace>:"
+}
+
+const storeTimeout = 5 * time.Second
+
+// NewStoreArchive creates an archive on top of a store
+func NewStoreArchive(store storage.Store) *StoreArchive {
+	return NewStoreArchiveNamespace(store, "")
+}
+
+// NewStoreArchiveNamespace creates an archive whose keys are kept apart from
+// other namespaces' (e.g. tenants sharing a store). The empty namespace is
+// the one of NewStoreArchive.
+func NewStoreArchiveNamespace(store storage.Store, namespace string) *StoreArchive {
+	prefix := "leaderboard:"
+	if namespace != "" {
+		prefix += namespace + ":"
+	}
+	return &StoreArchive{store: store, prefix: prefix}
+}
+
+func (a *StoreArchive) seasonIndexKey() string {
+	return a.prefix + "seasons"
+}
+
+func (a *StoreArchive) liveKey() string {
+	return a.prefix + "live"
+}
+
+func (a *StoreArchive) seasonKey(id int64) string {
+	return fmt.Sprintf("%sseason:%d", a.prefix, id)
+}
+
+// SaveSeason implements Archive
+func (a *StoreArchive) SaveSeason(season ArchivedSeason) error {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	data, err := json.Marshal(season)
+	if err != nil {
+		return err
+	}
+	if err := a.store.Set(ctx, a.seasonKey(season.Season.ID), data, 0); err != nil {
+		return err
+	}
+	id := season.Season.ID
+	return a.store.ZAdd(ctx, a.seasonIndexKey(), strconv.FormatInt(id, 10), float64(id))
+}
+
+// ListSeasons implements Archive, newest first
+func (a *StoreArchive) ListSeasons() ([]Season, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	ids, err := a.store.ZRevRange(ctx, a.seasonIndexKey(), 0, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	seasons := make([]Season, 0, len(ids))
+	for _, m := range ids {
+		archived, err := a.LoadSeason(int64(m.Score))
+		if err != nil {
+			return nil, err
+		}
+		seasons = append(seasons, archived.Season)
+	}
+	return seasons, nil
+}
+
+// LoadSeason implements Archive
+func (a *StoreArchive) LoadSeason(id int64) (ArchivedSeason, error) {
+	var season ArchivedSeason
+	ok, err := a.read(a.seasonKey(id), &season)
+	if err == nil && !ok {
+		err = ErrSeasonNotFound
+	}
+	return season, err
+}
+
+// SaveLive implements Archive
+func (a *StoreArchive) SaveLive(season ArchivedSeason) error {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	data, err := json.Marshal(season)
+	if err != nil {
+		return err
+	}
+	return a.store.Set(ctx, a.liveKey(), data, 0)
+}
+
+// LoadLive implements Archive
+func (a *StoreArchive) LoadLive() (ArchivedSeason, bool, error) {
+	var season ArchivedSeason
+	ok, err := a.read(a.liveKey(), &season)
+	return season, ok, err
+}
+
+// read decodes a stored value into v. Returns false if the key does not exist.
+func (a *StoreArchive) read(key string, v interface{}) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	data, ok, err := a.store.Get(ctx, key)
+	if err != nil || !ok {
+		return false, err
+	}
+	return true, json.Unmarshal(data, v)
+}
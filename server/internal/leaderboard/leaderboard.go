@@ -0,0 +1,409 @@
+// Package leaderboard keeps the global high-score board and its seasons.
+//
+// The live board holds each player's best run of the current season. When the
+// season ends the board is archived, season rewards are computed from the
+// final standings, and a fresh board starts.
+//
+// With a Signer every entry carries a certificate of its Submission, renewed
+// when the entry changes hands (Rename, Merge).
+package leaderboard
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/certify"
+)
+
+// Entry is a player's best score on a board
+type Entry struct {
+	Name  string    `json:"name"`
+	Score float64   `json:"score"`
+	At    time.Time `json:"at"`
+
+	Certificate *certify.Certificate `json:"certificate,omitempty"` // Kind certify.KindSubmission
+}
+
+// Submission is the certified content of an entry
+type Submission struct {
+	Board  string    `json:"board"` // Tenant key ("" for the default board)
+	Season int64     `json:"season"`
+	Name   string    `json:"name"`
+	Score  float64   `json:"score"`
+	At     time.Time `json:"at"`
+}
+
+// Season identifies a leaderboard season
+type Season struct {
+	ID    int64     `json:"id"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Reward is granted to a player for their final season standing
+type Reward struct {
+	Name  string `json:"name"`
+	Rank  int    `json:"rank"`
+	Award string `json:"award"`
+}
+
+// ArchivedSeason is a finished season with its final standings
+type ArchivedSeason struct {
+	Season  Season   `json:"season"`
+	Entries []Entry  `json:"entries"`
+	Rewards []Reward `json:"rewards"`
+}
+
+// Archive stores finished seasons and the live board
+type Archive interface {
+	SaveSeason(season ArchivedSeason) error
+	ListSeasons() ([]Season, error)
+	LoadSeason(id int64) (ArchivedSeason, error)
+
+	SaveLive(season ArchivedSeason) error
+	LoadLive() (ArchivedSeason, bool, error)
+}
+
+// Board is the live leaderboard of the current season
+type Board struct {
+	mu      sync.RWMutex
+	season  Season
+	entries map[string]Entry
+	dirty   bool
+
+	length  time.Duration
+	epoch   time.Time
+	archive Archive
+
+	// OnRewards is called with the rewards of each finished season
+	OnRewards func(season Season, rewards []Reward)
+
+	// Signer, if set, certifies the entries submitted from now on as
+	// entries of the board named Tenant
+	Signer *certify.Signer
+	Tenant string
+}
+
+// NewBoard creates a board with seasons of the given length, aligned to epoch.
+// The live board is restored from the archive if it belongs to the current season.
+func NewBoard(length time.Duration, epoch time.Time, archive Archive) *Board {
+	b := &Board{
+		entries: make(map[string]Entry),
+		length:  length,
+		epoch:   epoch,
+		archive: archive,
+	}
+	b.season = b.seasonAt(time.Now())
+
+	if live, ok, err := archive.LoadLive(); err != nil {
+		log.Printf("Failed to load live leaderboard: %v", err)
+	} else if ok {
+		if live.Season.ID == b.season.ID {
+			for _, e := range live.Entries {
+				b.entries[e.Name] = e
+			}
+		} else {
+			// The server was down across a season boundary: close that season now
+			b.archiveSeason(live)
+		}
+	}
+
+	return b
+}
+
+// seasonAt returns the season containing t
+func (b *Board) seasonAt(t time.Time) Season {
+	id := int64(t.Sub(b.epoch) / b.length)
+	start := b.epoch.Add(time.Duration(id) * b.length)
+	return Season{ID: id, Start: start, End: start.Add(b.length)}
+}
+
+// Submit records a finished run; only a player's best run is kept.
+func (b *Board) Submit(name string, score float64) {
+	if score <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if existing, ok := b.entries[name]; ok && existing.Score >= score {
+		return
+	}
+	b.entries[name] = b.certify(b.season, Entry{Name: name, Score: score, At: time.Now()})
+	b.dirty = true
+}
+
+// certify returns an entry of a season with a certificate of its content
+func (b *Board) certify(season Season, e Entry) Entry {
+	e.Certificate = nil
+	if b.Signer == nil {
+		return e
+	}
+	cert, err := b.Signer.Sign(certify.KindSubmission, Submission{
+		Board:  b.Tenant,
+		Season: season.ID,
+		Name:   e.Name,
+		Score:  e.Score,
+		At:     e.At,
+	})
+	if err != nil {
+		log.Printf("Failed to certify the leaderboard entry of %s: %v", e.Name, err)
+		return e
+	}
+	e.Certificate = cert
+	return e
+}
+
+// Len returns the number of players on the live board
+func (b *Board) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return len(b.entries)
+}
+
+// Season returns the current season
+func (b *Board) Season() Season {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.season
+}
+
+// Top returns the best n entries of the current season
+func (b *Board) Top(n int) []Entry {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return topEntries(b.entries, n)
+}
+
+// Rank returns a player's best entry of the current season and its 1-based
+// rank, or false if the player has no run this season
+func (b *Board) Rank(name string) (Entry, int, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	entry, ok := b.entries[name]
+	if !ok {
+		return Entry{}, 0, false
+	}
+	rank := 1
+	for _, e := range b.entries {
+		if e.Score > entry.Score || (e.Score == entry.Score && e.At.Before(entry.At)) {
+			rank++
+		}
+	}
+	return entry, rank, true
+}
+
+// PlayerSeasons returns the seasons a player has an entry or reward in, with
+// only that player's entries and rewards: the current season first, then the
+// archived ones, newest first
+func (b *Board) PlayerSeasons(name string) ([]ArchivedSeason, error) {
+	var result []ArchivedSeason
+	b.mu.RLock()
+	if e, ok := b.entries[name]; ok {
+		result = append(result, ArchivedSeason{Season: b.season, Entries: []Entry{e}})
+	}
+	b.mu.RUnlock()
+
+	seasons, err := b.archive.ListSeasons()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range seasons {
+		archived, err := b.archive.LoadSeason(s.ID)
+		if err != nil {
+			return nil, err
+		}
+		own := ArchivedSeason{Season: archived.Season}
+		for _, e := range archived.Entries {
+			if e.Name == name {
+				own.Entries = append(own.Entries, e)
+			}
+		}
+		for _, rw := range archived.Rewards {
+			if rw.Name == name {
+				own.Rewards = append(own.Rewards, rw)
+			}
+		}
+		if len(own.Entries) > 0 || len(own.Rewards) > 0 {
+			result = append(result, own)
+		}
+	}
+	return result, nil
+}
+
+// Rename moves a player's entries to another name, live and archived (e.g.
+// to anonymize a player who asked for deletion); standings stay the same.
+func (b *Board) Rename(name, to string) error {
+	b.mu.Lock()
+	if e, ok := b.entries[name]; ok {
+		delete(b.entries, name)
+		e.Name = to
+		b.entries[to] = b.certify(b.season, e)
+		b.dirty = true
+	}
+	b.mu.Unlock()
+
+	seasons, err := b.archive.ListSeasons()
+	if err != nil {
+		return err
+	}
+	for _, s := range seasons {
+		archived, err := b.archive.LoadSeason(s.ID)
+		if err != nil {
+			return err
+		}
+		changed := false
+		for i := range archived.Entries {
+			if archived.Entries[i].Name == name {
+				archived.Entries[i].Name = to
+				archived.Entries[i] = b.certify(archived.Season, archived.Entries[i])
+				changed = true
+			}
+		}
+		for i := range archived.Rewards {
+			if archived.Rewards[i].Name == name {
+				archived.Rewards[i].Name = to
+				changed = true
+			}
+		}
+		if changed {
+			if err := b.archive.SaveSeason(archived); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Merge moves a player's entry of the current season to another name, which
+// keeps the better of the two (a guest linked to an account). Archived
+// seasons keep the name their runs were driven under.
+func (b *Board) Merge(name, into string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e, ok := b.entries[name]
+	if !ok || name == into {
+		return
+	}
+	delete(b.entries, name)
+	if existing, ok := b.entries[into]; !ok || e.Score > existing.Score {
+		e.Name = into
+		b.entries[into] = b.certify(b.season, e)
+	}
+	b.dirty = true
+}
+
+// Seasons returns the archived seasons, newest first
+func (b *Board) Seasons() ([]Season, error) {
+	return b.archive.ListSeasons()
+}
+
+// ArchivedSeason returns the final standings of an archived season
+func (b *Board) ArchivedSeason(id int64) (ArchivedSeason, error) {
+	return b.archive.LoadSeason(id)
+}
+
+// Tick persists the live board and rolls the season over when it has ended.
+// Call periodically.
+func (b *Board) Tick(now time.Time) {
+	b.mu.Lock()
+	b.trimUnlocked()
+	if now.Before(b.season.End) {
+		var snapshot *ArchivedSeason
+		if b.dirty {
+			snapshot = &ArchivedSeason{Season: b.season, Entries: topEntries(b.entries, 0)}
+			b.dirty = false
+		}
+		b.mu.Unlock()
+
+		if snapshot != nil {
+			if err := b.archive.SaveLive(*snapshot); err != nil {
+				log.Printf("Failed to save live leaderboard: %v", err)
+			}
+		}
+		return
+	}
+
+	finished := ArchivedSeason{Season: b.season, Entries: topEntries(b.entries, 0)}
+	b.season = b.seasonAt(now)
+	b.entries = make(map[string]Entry)
+	b.dirty = true
+	b.mu.Unlock()
+
+	b.archiveSeason(finished)
+}
+
+// trimUnlocked drops the lowest entries beyond the board's capacity, so a
+// season with many one-off players can't grow the board without bound.
+// IMPORTANT: Caller must hold b.mu.
+func (b *Board) trimUnlocked() {
+	if len(b.entries) <= config.LeaderboardMaxEntries {
+		return
+	}
+	for _, e := range topEntries(b.entries, 0)[config.LeaderboardMaxEntries:] {
+		delete(b.entries, e.Name)
+	}
+	b.dirty = true
+}
+
+// archiveSeason stores a finished season and grants its rewards
+func (b *Board) archiveSeason(finished ArchivedSeason) {
+	finished.Rewards = SeasonRewards(finished.Entries)
+	if err := b.archive.SaveSeason(finished); err != nil {
+		log.Printf("Failed to archive season %d: %v", finished.Season.ID, err)
+		return
+	}
+	log.Printf("Season %d archived: %d players, %d rewards", finished.Season.ID, len(finished.Entries), len(finished.Rewards))
+
+	if b.OnRewards != nil && len(finished.Rewards) > 0 {
+		b.OnRewards(finished.Season, finished.Rewards)
+	}
+}
+
+// SeasonRewards computes rewards from final standings (entries sorted best first)
+func SeasonRewards(entries []Entry) []Reward {
+	var rewards []Reward
+	for i, e := range entries {
+		rank := i + 1
+		var award string
+		switch {
+		case rank == 1:
+			award = "season_champion"
+		case rank <= 10:
+			award = "season_top10"
+		case rank <= 100:
+			award = "season_top100"
+		default:
+			return rewards
+		}
+		rewards = append(rewards, Reward{Name: e.Name, Rank: rank, Award: award})
+	}
+	return rewards
+}
+
+// topEntries sorts entries best first and returns at most n (all if n <= 0)
+func topEntries(entries map[string]Entry, n int) []Entry {
+	sorted := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		sorted = append(sorted, e)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Score != sorted[j].Score {
+			return sorted[i].Score > sorted[j].Score
+		}
+		return sorted[i].At.Before(sorted[j].At)
+	})
+	if n > 0 && len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
@@ -3,22 +3,52 @@ package matchmaker
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"fmt"
+	"log"
 	"sync"
+	"time"
 
 	"github.com/race/server/config"
 	"github.com/race/server/internal/game"
+	"github.com/race/server/internal/track"
 )
 
 // Matchmaker handles player matchmaking and room assignment
 type Matchmaker struct {
-	mu    sync.RWMutex
-	rooms map[string]*game.Room
+	mu       sync.RWMutex
+	rooms    map[string]*game.Room
+	reserved map[string]time.Time   // Rooms booked for a match, until the reservation expires (see reserve.go)
+	private  map[string]privateRoom // Rooms joined by code, by room ID (see private.go)
+	codes    map[string]string      // Room IDs of the join codes
+
+	// Callbacks installed on every new room
+	onPlayerKick func(player *game.Player, reason string)
+	onRunEnd     func(player *game.Player, score float64)
+	onRoundEnd   func(result game.RoundResult)
+	onPrestige   func(player *game.Player, score float64)
+	onCheater    func(player *game.Player, reason string) bool
+	onQuarantine func(report game.TickBudgetReport)
+
+	suspendEmpty bool // Rooms pause their game loop while empty
+	sequenceMode game.SequenceMode
+	roomConfig   game.RoomConfig
+	publicTrack  *track.Track      // Road of new public rooms (nil: the built-in road)
+	scheduler    *game.Scheduler   // Runs the game loops of new rooms (nil: the default)
+	rules        game.RulesLibrary // Rules named by roomConfig (nil: none available)
+	scoring      game.Scorer       // Scoring of new rooms (nil: the default policy)
+	antiCheat    []game.AntiCheatRule
+	roomIDs      func() string // IDs of new rooms (nil: random)
 }
 
 // NewMatchmaker creates a new matchmaker
 func NewMatchmaker() *Matchmaker {
 	return &Matchmaker{
-		rooms: make(map[string]*game.Room),
+		rooms:        make(map[string]*game.Room),
+		reserved:     make(map[string]time.Time),
+		private:      make(map[string]privateRoom),
+		codes:        make(map[string]string),
+		roomConfig:   game.DefaultRoomConfig(),
+		sequenceMode: game.SequenceDrop,
 	}
 }
 
@@ -27,9 +57,18 @@ func (m *Matchmaker) FindRoom() *game.Room {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	// Find existing room with space
-	for _, room := range m.rooms {
-		if room.GetPlayerCount() < config.MaxPlayersPerRoom {
+	// Find existing room with space (practice, honeypot, reserved and
+	// private rooms are private, quarantined rooms take nobody). Rooms still
+	// on a previous public track are left to empty out.
+	for id, room := range m.rooms {
+		if _, reserved := m.reserved[id]; reserved {
+			continue
+		}
+		if _, private := m.private[id]; private {
+			continue
+		}
+		if !room.Practice() && !room.Honeypot() && !room.Quarantined() && room.Track().Label() == m.publicTrackUnlocked().Label() &&
+			room.GetPlayerCount() < config.MaxPlayersPerRoom {
 			return room
 		}
 	}
@@ -39,14 +78,135 @@ func (m *Matchmaker) FindRoom() *game.Room {
 		return nil // Server full
 	}
 
-	roomID := generateRoomID()
-	room := game.NewRoom(roomID)
-	m.rooms[roomID] = room
+	room := m.newRoomUnlocked(m.newRoomIDUnlocked())
+	if m.publicTrack != nil {
+		room.SetTrack(m.publicTrack)
+	}
+	m.attachRulesUnlocked(room)
+	room.Start()
+
+	return room
+}
+
+// CreatePracticeRoom creates a private practice room for one player, with
+// or without a replay of their best attempt, on road (nil: the built-in
+// road; custom tracks need not be approved). Returns nil if the server has
+// no room to spare.
+func (m *Matchmaker) CreatePracticeRoom(replay bool, road *track.Track) *game.Room {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.rooms) >= config.MaxRoomsPerServer || m.practiceRoomsUnlocked() >= config.PracticeRoomsMax {
+		return nil
+	}
+
+	room := m.newRoomUnlocked(m.newRoomIDUnlocked())
+	room.EnablePractice(replay)
+	if road != nil {
+		room.SetTrack(road)
+	}
+	room.Start()
+
+	return room
+}
+
+// CreateTutorialRoom creates a private tutorial room running the default
+// script. Tutorial rooms count as practice rooms. Returns nil if the server
+// has no room to spare.
+func (m *Matchmaker) CreateTutorialRoom() *game.Room {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.rooms) >= config.MaxRoomsPerServer || m.practiceRoomsUnlocked() >= config.PracticeRoomsMax {
+		return nil
+	}
+
+	room := m.newRoomUnlocked(m.newRoomIDUnlocked())
+	room.EnableTutorial(game.DefaultTutorial)
+	room.Start()
+
+	return room
+}
+
+// HoneypotRoom returns a honeypot room with space for another player (see
+// game/honeypot.go), creating one with config.HoneypotBots bots driving
+// profiles if none has. Nothing played there reaches the leaderboard, the
+// match history or prestige. Returns nil if the server has no room to spare.
+func (m *Matchmaker) HoneypotRoom(profiles []game.BotProfile) *game.Room {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	honeypots := 0
+	for _, room := range m.rooms {
+		if !room.Honeypot() {
+			continue
+		}
+		honeypots++
+		if room.GetPlayerCount() < config.MaxPlayersPerRoom {
+			return room
+		}
+	}
+	if len(m.rooms) >= config.MaxRoomsPerServer || honeypots >= config.HoneypotRoomsMax {
+		return nil
+	}
+
+	// Looks like a public room from the inside
+	room := m.newRoomUnlocked(m.newRoomIDUnlocked())
+	room.EnableHoneypot()
+	room.SetOnRunEnd(nil)
+	room.SetOnRoundEnd(nil)
+	room.SetOnPrestige(nil)
+	if m.publicTrack != nil {
+		room.SetTrack(m.publicTrack)
+	}
+	m.attachRulesUnlocked(room)
 	room.Start()
 
+	for i := 0; i < config.HoneypotBots && len(profiles) > 0; i++ {
+		if _, err := room.AddBot(profiles[i%len(profiles)]); err != nil {
+			break
+		}
+	}
+	log.Printf("Opened honeypot room %s", room.ID)
+	return room
+}
+
+// TrainingRoom returns a new room set up like a public room (config, road,
+// scoring, anti-cheat and rules) that is neither started nor tracked: the
+// caller advances it with Step and drops it when done. Nothing it does
+// reaches the leaderboard or the match history. Used by agent training
+// sessions.
+func (m *Matchmaker) TrainingRoom() *game.Room {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	room := game.NewRoomWithConfig("train-"+generateRoomID(), m.roomConfig)
+	room.SetSequenceMode(m.sequenceMode)
+	if m.scoring != nil {
+		room.SetScoringPolicy(m.scoring)
+	}
+	for _, rule := range m.antiCheat {
+		room.AddAntiCheatRule(rule)
+	}
+	if m.publicTrack != nil {
+		room.SetTrack(m.publicTrack)
+	}
+	m.attachRulesUnlocked(room)
 	return room
 }
 
+// practiceRoomsUnlocked counts the practice rooms.
+// IMPORTANT: Caller must hold the matchmaker lock (read or write).
+func (m *Matchmaker) practiceRoomsUnlocked() int {
+	n := 0
+	for _, room := range m.rooms {
+		if room.Practice() {
+			n++
+		}
+	}
+	return n
+}
+
 // GetRoom gets a room by ID
 func (m *Matchmaker) GetRoom(roomID string) *game.Room {
 	m.mu.RLock()
@@ -68,13 +228,265 @@ func (m *Matchmaker) GetOrCreateRoom(roomID string) *game.Room {
 		return nil
 	}
 
-	room := game.NewRoom(roomID)
-	m.rooms[roomID] = room
+	room := m.newRoomUnlocked(roomID)
+	m.attachRulesUnlocked(room)
 	room.Start()
 
 	return room
 }
 
+// newRoomUnlocked creates and registers a room with the matchmaker's callbacks.
+// IMPORTANT: Caller must hold the matchmaker write lock.
+func (m *Matchmaker) newRoomUnlocked(roomID string) *game.Room {
+	room := game.NewRoomWithConfig(roomID, m.roomConfig)
+	if m.onPlayerKick != nil {
+		room.SetOnPlayerKick(m.onPlayerKick)
+	}
+	if m.onRunEnd != nil {
+		room.SetOnRunEnd(m.onRunEnd)
+	}
+	if m.onRoundEnd != nil {
+		room.SetOnRoundEnd(m.onRoundEnd)
+	}
+	if m.onPrestige != nil {
+		room.SetOnPrestige(m.onPrestige)
+	}
+	if m.onCheater != nil {
+		room.SetOnCheater(m.onCheater)
+	}
+	if m.onQuarantine != nil {
+		room.SetOnQuarantine(m.onQuarantine)
+	}
+	room.SetSuspendWhenEmpty(m.suspendEmpty)
+	room.SetSequenceMode(m.sequenceMode)
+	if m.scheduler != nil {
+		room.SetScheduler(m.scheduler)
+	}
+	if m.scoring != nil {
+		room.SetScoringPolicy(m.scoring)
+	}
+	for _, rule := range m.antiCheat {
+		room.AddAntiCheatRule(rule)
+	}
+	m.rooms[roomID] = room
+	return room
+}
+
+// SetRoomIDs sets where the IDs of rooms created from now on come from (nil:
+// random IDs), e.g. the cluster's room registry, which keeps them unique
+// across servers. ids is called under the matchmaker lock and must not block.
+func (m *Matchmaker) SetRoomIDs(ids func() string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.roomIDs = ids
+}
+
+// newRoomIDUnlocked returns the ID of a new room.
+// IMPORTANT: Caller must hold the matchmaker write lock.
+func (m *Matchmaker) newRoomIDUnlocked() string {
+	if m.roomIDs != nil {
+		return m.roomIDs()
+	}
+	return generateRoomID()
+}
+
+// SetOnPlayerKick sets the kick callback installed on rooms created from now on.
+func (m *Matchmaker) SetOnPlayerKick(callback func(player *game.Player, reason string)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.onPlayerKick = callback
+}
+
+// SetOnRunEnd sets the run callback installed on rooms created from now on.
+func (m *Matchmaker) SetOnRunEnd(callback func(player *game.Player, score float64)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.onRunEnd = callback
+}
+
+// SetOnRoundEnd sets the round callback installed on rooms created from now on.
+func (m *Matchmaker) SetOnRoundEnd(callback func(result game.RoundResult)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.onRoundEnd = callback
+}
+
+// SetOnPrestige sets the prestige callback installed on rooms created from
+// now on.
+func (m *Matchmaker) SetOnPrestige(callback func(player *game.Player, score float64)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.onPrestige = callback
+}
+
+// SetOnCheater sets the callback that takes the players anti-cheat would
+// kick from rooms created from now on (nil: they are kicked).
+func (m *Matchmaker) SetOnCheater(callback func(player *game.Player, reason string) bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.onCheater = callback
+}
+
+// SetOnQuarantine sets the callback that gets the reports of the rooms
+// created from now on that the watchdog quarantines (see game/watchdog.go).
+func (m *Matchmaker) SetOnQuarantine(callback func(report game.TickBudgetReport)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.onQuarantine = callback
+}
+
+// SetScheduler sets the scheduler that runs the game loops of rooms created
+// from now on.
+func (m *Matchmaker) SetScheduler(s *game.Scheduler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.scheduler = s
+}
+
+// SetScoringPolicy sets the scoring of rooms created from now on (nil: the
+// default policy).
+func (m *Matchmaker) SetScoringPolicy(scoring game.Scorer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.scoring = scoring
+}
+
+// SetAntiCheatRules sets the extra anti-cheat checks of rooms created from
+// now on.
+func (m *Matchmaker) SetAntiCheatRules(rules []game.AntiCheatRule) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.antiCheat = rules
+}
+
+// SetRoomConfig sets the config of rooms created from now on. Rules must
+// exist in the rules library.
+func (m *Matchmaker) SetRoomConfig(cfg game.RoomConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if cfg.Rules != "" {
+		if m.rules == nil {
+			return fmt.Errorf("no rules library for rules %q", cfg.Rules)
+		}
+		if _, err := m.rules.New(cfg.Rules); err != nil {
+			return err
+		}
+	}
+	m.roomConfig = cfg
+	return nil
+}
+
+// SetRulesLibrary sets where the rules named by room configs come from.
+func (m *Matchmaker) SetRulesLibrary(lib game.RulesLibrary) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rules = lib
+}
+
+// attachRulesUnlocked gives a new public room the rules of the room config.
+// A script that fails to start is logged and the room races without it.
+// IMPORTANT: Caller must hold the matchmaker write lock.
+func (m *Matchmaker) attachRulesUnlocked(room *game.Room) {
+	if m.roomConfig.Rules == "" || m.rules == nil {
+		return
+	}
+	rules, err := m.rules.New(m.roomConfig.Rules)
+	if err != nil {
+		log.Printf("Room %s starts without rules: %v", room.ID, err)
+		return
+	}
+	room.SetRules(rules)
+}
+
+// RoomConfig returns the config new rooms are created with.
+func (m *Matchmaker) RoomConfig() game.RoomConfig {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.roomConfig
+}
+
+// SetPublicTrack sets the road of public rooms created from now on (nil:
+// the built-in road). Only approved tracks are allowed.
+func (m *Matchmaker) SetPublicTrack(t *track.Track) error {
+	if t != nil && !t.Approved {
+		return track.ErrNotApproved
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.publicTrack = t
+	return nil
+}
+
+// PublicTrack returns the road of new public rooms.
+func (m *Matchmaker) PublicTrack() *track.Track {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.publicTrackUnlocked()
+}
+
+// publicTrackUnlocked returns the road of new public rooms.
+// IMPORTANT: Caller must hold the matchmaker lock (read or write).
+func (m *Matchmaker) publicTrackUnlocked() *track.Track {
+	if m.publicTrack == nil {
+		return track.Default()
+	}
+	return m.publicTrack
+}
+
+// SetSuspendEmptyRooms sets whether rooms created from now on pause their
+// game loop while they have no human players.
+func (m *Matchmaker) SetSuspendEmptyRooms(suspend bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.suspendEmpty = suspend
+}
+
+// SuspendEmptyRooms returns whether new rooms pause while empty.
+func (m *Matchmaker) SuspendEmptyRooms() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.suspendEmpty
+}
+
+// SetSequenceMode sets how rooms created from now on enforce input sequence
+// numbers.
+func (m *Matchmaker) SetSequenceMode(mode game.SequenceMode) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sequenceMode = mode
+}
+
+// SequenceMode returns how new rooms enforce input sequence numbers.
+func (m *Matchmaker) SequenceMode() game.SequenceMode {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.sequenceMode
+}
+
 // RemoveRoom removes a room
 func (m *Matchmaker) RemoveRoom(roomID string) {
 	m.mu.Lock()
@@ -83,6 +495,8 @@ func (m *Matchmaker) RemoveRoom(roomID string) {
 	if room, ok := m.rooms[roomID]; ok {
 		room.Stop()
 		delete(m.rooms, roomID)
+		delete(m.reserved, roomID)
+		m.forgetPrivateUnlocked(roomID)
 	}
 }
 
@@ -91,11 +505,14 @@ func (m *Matchmaker) CleanupEmptyRooms() int {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	now := time.Now()
 	removed := 0
 	for id, room := range m.rooms {
-		if room.IsEmpty() {
+		if room.IsEmpty() && !m.keepEmptyUnlocked(id, now) {
 			room.Stop()
 			delete(m.rooms, id)
+			delete(m.reserved, id)
+			m.forgetPrivateUnlocked(id)
 			removed++
 		}
 	}
@@ -103,6 +520,20 @@ func (m *Matchmaker) CleanupEmptyRooms() int {
 	return removed
 }
 
+// StopAll stops and removes every room (server shutdown)
+func (m *Matchmaker) StopAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, room := range m.rooms {
+		room.Stop()
+		delete(m.rooms, id)
+	}
+	clear(m.reserved)
+	clear(m.private)
+	clear(m.codes)
+}
+
 // GetStats returns matchmaker statistics
 func (m *Matchmaker) GetStats() MatchmakerStats {
 	m.mu.RLock()
@@ -115,12 +546,46 @@ func (m *Matchmaker) GetStats() MatchmakerStats {
 
 	for id, room := range m.rooms {
 		playerCount := room.GetPlayerCount()
+		_, reserved := m.reserved[id]
+		_, private := m.private[id]
 		stats.TotalPlayers += playerCount
+		stats.Spectators += room.SpectatorCount()
+		stats.Viewers += room.ViewerCount()
 		stats.Rooms = append(stats.Rooms, RoomStats{
 			ID:          id,
 			PlayerCount: playerCount,
 			MaxPlayers:  config.MaxPlayersPerRoom,
+			Quality:     room.Quality(),
+			Latency:     room.LatencyStats(),
+			Overruns:    room.Overruns(),
+			GridCells:   room.GridCells(),
+			Suspended:   room.Suspended(),
+			Practice:    room.Practice(),
+			Honeypot:    room.Honeypot(),
+			Quarantined: room.Quarantined(),
+			Reserved:    reserved,
+			Private:     private,
+			Tutorial:    room.Tutorial(),
+			Track:       room.Track().Label(),
+			Sequence:    room.SequenceStats(),
 		})
+		stats.TickOverruns += room.Overruns()
+		stats.Sequence.Add(room.SequenceStats())
+		if room.Suspended() {
+			stats.SuspendedRooms++
+		}
+		if room.Practice() {
+			stats.PracticeRooms++
+		}
+		if room.Honeypot() {
+			stats.HoneypotRooms++
+		}
+		if room.Quarantined() {
+			stats.QuarantinedRooms++
+		}
+		if private {
+			stats.PrivateRooms++
+		}
 	}
 
 	return stats
@@ -128,9 +593,34 @@ func (m *Matchmaker) GetStats() MatchmakerStats {
 
 // MatchmakerStats contains matchmaker statistics
 type MatchmakerStats struct {
-	TotalRooms   int
-	TotalPlayers int
-	Rooms        []RoomStats
+	TotalRooms       int
+	TotalPlayers     int
+	Spectators       int                // Broadcast clients watching rooms (see game/director.go)
+	Viewers          int                // Clients watching public rooms (see game/spectate.go)
+	TickOverruns     uint64             // Physics tick overruns across all rooms
+	SuspendedRooms   int                // Rooms whose game loop is paused while empty
+	PracticeRooms    int                // Private single-player rooms
+	HoneypotRooms    int                // Rooms of flagged players (see game/honeypot.go)
+	QuarantinedRooms int                // Rooms over their tick budget (see game/watchdog.go)
+	PrivateRooms     int                // Rooms joined by code (see private.go)
+	Sequence         game.SequenceStats // Input sequence checks across all rooms
+	Rooms            []RoomStats
+}
+
+// Add adds the stats of another matchmaker (e.g. totals over several pools)
+func (s *MatchmakerStats) Add(other MatchmakerStats) {
+	s.TotalRooms += other.TotalRooms
+	s.TotalPlayers += other.TotalPlayers
+	s.Spectators += other.Spectators
+	s.Viewers += other.Viewers
+	s.TickOverruns += other.TickOverruns
+	s.SuspendedRooms += other.SuspendedRooms
+	s.PracticeRooms += other.PracticeRooms
+	s.HoneypotRooms += other.HoneypotRooms
+	s.QuarantinedRooms += other.QuarantinedRooms
+	s.PrivateRooms += other.PrivateRooms
+	s.Sequence.Add(other.Sequence)
+	s.Rooms = append(s.Rooms, other.Rooms...)
 }
 
 // RoomStats contains room statistics
@@ -138,6 +628,19 @@ type RoomStats struct {
 	ID          string
 	PlayerCount int
 	MaxPlayers  int
+	Quality     game.RoomQuality
+	Latency     game.LatencyStats
+	Overruns    uint64 // Physics ticks that exceeded their interval
+	GridCells   int    // Occupied spatial grid cells
+	Suspended   bool   // Game loop paused while the room is empty
+	Practice    bool   // Private single-player room
+	Honeypot    bool   // Room of flagged players
+	Quarantined bool   // Over its tick budget, takes no new players
+	Reserved    bool   // Booked for a match (see reserve.go)
+	Private     bool   // Joined by code (see private.go)
+	Tutorial    bool   // Practice room running the tutorial script
+	Track       string // Custom track ("id@version"), "" for the built-in road
+	Sequence    game.SequenceStats
 }
 
 // generateRoomID generates a random room ID
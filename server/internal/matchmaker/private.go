@@ -0,0 +1,170 @@
+package matchmaker
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/game"
+	"github.com/race/server/internal/network"
+)
+
+// Private rooms
+//
+// A player opens a private room for their friends and shares its join code:
+// a short code of unambiguous letters and digits that others type in to
+// join, with the password of the room if it has one. A private room is a
+// public room in every other way (track, rules, scoring), but FindRoom
+// doesn't hand it out. Its code is dropped with the room, once it is empty
+// and cleaned up. Codes are known only to the server that holds the room.
+// Private rooms are hosted (see game/host.go): the creator joins first, as
+// nobody else has the code yet, and so is the first host. The host may
+// close the room to new players and change its password.
+
+// Private room errors
+var (
+	ErrRoomCodeUnknown   = errors.New("no private room with this code")
+	ErrRoomWrongPassword = errors.New("wrong room password")
+	ErrRoomClosed        = errors.New("room is closed to new players")
+	ErrRoomNotPrivate    = errors.New("not a private room")
+)
+
+// roomCodeAlphabet has no I, O, 0 or 1, which are easily mistaken
+const roomCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// privateRoom is the access to a private room
+type privateRoom struct {
+	code     string
+	password [sha256.Size]byte // SHA-256 of the password, zero for none
+	locked   bool              // Has a password
+	closed   bool              // No one new may join
+}
+
+// CreatePrivateRoom creates a hosted private room on the public track,
+// joined with password ("" for none), and returns it with its join code.
+// Returns a nil room if the server has no room to spare.
+func (m *Matchmaker) CreatePrivateRoom(password string) (*game.Room, string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.rooms) >= config.MaxRoomsPerServer || len(m.private) >= config.PrivateRoomsMax {
+		return nil, ""
+	}
+
+	code := m.newRoomCodeUnlocked()
+	room := m.newRoomUnlocked(m.newRoomIDUnlocked())
+	if m.publicTrack != nil {
+		room.SetTrack(m.publicTrack)
+	}
+	m.attachRulesUnlocked(room)
+	room.SetHosted(true)
+	access := privateRoom{code: code}
+	access.setPassword(password)
+	m.private[room.ID] = access
+	m.codes[code] = room.ID
+	room.Start()
+
+	return room, code
+}
+
+// PrivateRoom returns the private room of a join code, if password is its
+// password
+func (m *Matchmaker) PrivateRoom(code, password string) (*game.Room, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	id, ok := m.codes[code]
+	if !ok {
+		return nil, ErrRoomCodeUnknown
+	}
+	access := m.private[id]
+	if access.closed {
+		return nil, ErrRoomClosed
+	}
+	if access.locked {
+		sum := sha256.Sum256([]byte(password))
+		if subtle.ConstantTimeCompare(sum[:], access.password[:]) != 1 {
+			return nil, ErrRoomWrongPassword
+		}
+	}
+	room, ok := m.rooms[id]
+	if !ok {
+		return nil, ErrRoomCodeUnknown
+	}
+	return room, nil
+}
+
+// RoomCode returns the join code of a private room, "" for other rooms
+func (m *Matchmaker) RoomCode(roomID string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.private[roomID].code
+}
+
+// SetPrivateAccess changes who may join a private room: closed keeps
+// everyone new out, and password replaces its password ("" for none)
+func (m *Matchmaker) SetPrivateAccess(roomID string, closed bool, password string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	access, ok := m.private[roomID]
+	if !ok {
+		return ErrRoomNotPrivate
+	}
+	access.closed = closed
+	access.setPassword(password)
+	m.private[roomID] = access
+	return nil
+}
+
+// PrivateAccess returns whether a private room is closed to new players and
+// whether joining it takes a password
+func (m *Matchmaker) PrivateAccess(roomID string) (closed, locked bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	access := m.private[roomID]
+	return access.closed, access.locked
+}
+
+// setPassword replaces the access's password ("" for none)
+func (a *privateRoom) setPassword(password string) {
+	a.locked = password != ""
+	a.password = [sha256.Size]byte{}
+	if a.locked {
+		a.password = sha256.Sum256([]byte(password))
+	}
+}
+
+// newRoomCodeUnlocked returns a join code no private room has.
+// IMPORTANT: Caller must hold the matchmaker write lock.
+func (m *Matchmaker) newRoomCodeUnlocked() string {
+	for {
+		code := randomRoomCode()
+		if _, taken := m.codes[code]; !taken {
+			return code
+		}
+	}
+}
+
+// forgetPrivateUnlocked drops the code of a removed room.
+// IMPORTANT: Caller must hold the matchmaker write lock.
+func (m *Matchmaker) forgetPrivateUnlocked(roomID string) {
+	if access, ok := m.private[roomID]; ok {
+		delete(m.codes, access.code)
+		delete(m.private, roomID)
+	}
+}
+
+// randomRoomCode generates a random join code
+func randomRoomCode() string {
+	bytes := make([]byte, network.RoomCodeLen)
+	rand.Read(bytes)
+	for i, b := range bytes {
+		bytes[i] = roomCodeAlphabet[int(b)%len(roomCodeAlphabet)]
+	}
+	return string(bytes)
+}
@@ -0,0 +1,141 @@
+package matchmaker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/race/server/internal/game"
+)
+
+// Waiter is a connection waiting in the join queue
+type Waiter interface {
+	// Admit joins the waiter into room. Returns false if the join failed
+	// (e.g. the room filled up meanwhile) so the waiter keeps its place.
+	// A waiter that has gone away must return true to give up its slot.
+	Admit(room *game.Room) bool
+	// QueueUpdate reports the waiter's 1-based position and the queue length
+	QueueUpdate(position, length int)
+	// QueueTimeout is called when the waiter waited too long and was dropped
+	QueueTimeout()
+}
+
+// JoinQueue holds joins waiting for capacity, first come first served
+type JoinQueue struct {
+	mu      sync.Mutex
+	entries []*queueEntry
+	maxLen  int
+	timeout time.Duration
+}
+
+type queueEntry struct {
+	waiter   Waiter
+	since    time.Time
+	position int // Last position reported to the waiter
+}
+
+// NewJoinQueue creates a join queue. A maxLen of 0 disables queueing.
+func NewJoinQueue(maxLen int, timeout time.Duration) *JoinQueue {
+	return &JoinQueue{maxLen: maxLen, timeout: timeout}
+}
+
+// Enabled reports whether joins may be queued
+func (q *JoinQueue) Enabled() bool {
+	return q.maxLen > 0
+}
+
+// Len returns the number of waiting joins
+func (q *JoinQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.entries)
+}
+
+// Enqueue adds a waiter to the back of the queue.
+// Returns false if the queue is full.
+func (q *JoinQueue) Enqueue(w Waiter) bool {
+	q.mu.Lock()
+	if len(q.entries) >= q.maxLen {
+		q.mu.Unlock()
+		return false
+	}
+	q.entries = append(q.entries, &queueEntry{waiter: w, since: time.Now(), position: len(q.entries) + 1})
+	position, length := len(q.entries), len(q.entries)
+	q.mu.Unlock()
+
+	w.QueueUpdate(position, length)
+	return true
+}
+
+// Remove drops a waiter from the queue (e.g. on disconnect)
+func (q *JoinQueue) Remove(w Waiter) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, e := range q.entries {
+		if e.waiter == w {
+			q.entries = append(q.entries[:i], q.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// Process drops timed out waiters, admits waiters while the matchmaker has
+// room for them, and sends position updates to everyone who moved up.
+// Must only be called from one goroutine at a time.
+func (q *JoinQueue) Process(m *Matchmaker, now time.Time) {
+	// Drop timed out waiters
+	q.mu.Lock()
+	var expired []Waiter
+	kept := q.entries[:0]
+	for _, e := range q.entries {
+		if now.Sub(e.since) > q.timeout {
+			expired = append(expired, e.waiter)
+		} else {
+			kept = append(kept, e)
+		}
+	}
+	q.entries = kept
+	q.mu.Unlock()
+
+	for _, w := range expired {
+		w.QueueTimeout()
+	}
+
+	// Admit from the front while there is capacity
+	for {
+		q.mu.Lock()
+		if len(q.entries) == 0 {
+			q.mu.Unlock()
+			break
+		}
+		head := q.entries[0]
+		q.mu.Unlock()
+
+		room := m.FindRoom()
+		if room == nil || !head.waiter.Admit(room) {
+			break
+		}
+		q.Remove(head.waiter)
+	}
+
+	// Report new positions
+	type update struct {
+		waiter   Waiter
+		position int
+	}
+	var updates []update
+	q.mu.Lock()
+	length := len(q.entries)
+	for i, e := range q.entries {
+		if e.position != i+1 {
+			e.position = i + 1
+			updates = append(updates, update{waiter: e.waiter, position: e.position})
+		}
+	}
+	q.mu.Unlock()
+
+	for _, u := range updates {
+		u.waiter.QueueUpdate(u.position, length)
+	}
+}
@@ -0,0 +1,90 @@
+package matchmaker
+
+import (
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/game"
+)
+
+// Reserved rooms
+//
+// An external matchmaker (Open Match through the allocation API) books a
+// room for the players of a match. A reserved room is public in every other
+// way, but FindRoom doesn't hand it out: only players holding a ticket for it
+// join. It isn't cleaned up while empty until the reservation expires, so the
+// players have time to connect; after that it is an ordinary room that is
+// removed once empty.
+
+// ReserveRoom creates a room on the public track, reserved until the given
+// time. Returns nil if the server has no room to spare.
+func (m *Matchmaker) ReserveRoom(until time.Time) *game.Room {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.rooms) >= config.MaxRoomsPerServer {
+		return nil
+	}
+
+	room := m.newRoomUnlocked(m.newRoomIDUnlocked())
+	if m.publicTrack != nil {
+		room.SetTrack(m.publicTrack)
+	}
+	m.attachRulesUnlocked(room)
+	m.reserved[room.ID] = until
+	room.Start()
+
+	return room
+}
+
+// RestoreRoom creates a room from a snapshot (see game/roomsnapshot.go),
+// reserved until the given time so support can join it. Returns a nil room
+// and error if the server has no room to spare.
+func (m *Matchmaker) RestoreRoom(snap *game.RoomSnapshot, until time.Time) (*game.Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.rooms) >= config.MaxRoomsPerServer {
+		return nil, nil
+	}
+
+	room := m.newRoomUnlocked(m.newRoomIDUnlocked())
+	if err := room.Restore(snap); err != nil {
+		delete(m.rooms, room.ID)
+		return nil, err
+	}
+	m.reserved[room.ID] = until
+	room.Start()
+
+	return room, nil
+}
+
+// Reserved returns whether a room is reserved for a match
+func (m *Matchmaker) Reserved(roomID string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, ok := m.reserved[roomID]
+	return ok
+}
+
+// ReservedRooms returns the number of rooms waiting for their players
+func (m *Matchmaker) ReservedRooms(now time.Time) int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	n := 0
+	for id, until := range m.reserved {
+		if room, ok := m.rooms[id]; ok && now.Before(until) && room.IsEmpty() {
+			n++
+		}
+	}
+	return n
+}
+
+// keepEmptyUnlocked returns whether an empty room must not be cleaned up yet.
+// IMPORTANT: Caller must hold the matchmaker lock.
+func (m *Matchmaker) keepEmptyUnlocked(roomID string, now time.Time) bool {
+	until, ok := m.reserved[roomID]
+	return ok && now.Before(until)
+}
@@ -0,0 +1,139 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/race/server/internal/network"
+)
+
+// ExternalPolicy asks an external moderation API about text.
+//
+// The API receives POST {"kind": "...", "text": "..."} and answers
+// {"allowed": bool, "text": "optional replacement"}. Text checked for a
+// client connection also carries the client's "locale" (e.g. "de-DE"), if
+// known. Results are cached, and the policy fails open (allows the text) if
+// the API is slow or unreachable, so an outage never blocks players from
+// joining.
+type ExternalPolicy struct {
+	url    string
+	client *http.Client
+
+	mu       sync.Mutex
+	cache    map[cacheKey]cachedVerdict
+	ttl      time.Duration
+	maxCache int
+}
+
+type cacheKey struct {
+	kind Kind
+	text string
+}
+
+type cachedVerdict struct {
+	verdict Verdict
+	expires time.Time
+}
+
+// NewExternalPolicy creates an external moderation policy
+func NewExternalPolicy(url string, timeout, ttl time.Duration, maxCache int) *ExternalPolicy {
+	return &ExternalPolicy{
+		url:      url,
+		client:   &http.Client{Timeout: timeout},
+		cache:    make(map[cacheKey]cachedVerdict),
+		ttl:      ttl,
+		maxCache: maxCache,
+	}
+}
+
+// Check implements Policy
+func (p *ExternalPolicy) Check(ctx context.Context, kind Kind, text string) Verdict {
+	key := cacheKey{kind: kind, text: text}
+	now := time.Now()
+
+	p.mu.Lock()
+	if cached, ok := p.cache[key]; ok && now.Before(cached.expires) {
+		p.mu.Unlock()
+		return cached.verdict
+	}
+	p.mu.Unlock()
+
+	verdict, ok := p.query(ctx, kind, text)
+	if !ok {
+		// Fail open and don't cache, so the next check retries
+		return Verdict{Allowed: true, Text: text}
+	}
+
+	p.mu.Lock()
+	if len(p.cache) >= p.maxCache {
+		p.evictUnlocked(now)
+	}
+	p.cache[key] = cachedVerdict{verdict: verdict, expires: now.Add(p.ttl)}
+	p.mu.Unlock()
+
+	return verdict
+}
+
+// query calls the moderation API. Returns false on any failure, or if ctx
+// is done first.
+func (p *ExternalPolicy) query(ctx context.Context, kind Kind, text string) (Verdict, bool) {
+	fields := map[string]string{"kind": string(kind), "text": text}
+	if info := network.ConnInfoFrom(ctx); info != nil && info.Locale != "" {
+		fields["locale"] = info.Locale
+	}
+	body, _ := json.Marshal(fields)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Invalid moderation API request: %v", err)
+		return Verdict{}, false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Moderation API unavailable: %v", err)
+		}
+		return Verdict{}, false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Moderation API returned %d", resp.StatusCode)
+		return Verdict{}, false
+	}
+
+	var result struct {
+		Allowed bool   `json:"allowed"`
+		Text    string `json:"text"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		log.Printf("Invalid moderation API response: %v", err)
+		return Verdict{}, false
+	}
+
+	if !result.Allowed {
+		return Verdict{Allowed: false, Reason: "rejected by moderation API"}, true
+	}
+	if result.Text != "" && result.Text != text {
+		return Verdict{Allowed: true, Text: result.Text, Reason: "replaced by moderation API"}, true
+	}
+	return Verdict{Allowed: true, Text: text}, true
+}
+
+// evictUnlocked drops expired entries, or everything if none expired.
+// IMPORTANT: Caller must hold p.mu.
+func (p *ExternalPolicy) evictUnlocked(now time.Time) {
+	for key, cached := range p.cache {
+		if now.After(cached.expires) {
+			delete(p.cache, key)
+		}
+	}
+	if len(p.cache) >= p.maxCache {
+		p.cache = make(map[cacheKey]cachedVerdict)
+	}
+}
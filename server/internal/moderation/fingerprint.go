@@ -0,0 +1,349 @@
+package moderation
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fingerprint summarizes how a client behaves on the wire, from signals any
+// server sees anyway: which headers its handshake carries, how fast it says
+// Hello and joins after connecting, and the rhythm of its inputs. Header
+// values are hashed. The only things asked of the client are the telemetry
+// samples of players (see cmd/gameserver/telemetry.go): their input device,
+// their frame rate and, from the samples that were missing or implausible,
+// a suspicion weight. A fingerprint doesn't identify anyone on its own; it
+// correlates a new connection with kicked players who may be evading their
+// cooldown under a new name or address.
+type Fingerprint struct {
+	Headers  string `json:"headers"`  // Hash of the handshake's header names
+	Agent    string `json:"agent"`    // Hash of User-Agent
+	Language string `json:"language"` // Hash of Accept-Language
+
+	HelloMS float64 `json:"helloMs"` // Connection to Hello (0: never sent)
+	JoinMS  float64 `json:"joinMs"`  // Connection to the first join (0: never joined)
+
+	Inputs        int     `json:"inputs"`        // Input intervals sampled
+	InputMS       float64 `json:"inputMs"`       // Mean interval between inputs
+	InputJitterMS float64 `json:"inputJitterMs"` // Standard deviation of the interval
+
+	Device        string  `json:"device,omitempty"` // Input device class of the last telemetry sample
+	FrameMS       float64 `json:"frameMs"`          // Mean frame time of that sample (0: none yet)
+	FrameJitterMS float64 `json:"frameJitterMs"`    // Standard deviation of the frame time
+	Suspicion     float64 `json:"suspicion"`        // Weight of missing and implausible samples
+}
+
+// fingerprintHash shortens a signal to a hash that can't be read back
+func fingerprintHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Similarity scores how alike two fingerprints are, from 0 to 1. Signals
+// one of them lacks (no Hello, too few inputs) are left out.
+func (f Fingerprint) Similarity(other Fingerprint) float64 {
+	var score, weight float64
+	add := func(w, s float64) {
+		score += w * s
+		weight += w
+	}
+
+	add(0.25, same(f.Headers, other.Headers))
+	add(0.2, same(f.Agent, other.Agent))
+	add(0.1, same(f.Language, other.Language))
+	if f.HelloMS > 0 && other.HelloMS > 0 {
+		add(0.1, closeness(f.HelloMS, other.HelloMS))
+	}
+	if f.JoinMS > 0 && other.JoinMS > 0 {
+		add(0.05, closeness(f.JoinMS, other.JoinMS))
+	}
+	if f.Inputs >= fingerprintMinInputs && other.Inputs >= fingerprintMinInputs {
+		add(0.15, closeness(f.InputMS, other.InputMS))
+		add(0.15, closeness(f.InputJitterMS, other.InputJitterMS))
+	}
+	if f.FrameMS > 0 && other.FrameMS > 0 {
+		add(0.05, same(f.Device, other.Device))
+		add(0.1, closeness(f.FrameMS, other.FrameMS))
+	}
+
+	return score / weight
+}
+
+// same scores equal hashes 1
+func same(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	return 0
+}
+
+// closeness scores two positive measurements by their ratio
+func closeness(a, b float64) float64 {
+	if a == b {
+		return 1
+	}
+	return math.Min(a, b) / math.Max(a, b)
+}
+
+// fingerprintMinInputs is how many input intervals make a cadence worth comparing
+const fingerprintMinInputs = 8
+
+// FingerprintSampler collects a connection's fingerprint as it goes.
+// Safe for concurrent use: the read loop feeds it, kicks read it.
+type FingerprintSampler struct {
+	mu        sync.Mutex
+	fp        Fingerprint
+	connected time.Time
+	lastInput time.Time
+	sample    int     // Input intervals wanted
+	sum, sq   float64 // Sum and sum of squares of the intervals (ms)
+}
+
+// NewFingerprintSampler starts a fingerprint from the handshake request.
+// Cadence is sampled over the first sample input intervals.
+func NewFingerprintSampler(header http.Header, connected time.Time, sample int) *FingerprintSampler {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, strings.ToLower(name))
+	}
+	sort.Strings(names)
+
+	return &FingerprintSampler{
+		fp: Fingerprint{
+			Headers:  fingerprintHash(strings.Join(names, ",")),
+			Agent:    fingerprintHash(header.Get("User-Agent")),
+			Language: fingerprintHash(header.Get("Accept-Language")),
+		},
+		connected: connected,
+		sample:    sample,
+	}
+}
+
+// Hello records the protocol handshake
+func (s *FingerprintSampler) Hello(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fp.HelloMS == 0 {
+		s.fp.HelloMS = sinceMS(s.connected, now)
+	}
+}
+
+// Join records a join request
+func (s *FingerprintSampler) Join(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fp.JoinMS == 0 {
+		s.fp.JoinMS = sinceMS(s.connected, now)
+	}
+}
+
+// Input records an input. Returns true once, with the input that completes
+// the cadence sample.
+func (s *FingerprintSampler) Input(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	last := s.lastInput
+	s.lastInput = now
+	if last.IsZero() || s.fp.Inputs >= s.sample {
+		return false
+	}
+
+	interval := sinceMS(last, now)
+	s.sum += interval
+	s.sq += interval * interval
+	s.fp.Inputs++
+
+	n := float64(s.fp.Inputs)
+	s.fp.InputMS = s.sum / n
+	s.fp.InputJitterMS = math.Sqrt(math.Max(0, s.sq/n-s.fp.InputMS*s.fp.InputMS))
+	return s.fp.Inputs == s.sample
+}
+
+// Telemetry records a plausible telemetry sample, which takes relief off
+// the suspicion. Returns the suspicion left.
+func (s *FingerprintSampler) Telemetry(device string, frameMS, jitterMS, relief float64) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fp.Device = device
+	s.fp.FrameMS, s.fp.FrameJitterMS = frameMS, jitterMS
+	s.fp.Suspicion = math.Max(0, s.fp.Suspicion-relief)
+	return s.fp.Suspicion
+}
+
+// Suspect adds weight to the suspicion, up to limit. Returns the suspicion.
+func (s *FingerprintSampler) Suspect(weight, limit float64) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fp.Suspicion = math.Min(limit, s.fp.Suspicion+weight)
+	return s.fp.Suspicion
+}
+
+// Fingerprint returns the fingerprint collected so far
+func (s *FingerprintSampler) Fingerprint() Fingerprint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.fp
+}
+
+// sinceMS returns the milliseconds from start to now, at least a
+// microsecond so a measured signal is never mistaken for a missing one
+func sinceMS(start, now time.Time) float64 {
+	return math.Max(0.001, float64(now.Sub(start))/float64(time.Millisecond))
+}
+
+// FingerprintRecord is the fingerprint of a kicked player
+type FingerprintRecord struct {
+	Name        string      `json:"name"`
+	IP          string      `json:"ip"`
+	Reason      string      `json:"reason"`
+	Kicked      time.Time   `json:"kicked"`
+	Fingerprint Fingerprint `json:"fingerprint"`
+}
+
+// FingerprintMatch is a connection that resembles a kicked player
+type FingerprintMatch struct {
+	Name        string            `json:"name"`
+	IP          string            `json:"ip"`
+	Seen        time.Time         `json:"seen"`
+	Score       float64           `json:"score"` // Similarity, 0-1
+	Fingerprint Fingerprint       `json:"fingerprint"`
+	Kicked      FingerprintRecord `json:"kicked"` // Best matching kick
+}
+
+// FingerprintStore keeps the fingerprints of kicked players and the
+// connections that resembled them, for moderators to review. Matches are
+// evidence, not verdicts: nothing is blocked automatically.
+type FingerprintStore struct {
+	mu      sync.Mutex
+	records []FingerprintRecord // Oldest first
+	matches []FingerprintMatch  // Oldest first
+
+	threshold  float64       // Minimum similarity of a match
+	retention  time.Duration // How long records and matches are kept
+	maxRecords int           // Bound on records and on matches
+}
+
+// NewFingerprintStore creates a fingerprint store
+func NewFingerprintStore(threshold float64, retention time.Duration, maxRecords int) *FingerprintStore {
+	return &FingerprintStore{
+		threshold:  threshold,
+		retention:  retention,
+		maxRecords: maxRecords,
+	}
+}
+
+// Len returns the number of kick records
+func (s *FingerprintStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.records)
+}
+
+// RecordKick keeps the fingerprint of a kicked player
+func (s *FingerprintStore) RecordKick(record FingerprintRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records = append(s.records, record)
+	if len(s.records) > s.maxRecords {
+		s.records = s.records[len(s.records)-s.maxRecords:]
+	}
+}
+
+// Match compares a connection with the kicked players and records the best
+// match above the threshold. A player returning under the same name from the
+// same address isn't evading anything and is skipped.
+func (s *FingerprintStore) Match(name, ip string, fp Fingerprint, now time.Time) (FingerprintMatch, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	best := FingerprintMatch{Name: name, IP: ip, Seen: now, Fingerprint: fp}
+	for _, record := range s.records {
+		if record.Name == name && record.IP == ip {
+			continue
+		}
+		if score := fp.Similarity(record.Fingerprint); score > best.Score {
+			best.Score, best.Kicked = score, record
+		}
+	}
+	if best.Score < s.threshold {
+		return FingerprintMatch{}, false
+	}
+
+	s.matches = append(s.matches, best)
+	if len(s.matches) > s.maxRecords {
+		s.matches = s.matches[len(s.matches)-s.maxRecords:]
+	}
+	return best, true
+}
+
+// Records returns the kick records, newest first
+func (s *FingerprintStore) Records() []FingerprintRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := make([]FingerprintRecord, len(s.records))
+	for i, record := range s.records {
+		records[len(records)-1-i] = record
+	}
+	return records
+}
+
+// Matches returns the matches, newest first
+func (s *FingerprintStore) Matches() []FingerprintMatch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matches := make([]FingerprintMatch, len(s.matches))
+	for i, match := range s.matches {
+		matches[len(matches)-1-i] = match
+	}
+	return matches
+}
+
+// Rename replaces a player's name in records and matches (see the
+// personal data deletion)
+func (s *FingerprintStore) Rename(name, alias string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.records {
+		if s.records[i].Name == name {
+			s.records[i].Name = alias
+		}
+	}
+	for i := range s.matches {
+		if s.matches[i].Name == name {
+			s.matches[i].Name = alias
+		}
+		if s.matches[i].Kicked.Name == name {
+			s.matches[i].Kicked.Name = alias
+		}
+	}
+}
+
+// Sweep removes records and matches older than the retention. Returns the
+// number removed.
+func (s *FingerprintStore) Sweep() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := time.Now().Add(-s.retention)
+	records := sort.Search(len(s.records), func(i int) bool { return s.records[i].Kicked.After(cutoff) })
+	matches := sort.Search(len(s.matches), func(i int) bool { return s.matches[i].Seen.After(cutoff) })
+	s.records = s.records[records:]
+	s.matches = s.matches[matches:]
+	return records + matches
+}
@@ -0,0 +1,108 @@
+// Package moderation implements player penalties and bans.
+package moderation
+
+import (
+	"sync"
+	"time"
+)
+
+// PenaltyEntry tracks the kick history of a single key (IP or session).
+type PenaltyEntry struct {
+	Offenses    int       // Kicks within the decay window
+	LastOffense time.Time // When the most recent kick happened
+	Until       time.Time // Rejoin is blocked until this time
+}
+
+// PenaltyStore tracks rejoin cooldowns after anti-cheat kicks.
+//
+// Each kick escalates the cooldown for the offending key: the first kick
+// blocks rejoining for baseCooldown, and each further kick within the decay
+// window doubles it, capped at maxCooldown. Offense counts are forgotten once
+// a key has stayed clean for the decay window.
+type PenaltyStore struct {
+	mu      sync.Mutex
+	entries map[string]*PenaltyEntry
+
+	baseCooldown time.Duration
+	maxCooldown  time.Duration
+	decay        time.Duration
+}
+
+// NewPenaltyStore creates a new penalty store
+func NewPenaltyStore(baseCooldown, maxCooldown, decay time.Duration) *PenaltyStore {
+	return &PenaltyStore{
+		entries:      make(map[string]*PenaltyEntry),
+		baseCooldown: baseCooldown,
+		maxCooldown:  maxCooldown,
+		decay:        decay,
+	}
+}
+
+// Len returns the number of tracked keys
+func (s *PenaltyStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.entries)
+}
+
+// RecordKick registers a kick for the key and returns the resulting cooldown.
+func (s *PenaltyStore) RecordKick(key string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	entry, ok := s.entries[key]
+	if !ok || now.Sub(entry.LastOffense) > s.decay {
+		entry = &PenaltyEntry{}
+		s.entries[key] = entry
+	}
+
+	entry.Offenses++
+	entry.LastOffense = now
+
+	cooldown := s.baseCooldown
+	for i := 1; i < entry.Offenses && cooldown < s.maxCooldown; i++ {
+		cooldown *= 2
+	}
+	if cooldown > s.maxCooldown {
+		cooldown = s.maxCooldown
+	}
+
+	entry.Until = now.Add(cooldown)
+	return cooldown
+}
+
+// Remaining returns how long the key must still wait before rejoining,
+// and the number of offenses on record. Zero means the key may join.
+func (s *PenaltyStore) Remaining(key string) (time.Duration, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return 0, 0
+	}
+
+	remaining := time.Until(entry.Until)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, entry.Offenses
+}
+
+// Sweep removes entries whose offenses have decayed. Returns the number removed.
+func (s *PenaltyStore) Sweep() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	removed := 0
+	for key, entry := range s.entries {
+		if now.After(entry.Until) && now.Sub(entry.LastOffense) > s.decay {
+			delete(s.entries, key)
+			removed++
+		}
+	}
+	return removed
+}
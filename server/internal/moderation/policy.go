@@ -0,0 +1,287 @@
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Kind identifies what a piece of text is used for
+type Kind string
+
+const (
+	KindName Kind = "name"
+	KindChat Kind = "chat"
+)
+
+// Action is what a rule does with matching text
+type Action string
+
+const (
+	ActionMask   Action = "mask"   // Replace the match with asterisks
+	ActionReject Action = "reject" // Refuse the whole text
+)
+
+// Verdict is the outcome of checking a piece of text
+type Verdict struct {
+	Allowed bool   // False if the text must not be used at all
+	Text    string // Text to use (possibly masked)
+	Reason  string // Why the text was changed or rejected
+}
+
+// Policy checks user-provided text. Policies that call out to other
+// services give up when ctx is done.
+// Implementations must be safe for concurrent use.
+type Policy interface {
+	Check(ctx context.Context, kind Kind, text string) Verdict
+}
+
+// Rules is the JSON-configurable rule set
+type Rules struct {
+	DenyWords []string    `json:"denyWords"` // Case-insensitive substrings
+	DenyMode  Action      `json:"denyMode"`  // Action for deny words (default mask)
+	Patterns  []RegexRule `json:"patterns"`
+}
+
+// RegexRule is a single regular expression rule
+type RegexRule struct {
+	Pattern string `json:"pattern"`
+	Action  Action `json:"action"`
+	Kinds   []Kind `json:"kinds,omitempty"` // Empty means all kinds
+}
+
+// LoadRules reads a rule set from a JSON file
+func LoadRules(path string) (Rules, error) {
+	var rules Rules
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return rules, err
+	}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return rules, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// Moderator applies the current policy chain to names and chat.
+// The rule set can be replaced at runtime.
+type Moderator struct {
+	mu       sync.RWMutex
+	rules    Rules
+	policies []Policy
+	external Policy // Optional external moderation API, checked last
+}
+
+// NewModerator creates a moderator with an empty rule set
+func NewModerator(external Policy) *Moderator {
+	m := &Moderator{external: external}
+	m.SetRules(Rules{})
+	return m
+}
+
+// SetRules compiles and installs a new rule set
+func (m *Moderator) SetRules(rules Rules) error {
+	policies := make([]Policy, 0, 2)
+
+	if len(rules.DenyWords) > 0 {
+		mode := rules.DenyMode
+		if mode == "" {
+			mode = ActionMask
+		}
+		policies = append(policies, newDenyListPolicy(rules.DenyWords, mode))
+	}
+
+	if len(rules.Patterns) > 0 {
+		regexPolicy, err := newRegexPolicy(rules.Patterns)
+		if err != nil {
+			return err
+		}
+		policies = append(policies, regexPolicy)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rules = rules
+	m.policies = policies
+	return nil
+}
+
+// Rules returns the installed rule set
+func (m *Moderator) Rules() Rules {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.rules
+}
+
+// Check runs text through every policy, stopping at the first rejection.
+func (m *Moderator) Check(ctx context.Context, kind Kind, text string) Verdict {
+	m.mu.RLock()
+	policies := m.policies
+	m.mu.RUnlock()
+
+	verdict := Verdict{Allowed: true, Text: text}
+	for _, p := range policies {
+		v := p.Check(ctx, kind, verdict.Text)
+		if !v.Allowed {
+			return v
+		}
+		if v.Text != verdict.Text {
+			verdict.Text = v.Text
+			verdict.Reason = v.Reason
+		}
+	}
+
+	if m.external != nil {
+		v := m.external.Check(ctx, kind, verdict.Text)
+		if !v.Allowed {
+			return v
+		}
+		if v.Text != verdict.Text {
+			verdict.Text = v.Text
+			verdict.Reason = v.Reason
+		}
+	}
+
+	return verdict
+}
+
+// SanitizeName normalizes a player name and applies the name policy.
+// Control characters are stripped, whitespace trimmed and the result
+// truncated to maxRunes. Rejected or empty names fall back to fallback.
+func (m *Moderator) SanitizeName(ctx context.Context, name string, maxRunes int, fallback string) (string, Verdict) {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == unicode.ReplacementChar {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+
+	if runes := []rune(name); len(runes) > maxRunes {
+		name = strings.TrimSpace(string(runes[:maxRunes]))
+	}
+
+	if name == "" {
+		return fallback, Verdict{Allowed: true, Text: fallback}
+	}
+
+	verdict := m.Check(ctx, KindName, name)
+	if !verdict.Allowed {
+		return fallback, verdict
+	}
+	return verdict.Text, verdict
+}
+
+// denyListPolicy matches case-insensitive substrings
+type denyListPolicy struct {
+	words []string
+	mode  Action
+}
+
+func newDenyListPolicy(words []string, mode Action) *denyListPolicy {
+	lower := make([]string, 0, len(words))
+	for _, w := range words {
+		if w = strings.Map(unicode.ToLower, strings.TrimSpace(w)); w != "" {
+			lower = append(lower, w)
+		}
+	}
+	return &denyListPolicy{words: lower, mode: mode}
+}
+
+func (p *denyListPolicy) Check(ctx context.Context, kind Kind, text string) Verdict {
+	// Lowercase rune by rune so indexes line up with the original text
+	masked := []rune(text)
+	lowerRunes := make([]rune, len(masked))
+	for i, r := range masked {
+		lowerRunes[i] = unicode.ToLower(r)
+	}
+	lower := string(lowerRunes)
+	changed := false
+
+	for _, w := range p.words {
+		if !strings.Contains(lower, w) {
+			continue
+		}
+		if p.mode == ActionReject {
+			return Verdict{Allowed: false, Reason: "denied word"}
+		}
+		// Mask every occurrence (rune-based so multi-byte text stays valid)
+		wordRunes := []rune(w)
+		for i := 0; i+len(wordRunes) <= len(lowerRunes); i++ {
+			if string(lowerRunes[i:i+len(wordRunes)]) == w {
+				for j := i; j < i+len(wordRunes); j++ {
+					masked[j] = '*'
+				}
+				changed = true
+			}
+		}
+	}
+
+	if changed {
+		return Verdict{Allowed: true, Text: string(masked), Reason: "denied word masked"}
+	}
+	return Verdict{Allowed: true, Text: text}
+}
+
+// regexPolicy applies regular expression rules
+type regexPolicy struct {
+	rules []compiledRule
+}
+
+type compiledRule struct {
+	re     *regexp.Regexp
+	action Action
+	kinds  []Kind
+}
+
+func newRegexPolicy(rules []RegexRule) (*regexPolicy, error) {
+	compiled := make([]compiledRule, 0, len(rules))
+	for _, r := range rules {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", r.Pattern, err)
+		}
+		action := r.Action
+		if action == "" {
+			action = ActionReject
+		}
+		compiled = append(compiled, compiledRule{re: re, action: action, kinds: r.Kinds})
+	}
+	return &regexPolicy{rules: compiled}, nil
+}
+
+func (p *regexPolicy) Check(ctx context.Context, kind Kind, text string) Verdict {
+	verdict := Verdict{Allowed: true, Text: text}
+	for _, r := range p.rules {
+		if !r.appliesTo(kind) || !r.re.MatchString(verdict.Text) {
+			continue
+		}
+		if r.action == ActionReject {
+			return Verdict{Allowed: false, Reason: "matched pattern " + r.re.String()}
+		}
+		verdict.Text = r.re.ReplaceAllStringFunc(verdict.Text, func(match string) string {
+			return strings.Repeat("*", len([]rune(match)))
+		})
+		verdict.Reason = "pattern masked"
+	}
+	return verdict
+}
+
+func (r compiledRule) appliesTo(kind Kind) bool {
+	if len(r.kinds) == 0 {
+		return true
+	}
+	for _, k := range r.kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,595 @@
+package network
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// WebSocket subprotocols (Sec-WebSocket-Protocol). The binary protocol is the
+// game's native format; the JSON one carries the same messages as text
+// frames, for tools, bots and debugging. Clients that request no subprotocol
+// get the binary protocol, as before subprotocols were negotiated.
+const (
+	SubprotocolBinary = "vracer.v1.bin"
+	SubprotocolJSON   = "vracer.v1.json"
+)
+
+// Subprotocols lists the supported subprotocols, in order of preference
+var Subprotocols = []string{SubprotocolBinary, SubprotocolJSON}
+
+// WebSocket frame types (opcodes, as gorilla/websocket numbers them)
+const (
+	FrameText   = 1
+	FrameBinary = 2
+)
+
+// ErrUnknownMessage is returned for messages a codec can't translate
+var ErrUnknownMessage = errors.New("unknown message type")
+
+// Codec translates between a connection's wire format and the binary
+// messages the server handles. The server encodes every message once in the
+// binary format (rooms broadcast the same bytes to all players); codecs
+// translate them per connection.
+type Codec interface {
+	// Subprotocol returns the negotiated subprotocol ("" for legacy clients)
+	Subprotocol() string
+
+	// Decode turns a received frame into a binary client message
+	Decode(frameType int, data []byte) ([]byte, error)
+
+	// Encode turns a binary server message, encoded for the given protocol
+	// version, into a frame
+	Encode(version uint8, msg []byte) (frameType int, data []byte, err error)
+}
+
+// CodecFor returns the codec of a negotiated subprotocol ("" for none)
+func CodecFor(subprotocol string) (Codec, bool) {
+	switch subprotocol {
+	case "", SubprotocolBinary:
+		return binaryCodec{subprotocol: subprotocol}, true
+	case SubprotocolJSON:
+		return jsonCodec{}, true
+	}
+	return nil, false
+}
+
+// binaryCodec passes binary messages through
+type binaryCodec struct {
+	subprotocol string
+}
+
+func (c binaryCodec) Subprotocol() string { return c.subprotocol }
+
+func (c binaryCodec) Decode(frameType int, data []byte) ([]byte, error) {
+	return data, nil
+}
+
+func (c binaryCodec) Encode(version uint8, msg []byte) (int, []byte, error) {
+	return FrameBinary, msg, nil
+}
+
+// jsonCodec speaks JSON objects with a "type" and the fields of the message,
+// named as in the protocol test vectors (protocol/vectors.json), e.g.
+//
+//	{"type": "input", "sequence": 7, "keys": 1, "steering": -64, "throttle": 127, "flags": 0}
+//
+// Values are the wire values (scaled integers), so both formats carry
+// exactly the same information. 64-bit timestamps are strings.
+type jsonCodec struct{}
+
+func (jsonCodec) Subprotocol() string { return SubprotocolJSON }
+
+// jsonClientMessage is the union of the client messages' fields
+type jsonClientMessage struct {
+	Type string `json:"type"`
+
+	// input
+	Sequence uint8 `json:"sequence"`
+	Keys     uint8 `json:"keys"`
+	Steering int8  `json:"steering"`
+	Throttle int8  `json:"throttle"`
+	Flags    uint8 `json:"flags"`
+
+	// join, update-profile, create-room, join-by-code
+	Name         string `json:"name"`
+	Color        uint8  `json:"color"`
+	Options      uint8  `json:"options"`
+	TrackID      string `json:"trackId"`
+	TrackVersion uint16 `json:"trackVersion"`
+	Trail        uint8  `json:"trail"`
+	Decal        uint8  `json:"decal"`
+
+	// ping, optionally with a performance report
+	Timestamp     json.Number `json:"timestamp"`
+	FPS           *uint8      `json:"fps"`
+	InterpDelayMS uint16      `json:"interpDelayMs"`
+	JitterMS      uint16      `json:"jitterMs"`
+
+	// hello
+	Version uint8 `json:"version"`
+
+	// host-kick
+	TargetID uint16 `json:"targetId"`
+
+	// link
+	Token string `json:"token"`
+
+	// signal (also targetId)
+	Kind    uint8  `json:"kind"`
+	Payload string `json:"payload"`
+
+	// net-sim (also jitterMs)
+	LatencyMS uint16 `json:"latencyMs"`
+	Loss      uint8  `json:"loss"`
+
+	// event-auth, telemetry: hex
+	MAC string `json:"mac"`
+
+	// mute (also targetId)
+	Op uint8 `json:"op"`
+
+	// create-room, join-by-code (also the join fields), host-settings
+	Code     string `json:"code"`
+	Password string `json:"password"`
+
+	// host-settings (also password)
+	Closed bool `json:"closed"`
+
+	// spectate
+	Room string `json:"room"`
+
+	// telemetry (also mac)
+	Nonce  string   `json:"nonce"`
+	Device uint8    `json:"device"`
+	Frames []uint16 `json:"frames"`
+}
+
+func (jsonCodec) Decode(frameType int, data []byte) ([]byte, error) {
+	var m jsonClientMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	switch m.Type {
+	case "input":
+		return []byte{MsgTypeInput, m.Sequence, m.Keys, uint8(m.Steering), uint8(m.Throttle), m.Flags}, nil
+
+	case "join":
+		if len(m.Name) > 255 || len(m.TrackID) > TrackIDMaxLen {
+			return nil, ErrInvalidMessage
+		}
+		return m.appendJoin([]byte{MsgTypeJoinRoom}), nil
+
+	case "create-room":
+		if len(m.Name) > 255 || len(m.Password) > RoomPasswordMaxLen {
+			return nil, ErrInvalidMessage
+		}
+		buf := append([]byte{MsgTypeCreateRoom, uint8(len(m.Password))}, m.Password...)
+		return m.appendJoin(buf), nil
+
+	case "join-by-code":
+		if len(m.Name) > 255 || len(m.Code) != RoomCodeLen || len(m.Password) > RoomPasswordMaxLen {
+			return nil, ErrInvalidMessage
+		}
+		buf := append([]byte{MsgTypeJoinByCode, uint8(len(m.Code))}, m.Code...)
+		buf = append(buf, uint8(len(m.Password)))
+		buf = append(buf, m.Password...)
+		return m.appendJoin(buf), nil
+
+	case "spectate":
+		if len(m.Room) == 0 || len(m.Room) > 255 {
+			return nil, ErrInvalidMessage
+		}
+		return append([]byte{MsgTypeSpectate, uint8(len(m.Room))}, m.Room...), nil
+
+	case "leave":
+		return []byte{MsgTypeLeaveRoom}, nil
+
+	case "ping":
+		ts, err := strconv.ParseUint(m.Timestamp.String(), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("timestamp: %w", err)
+		}
+		buf := binary.LittleEndian.AppendUint64([]byte{MsgTypePing}, ts)
+		if m.FPS != nil {
+			buf = append(buf, *m.FPS)
+			buf = binary.LittleEndian.AppendUint16(buf, m.InterpDelayMS)
+			buf = binary.LittleEndian.AppendUint16(buf, m.JitterMS)
+		}
+		return buf, nil
+
+	case "hello":
+		return []byte{MsgTypeHello, m.Version}, nil
+
+	case "host-kick":
+		return binary.LittleEndian.AppendUint16([]byte{MsgTypeHostKick}, m.TargetID), nil
+
+	case "reset":
+		return []byte{MsgTypeReset}, nil
+
+	case "prestige":
+		return []byte{MsgTypePrestige}, nil
+
+	case "clip":
+		return []byte{MsgTypeClip}, nil
+
+	case "host-start-race":
+		return []byte{MsgTypeHostStartRace}, nil
+
+	case "host-settings":
+		if len(m.Password) > RoomPasswordMaxLen {
+			return nil, ErrInvalidMessage
+		}
+		var flags uint8
+		if m.Closed {
+			flags |= RoomSettingsClosed
+		}
+		return append([]byte{MsgTypeHostSettings, flags, uint8(len(m.Password))}, m.Password...), nil
+
+	case "net-sim":
+		buf := binary.LittleEndian.AppendUint16([]byte{MsgTypeNetSim}, m.LatencyMS)
+		buf = binary.LittleEndian.AppendUint16(buf, m.JitterMS)
+		return append(buf, m.Loss), nil
+
+	case "event-auth":
+		mac, err := hex.DecodeString(m.MAC)
+		if err != nil || len(mac) != EventMACLen {
+			return nil, ErrInvalidMessage
+		}
+		return append([]byte{MsgTypeEventAuth}, mac...), nil
+
+	case "telemetry":
+		nonce, err := hex.DecodeString(m.Nonce)
+		if err != nil || len(nonce) != TelemetryNonceLen || len(m.Frames) > TelemetryMaxFrames {
+			return nil, ErrInvalidMessage
+		}
+		mac, err := hex.DecodeString(m.MAC)
+		if err != nil || len(mac) != TelemetryMACLen {
+			return nil, ErrInvalidMessage
+		}
+		buf := append([]byte{MsgTypeTelemetry}, nonce...)
+		buf = append(buf, m.Device, uint8(len(m.Frames)))
+		for _, f := range m.Frames {
+			buf = binary.LittleEndian.AppendUint16(buf, f)
+		}
+		return append(buf, mac...), nil
+
+	case "mute":
+		return binary.LittleEndian.AppendUint16([]byte{MsgTypeMute, m.Op}, m.TargetID), nil
+
+	case "update-profile":
+		if len(m.Name) > 255 {
+			return nil, ErrInvalidMessage
+		}
+		buf := append([]byte{MsgTypeUpdateProfile, uint8(len(m.Name))}, m.Name...)
+		return append(buf, m.Color), nil
+
+	case "link":
+		if len(m.Token) == 0 || len(m.Token) > LinkTokenMaxLen {
+			return nil, ErrInvalidMessage
+		}
+		buf := binary.LittleEndian.AppendUint16([]byte{MsgTypeLink}, uint16(len(m.Token)))
+		return append(buf, m.Token...), nil
+
+	case "signal":
+		if m.Kind >= SignalKindCount || len(m.Payload) > SignalPayloadMaxLen {
+			return nil, ErrInvalidMessage
+		}
+		buf := binary.LittleEndian.AppendUint16([]byte{MsgTypeSignal}, m.TargetID)
+		buf = append(buf, m.Kind)
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(len(m.Payload)))
+		return append(buf, m.Payload...), nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownMessage, m.Type)
+}
+
+// appendJoin appends the fields of a join, from [nameLen]
+func (m *jsonClientMessage) appendJoin(buf []byte) []byte {
+	buf = append(buf, uint8(len(m.Name)))
+	buf = append(buf, m.Name...)
+	buf = append(buf, m.Color)
+	if m.Options != 0 {
+		buf = append(buf, m.Options)
+	}
+	if m.Options&JoinTrack != 0 {
+		buf = append(buf, uint8(len(m.TrackID)))
+		buf = append(buf, m.TrackID...)
+		buf = binary.LittleEndian.AppendUint16(buf, m.TrackVersion)
+	}
+	if m.Options&JoinCosmetics != 0 {
+		buf = append(buf, m.Trail, m.Decal)
+	}
+	return buf
+}
+
+func (jsonCodec) Encode(version uint8, msg []byte) (int, []byte, error) {
+	fields, err := decodeServerMessage(version, msg)
+	if err != nil {
+		return 0, nil, err
+	}
+	data, err := json.Marshal(fields)
+	return FrameText, data, err
+}
+
+// decodeServerMessage reads a binary server message into its JSON fields
+func decodeServerMessage(version uint8, msg []byte) (map[string]interface{}, error) {
+	r := &wireReader{buf: msg}
+	msgType := r.u8()
+	var f map[string]interface{}
+
+	switch msgType {
+	case MsgTypeStateUpdate:
+		f = map[string]interface{}{"type": "state", "tick": r.u16()}
+		count := int(r.u8())
+		if version >= ProtocolV10 {
+			f["baseY"] = int64(r.u64())
+		}
+		players := make([]map[string]interface{}, 0, count)
+		for i := 0; i < count && r.err == nil; i++ {
+			p := map[string]interface{}{
+				"id":     r.u16(),
+				"x":      int16(r.u16()),
+				"y":      int32(r.u32()),
+				"speed":  int16(r.u16()),
+				"angle":  int8(r.u8()),
+				"rating": uint32(r.u8()) | uint32(r.u8())<<8 | uint32(r.u8())<<16,
+				"flags":  r.u8(),
+				"color":  r.u8(),
+			}
+			if version >= ProtocolV2 {
+				p["velX"] = int16(r.u16())
+				p["velY"] = int16(r.u16())
+			}
+			if version >= ProtocolV17 {
+				p["lane"] = r.u8()
+			}
+			players = append(players, p)
+		}
+		f["players"] = players
+		if version >= ProtocolV20 {
+			own := r.next(int(r.u8()))
+			self := map[string]interface{}{}
+			if len(own) >= 2 && binary.LittleEndian.Uint16(own) != OwnFuelUntracked {
+				self["fuel"] = binary.LittleEndian.Uint16(own)
+			}
+			if len(own) >= 3 {
+				self["ack"] = own[2]
+			}
+			f["own"] = self
+		}
+
+	case MsgTypePlayerJoin:
+		f = map[string]interface{}{"type": "player-join", "id": r.u16(), "name": r.str(), "color": r.u8()}
+
+	case MsgTypePlayerLeave:
+		f = map[string]interface{}{"type": "player-leave", "id": r.u16()}
+
+	case MsgTypePlayerDeath:
+		f = map[string]interface{}{"type": "player-death", "id": r.u16()}
+
+	case MsgTypeRoomInfo:
+		f = map[string]interface{}{"type": "room-info", "roomId": r.str(), "playerCount": r.u8(), "maxPlayers": r.u8(), "yourId": r.u16()}
+		if version >= ProtocolV37 {
+			f["collisions"] = r.u8()
+		}
+
+	case MsgTypePong:
+		f = map[string]interface{}{"type": "pong", "timestamp": strconv.FormatUint(r.u64(), 10)}
+
+	case MsgTypeCooldown:
+		f = map[string]interface{}{"type": "cooldown", "remainingMs": r.u32(), "offenses": r.u8()}
+
+	case MsgTypeHelloAck:
+		f = map[string]interface{}{"type": "hello-ack", "version": r.u8()}
+
+	case MsgTypeScoreboard:
+		count := int(r.u8())
+		entries := make([]map[string]interface{}, 0, count)
+		for i := 0; i < count && r.err == nil; i++ {
+			entries = append(entries, map[string]interface{}{"id": r.u16(), "rttMs": r.u16()})
+		}
+		f = map[string]interface{}{"type": "scoreboard", "entries": entries}
+
+	case MsgTypeHostChange:
+		f = map[string]interface{}{"type": "host-change", "hostId": r.u16()}
+
+	case MsgTypeQueueStatus:
+		f = map[string]interface{}{"type": "queue-status", "position": r.u16(), "length": r.u16()}
+
+	case MsgTypeTickRate:
+		f = map[string]interface{}{"type": "tick-rate", "physicsRate": r.u8(), "broadcastRate": r.u8()}
+
+	case MsgTypeTutorial:
+		f = map[string]interface{}{"type": "tutorial", "step": r.u8(), "steps": r.u8(), "text": r.str()}
+
+	case MsgTypeTrack:
+		f = map[string]interface{}{"type": "track", "width": r.u16()}
+		f["curves"] = r.trackCurves()
+		if version >= ProtocolV17 {
+			f["lanes"] = r.u8()
+		}
+		if version >= ProtocolV18 {
+			f["banking"] = r.f32()
+			f["elevation"] = r.trackCurves()
+		}
+		if version >= ProtocolV19 {
+			count := int(r.u8())
+			ramps := make([]map[string]interface{}, 0, count)
+			for i := 0; i < count && r.err == nil; i++ {
+				ramps = append(ramps, map[string]interface{}{"offset": r.f32(), "spacing": r.f32(), "length": r.f32(), "height": r.f32()})
+			}
+			f["ramps"] = ramps
+		}
+		if version >= ProtocolV21 {
+			f["variant"] = r.u8()
+		}
+		if version >= ProtocolV22 {
+			f["escalationRate"] = r.f32()
+			f["escalationMax"] = r.f32()
+		}
+
+	case MsgTypeResults:
+		f = map[string]interface{}{"type": "results", "round": r.u16()}
+		count := int(r.u8())
+		awards := make([]map[string]interface{}, 0, count)
+		for i := 0; i < count && r.err == nil; i++ {
+			awards = append(awards, map[string]interface{}{"kind": r.u8(), "playerId": r.u16(), "value": r.f32()})
+		}
+		f["awards"] = awards
+
+	case MsgTypeRebase:
+		f = map[string]interface{}{"type": "rebase", "origin": r.f64(), "shift": r.f64()}
+
+	case MsgTypeInterpDelay:
+		f = map[string]interface{}{"type": "interp-delay", "delayMs": r.u16()}
+
+	case MsgTypeLinked:
+		f = map[string]interface{}{"type": "linked", "account": r.str()}
+
+	case MsgTypeSession:
+		f = map[string]interface{}{"type": "session", "token": string(r.next(int(r.u16())))}
+
+	case MsgTypeRoomCode:
+		f = map[string]interface{}{"type": "room-code", "code": r.str()}
+
+	case MsgTypeSignalRelay:
+		f = map[string]interface{}{"type": "signal", "fromId": r.u16(), "kind": r.u8()}
+		f["payload"] = string(r.next(int(r.u16())))
+
+	case MsgTypeNearby:
+		count := int(r.u8())
+		ids := make([]uint16, 0, count)
+		for i := 0; i < count && r.err == nil; i++ {
+			ids = append(ids, r.u16())
+		}
+		f = map[string]interface{}{"type": "nearby", "ids": ids}
+
+	case MsgTypeAppearance:
+		f = map[string]interface{}{"type": "appearance", "id": r.u16(), "trail": r.u8(), "decal": r.u8()}
+
+	case MsgTypeDirector:
+		f = map[string]interface{}{"type": "director", "target": r.u16(), "second": r.u16(), "reason": r.u8()}
+
+	case MsgTypeMilestone:
+		f = map[string]interface{}{"type": "milestone", "playerId": r.u16(), "kind": r.u8(), "count": r.u16(), "value": r.f32()}
+
+	case MsgTypeCollision:
+		f = map[string]interface{}{"type": "collision", "a": r.u16(), "b": r.u16(), "impact": r.f32()}
+
+	case MsgTypeRelay:
+		f = map[string]interface{}{"type": "relay", "team": r.u8(), "active": r.u16(), "legs": r.u16(),
+			"leg": r.f32(), "legLength": r.f32(), "distance": r.f32()}
+		count := int(r.u8())
+		members := make([]uint16, 0, count)
+		for i := 0; i < count && r.err == nil; i++ {
+			members = append(members, r.u16())
+		}
+		f["members"] = members
+
+	case MsgTypeRaceStart:
+		f = map[string]interface{}{"type": "race-start", "race": r.u16(), "laps": r.u8(), "checkpoints": r.u8(),
+			"lapLength": r.f32(), "countdownMs": r.u16(), "entered": r.u8() != 0}
+
+	case MsgTypeCheckpointPassed:
+		f = map[string]interface{}{"type": "checkpoint-passed", "playerId": r.u16(), "lap": r.u8(), "checkpoint": r.u8(), "timeMs": r.u32()}
+
+	case MsgTypeRaceFinished:
+		f = map[string]interface{}{"type": "race-finished", "playerId": r.u16(), "place": r.u8(), "timeMs": r.u32()}
+
+	case MsgTypeLeaderboard:
+		f = map[string]interface{}{"type": "leaderboard", "race": r.u16(), "final": r.u8() != 0}
+		count := int(r.u8())
+		standings := make([]map[string]interface{}, 0, count)
+		for i := 0; i < count && r.err == nil; i++ {
+			standings = append(standings, map[string]interface{}{"playerId": r.u16(), "lap": r.u8(), "checkpoint": r.u8(), "timeMs": r.u32()})
+		}
+		f["standings"] = standings
+
+	case MsgTypeClipSaved:
+		f = map[string]interface{}{"type": "clip-saved", "id": r.str()}
+
+	case MsgTypeRoomSettings:
+		flags := r.u8()
+		f = map[string]interface{}{
+			"type":     "room-settings",
+			"closed":   flags&RoomSettingsClosed != 0,
+			"password": flags&RoomSettingsPassword != 0,
+		}
+
+	case MsgTypeChallenge:
+		f = map[string]interface{}{"type": "challenge", "nonce": hex.EncodeToString(r.next(EventNonceLen))}
+
+	case MsgTypeProbe:
+		f = map[string]interface{}{"type": "probe", "nonce": hex.EncodeToString(r.next(TelemetryNonceLen)), "frames": r.u8()}
+
+	case MsgTypeMutes:
+		count := int(r.u8())
+		names := make([]string, 0, count)
+		for i := 0; i < count && r.err == nil; i++ {
+			names = append(names, r.str())
+		}
+		f = map[string]interface{}{"type": "mutes", "names": names}
+
+	case MsgTypeError:
+		f = map[string]interface{}{"type": "error", "code": r.u8(), "message": r.str()}
+
+	default:
+		return nil, fmt.Errorf("%w 0x%02x", ErrUnknownMessage, msgType)
+	}
+
+	if r.err != nil {
+		return nil, fmt.Errorf("message 0x%02x: %w", msgType, r.err)
+	}
+	return f, nil
+}
+
+// wireReader reads little-endian fields, remembering the first short read
+type wireReader struct {
+	buf []byte
+	off int
+	err error
+}
+
+// next returns the next n bytes, or zeros after a short read
+func (r *wireReader) next(n int) []byte {
+	if r.err != nil || r.off+n > len(r.buf) {
+		r.err = ErrBufferTooSmall
+		return make([]byte, n)
+	}
+	b := r.buf[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *wireReader) u8() uint8    { return r.next(1)[0] }
+func (r *wireReader) u16() uint16  { return binary.LittleEndian.Uint16(r.next(2)) }
+func (r *wireReader) u32() uint32  { return binary.LittleEndian.Uint32(r.next(4)) }
+func (r *wireReader) u64() uint64  { return binary.LittleEndian.Uint64(r.next(8)) }
+func (r *wireReader) f32() float32 { return math.Float32frombits(r.u32()) }
+func (r *wireReader) f64() float64 { return math.Float64frombits(r.u64()) }
+
+// str reads a string with a one-byte length prefix
+func (r *wireReader) str() string {
+	n := int(r.u8())
+	return string(r.next(n))
+}
+
+// trackCurves reads a count byte and that many curves of a Track message
+func (r *wireReader) trackCurves() []map[string]interface{} {
+	count := int(r.u8())
+	curves := make([]map[string]interface{}, 0, count)
+	for i := 0; i < count && r.err == nil; i++ {
+		curves = append(curves, map[string]interface{}{
+			"amplitude":  r.f32(),
+			"wavelength": r.f32(),
+			"phase":      r.f32(),
+			"sharpness":  r.u8(),
+		})
+	}
+	return curves
+}
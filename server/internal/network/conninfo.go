@@ -0,0 +1,117 @@
+package network
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ConnInfo is the metadata of a client connection, carried by the
+// connection's context so work done on its behalf (in any package) can tell
+// whom it is for. Safe for concurrent use: rooms read the protocol version
+// while the read loop sets it.
+type ConnInfo struct {
+	ID          uint64 // Unique within the server process
+	IP          string // Client IP (proxy-aware)
+	Subprotocol string // Negotiated WebSocket subprotocol ("" for legacy clients)
+	Locale      string // Preferred language tag from Accept-Language ("" if none)
+
+	version atomic.Uint32 // Negotiated protocol version (v1 until Hello)
+
+	mu      sync.Mutex
+	account string // Name the player joined under ("" until the first join)
+}
+
+// connIDs numbers connections
+var connIDs atomic.Uint64
+
+// NewConnInfo creates the metadata of a new connection
+func NewConnInfo(ip, subprotocol, acceptLanguage string) *ConnInfo {
+	info := &ConnInfo{
+		ID:          connIDs.Add(1),
+		IP:          ip,
+		Subprotocol: subprotocol,
+		Locale:      preferredLocale(acceptLanguage),
+	}
+	info.version.Store(uint32(ProtocolV1))
+	return info
+}
+
+// Version returns the negotiated protocol version
+func (i *ConnInfo) Version() uint8 {
+	return uint8(i.version.Load())
+}
+
+// SetVersion records the version negotiated by Hello
+func (i *ConnInfo) SetVersion(version uint8) {
+	i.version.Store(uint32(version))
+}
+
+// Account returns the name the player plays under ("" before joining).
+// Players have no accounts beyond their name, which also keys their history
+// and leaderboard entries.
+func (i *ConnInfo) Account() string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.account
+}
+
+// SetAccount records the name of a join
+func (i *ConnInfo) SetAccount(name string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.account = name
+}
+
+// String identifies the connection in logs, e.g. "c42 (10.0.0.7, Alice)"
+func (i *ConnInfo) String() string {
+	id := "c" + strconv.FormatUint(i.ID, 10)
+	if account := i.Account(); account != "" {
+		return id + " (" + i.IP + ", " + account + ")"
+	}
+	return id + " (" + i.IP + ")"
+}
+
+// connInfoKey is the context key of the connection metadata
+type connInfoKey struct{}
+
+// WithConnInfo returns a context carrying connection metadata
+func WithConnInfo(ctx context.Context, info *ConnInfo) context.Context {
+	return context.WithValue(ctx, connInfoKey{}, info)
+}
+
+// ConnInfoFrom returns the connection metadata of a context (nil if the
+// work isn't done for a connection)
+func ConnInfoFrom(ctx context.Context) *ConnInfo {
+	info, _ := ctx.Value(connInfoKey{}).(*ConnInfo)
+	return info
+}
+
+// preferredLocale returns the language tag an Accept-Language header weighs
+// highest, e.g. "de-DE" for "en;q=0.5, de-DE". Ties go to the first listed.
+func preferredLocale(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" || len(tag) > 35 {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > bestQ {
+			best, bestQ = tag, q
+		}
+	}
+	return best
+}
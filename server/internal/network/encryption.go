@@ -0,0 +1,228 @@
+package network
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Payload encryption
+//
+// Where TLS ends at an edge the operator doesn't trust (a CDN, a shared
+// proxy), a client can encrypt its payloads end to end with the server. It
+// makes a fresh X25519 key for the connection and presents the public half
+// at the upgrade; both sides combine it with the server's static key (NaCl
+// box's key agreement) and derive a key per direction with HKDF-SHA256,
+// salted with both public keys. Frames are sealed with AES-256-GCM, which
+// stands in for NaCl's XSalsa20-Poly1305: neither the standard library nor
+// browsers' WebCrypto have the latter. Every frame of such a connection is
+// binary:
+//
+//	[counter:8][sealed: [frameType:1][frame]]
+//
+// The counter, which is the nonce (zero padded), counts the frames sent in
+// its direction from 1. It may skip (QA network simulation drops state
+// updates) but never go back, so frames can't be replayed or reordered.
+// A wrapping codec does all of this, so rooms and the protocol never see
+// it: the negotiated subprotocol's codec works inside it as without.
+
+// PayloadKeyLen is the length of X25519 keys, private and public
+const PayloadKeyLen = 32
+
+var (
+	ErrPayloadFrame   = errors.New("encrypted connection: frame not sealed")
+	ErrPayloadCounter = errors.New("encrypted connection: replayed or reordered frame")
+	ErrPayloadOpen    = errors.New("encrypted connection: frame failed authentication")
+)
+
+// HKDF labels of the two directions
+const (
+	payloadInfoUp   = "vracer payload client to server"
+	payloadInfoDown = "vracer payload server to client"
+)
+
+// PayloadOverhead is what sealing adds to a frame: counter, frame type and
+// GCM tag
+const PayloadOverhead = 8 + 1 + 16
+
+// PayloadKey is an X25519 key pair
+type PayloadKey struct {
+	key *ecdh.PrivateKey
+}
+
+// NewPayloadKey creates a key from a base64 encoded 32-byte X25519 private
+// key (any 32 random bytes are one)
+func NewPayloadKey(private string) (*PayloadKey, error) {
+	b, err := base64.StdEncoding.DecodeString(private)
+	if err != nil {
+		return nil, fmt.Errorf("payload key: %w", err)
+	}
+	key, err := ecdh.X25519().NewPrivateKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("payload key: %w", err)
+	}
+	return &PayloadKey{key: key}, nil
+}
+
+// GeneratePayloadKey makes a key for one connection
+func GeneratePayloadKey() (*PayloadKey, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &PayloadKey{key: key}, nil
+}
+
+// Public returns the raw public key
+func (k *PayloadKey) Public() []byte {
+	return k.key.PublicKey().Bytes()
+}
+
+// PayloadCipher seals the frames one side sends and opens those it
+// receives. Sealing and opening may run concurrently, each from one
+// goroutine.
+type PayloadCipher struct {
+	seal, open cipher.AEAD
+	sent       uint64 // Counter of the last frame sealed
+	received   uint64 // Counter of the last frame opened
+}
+
+// ServerPayloadCipher returns the server's cipher of a connection whose
+// client presented the public key client
+func ServerPayloadCipher(server *PayloadKey, client []byte) (*PayloadCipher, error) {
+	up, down, err := payloadKeys(server, client, client, server.Public())
+	if err != nil {
+		return nil, err
+	}
+	return newPayloadCipher(down, up)
+}
+
+// ClientPayloadCipher returns a client's cipher for a connection to the
+// server of the public key server, keyed with the connection's own key
+func ClientPayloadCipher(client *PayloadKey, server []byte) (*PayloadCipher, error) {
+	up, down, err := payloadKeys(client, server, client.Public(), server)
+	if err != nil {
+		return nil, err
+	}
+	return newPayloadCipher(up, down)
+}
+
+// payloadKeys derives the keys of both directions from own's private key
+// and the peer's public one
+func payloadKeys(own *PayloadKey, peer, client, server []byte) (up, down []byte, err error) {
+	peerKey, err := ecdh.X25519().NewPublicKey(peer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("payload key: %w", err)
+	}
+	secret, err := own.key.ECDH(peerKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("payload key: %w", err)
+	}
+
+	// HKDF-SHA256 (RFC 5869): one block of output per key
+	mac := hmac.New(sha256.New, append(append([]byte(nil), client...), server...))
+	mac.Write(secret)
+	prk := mac.Sum(nil)
+	expand := func(info string) []byte {
+		mac := hmac.New(sha256.New, prk)
+		mac.Write([]byte(info))
+		mac.Write([]byte{1})
+		return mac.Sum(nil)
+	}
+	return expand(payloadInfoUp), expand(payloadInfoDown), nil
+}
+
+func newPayloadCipher(sealKey, openKey []byte) (*PayloadCipher, error) {
+	seal, err := newGCM(sealKey)
+	if err != nil {
+		return nil, err
+	}
+	open, err := newGCM(openKey)
+	if err != nil {
+		return nil, err
+	}
+	return &PayloadCipher{seal: seal, open: open}, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// payloadNonce is a frame counter as a GCM nonce
+func payloadNonce(counter uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.LittleEndian.PutUint64(nonce, counter)
+	return nonce
+}
+
+// Seal encrypts a frame of the given type into a binary frame
+func (c *PayloadCipher) Seal(frameType int, data []byte) []byte {
+	c.sent++
+	buf := make([]byte, 8, len(data)+PayloadOverhead)
+	binary.LittleEndian.PutUint64(buf, c.sent)
+	plain := make([]byte, 1+len(data))
+	plain[0] = uint8(frameType)
+	copy(plain[1:], data)
+	return c.seal.Seal(buf, payloadNonce(c.sent), plain, nil)
+}
+
+// Open decrypts a sealed binary frame and returns the frame's type and data
+func (c *PayloadCipher) Open(frame []byte) (frameType int, data []byte, err error) {
+	if len(frame) < PayloadOverhead {
+		return 0, nil, ErrPayloadFrame
+	}
+	counter := binary.LittleEndian.Uint64(frame)
+	if counter <= c.received {
+		return 0, nil, ErrPayloadCounter
+	}
+	plain, err := c.open.Open(nil, payloadNonce(counter), frame[8:], nil)
+	if err != nil {
+		return 0, nil, ErrPayloadOpen
+	}
+	c.received = counter
+	return int(plain[0]), plain[1:], nil
+}
+
+// EncryptedCodec wraps a connection's codec in payload encryption
+func EncryptedCodec(inner Codec, c *PayloadCipher) Codec {
+	return encryptedCodec{inner: inner, cipher: c}
+}
+
+// encryptedCodec seals what its inner codec encodes and opens what it
+// decodes
+type encryptedCodec struct {
+	inner  Codec
+	cipher *PayloadCipher
+}
+
+func (c encryptedCodec) Subprotocol() string { return c.inner.Subprotocol() }
+
+func (c encryptedCodec) Decode(frameType int, data []byte) ([]byte, error) {
+	if frameType != FrameBinary {
+		return nil, ErrPayloadFrame
+	}
+	innerType, frame, err := c.cipher.Open(data)
+	if err != nil {
+		return nil, err
+	}
+	return c.inner.Decode(innerType, frame)
+}
+
+func (c encryptedCodec) Encode(version uint8, msg []byte) (int, []byte, error) {
+	frameType, frame, err := c.inner.Encode(version, msg)
+	if err != nil {
+		return 0, nil, err
+	}
+	return FrameBinary, c.cipher.Seal(frameType, frame), nil
+}
@@ -0,0 +1,366 @@
+package network
+
+// Protocol versions
+const (
+	ProtocolV1  uint8 = 1
+	ProtocolV2  uint8 = 2  // State records carry velocity hints
+	ProtocolV3  uint8 = 3  // Players missing from a state update are unchanged
+	ProtocolV4  uint8 = 4  // Rooms announce their tick rates (TickRate message)
+	ProtocolV5  uint8 = 5  // Join options (practice rooms) and the Reset message
+	ProtocolV6  uint8 = 6  // Tutorial rooms and the Tutorial message
+	ProtocolV7  uint8 = 7  // Custom tracks: track join option and the Track message
+	ProtocolV8  uint8 = 8  // Round awards (Results message)
+	ProtocolV9  uint8 = 9  // World rebasing (Rebase message)
+	ProtocolV10 uint8 = 10 // State updates carry the Y base of their records
+	ProtocolV11 uint8 = 11 // Interpolation delay recommendation (InterpDelay message)
+	ProtocolV12 uint8 = 12 // Guest sessions link to accounts (Link and Linked messages)
+	ProtocolV13 uint8 = 13 // Voice chat signaling relay (Signal and SignalRelay messages)
+	ProtocolV14 uint8 = 14 // Proximity groups (Nearby message)
+	ProtocolV15 uint8 = 15 // Cosmetics: join option and the Appearance message
+	ProtocolV16 uint8 = 16 // Spectators and the broadcast director (Director message)
+	ProtocolV17 uint8 = 17 // Lanes: state records carry the car's lane, Track messages the lane count
+	ProtocolV18 uint8 = 18 // Track messages carry elevation and banking
+	ProtocolV19 uint8 = 19 // Jump ramps: Track messages carry the ramps
+	ProtocolV20 uint8 = 20 // State updates end with the receiver's own extended state (fuel)
+	ProtocolV21 uint8 = 21 // Track variants: Track messages carry mirror and night
+	ProtocolV22 uint8 = 22 // Escalating roads: Track messages carry the escalation
+	ProtocolV23 uint8 = 23 // Endless mode: Milestone announcements and Prestige resets
+	ProtocolV24 uint8 = 24 // Collision events with their impact (Collision message)
+	ProtocolV25 uint8 = 25 // Simulated network conditions in QA rooms (NetSim message)
+	ProtocolV26 uint8 = 26 // Tournament rooms: Challenge and EventAuth handshake
+	ProtocolV27 uint8 = 27 // Voice chat mutes kept by the server (Mute and Mutes messages)
+	ProtocolV28 uint8 = 28 // Session tokens to rejoin a room where the player left (Session message)
+	ProtocolV29 uint8 = 29 // Name and color changes in a room (UpdateProfile message)
+	ProtocolV30 uint8 = 30 // Private rooms with join codes (CreateRoom, JoinByCode and RoomCode messages)
+	ProtocolV31 uint8 = 31 // Relay rooms: teams take turns driving (Relay message)
+	ProtocolV32 uint8 = 32 // Open spectating of public rooms (Spectate message)
+	ProtocolV33 uint8 = 33 // Client telemetry sampling for anti-cheat (Probe and Telemetry messages)
+	ProtocolV34 uint8 = 34 // Race rooms: laps, checkpoints and finishes (RaceStart, CheckpointPassed, RaceFinished and Leaderboard messages)
+	ProtocolV35 uint8 = 35 // Input acknowledgement: state updates end the receiver's own state with the sequence number of its last input driven with
+	ProtocolV36 uint8 = 36 // Highlight clips of the last seconds of a room (Clip and ClipSaved messages)
+	ProtocolV37 uint8 = 37 // Collision modes: RoomInfo ends with whether the room's cars collide
+	ProtocolV38 uint8 = 38 // Host commands of private rooms (HostStartRace, HostSettings and RoomSettings messages)
+
+	// ProtocolVersionMin and ProtocolVersionMax bound what this server speaks.
+	// Clients that never send Hello are treated as ProtocolV1.
+	ProtocolVersionMin = ProtocolV1
+	ProtocolVersionMax = ProtocolV38
+)
+
+// MaxMessageSize is the transport read limit. Anything larger is a protocol
+// violation and closes the connection; per-type limits below are enforced
+// after the type byte and answered with an error message instead.
+const MaxMessageSize = 4096
+
+// DefaultMessageSizeLimit applies to message types without an explicit limit.
+const DefaultMessageSizeLimit = 64
+
+// messageSizeLimits holds the maximum size (including the type byte) of each
+// client message, per protocol version.
+var messageSizeLimits = map[uint8]map[uint8]int{
+	ProtocolV1:  v1MessageSizeLimits,
+	ProtocolV2:  v1MessageSizeLimits, // v2-v4 only changed server messages
+	ProtocolV3:  v1MessageSizeLimits,
+	ProtocolV4:  v1MessageSizeLimits,
+	ProtocolV5:  v5MessageSizeLimits,
+	ProtocolV6:  v5MessageSizeLimits, // v6 only added a server message
+	ProtocolV7:  v7MessageSizeLimits,
+	ProtocolV8:  v7MessageSizeLimits, // v8 only added a server message
+	ProtocolV9:  v7MessageSizeLimits, // v9 only added a server message
+	ProtocolV10: v7MessageSizeLimits, // v10 only changed a server message
+	ProtocolV11: v7MessageSizeLimits, // v11 only added a server message
+	ProtocolV12: v12MessageSizeLimits,
+	ProtocolV13: v13MessageSizeLimits,
+	ProtocolV14: v13MessageSizeLimits, // v14 only added a server message
+	ProtocolV15: v15MessageSizeLimits,
+	ProtocolV16: v15MessageSizeLimits, // v16 only added a server message
+	ProtocolV17: v15MessageSizeLimits, // v17 only changed server messages
+	ProtocolV18: v15MessageSizeLimits, // v18 only changed a server message
+	ProtocolV19: v15MessageSizeLimits, // v19 only changed a server message
+	ProtocolV20: v15MessageSizeLimits, // v20 only changed a server message
+	ProtocolV21: v15MessageSizeLimits, // v21 only changed a server message
+	ProtocolV22: v15MessageSizeLimits, // v22 only changed a server message
+	ProtocolV23: v23MessageSizeLimits,
+	ProtocolV24: v23MessageSizeLimits, // v24 only added a server message
+	ProtocolV25: v25MessageSizeLimits,
+	ProtocolV26: v26MessageSizeLimits,
+	ProtocolV27: v27MessageSizeLimits,
+	ProtocolV28: v27MessageSizeLimits, // v28 only added a server message
+	ProtocolV29: v29MessageSizeLimits,
+	ProtocolV30: v30MessageSizeLimits,
+	ProtocolV31: v30MessageSizeLimits, // v31 only added a server message
+	ProtocolV32: v32MessageSizeLimits,
+	ProtocolV33: v33MessageSizeLimits,
+	ProtocolV34: v33MessageSizeLimits, // v34 only added server messages
+	ProtocolV35: v33MessageSizeLimits, // v35 only changed server messages
+	ProtocolV36: v36MessageSizeLimits,
+	ProtocolV37: v36MessageSizeLimits, // v37 only changed a server message
+	ProtocolV38: v38MessageSizeLimits,
+}
+
+var v1MessageSizeLimits = map[uint8]int{
+	MsgTypeInput:     6,
+	MsgTypeJoinRoom:  2 + 255 + 1, // [type][nameLen][name:255][color]
+	MsgTypeLeaveRoom: 1,
+	MsgTypePing:      14, // 9, or 14 with performance data
+	MsgTypeHello:     2,
+	MsgTypeHostKick:  3,
+}
+
+var v5MessageSizeLimits = map[uint8]int{
+	MsgTypeInput:     6,
+	MsgTypeJoinRoom:  2 + 255 + 2, // [type][nameLen][name:255][color][options]
+	MsgTypeLeaveRoom: 1,
+	MsgTypePing:      14,
+	MsgTypeHello:     2,
+	MsgTypeHostKick:  3,
+	MsgTypeReset:     1,
+}
+
+var v7MessageSizeLimits = map[uint8]int{
+	MsgTypeInput:     6,
+	MsgTypeJoinRoom:  2 + 255 + 2 + 1 + TrackIDMaxLen + 2, // ...[options][idLen][id][version:2]
+	MsgTypeLeaveRoom: 1,
+	MsgTypePing:      14,
+	MsgTypeHello:     2,
+	MsgTypeHostKick:  3,
+	MsgTypeReset:     1,
+}
+
+var v12MessageSizeLimits = map[uint8]int{
+	MsgTypeInput:     6,
+	MsgTypeJoinRoom:  2 + 255 + 2 + 1 + TrackIDMaxLen + 2,
+	MsgTypeLeaveRoom: 1,
+	MsgTypePing:      14,
+	MsgTypeHello:     2,
+	MsgTypeHostKick:  3,
+	MsgTypeReset:     1,
+	MsgTypeLink:      3 + LinkTokenMaxLen, // [type][tokenLen:2][token]
+}
+
+var v13MessageSizeLimits = map[uint8]int{
+	MsgTypeInput:     6,
+	MsgTypeJoinRoom:  2 + 255 + 2 + 1 + TrackIDMaxLen + 2,
+	MsgTypeLeaveRoom: 1,
+	MsgTypePing:      14,
+	MsgTypeHello:     2,
+	MsgTypeHostKick:  3,
+	MsgTypeReset:     1,
+	MsgTypeLink:      3 + LinkTokenMaxLen,
+	MsgTypeSignal:    6 + SignalPayloadMaxLen, // [type][target:2][kind][payloadLen:2][payload]
+}
+
+var v15MessageSizeLimits = map[uint8]int{
+	MsgTypeInput:     6,
+	MsgTypeJoinRoom:  2 + 255 + 2 + 1 + TrackIDMaxLen + 2 + 2, // ...[version:2][trail][decal]
+	MsgTypeLeaveRoom: 1,
+	MsgTypePing:      14,
+	MsgTypeHello:     2,
+	MsgTypeHostKick:  3,
+	MsgTypeReset:     1,
+	MsgTypeLink:      3 + LinkTokenMaxLen,
+	MsgTypeSignal:    6 + SignalPayloadMaxLen,
+}
+
+var v23MessageSizeLimits = map[uint8]int{
+	MsgTypeInput:     6,
+	MsgTypeJoinRoom:  2 + 255 + 2 + 1 + TrackIDMaxLen + 2 + 2,
+	MsgTypeLeaveRoom: 1,
+	MsgTypePing:      14,
+	MsgTypeHello:     2,
+	MsgTypeHostKick:  3,
+	MsgTypeReset:     1,
+	MsgTypeLink:      3 + LinkTokenMaxLen,
+	MsgTypeSignal:    6 + SignalPayloadMaxLen,
+	MsgTypePrestige:  1,
+}
+
+var v25MessageSizeLimits = map[uint8]int{
+	MsgTypeInput:     6,
+	MsgTypeJoinRoom:  2 + 255 + 2 + 1 + TrackIDMaxLen + 2 + 2,
+	MsgTypeLeaveRoom: 1,
+	MsgTypePing:      14,
+	MsgTypeHello:     2,
+	MsgTypeHostKick:  3,
+	MsgTypeReset:     1,
+	MsgTypeLink:      3 + LinkTokenMaxLen,
+	MsgTypeSignal:    6 + SignalPayloadMaxLen,
+	MsgTypePrestige:  1,
+	MsgTypeNetSim:    6,
+}
+
+var v26MessageSizeLimits = map[uint8]int{
+	MsgTypeInput:     6,
+	MsgTypeJoinRoom:  2 + 255 + 2 + 1 + TrackIDMaxLen + 2 + 2,
+	MsgTypeLeaveRoom: 1,
+	MsgTypePing:      14,
+	MsgTypeHello:     2,
+	MsgTypeHostKick:  3,
+	MsgTypeReset:     1,
+	MsgTypeLink:      3 + LinkTokenMaxLen,
+	MsgTypeSignal:    6 + SignalPayloadMaxLen,
+	MsgTypePrestige:  1,
+	MsgTypeNetSim:    6,
+	MsgTypeEventAuth: 1 + EventMACLen,
+}
+
+var v27MessageSizeLimits = map[uint8]int{
+	MsgTypeInput:     6,
+	MsgTypeJoinRoom:  2 + 255 + 2 + 1 + TrackIDMaxLen + 2 + 2,
+	MsgTypeLeaveRoom: 1,
+	MsgTypePing:      14,
+	MsgTypeHello:     2,
+	MsgTypeHostKick:  3,
+	MsgTypeReset:     1,
+	MsgTypeLink:      3 + LinkTokenMaxLen,
+	MsgTypeSignal:    6 + SignalPayloadMaxLen,
+	MsgTypePrestige:  1,
+	MsgTypeNetSim:    6,
+	MsgTypeEventAuth: 1 + EventMACLen,
+	MsgTypeMute:      4,
+}
+
+var v29MessageSizeLimits = map[uint8]int{
+	MsgTypeInput:         6,
+	MsgTypeJoinRoom:      2 + 255 + 2 + 1 + TrackIDMaxLen + 2 + 2,
+	MsgTypeLeaveRoom:     1,
+	MsgTypePing:          14,
+	MsgTypeHello:         2,
+	MsgTypeHostKick:      3,
+	MsgTypeReset:         1,
+	MsgTypeLink:          3 + LinkTokenMaxLen,
+	MsgTypeSignal:        6 + SignalPayloadMaxLen,
+	MsgTypePrestige:      1,
+	MsgTypeNetSim:        6,
+	MsgTypeEventAuth:     1 + EventMACLen,
+	MsgTypeMute:          4,
+	MsgTypeUpdateProfile: 3 + 255, // [type][nameLen][name:255][color]
+}
+
+var v30MessageSizeLimits = map[uint8]int{
+	MsgTypeInput:         6,
+	MsgTypeJoinRoom:      2 + 255 + 2 + 1 + TrackIDMaxLen + 2 + 2,
+	MsgTypeLeaveRoom:     1,
+	MsgTypePing:          14,
+	MsgTypeHello:         2,
+	MsgTypeHostKick:      3,
+	MsgTypeReset:         1,
+	MsgTypeLink:          3 + LinkTokenMaxLen,
+	MsgTypeSignal:        6 + SignalPayloadMaxLen,
+	MsgTypePrestige:      1,
+	MsgTypeNetSim:        6,
+	MsgTypeEventAuth:     1 + EventMACLen,
+	MsgTypeMute:          4,
+	MsgTypeUpdateProfile: 3 + 255,
+	MsgTypeCreateRoom:    2 + RoomPasswordMaxLen + 1 + 255 + 2 + 2,                   // [type][pwLen][password][nameLen][name][color][options][trail][decal]
+	MsgTypeJoinByCode:    2 + RoomCodeLen + 1 + RoomPasswordMaxLen + 1 + 255 + 2 + 2, // [type][codeLen][code][pwLen][password]...
+}
+
+var v32MessageSizeLimits = map[uint8]int{
+	MsgTypeInput:         6,
+	MsgTypeJoinRoom:      2 + 255 + 2 + 1 + TrackIDMaxLen + 2 + 2,
+	MsgTypeLeaveRoom:     1,
+	MsgTypePing:          14,
+	MsgTypeHello:         2,
+	MsgTypeHostKick:      3,
+	MsgTypeReset:         1,
+	MsgTypeLink:          3 + LinkTokenMaxLen,
+	MsgTypeSignal:        6 + SignalPayloadMaxLen,
+	MsgTypePrestige:      1,
+	MsgTypeNetSim:        6,
+	MsgTypeEventAuth:     1 + EventMACLen,
+	MsgTypeMute:          4,
+	MsgTypeUpdateProfile: 3 + 255,
+	MsgTypeCreateRoom:    2 + RoomPasswordMaxLen + 1 + 255 + 2 + 2,
+	MsgTypeJoinByCode:    2 + RoomCodeLen + 1 + RoomPasswordMaxLen + 1 + 255 + 2 + 2,
+	MsgTypeSpectate:      2 + 255, // [type][idLen][roomID]
+}
+
+var v33MessageSizeLimits = map[uint8]int{
+	MsgTypeInput:         6,
+	MsgTypeJoinRoom:      2 + 255 + 2 + 1 + TrackIDMaxLen + 2 + 2,
+	MsgTypeLeaveRoom:     1,
+	MsgTypePing:          14,
+	MsgTypeHello:         2,
+	MsgTypeHostKick:      3,
+	MsgTypeReset:         1,
+	MsgTypeLink:          3 + LinkTokenMaxLen,
+	MsgTypeSignal:        6 + SignalPayloadMaxLen,
+	MsgTypePrestige:      1,
+	MsgTypeNetSim:        6,
+	MsgTypeEventAuth:     1 + EventMACLen,
+	MsgTypeMute:          4,
+	MsgTypeUpdateProfile: 3 + 255,
+	MsgTypeCreateRoom:    2 + RoomPasswordMaxLen + 1 + 255 + 2 + 2,
+	MsgTypeJoinByCode:    2 + RoomCodeLen + 1 + RoomPasswordMaxLen + 1 + 255 + 2 + 2,
+	MsgTypeSpectate:      2 + 255,
+	MsgTypeTelemetry:     3 + TelemetryNonceLen + 2*TelemetryMaxFrames + TelemetryMACLen, // [type][nonce][device][count][frames][mac]
+}
+
+var v36MessageSizeLimits = map[uint8]int{
+	MsgTypeInput:         6,
+	MsgTypeJoinRoom:      2 + 255 + 2 + 1 + TrackIDMaxLen + 2 + 2,
+	MsgTypeLeaveRoom:     1,
+	MsgTypePing:          14,
+	MsgTypeHello:         2,
+	MsgTypeHostKick:      3,
+	MsgTypeReset:         1,
+	MsgTypeLink:          3 + LinkTokenMaxLen,
+	MsgTypeSignal:        6 + SignalPayloadMaxLen,
+	MsgTypePrestige:      1,
+	MsgTypeNetSim:        6,
+	MsgTypeEventAuth:     1 + EventMACLen,
+	MsgTypeMute:          4,
+	MsgTypeUpdateProfile: 3 + 255,
+	MsgTypeCreateRoom:    2 + RoomPasswordMaxLen + 1 + 255 + 2 + 2,
+	MsgTypeJoinByCode:    2 + RoomCodeLen + 1 + RoomPasswordMaxLen + 1 + 255 + 2 + 2,
+	MsgTypeSpectate:      2 + 255,
+	MsgTypeTelemetry:     3 + TelemetryNonceLen + 2*TelemetryMaxFrames + TelemetryMACLen,
+	MsgTypeClip:          1,
+}
+
+var v38MessageSizeLimits = map[uint8]int{
+	MsgTypeInput:         6,
+	MsgTypeJoinRoom:      2 + 255 + 2 + 1 + TrackIDMaxLen + 2 + 2,
+	MsgTypeLeaveRoom:     1,
+	MsgTypePing:          14,
+	MsgTypeHello:         2,
+	MsgTypeHostKick:      3,
+	MsgTypeReset:         1,
+	MsgTypeLink:          3 + LinkTokenMaxLen,
+	MsgTypeSignal:        6 + SignalPayloadMaxLen,
+	MsgTypePrestige:      1,
+	MsgTypeNetSim:        6,
+	MsgTypeEventAuth:     1 + EventMACLen,
+	MsgTypeMute:          4,
+	MsgTypeUpdateProfile: 3 + 255,
+	MsgTypeCreateRoom:    2 + RoomPasswordMaxLen + 1 + 255 + 2 + 2,
+	MsgTypeJoinByCode:    2 + RoomCodeLen + 1 + RoomPasswordMaxLen + 1 + 255 + 2 + 2,
+	MsgTypeSpectate:      2 + 255,
+	MsgTypeTelemetry:     3 + TelemetryNonceLen + 2*TelemetryMaxFrames + TelemetryMACLen,
+	MsgTypeClip:          1,
+	MsgTypeHostStartRace: 1,
+	MsgTypeHostSettings:  3 + RoomPasswordMaxLen, // [type][flags][pwLen][password]
+}
+
+// MessageSizeLimit returns the maximum accepted size of a client message.
+func MessageSizeLimit(version, msgType uint8) int {
+	if limits, ok := messageSizeLimits[version]; ok {
+		if limit, ok := limits[msgType]; ok {
+			return limit
+		}
+	}
+	return DefaultMessageSizeLimit
+}
+
+// NegotiateVersion picks the protocol version to use for a client that
+// announced clientVersion. Returns false if there is no common version.
+func NegotiateVersion(clientVersion uint8) (uint8, bool) {
+	if clientVersion < ProtocolVersionMin {
+		return 0, false
+	}
+	if clientVersion > ProtocolVersionMax {
+		return ProtocolVersionMax, true
+	}
+	return clientVersion, true
+}
@@ -3,10 +3,31 @@ package network
 // Message types
 const (
 	// Client -> Server
-	MsgTypeInput      uint8 = 0x01
-	MsgTypeJoinRoom   uint8 = 0x02
-	MsgTypeLeaveRoom  uint8 = 0x03
-	MsgTypePing       uint8 = 0x04
+	MsgTypeInput         uint8 = 0x01
+	MsgTypeJoinRoom      uint8 = 0x02
+	MsgTypeLeaveRoom     uint8 = 0x03
+	MsgTypePing          uint8 = 0x04
+	MsgTypeHello         uint8 = 0x05
+	MsgTypeHostKick      uint8 = 0x06
+	MsgTypeReset         uint8 = 0x07
+	MsgTypeLink          uint8 = 0x08
+	MsgTypeSignal        uint8 = 0x09
+	MsgTypePrestige      uint8 = 0x0A
+	MsgTypeNetSim        uint8 = 0x0B
+	MsgTypeEventAuth     uint8 = 0x0C
+	MsgTypeMute          uint8 = 0x0D
+	MsgTypeUpdateProfile uint8 = 0x0E
+	MsgTypeCreateRoom    uint8 = 0x0F
+
+	// Client types go on after the server's 0x10-0x3F
+	MsgTypeJoinByCode uint8 = 0x40
+	MsgTypeSpectate   uint8 = 0x41
+	MsgTypeTelemetry  uint8 = 0x42
+	MsgTypeClip       uint8 = 0x43
+
+	// Host commands of private rooms
+	MsgTypeHostStartRace uint8 = 0x44
+	MsgTypeHostSettings  uint8 = 0x45
 
 	// Server -> Client
 	MsgTypeStateUpdate uint8 = 0x10
@@ -15,13 +36,60 @@ const (
 	MsgTypePlayerDeath uint8 = 0x13
 	MsgTypeRoomInfo    uint8 = 0x14
 	MsgTypePong        uint8 = 0x15
+	MsgTypeCooldown    uint8 = 0x16
+	MsgTypeHelloAck    uint8 = 0x17
+	MsgTypeScoreboard  uint8 = 0x18
+	MsgTypeHostChange  uint8 = 0x19
+	MsgTypeQueueStatus uint8 = 0x1A
+	MsgTypeTickRate    uint8 = 0x1B
+	MsgTypeTutorial    uint8 = 0x1C
+	MsgTypeTrack       uint8 = 0x1D
+	MsgTypeResults     uint8 = 0x1E
+	MsgTypeRebase      uint8 = 0x1F
+	MsgTypeInterpDelay uint8 = 0x20
+	MsgTypeLinked      uint8 = 0x21
+	MsgTypeSignalRelay uint8 = 0x22
+	MsgTypeNearby      uint8 = 0x23
+	MsgTypeAppearance  uint8 = 0x24
+	MsgTypeDirector    uint8 = 0x25
+	MsgTypeMilestone   uint8 = 0x26
+	MsgTypeCollision   uint8 = 0x27
+	MsgTypeChallenge   uint8 = 0x28
+	MsgTypeMutes       uint8 = 0x29
+	MsgTypeSession     uint8 = 0x2A
+	MsgTypeRoomCode    uint8 = 0x2B
+	MsgTypeRelay       uint8 = 0x2C
+	MsgTypeProbe       uint8 = 0x2D
 	MsgTypeError       uint8 = 0xFF
+
+	// Race rooms
+	MsgTypeRaceStart        uint8 = 0x2E
+	MsgTypeCheckpointPassed uint8 = 0x2F
+	MsgTypeRaceFinished     uint8 = 0x30
+	MsgTypeLeaderboard      uint8 = 0x31
+
+	// Highlight clips
+	MsgTypeClipSaved uint8 = 0x32
+
+	// Private rooms
+	MsgTypeRoomSettings uint8 = 0x33
 )
 
 // Player flags
 const (
 	FlagExploded uint8 = 1 << 0
 	FlagRespawning uint8 = 1 << 1
+	FlagGhost uint8 = 1 << 2 // Collisions disabled (e.g. ramming penalty)
+
+	// In the air off a jump ramp (only on tracks with ramps, ProtocolV19)
+	FlagAirborne uint8 = 1 << 3
+)
+
+// Car-to-car collisions of a room (ProtocolV37 RoomInfo)
+const (
+	CollisionsOn   uint8 = 0 // Cars push each other
+	CollisionsSoft uint8 = 1 // Weaker pushes
+	CollisionsOff  uint8 = 2 // Cars drive through each other
 )
 
 // Key flags (bit field)
@@ -67,8 +135,277 @@ type JoinMessage struct {
 	MsgType uint8
 	Name    string
 	Color   uint8
+	Options uint8 // ProtocolV5: JoinSolo, JoinReplay (0 if absent)
+
+	// ProtocolV7, with JoinTrack: custom track of the practice room
+	TrackID      string
+	TrackVersion uint16 // 0 for the latest version
+
+	// ProtocolV15, with JoinCosmetics: cosmetic IDs (0 for none)
+	Trail uint8
+	Decal uint8
 }
 
+// Join options (bit field)
+const (
+	JoinSolo      uint8 = 1 << 0 // Private practice room
+	JoinReplay    uint8 = 1 << 1 // Practice room shows the best run as a ghost car
+	JoinTutorial  uint8 = 1 << 2 // Private tutorial room (ProtocolV6)
+	JoinTrack     uint8 = 1 << 3 // Practice room on a custom track (ProtocolV7)
+	JoinCosmetics uint8 = 1 << 4 // Trail and decal follow (ProtocolV15)
+)
+
+// TrackIDMaxLen is the longest track ID a join can name
+const TrackIDMaxLen = 16
+
+// TrackCurve is one curve of a custom track's road (see the Track message)
+type TrackCurve struct {
+	Amplitude  float32
+	Wavelength float32
+	Phase      float32
+	Sharpness  uint8
+}
+
+// TrackMessage to client (ProtocolV7): a custom track's road, sent after
+// RoomInfo
+type TrackMessage struct {
+	MsgType   uint8
+	Width     uint16
+	Curves    []TrackCurve
+	Lanes     uint8        // ProtocolV17
+	Banking   float32      // ProtocolV18: 0..1
+	Elevation []TrackCurve // ProtocolV18: height profile (none: flat)
+	Ramps     []TrackRamp  // ProtocolV19: jump ramps
+	Variant   uint8        // ProtocolV21: TrackVariant* bits
+
+	// ProtocolV22: the bends' amplitude grows by EscalationRate of itself
+	// per unit of road, up to EscalationMax more (0, 0: it doesn't)
+	EscalationRate float32
+	EscalationMax  float32
+}
+
+// Track variants (bit field of TrackMessage.Variant, ProtocolV21)
+const (
+	TrackVariantMirror uint8 = 1 << 0 // Road mirrored left to right
+	TrackVariantNight  uint8 = 1 << 1 // Reduced visibility
+)
+
+// TrackRamp is a jump ramp of a TrackMessage (see track.Ramp)
+type TrackRamp struct {
+	Offset  float32
+	Spacing float32
+	Length  float32
+	Height  float32
+}
+
+// PingMessage from client (9 bytes, or 14 with performance data)
+type PingMessage struct {
+	MsgType   uint8
+	Timestamp uint64
+
+	// Optional client performance report
+	HasPerf       bool
+	FPS           uint8
+	InterpDelayMS uint16 // Interpolation buffer the client renders behind
+	JitterMS      uint16 // Perceived state update jitter
+}
+
+// HelloMessage from client: protocol handshake
+type HelloMessage struct {
+	MsgType uint8
+	Version uint8 // Highest protocol version the client speaks
+}
+
+// HostKickMessage from client: host removes a player
+type HostKickMessage struct {
+	MsgType  uint8
+	TargetID uint16
+}
+
+// HostSettingsMessage from client (ProtocolV38): the host changes who may
+// join their private room
+type HostSettingsMessage struct {
+	MsgType  uint8
+	Closed   bool   // No one new may join
+	Password string // Replaces the room's, "" for none
+}
+
+// LinkMessage from client (ProtocolV12): upgrade the guest session to the
+// account of a link token
+type LinkMessage struct {
+	MsgType uint8
+	Token   string
+}
+
+// LinkTokenMaxLen is the longest link token a Link message can carry
+const LinkTokenMaxLen = 512
+
+// SignalMessage from client (ProtocolV13): a WebRTC signaling payload for
+// another player in the room, relayed as is
+type SignalMessage struct {
+	MsgType  uint8
+	TargetID uint16
+	Kind     uint8
+	Payload  []byte
+}
+
+// Signal kinds: what the payload of a Signal message carries
+const (
+	SignalOffer     uint8 = 0 // SDP offer
+	SignalAnswer    uint8 = 1 // SDP answer
+	SignalCandidate uint8 = 2 // ICE candidate
+	SignalHangup    uint8 = 3 // Peer connection closed (empty payload)
+	SignalKindCount uint8 = 4 // Number of kinds
+)
+
+// SignalPayloadMaxLen is the largest payload a Signal message can carry
+const SignalPayloadMaxLen = 2048
+
+// NetSimMessage from client (ProtocolV25, QA rooms): network conditions
+// for the server to simulate on what it sends this client; all zero for
+// none
+type NetSimMessage struct {
+	MsgType   uint8
+	LatencyMS uint16 // Extra delay
+	JitterMS  uint16 // Random extra delay on top, up to this much
+	Loss      uint8  // Percent of state updates dropped
+}
+
+// Tournament handshake (ProtocolV26): a connection bound to a tournament
+// room gets a Challenge with a random nonce and answers with EventAuth,
+// the HMAC-SHA256 of the nonce keyed with the event's secret (see
+// EventAuthMAC)
+const (
+	EventNonceLen = 16
+	EventMACLen   = 32
+)
+
+// EventAuthMessage from client (ProtocolV26): proof of the event secret
+type EventAuthMessage struct {
+	MsgType uint8
+	MAC     [EventMACLen]byte
+}
+
+// MuteMessage from client (ProtocolV27): mutes or unmutes another player of
+// the room for voice chat, for the rest of the connection
+type MuteMessage struct {
+	MsgType  uint8
+	Op       uint8
+	TargetID uint16 // Player to mute or unmute (MuteOpMute, MuteOpUnmute)
+}
+
+// UpdateProfileMessage from client (ProtocolV29): changes the player's name
+// and color in the room they play in, keeping their progress
+type UpdateProfileMessage struct {
+	MsgType uint8
+	Name    string
+	Color   uint8
+}
+
+// PrivateRoomMessage from client (ProtocolV30): CreateRoom opens a private
+// room and joins it, JoinByCode joins one by its code. Join carries the
+// player's name, color and cosmetics; of the join options only
+// JoinCosmetics applies.
+type PrivateRoomMessage struct {
+	MsgType  uint8
+	Code     string // JoinByCode only
+	Password string // "" for none
+	Join     *JoinMessage
+}
+
+// SpectateMessage from client (ProtocolV32): watch a public room without a
+// car
+type SpectateMessage struct {
+	MsgType uint8
+	RoomID  string
+}
+
+// Client telemetry (ProtocolV33): at random times the server sends a
+// player a Probe with a random nonce and the number of frames it wants,
+// and the client answers with Telemetry: the nonce, its input device and
+// the times of its last frames, signed with the HMAC-SHA256 of the
+// official client's telemetry key (see TelemetryMAC)
+const (
+	TelemetryNonceLen  = 16
+	TelemetryMACLen    = 32
+	TelemetryMaxFrames = 64
+)
+
+// Input device classes of a Telemetry message
+const (
+	TelemetryDeviceUnknown  uint8 = 0
+	TelemetryDeviceKeyboard uint8 = 1
+	TelemetryDeviceTouch    uint8 = 2
+	TelemetryDeviceGamepad  uint8 = 3
+	TelemetryDeviceCount    uint8 = 4 // Number of classes
+)
+
+// TelemetryMessage from client (ProtocolV33): the answer to a Probe
+type TelemetryMessage struct {
+	MsgType uint8
+	Nonce   [TelemetryNonceLen]byte // The Probe's
+	Device  uint8                   // TelemetryDevice*
+	Frames  []uint16                // Frame times in tenths of a millisecond, oldest first
+	MAC     [TelemetryMACLen]byte
+}
+
+// RelayTeam is a relay room's team as its members hear of it
+// (ProtocolV31 Relay message)
+type RelayTeam struct {
+	Team      uint8
+	Active    uint16   // Player with the baton
+	Legs      uint16   // Legs completed
+	Leg       float32  // Distance of the leg in progress
+	LegLength float32  // Distance of a leg: the baton passes once it's driven
+	Distance  float32  // Combined distance of the team's legs
+	Members   []uint16 // In driving order
+}
+
+// RaceStart announces a race of a race room (ProtocolV34 RaceStart
+// message): at the countdown, at the start, and to players who join
+// during either
+type RaceStart struct {
+	Race        uint16 // Number of the race in the room
+	Laps        uint8
+	Checkpoints uint8   // Per lap, the lap's line last
+	LapLength   float32 // Road distance of a lap
+	CountdownMS uint16  // Until the start (0: started)
+	Entered     bool    // The receiver races in it
+}
+
+// RaceStanding is a racer's place in a race (ProtocolV34 Leaderboard
+// message). A racer has finished once they pass the last checkpoint of the
+// last lap.
+type RaceStanding struct {
+	PlayerID   uint16
+	Lap        uint8  // Lap of the last checkpoint passed (0: none yet)
+	Checkpoint uint8  // Its number in the lap
+	TimeMS     uint32 // Race time it was passed at
+}
+
+// RoomCodeLen is the length of a private room's join code, and
+// RoomPasswordMaxLen the longest password a private room can have
+const (
+	RoomCodeLen        = 6
+	RoomPasswordMaxLen = 64
+)
+
+// Private room settings flags (ProtocolV38 HostSettings and RoomSettings
+// messages)
+const (
+	RoomSettingsClosed   uint8 = 1 << 0 // No one new may join
+	RoomSettingsPassword uint8 = 1 << 1 // Joining takes a password (RoomSettings only)
+)
+
+// Mute operations: every one is answered with the Mutes list
+const (
+	MuteOpMute   uint8 = 0 // Mute the target
+	MuteOpUnmute uint8 = 1 // Unmute the target
+	MuteOpClear  uint8 = 2 // Unmute everyone
+	MuteOpList   uint8 = 3 // Change nothing, only send the list
+	MuteOpCount  uint8 = 4 // Number of operations
+)
+
 // StateUpdateMessage to client
 type StateUpdateMessage struct {
 	MsgType     uint8
@@ -77,7 +414,8 @@ type StateUpdateMessage struct {
 	Players     []PlayerStateData
 }
 
-// PlayerStateData in state update (16 bytes per player)
+// PlayerStateData in state update (16 bytes per player, 20 in ProtocolV2,
+// 21 in ProtocolV17)
 type PlayerStateData struct {
 	ID     uint16
 	X      int16  // Scaled by 10
@@ -87,8 +425,28 @@ type PlayerStateData struct {
 	Rating uint32 // 24-bit, stored in lower 3 bytes
 	Flags  uint8
 	Color  uint8
+	VelX   int16 // ProtocolV2 only: lateral velocity, scaled by 10
+	VelY   int16 // ProtocolV2 only: forward velocity, scaled by 10
+	Lane   uint8 // ProtocolV17 only: lane from the left edge, 1-based (0: off the road)
+}
+
+// OwnState is the receiver's own extended state at the end of a
+// ProtocolV20 state update: [size:1] + size bytes of fields, of which v20
+// defines [fuel:2] and v35 [ack:1] after it. Fields the room doesn't track
+// are left out from the end, so a room without fuel sends size 0 to v20
+// clients, as do updates to spectators; in front of an ack, its fuel is
+// OwnFuelUntracked.
+type OwnState struct {
+	HasFuel bool   // The room runs in fuel mode
+	Fuel    uint16 // Thousandths of a full tank
+	HasAck  bool   // ProtocolV35: the receiver has an input acknowledged
+	Ack     uint8  // Sequence number of the last input the state reflects
 }
 
+// OwnFuelUntracked is the fuel of a room without fuel in an own state that
+// goes on past it
+const OwnFuelUntracked uint16 = 0xFFFF
+
 // PlayerJoinMessage to client
 type PlayerJoinMessage struct {
 	MsgType uint8
@@ -110,6 +468,7 @@ type RoomInfoMessage struct {
 	PlayerCount  uint8
 	MaxPlayers   uint8
 	YourPlayerID uint16
+	Collisions   uint8 // ProtocolV37
 }
 
 // PongMessage to client
@@ -118,6 +477,108 @@ type PongMessage struct {
 	Timestamp uint64
 }
 
+// CooldownMessage to client: rejoin is blocked after a kick
+type CooldownMessage struct {
+	MsgType     uint8
+	RemainingMS uint32
+	Offenses    uint8
+}
+
+// HelloAckMessage to client: negotiated protocol version
+type HelloAckMessage struct {
+	MsgType uint8
+	Version uint8
+}
+
+// ScoreboardEntry in scoreboard message (4 bytes per player)
+type ScoreboardEntry struct {
+	ID    uint16
+	RTTMS uint16 // Smoothed round-trip time in milliseconds
+}
+
+// HostChangeMessage to client: new room host
+type HostChangeMessage struct {
+	MsgType uint8
+	HostID  uint16
+}
+
+// QueueStatusMessage to client: position in the join queue while the server is full
+type QueueStatusMessage struct {
+	MsgType  uint8
+	Position uint16 // 1-based position in the queue
+	Length   uint16 // Total number of waiting joins
+}
+
+// TickRateMessage to client (ProtocolV4): the room's simulation and
+// broadcast rates, sent on join and whenever they change
+type TickRateMessage struct {
+	MsgType       uint8
+	PhysicsRate   uint8 // Hz
+	BroadcastRate uint8 // Hz
+}
+
+// Award kinds of the Results message
+const (
+	AwardMVP           uint8 = 0 // Most rating earned in the round
+	AwardOvertakes     uint8 = 1 // Most overtakes
+	AwardCleanest      uint8 = 2 // Fewest contacts and crashes per 1000 units
+	AwardSurvival      uint8 = 3 // Longest run without a crash, in seconds
+	AwardFastestSector uint8 = 4 // Fastest sector, in seconds
+)
+
+// ResultAward is one award of the Results message (ProtocolV8), sent to
+// everyone in the room when a round ends
+type ResultAward struct {
+	Kind     uint8
+	PlayerID uint16
+	Value    float32
+}
+
+// Director shot reasons (ProtocolV16): why the director points the
+// broadcast camera at a car
+const (
+	DirectorLeader     uint8 = 0 // Following the leader of the standings
+	DirectorLeadChange uint8 = 1 // Target just took the lead from Second
+	DirectorCollision  uint8 = 2 // Target and Second are about to collide
+	DirectorBattle     uint8 = 3 // Target and Second fight for the lead at the end of the round
+	DirectorCrash      uint8 = 4 // Target, near the top of the standings, exploded
+)
+
+// Milestone kinds (ProtocolV23)
+const (
+	MilestoneDistance uint8 = 0 // Count-th milestone of the run; value is the rating bonus
+	MilestonePrestige uint8 = 1 // Count-th prestige reset; value is the banked score
+)
+
+// MilestoneMessage to clients (ProtocolV23): a car of an endless room
+// reached a milestone of its run, or reset its run for prestige
+type MilestoneMessage struct {
+	MsgType  uint8
+	PlayerID uint16
+	Kind     uint8
+	Count    uint16
+	Value    float32
+}
+
+// CollisionMessage to clients (ProtocolV24): two cars hit each other, with
+// the impact, their relative speed at contact in units/s, for clients to
+// scale sound and screen shake
+type CollisionMessage struct {
+	MsgType uint8
+	A       uint16
+	B       uint16
+	Impact  float32
+}
+
+// DirectorMessage to spectators (ProtocolV16): the car the broadcast
+// camera should follow, sent when the shot changes
+type DirectorMessage struct {
+	MsgType uint8
+	Target  uint16
+	Second  uint16 // Other car of the shot (0 if none)
+	Reason  uint8
+}
+
 // ErrorMessage to client
 type ErrorMessage struct {
 	MsgType uint8
@@ -127,8 +588,11 @@ type ErrorMessage struct {
 
 // Error codes
 const (
-	ErrorCodeInvalidMessage uint8 = 1
-	ErrorCodeRoomFull       uint8 = 2
-	ErrorCodeKicked         uint8 = 3
-	ErrorCodeServerError    uint8 = 4
+	ErrorCodeInvalidMessage     uint8 = 1
+	ErrorCodeRoomFull           uint8 = 2
+	ErrorCodeKicked             uint8 = 3
+	ErrorCodeServerError        uint8 = 4
+	ErrorCodeMessageTooLarge    uint8 = 5
+	ErrorCodeUnsupportedVersion uint8 = 6
+	ErrorCodeNotAllowed         uint8 = 7
 )
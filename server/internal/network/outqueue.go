@@ -0,0 +1,225 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Outbound priorities
+//
+// What the server sends a connection waits in an OutQueue until the write
+// pump takes it. A client that can't keep up, on a congested link or a
+// stalled tab, would otherwise fill one queue with state updates, the bulk
+// of the traffic, and lose whatever came next: an error, a player leaving,
+// the results. Each message type has a priority class with a queue of its
+// own:
+//
+//	critical  errors, cooldowns, the handshake and session changes
+//	high      room events: joins, leaves, explosions, room changes, results
+//	normal    state updates and what else refreshes at a steady rate
+//	low       cosmetic events: appearances, milestones, collision effects
+//
+// Higher classes are written first, but a class kept waiting behind
+// starvation-limit messages of higher ones goes next, so nothing waits
+// forever. A full normal or low queue drops its oldest message: a newer
+// state update supersedes it. A full critical or high queue can't drop
+// anything the client relies on, so Push fails and the connection should
+// be closed; the client reconnects and starts over.
+
+// Priority is a message's class in the outbound queues
+type Priority uint8
+
+const (
+	PriorityCritical Priority = iota
+	PriorityHigh
+	PriorityNormal
+	PriorityLow
+	PriorityCount
+)
+
+var priorityNames = [PriorityCount]string{"critical", "high", "normal", "low"}
+
+func (p Priority) String() string {
+	if p < PriorityCount {
+		return priorityNames[p]
+	}
+	return fmt.Sprintf("Priority(%d)", p)
+}
+
+// Lossy reports whether messages of the class may be dropped
+func (p Priority) Lossy() bool {
+	return p >= PriorityNormal
+}
+
+// Priorities are the classes of the server's message types
+type Priorities [256]Priority
+
+// DefaultPriorities returns the classes of every server message type;
+// types not listed are normal
+func DefaultPriorities() *Priorities {
+	p := new(Priorities)
+	for i := range p {
+		p[i] = PriorityNormal
+	}
+	for _, t := range []uint8{MsgTypeError, MsgTypeCooldown, MsgTypeHelloAck, MsgTypeSession, MsgTypeLinked, MsgTypeChallenge, MsgTypeProbe} {
+		p[t] = PriorityCritical
+	}
+	for _, t := range []uint8{
+		MsgTypePlayerJoin, MsgTypePlayerLeave, MsgTypePlayerDeath, MsgTypeRoomInfo, MsgTypeHostChange,
+		MsgTypeQueueStatus, MsgTypeTickRate, MsgTypeTutorial, MsgTypeTrack, MsgTypeResults, MsgTypeRebase,
+		MsgTypeInterpDelay, MsgTypeSignalRelay, MsgTypeMutes, MsgTypeRoomCode, MsgTypeRelay, MsgTypeRaceStart,
+		MsgTypeCheckpointPassed, MsgTypeRaceFinished, MsgTypeClipSaved, MsgTypeRoomSettings,
+	} {
+		p[t] = PriorityHigh
+	}
+	for _, t := range []uint8{MsgTypeAppearance, MsgTypeMilestone, MsgTypeCollision} {
+		p[t] = PriorityLow
+	}
+	return p
+}
+
+// ParsePriorities returns the default classes changed by overrides, a
+// comma separated list of type=class such as "0x26=high,0x27=normal"
+func ParsePriorities(overrides string) (*Priorities, error) {
+	p := DefaultPriorities()
+	for _, o := range strings.Split(overrides, ",") {
+		if o = strings.TrimSpace(o); o == "" {
+			continue
+		}
+		typ, class, ok := strings.Cut(o, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q: want type=class", o)
+		}
+		t, err := strconv.ParseUint(strings.TrimSpace(typ), 0, 8)
+		if err != nil {
+			return nil, fmt.Errorf("%q: bad message type", o)
+		}
+		c := PriorityCount
+		for i, name := range priorityNames {
+			if strings.TrimSpace(class) == name {
+				c = Priority(i)
+			}
+		}
+		if c == PriorityCount {
+			return nil, fmt.Errorf("%q: unknown class (critical, high, normal or low)", o)
+		}
+		p[t] = c
+	}
+	return p, nil
+}
+
+// ErrOutQueueFull is returned by Push for a message of a full queue that
+// can't drop anything
+var ErrOutQueueFull = errors.New("outbound queue full")
+
+// OutQueue holds a connection's outbound messages, one queue per class.
+// Any goroutine may push; one goroutine pops.
+type OutQueue struct {
+	priorities *Priorities
+	capacity   [PriorityCount]int
+	starvation int
+
+	mu     sync.Mutex
+	queues [PriorityCount][][]byte
+	waited [PriorityCount]int // Messages of higher classes popped while the class waited
+	ready  chan struct{}
+}
+
+// NewOutQueue returns an empty queue that holds up to capacity messages of
+// each class and serves a class kept waiting behind starvation messages
+func NewOutQueue(priorities *Priorities, capacity [PriorityCount]int, starvation int) *OutQueue {
+	return &OutQueue{
+		priorities: priorities,
+		capacity:   capacity,
+		starvation: starvation,
+		ready:      make(chan struct{}, 1),
+	}
+}
+
+// Push queues a message. dropped tells whether a message of its class was
+// dropped to make room; the error is ErrOutQueueFull if there was none.
+func (q *OutQueue) Push(msg []byte) (dropped bool, err error) {
+	class := PriorityNormal
+	if len(msg) > 0 {
+		class = q.priorities[msg[0]]
+	}
+
+	q.mu.Lock()
+	queue := q.queues[class]
+	if len(queue) >= q.capacity[class] {
+		if !class.Lossy() || len(queue) == 0 {
+			q.mu.Unlock()
+			return false, fmt.Errorf("%w: %d %s messages waiting", ErrOutQueueFull, len(queue), class)
+		}
+		queue[0] = nil
+		queue = queue[1:]
+		dropped = true
+	}
+	q.queues[class] = append(queue, msg)
+	q.mu.Unlock()
+
+	q.signal()
+	return dropped, nil
+}
+
+// Ready fires when messages may be waiting
+func (q *OutQueue) Ready() <-chan struct{} {
+	return q.ready
+}
+
+// Pop takes the next message, false if none are waiting
+func (q *OutQueue) Pop() ([]byte, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	next := PriorityCount
+	for class := PriorityCritical; class < PriorityCount; class++ {
+		if len(q.queues[class]) == 0 {
+			continue
+		}
+		if next == PriorityCount {
+			next = class
+		} else if q.waited[class] >= q.starvation {
+			next = class // Starved: goes before the higher class
+			break
+		}
+	}
+	if next == PriorityCount {
+		return nil, false
+	}
+
+	msg := q.queues[next][0]
+	q.queues[next][0] = nil
+	q.queues[next] = q.queues[next][1:]
+	q.waited[next] = 0
+	for class := next + 1; class < PriorityCount; class++ {
+		if len(q.queues[class]) > 0 {
+			q.waited[class]++
+		}
+	}
+	for class := range q.queues {
+		if len(q.queues[class]) > 0 {
+			q.signal()
+			break
+		}
+	}
+	return msg, true
+}
+
+// Len returns the messages of a class waiting
+func (q *OutQueue) Len(class Priority) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.queues[class])
+}
+
+// signal makes Ready fire
+func (q *OutQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
@@ -1,8 +1,11 @@
 package network
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"math"
 )
 
@@ -54,32 +57,501 @@ func (p *Protocol) DecodeJoin(data []byte) (*JoinMessage, error) {
 		return nil, ErrBufferTooSmall
 	}
 
-	return &JoinMessage{
+	msg := &JoinMessage{
 		MsgType: data[0],
 		Name:    string(data[2 : 2+nameLen]),
 		Color:   data[2+nameLen],
+	}
+
+	// Options: 1 byte (ProtocolV5)
+	if len(data) > 3+nameLen {
+		msg.Options = data[3+nameLen]
+	}
+
+	// Track: [idLen:1][id][version:2] (ProtocolV7)
+	if msg.Options&JoinTrack != 0 {
+		off := 4 + nameLen
+		if len(data) < off+1 {
+			return nil, ErrBufferTooSmall
+		}
+		idLen := int(data[off])
+		if idLen == 0 || idLen > TrackIDMaxLen {
+			return nil, ErrInvalidMessage
+		}
+		if len(data) < off+1+idLen+2 {
+			return nil, ErrBufferTooSmall
+		}
+		msg.TrackID = string(data[off+1 : off+1+idLen])
+		msg.TrackVersion = binary.LittleEndian.Uint16(data[off+1+idLen:])
+	}
+
+	// Cosmetics: [trail:1][decal:1] after the track (ProtocolV15)
+	if msg.Options&JoinCosmetics != 0 {
+		off := 4 + nameLen
+		if msg.Options&JoinTrack != 0 {
+			off += 1 + len(msg.TrackID) + 2
+		}
+		if len(data) < off+2 {
+			return nil, ErrBufferTooSmall
+		}
+		msg.Trail, msg.Decal = data[off], data[off+1]
+	}
+	return msg, nil
+}
+
+// DecodePing decodes a ping message with optional performance data
+func (p *Protocol) DecodePing(data []byte) (*PingMessage, error) {
+	if len(data) < 9 {
+		return nil, ErrBufferTooSmall
+	}
+
+	if data[0] != MsgTypePing {
+		return nil, ErrInvalidMessage
+	}
+
+	msg := &PingMessage{
+		MsgType:   data[0],
+		Timestamp: binary.LittleEndian.Uint64(data[1:9]),
+	}
+
+	// Performance data: [fps:1][interp_delay_ms:2][jitter_ms:2]
+	if len(data) >= 14 {
+		msg.HasPerf = true
+		msg.FPS = data[9]
+		msg.InterpDelayMS = binary.LittleEndian.Uint16(data[10:12])
+		msg.JitterMS = binary.LittleEndian.Uint16(data[12:14])
+	}
+
+	return msg, nil
+}
+
+// DecodeHello decodes a protocol handshake message
+func (p *Protocol) DecodeHello(data []byte) (*HelloMessage, error) {
+	if len(data) < 2 {
+		return nil, ErrBufferTooSmall
+	}
+
+	if data[0] != MsgTypeHello {
+		return nil, ErrInvalidMessage
+	}
+
+	return &HelloMessage{
+		MsgType: data[0],
+		Version: data[1],
+	}, nil
+}
+
+// DecodeHostKick decodes a host kick request
+func (p *Protocol) DecodeHostKick(data []byte) (*HostKickMessage, error) {
+	if len(data) < 3 {
+		return nil, ErrBufferTooSmall
+	}
+
+	if data[0] != MsgTypeHostKick {
+		return nil, ErrInvalidMessage
+	}
+
+	return &HostKickMessage{
+		MsgType:  data[0],
+		TargetID: binary.LittleEndian.Uint16(data[1:3]),
+	}, nil
+}
+
+// DecodeHostSettings decodes a private room's new settings from its host
+// (ProtocolV38): [flags:1][pwLen:1][password]
+func (p *Protocol) DecodeHostSettings(data []byte) (*HostSettingsMessage, error) {
+	if len(data) < 3 {
+		return nil, ErrBufferTooSmall
+	}
+
+	if data[0] != MsgTypeHostSettings {
+		return nil, ErrInvalidMessage
+	}
+
+	flags, pwLen := data[1], int(data[2])
+	if flags&^RoomSettingsClosed != 0 || pwLen > RoomPasswordMaxLen {
+		return nil, ErrInvalidMessage
+	}
+	if len(data) < 3+pwLen {
+		return nil, ErrBufferTooSmall
+	}
+
+	return &HostSettingsMessage{
+		MsgType:  data[0],
+		Closed:   flags&RoomSettingsClosed != 0,
+		Password: string(data[3 : 3+pwLen]),
+	}, nil
+}
+
+// DecodeNetSim decodes a network conditions request (ProtocolV25):
+// [latency_ms:2][jitter_ms:2][loss:1]
+func (p *Protocol) DecodeNetSim(data []byte) (*NetSimMessage, error) {
+	if len(data) < 6 {
+		return nil, ErrBufferTooSmall
+	}
+
+	if data[0] != MsgTypeNetSim {
+		return nil, ErrInvalidMessage
+	}
+
+	return &NetSimMessage{
+		MsgType:   data[0],
+		LatencyMS: binary.LittleEndian.Uint16(data[1:3]),
+		JitterMS:  binary.LittleEndian.Uint16(data[3:5]),
+		Loss:      data[5],
+	}, nil
+}
+
+// DecodeEventAuth decodes the answer to a tournament challenge
+// (ProtocolV26): [mac:32]
+func (p *Protocol) DecodeEventAuth(data []byte) (*EventAuthMessage, error) {
+	if len(data) < 1+EventMACLen {
+		return nil, ErrBufferTooSmall
+	}
+
+	if data[0] != MsgTypeEventAuth {
+		return nil, ErrInvalidMessage
+	}
+
+	msg := &EventAuthMessage{MsgType: data[0]}
+	copy(msg.MAC[:], data[1:])
+	return msg, nil
+}
+
+// DecodeMute decodes a mute request (ProtocolV27): [op][targetId:2]
+func (p *Protocol) DecodeMute(data []byte) (*MuteMessage, error) {
+	if len(data) < 4 {
+		return nil, ErrBufferTooSmall
+	}
+
+	if data[0] != MsgTypeMute {
+		return nil, ErrInvalidMessage
+	}
+
+	op := data[1]
+	if op >= MuteOpCount {
+		return nil, fmt.Errorf("invalid mute operation %d", op)
+	}
+
+	return &MuteMessage{
+		MsgType:  data[0],
+		Op:       op,
+		TargetID: binary.LittleEndian.Uint16(data[2:4]),
+	}, nil
+}
+
+// DecodeUpdateProfile decodes a name and color change (ProtocolV29):
+// [nameLen:1][name][color:1]
+func (p *Protocol) DecodeUpdateProfile(data []byte) (*UpdateProfileMessage, error) {
+	if len(data) < 3 {
+		return nil, ErrBufferTooSmall
+	}
+
+	if data[0] != MsgTypeUpdateProfile {
+		return nil, ErrInvalidMessage
+	}
+
+	nameLen := int(data[1])
+	if len(data) < 3+nameLen {
+		return nil, ErrBufferTooSmall
+	}
+
+	return &UpdateProfileMessage{
+		MsgType: data[0],
+		Name:    string(data[2 : 2+nameLen]),
+		Color:   data[2+nameLen],
+	}, nil
+}
+
+// DecodeSpectate decodes a request to watch a room (ProtocolV32):
+// [idLen:1][roomID]
+func (p *Protocol) DecodeSpectate(data []byte) (*SpectateMessage, error) {
+	if len(data) < 2 {
+		return nil, ErrBufferTooSmall
+	}
+
+	if data[0] != MsgTypeSpectate {
+		return nil, ErrInvalidMessage
+	}
+
+	idLen := int(data[1])
+	if idLen == 0 {
+		return nil, ErrInvalidMessage
+	}
+	if len(data) < 2+idLen {
+		return nil, ErrBufferTooSmall
+	}
+
+	return &SpectateMessage{MsgType: data[0], RoomID: string(data[2 : 2+idLen])}, nil
+}
+
+// DecodeTelemetry decodes the answer to a probe (ProtocolV33):
+// [nonce:16][device:1][count:1]+[frame:2][mac:32]
+func (p *Protocol) DecodeTelemetry(data []byte) (*TelemetryMessage, error) {
+	if len(data) < 3+TelemetryNonceLen+TelemetryMACLen {
+		return nil, ErrBufferTooSmall
+	}
+
+	if data[0] != MsgTypeTelemetry {
+		return nil, ErrInvalidMessage
+	}
+
+	msg := &TelemetryMessage{MsgType: data[0]}
+	copy(msg.Nonce[:], data[1:])
+	offset := 1 + TelemetryNonceLen
+	msg.Device = data[offset]
+	count := int(data[offset+1])
+	offset += 2
+	if count > TelemetryMaxFrames {
+		return nil, ErrInvalidMessage
+	}
+	if len(data) < offset+2*count+TelemetryMACLen {
+		return nil, ErrBufferTooSmall
+	}
+
+	msg.Frames = make([]uint16, count)
+	for i := range msg.Frames {
+		msg.Frames[i] = binary.LittleEndian.Uint16(data[offset+2*i:])
+	}
+	copy(msg.MAC[:], data[offset+2*count:])
+	return msg, nil
+}
+
+// DecodeCreateRoom decodes a request for a private room (ProtocolV30):
+// [pwLen:1][password] followed by the fields of a join, from [nameLen]
+func (p *Protocol) DecodeCreateRoom(data []byte) (*PrivateRoomMessage, error) {
+	if len(data) < 2 {
+		return nil, ErrBufferTooSmall
+	}
+
+	if data[0] != MsgTypeCreateRoom {
+		return nil, ErrInvalidMessage
+	}
+
+	msg := &PrivateRoomMessage{MsgType: data[0]}
+	rest, err := msg.decodePassword(data[1:])
+	if err != nil {
+		return nil, err
+	}
+	return msg, msg.decodeJoin(p, rest)
+}
+
+// DecodeJoinByCode decodes a join of a private room (ProtocolV30):
+// [codeLen:1][code][pwLen:1][password] followed by the fields of a join,
+// from [nameLen]
+func (p *Protocol) DecodeJoinByCode(data []byte) (*PrivateRoomMessage, error) {
+	if len(data) < 2 {
+		return nil, ErrBufferTooSmall
+	}
+
+	if data[0] != MsgTypeJoinByCode {
+		return nil, ErrInvalidMessage
+	}
+
+	codeLen := int(data[1])
+	if codeLen != RoomCodeLen {
+		return nil, ErrInvalidMessage
+	}
+	if len(data) < 2+codeLen+1 {
+		return nil, ErrBufferTooSmall
+	}
+
+	msg := &PrivateRoomMessage{MsgType: data[0], Code: string(data[2 : 2+codeLen])}
+	rest, err := msg.decodePassword(data[2+codeLen:])
+	if err != nil {
+		return nil, err
+	}
+	return msg, msg.decodeJoin(p, rest)
+}
+
+// decodePassword reads [pwLen:1][password] and returns what follows
+func (m *PrivateRoomMessage) decodePassword(data []byte) ([]byte, error) {
+	pwLen := int(data[0])
+	if pwLen > RoomPasswordMaxLen {
+		return nil, ErrInvalidMessage
+	}
+	if len(data) < 1+pwLen {
+		return nil, ErrBufferTooSmall
+	}
+	m.Password = string(data[1 : 1+pwLen])
+	return data[1+pwLen:], nil
+}
+
+// decodeJoin reads the fields of a join, which allow no options but
+// JoinCosmetics
+func (m *PrivateRoomMessage) decodeJoin(p *Protocol, data []byte) error {
+	join, err := p.DecodeJoin(append([]byte{MsgTypeJoinRoom}, data...))
+	if err != nil {
+		return err
+	}
+	if join.Options&^JoinCosmetics != 0 {
+		return ErrInvalidMessage
+	}
+	m.Join = join
+	return nil
+}
+
+// EventAuthMAC is the answer to a tournament challenge: the HMAC-SHA256 of
+// its nonce keyed with the event secret
+func EventAuthMAC(secret, nonce []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(nonce)
+	return mac.Sum(nil)
+}
+
+// TelemetryMAC signs a telemetry sample: the HMAC-SHA256, keyed with the
+// telemetry key, of the Telemetry message between its type and its MAC
+func TelemetryMAC(key, nonce []byte, device uint8, frames []uint16) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(nonce)
+	mac.Write([]byte{device, uint8(len(frames))})
+	for _, f := range frames {
+		mac.Write(binary.LittleEndian.AppendUint16(nil, f))
+	}
+	return mac.Sum(nil)
+}
+
+// DecodeLink decodes an account link request: [tokenLen:2][token]
+func (p *Protocol) DecodeLink(data []byte) (*LinkMessage, error) {
+	if len(data) < 3 {
+		return nil, ErrBufferTooSmall
+	}
+
+	if data[0] != MsgTypeLink {
+		return nil, ErrInvalidMessage
+	}
+
+	tokenLen := int(binary.LittleEndian.Uint16(data[1:3]))
+	if tokenLen == 0 || tokenLen > LinkTokenMaxLen {
+		return nil, ErrInvalidMessage
+	}
+	if len(data) < 3+tokenLen {
+		return nil, ErrBufferTooSmall
+	}
+
+	return &LinkMessage{
+		MsgType: data[0],
+		Token:   string(data[3 : 3+tokenLen]),
+	}, nil
+}
+
+// DecodeSignal decodes a signaling message:
+// [targetID:2][kind:1][payloadLen:2][payload]
+func (p *Protocol) DecodeSignal(data []byte) (*SignalMessage, error) {
+	if len(data) < 6 {
+		return nil, ErrBufferTooSmall
+	}
+
+	if data[0] != MsgTypeSignal {
+		return nil, ErrInvalidMessage
+	}
+
+	kind := data[3]
+	if kind >= SignalKindCount {
+		return nil, ErrInvalidMessage
+	}
+	payloadLen := int(binary.LittleEndian.Uint16(data[4:6]))
+	if payloadLen > SignalPayloadMaxLen {
+		return nil, ErrInvalidMessage
+	}
+	if len(data) < 6+payloadLen {
+		return nil, ErrBufferTooSmall
+	}
+
+	return &SignalMessage{
+		MsgType:  data[0],
+		TargetID: binary.LittleEndian.Uint16(data[1:3]),
+		Kind:     kind,
+		Payload:  data[6 : 6+payloadLen],
 	}, nil
 }
 
 // EncodeStateUpdate encodes a state update message
 func (p *Protocol) EncodeStateUpdate(tick uint16, players []PlayerStateData) []byte {
+	return p.EncodeStateUpdateVersion(ProtocolV1, tick, players)
+}
+
+// EncodeStateUpdateVersion encodes a state update message in the record
+// format of the given protocol version. ProtocolV2 records append the
+// velocity hints: [velX:2][velY:2], and ProtocolV17 records the lane after
+// them: [lane:1].
+func (p *Protocol) EncodeStateUpdateVersion(version uint8, tick uint16, players []PlayerStateData) []byte {
+	return p.EncodeStateUpdateBase(version, tick, 0, players)
+}
+
+// EncodeStateUpdateBase encodes a state update message whose record Ys are
+// relative to baseY, the road distance of Y=0. ProtocolV10 headers carry
+// it: [baseY:8] after the player count. Older versions leave it out.
+func (p *Protocol) EncodeStateUpdateBase(version uint8, tick uint16, baseY int64, players []PlayerStateData) []byte {
+	return p.EncodeStateUpdateOwn(version, tick, baseY, players, OwnState{})
+}
+
+// EncodeStateUpdateOwn encodes a state update message for one receiver:
+// ProtocolV20 messages end with its own extended state (see OwnState),
+// ProtocolV35 ones with its input ack in it.
+func (p *Protocol) EncodeStateUpdateOwn(version uint8, tick uint16, baseY int64, players []PlayerStateData, own OwnState) []byte {
 	playerCount := len(players)
 	if playerCount > 255 {
 		playerCount = 255
 	}
 
-	// Header: 4 bytes + 16 bytes per player
-	buf := make([]byte, 4+playerCount*16)
+	recordSize := 16
+	switch {
+	case version >= ProtocolV17:
+		recordSize = 21
+	case version >= ProtocolV2:
+		recordSize = 20
+	}
+	headerSize := 4
+	if version >= ProtocolV10 {
+		headerSize = 12
+	}
+	ownSize := -1 // No own state
+	if version >= ProtocolV20 {
+		ownSize = 0
+		if own.HasFuel {
+			ownSize = 2
+		}
+		if own.HasAck && version >= ProtocolV35 {
+			ownSize = 3
+		}
+	}
+
+	// Header + one record per player + own state
+	buf := make([]byte, headerSize+playerCount*recordSize+ownSize+1)
 
 	buf[0] = MsgTypeStateUpdate
 	binary.LittleEndian.PutUint16(buf[1:3], tick)
 	buf[3] = uint8(playerCount)
+	if version >= ProtocolV10 {
+		binary.LittleEndian.PutUint64(buf[4:12], uint64(baseY))
+	}
 
-	offset := 4
+	offset := headerSize
 	for i := 0; i < playerCount; i++ {
 		player := players[i]
 		p.encodePlayerState(buf[offset:], player)
-		offset += 16
+		if recordSize >= 20 {
+			binary.LittleEndian.PutUint16(buf[offset+16:offset+18], uint16(player.VelX))
+			binary.LittleEndian.PutUint16(buf[offset+18:offset+20], uint16(player.VelY))
+		}
+		if recordSize == 21 {
+			buf[offset+20] = player.Lane
+		}
+		offset += recordSize
+	}
+	if ownSize >= 0 {
+		buf[offset] = uint8(ownSize)
+		if ownSize >= 2 {
+			fuel := own.Fuel
+			if !own.HasFuel {
+				fuel = OwnFuelUntracked
+			}
+			binary.LittleEndian.PutUint16(buf[offset+1:], fuel)
+		}
+		if ownSize >= 3 {
+			buf[offset+3] = own.Ack
+		}
 	}
 
 	return buf
@@ -153,19 +625,34 @@ func (p *Protocol) EncodePlayerDeath(id uint16) []byte {
 
 // EncodeRoomInfo encodes room info message
 func (p *Protocol) EncodeRoomInfo(roomID string, playerCount, maxPlayers uint8, yourID uint16) []byte {
-	roomIDBytes := []byte(roomID)
+	return p.EncodeRoomInfoVersion(ProtocolV1, RoomInfoMessage{
+		RoomID:       roomID,
+		PlayerCount:  playerCount,
+		MaxPlayers:   maxPlayers,
+		YourPlayerID: yourID,
+	})
+}
+
+// EncodeRoomInfoVersion encodes room info for the given protocol version:
+// [idLen][id][players:1][max:1][yourId:2], and ProtocolV37 appends the
+// room's collisions: [collisions:1]
+func (p *Protocol) EncodeRoomInfoVersion(version uint8, msg RoomInfoMessage) []byte {
+	roomIDBytes := []byte(msg.RoomID)
 	if len(roomIDBytes) > 255 {
 		roomIDBytes = roomIDBytes[:255]
 	}
 
-	buf := make([]byte, 6+len(roomIDBytes))
+	buf := make([]byte, 6+len(roomIDBytes), 7+len(roomIDBytes))
 	buf[0] = MsgTypeRoomInfo
 	buf[1] = uint8(len(roomIDBytes))
 	copy(buf[2:], roomIDBytes)
 	offset := 2 + len(roomIDBytes)
-	buf[offset] = playerCount
-	buf[offset+1] = maxPlayers
-	binary.LittleEndian.PutUint16(buf[offset+2:], yourID)
+	buf[offset] = msg.PlayerCount
+	buf[offset+1] = msg.MaxPlayers
+	binary.LittleEndian.PutUint16(buf[offset+2:], msg.YourPlayerID)
+	if version >= ProtocolV37 {
+		buf = append(buf, msg.Collisions)
+	}
 
 	return buf
 }
@@ -178,6 +665,468 @@ func (p *Protocol) EncodePong(timestamp uint64) []byte {
 	return buf
 }
 
+// EncodeCooldown encodes a rejoin cooldown message
+func (p *Protocol) EncodeCooldown(remainingMS uint32, offenses uint8) []byte {
+	buf := make([]byte, 6)
+	buf[0] = MsgTypeCooldown
+	binary.LittleEndian.PutUint32(buf[1:5], remainingMS)
+	buf[5] = offenses
+	return buf
+}
+
+// EncodeHelloAck encodes the handshake response
+func (p *Protocol) EncodeHelloAck(version uint8) []byte {
+	return []byte{MsgTypeHelloAck, version}
+}
+
+// EncodeMessageTooLarge encodes the error sent for an oversize message
+func (p *Protocol) EncodeMessageTooLarge(msgType uint8, limit int) []byte {
+	return p.EncodeError(ErrorCodeMessageTooLarge, fmt.Sprintf("message 0x%02x exceeds %d bytes", msgType, limit))
+}
+
+// EncodeQueueStatus encodes a join queue position update
+func (p *Protocol) EncodeQueueStatus(position, length uint16) []byte {
+	buf := make([]byte, 5)
+	buf[0] = MsgTypeQueueStatus
+	binary.LittleEndian.PutUint16(buf[1:3], position)
+	binary.LittleEndian.PutUint16(buf[3:5], length)
+	return buf
+}
+
+// EncodeTickRate encodes a room tick rate notification
+func (p *Protocol) EncodeTickRate(physicsRate, broadcastRate uint8) []byte {
+	return []byte{MsgTypeTickRate, physicsRate, broadcastRate}
+}
+
+// EncodeTrack encodes a custom track's road: its width and curves, 13
+// bytes each (at most 255)
+func (p *Protocol) EncodeTrack(width uint16, curves []TrackCurve) []byte {
+	return p.EncodeTrackVersion(ProtocolV7, TrackMessage{Width: width, Curves: curves})
+}
+
+// EncodeTrackVersion encodes a custom track's road for the given protocol
+// version. ProtocolV17 appends the number of lanes: [lanes:1],
+// ProtocolV18 the banking and the elevation curves after it:
+// [banking:f32][count:1] + 13 bytes each, ProtocolV19 the jump ramps:
+// [count:1] + [offset:f32][spacing:f32][length:f32][height:f32] each, and
+// ProtocolV21 the variant: [variant:1], and ProtocolV22 the escalation:
+// [rate:f32][max:f32]. From ProtocolV21 on, rooms on the built-in road with
+// a variant or escalation send it too, without curves.
+func (p *Protocol) EncodeTrackVersion(version uint8, m TrackMessage) []byte {
+	curves, elevation, ramps := m.Curves, m.Elevation, m.Ramps
+	if len(curves) > 255 {
+		curves = curves[:255]
+	}
+	if len(elevation) > 255 {
+		elevation = elevation[:255]
+	}
+	if len(ramps) > 255 {
+		ramps = ramps[:255]
+	}
+
+	size := 4 + len(curves)*13
+	if version >= ProtocolV17 {
+		size++
+	}
+	if version >= ProtocolV18 {
+		size += 5 + len(elevation)*13
+	}
+	if version >= ProtocolV19 {
+		size += 1 + len(ramps)*16
+	}
+	if version >= ProtocolV21 {
+		size++
+	}
+	if version >= ProtocolV22 {
+		size += 8
+	}
+	buf := make([]byte, size)
+	buf[0] = MsgTypeTrack
+	binary.LittleEndian.PutUint16(buf[1:3], m.Width)
+	buf[3] = uint8(len(curves))
+
+	offset := putTrackCurves(buf, 4, curves)
+	if version >= ProtocolV17 {
+		buf[offset] = m.Lanes
+		offset++
+	}
+	if version >= ProtocolV18 {
+		binary.LittleEndian.PutUint32(buf[offset:], math.Float32bits(m.Banking))
+		buf[offset+4] = uint8(len(elevation))
+		offset = putTrackCurves(buf, offset+5, elevation)
+	}
+	if version >= ProtocolV19 {
+		buf[offset] = uint8(len(ramps))
+		offset++
+		for _, r := range ramps {
+			binary.LittleEndian.PutUint32(buf[offset:], math.Float32bits(r.Offset))
+			binary.LittleEndian.PutUint32(buf[offset+4:], math.Float32bits(r.Spacing))
+			binary.LittleEndian.PutUint32(buf[offset+8:], math.Float32bits(r.Length))
+			binary.LittleEndian.PutUint32(buf[offset+12:], math.Float32bits(r.Height))
+			offset += 16
+		}
+	}
+	if version >= ProtocolV21 {
+		buf[offset] = m.Variant
+		offset++
+	}
+	if version >= ProtocolV22 {
+		binary.LittleEndian.PutUint32(buf[offset:], math.Float32bits(m.EscalationRate))
+		binary.LittleEndian.PutUint32(buf[offset+4:], math.Float32bits(m.EscalationMax))
+	}
+
+	return buf
+}
+
+// putTrackCurves writes curves, 13 bytes each, at offset and returns the
+// offset after them
+func putTrackCurves(buf []byte, offset int, curves []TrackCurve) int {
+	for _, c := range curves {
+		binary.LittleEndian.PutUint32(buf[offset:], math.Float32bits(c.Amplitude))
+		binary.LittleEndian.PutUint32(buf[offset+4:], math.Float32bits(c.Wavelength))
+		binary.LittleEndian.PutUint32(buf[offset+8:], math.Float32bits(c.Phase))
+		buf[offset+12] = c.Sharpness
+		offset += 13
+	}
+	return offset
+}
+
+// EncodeResults encodes the awards of a finished round, 7 bytes each (at
+// most 255)
+func (p *Protocol) EncodeResults(round uint16, awards []ResultAward) []byte {
+	if len(awards) > 255 {
+		awards = awards[:255]
+	}
+
+	buf := make([]byte, 4+len(awards)*7)
+	buf[0] = MsgTypeResults
+	binary.LittleEndian.PutUint16(buf[1:3], round)
+	buf[3] = uint8(len(awards))
+
+	offset := 4
+	for _, a := range awards {
+		buf[offset] = a.Kind
+		binary.LittleEndian.PutUint16(buf[offset+1:], a.PlayerID)
+		binary.LittleEndian.PutUint32(buf[offset+3:], math.Float32bits(a.Value))
+		offset += 7
+	}
+
+	return buf
+}
+
+// EncodeRebase encodes a world rebase: the road now starts at origin (the
+// total distance shifted out), and every position moved back by shift
+func (p *Protocol) EncodeRebase(origin, shift float64) []byte {
+	buf := make([]byte, 17)
+	buf[0] = MsgTypeRebase
+	binary.LittleEndian.PutUint64(buf[1:9], math.Float64bits(origin))
+	binary.LittleEndian.PutUint64(buf[9:17], math.Float64bits(shift))
+	return buf
+}
+
+// EncodeInterpDelay encodes the interpolation delay recommended to a client
+func (p *Protocol) EncodeInterpDelay(delayMS uint16) []byte {
+	buf := make([]byte, 3)
+	buf[0] = MsgTypeInterpDelay
+	binary.LittleEndian.PutUint16(buf[1:3], delayMS)
+	return buf
+}
+
+// EncodeLinked confirms an account link with the account's name, truncated
+// to 255 bytes
+func (p *Protocol) EncodeLinked(account string) []byte {
+	nameBytes := []byte(account)
+	if len(nameBytes) > 255 {
+		nameBytes = nameBytes[:255]
+	}
+
+	buf := make([]byte, 2+len(nameBytes))
+	buf[0] = MsgTypeLinked
+	buf[1] = uint8(len(nameBytes))
+	copy(buf[2:], nameBytes)
+
+	return buf
+}
+
+// EncodeSignalRelay encodes a signaling payload relayed from another
+// player: [fromID:2][kind:1][payloadLen:2][payload]
+func (p *Protocol) EncodeSignalRelay(fromID uint16, kind uint8, payload []byte) []byte {
+	if len(payload) > SignalPayloadMaxLen {
+		payload = payload[:SignalPayloadMaxLen]
+	}
+
+	buf := make([]byte, 6+len(payload))
+	buf[0] = MsgTypeSignalRelay
+	binary.LittleEndian.PutUint16(buf[1:3], fromID)
+	buf[3] = kind
+	binary.LittleEndian.PutUint16(buf[4:6], uint16(len(payload)))
+	copy(buf[6:], payload)
+
+	return buf
+}
+
+// EncodeNearby encodes the IDs of the players near the receiver (at most
+// 255): [count:1] + [id:2] each
+func (p *Protocol) EncodeNearby(ids []uint16) []byte {
+	if len(ids) > 255 {
+		ids = ids[:255]
+	}
+
+	buf := make([]byte, 2+len(ids)*2)
+	buf[0] = MsgTypeNearby
+	buf[1] = uint8(len(ids))
+	for i, id := range ids {
+		binary.LittleEndian.PutUint16(buf[2+i*2:], id)
+	}
+
+	return buf
+}
+
+// EncodeAppearance encodes a car's cosmetics (0 for none):
+// [id:2][trail:1][decal:1]
+func (p *Protocol) EncodeAppearance(playerID uint16, trail, decal uint8) []byte {
+	buf := make([]byte, 5)
+	buf[0] = MsgTypeAppearance
+	binary.LittleEndian.PutUint16(buf[1:3], playerID)
+	buf[3] = trail
+	buf[4] = decal
+	return buf
+}
+
+// EncodeDirector encodes a camera hint for spectators:
+// [target:2][second:2][reason:1]
+func (p *Protocol) EncodeDirector(target, second uint16, reason uint8) []byte {
+	buf := make([]byte, 6)
+	buf[0] = MsgTypeDirector
+	binary.LittleEndian.PutUint16(buf[1:3], target)
+	binary.LittleEndian.PutUint16(buf[3:5], second)
+	buf[5] = reason
+	return buf
+}
+
+// EncodeMilestone encodes an endless-mode announcement (ProtocolV23):
+// [id:2][kind:1][count:2][value:f32]
+func (p *Protocol) EncodeMilestone(playerID uint16, kind uint8, count uint16, value float32) []byte {
+	buf := make([]byte, 10)
+	buf[0] = MsgTypeMilestone
+	binary.LittleEndian.PutUint16(buf[1:3], playerID)
+	buf[3] = kind
+	binary.LittleEndian.PutUint16(buf[4:6], count)
+	binary.LittleEndian.PutUint32(buf[6:10], math.Float32bits(value))
+	return buf
+}
+
+// EncodeCollision encodes a collision event (ProtocolV24):
+// [a:2][b:2][impact:f32]
+func (p *Protocol) EncodeCollision(a, b uint16, impact float32) []byte {
+	buf := make([]byte, 9)
+	buf[0] = MsgTypeCollision
+	binary.LittleEndian.PutUint16(buf[1:3], a)
+	binary.LittleEndian.PutUint16(buf[3:5], b)
+	binary.LittleEndian.PutUint32(buf[5:9], math.Float32bits(impact))
+	return buf
+}
+
+// EncodeRelay encodes a relay team (ProtocolV31): [team:1][active:2]
+// [legs:2][leg:f32][legLength:f32][distance:f32][count:1] + [id:2] each,
+// at most 255 members
+func (p *Protocol) EncodeRelay(t RelayTeam) []byte {
+	members := t.Members
+	if len(members) > 255 {
+		members = members[:255]
+	}
+
+	buf := make([]byte, 19+len(members)*2)
+	buf[0] = MsgTypeRelay
+	buf[1] = t.Team
+	binary.LittleEndian.PutUint16(buf[2:4], t.Active)
+	binary.LittleEndian.PutUint16(buf[4:6], t.Legs)
+	binary.LittleEndian.PutUint32(buf[6:10], math.Float32bits(t.Leg))
+	binary.LittleEndian.PutUint32(buf[10:14], math.Float32bits(t.LegLength))
+	binary.LittleEndian.PutUint32(buf[14:18], math.Float32bits(t.Distance))
+	buf[18] = uint8(len(members))
+	for i, id := range members {
+		binary.LittleEndian.PutUint16(buf[19+i*2:], id)
+	}
+	return buf
+}
+
+// EncodeRaceStart encodes the announcement of a race (ProtocolV34):
+// [race:2][laps:1][checkpoints:1][lapLength:f32][countdownMs:2][entered:1]
+func (p *Protocol) EncodeRaceStart(s RaceStart) []byte {
+	buf := make([]byte, 12)
+	buf[0] = MsgTypeRaceStart
+	binary.LittleEndian.PutUint16(buf[1:3], s.Race)
+	buf[3] = s.Laps
+	buf[4] = s.Checkpoints
+	binary.LittleEndian.PutUint32(buf[5:9], math.Float32bits(s.LapLength))
+	binary.LittleEndian.PutUint16(buf[9:11], s.CountdownMS)
+	if s.Entered {
+		buf[11] = 1
+	}
+	return buf
+}
+
+// EncodeCheckpointPassed encodes a racer passing a checkpoint
+// (ProtocolV34): [id:2][lap:1][checkpoint:1][timeMs:4]
+func (p *Protocol) EncodeCheckpointPassed(s RaceStanding) []byte {
+	buf := make([]byte, 9)
+	buf[0] = MsgTypeCheckpointPassed
+	binary.LittleEndian.PutUint16(buf[1:3], s.PlayerID)
+	buf[3] = s.Lap
+	buf[4] = s.Checkpoint
+	binary.LittleEndian.PutUint32(buf[5:9], s.TimeMS)
+	return buf
+}
+
+// EncodeRaceFinished encodes a racer finishing (ProtocolV34):
+// [id:2][place:1][timeMs:4]
+func (p *Protocol) EncodeRaceFinished(playerID uint16, place uint8, timeMS uint32) []byte {
+	buf := make([]byte, 8)
+	buf[0] = MsgTypeRaceFinished
+	binary.LittleEndian.PutUint16(buf[1:3], playerID)
+	buf[3] = place
+	binary.LittleEndian.PutUint32(buf[4:8], timeMS)
+	return buf
+}
+
+// EncodeLeaderboard encodes the standings of a race, leader first
+// (ProtocolV34): [race:2][final:1][count:1] + [id:2][lap:1][checkpoint:1]
+// [timeMs:4] per racer. Racers past 255 are left out.
+func (p *Protocol) EncodeLeaderboard(race uint16, final bool, standings []RaceStanding) []byte {
+	standings = standings[:min(len(standings), 255)]
+	buf := make([]byte, 5+len(standings)*8)
+	buf[0] = MsgTypeLeaderboard
+	binary.LittleEndian.PutUint16(buf[1:3], race)
+	if final {
+		buf[3] = 1
+	}
+	buf[4] = uint8(len(standings))
+	for i, s := range standings {
+		off := 5 + i*8
+		binary.LittleEndian.PutUint16(buf[off:], s.PlayerID)
+		buf[off+2] = s.Lap
+		buf[off+3] = s.Checkpoint
+		binary.LittleEndian.PutUint32(buf[off+4:], s.TimeMS)
+	}
+	return buf
+}
+
+// EncodeClipSaved encodes the retrieval ID of a highlight clip the player
+// asked for (ProtocolV36): [idLen][id]
+func (p *Protocol) EncodeClipSaved(id string) []byte {
+	return append([]byte{MsgTypeClipSaved, uint8(len(id))}, id...)
+}
+
+// EncodeChallenge encodes a tournament challenge (ProtocolV26): [nonce:16]
+func (p *Protocol) EncodeChallenge(nonce []byte) []byte {
+	buf := make([]byte, 1+EventNonceLen)
+	buf[0] = MsgTypeChallenge
+	copy(buf[1:], nonce)
+	return buf
+}
+
+// EncodeProbe encodes a request for a telemetry sample (ProtocolV33):
+// [nonce:16][frames:1]
+func (p *Protocol) EncodeProbe(nonce []byte, frames uint8) []byte {
+	buf := make([]byte, 2+TelemetryNonceLen)
+	buf[0] = MsgTypeProbe
+	copy(buf[1:], nonce)
+	buf[1+TelemetryNonceLen] = frames
+	return buf
+}
+
+// EncodeSession encodes the session token of a join (ProtocolV28):
+// [tokenLen:2][token]
+func (p *Protocol) EncodeSession(token string) []byte {
+	buf := binary.LittleEndian.AppendUint16([]byte{MsgTypeSession}, uint16(len(token)))
+	return append(buf, token...)
+}
+
+// EncodeRoomCode encodes the join code of the private room a player is in
+// (ProtocolV30): [codeLen][code]
+func (p *Protocol) EncodeRoomCode(code string) []byte {
+	return append([]byte{MsgTypeRoomCode, uint8(len(code))}, code...)
+}
+
+// EncodeRoomSettings encodes who may join the private room a player is in
+// (ProtocolV38): [flags:1]
+func (p *Protocol) EncodeRoomSettings(closed, password bool) []byte {
+	var flags uint8
+	if closed {
+		flags |= RoomSettingsClosed
+	}
+	if password {
+		flags |= RoomSettingsPassword
+	}
+	return []byte{MsgTypeRoomSettings, flags}
+}
+
+// EncodeMutes encodes the names a player muted (ProtocolV27):
+// [count][nameLen][name]... with names truncated to 255 bytes
+func (p *Protocol) EncodeMutes(names []string) []byte {
+	if len(names) > 255 {
+		names = names[:255]
+	}
+
+	buf := []byte{MsgTypeMutes, uint8(len(names))}
+	for _, name := range names {
+		if len(name) > 255 {
+			name = name[:255]
+		}
+		buf = append(buf, uint8(len(name)))
+		buf = append(buf, name...)
+	}
+	return buf
+}
+
+// EncodeTutorial encodes a tutorial prompt: step of steps (step == steps
+// when the tutorial is complete) and its text, truncated to 255 bytes
+func (p *Protocol) EncodeTutorial(step, steps uint8, text string) []byte {
+	textBytes := []byte(text)
+	if len(textBytes) > 255 {
+		textBytes = textBytes[:255]
+	}
+
+	buf := make([]byte, 4+len(textBytes))
+	buf[0] = MsgTypeTutorial
+	buf[1] = step
+	buf[2] = steps
+	buf[3] = uint8(len(textBytes))
+	copy(buf[4:], textBytes)
+
+	return buf
+}
+
+// EncodeHostChange encodes a host change notification
+func (p *Protocol) EncodeHostChange(hostID uint16) []byte {
+	buf := make([]byte, 3)
+	buf[0] = MsgTypeHostChange
+	binary.LittleEndian.PutUint16(buf[1:3], hostID)
+	return buf
+}
+
+// EncodeScoreboard encodes the per-room latency scoreboard
+func (p *Protocol) EncodeScoreboard(entries []ScoreboardEntry) []byte {
+	count := len(entries)
+	if count > 255 {
+		count = 255
+	}
+
+	buf := make([]byte, 2+count*4)
+	buf[0] = MsgTypeScoreboard
+	buf[1] = uint8(count)
+
+	offset := 2
+	for i := 0; i < count; i++ {
+		binary.LittleEndian.PutUint16(buf[offset:], entries[i].ID)
+		binary.LittleEndian.PutUint16(buf[offset+2:], entries[i].RTTMS)
+		offset += 4
+	}
+
+	return buf
+}
+
 // EncodeError encodes an error message
 func (p *Protocol) EncodeError(code uint8, message string) []byte {
 	msgBytes := []byte(message)
@@ -216,6 +1165,18 @@ func ConvertToPlayerStateData(id uint16, x, y, speed, angle, rating float64, exp
 	}
 }
 
+// ScaleFuel converts a share of a full tank (0 to 1) to the wire format
+// (thousandths)
+func ScaleFuel(share float64) uint16 {
+	return uint16(math.Round(math.Max(0, math.Min(1, share)) * 1000))
+}
+
+// ScaleVelocity converts a velocity in units per second to the wire format
+// (scaled by 10, clamped to the int16 range)
+func ScaleVelocity(v float64) int16 {
+	return int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, math.Round(v*10))))
+}
+
 // DecodeSteeringThrottle converts int8 values to float64
 func DecodeSteeringThrottle(steering, throttle int8) (float64, float64) {
 	return float64(steering) / 127.0, float64(throttle) / 127.0
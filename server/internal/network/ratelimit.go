@@ -0,0 +1,48 @@
+package network
+
+import "time"
+
+// RateLimiter is a token bucket used to cap inbound message rates.
+// It refills at rate tokens per second up to burst tokens.
+//
+// Not thread-safe: each connection owns one limiter and only its read
+// goroutine uses it.
+type RateLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a full token bucket
+func NewRateLimiter(rate, burst float64) *RateLimiter {
+	return &RateLimiter{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// Allow consumes a token if one is available
+func (l *RateLimiter) Allow(now time.Time) bool {
+	elapsed := now.Sub(l.last).Seconds()
+	l.last = now
+
+	l.tokens += elapsed * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// Full reports whether the bucket has refilled by now, so that dropping the
+// limiter and starting a new one would change nothing
+func (l *RateLimiter) Full(now time.Time) bool {
+	return l.tokens+now.Sub(l.last).Seconds()*l.rate >= l.burst
+}
@@ -0,0 +1,144 @@
+package network
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"maps"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The golden vectors of protocol/vectors.json (see cmd/protovectors), which
+// the web client checks its codec against too. Server messages must decode
+// to the fields recorded with them, as JSON clients get them (see codec.go),
+// and client messages must encode from their fields to the recorded bytes.
+
+// vectorsFile is the vectors' path from this package
+const vectorsFile = "../../../protocol/vectors.json"
+
+type vector struct {
+	Name      string                 `json:"name"`
+	Direction string                 `json:"direction"`
+	Type      uint8                  `json:"type"`
+	Hex       string                 `json:"hex"`
+	Fields    map[string]interface{} `json:"fields"`
+}
+
+func loadVectors(t *testing.T) []vector {
+	t.Helper()
+	data, err := os.ReadFile(vectorsFile)
+	if err != nil {
+		t.Fatalf("read vectors: %v", err)
+	}
+	var file struct {
+		Vectors []vector `json:"vectors"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatalf("parse vectors: %v", err)
+	}
+	if len(file.Vectors) == 0 {
+		t.Fatal("no vectors")
+	}
+	return file.Vectors
+}
+
+// messageName returns the JSON type of a vector's message, the vector's
+// name up to the variant
+func (v vector) messageName() string {
+	name, _, _ := strings.Cut(v.Name, "/")
+	return name
+}
+
+// version returns the protocol version the vector was encoded for, which
+// the vectors of versioned layouts record with their fields
+func (v vector) version() uint8 {
+	if version, ok := v.Fields["version"].(float64); ok {
+		return uint8(version)
+	}
+	return ProtocolV1
+}
+
+func (v vector) bytes(t *testing.T) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(v.Hex)
+	if err != nil {
+		t.Fatalf("hex: %v", err)
+	}
+	if len(data) == 0 || data[0] != v.Type {
+		t.Fatalf("bytes %s are not of message type %#x", v.Hex, v.Type)
+	}
+	return data
+}
+
+// normalized returns fields as they come out of JSON, numbers as float64
+func normalized(t *testing.T, fields map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	data, err := json.Marshal(fields)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var out map[string]interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	return out
+}
+
+func TestServerVectors(t *testing.T) {
+	for _, v := range loadVectors(t) {
+		if v.Direction != "server" {
+			continue
+		}
+		t.Run(v.Name, func(t *testing.T) {
+			frameType, frame, err := jsonCodec{}.Encode(v.version(), v.bytes(t))
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if frameType != FrameText {
+				t.Errorf("frame type %d, want text", frameType)
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(frame, &got); err != nil {
+				t.Fatalf("decoded JSON %s: %v", frame, err)
+			}
+			delete(got, "type")
+			want := normalized(t, v.Fields)
+			if _, ok := got["version"]; !ok {
+				delete(want, "version")
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("decoded\n  %v\nwant\n  %v", got, want)
+			}
+		})
+	}
+}
+
+func TestClientVectors(t *testing.T) {
+	for _, v := range loadVectors(t) {
+		if v.Direction != "client" {
+			continue
+		}
+		t.Run(v.Name, func(t *testing.T) {
+			want := v.bytes(t)
+			msg := maps.Clone(v.Fields)
+			msg["type"] = v.messageName()
+			text, err := json.Marshal(msg)
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+
+			got, err := jsonCodec{}.Decode(FrameText, text)
+			if err != nil {
+				t.Fatalf("encode %s: %v", text, err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("encoded %s to %x, want %s", text, got, v.Hex)
+			}
+			if limit := MessageSizeLimit(ProtocolVersionMax, v.Type); len(want) > limit {
+				t.Errorf("%d bytes, over the limit of %d", len(want), limit)
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+// Package outbox keeps the writes to the shared store that failed, so a
+// short Redis or database outage doesn't lose scores and records.
+//
+// Every write goes through Do under a kind whose handler (see Handle)
+// performs it. If the handler fails, the write is added to the outbox, a
+// JSON lines file in the data directory that survives restarts, and Replay
+// retries the pending writes in order until they succeed. While writes are
+// pending, new ones join the outbox behind them rather than overtake them.
+// A write with a key supersedes the pending writes of the same key (e.g.
+// snapshots of a board), and a write older than config.OutboxMaxAge is
+// given up. Handlers should be idempotent: a write that failed halfway is
+// replayed whole.
+package outbox
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/race/server/config"
+)
+
+// Entry is a pending write
+type Entry struct {
+	Kind   string          `json:"kind"`
+	Key    string          `json:"key,omitempty"` // Superseded by later writes of the same key
+	Data   json.RawMessage `json:"data"`
+	Queued time.Time       `json:"queued"`
+
+	seq uint64 // Order of queueing, not saved
+}
+
+// Handler performs a write of its kind from the write's data
+type Handler func(data []byte) error
+
+// Outbox holds the pending writes in a file
+type Outbox struct {
+	path string
+
+	mu        sync.Mutex
+	entries   []Entry
+	handlers  map[string]Handler
+	seq       uint64 // Of the last entry queued
+	replaying bool   // A Replay is running its handlers
+}
+
+// Open loads the pending writes of the outbox file at path, creating its
+// directory if needed
+func Open(path string) (*Outbox, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	o := &Outbox{path: path, handlers: make(map[string]Handler)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("outbox %s: %w", path, err)
+		}
+		o.seq++
+		e.seq = o.seq
+		o.entries = append(o.entries, e)
+	}
+	return o, scanner.Err()
+}
+
+// Handle sets the handler of a kind of write. Set every handler before the
+// first Do or Replay.
+func (o *Outbox) Handle(kind string, handler Handler) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.handlers[kind] = handler
+}
+
+// Do performs a write of a kind with v as its data. If the write fails it
+// is logged and kept for Replay, and while writes are pending it is kept
+// behind them without being tried. Do only returns the errors of writes it
+// can't keep (data that doesn't marshal, a kind without a handler).
+func (o *Outbox) Do(kind, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	handler, ok := o.handlers[kind]
+	if !ok {
+		o.mu.Unlock()
+		return fmt.Errorf("outbox: no handler for %q", kind)
+	}
+	if len(o.entries) > 0 || o.replaying {
+		defer o.mu.Unlock()
+		if key != "" {
+			o.dropKeyUnlocked(key)
+		}
+		o.queueUnlocked(Entry{Kind: kind, Key: key, Data: data, Queued: time.Now().UTC()})
+		o.saveUnlocked()
+		return nil
+	}
+	o.mu.Unlock()
+
+	writeErr := handler(data)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	// A write of the key that failed meanwhile is older than this one
+	changed := key != "" && o.dropKeyUnlocked(key)
+	if writeErr != nil {
+		log.Printf("Outbox: queued a %q write: %v", kind, writeErr)
+		o.queueUnlocked(Entry{Kind: kind, Key: key, Data: data, Queued: time.Now().UTC()})
+		changed = true
+	}
+	if changed {
+		o.saveUnlocked()
+	}
+	return nil
+}
+
+// Replay retries the pending writes in order, stopping at the first that
+// fails again (the store is likely still down). Returns the number of
+// writes done. Call periodically; the handlers run without holding the
+// outbox, new writes queue behind the pending ones meanwhile, and a Replay
+// while another runs does nothing.
+func (o *Outbox) Replay() int {
+	o.mu.Lock()
+	if o.replaying || len(o.entries) == 0 {
+		o.mu.Unlock()
+		return 0
+	}
+	o.replaying = true
+	batch := slices.Clone(o.entries)
+	handlers := make([]Handler, len(batch))
+	for i, e := range batch {
+		handlers[i] = o.handlers[e.Kind]
+	}
+	o.mu.Unlock()
+
+	done, replayed := 0, 0
+	cutoff := time.Now().Add(-config.OutboxMaxAge)
+	for i, e := range batch {
+		if handlers[i] == nil {
+			log.Printf("Outbox: giving up a %q write without a handler", e.Kind)
+		} else if e.Queued.Before(cutoff) {
+			log.Printf("Outbox: giving up a %q write queued at %s", e.Kind, e.Queued.Format(time.RFC3339))
+		} else if err := handlers[i](e.Data); err != nil {
+			log.Printf("Outbox: %d writes still pending: %v", len(batch)-i, err)
+			break
+		} else {
+			done++
+		}
+		replayed++
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.replaying = false
+	if replayed == 0 {
+		return done
+	}
+	// Trim what was replayed, unless a keyed write superseded it meanwhile
+	last := batch[replayed-1].seq
+	n := 0
+	for n < len(o.entries) && o.entries[n].seq <= last {
+		n++
+	}
+	if n > 0 {
+		o.entries = o.entries[n:]
+		o.saveUnlocked()
+	}
+	return done
+}
+
+// Len returns the number of pending writes
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.entries)
+}
+
+// queueUnlocked adds a pending write, giving up the oldest if the outbox
+// is full.
+// IMPORTANT: Caller must hold o.mu.
+func (o *Outbox) queueUnlocked(e Entry) {
+	o.seq++
+	e.seq = o.seq
+	o.entries = append(o.entries, e)
+	if over := len(o.entries) - config.OutboxMaxEntries; over > 0 {
+		log.Printf("Outbox full: giving up %d oldest writes", over)
+		o.entries = append(o.entries[:0], o.entries[over:]...)
+	}
+}
+
+// dropKeyUnlocked removes the pending writes of a key, reporting whether
+// there were any.
+// IMPORTANT: Caller must hold o.mu.
+func (o *Outbox) dropKeyUnlocked(key string) bool {
+	kept := o.entries[:0]
+	for _, e := range o.entries {
+		if e.Key != key {
+			kept = append(kept, e)
+		}
+	}
+	dropped := len(kept) < len(o.entries)
+	clear(o.entries[len(kept):])
+	o.entries = kept
+	return dropped
+}
+
+// saveUnlocked replaces the outbox file with the pending writes, or
+// removes it when there are none.
+// IMPORTANT: Caller must hold o.mu.
+func (o *Outbox) saveUnlocked() {
+	if err := o.writeUnlocked(); err != nil {
+		log.Printf("Failed to save outbox: %v", err)
+	}
+}
+
+// writeUnlocked writes the outbox file for saveUnlocked.
+// IMPORTANT: Caller must hold o.mu.
+func (o *Outbox) writeUnlocked() error {
+	if len(o.entries) == 0 {
+		if err := os.Remove(o.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range o.entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	tmp := o.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, o.path)
+}
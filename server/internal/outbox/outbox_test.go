@@ -0,0 +1,102 @@
+package outbox
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// store records the writes it takes while up
+type store struct {
+	down   bool
+	writes []string
+}
+
+func (s *store) handler(data []byte) error {
+	if s.down {
+		return errors.New("store down")
+	}
+	s.writes = append(s.writes, string(data))
+	return nil
+}
+
+func openTest(t *testing.T) *Outbox {
+	t.Helper()
+	o, err := Open(filepath.Join(t.TempDir(), "outbox.jsonl"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	return o
+}
+
+// TestOrder checks that writes made while others are pending wait behind
+// them, keyed ones superseding their key's, and survive a restart
+func TestOrder(t *testing.T) {
+	o := openTest(t)
+	s := &store{down: true}
+	o.Handle("write", s.handler)
+
+	o.Do("write", "", 1)
+	o.Do("write", "board", 2)
+	s.down = false
+	o.Do("write", "", 3)
+	o.Do("write", "board", 4)
+	if len(s.writes) != 0 {
+		t.Fatalf("writes %v overtook the pending ones", s.writes)
+	}
+
+	reopened, err := Open(o.path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	reopened.Handle("write", s.handler)
+	if n := reopened.Replay(); n != 3 {
+		t.Errorf("replayed %d writes, want 3", n)
+	}
+	if want := []string{"1", "3", "4"}; !slices.Equal(s.writes, want) {
+		t.Errorf("writes %v, want %v", s.writes, want)
+	}
+	if n := reopened.Len(); n != 0 {
+		t.Errorf("%d writes pending, want none", n)
+	}
+}
+
+// TestReplayUnlocked checks that handlers replay without holding the
+// outbox: writes made meanwhile queue behind the replayed ones, even those
+// of a key being replayed
+func TestReplayUnlocked(t *testing.T) {
+	o := openTest(t)
+	s := &store{down: true}
+	o.Handle("write", func(data []byte) error {
+		if err := s.handler(data); err != nil || string(data) != "1" {
+			return err
+		}
+		o.Do("write", "board", 3) // Deadlocks if Replay holds the outbox
+		return nil
+	})
+
+	o.Do("write", "", 1)
+	o.Do("write", "board", 2)
+	s.down = false
+	if n := o.Replay(); n != 2 {
+		t.Errorf("first replay did %d writes, want 2", n)
+	}
+	if n := o.Len(); n != 1 {
+		t.Fatalf("%d writes pending, want the one made during the replay", n)
+	}
+	if n := o.Replay(); n != 1 {
+		t.Errorf("second replay did %d writes, want 1", n)
+	}
+	if want := []string{"1", "2", "3"}; !slices.Equal(s.writes, want) {
+		t.Errorf("writes %v, want %v", s.writes, want)
+	}
+}
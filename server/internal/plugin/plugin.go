@@ -0,0 +1,230 @@
+// Package plugin is the registry of extensions compiled into the server, so
+// forks and other teams can add behavior without changing the core
+// packages.
+//
+// A plugin is a Go package of this module that registers its extensions
+// from an init function:
+//
+//	func init() {
+//		plugin.RegisterGameMode(elimination{})
+//	}
+//
+// and is linked in with a blank import in cmd/gameserver/plugins.go (a fork
+// can add a file of its own next to it, which merges without conflicts).
+// Nothing registered is used until configured by name:
+//
+//	GameMode        ROOM_RULES, a tenant's "rules" or the admin room config,
+//	                like a rules script (see internal/rules)
+//	ScoringPolicy   SCORING_POLICY
+//	AntiCheatRule   ANTICHEAT_RULES (comma-separated)
+//	StorageBackend  STORE_BACKEND, opened with STORE_URL
+//
+// The contract: names match [a-z0-9-]{1,32} and are unique per kind (a
+// game mode shadows a rules script of the same name; storage backends
+// can't reuse the built-in names). Registering twice panics, like
+// database/sql drivers. Game mode rules, scorers and anti-cheat rules are
+// called from a room's physics tick: they must not block, and a room's
+// rules are only ever called from that room's tick. Scorers and anti-cheat
+// rules are shared by every room and must be safe for concurrent use.
+// plugins/example has one extension of every kind.
+package plugin
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/race/server/internal/game"
+	"github.com/race/server/internal/storage"
+)
+
+// GameMode is a set of room rules written in Go
+type GameMode interface {
+	Name() string
+	// NewRules creates the rules of one room
+	NewRules() (game.RoomRules, error)
+}
+
+// ScoringPolicy turns runs into leaderboard scores in place of the
+// built-in game.ScoringPolicy
+type ScoringPolicy interface {
+	Name() string
+	game.Scorer
+}
+
+// AntiCheatRule is a movement check run after the built-in ones
+type AntiCheatRule = game.AntiCheatRule
+
+// StorageBackend is a shared state backend besides memory, Redis and SQL
+type StorageBackend interface {
+	Name() string
+	// Open connects to the store at url (STORE_URL)
+	Open(url string) (storage.Store, error)
+}
+
+// Extension kinds, as listed by Extensions
+const (
+	KindGameMode       = "game mode"
+	KindScoringPolicy  = "scoring policy"
+	KindAntiCheatRule  = "anti-cheat rule"
+	KindStorageBackend = "storage backend"
+)
+
+// Extension is a registered extension
+type Extension struct {
+	Kind string
+	Name string
+}
+
+var namePattern = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+
+var registry = struct {
+	sync.RWMutex
+	modes     map[string]GameMode
+	scoring   map[string]ScoringPolicy
+	antiCheat map[string]AntiCheatRule
+	storage   map[string]StorageBackend
+}{
+	modes:     make(map[string]GameMode),
+	scoring:   make(map[string]ScoringPolicy),
+	antiCheat: make(map[string]AntiCheatRule),
+	storage:   make(map[string]StorageBackend),
+}
+
+// register adds an extension to its kind's map. Panics on a bad or taken
+// name: both are programming errors found the first time the server runs.
+func register[T any](kind string, m map[string]T, name string, ext T) {
+	if !namePattern.MatchString(name) {
+		panic(fmt.Sprintf("plugin: bad %s name %q", kind, name))
+	}
+	if _, dup := m[name]; dup {
+		panic(fmt.Sprintf("plugin: %s %q registered twice", kind, name))
+	}
+	m[name] = ext
+}
+
+// RegisterGameMode makes a game mode available to room configs
+func RegisterGameMode(mode GameMode) {
+	registry.Lock()
+	defer registry.Unlock()
+	register(KindGameMode, registry.modes, mode.Name(), mode)
+}
+
+// RegisterScoringPolicy makes a scoring policy available to SCORING_POLICY
+func RegisterScoringPolicy(policy ScoringPolicy) {
+	registry.Lock()
+	defer registry.Unlock()
+	register(KindScoringPolicy, registry.scoring, policy.Name(), policy)
+}
+
+// RegisterAntiCheatRule makes an anti-cheat rule available to
+// ANTICHEAT_RULES
+func RegisterAntiCheatRule(rule AntiCheatRule) {
+	registry.Lock()
+	defer registry.Unlock()
+	register(KindAntiCheatRule, registry.antiCheat, rule.Name(), rule)
+}
+
+// RegisterStorageBackend makes a storage backend available to STORE_BACKEND
+func RegisterStorageBackend(backend StorageBackend) {
+	name := backend.Name()
+	switch name {
+	case storage.BackendMemory, storage.BackendRedis, storage.BackendSQL:
+		panic(fmt.Sprintf("plugin: %s %q is built in", KindStorageBackend, name))
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	register(KindStorageBackend, registry.storage, name, backend)
+}
+
+// Extensions lists the registered extensions by kind and name
+func Extensions() []Extension {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	var exts []Extension
+	for name := range registry.modes {
+		exts = append(exts, Extension{KindGameMode, name})
+	}
+	for name := range registry.scoring {
+		exts = append(exts, Extension{KindScoringPolicy, name})
+	}
+	for name := range registry.antiCheat {
+		exts = append(exts, Extension{KindAntiCheatRule, name})
+	}
+	for name := range registry.storage {
+		exts = append(exts, Extension{KindStorageBackend, name})
+	}
+	sort.Slice(exts, func(i, j int) bool {
+		if exts[i].Kind != exts[j].Kind {
+			return exts[i].Kind < exts[j].Kind
+		}
+		return exts[i].Name < exts[j].Name
+	})
+	return exts
+}
+
+// LookupGameMode returns the game mode registered under name
+func LookupGameMode(name string) (GameMode, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	mode, ok := registry.modes[name]
+	return mode, ok
+}
+
+// LookupScoringPolicy returns the scoring policy registered under name
+func LookupScoringPolicy(name string) (ScoringPolicy, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	policy, ok := registry.scoring[name]
+	return policy, ok
+}
+
+// AntiCheatRules returns the anti-cheat rules registered under names, or
+// an error naming the first that isn't
+func AntiCheatRules(names []string) ([]game.AntiCheatRule, error) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	rules := make([]game.AntiCheatRule, 0, len(names))
+	for _, name := range names {
+		rule, ok := registry.antiCheat[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown %s %q", KindAntiCheatRule, name)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// OpenStore opens a store of a built-in or registered backend
+func OpenStore(backend, url string) (storage.Store, error) {
+	registry.RLock()
+	b, ok := registry.storage[backend]
+	registry.RUnlock()
+	if ok {
+		return b.Open(url)
+	}
+	return storage.Open(backend, url)
+}
+
+// Modes returns a rules library of the registered game modes, falling back
+// to lib (nil: none) for other names
+func Modes(lib game.RulesLibrary) game.RulesLibrary {
+	return modeLibrary{lib}
+}
+
+type modeLibrary struct {
+	fallback game.RulesLibrary
+}
+
+func (l modeLibrary) New(name string) (game.RoomRules, error) {
+	if mode, ok := LookupGameMode(name); ok {
+		return mode.NewRules()
+	}
+	if l.fallback == nil {
+		return nil, fmt.Errorf("unknown %s %q", KindGameMode, name)
+	}
+	return l.fallback.New(name)
+}
@@ -0,0 +1,92 @@
+// Package routines accounts for the server's long-running goroutines.
+//
+// Every goroutine the server starts for longer than a request goes through
+// Go with the name of its owner, so /stats and /debug/goroutines can show
+// how many each subsystem runs. A count that keeps growing points at the
+// owner that leaks; goroutines not started through Go show up as the
+// difference to runtime.NumGoroutine. A panic in one of them is reported
+// (see internal/crash) before it ends the process.
+//
+// Owners and their shutdown paths:
+//
+//	sim.clock          Scheduler.clock, queues due room ticks; exits on Scheduler.Stop (shutdown)
+//	sim.worker         Scheduler.worker, runs room ticks; exits on Scheduler.Stop (shutdown)
+//	conn.read          ClientConnection.readPump; exits when the socket read fails
+//	conn.write         ClientConnection.writePump; exits on close (context cancelled) or write failure
+//	server.cleanup     room/penalty/store sweeps; exits on GameServer.Shutdown
+//	server.stats       periodic stats log; exits on GameServer.Shutdown
+//	server.trends      load sampling for the /stats trends; exits on GameServer.Shutdown
+//	server.statsd      metrics pushes to a statsd agent (see cmd/gameserver/statsd.go); exits on GameServer.Shutdown
+//	server.leaderboard leaderboard persistence; exits on GameServer.Shutdown
+//	server.queue       join queue admission; exits on GameServer.Shutdown
+//	server.directory   cluster directory announcements; exits on GameServer.Shutdown
+//	server.agones      Agones readiness and health pings; exits on GameServer.Shutdown
+//	server.outbox      replays of failed store writes; exits on GameServer.Shutdown
+//	server.writer      store writes handed off by rooms (see cmd/gameserver/writer.go); exits on GameServer.Shutdown
+//	server.history     one profile read for a joining player's placement; exits when it is read (store timeout)
+//	server.cosmetics   one ownership check of a join's cosmetics; exits when it is read (store timeout)
+//	server.quarantine  one room quarantine's trace, webhook and migration (see cmd/gameserver/quarantine.go); exits when done (trace cut short on shutdown)
+//	console.listen     console socket accept loop; exits on GameServer.Shutdown (listener closed)
+//	console.session    one console session's commands; exits at the end of its input
+//	console.input      one console session's input reader; exits at EOF (socket closed on shutdown)
+//	agent.session      one agent API session (see cmd/gameserver/agent.go); exits when its socket closes (closed on shutdown)
+//	agent.stream       a live agent session's frames; exits when the session or the room ends
+package routines
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/race/server/internal/crash"
+)
+
+var counts sync.Map // owner -> *atomic.Int64
+
+// Go runs fn in a new goroutine accounted to owner.
+func Go(owner string, fn func()) {
+	c := counter(owner)
+	c.Add(1)
+	go func() {
+		defer c.Add(-1)
+		defer crash.Recover(owner)
+		fn()
+	}()
+}
+
+func counter(owner string) *atomic.Int64 {
+	if c, ok := counts.Load(owner); ok {
+		return c.(*atomic.Int64)
+	}
+	c, _ := counts.LoadOrStore(owner, new(atomic.Int64))
+	return c.(*atomic.Int64)
+}
+
+// Counts returns the number of running goroutines per owner. Owners that
+// ran goroutines before are included with 0.
+func Counts() map[string]int64 {
+	result := make(map[string]int64)
+	counts.Range(func(owner, c interface{}) bool {
+		result[owner.(string)] = c.(*atomic.Int64).Load()
+		return true
+	})
+	return result
+}
+
+// Report is a snapshot of goroutine accounting
+type Report struct {
+	Total   int              `json:"total"`   // runtime.NumGoroutine
+	Owned   map[string]int64 `json:"owned"`   // Started through Go, per owner
+	Unowned int64            `json:"unowned"` // Everything else (HTTP handlers, runtime, libraries)
+}
+
+// Snapshot returns the current accounting.
+func Snapshot() Report {
+	owned := Counts()
+	var sum int64
+	for _, n := range owned {
+		sum += n
+	}
+	total := runtime.NumGoroutine()
+	return Report{Total: total, Owned: owned, Unowned: int64(total) - sum}
+}
@@ -0,0 +1,66 @@
+// Package routinestest checks that tests leave none of the server's
+// long-running goroutines behind, by the per-owner counts of package
+// routines.
+//
+// Goroutines end asynchronously after the teardown that stops them (a
+// closed socket, a stopped scheduler), so the counts are polled until they
+// are back to zero or Timeout passes. The counts are process-wide: tests
+// that check them must not run in parallel with tests that start
+// goroutines of the same owners.
+package routinestest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/race/server/internal/routines"
+)
+
+// Timeout bounds the wait for goroutines to end
+const Timeout = 5 * time.Second
+
+// CheckZero fails tb unless the goroutines of the given owners, or of every
+// owner if none are given, all end within Timeout
+func CheckZero(tb testing.TB, owners ...string) {
+	tb.Helper()
+	deadline := time.Now().Add(Timeout)
+	for {
+		left := running(owners)
+		if len(left) == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			tb.Errorf("goroutines still running after %v: %s", Timeout, strings.Join(left, ", "))
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// CheckZeroAtCleanup runs CheckZero when tb ends, after the cleanups
+// registered later, such as those that shut down what the test started
+func CheckZeroAtCleanup(tb testing.TB, owners ...string) {
+	tb.Helper()
+	tb.Cleanup(func() { CheckZero(tb, owners...) })
+}
+
+// running returns the owners with goroutines left, with their counts
+func running(owners []string) []string {
+	counts := routines.Counts()
+	if len(owners) == 0 {
+		for owner := range counts {
+			owners = append(owners, owner)
+		}
+		sort.Strings(owners)
+	}
+	var left []string
+	for _, owner := range owners {
+		if n := counts[owner]; n != 0 {
+			left = append(left, fmt.Sprintf("%s=%d", owner, n))
+		}
+	}
+	return left
+}
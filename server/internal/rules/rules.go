@@ -0,0 +1,290 @@
+// Package rules runs custom room rules written in Starlark, a small Python
+// dialect made for embedding: community-made modes such as tag, convoy or
+// king of the road, without recompiling the server.
+//
+// A rules script is a .star file that defines some of these hooks, called
+// by the room's physics tick (see game/rules.go):
+//
+//	def on_join(room, player): ...       # a car appeared
+//	def on_collision(room, a, b): ...    # two cars are in contact
+//	def on_explosion(room, player): ...  # a car exploded
+//	def on_tick(room, dt): ...           # once per physics tick, dt in seconds
+//
+// Cars are structs with id, name, x, y (down the road), speed, rating,
+// lane (from 1 at the left edge, 0 off the road), airborne (off a jump
+// ramp), exploded and ghost. The room offers players(), explode(id),
+// add_rating(id, points) and ghost(id, seconds) (0 lifts it); print() goes
+// to the server log. Each room runs its own instance of the script, so
+// top-level dicts and lists are the room's state. Every hook call may take at most config.RulesMaxSteps interpreter
+// steps; a script that fails or runs over is detached from the room.
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/game"
+)
+
+// Hook names
+const (
+	hookJoin      = "on_join"
+	hookCollision = "on_collision"
+	hookExplosion = "on_explosion"
+	hookTick      = "on_tick"
+)
+
+var hooks = []string{hookJoin, hookCollision, hookExplosion, hookTick}
+
+// namePattern restricts script names (file names without .star) to what
+// fits in configs and logs
+var namePattern = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+
+// ErrUnknownRules is returned for a name no script was loaded under
+var ErrUnknownRules = errors.New("unknown rules")
+
+// Script is a compiled rules script
+type Script struct {
+	name string
+	prog *starlark.Program
+}
+
+// Compile compiles a rules script and checks its hooks by running its top
+// level once.
+func Compile(name string, src []byte) (*Script, error) {
+	if !namePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid rules name %q (lowercase letters, digits and dashes)", name)
+	}
+	_, prog, err := starlark.SourceProgram(name+".star", src, predeclared.Has)
+	if err != nil {
+		return nil, err
+	}
+	s := &Script{name: name, prog: prog}
+	if _, err := s.New(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Name returns the script's name
+func (s *Script) Name() string {
+	return s.name
+}
+
+// New runs the script's top level for a room and returns its rules.
+func (s *Script) New() (game.RoomRules, error) {
+	r := &Rules{
+		name:   s.name,
+		thread: &starlark.Thread{Name: "rules " + s.name},
+		hooks:  make(map[string]starlark.Callable, len(hooks)),
+	}
+	r.thread.Print = func(_ *starlark.Thread, msg string) {
+		log.Printf("Rules %s: %s", r.name, msg)
+	}
+	r.room = r.roomValue()
+
+	r.budget()
+	globals, err := s.prog.Init(r.thread, predeclared)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.name, err)
+	}
+	for name, v := range globals {
+		if !strings.HasPrefix(name, "on_") {
+			continue
+		}
+		fn, ok := v.(starlark.Callable)
+		if !ok || !isHook(name) {
+			return nil, fmt.Errorf("%s: %s is not a hook (%s)", s.name, name, strings.Join(hooks, ", "))
+		}
+		r.hooks[name] = fn
+	}
+	if len(r.hooks) == 0 {
+		return nil, fmt.Errorf("%s: defines no hook (%s)", s.name, strings.Join(hooks, ", "))
+	}
+	return r, nil
+}
+
+func isHook(name string) bool {
+	for _, h := range hooks {
+		if h == name {
+			return true
+		}
+	}
+	return false
+}
+
+// predeclared are the names scripts may use besides the Starlark builtins
+var predeclared = starlark.StringDict{
+	"struct": starlark.NewBuiltin("struct", starlarkstruct.Make),
+}
+
+// Rules are a script's instance for one room. Only the room's tick calls
+// the hooks, so they need no lock.
+type Rules struct {
+	name   string
+	thread *starlark.Thread
+	hooks  map[string]starlark.Callable
+	room   starlark.Value
+
+	api game.RulesAPI // Of the hook call in progress
+}
+
+func (r *Rules) Name() string { return r.name }
+
+func (r *Rules) OnJoin(api game.RulesAPI, p game.PlayerState) error {
+	return r.call(api, hookJoin, r.room, playerValue(p))
+}
+
+func (r *Rules) OnCollision(api game.RulesAPI, a, b game.PlayerState) error {
+	return r.call(api, hookCollision, r.room, playerValue(a), playerValue(b))
+}
+
+func (r *Rules) OnExplosion(api game.RulesAPI, p game.PlayerState) error {
+	return r.call(api, hookExplosion, r.room, playerValue(p))
+}
+
+func (r *Rules) OnTick(api game.RulesAPI, dt float64) error {
+	return r.call(api, hookTick, r.room, starlark.Float(dt))
+}
+
+// call runs a hook, if the script defines it, within the step budget
+func (r *Rules) call(api game.RulesAPI, hook string, args ...starlark.Value) error {
+	fn, ok := r.hooks[hook]
+	if !ok {
+		return nil
+	}
+	r.api = api
+	defer func() { r.api = nil }()
+
+	r.budget()
+	_, err := starlark.Call(r.thread, fn, args, nil)
+	return err
+}
+
+// budget allows the next call config.RulesMaxSteps steps
+func (r *Rules) budget() {
+	r.thread.Uncancel()
+	r.thread.SetMaxExecutionSteps(r.thread.ExecutionSteps() + config.RulesMaxSteps)
+}
+
+// roomValue builds the room object passed to hooks
+func (r *Rules) roomValue() starlark.Value {
+	return starlarkstruct.FromStringDict(starlark.String("room"), starlark.StringDict{
+		"players": starlark.NewBuiltin("players", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+				return nil, err
+			}
+			states := r.api.Players()
+			list := make([]starlark.Value, len(states))
+			for i, p := range states {
+				list[i] = playerValue(p)
+			}
+			return starlark.NewList(list), nil
+		}),
+		"explode": starlark.NewBuiltin("explode", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var id int
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &id); err != nil {
+				return nil, err
+			}
+			return starlark.Bool(r.api.Explode(uint16(id)) == nil), nil
+		}),
+		"add_rating": starlark.NewBuiltin("add_rating", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var id int
+			var points starlark.Value
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &id, &points); err != nil {
+				return nil, err
+			}
+			f, ok := starlark.AsFloat(points)
+			if !ok || math.IsNaN(f) || math.IsInf(f, 0) {
+				return nil, fmt.Errorf("%s: points must be a finite number", b.Name())
+			}
+			return starlark.Bool(r.api.AddRating(uint16(id), f) == nil), nil
+		}),
+		"ghost": starlark.NewBuiltin("ghost", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var id int
+			var seconds starlark.Value
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &id, &seconds); err != nil {
+				return nil, err
+			}
+			f, ok := starlark.AsFloat(seconds)
+			if !ok || math.IsNaN(f) || f > config.RulesGhostMax.Seconds() {
+				return nil, fmt.Errorf("%s: seconds must be at most %.0f", b.Name(), config.RulesGhostMax.Seconds())
+			}
+			return starlark.Bool(r.api.Ghost(uint16(id), time.Duration(f*float64(time.Second))) == nil), nil
+		}),
+	})
+}
+
+// playerValue converts a car's state for scripts
+func playerValue(p game.PlayerState) starlark.Value {
+	return starlarkstruct.FromStringDict(starlark.String("player"), starlark.StringDict{
+		"id":       starlark.MakeInt(int(p.ID)),
+		"name":     starlark.String(p.Name),
+		"x":        starlark.Float(p.X),
+		"y":        starlark.Float(p.Y),
+		"speed":    starlark.Float(p.Speed),
+		"rating":   starlark.Float(p.Rating),
+		"lane":     starlark.MakeInt(p.Lane),
+		"airborne": starlark.Bool(p.Airborne),
+		"exploded": starlark.Bool(p.Exploded),
+		"ghost":    starlark.Bool(p.Ghost),
+	})
+}
+
+// Library holds the rules scripts rooms can run, by name
+type Library struct {
+	scripts map[string]*Script
+}
+
+// LoadDir compiles every .star file in dir; a script's name is its file
+// name without the extension.
+func LoadDir(dir string) (*Library, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.star"))
+	if err != nil {
+		return nil, err
+	}
+	lib := &Library{scripts: make(map[string]*Script, len(paths))}
+	for _, path := range paths {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		s, err := Compile(strings.TrimSuffix(filepath.Base(path), ".star"), src)
+		if err != nil {
+			return nil, err
+		}
+		lib.scripts[s.name] = s
+	}
+	return lib, nil
+}
+
+// Names lists the loaded scripts
+func (l *Library) Names() []string {
+	names := make([]string, 0, len(l.scripts))
+	for name := range l.scripts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates a room's instance of the named script
+func (l *Library) New(name string) (game.RoomRules, error) {
+	s, ok := l.scripts[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownRules, name)
+	}
+	return s.New()
+}
@@ -0,0 +1,276 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps everything in process memory. Data is lost on restart
+// and not shared between servers.
+type MemoryStore struct {
+	mu      sync.Mutex
+	kv      map[string]memoryValue
+	sets    map[string]map[string]float64
+	streams map[string][]StreamEntry
+	nextID  uint64
+}
+
+type memoryValue struct {
+	data    []byte
+	expires time.Time // Zero means no expiry
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		kv:      make(map[string]memoryValue),
+		sets:    make(map[string]map[string]float64),
+		streams: make(map[string][]StreamEntry),
+	}
+}
+
+// Get implements KV
+func (s *MemoryStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.kv[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if !v.expires.IsZero() && time.Now().After(v.expires) {
+		delete(s.kv, key)
+		return nil, false, nil
+	}
+	return append([]byte(nil), v.data...), true, nil
+}
+
+// Set implements KV
+func (s *MemoryStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.setUnlocked(key, value, ttl)
+	return nil
+}
+
+// setUnlocked stores value under key.
+// IMPORTANT: Caller must hold s.mu.
+func (s *MemoryStore) setUnlocked(key string, value []byte, ttl time.Duration) {
+	v := memoryValue{data: append([]byte(nil), value...)}
+	if ttl > 0 {
+		v.expires = time.Now().Add(ttl)
+	}
+	s.kv[key] = v
+
+	// Expired keys are removed lazily; sweep occasionally so they can't pile up
+	if len(s.kv)%1024 == 0 {
+		s.sweepUnlocked()
+	}
+}
+
+// existsUnlocked reports whether key exists and hasn't expired.
+// IMPORTANT: Caller must hold s.mu.
+func (s *MemoryStore) existsUnlocked(key string) bool {
+	v, ok := s.kv[key]
+	return ok && (v.expires.IsZero() || time.Now().Before(v.expires))
+}
+
+// SetIfAbsent implements KV
+func (s *MemoryStore) SetIfAbsent(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.existsUnlocked(key) {
+		return false, nil
+	}
+	s.setUnlocked(key, value, ttl)
+	return true, nil
+}
+
+// Delete implements KV
+func (s *MemoryStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.kv, key)
+	return nil
+}
+
+// Sweep removes expired keys. Call periodically.
+func (s *MemoryStore) Sweep() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweepUnlocked()
+}
+
+// sweepUnlocked removes expired keys.
+// IMPORTANT: Caller must hold s.mu.
+func (s *MemoryStore) sweepUnlocked() {
+	now := time.Now()
+	for key, v := range s.kv {
+		if !v.expires.IsZero() && now.After(v.expires) {
+			delete(s.kv, key)
+		}
+	}
+}
+
+// ZAdd implements SortedSets
+func (s *MemoryStore) ZAdd(ctx context.Context, set, member string, score float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	members, ok := s.sets[set]
+	if !ok {
+		members = make(map[string]float64)
+		s.sets[set] = members
+	}
+	members[member] = score
+	return nil
+}
+
+// ZScore implements SortedSets
+func (s *MemoryStore) ZScore(ctx context.Context, set, member string) (float64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	score, ok := s.sets[set][member]
+	return score, ok, nil
+}
+
+// ZRem implements SortedSets
+func (s *MemoryStore) ZRem(ctx context.Context, set, member string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sets[set], member)
+	if len(s.sets[set]) == 0 {
+		delete(s.sets, set)
+	}
+	return nil
+}
+
+// ZRevRange implements SortedSets
+func (s *MemoryStore) ZRevRange(ctx context.Context, set string, start, stop int) ([]Member, error) {
+	s.mu.Lock()
+	sorted := make([]Member, 0, len(s.sets[set]))
+	for member, score := range s.sets[set] {
+		sorted = append(sorted, Member{Member: member, Score: score})
+	}
+	s.mu.Unlock()
+
+	// Same order as Redis: score descending, then member descending
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Score != sorted[j].Score {
+			return sorted[i].Score > sorted[j].Score
+		}
+		return sorted[i].Member > sorted[j].Member
+	})
+
+	if stop < 0 || stop >= len(sorted) {
+		stop = len(sorted) - 1
+	}
+	if start < 0 || start > stop {
+		return nil, nil
+	}
+	return sorted[start : stop+1], nil
+}
+
+// ZCard implements SortedSets
+func (s *MemoryStore) ZCard(ctx context.Context, set string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.sets[set]), nil
+}
+
+// XAdd implements Streams
+func (s *MemoryStore) XAdd(ctx context.Context, stream string, data []byte, maxLen int) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.xaddUnlocked(stream, data, maxLen), nil
+}
+
+// xaddUnlocked appends data to a stream and returns its ID.
+// IMPORTANT: Caller must hold s.mu.
+func (s *MemoryStore) xaddUnlocked(stream string, data []byte, maxLen int) string {
+	s.nextID++
+	id := strconv.FormatUint(s.nextID, 10)
+	entries := append(s.streams[stream], StreamEntry{ID: id, Data: append([]byte(nil), data...)})
+	if maxLen > 0 && len(entries) > maxLen {
+		entries = append([]StreamEntry(nil), entries[len(entries)-maxLen:]...)
+	}
+	s.streams[stream] = entries
+	return id
+}
+
+// DeleteStream implements Streams
+func (s *MemoryStore) DeleteStream(ctx context.Context, stream string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.streams, stream)
+	return nil
+}
+
+// XRange implements Streams
+func (s *MemoryStore) XRange(ctx context.Context, stream, after string, count int) ([]StreamEntry, error) {
+	var afterID uint64
+	if after != "" {
+		id, err := strconv.ParseUint(after, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		afterID = id
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := s.streams[stream]
+	// IDs are increasing, so binary search for the first entry after afterID
+	i := sort.Search(len(entries), func(i int) bool {
+		id, _ := strconv.ParseUint(entries[i].ID, 10, 64)
+		return id > afterID
+	})
+	entries = entries[i:]
+	if count > 0 && len(entries) > count {
+		entries = entries[:count]
+	}
+	return append([]StreamEntry(nil), entries...), nil
+}
+
+// Commit implements Transactions. The store's lock makes it atomic.
+func (s *MemoryStore) Commit(ctx context.Context, txn *Txn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, op := range txn.ops {
+		if op.kind == txnSetIfAbsent && s.existsUnlocked(op.key) {
+			return ErrConflict
+		}
+	}
+	for _, op := range txn.ops {
+		switch op.kind {
+		case txnSet, txnSetIfAbsent:
+			s.setUnlocked(op.key, op.value, op.ttl)
+		case txnDelete:
+			delete(s.kv, op.key)
+		case txnXAdd:
+			s.xaddUnlocked(op.key, op.value, op.maxLen)
+		case txnDeleteStream:
+			delete(s.streams, op.key)
+		}
+	}
+	return nil
+}
+
+// Close implements Store
+func (s *MemoryStore) Close() error {
+	return nil
+}
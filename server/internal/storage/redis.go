@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore maps the data models onto native Redis types.
+// Streams store each entry's data in the field "d". Requires Redis 6.2+.
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore connects to Redis, e.g. "redis://localhost:6379/0"
+func NewRedisStore(url string) (*RedisStore, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis url: %w", err)
+	}
+
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("connect to redis: %w", err)
+	}
+	return &RedisStore{client: client}, nil
+}
+
+// Get implements KV
+func (s *RedisStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	data, err := s.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// Set implements KV
+func (s *RedisStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.client.Set(ctx, key, value, ttl).Err()
+}
+
+// SetIfAbsent implements KV
+func (s *RedisStore) SetIfAbsent(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, key, value, ttl).Result()
+}
+
+// Delete implements KV
+func (s *RedisStore) Delete(ctx context.Context, key string) error {
+	return s.client.Del(ctx, key).Err()
+}
+
+// ZAdd implements SortedSets
+func (s *RedisStore) ZAdd(ctx context.Context, set, member string, score float64) error {
+	return s.client.ZAdd(ctx, set, redis.Z{Score: score, Member: member}).Err()
+}
+
+// ZScore implements SortedSets
+func (s *RedisStore) ZScore(ctx context.Context, set, member string) (float64, bool, error) {
+	score, err := s.client.ZScore(ctx, set, member).Result()
+	if errors.Is(err, redis.Nil) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return score, true, nil
+}
+
+// ZRem implements SortedSets
+func (s *RedisStore) ZRem(ctx context.Context, set, member string) error {
+	return s.client.ZRem(ctx, set, member).Err()
+}
+
+// ZRevRange implements SortedSets
+func (s *RedisStore) ZRevRange(ctx context.Context, set string, start, stop int) ([]Member, error) {
+	if stop < 0 {
+		stop = -1
+	}
+	zs, err := s.client.ZRevRangeWithScores(ctx, set, int64(start), int64(stop)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]Member, len(zs))
+	for i, z := range zs {
+		members[i] = Member{Member: fmt.Sprint(z.Member), Score: z.Score}
+	}
+	return members, nil
+}
+
+// ZCard implements SortedSets
+func (s *RedisStore) ZCard(ctx context.Context, set string) (int, error) {
+	n, err := s.client.ZCard(ctx, set).Result()
+	return int(n), err
+}
+
+// XAdd implements Streams
+func (s *RedisStore) XAdd(ctx context.Context, stream string, data []byte, maxLen int) (string, error) {
+	return s.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: stream,
+		MaxLen: int64(maxLen),
+		Approx: true,
+		Values: []interface{}{"d", data},
+	}).Result()
+}
+
+// XRange implements Streams
+func (s *RedisStore) XRange(ctx context.Context, stream, after string, count int) ([]StreamEntry, error) {
+	start := "-"
+	if after != "" {
+		start = "(" + after // Exclusive range
+	}
+
+	var msgs []redis.XMessage
+	var err error
+	if count > 0 {
+		msgs, err = s.client.XRangeN(ctx, stream, start, "+", int64(count)).Result()
+	} else {
+		msgs, err = s.client.XRange(ctx, stream, start, "+").Result()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]StreamEntry, len(msgs))
+	for i, msg := range msgs {
+		data, _ := msg.Values["d"].(string) // Redis returns field values as strings
+		entries[i] = StreamEntry{ID: msg.ID, Data: []byte(data)}
+	}
+	return entries, nil
+}
+
+// DeleteStream implements Streams
+func (s *RedisStore) DeleteStream(ctx context.Context, stream string) error {
+	return s.client.Del(ctx, stream).Err()
+}
+
+// Commit implements Transactions with MULTI/EXEC. Keys that must be absent
+// are checked under WATCH, so a concurrent write to one of them makes the
+// transaction fail with ErrConflict instead of applying.
+func (s *RedisStore) Commit(ctx context.Context, txn *Txn) error {
+	var guards []string
+	for _, op := range txn.ops {
+		if op.kind == txnSetIfAbsent {
+			guards = append(guards, op.key)
+		}
+	}
+
+	apply := func(tx *redis.Tx) error {
+		if len(guards) > 0 {
+			n, err := tx.Exists(ctx, guards...).Result()
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				return ErrConflict
+			}
+		}
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, op := range txn.ops {
+				switch op.kind {
+				case txnSet, txnSetIfAbsent:
+					pipe.Set(ctx, op.key, op.value, op.ttl)
+				case txnDelete, txnDeleteStream:
+					pipe.Del(ctx, op.key)
+				case txnXAdd:
+					pipe.XAdd(ctx, &redis.XAddArgs{
+						Stream: op.key,
+						MaxLen: int64(op.maxLen),
+						Approx: true,
+						Values: []interface{}{"d", op.value},
+					})
+				}
+			}
+			return nil
+		})
+		return err
+	}
+
+	err := s.client.Watch(ctx, apply, guards...)
+	if errors.Is(err, redis.TxFailedErr) {
+		return ErrConflict
+	}
+	return err
+}
+
+// Close implements Store
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
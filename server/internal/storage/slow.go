@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// SlowStore delays every call to a store, to inject the fault of a slow
+// backend in tests and staging (STORE_FAULT_DELAY). A call whose context
+// ends during the delay fails with the context's error, as it would on a
+// backend that doesn't answer in time.
+type SlowStore struct {
+	Store
+	delay time.Duration
+}
+
+// NewSlowStore wraps store so that every call takes delay longer
+func NewSlowStore(store Store, delay time.Duration) *SlowStore {
+	return &SlowStore{Store: store, delay: delay}
+}
+
+// wait holds a call for the delay
+func (s *SlowStore) wait(ctx context.Context) error {
+	t := time.NewTimer(s.delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Get implements KV
+func (s *SlowStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	if err := s.wait(ctx); err != nil {
+		return nil, false, err
+	}
+	return s.Store.Get(ctx, key)
+}
+
+// Set implements KV
+func (s *SlowStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := s.wait(ctx); err != nil {
+		return err
+	}
+	return s.Store.Set(ctx, key, value, ttl)
+}
+
+// SetIfAbsent implements KV
+func (s *SlowStore) SetIfAbsent(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	if err := s.wait(ctx); err != nil {
+		return false, err
+	}
+	return s.Store.SetIfAbsent(ctx, key, value, ttl)
+}
+
+// Delete implements KV
+func (s *SlowStore) Delete(ctx context.Context, key string) error {
+	if err := s.wait(ctx); err != nil {
+		return err
+	}
+	return s.Store.Delete(ctx, key)
+}
+
+// ZAdd implements SortedSets
+func (s *SlowStore) ZAdd(ctx context.Context, set, member string, score float64) error {
+	if err := s.wait(ctx); err != nil {
+		return err
+	}
+	return s.Store.ZAdd(ctx, set, member, score)
+}
+
+// ZScore implements SortedSets
+func (s *SlowStore) ZScore(ctx context.Context, set, member string) (float64, bool, error) {
+	if err := s.wait(ctx); err != nil {
+		return 0, false, err
+	}
+	return s.Store.ZScore(ctx, set, member)
+}
+
+// ZRem implements SortedSets
+func (s *SlowStore) ZRem(ctx context.Context, set, member string) error {
+	if err := s.wait(ctx); err != nil {
+		return err
+	}
+	return s.Store.ZRem(ctx, set, member)
+}
+
+// ZRevRange implements SortedSets
+func (s *SlowStore) ZRevRange(ctx context.Context, set string, start, stop int) ([]Member, error) {
+	if err := s.wait(ctx); err != nil {
+		return nil, err
+	}
+	return s.Store.ZRevRange(ctx, set, start, stop)
+}
+
+// ZCard implements SortedSets
+func (s *SlowStore) ZCard(ctx context.Context, set string) (int, error) {
+	if err := s.wait(ctx); err != nil {
+		return 0, err
+	}
+	return s.Store.ZCard(ctx, set)
+}
+
+// XAdd implements Streams
+func (s *SlowStore) XAdd(ctx context.Context, stream string, data []byte, maxLen int) (string, error) {
+	if err := s.wait(ctx); err != nil {
+		return "", err
+	}
+	return s.Store.XAdd(ctx, stream, data, maxLen)
+}
+
+// XRange implements Streams
+func (s *SlowStore) XRange(ctx context.Context, stream, after string, count int) ([]StreamEntry, error) {
+	if err := s.wait(ctx); err != nil {
+		return nil, err
+	}
+	return s.Store.XRange(ctx, stream, after, count)
+}
+
+// DeleteStream implements Streams
+func (s *SlowStore) DeleteStream(ctx context.Context, stream string) error {
+	if err := s.wait(ctx); err != nil {
+		return err
+	}
+	return s.Store.DeleteStream(ctx, stream)
+}
+
+// Commit implements Transactions
+func (s *SlowStore) Commit(ctx context.Context, txn *Txn) error {
+	if err := s.wait(ctx); err != nil {
+		return err
+	}
+	return s.Store.Commit(ctx, txn)
+}
+
+// Sweep implements Sweeper for a wrapped Sweeper
+func (s *SlowStore) Sweep() {
+	if store, ok := s.Store.(Sweeper); ok {
+		store.Sweep()
+	}
+}
@@ -0,0 +1,163 @@
+// Package storage abstracts the shared state used by leaderboards, bans,
+// sessions and presence.
+//
+// A Store offers three data models: key-value with expiry, sorted sets and
+// append-only streams, plus transactions that write to several keys at once
+// (see Txn). The in-memory backend lets a single server run
+// standalone; the Redis and SQL backends share state between servers in a
+// cluster.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Backend names accepted by Open
+const (
+	BackendMemory = "memory"
+	BackendRedis  = "redis"
+	BackendSQL    = "sql"
+)
+
+// KV is a key-value store with optional expiry
+type KV interface {
+	// Get returns the value of key, or false if it does not exist or expired
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores value under key. A ttl of 0 means no expiry.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// SetIfAbsent atomically stores value only if key does not exist (or
+	// expired). Returns false if the key was already present.
+	SetIfAbsent(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Member is an element of a sorted set
+type Member struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+// SortedSets stores sets of members ordered by score
+type SortedSets interface {
+	// ZAdd adds member or updates its score
+	ZAdd(ctx context.Context, set, member string, score float64) error
+	ZScore(ctx context.Context, set, member string) (float64, bool, error)
+	ZRem(ctx context.Context, set, member string) error
+	// ZRevRange returns members ranked start..stop (inclusive, 0-based),
+	// highest score first. A negative stop means "to the end".
+	ZRevRange(ctx context.Context, set string, start, stop int) ([]Member, error)
+	ZCard(ctx context.Context, set string) (int, error)
+}
+
+// StreamEntry is a single entry of a stream.
+// IDs are opaque, increasing, and only meaningful to the backend that issued them.
+type StreamEntry struct {
+	ID   string `json:"id"`
+	Data []byte `json:"data"`
+}
+
+// Streams stores append-only logs of entries
+type Streams interface {
+	// XAdd appends data and returns its ID. If maxLen > 0 the stream is
+	// trimmed to roughly the newest maxLen entries.
+	XAdd(ctx context.Context, stream string, data []byte, maxLen int) (string, error)
+	// XRange returns up to count entries after the given ID ("" = from the start)
+	XRange(ctx context.Context, stream, after string, count int) ([]StreamEntry, error)
+	// DeleteStream removes a stream with all its entries
+	DeleteStream(ctx context.Context, stream string) error
+}
+
+// ErrConflict is returned by Commit when a key the transaction requires to
+// be absent exists
+var ErrConflict = errors.New("storage: conflicting write")
+
+// Txn is a list of writes to apply atomically (see Transactions). The zero
+// value is an empty transaction.
+type Txn struct {
+	ops []txnOp
+}
+
+type txnOpKind int
+
+const (
+	txnSet txnOpKind = iota
+	txnSetIfAbsent
+	txnDelete
+	txnXAdd
+	txnDeleteStream
+)
+
+// txnOp is one write of a transaction
+type txnOp struct {
+	kind   txnOpKind
+	key    string // Key or stream
+	value  []byte
+	ttl    time.Duration
+	maxLen int
+}
+
+// Set stores value under key, as KV.Set
+func (t *Txn) Set(key string, value []byte, ttl time.Duration) {
+	t.ops = append(t.ops, txnOp{kind: txnSet, key: key, value: value, ttl: ttl})
+}
+
+// SetIfAbsent stores value under key, as KV.SetIfAbsent. If the key exists
+// the whole transaction fails with ErrConflict.
+func (t *Txn) SetIfAbsent(key string, value []byte, ttl time.Duration) {
+	t.ops = append(t.ops, txnOp{kind: txnSetIfAbsent, key: key, value: value, ttl: ttl})
+}
+
+// Delete removes key, as KV.Delete
+func (t *Txn) Delete(key string) {
+	t.ops = append(t.ops, txnOp{kind: txnDelete, key: key})
+}
+
+// XAdd appends data to a stream, as Streams.XAdd
+func (t *Txn) XAdd(stream string, data []byte, maxLen int) {
+	t.ops = append(t.ops, txnOp{kind: txnXAdd, key: stream, value: data, maxLen: maxLen})
+}
+
+// DeleteStream removes a stream, as Streams.DeleteStream
+func (t *Txn) DeleteStream(stream string) {
+	t.ops = append(t.ops, txnOp{kind: txnDeleteStream, key: stream})
+}
+
+// Transactions applies several writes as one
+type Transactions interface {
+	// Commit applies all writes of txn in order, or none of them: other
+	// clients never see some of them without the rest.
+	Commit(ctx context.Context, txn *Txn) error
+}
+
+// Store combines all data models of a backend
+type Store interface {
+	KV
+	SortedSets
+	Streams
+	Transactions
+	Close() error
+}
+
+// Sweeper is a store that removes its expired keys when swept rather than
+// on its own (MemoryStore)
+type Sweeper interface {
+	Sweep()
+}
+
+// Open creates a store for the configured backend.
+// url is the Redis URL or SQL (PostgreSQL) connection string; unused for memory.
+func Open(backend, url string) (Store, error) {
+	switch backend {
+	case "", BackendMemory:
+		return NewMemoryStore(), nil
+	case BackendRedis:
+		return NewRedisStore(url)
+	case BackendSQL:
+		return NewSQLStore(url)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}
@@ -0,0 +1,184 @@
+// Package token issues and verifies signed, expiring tokens for sessions,
+// invites, matchmaking tickets and account links.
+//
+// A token is "<payload>.<signature>", both base64url encoded. The payload is
+// JSON claims and the signature is HMAC-SHA256 over the encoded payload, so
+// tokens cannot be forged or altered without the server secret. Every token
+// carries a random nonce; single-use tokens are redeemed by recording the
+// nonce in the store until the token expires, which makes replays fail on
+// every server sharing that store.
+package token
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/race/server/internal/storage"
+)
+
+// Purpose scopes a token so one kind can't be used as another
+type Purpose string
+
+const (
+	PurposeSession  Purpose = "session"  // Reconnect to an existing session
+	PurposeInvite   Purpose = "invite"   // Join a specific room
+	PurposeTicket   Purpose = "ticket"   // Matchmaker assignment
+	PurposeLink     Purpose = "link"     // Upgrade a guest to an account
+	PurposeSpectate Purpose = "spectate" // Watch a room without a car (broadcast director)
+)
+
+var (
+	ErrMalformed = errors.New("malformed token")
+	ErrSignature = errors.New("invalid token signature")
+	ErrExpired   = errors.New("token expired")
+	ErrPurpose   = errors.New("token issued for another purpose")
+	ErrReplayed  = errors.New("token already used")
+)
+
+// Claims is the signed content of a token
+type Claims struct {
+	Purpose Purpose `json:"p"`
+	Subject string  `json:"s"`           // Player, session or room the token refers to
+	Data    string  `json:"d,omitempty"` // Purpose-specific extra data
+	Expires int64   `json:"e"`           // Unix seconds
+	Nonce   string  `json:"n"`
+}
+
+// ExpiresAt returns the expiry time of the claims
+func (c Claims) ExpiresAt() time.Time {
+	return time.Unix(c.Expires, 0)
+}
+
+// Service issues and verifies tokens
+type Service struct {
+	secret []byte
+	nonces storage.KV // Redeemed nonces, kept until the token expires
+}
+
+// NewService creates a token service. All servers of a cluster must share
+// the secret and the nonce store.
+func NewService(secret []byte, nonces storage.KV) *Service {
+	return &Service{secret: secret, nonces: nonces}
+}
+
+// RandomSecret generates a secret for a standalone server.
+// Tokens signed with it do not survive a restart.
+func RandomSecret() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic("token: no randomness available: " + err.Error())
+	}
+	return secret
+}
+
+// Issue creates a token for subject that is valid for ttl
+func (s *Service) Issue(purpose Purpose, subject, data string, ttl time.Duration) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(Claims{
+		Purpose: purpose,
+		Subject: subject,
+		Data:    data,
+		Expires: time.Now().Add(ttl).Unix(),
+		Nonce:   hex.EncodeToString(nonce),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(s.sign(encoded)), nil
+}
+
+// Verify checks signature, expiry and purpose without consuming the token.
+// Use for tokens that may be presented several times until they expire.
+func (s *Service) Verify(purpose Purpose, token string) (Claims, error) {
+	var claims Claims
+
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return claims, ErrMalformed
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return claims, ErrMalformed
+	}
+	if !hmac.Equal(mac, s.sign(encoded)) {
+		return claims, ErrSignature
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return claims, ErrMalformed
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return claims, ErrMalformed
+	}
+
+	if claims.Purpose != purpose {
+		return claims, ErrPurpose
+	}
+	if time.Now().Unix() >= claims.Expires {
+		return claims, ErrExpired
+	}
+	return claims, nil
+}
+
+// Redeem verifies a single-use token and consumes it.
+// Presenting the same token again fails with ErrReplayed.
+func (s *Service) Redeem(ctx context.Context, purpose Purpose, token string) (Claims, error) {
+	claims, err := s.Verify(purpose, token)
+	if err != nil {
+		return claims, err
+	}
+
+	fresh, err := s.nonces.SetIfAbsent(ctx, nonceKey(claims), []byte{1}, nonceTTL(claims))
+	if err != nil {
+		return claims, err
+	}
+	if !fresh {
+		return claims, ErrReplayed
+	}
+	return claims, nil
+}
+
+// RedeemIn verifies a single-use token and adds its consumption to txn, so
+// the token is only spent along with the writes it allows. Committing txn
+// fails with storage.ErrConflict if the token was used before. txn must be
+// committed to the store holding the service's nonces.
+func (s *Service) RedeemIn(txn *storage.Txn, purpose Purpose, token string) (Claims, error) {
+	claims, err := s.Verify(purpose, token)
+	if err != nil {
+		return claims, err
+	}
+	txn.SetIfAbsent(nonceKey(claims), []byte{1}, nonceTTL(claims))
+	return claims, nil
+}
+
+func nonceKey(claims Claims) string {
+	return "token:nonce:" + claims.Nonce
+}
+
+// nonceTTL keeps a redeemed nonce a little longer than the token to absorb
+// clock skew between servers
+func nonceTTL(claims Claims) time.Duration {
+	return time.Until(claims.ExpiresAt()) + time.Minute
+}
+
+// sign computes the HMAC of the encoded payload
+func (s *Service) sign(encoded string) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,296 @@
+package track
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/storage"
+)
+
+// Registry errors
+var (
+	ErrNotFound        = errors.New("track not found")
+	ErrForbidden       = errors.New("wrong edit key")
+	ErrTooManyVersions = errors.New("track has too many versions")
+	ErrConflict        = errors.New("concurrent update, try again")
+	ErrTooManyPending  = errors.New("too many tracks await approval, try again later")
+)
+
+// Registry stores custom tracks in a shared storage backend, so every
+// server of a cluster can host them. Versions are JSON values under
+// "track:<id>:v<version>" whose road never changes (only the approval
+// does), indexed by the sorted set "track:<id>:versions"; "tracks" indexes
+// the tracks by creation time, and "tracks:pending" those without an
+// approved version, of which there may be config.TrackUnapprovedMax.
+// Whoever creates a track gets an edit key that new versions must carry;
+// only its hash is stored.
+type Registry struct {
+	store storage.Store
+}
+
+const (
+	trackIndexKey = "tracks"
+	pendingKey    = "tracks:pending"
+	storeTimeout  = 5 * time.Second
+)
+
+// NewRegistry creates a registry on top of a store
+func NewRegistry(store storage.Store) *Registry {
+	return &Registry{store: store}
+}
+
+func versionKey(id string, version int) string {
+	return fmt.Sprintf("track:%s:v%d", id, version)
+}
+
+func versionsKey(id string) string {
+	return fmt.Sprintf("track:%s:versions", id)
+}
+
+func editKeyKey(id string) string {
+	return fmt.Sprintf("track:%s:key", id)
+}
+
+func hashKey(key string) []byte {
+	sum := sha256.Sum256([]byte(key))
+	return sum[:]
+}
+
+// Create stores t (validated) as version 1 of a new track and returns it
+// with the edit key for later versions. It fails with ErrTooManyPending
+// while config.TrackUnapprovedMax tracks await approval.
+func (r *Registry) Create(t Track) (Track, string, error) {
+	if err := t.Validate(); err != nil {
+		return Track{}, "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	pending, err := r.store.ZCard(ctx, pendingKey)
+	if err != nil {
+		return Track{}, "", err
+	}
+	if pending >= config.TrackUnapprovedMax {
+		return Track{}, "", ErrTooManyPending
+	}
+
+	key := randomHex(16)
+	for attempt := 0; ; attempt++ {
+		t.ID = randomHex(4)
+		ok, err := r.store.SetIfAbsent(ctx, editKeyKey(t.ID), hashKey(key), 0)
+		if err != nil {
+			return Track{}, "", err
+		}
+		if ok {
+			break
+		}
+		if attempt == 3 {
+			return Track{}, "", ErrConflict
+		}
+	}
+
+	t.Version = 1
+	t.Approved = false
+	t.Created = time.Now().UTC()
+	if err := r.put(ctx, t); err != nil {
+		return Track{}, "", err
+	}
+	if err := r.store.ZAdd(ctx, pendingKey, t.ID, float64(t.Created.Unix())); err != nil {
+		return Track{}, "", err
+	}
+	if err := r.store.ZAdd(ctx, trackIndexKey, t.ID, float64(t.Created.Unix())); err != nil {
+		return Track{}, "", err
+	}
+	return t, key, nil
+}
+
+// AddVersion stores t (validated) as the next version of track id. Earlier
+// versions stay available, and approval is per version.
+func (r *Registry) AddVersion(id, key string, t Track) (Track, error) {
+	if err := t.Validate(); err != nil {
+		return Track{}, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	hash, ok, err := r.store.Get(ctx, editKeyKey(id))
+	if err != nil {
+		return Track{}, err
+	}
+	if !ok {
+		return Track{}, ErrNotFound
+	}
+	if subtle.ConstantTimeCompare(hash, hashKey(key)) != 1 {
+		return Track{}, ErrForbidden
+	}
+
+	n, err := r.store.ZCard(ctx, versionsKey(id))
+	if err != nil {
+		return Track{}, err
+	}
+	if n >= config.TrackVersionsMax {
+		return Track{}, ErrTooManyVersions
+	}
+
+	t.ID = id
+	t.Version = n + 1
+	t.Approved = false
+	t.Created = time.Now().UTC()
+	return t, r.put(ctx, t)
+}
+
+// put stores a new version; it fails with ErrConflict if the version exists
+func (r *Registry) put(ctx context.Context, t Track) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	ok, err := r.store.SetIfAbsent(ctx, versionKey(t.ID, t.Version), data, 0)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrConflict
+	}
+	return r.store.ZAdd(ctx, versionsKey(t.ID), strconv.Itoa(t.Version), float64(t.Version))
+}
+
+// Get returns a version of a track (version 0: the latest)
+func (r *Registry) Get(id string, version int) (Track, error) {
+	return r.GetContext(context.Background(), id, version)
+}
+
+// GetContext is Get for a caller that may give up early, like a client
+// connection that closes
+func (r *Registry) GetContext(ctx context.Context, id string, version int) (Track, error) {
+	if !ValidID(id) {
+		return Track{}, ErrNotFound
+	}
+	ctx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+
+	if version == 0 {
+		latest, err := r.store.ZRevRange(ctx, versionsKey(id), 0, 0)
+		if err != nil {
+			return Track{}, err
+		}
+		if len(latest) == 0 {
+			return Track{}, ErrNotFound
+		}
+		version = int(latest[0].Score)
+	}
+
+	data, ok, err := r.store.Get(ctx, versionKey(id, version))
+	if err != nil {
+		return Track{}, err
+	}
+	if !ok {
+		return Track{}, ErrNotFound
+	}
+	var t Track
+	if err := json.Unmarshal(data, &t); err != nil {
+		return Track{}, err
+	}
+	return t, nil
+}
+
+// Versions returns every version of a track, newest first
+func (r *Registry) Versions(id string) ([]Track, error) {
+	if !ValidID(id) {
+		return nil, ErrNotFound
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	versions, err := r.store.ZRevRange(ctx, versionsKey(id), 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, ErrNotFound
+	}
+
+	tracks := make([]Track, 0, len(versions))
+	for _, v := range versions {
+		t, err := r.Get(id, int(v.Score))
+		if err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, t)
+	}
+	return tracks, nil
+}
+
+// List returns the latest version of up to limit tracks, newest track first
+func (r *Registry) List(limit int) ([]Track, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	ids, err := r.store.ZRevRange(ctx, trackIndexKey, 0, limit-1)
+	if err != nil {
+		return nil, err
+	}
+
+	tracks := make([]Track, 0, len(ids))
+	for _, m := range ids {
+		t, err := r.Get(m.Member, 0)
+		if err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, t)
+	}
+	return tracks, nil
+}
+
+// SetApproved approves a track version for public matchmaking, or revokes
+// the approval. Rooms already running the version keep it. A track awaits
+// approval again once none of its versions is approved.
+func (r *Registry) SetApproved(id string, version int, approved bool) (Track, error) {
+	if version <= 0 {
+		return Track{}, ErrNotFound
+	}
+	t, err := r.Get(id, version)
+	if err != nil {
+		return Track{}, err
+	}
+	t.Approved = approved
+
+	data, err := json.Marshal(t)
+	if err != nil {
+		return Track{}, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if err := r.store.Set(ctx, versionKey(id, version), data, 0); err != nil {
+		return Track{}, err
+	}
+	if approved {
+		return t, r.store.ZRem(ctx, pendingKey, id)
+	}
+
+	versions, err := r.Versions(id)
+	if err != nil {
+		return Track{}, err
+	}
+	for _, v := range versions {
+		if v.Approved {
+			return t, nil
+		}
+	}
+	return t, r.store.ZAdd(ctx, pendingKey, id, float64(versions[len(versions)-1].Created.Unix()))
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
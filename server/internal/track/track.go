@@ -0,0 +1,422 @@
+// Package track describes road geometry and stores user-created tracks.
+//
+// A track is a road of fixed width whose center line swings sideways as a
+// sum of curves: each curve is a sine wave along the road, raised to an odd
+// power to sharpen its bends. Across its width the road is split into
+// logical lanes of equal width, numbered from 1 at the left edge; lane 0 is
+// off the road. A track may also climb and fall, its height a sum of curves
+// like the center line's, and bank its bends: the grade slows cars uphill
+// and speeds them up downhill, and banking gives them grip in the bends
+// (see Grade and Bank). Jump ramps across the road launch cars into the air
+// (see Launch). The built-in road (config.GetRoadCurve) is the default
+// track, flat and unbanked, without ramps. Custom tracks come from the map
+// editor through the HTTP API; they can be driven in private rooms right
+// away and in public matchmaking once an operator approves them. Rooms may
+// drive any track in a variant: mirrored left to right, or at night (see
+// Variant), and escalate it: its bends swing wider the further down the
+// road (see WithEscalation).
+package track
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/race/server/config"
+	"github.com/race/server/internal/network"
+)
+
+// Curve is one component of the road's center line:
+// Amplitude * sin(2π*y/Wavelength + Phase)^Sharpness
+type Curve struct {
+	Amplitude  float64 `json:"amplitude"`  // Sideways swing in units
+	Wavelength float64 `json:"wavelength"` // Road length of one full swing
+	Phase      float64 `json:"phase"`      // Radians
+	Sharpness  int     `json:"sharpness"`  // Odd exponent (default 1); higher makes sharper bends
+}
+
+// Ramp is a jump across the whole road, repeating every Spacing units of
+// road from Offset: a slope Length long rising to Height, then a drop
+type Ramp struct {
+	Offset  float64 `json:"offset"`  // Road distance of the first ramp's foot
+	Spacing float64 `json:"spacing"` // Road distance between ramps
+	Length  float64 `json:"length"`
+	Height  float64 `json:"height"`
+}
+
+// Track is a versioned road definition
+type Track struct {
+	ID      string  `json:"id"`
+	Version int     `json:"version"`
+	Name    string  `json:"name"`
+	Author  string  `json:"author,omitempty"`
+	Width   float64 `json:"width"`
+	Lanes   int     `json:"lanes,omitempty"` // 0 (tracks from before lanes): config.RoadLanes
+	Curves  []Curve `json:"curves"`
+
+	// Height profile (amplitudes in height units; none: flat) and how much
+	// of the bends is banked, 0 (none) to 1
+	Elevation []Curve `json:"elevation,omitempty"`
+	Banking   float64 `json:"banking,omitempty"`
+	Ramps     []Ramp  `json:"ramps,omitempty"`
+
+	Approved bool      `json:"approved"` // Allowed in public matchmaking
+	Created  time.Time `json:"created"`
+
+	origin  float64 // Road distance before Y=0 (see Shifted)
+	variant Variant // See WithVariant
+
+	// Growth of the bends' amplitude per unit of road, and its cap (see
+	// WithEscalation)
+	escalation, escalationMax float64
+}
+
+// Variant transforms a track for the rooms that drive it (bits of
+// network.TrackVariant*). Mirror flips the road's bends left to right;
+// Night only tells clients to draw less of the road ahead, the physics are
+// the same.
+type Variant uint8
+
+// Track variants
+const (
+	Mirror = Variant(network.TrackVariantMirror)
+	Night  = Variant(network.TrackVariantNight)
+)
+
+// Validation errors
+var (
+	ErrInvalid     = errors.New("invalid track")
+	ErrNotApproved = errors.New("track is not approved for public matchmaking")
+)
+
+var idPattern = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// Default returns the built-in road
+func Default() *Track {
+	return &Track{
+		Name:  "Default",
+		Width: config.RoadWidth,
+		Lanes: config.RoadLanes,
+		Curves: []Curve{
+			{Amplitude: config.RoadAmplitude, Wavelength: 2 * math.Pi / config.RoadScale, Sharpness: 1},
+			{Amplitude: config.RoadAmplitude * 0.5, Wavelength: 2 * math.Pi / (config.RoadScale * 1.5), Sharpness: 3},
+		},
+		Approved: true,
+	}
+}
+
+// IsDefault reports whether t is the built-in road (which clients know
+// without being told).
+func (t *Track) IsDefault() bool {
+	return t.ID == ""
+}
+
+// Shifted returns a copy of the track whose Y=0 is dy further down the road,
+// for rooms that move their world origin (rebasing)
+func (t *Track) Shifted(dy float64) *Track {
+	shifted := *t
+	shifted.origin += dy
+	return &shifted
+}
+
+// Origin returns the road distance before Y=0 (0 unless shifted)
+func (t *Track) Origin() float64 {
+	return t.origin
+}
+
+// WithVariant returns a copy of the track in a variant, which its shifted
+// copies keep
+func (t *Track) WithVariant(v Variant) *Track {
+	varied := *t
+	varied.variant = v
+	return &varied
+}
+
+// Variant returns the track's variant (0 for the track as designed)
+func (t *Track) Variant() Variant {
+	return t.variant
+}
+
+// WithEscalation returns a copy of the track whose bends get harder the
+// further down the road: at road distance d their amplitude is scaled by
+// 1 + min(limit, d*rate), so a race gets harder the longer a car survives.
+// The parameters are normalized to what the wire format carries; shifted
+// copies keep them.
+func (t *Track) WithEscalation(rate, limit float64) *Track {
+	escalated := *t
+	escalated.escalation = float64(float32(rate))
+	escalated.escalationMax = float64(float32(limit))
+	return &escalated
+}
+
+// Escalation returns the track's escalation rate and cap (0, 0 for a road
+// that doesn't escalate)
+func (t *Track) Escalation() (rate, limit float64) {
+	return t.escalation, t.escalationMax
+}
+
+// scale returns the factor of the bends' amplitude at road distance d
+func (t *Track) scale(d float64) float64 {
+	if t.escalation == 0 || d <= 0 {
+		return 1
+	}
+	return 1 + math.Min(t.escalationMax, d*t.escalation)
+}
+
+// Center returns the X of the road center at worldY
+func (t *Track) Center(worldY float64) float64 {
+	worldY += t.origin
+	x := 0.0
+	if t.IsDefault() {
+		x = config.GetRoadCurve(worldY)
+	} else {
+		for _, c := range t.Curves {
+			x += math.Pow(math.Sin(worldY*2*math.Pi/c.Wavelength+c.Phase), float64(c.Sharpness)) * c.Amplitude
+		}
+	}
+	x *= t.scale(worldY)
+	if t.variant&Mirror != 0 {
+		return -x
+	}
+	return x
+}
+
+// LaneCount returns the number of lanes across the road
+func (t *Track) LaneCount() int {
+	if t.Lanes == 0 {
+		return config.RoadLanes
+	}
+	return t.Lanes
+}
+
+// Lane returns the lane of a car at (x, worldY) that was in lane prev
+// before: the lane its center is in, or prev while the center is within
+// config.LaneHysteresis of prev's lines. 0 is off the road.
+func (t *Track) Lane(x, worldY float64, prev int) int {
+	lanes := t.LaneCount()
+	width := t.Width / float64(lanes)
+	pos := x - t.Center(worldY) + t.Width/2 // From the left edge
+	if pos < 0 || pos > t.Width {
+		return 0
+	}
+	if prev > 0 && prev <= lanes &&
+		pos >= float64(prev-1)*width-config.LaneHysteresis && pos <= float64(prev)*width+config.LaneHysteresis {
+		return prev
+	}
+	return min(int(pos/width)+1, lanes)
+}
+
+// LaneCenter returns the X of the middle of a lane (1 to LaneCount) at
+// worldY
+func (t *Track) LaneCenter(lane int, worldY float64) float64 {
+	width := t.Width / float64(t.LaneCount())
+	return t.Center(worldY) - t.Width/2 + (float64(lane)-0.5)*width
+}
+
+// IsFlat reports whether the track neither climbs nor banks its bends, so
+// its physics are those of the built-in road
+func (t *Track) IsFlat() bool {
+	return len(t.Elevation) == 0 && t.Banking == 0
+}
+
+// Grade returns the climb of the road at worldY: height gained per unit
+// forward (negative downhill)
+func (t *Track) Grade(worldY float64) float64 {
+	if len(t.Elevation) == 0 {
+		return 0
+	}
+	return slopeOf(t.Elevation, worldY+t.origin)
+}
+
+// Bank returns how banked the road is at worldY, 0 (flat) to Banking: the
+// bends are banked with their curvature, fully from
+// config.BankCurvatureFull.
+func (t *Track) Bank(worldY float64) float64 {
+	if t.Banking == 0 {
+		return 0
+	}
+	d := worldY + t.origin
+	return t.Banking * math.Min(1, math.Abs(t.scale(d)*curvatureOf(t.Curves, d))/config.BankCurvatureFull)
+}
+
+// Launch reports whether a car driving forward from fromY to toY left a
+// ramp's lip on the way, and the ramp's height and grade (Height/Length):
+// the car flies off at that height, climbing at its speed times the grade.
+func (t *Track) Launch(fromY, toY float64) (height, grade float64, ok bool) {
+	for _, r := range t.Ramps {
+		lip := r.Offset + r.Length
+		next := math.Max(0, math.Floor((fromY+t.origin-lip)/r.Spacing)+1) // First lip past fromY
+		if lip+next*r.Spacing <= toY+t.origin {
+			return r.Height, r.Height / r.Length, true
+		}
+	}
+	return 0, 0, false
+}
+
+// InPit reports whether worldY is in a pit zone, where fuel mode refills
+// cars (see config.PitSpacing). Pit zones are the same on every track.
+func (t *Track) InPit(worldY float64) bool {
+	return math.Mod(worldY+t.origin, config.PitSpacing) >= config.PitSpacing-config.PitLength
+}
+
+// slope returns dX/dY of the road center at worldY
+func (t *Track) slope(worldY float64) float64 {
+	return slopeOf(t.Curves, worldY)
+}
+
+// slopeOf returns the derivative of a sum of curves at y
+func slopeOf(curves []Curve, y float64) float64 {
+	s := 0.0
+	for _, c := range curves {
+		k := 2 * math.Pi / c.Wavelength
+		a := y*k + c.Phase
+		s += c.Amplitude * float64(c.Sharpness) * math.Pow(math.Sin(a), float64(c.Sharpness-1)) * math.Cos(a) * k
+	}
+	return s
+}
+
+// curvatureOf returns the second derivative of a sum of curves at y
+func curvatureOf(curves []Curve, y float64) float64 {
+	s := 0.0
+	for _, c := range curves {
+		k := 2 * math.Pi / c.Wavelength
+		a := y*k + c.Phase
+		n := float64(c.Sharpness)
+		sin, cos := math.Sin(a), math.Cos(a)
+		d := -math.Pow(sin, n)
+		if c.Sharpness > 1 {
+			d += (n - 1) * math.Pow(sin, n-2) * cos * cos
+		}
+		s += c.Amplitude * n * k * k * d
+	}
+	return s
+}
+
+// Label names a track version for logs and stats ("" for the default road)
+func (t *Track) Label() string {
+	if t.IsDefault() {
+		return ""
+	}
+	return fmt.Sprintf("%s@%d", t.ID, t.Version)
+}
+
+// Validate checks a submitted definition against the config.Track* limits
+// and normalizes it to what the wire format carries (whole-unit width,
+// float32 curve parameters), so servers and clients drive the same road.
+func (t *Track) Validate() error {
+	t.Name = strings.TrimSpace(t.Name)
+	t.Author = strings.TrimSpace(t.Author)
+	switch {
+	case t.Name == "" || len(t.Name) > config.TrackNameMaxLen:
+		return fmt.Errorf("%w: name must be 1-%d bytes", ErrInvalid, config.TrackNameMaxLen)
+	case len(t.Author) > config.TrackNameMaxLen:
+		return fmt.Errorf("%w: author must be at most %d bytes", ErrInvalid, config.TrackNameMaxLen)
+	case t.Width < config.TrackWidthMin || t.Width > config.TrackWidthMax:
+		return fmt.Errorf("%w: width must be %.0f-%.0f", ErrInvalid, config.TrackWidthMin, config.TrackWidthMax)
+	case len(t.Curves) == 0 || len(t.Curves) > config.TrackCurvesMax:
+		return fmt.Errorf("%w: 1-%d curves required", ErrInvalid, config.TrackCurvesMax)
+	}
+	t.Width = math.Round(t.Width)
+	if t.Lanes == 0 {
+		t.Lanes = config.RoadLanes
+	}
+	if t.Lanes < 1 || t.Lanes > config.TrackLanesMax || t.Width/float64(t.Lanes) < config.TrackLaneWidthMin {
+		return fmt.Errorf("%w: lanes must be 1-%d and at least %.0f wide", ErrInvalid, config.TrackLanesMax, float64(config.TrackLaneWidthMin))
+	}
+
+	if err := validateCurves(t.Curves, "curve", config.TrackAmplitudeMax); err != nil {
+		return err
+	}
+	if len(t.Elevation) > config.TrackElevationCurvesMax {
+		return fmt.Errorf("%w: at most %d elevation curves", ErrInvalid, config.TrackElevationCurvesMax)
+	}
+	if err := validateCurves(t.Elevation, "elevation curve", config.TrackElevationMax); err != nil {
+		return err
+	}
+	if !(t.Banking >= 0 && t.Banking <= 1) {
+		return fmt.Errorf("%w: banking must be 0-1", ErrInvalid)
+	}
+	t.Banking = float64(float32(t.Banking))
+	if err := validateRamps(t.Ramps); err != nil {
+		return err
+	}
+
+	// Drivability: sample the slope and grade 100 times per shortest
+	// wavelength
+	step := config.TrackWavelengthMax
+	for _, curves := range [][]Curve{t.Curves, t.Elevation} {
+		for _, c := range curves {
+			step = math.Min(step, c.Wavelength/100)
+		}
+	}
+	for y := 0.0; y <= config.TrackCheckLength; y += step {
+		if s := t.slope(y); math.Abs(s) > config.TrackSlopeMax {
+			return fmt.Errorf("%w: road too steep at y=%.0f (slope %.2f, max %.2f)", ErrInvalid, y, math.Abs(s), config.TrackSlopeMax)
+		}
+		if g := t.Grade(y); math.Abs(g) > config.TrackGradeMax {
+			return fmt.Errorf("%w: road too steep at y=%.0f (grade %.2f, max %.2f)", ErrInvalid, y, math.Abs(g), config.TrackGradeMax)
+		}
+	}
+	return nil
+}
+
+// validateCurves checks curves against the config.Track* limits and
+// normalizes them to what the wire format carries
+func validateCurves(curves []Curve, what string, amplitudeMax float64) error {
+	for i := range curves {
+		c := &curves[i]
+		if c.Sharpness == 0 {
+			c.Sharpness = 1 // Plain sine wave
+		}
+		switch {
+		case math.IsNaN(c.Amplitude) || math.Abs(c.Amplitude) > amplitudeMax:
+			return fmt.Errorf("%w: %s %d: amplitude must be within ±%.0f", ErrInvalid, what, i, amplitudeMax)
+		case !(c.Wavelength >= config.TrackWavelengthMin && c.Wavelength <= config.TrackWavelengthMax):
+			return fmt.Errorf("%w: %s %d: wavelength must be %.0f-%.0f", ErrInvalid, what, i, config.TrackWavelengthMin, config.TrackWavelengthMax)
+		case math.IsNaN(c.Phase) || math.IsInf(c.Phase, 0):
+			return fmt.Errorf("%w: %s %d: invalid phase", ErrInvalid, what, i)
+		case c.Sharpness < 1 || c.Sharpness > config.TrackSharpnessMax || c.Sharpness%2 == 0:
+			return fmt.Errorf("%w: %s %d: sharpness must be odd, 1-%d", ErrInvalid, what, i, config.TrackSharpnessMax)
+		}
+		c.Amplitude = float64(float32(c.Amplitude))
+		c.Wavelength = float64(float32(c.Wavelength))
+		c.Phase = float64(float32(math.Mod(c.Phase, 2*math.Pi)))
+	}
+	return nil
+}
+
+// validateRamps checks ramps against the config.TrackRamp* limits and
+// normalizes them to what the wire format carries
+func validateRamps(ramps []Ramp) error {
+	if len(ramps) > config.TrackRampsMax {
+		return fmt.Errorf("%w: at most %d ramps", ErrInvalid, config.TrackRampsMax)
+	}
+	for i := range ramps {
+		r := &ramps[i]
+		r.Offset = float64(float32(r.Offset))
+		r.Spacing = float64(float32(r.Spacing))
+		r.Length = float64(float32(r.Length))
+		r.Height = float64(float32(r.Height))
+		switch {
+		case !(r.Spacing >= config.TrackRampSpacingMin && r.Spacing <= config.TrackWavelengthMax):
+			return fmt.Errorf("%w: ramp %d: spacing must be %.0f-%.0f", ErrInvalid, i, config.TrackRampSpacingMin, config.TrackWavelengthMax)
+		case !(r.Offset >= 0 && r.Offset < r.Spacing):
+			return fmt.Errorf("%w: ramp %d: offset must be 0 to under its spacing", ErrInvalid, i)
+		case !(r.Height >= config.TrackRampHeightMin && r.Height <= config.TrackRampHeightMax):
+			return fmt.Errorf("%w: ramp %d: height must be %.0f-%.0f", ErrInvalid, i, config.TrackRampHeightMin, config.TrackRampHeightMax)
+		case !(r.Length >= config.TrackRampLengthMin && r.Length < r.Spacing):
+			return fmt.Errorf("%w: ramp %d: length must be %.0f to under its spacing", ErrInvalid, i, config.TrackRampLengthMin)
+		case r.Height/r.Length > config.TrackRampGradeMax:
+			return fmt.Errorf("%w: ramp %d: steeper than %.2f", ErrInvalid, i, config.TrackRampGradeMax)
+		}
+	}
+	return nil
+}
+
+// ValidID reports whether id can name a track
+func ValidID(id string) bool {
+	return len(id) > 0 && len(id) <= network.TrackIDMaxLen && idPattern.MatchString(id)
+}
@@ -0,0 +1,26 @@
+package example
+
+import (
+	"github.com/race/server/config"
+	"github.com/race/server/internal/game"
+)
+
+// reverseSpeed is the top speed in reverse gear (see Physics.UpdatePlayer)
+const reverseSpeed = config.MaxSpeed * 0.2
+
+// reverse rubberbands cars that back up faster than reverse gear goes. The
+// built-in speed check allows full speed in any direction. Cars in contact
+// are skipped: a hit can push a car back.
+type reverse struct{}
+
+func (reverse) Name() string { return "reverse" }
+
+func (reverse) Check(m game.Movement, dt float64) game.ValidationResult {
+	if m.Pushed {
+		return game.ValidationValid
+	}
+	if m.FromY-m.Player.Y > reverseSpeed*dt*config.SpeedTolerance {
+		return game.ValidationRubberband
+	}
+	return game.ValidationValid
+}
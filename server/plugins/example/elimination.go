@@ -0,0 +1,56 @@
+package example
+
+import (
+	"github.com/race/server/internal/game"
+)
+
+// eliminationInterval is the time between eliminations, in seconds
+const eliminationInterval = 30.0
+
+// elimination explodes the car furthest back every eliminationInterval,
+// while at least two cars are in the race
+type elimination struct{}
+
+func (elimination) Name() string { return "elimination" }
+
+func (elimination) NewRules() (game.RoomRules, error) {
+	return &eliminationRules{}, nil
+}
+
+// eliminationRules is a room's state: the time to the next elimination
+type eliminationRules struct {
+	elapsed float64
+}
+
+func (e *eliminationRules) Name() string { return "elimination" }
+
+func (e *eliminationRules) OnJoin(api game.RulesAPI, p game.PlayerState) error { return nil }
+
+func (e *eliminationRules) OnCollision(api game.RulesAPI, a, b game.PlayerState) error { return nil }
+
+func (e *eliminationRules) OnExplosion(api game.RulesAPI, p game.PlayerState) error { return nil }
+
+func (e *eliminationRules) OnTick(api game.RulesAPI, dt float64) error {
+	e.elapsed += dt
+	if e.elapsed < eliminationInterval {
+		return nil
+	}
+	e.elapsed = 0
+
+	var last *game.PlayerState
+	racing := 0
+	players := api.Players()
+	for i := range players {
+		if players[i].Exploded {
+			continue
+		}
+		racing++
+		if last == nil || players[i].Y < last.Y {
+			last = &players[i]
+		}
+	}
+	if racing < 2 {
+		return nil
+	}
+	return api.Explode(last.ID)
+}
@@ -0,0 +1,18 @@
+// Package example shows one extension of every kind internal/plugin
+// registers; copy one of its files to start a plugin. None of them is used
+// until configured:
+//
+//	ROOM_RULES=elimination   the slowest car explodes every 30 seconds
+//	SCORING_POLICY=flat      leaderboard scores are the plain rating
+//	ANTICHEAT_RULES=reverse  cars may not back up faster than reverse gear
+//	STORE_BACKEND=valkey     Valkey, through the Redis backend
+package example
+
+import "github.com/race/server/internal/plugin"
+
+func init() {
+	plugin.RegisterGameMode(elimination{})
+	plugin.RegisterScoringPolicy(flat{})
+	plugin.RegisterAntiCheatRule(reverse{})
+	plugin.RegisterStorageBackend(valkey{})
+}
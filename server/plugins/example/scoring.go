@@ -0,0 +1,13 @@
+package example
+
+import "github.com/race/server/internal/game"
+
+// flat scores a run at its rating, whoever else was on the road: for
+// events where every room is set up the same
+type flat struct{}
+
+func (flat) Name() string { return "flat" }
+
+func (flat) Multiplier(d game.RoomDifficulty) float64 { return 1 }
+
+func (flat) Score(run game.Run) float64 { return run.Rating }
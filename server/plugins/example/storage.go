@@ -0,0 +1,13 @@
+package example
+
+import "github.com/race/server/internal/storage"
+
+// valkey stores shared state in Valkey, which speaks the Redis protocol:
+// STORE_URL is a redis:// URL
+type valkey struct{}
+
+func (valkey) Name() string { return "valkey" }
+
+func (valkey) Open(url string) (storage.Store, error) {
+	return storage.NewRedisStore(url)
+}